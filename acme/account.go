@@ -2,6 +2,7 @@ package acme
 
 import (
 	"encoding/json"
+	"net/http"
 	"time"
 
 	"github.com/pkg/errors"
@@ -46,8 +47,10 @@ func (a *Account) IsValid() bool {
 
 // AccountOptions are the options needed to create a new ACME account.
 type AccountOptions struct {
-	Key     *jose.JSONWebKey
-	Contact []string
+	Key                    *jose.JSONWebKey
+	Contact                []string
+	ExternalAccountBinding json.RawMessage
+	eabKeyID               string
 }
 
 // account represents an ACME account.
@@ -58,6 +61,7 @@ type account struct {
 	Key         *jose.JSONWebKey `json:"key"`
 	Contact     []string         `json:"contact,omitempty"`
 	Status      string           `json:"status"`
+	EABKeyID    string           `json:"eabKeyID,omitempty"`
 }
 
 // newAccount returns a new acme account type.
@@ -68,11 +72,12 @@ func newAccount(db nosql.DB, ops AccountOptions) (*account, error) {
 	}
 
 	a := &account{
-		ID:      id,
-		Key:     ops.Key,
-		Contact: ops.Contact,
-		Status:  "valid",
-		Created: clock.Now(),
+		ID:       id,
+		Key:      ops.Key,
+		Contact:  ops.Contact,
+		Status:   "valid",
+		Created:  clock.Now(),
+		EABKeyID: ops.eabKeyID,
 	}
 	return a, a.saveNew(db)
 }
@@ -167,6 +172,45 @@ func (a *account) deactivate(db nosql.DB) (*account, error) {
 	return &b, nil
 }
 
+// changeKey replaces the key of the acme account. The key-id to account-id
+// index of the new key is created first, and it's removed if the account has
+// changed since the last read. The index of the old key is only removed after
+// the account has been updated.
+func (a *account) changeKey(db nosql.DB, key *jose.JSONWebKey) (*account, error) {
+	oldKid, err := keyToID(a.Key)
+	if err != nil {
+		return nil, err
+	}
+	newKid, err := keyToID(key)
+	if err != nil {
+		return nil, err
+	}
+	newKidB := []byte(newKid)
+
+	// Set the new jwkID -> acme account ID index
+	_, swapped, err := db.CmpAndSwap(accountByKeyIDTable, newKidB, nil, []byte(a.ID))
+	switch {
+	case err != nil:
+		return nil, ServerInternalErr(errors.Wrap(err, "error setting key-id to account-id index"))
+	case !swapped:
+		e := MalformedErr(errors.New("new key is already in use by another account"))
+		e.Status = http.StatusConflict
+		return nil, e
+	}
+
+	b := *a
+	b.Key = key
+	if err := b.save(db, a); err != nil {
+		db.Del(accountByKeyIDTable, newKidB)
+		return nil, err
+	}
+
+	if err := db.Del(accountByKeyIDTable, []byte(oldKid)); err != nil {
+		return nil, ServerInternalErr(errors.Wrap(err, "error deleting key-id to account-id index"))
+	}
+	return &b, nil
+}
+
 // getAccountByID retrieves the account with the given ID.
 func getAccountByID(db nosql.DB, id string) (*account, error) {
 	ab, err := db.Get(accountTable, []byte(id))
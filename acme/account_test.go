@@ -37,6 +37,16 @@ func newProv() provisioner.Interface {
 	return p
 }
 
+func newPolicyProv(t *testing.T, policy *provisioner.ACMEPolicy) provisioner.Interface {
+	p := &provisioner.ACME{
+		Type:   "ACME",
+		Name:   "test@acme-policy-provisioner.com",
+		Policy: policy,
+	}
+	assert.FatalError(t, p.Init(provisioner.Config{Claims: globalProvisionerClaims}))
+	return p
+}
+
 func newAcc() (*account, error) {
 	jwk, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
 	if err != nil {
@@ -690,6 +700,171 @@ func TestAccountUpdate(t *testing.T) {
 	}
 }
 
+func TestAccountChangeKey(t *testing.T) {
+	type test struct {
+		acc *account
+		key *jose.JSONWebKey
+		db  nosql.DB
+		err *Error
+	}
+	tests := map[string]func(t *testing.T) test{
+		"fail/index-error": func(t *testing.T) test {
+			acc, err := newAcc()
+			assert.FatalError(t, err)
+			key, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
+			assert.FatalError(t, err)
+			return test{
+				acc: acc,
+				key: key,
+				db: &db.MockNoSQLDB{
+					MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+						return nil, false, errors.New("force")
+					},
+					MDel: func(bucket, key []byte) error {
+						assert.FatalError(t, errors.New("unexpected delete"))
+						return nil
+					},
+				},
+				err: ServerInternalErr(errors.New("error setting key-id to account-id index: force")),
+			}
+		},
+		"fail/key-in-use": func(t *testing.T) test {
+			acc, err := newAcc()
+			assert.FatalError(t, err)
+			key, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
+			assert.FatalError(t, err)
+			err409 := MalformedErr(errors.New("new key is already in use by another account"))
+			err409.Status = 409
+			return test{
+				acc: acc,
+				key: key,
+				db: &db.MockNoSQLDB{
+					MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+						assert.Equals(t, accountByKeyIDTable, bucket)
+						return []byte("other"), false, nil
+					},
+					MDel: func(bucket, key []byte) error {
+						assert.FatalError(t, errors.New("unexpected delete"))
+						return nil
+					},
+				},
+				err: err409,
+			}
+		},
+		"fail/account-changed": func(t *testing.T) test {
+			acc, err := newAcc()
+			assert.FatalError(t, err)
+			key, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
+			assert.FatalError(t, err)
+			newKid, err := keyToID(key)
+			assert.FatalError(t, err)
+			var deleted [][]byte
+			return test{
+				acc: acc,
+				key: key,
+				db: &db.MockNoSQLDB{
+					MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+						switch string(bucket) {
+						case string(accountByKeyIDTable):
+							return newval, true, nil
+						default:
+							// The account has been modified concurrently.
+							return []byte("other"), false, nil
+						}
+					},
+					MDel: func(bucket, key []byte) error {
+						assert.Equals(t, accountByKeyIDTable, bucket)
+						deleted = append(deleted, key)
+						// Only the new index is rolled back.
+						assert.Equals(t, [][]byte{[]byte(newKid)}, deleted)
+						return nil
+					},
+				},
+				err: ServerInternalErr(errors.New("error storing account; value has changed since last read")),
+			}
+		},
+		"fail/delete-error": func(t *testing.T) test {
+			acc, err := newAcc()
+			assert.FatalError(t, err)
+			key, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
+			assert.FatalError(t, err)
+			return test{
+				acc: acc,
+				key: key,
+				db: &db.MockNoSQLDB{
+					MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+						return newval, true, nil
+					},
+					MDel: func(bucket, key []byte) error {
+						return errors.New("force")
+					},
+				},
+				err: ServerInternalErr(errors.New("error deleting key-id to account-id index: force")),
+			}
+		},
+		"ok": func(t *testing.T) test {
+			acc, err := newAcc()
+			assert.FatalError(t, err)
+			key, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
+			assert.FatalError(t, err)
+			oldKid, err := keyToID(acc.Key)
+			assert.FatalError(t, err)
+			newKid, err := keyToID(key)
+			assert.FatalError(t, err)
+			oldb, err := json.Marshal(acc)
+			assert.FatalError(t, err)
+			count := 0
+			return test{
+				acc: acc,
+				key: key,
+				db: &db.MockNoSQLDB{
+					MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+						switch count {
+						case 0:
+							assert.Equals(t, accountByKeyIDTable, bucket)
+							assert.Equals(t, []byte(newKid), key)
+							assert.Nil(t, old)
+							assert.Equals(t, []byte(acc.ID), newval)
+						case 1:
+							assert.Equals(t, accountTable, bucket)
+							assert.Equals(t, []byte(acc.ID), key)
+							assert.Equals(t, oldb, old)
+						}
+						count++
+						return newval, true, nil
+					},
+					MDel: func(bucket, key []byte) error {
+						assert.Equals(t, 2, count)
+						assert.Equals(t, accountByKeyIDTable, bucket)
+						assert.Equals(t, []byte(oldKid), key)
+						return nil
+					},
+				},
+			}
+		},
+	}
+	for name, run := range tests {
+		t.Run(name, func(t *testing.T) {
+			tc := run(t)
+			acc, err := tc.acc.changeKey(tc.db, tc.key)
+			if err != nil {
+				if assert.NotNil(t, tc.err) {
+					ae, ok := err.(*Error)
+					assert.True(t, ok)
+					assert.HasPrefix(t, ae.Error(), tc.err.Error())
+					assert.Equals(t, ae.StatusCode(), tc.err.StatusCode())
+					assert.Equals(t, ae.Type, tc.err.Type)
+				}
+			} else {
+				if assert.Nil(t, tc.err) {
+					assert.Equals(t, tc.key, acc.Key)
+					assert.Equals(t, tc.acc.ID, acc.ID)
+				}
+			}
+		})
+	}
+}
+
 func TestAccountDeactivate(t *testing.T) {
 	type test struct {
 		acc *account
@@ -1,6 +1,8 @@
 package api
 
 import (
+	"bytes"
+	"crypto"
 	"encoding/json"
 	"net/http"
 
@@ -9,13 +11,15 @@ import (
 	"github.com/smallstep/certificates/acme"
 	"github.com/smallstep/certificates/api"
 	"github.com/smallstep/certificates/logging"
+	"github.com/smallstep/cli/jose"
 )
 
 // NewAccountRequest represents the payload for a new account request.
 type NewAccountRequest struct {
-	Contact              []string `json:"contact"`
-	OnlyReturnExisting   bool     `json:"onlyReturnExisting"`
-	TermsOfServiceAgreed bool     `json:"termsOfServiceAgreed"`
+	Contact                []string        `json:"contact"`
+	OnlyReturnExisting     bool            `json:"onlyReturnExisting"`
+	TermsOfServiceAgreed   bool            `json:"termsOfServiceAgreed"`
+	ExternalAccountBinding json.RawMessage `json:"externalAccountBinding,omitempty"`
 }
 
 func validateContacts(cs []string) error {
@@ -114,8 +118,9 @@ func (h *Handler) NewAccount(w http.ResponseWriter, r *http.Request) {
 		}
 
 		if acc, err = h.Auth.NewAccount(prov, acme.AccountOptions{
-			Key:     jwk,
-			Contact: nar.Contact,
+			Key:                    jwk,
+			Contact:                nar.Contact,
+			ExternalAccountBinding: nar.ExternalAccountBinding,
 		}); err != nil {
 			api.WriteError(w, err)
 			return
@@ -208,3 +213,131 @@ func (h *Handler) GetOrdersByAccount(w http.ResponseWriter, r *http.Request) {
 	api.JSON(w, orders)
 	logOrdersByAccount(w, orders)
 }
+
+// KeyChangeRequest represents the payload of the inner JWS of a key-change
+// request.
+type KeyChangeRequest struct {
+	Account string           `json:"account"`
+	OldKey  *jose.JSONWebKey `json:"oldKey"`
+}
+
+// Validate validates a key-change request body.
+func (k *KeyChangeRequest) Validate() error {
+	switch {
+	case len(k.Account) == 0:
+		return acme.MalformedErr(errors.New("account cannot be empty"))
+	case k.OldKey == nil:
+		return acme.MalformedErr(errors.New("oldKey cannot be empty"))
+	case !k.OldKey.Valid():
+		return acme.MalformedErr(errors.New("invalid oldKey"))
+	default:
+		return nil
+	}
+}
+
+// KeyChange is the ACME api for replacing the key of an account. The payload
+// of the request is a JWS signed by the new key, containing the account URL
+// and the old key.
+func (h *Handler) KeyChange(w http.ResponseWriter, r *http.Request) {
+	prov, err := provisionerFromContext(r)
+	if err != nil {
+		api.WriteError(w, err)
+		return
+	}
+	acc, err := accountFromContext(r)
+	if err != nil {
+		api.WriteError(w, err)
+		return
+	}
+	jws, err := jwsFromContext(r)
+	if err != nil {
+		api.WriteError(w, err)
+		return
+	}
+	payload, err := payloadFromContext(r)
+	if err != nil {
+		api.WriteError(w, err)
+		return
+	}
+
+	inner, err := jose.ParseJWS(string(payload.value))
+	if err != nil {
+		api.WriteError(w, acme.MalformedErr(errors.Wrap(err, "failed to parse inner JWS from request payload")))
+		return
+	}
+	if len(inner.Signatures) != 1 {
+		api.WriteError(w, acme.MalformedErr(errors.New("inner JWS must contain exactly one signature")))
+		return
+	}
+	hdr := inner.Signatures[0].Protected
+	switch {
+	case hdr.JSONWebKey == nil:
+		api.WriteError(w, acme.MalformedErr(errors.New("jwk expected in inner JWS protected header")))
+		return
+	case !hdr.JSONWebKey.Valid():
+		api.WriteError(w, acme.MalformedErr(errors.New("invalid jwk in inner JWS protected header")))
+		return
+	case len(hdr.Nonce) > 0:
+		api.WriteError(w, acme.MalformedErr(errors.New("inner JWS must not contain a nonce")))
+		return
+	case hdr.ExtraHeaders["url"] != jws.Signatures[0].Protected.ExtraHeaders["url"]:
+		api.WriteError(w, acme.MalformedErr(errors.New("url header in inner JWS does not match outer JWS")))
+		return
+	}
+	newKey := hdr.JSONWebKey
+	if len(newKey.Algorithm) != 0 && newKey.Algorithm != hdr.Algorithm {
+		api.WriteError(w, acme.MalformedErr(errors.New("verifier and signature algorithm do not match")))
+		return
+	}
+	b, err := inner.Verify(newKey)
+	if err != nil {
+		api.WriteError(w, acme.MalformedErr(errors.Wrap(err, "error verifying inner JWS")))
+		return
+	}
+
+	var kcr KeyChangeRequest
+	if err := json.Unmarshal(b, &kcr); err != nil {
+		api.WriteError(w, acme.MalformedErr(errors.Wrap(err,
+			"failed to unmarshal key-change request payload")))
+		return
+	}
+	if err := kcr.Validate(); err != nil {
+		api.WriteError(w, err)
+		return
+	}
+
+	accURL := h.Auth.GetLink(acme.AccountLink, acme.URLSafeProvisionerName(prov), true, acc.GetID())
+	if kcr.Account != accURL {
+		api.WriteError(w, acme.MalformedErr(errors.Errorf("account in key-change "+
+			"request (%s) does not match the account (%s)", kcr.Account, accURL)))
+		return
+	}
+	if !keysEqual(kcr.OldKey, acc.GetKey()) {
+		api.WriteError(w, acme.UnauthorizedErr(errors.New("oldKey does not match the account key")))
+		return
+	}
+
+	if acc, err = h.Auth.KeyChange(prov, acc.GetID(), newKey); err != nil {
+		api.WriteError(w, err)
+		return
+	}
+
+	w.Header().Set("Location", accURL)
+	api.JSON(w, acc)
+}
+
+// keysEqual returns true if both keys have the same thumbprint.
+func keysEqual(a, b *jose.JSONWebKey) bool {
+	if a == nil || b == nil {
+		return false
+	}
+	ta, err := a.Thumbprint(crypto.SHA256)
+	if err != nil {
+		return false
+	}
+	tb, err := b.Thumbprint(crypto.SHA256)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(ta, tb)
+}
@@ -788,3 +788,183 @@ func TestHandlerGetUpdateAccount(t *testing.T) {
 		})
 	}
 }
+
+func TestHandlerKeyChange(t *testing.T) {
+	prov := newProv()
+	url := fmt.Sprintf("https://ca.smallstep.com/acme/%s/key-change",
+		acme.URLSafeProvisionerName(prov))
+	accURL := fmt.Sprintf("https://ca.smallstep.com/acme/%s/account/accID",
+		acme.URLSafeProvisionerName(prov))
+
+	oldKey, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
+	assert.FatalError(t, err)
+	newKey, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
+	assert.FatalError(t, err)
+	oldPub, newPub := oldKey.Public(), newKey.Public()
+	acc := &acme.Account{ID: "accID", Key: &oldPub}
+
+	// innerJWS returns the payload of a key-change request signed by the new
+	// key.
+	innerJWS := func(t *testing.T, jwsURL string, kcr *KeyChangeRequest) []byte {
+		b, err := json.Marshal(kcr)
+		assert.FatalError(t, err)
+		so := new(jose.SignerOptions)
+		so.WithHeader("jwk", newPub)
+		so.WithHeader("url", jwsURL)
+		signer, err := jose.NewSigner(jose.SigningKey{
+			Algorithm: jose.SignatureAlgorithm(newKey.Algorithm),
+			Key:       newKey.Key,
+		}, so)
+		assert.FatalError(t, err)
+		jws, err := signer.Sign(b)
+		assert.FatalError(t, err)
+		raw, err := jws.CompactSerialize()
+		assert.FatalError(t, err)
+		return []byte(raw)
+	}
+	outerJWS := &jose.JSONWebSignature{
+		Signatures: []jose.Signature{
+			{
+				Protected: jose.Header{
+					KeyID: accURL,
+					ExtraHeaders: map[jose.HeaderKey]interface{}{
+						"url": url,
+					},
+				},
+			},
+		},
+	}
+	baseCtx := func(value []byte) context.Context {
+		ctx := context.WithValue(context.Background(), provisionerContextKey, prov)
+		ctx = context.WithValue(ctx, accContextKey, acc)
+		ctx = context.WithValue(ctx, jwsContextKey, outerJWS)
+		return context.WithValue(ctx, payloadContextKey, &payloadInfo{value: value})
+	}
+	getLink := func(typ acme.Link, provID string, abs bool, ins ...string) string {
+		assert.Equals(t, acme.AccountLink, typ)
+		assert.Equals(t, acme.URLSafeProvisionerName(prov), provID)
+		assert.True(t, abs)
+		return fmt.Sprintf("https://ca.smallstep.com/acme/%s/account/%s", provID, ins[0])
+	}
+
+	type test struct {
+		auth       acme.Interface
+		ctx        context.Context
+		statusCode int
+		problem    *acme.Error
+	}
+	var tests = map[string]func(t *testing.T) test{
+		"fail/no-account": func(t *testing.T) test {
+			return test{
+				ctx:        context.WithValue(context.Background(), provisionerContextKey, prov),
+				statusCode: 400,
+				problem:    acme.AccountDoesNotExistErr(nil),
+			}
+		},
+		"fail/parse-inner-jws": func(t *testing.T) test {
+			return test{
+				ctx:        baseCtx([]byte("foo")),
+				statusCode: 400,
+				problem:    acme.MalformedErr(errors.New("failed to parse inner JWS from request payload")),
+			}
+		},
+		"fail/url-mismatch": func(t *testing.T) test {
+			return test{
+				ctx: baseCtx(innerJWS(t, "https://ca.smallstep.com/foo", &KeyChangeRequest{
+					Account: accURL, OldKey: &oldPub,
+				})),
+				statusCode: 400,
+				problem:    acme.MalformedErr(errors.New("url header in inner JWS does not match outer JWS")),
+			}
+		},
+		"fail/validate": func(t *testing.T) test {
+			return test{
+				ctx:        baseCtx(innerJWS(t, url, &KeyChangeRequest{OldKey: &oldPub})),
+				statusCode: 400,
+				problem:    acme.MalformedErr(errors.New("account cannot be empty")),
+			}
+		},
+		"fail/account-mismatch": func(t *testing.T) test {
+			return test{
+				auth: &mockAcmeAuthority{getLink: getLink},
+				ctx: baseCtx(innerJWS(t, url, &KeyChangeRequest{
+					Account: "https://ca.smallstep.com/acme/foo/account/bar", OldKey: &oldPub,
+				})),
+				statusCode: 400,
+				problem:    acme.MalformedErr(errors.New("account in key-change request")),
+			}
+		},
+		"fail/old-key-mismatch": func(t *testing.T) test {
+			return test{
+				auth: &mockAcmeAuthority{getLink: getLink},
+				ctx: baseCtx(innerJWS(t, url, &KeyChangeRequest{
+					Account: accURL, OldKey: &newPub,
+				})),
+				statusCode: 401,
+				problem:    acme.UnauthorizedErr(errors.New("oldKey does not match the account key")),
+			}
+		},
+		"fail/keyChange-error": func(t *testing.T) test {
+			return test{
+				auth: &mockAcmeAuthority{
+					getLink: getLink,
+					keyChange: func(p provisioner.Interface, id string, key *jose.JSONWebKey) (*acme.Account, error) {
+						return nil, acme.ServerInternalErr(errors.New("force"))
+					},
+				},
+				ctx: baseCtx(innerJWS(t, url, &KeyChangeRequest{
+					Account: accURL, OldKey: &oldPub,
+				})),
+				statusCode: 500,
+				problem:    acme.ServerInternalErr(errors.New("force")),
+			}
+		},
+		"ok": func(t *testing.T) test {
+			return test{
+				auth: &mockAcmeAuthority{
+					getLink: getLink,
+					keyChange: func(p provisioner.Interface, id string, key *jose.JSONWebKey) (*acme.Account, error) {
+						assert.Equals(t, prov, p)
+						assert.Equals(t, "accID", id)
+						assert.Equals(t, newPub.KeyID, key.KeyID)
+						return &acme.Account{ID: "accID", Key: key, Status: acme.StatusValid}, nil
+					},
+				},
+				ctx: baseCtx(innerJWS(t, url, &KeyChangeRequest{
+					Account: accURL, OldKey: &oldPub,
+				})),
+				statusCode: 200,
+			}
+		},
+	}
+	for name, run := range tests {
+		tc := run(t)
+		t.Run(name, func(t *testing.T) {
+			h := New(tc.auth).(*Handler)
+			req := httptest.NewRequest("POST", url, nil)
+			req = req.WithContext(tc.ctx)
+			w := httptest.NewRecorder()
+			h.KeyChange(w, req)
+			res := w.Result()
+
+			assert.Equals(t, res.StatusCode, tc.statusCode)
+
+			body, err := ioutil.ReadAll(res.Body)
+			res.Body.Close()
+			assert.FatalError(t, err)
+
+			if res.StatusCode >= 400 && assert.NotNil(t, tc.problem) {
+				var ae acme.AError
+				assert.FatalError(t, json.Unmarshal(bytes.TrimSpace(body), &ae))
+				prob := tc.problem.ToACME()
+
+				assert.Equals(t, ae.Type, prob.Type)
+				assert.HasPrefix(t, ae.Detail, prob.Detail)
+				assert.Equals(t, res.Header["Content-Type"], []string{"application/problem+json"})
+			} else {
+				assert.Equals(t, res.Header["Location"], []string{accURL})
+				assert.Equals(t, res.Header["Content-Type"], []string{"application/json"})
+			}
+		})
+	}
+}
@@ -2,7 +2,10 @@ package api
 
 import (
 	"fmt"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/go-chi/chi"
 	"github.com/pkg/errors"
@@ -16,6 +19,26 @@ func link(url, typ string) string {
 	return fmt.Sprintf("<%s>;rel=\"%s\"", url, typ)
 }
 
+// retryAfter sets the Retry-After header if any of the given challenges is
+// being processed. The value is the time, in seconds, until the next
+// validation attempt.
+func retryAfter(w http.ResponseWriter, chs ...*acme.Challenge) {
+	var next time.Time
+	for _, ch := range chs {
+		if ch.Status == acme.StatusProcessing && (next.IsZero() || ch.RetryAfter.Before(next)) {
+			next = ch.RetryAfter
+		}
+	}
+	if next.IsZero() {
+		return
+	}
+	secs := int64(math.Ceil(time.Until(next).Seconds()))
+	if secs < 1 {
+		secs = 1
+	}
+	w.Header().Set("Retry-After", strconv.FormatInt(secs, 10))
+}
+
 type contextKey string
 
 const (
@@ -93,6 +116,9 @@ func (h *Handler) Route(r api.Router) {
 	extractPayloadByKid := func(next nextHTTP) nextHTTP {
 		return h.lookupProvisioner(h.addNonce(h.addDirLink(h.verifyContentType(h.parseJWS(h.validateJWS(h.lookupJWK(h.verifyAndExtractJWSPayload(next))))))))
 	}
+	extractPayloadByJWKOrKid := func(next nextHTTP) nextHTTP {
+		return h.lookupProvisioner(h.addNonce(h.addDirLink(h.verifyContentType(h.parseJWS(h.validateJWS(h.extractOrLookupJWK(h.verifyAndExtractJWSPayload(next))))))))
+	}
 
 	r.MethodFunc("POST", getLink(acme.NewAccountLink, "{provisionerID}", false), extractPayloadByJWK(h.NewAccount))
 	r.MethodFunc("POST", getLink(acme.AccountLink, "{provisionerID}", false, "{accID}"), extractPayloadByKid(h.GetUpdateAccount))
@@ -103,6 +129,8 @@ func (h *Handler) Route(r api.Router) {
 	r.MethodFunc("POST", getLink(acme.AuthzLink, "{provisionerID}", false, "{authzID}"), extractPayloadByKid(h.isPostAsGet(h.GetAuthz)))
 	r.MethodFunc("POST", getLink(acme.ChallengeLink, "{provisionerID}", false, "{chID}"), extractPayloadByKid(h.GetChallenge))
 	r.MethodFunc("POST", getLink(acme.CertificateLink, "{provisionerID}", false, "{certID}"), extractPayloadByKid(h.isPostAsGet(h.GetCertificate)))
+	r.MethodFunc("POST", getLink(acme.RevokeCertLink, "{provisionerID}", false), extractPayloadByJWKOrKid(h.RevokeCert))
+	r.MethodFunc("POST", getLink(acme.KeyChangeLink, "{provisionerID}", false), extractPayloadByKid(h.KeyChange))
 }
 
 // GetNonce just sets the right header since a Nonce is added to each response
@@ -145,6 +173,9 @@ func (h *Handler) GetAuthz(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if authz.Status == acme.StatusPending {
+		retryAfter(w, authz.Challenges...)
+	}
 	w.Header().Set("Location", h.Auth.GetLink(acme.AuthzLink, acme.URLSafeProvisionerName(prov), true, authz.GetID()))
 	api.JSON(w, authz)
 }
@@ -187,6 +218,7 @@ func (h *Handler) GetChallenge(w http.ResponseWriter, r *http.Request) {
 	getLink := h.Auth.GetLink
 	w.Header().Add("Link", link(getLink(acme.AuthzLink, acme.URLSafeProvisionerName(prov), true, ch.GetAuthzID()), "up"))
 	w.Header().Set("Location", getLink(acme.ChallengeLink, acme.URLSafeProvisionerName(prov), true, ch.GetID()))
+	retryAfter(w, ch)
 	api.JSON(w, ch)
 }
 
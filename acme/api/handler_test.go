@@ -34,10 +34,12 @@ type mockAcmeAuthority struct {
 	getLink             func(acme.Link, string, bool, ...string) string
 	getOrder            func(p provisioner.Interface, accID string, id string) (*acme.Order, error)
 	getOrdersByAccount  func(p provisioner.Interface, id string) ([]string, error)
+	keyChange           func(p provisioner.Interface, id string, key *jose.JSONWebKey) (*acme.Account, error)
 	loadProvisionerByID func(string) (provisioner.Interface, error)
 	newAccount          func(provisioner.Interface, acme.AccountOptions) (*acme.Account, error)
 	newNonce            func() (string, error)
 	newOrder            func(provisioner.Interface, acme.OrderOptions) (*acme.Order, error)
+	revokeCertificate   func(p provisioner.Interface, accID string, jwk *jose.JSONWebKey, crt *x509.Certificate, reasonCode int) error
 	updateAccount       func(provisioner.Interface, string, []string) (*acme.Account, error)
 	useNonce            func(string) error
 	validateChallenge   func(p provisioner.Interface, accID string, id string, jwk *jose.JSONWebKey) (*acme.Challenge, error)
@@ -176,6 +178,22 @@ func (m *mockAcmeAuthority) NewOrder(p provisioner.Interface, ops acme.OrderOpti
 	return m.ret1.(*acme.Order), m.err
 }
 
+func (m *mockAcmeAuthority) KeyChange(p provisioner.Interface, id string, key *jose.JSONWebKey) (*acme.Account, error) {
+	if m.keyChange != nil {
+		return m.keyChange(p, id, key)
+	} else if m.err != nil {
+		return nil, m.err
+	}
+	return m.ret1.(*acme.Account), m.err
+}
+
+func (m *mockAcmeAuthority) RevokeCertificate(p provisioner.Interface, accID string, jwk *jose.JSONWebKey, crt *x509.Certificate, reasonCode int) error {
+	if m.revokeCertificate != nil {
+		return m.revokeCertificate(p, accID, jwk, crt, reasonCode)
+	}
+	return m.err
+}
+
 func (m *mockAcmeAuthority) UpdateAccount(p provisioner.Interface, id string, contact []string) (*acme.Account, error) {
 	if m.updateAccount != nil {
 		return m.updateAccount(p, id, contact)
@@ -604,6 +622,7 @@ func TestHandlerGetChallenge(t *testing.T) {
 		statusCode int
 		ch         acme.Challenge
 		problem    *acme.Error
+		retryAfter []string
 	}
 	var tests = map[string]func(t *testing.T) test{
 		"fail/no-provisioner": func(t *testing.T) test {
@@ -733,6 +752,37 @@ func TestHandlerGetChallenge(t *testing.T) {
 				ch:         ch,
 			}
 		},
+		"ok/processing": func(t *testing.T) test {
+			key, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
+			assert.FatalError(t, err)
+			acc := &acme.Account{ID: "accID", Key: key}
+			ctx := context.WithValue(context.Background(), provisionerContextKey, prov)
+			ctx = context.WithValue(ctx, accContextKey, acc)
+			ctx = context.WithValue(ctx, payloadContextKey, &payloadInfo{isEmptyJSON: true})
+			ctx = context.WithValue(ctx, chi.RouteCtxKey, chiCtx)
+			ch := ch()
+			ch.Status = acme.StatusProcessing
+			ch.RetryAfter = time.Now().Add(-time.Second)
+			count := 0
+			return test{
+				auth: &mockAcmeAuthority{
+					validateChallenge: func(p provisioner.Interface, accID, id string, jwk *jose.JSONWebKey) (*acme.Challenge, error) {
+						return &ch, nil
+					},
+					getLink: func(typ acme.Link, provID string, abs bool, in ...string) string {
+						defer func() { count++ }()
+						if count == 0 {
+							return fmt.Sprintf("https://ca.smallstep.com/acme/authz/%s", ch.AuthzID)
+						}
+						return url
+					},
+				},
+				ctx:        ctx,
+				statusCode: 200,
+				ch:         ch,
+				retryAfter: []string{"1"},
+			}
+		},
 	}
 	for name, run := range tests {
 		tc := run(t)
@@ -767,6 +817,7 @@ func TestHandlerGetChallenge(t *testing.T) {
 				assert.Equals(t, res.Header["Link"], []string{fmt.Sprintf("<https://ca.smallstep.com/acme/authz/%s>;rel=\"up\"", tc.ch.AuthzID)})
 				assert.Equals(t, res.Header["Location"], []string{url})
 				assert.Equals(t, res.Header["Content-Type"], []string{"application/json"})
+				assert.Equals(t, res.Header["Retry-After"], tc.retryAfter)
 			}
 		})
 	}
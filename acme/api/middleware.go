@@ -246,6 +246,25 @@ func (h *Handler) extractJWK(next nextHTTP) nextHTTP {
 	}
 }
 
+// extractOrLookupJWK is a middleware that loads the JWK of the account
+// referenced by the kid parameter if one is present in the JWS, or extracts
+// the JWK from the JWS otherwise.
+// Make sure to parse and validate the JWS before running this middleware.
+func (h *Handler) extractOrLookupJWK(next nextHTTP) nextHTTP {
+	return func(w http.ResponseWriter, r *http.Request) {
+		jws, err := jwsFromContext(r)
+		if err != nil {
+			api.WriteError(w, err)
+			return
+		}
+		if len(jws.Signatures[0].Protected.KeyID) > 0 {
+			h.lookupJWK(next)(w, r)
+			return
+		}
+		h.extractJWK(next)(w, r)
+	}
+}
+
 // lookupProvisioner loads the provisioner associated with the request.
 // Responsds 404 if the provisioner does not exist.
 func (h *Handler) lookupProvisioner(next nextHTTP) nextHTTP {
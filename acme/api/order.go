@@ -4,6 +4,7 @@ import (
 	"crypto/x509"
 	"encoding/base64"
 	"encoding/json"
+	"net"
 	"net/http"
 	"time"
 
@@ -26,7 +27,13 @@ func (n *NewOrderRequest) Validate() error {
 		return acme.MalformedErr(errors.Errorf("identifiers list cannot be empty"))
 	}
 	for _, id := range n.Identifiers {
-		if id.Type != "dns" {
+		switch id.Type {
+		case "dns":
+		case "ip":
+			if net.ParseIP(id.Value) == nil {
+				return acme.MalformedErr(errors.Errorf("invalid IP address: %s", id.Value))
+			}
+		default:
 			return acme.MalformedErr(errors.Errorf("identifier type unsupported: %s", id.Type))
 		}
 	}
@@ -44,6 +44,33 @@ func TestNewOrderRequestValidate(t *testing.T) {
 				err: acme.MalformedErr(errors.Errorf("identifier type unsupported: foo")),
 			}
 		},
+		"fail/bad-ip": func(t *testing.T) test {
+			return test{
+				nor: &NewOrderRequest{
+					Identifiers: []acme.Identifier{
+						{Type: "ip", Value: "example.com"},
+					},
+				},
+				err: acme.MalformedErr(errors.Errorf("invalid IP address: example.com")),
+			}
+		},
+		"ok/ip": func(t *testing.T) test {
+			nbf := time.Now().UTC().Add(time.Minute)
+			naf := time.Now().UTC().Add(5 * time.Minute)
+			return test{
+				nor: &NewOrderRequest{
+					Identifiers: []acme.Identifier{
+						{Type: "dns", Value: "example.com"},
+						{Type: "ip", Value: "192.168.1.10"},
+						{Type: "ip", Value: "2001:db8::1"},
+					},
+					NotAfter:  naf,
+					NotBefore: nbf,
+				},
+				nbf: nbf,
+				naf: naf,
+			}
+		},
 		"ok": func(t *testing.T) test {
 			nbf := time.Now().UTC().Add(time.Minute)
 			naf := time.Now().UTC().Add(5 * time.Minute)
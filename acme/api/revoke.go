@@ -0,0 +1,116 @@
+package api
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/acme"
+	"github.com/smallstep/certificates/api"
+	"github.com/smallstep/certificates/logging"
+	"golang.org/x/crypto/ocsp"
+)
+
+// RevokeCertRequest represents the payload of a revoke-cert request.
+type RevokeCertRequest struct {
+	Certificate string `json:"certificate"`
+	Reason      *int   `json:"reason,omitempty"`
+	crt         *x509.Certificate
+}
+
+// Validate validates a revoke-cert request body.
+func (rcr *RevokeCertRequest) Validate() error {
+	if rcr.Certificate == "" {
+		return acme.MalformedErr(errors.New("certificate cannot be empty"))
+	}
+	der, err := base64.RawURLEncoding.DecodeString(rcr.Certificate)
+	if err != nil {
+		return acme.MalformedErr(errors.Wrap(err, "error base64url decoding certificate"))
+	}
+	if rcr.crt, err = x509.ParseCertificate(der); err != nil {
+		return acme.MalformedErr(errors.Wrap(err, "error parsing certificate"))
+	}
+	// Reason codes are defined in RFC 5280, the code 7 is not used.
+	if rcr.Reason != nil {
+		if r := *rcr.Reason; r < ocsp.Unspecified || r > ocsp.AACompromise || r == 7 {
+			return acme.BadRevocationReasonErr(errors.Errorf("reason code %d is not supported", r))
+		}
+	}
+	return nil
+}
+
+// reasonCode returns the reason code of the request, unspecified by default.
+func (rcr *RevokeCertRequest) reasonCode() int {
+	if rcr.Reason == nil {
+		return ocsp.Unspecified
+	}
+	return *rcr.Reason
+}
+
+func logRevokeCert(w http.ResponseWriter, rcr *RevokeCertRequest) {
+	if rl, ok := w.(logging.ResponseLogger); ok {
+		rl.WithFields(map[string]interface{}{
+			"serial":     rcr.crt.SerialNumber.String(),
+			"reasonCode": rcr.reasonCode(),
+		})
+	}
+}
+
+// RevokeCert is the ACME api for revoking a certificate. The request can be
+// signed either with the key of the account that owns the certificate, or with
+// the key of the certificate.
+func (h *Handler) RevokeCert(w http.ResponseWriter, r *http.Request) {
+	prov, err := provisionerFromContext(r)
+	if err != nil {
+		api.WriteError(w, err)
+		return
+	}
+	jws, err := jwsFromContext(r)
+	if err != nil {
+		api.WriteError(w, err)
+		return
+	}
+	jwk, err := jwkFromContext(r)
+	if err != nil {
+		api.WriteError(w, err)
+		return
+	}
+	payload, err := payloadFromContext(r)
+	if err != nil {
+		api.WriteError(w, err)
+		return
+	}
+
+	var rcr RevokeCertRequest
+	if err := json.Unmarshal(payload.value, &rcr); err != nil {
+		api.WriteError(w, acme.MalformedErr(errors.Wrap(err,
+			"failed to unmarshal revoke-cert request payload")))
+		return
+	}
+	if err := rcr.Validate(); err != nil {
+		api.WriteError(w, err)
+		return
+	}
+
+	// Requests using the kid are authorized by the account, requests with a
+	// jwk are authorized by the certificate key.
+	var accID string
+	if len(jws.Signatures[0].Protected.KeyID) > 0 {
+		acc, err := accountFromContext(r)
+		if err != nil {
+			api.WriteError(w, err)
+			return
+		}
+		accID = acc.GetID()
+	}
+
+	if err := h.Auth.RevokeCertificate(prov, accID, jwk, rcr.crt, rcr.reasonCode()); err != nil {
+		api.WriteError(w, err)
+		return
+	}
+
+	logRevokeCert(w, &rcr)
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,232 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/acme"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/cli/crypto/pemutil"
+	"github.com/smallstep/cli/jose"
+)
+
+func TestRevokeCertRequestValidate(t *testing.T) {
+	crt, err := pemutil.ReadCertificate("../../authority/testdata/certs/foo.crt")
+	assert.FatalError(t, err)
+	certificate := base64.RawURLEncoding.EncodeToString(crt.Raw)
+	reason := func(i int) *int { return &i }
+
+	type test struct {
+		rcr *RevokeCertRequest
+		err *acme.Error
+	}
+	var tests = map[string]func(t *testing.T) test{
+		"fail/empty": func(t *testing.T) test {
+			return test{
+				rcr: &RevokeCertRequest{},
+				err: acme.MalformedErr(errors.New("certificate cannot be empty")),
+			}
+		},
+		"fail/bad-base64": func(t *testing.T) test {
+			return test{
+				rcr: &RevokeCertRequest{Certificate: "!"},
+				err: acme.MalformedErr(errors.New("error base64url decoding certificate")),
+			}
+		},
+		"fail/bad-certificate": func(t *testing.T) test {
+			return test{
+				rcr: &RevokeCertRequest{Certificate: "Zm9v"},
+				err: acme.MalformedErr(errors.New("error parsing certificate")),
+			}
+		},
+		"fail/reason-unused": func(t *testing.T) test {
+			return test{
+				rcr: &RevokeCertRequest{Certificate: certificate, Reason: reason(7)},
+				err: acme.BadRevocationReasonErr(errors.New("reason code 7 is not supported")),
+			}
+		},
+		"fail/reason-out-of-range": func(t *testing.T) test {
+			return test{
+				rcr: &RevokeCertRequest{Certificate: certificate, Reason: reason(11)},
+				err: acme.BadRevocationReasonErr(errors.New("reason code 11 is not supported")),
+			}
+		},
+		"ok": func(t *testing.T) test {
+			return test{
+				rcr: &RevokeCertRequest{Certificate: certificate},
+			}
+		},
+		"ok/reason": func(t *testing.T) test {
+			return test{
+				rcr: &RevokeCertRequest{Certificate: certificate, Reason: reason(1)},
+			}
+		},
+	}
+	for name, run := range tests {
+		tc := run(t)
+		t.Run(name, func(t *testing.T) {
+			if err := tc.rcr.Validate(); err != nil {
+				if assert.NotNil(t, tc.err) {
+					ae, ok := err.(*acme.Error)
+					assert.True(t, ok)
+					assert.HasPrefix(t, ae.Error(), tc.err.Error())
+					assert.Equals(t, ae.StatusCode(), tc.err.StatusCode())
+					assert.Equals(t, ae.Type, tc.err.Type)
+				}
+			} else {
+				if assert.Nil(t, tc.err) {
+					assert.Equals(t, crt, tc.rcr.crt)
+				}
+			}
+		})
+	}
+}
+
+func TestHandlerRevokeCert(t *testing.T) {
+	crt, err := pemutil.ReadCertificate("../../authority/testdata/certs/foo.crt")
+	assert.FatalError(t, err)
+	jwk, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
+	assert.FatalError(t, err)
+	payload, err := json.Marshal(map[string]interface{}{
+		"certificate": base64.RawURLEncoding.EncodeToString(crt.Raw),
+		"reason":      4,
+	})
+	assert.FatalError(t, err)
+
+	prov := newProv()
+	url := fmt.Sprintf("https://ca.smallstep.com/acme/%s/revoke-cert",
+		acme.URLSafeProvisionerName(prov))
+	jwsWithKid := &jose.JSONWebSignature{
+		Signatures: []jose.Signature{{Protected: jose.Header{KeyID: "kid"}}},
+	}
+	jwsWithJWK := &jose.JSONWebSignature{
+		Signatures: []jose.Signature{{Protected: jose.Header{JSONWebKey: jwk}}},
+	}
+	baseCtx := func(jws *jose.JSONWebSignature, value []byte) context.Context {
+		ctx := context.WithValue(context.Background(), provisionerContextKey, prov)
+		ctx = context.WithValue(ctx, jwsContextKey, jws)
+		ctx = context.WithValue(ctx, jwkContextKey, jwk)
+		return context.WithValue(ctx, payloadContextKey, &payloadInfo{value: value})
+	}
+
+	type test struct {
+		auth       acme.Interface
+		ctx        context.Context
+		statusCode int
+		problem    *acme.Error
+	}
+	var tests = map[string]func(t *testing.T) test{
+		"fail/no-provisioner": func(t *testing.T) test {
+			return test{
+				ctx:        context.Background(),
+				statusCode: 500,
+				problem:    acme.ServerInternalErr(errors.New("provisioner expected in request context")),
+			}
+		},
+		"fail/no-jws": func(t *testing.T) test {
+			return test{
+				ctx:        context.WithValue(context.Background(), provisionerContextKey, prov),
+				statusCode: 500,
+				problem:    acme.ServerInternalErr(errors.New("jws expected in request context")),
+			}
+		},
+		"fail/unmarshal-payload": func(t *testing.T) test {
+			return test{
+				ctx:        baseCtx(jwsWithJWK, []byte("foo")),
+				statusCode: 400,
+				problem:    acme.MalformedErr(errors.New("failed to unmarshal revoke-cert request payload")),
+			}
+		},
+		"fail/validate": func(t *testing.T) test {
+			return test{
+				ctx:        baseCtx(jwsWithJWK, []byte("{}")),
+				statusCode: 400,
+				problem:    acme.MalformedErr(errors.New("certificate cannot be empty")),
+			}
+		},
+		"fail/no-account": func(t *testing.T) test {
+			return test{
+				ctx:        baseCtx(jwsWithKid, payload),
+				statusCode: 400,
+				problem:    acme.AccountDoesNotExistErr(nil),
+			}
+		},
+		"fail/revoke-error": func(t *testing.T) test {
+			return test{
+				auth: &mockAcmeAuthority{
+					err: acme.UnauthorizedErr(errors.New("account does not own certificate")),
+				},
+				ctx:        context.WithValue(baseCtx(jwsWithKid, payload), accContextKey, &acme.Account{ID: "accID"}),
+				statusCode: 401,
+				problem:    acme.UnauthorizedErr(errors.New("account does not own certificate")),
+			}
+		},
+		"ok/account": func(t *testing.T) test {
+			return test{
+				auth: &mockAcmeAuthority{
+					revokeCertificate: func(p provisioner.Interface, accID string, key *jose.JSONWebKey, cert *x509.Certificate, reasonCode int) error {
+						assert.Equals(t, prov, p)
+						assert.Equals(t, "accID", accID)
+						assert.Equals(t, jwk, key)
+						assert.Equals(t, crt, cert)
+						assert.Equals(t, 4, reasonCode)
+						return nil
+					},
+				},
+				ctx:        context.WithValue(baseCtx(jwsWithKid, payload), accContextKey, &acme.Account{ID: "accID"}),
+				statusCode: 200,
+			}
+		},
+		"ok/certificate-key": func(t *testing.T) test {
+			return test{
+				auth: &mockAcmeAuthority{
+					revokeCertificate: func(p provisioner.Interface, accID string, key *jose.JSONWebKey, cert *x509.Certificate, reasonCode int) error {
+						assert.Equals(t, "", accID)
+						assert.Equals(t, jwk, key)
+						return nil
+					},
+				},
+				ctx:        baseCtx(jwsWithJWK, payload),
+				statusCode: 200,
+			}
+		},
+	}
+	for name, run := range tests {
+		tc := run(t)
+		t.Run(name, func(t *testing.T) {
+			h := New(tc.auth).(*Handler)
+			req := httptest.NewRequest("POST", url, nil)
+			req = req.WithContext(tc.ctx)
+			w := httptest.NewRecorder()
+			h.RevokeCert(w, req)
+			res := w.Result()
+
+			assert.Equals(t, res.StatusCode, tc.statusCode)
+
+			body, err := ioutil.ReadAll(res.Body)
+			res.Body.Close()
+			assert.FatalError(t, err)
+
+			if res.StatusCode >= 400 && assert.NotNil(t, tc.problem) {
+				var ae acme.AError
+				assert.FatalError(t, json.Unmarshal(bytes.TrimSpace(body), &ae))
+				prob := tc.problem.ToACME()
+
+				assert.Equals(t, ae.Type, prob.Type)
+				assert.HasPrefix(t, ae.Detail, prob.Detail)
+				assert.Equals(t, res.Header["Content-Type"], []string{"application/problem+json"})
+			} else {
+				assert.Len(t, 0, body)
+			}
+		})
+	}
+}
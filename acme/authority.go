@@ -1,18 +1,20 @@
 package acme
 
 import (
+	"context"
 	"crypto"
-	"crypto/tls"
 	"crypto/x509"
 	"encoding/base64"
-	"net"
+	"log"
 	"net/http"
 	"net/url"
-	"time"
+	"sync"
 
 	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/authority"
 	"github.com/smallstep/certificates/authority/provisioner"
 	database "github.com/smallstep/certificates/db"
+	"github.com/smallstep/certificates/errs"
 	"github.com/smallstep/cli/jose"
 	"github.com/smallstep/nosql"
 )
@@ -29,10 +31,12 @@ type Interface interface {
 	GetLink(Link, string, bool, ...string) string
 	GetOrder(provisioner.Interface, string, string) (*Order, error)
 	GetOrdersByAccount(provisioner.Interface, string) ([]string, error)
+	KeyChange(provisioner.Interface, string, *jose.JSONWebKey) (*Account, error)
 	LoadProvisionerByID(string) (provisioner.Interface, error)
 	NewAccount(provisioner.Interface, AccountOptions) (*Account, error)
 	NewNonce() (string, error)
 	NewOrder(provisioner.Interface, OrderOptions) (*Order, error)
+	RevokeCertificate(provisioner.Interface, string, *jose.JSONWebKey, *x509.Certificate, int) error
 	UpdateAccount(provisioner.Interface, string, []string) (*Account, error)
 	UseNonce(string) error
 	ValidateChallenge(provisioner.Interface, string, string, *jose.JSONWebKey) (*Challenge, error)
@@ -40,9 +44,11 @@ type Interface interface {
 
 // Authority is the layer that handles all ACME interactions.
 type Authority struct {
-	db       nosql.DB
-	dir      *directory
-	signAuth SignAuthority
+	db              nosql.DB
+	dir             *directory
+	signAuth        SignAuthority
+	validationMutex sync.Mutex
+	validationPools map[string]*validationPool
 }
 
 var (
@@ -54,6 +60,7 @@ var (
 	orderTable             = []byte("acme_orders")
 	ordersByAccountIDTable = []byte("acme_account_orders_index")
 	certTable              = []byte("acme_certs")
+	accountByEABKeyIDTable = []byte("acme_eabKeyID_accountID_index")
 )
 
 // NewAuthority returns a new Authority that implements the ACME interface.
@@ -63,7 +70,7 @@ func NewAuthority(db nosql.DB, dns, prefix string, signAuth SignAuthority) (*Aut
 		// necessary ACME tables. SimpleDB should ONLY be used for testing.
 		tables := [][]byte{accountTable, accountByKeyIDTable, authzTable,
 			challengeTable, nonceTable, orderTable, ordersByAccountIDTable,
-			certTable}
+			certTable, accountByEABKeyIDTable}
 		for _, b := range tables {
 			if err := db.CreateTable(b); err != nil {
 				return nil, errors.Wrapf(err, "error creating table %s",
@@ -90,9 +97,19 @@ func (a *Authority) GetDirectory(p provisioner.Interface) *Directory {
 		NewOrder:   a.dir.getLink(NewOrderLink, name, true),
 		RevokeCert: a.dir.getLink(RevokeCertLink, name, true),
 		KeyChange:  a.dir.getLink(KeyChangeLink, name, true),
+		Meta:       directoryMeta(p),
 	}
 }
 
+// directoryMeta returns the metadata of the directory for the given
+// provisioner, or nil if there is none.
+func directoryMeta(p provisioner.Interface) *DirectoryMeta {
+	if prov, ok := p.(*provisioner.ACME); ok && prov.IsExternalAccountRequired() {
+		return &DirectoryMeta{ExternalAccountRequired: true}
+	}
+	return nil
+}
+
 // LoadProvisionerByID calls out to the SignAuthority interface to load a
 // provisioner by ID.
 func (a *Authority) LoadProvisionerByID(id string) (provisioner.Interface, error) {
@@ -113,12 +130,24 @@ func (a *Authority) UseNonce(nonce string) error {
 	return useNonce(a.db, nonce)
 }
 
-// NewAccount creates, stores, and returns a new ACME account.
+// NewAccount creates, stores, and returns a new ACME account. If the
+// provisioner requires it, the external account binding is verified and
+// the external account key is bound to the new account.
 func (a *Authority) NewAccount(p provisioner.Interface, ao AccountOptions) (*Account, error) {
+	eabKeyID, err := a.verifyExternalAccountBinding(p, ao)
+	if err != nil {
+		return nil, err
+	}
+	ao.eabKeyID = eabKeyID
 	acc, err := newAccount(a.db, ao)
 	if err != nil {
 		return nil, err
 	}
+	if eabKeyID != "" {
+		if err := acc.bindExternalAccountKey(a.db, p); err != nil {
+			return nil, err
+		}
+	}
 	return acc.toACME(a.db, a.dir, p)
 }
 
@@ -155,6 +184,31 @@ func (a *Authority) DeactivateAccount(p provisioner.Interface, id string) (*Acco
 	return acc.toACME(a.db, a.dir, p)
 }
 
+// KeyChange replaces the key of an ACME account with the given one.
+func (a *Authority) KeyChange(p provisioner.Interface, id string, key *jose.JSONWebKey) (*Account, error) {
+	kid, err := keyToID(key)
+	if err != nil {
+		return nil, err
+	}
+	switch _, err := a.db.Get(accountByKeyIDTable, []byte(kid)); {
+	case err == nil:
+		e := MalformedErr(errors.New("new key is already in use by another account"))
+		e.Status = http.StatusConflict
+		return nil, e
+	case !nosql.IsErrNotFound(err):
+		return nil, ServerInternalErr(errors.Wrap(err, "error loading key-account index"))
+	}
+
+	acc, err := getAccountByID(a.db, id)
+	if err != nil {
+		return nil, err
+	}
+	if acc, err = acc.changeKey(a.db, key); err != nil {
+		return nil, err
+	}
+	return acc.toACME(a.db, a.dir, p)
+}
+
 func keyToID(jwk *jose.JSONWebKey) (string, error) {
 	kid, err := jwk.Thumbprint(crypto.SHA256)
 	if err != nil {
@@ -188,6 +242,16 @@ func (a *Authority) GetOrder(p provisioner.Interface, accID, orderID string) (*O
 	if o, err = o.updateStatus(a.db); err != nil {
 		return nil, err
 	}
+	if o.Status == StatusPending {
+		for _, azID := range o.Authorizations {
+			az, err := getAuthz(a.db, azID)
+			if err != nil {
+				log.Printf("error resuming acme authz %s: %v", azID, err)
+				continue
+			}
+			a.resumeValidations(p, az)
+		}
+	}
 	return o.toACME(a.db, a.dir, p)
 }
 
@@ -214,6 +278,9 @@ func (a *Authority) GetOrdersByAccount(p provisioner.Interface, id string) ([]st
 
 // NewOrder generates, stores, and returns a new ACME order.
 func (a *Authority) NewOrder(p provisioner.Interface, ops OrderOptions) (*Order, error) {
+	if err := authorizeIdentifiers(p, ops.Identifiers); err != nil {
+		return nil, err
+	}
 	order, err := newOrder(a.db, ops)
 	if err != nil {
 		return nil, Wrap(err, "error creating order")
@@ -230,6 +297,11 @@ func (a *Authority) FinalizeOrder(p provisioner.Interface, accID, orderID string
 	if accID != o.AccountID {
 		return nil, UnauthorizedErr(errors.New("account does not own order"))
 	}
+	// The policy of the provisioner might have changed since the order was
+	// created. The names in the CSR must match the identifiers of the order.
+	if err := authorizeIdentifiers(p, o.Identifiers); err != nil {
+		return nil, err
+	}
 	o, err = o.finalize(a.db, csr, a.signAuth, p)
 	if err != nil {
 		return nil, Wrap(err, "error finalizing order")
@@ -251,10 +323,14 @@ func (a *Authority) GetAuthz(p provisioner.Interface, accID, authzID string) (*A
 	if err != nil {
 		return nil, Wrap(err, "error updating authz status")
 	}
+	a.resumeValidations(p, az)
 	return az.toACME(a.db, a.dir, p)
 }
 
-// ValidateChallenge attempts to validate the challenge.
+// ValidateChallenge starts the validation of a pending challenge. The
+// challenge is moved to the processing state and it is validated in the
+// background by the validation workers of the provisioner. Clients must poll
+// the challenge or the authz to know the result of the validation.
 func (a *Authority) ValidateChallenge(p provisioner.Interface, accID, chID string, jwk *jose.JSONWebKey) (*Challenge, error) {
 	ch, err := getChallenge(a.db, chID)
 	if err != nil {
@@ -263,21 +339,24 @@ func (a *Authority) ValidateChallenge(p provisioner.Interface, accID, chID strin
 	if accID != ch.getAccountID() {
 		return nil, UnauthorizedErr(errors.New("account does not own challenge"))
 	}
-	client := http.Client{
-		Timeout: time.Duration(30 * time.Second),
-	}
-	dialer := &net.Dialer{
-		Timeout: 30 * time.Second,
-	}
-	ch, err = ch.validate(a.db, jwk, validateOptions{
-		httpGet:   client.Get,
-		lookupTxt: net.LookupTXT,
-		tlsDial: func(network, addr string, config *tls.Config) (*tls.Conn, error) {
-			return tls.DialWithDialer(dialer, network, addr, config)
-		},
-	})
-	if err != nil {
-		return nil, Wrap(err, "error attempting challenge validation")
+
+	switch ch.getStatus() {
+	case StatusPending:
+		upd := ch.clone()
+		upd.Status = StatusProcessing
+		upd.RetryAfter = clock.Now()
+		if err := upd.save(a.db, ch); err != nil {
+			return nil, Wrap(err, "error attempting challenge validation")
+		}
+		a.getValidationPool(p).submit(&validationJob{chID: ch.getID(), jwk: jwk})
+		return upd.toACME(a.db, a.dir, p)
+	case StatusProcessing:
+		// Resume validations interrupted by a restart of the CA.
+		if vp := a.getValidationPool(p); vp.isStale(ch) {
+			if err := vp.resume(ch, jwk); err != nil {
+				log.Printf("error resuming acme challenge %s: %v", ch.getID(), err)
+			}
+		}
 	}
 	return ch.toACME(a.db, a.dir, p)
 }
@@ -293,3 +372,52 @@ func (a *Authority) GetCertificate(accID, certID string) ([]byte, error) {
 	}
 	return cert.toACME(a.db, a.dir)
 }
+
+// RevokeCertificate revokes the given certificate. The request must be
+// authorized by the account that owns the certificate, or if the account ID is
+// empty, by the key of the certificate.
+func (a *Authority) RevokeCertificate(p provisioner.Interface, accID string, jwk *jose.JSONWebKey, crt *x509.Certificate, reasonCode int) error {
+	if accID != "" {
+		ok, err := accountOwnsCertificate(a.db, accID, crt)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return UnauthorizedErr(errors.New("account does not own certificate"))
+		}
+	} else {
+		kid, err := keyToID(jwk)
+		if err != nil {
+			return err
+		}
+		crtKid, err := keyToID(&jose.JSONWebKey{Key: crt.PublicKey})
+		if err != nil {
+			return err
+		}
+		if kid != crtKid {
+			return UnauthorizedErr(errors.New("jwk does not match the certificate key"))
+		}
+	}
+
+	ctx := provisioner.NewContextWithMethod(context.Background(), provisioner.RevokeMethod)
+	err := a.signAuth.Revoke(ctx, &authority.RevokeOptions{
+		Serial:     crt.SerialNumber.String(),
+		ReasonCode: reasonCode,
+		ACME:       true,
+		Crt:        crt,
+	})
+	if err == nil {
+		return nil
+	}
+	// The authority returns a bad request if the certificate has been
+	// already revoked.
+	if sc, ok := err.(errs.StatusCoder); ok {
+		switch sc.StatusCode() {
+		case http.StatusBadRequest:
+			return AlreadyRevokedErr(err)
+		case http.StatusUnauthorized, http.StatusForbidden:
+			return UnauthorizedErr(err)
+		}
+	}
+	return ServerInternalErr(errors.Wrap(err, "error revoking certificate"))
+}
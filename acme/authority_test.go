@@ -1,6 +1,7 @@
 package acme
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"testing"
@@ -8,7 +9,10 @@ import (
 
 	"github.com/pkg/errors"
 	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/authority"
+	"github.com/smallstep/certificates/authority/provisioner"
 	"github.com/smallstep/certificates/db"
+	"github.com/smallstep/certificates/errs"
 	"github.com/smallstep/cli/jose"
 	"github.com/smallstep/nosql/database"
 )
@@ -80,6 +84,11 @@ func TestAuthorityGetDirectory(t *testing.T) {
 	//assert.Equals(t, acmeDir.NewOrder, "httsp://ca.smallstep.com/acme/new-authz")
 	assert.Equals(t, acmeDir.RevokeCert, fmt.Sprintf("https://ca.smallstep.com/acme/%s/revoke-cert", URLSafeProvisionerName(prov)))
 	assert.Equals(t, acmeDir.KeyChange, fmt.Sprintf("https://ca.smallstep.com/acme/%s/key-change", URLSafeProvisionerName(prov)))
+	assert.Nil(t, acmeDir.Meta)
+
+	eabProv := newEABProv(t, map[string][]byte{"kid": []byte("secret")})
+	acmeDir = auth.GetDirectory(eabProv)
+	assert.Equals(t, acmeDir.Meta, &DirectoryMeta{ExternalAccountRequired: true})
 }
 
 func TestAuthorityNewNonce(t *testing.T) {
@@ -852,11 +861,28 @@ func TestAuthorityNewOrder(t *testing.T) {
 	prov := newProv()
 	type test struct {
 		auth *Authority
+		prov provisioner.Interface
 		ops  OrderOptions
 		err  *Error
 		o    **Order
 	}
 	tests := map[string]func(t *testing.T) test{
+		"fail/policy": func(t *testing.T) test {
+			auth, err := NewAuthority(&db.MockNoSQLDB{
+				MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+					assert.FatalError(t, errors.New("unexpected write"))
+					return nil, false, nil
+				},
+			}, "ca.smallstep.com", "acme", nil)
+			assert.FatalError(t, err)
+			ops := defaultOrderOps()
+			return test{
+				auth: auth,
+				prov: newPolicyProv(t, &provisioner.ACMEPolicy{DeniedDomains: []string{ops.Identifiers[1].Value}}),
+				ops:  ops,
+				err:  RejectedIdentifierErr(errors.Errorf("dns %s is denied", ops.Identifiers[1].Value)),
+			}
+		},
 		"fail/newOrder-error": func(t *testing.T) test {
 			auth, err := NewAuthority(&db.MockNoSQLDB{
 				MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
@@ -928,7 +954,10 @@ func TestAuthorityNewOrder(t *testing.T) {
 	for name, run := range tests {
 		t.Run(name, func(t *testing.T) {
 			tc := run(t)
-			if acmeO, err := tc.auth.NewOrder(prov, tc.ops); err != nil {
+			if tc.prov == nil {
+				tc.prov = prov
+			}
+			if acmeO, err := tc.auth.NewOrder(tc.prov, tc.ops); err != nil {
 				if assert.NotNil(t, tc.err) {
 					ae, ok := err.(*Error)
 					assert.True(t, ok)
@@ -1081,11 +1110,33 @@ func TestAuthorityFinalizeOrder(t *testing.T) {
 	prov := newProv()
 	type test struct {
 		auth      *Authority
+		prov      provisioner.Interface
 		id, accID string
 		err       *Error
 		o         *order
 	}
 	tests := map[string]func(t *testing.T) test{
+		"fail/policy": func(t *testing.T) test {
+			o, err := newO()
+			assert.FatalError(t, err)
+			b, err := json.Marshal(o)
+			assert.FatalError(t, err)
+			auth, err := NewAuthority(&db.MockNoSQLDB{
+				MGet: func(bucket, key []byte) ([]byte, error) {
+					assert.Equals(t, bucket, orderTable)
+					assert.Equals(t, key, []byte(o.ID))
+					return b, nil
+				},
+			}, "ca.smallstep.com", "acme", nil)
+			assert.FatalError(t, err)
+			return test{
+				auth:  auth,
+				prov:  newPolicyProv(t, &provisioner.ACMEPolicy{AllowedDomains: []string{"smallstep.com"}}),
+				id:    o.ID,
+				accID: o.AccountID,
+				err:   RejectedIdentifierErr(errors.Errorf("dns %s is not allowed", o.Identifiers[0].Value)),
+			}
+		},
 		"fail/getOrder-error": func(t *testing.T) test {
 			id := "foo"
 			auth, err := NewAuthority(&db.MockNoSQLDB{
@@ -1174,7 +1225,10 @@ func TestAuthorityFinalizeOrder(t *testing.T) {
 	for name, run := range tests {
 		t.Run(name, func(t *testing.T) {
 			tc := run(t)
-			if acmeO, err := tc.auth.FinalizeOrder(prov, tc.accID, tc.id, nil); err != nil {
+			if tc.prov == nil {
+				tc.prov = prov
+			}
+			if acmeO, err := tc.auth.FinalizeOrder(tc.prov, tc.accID, tc.id, nil); err != nil {
 				if assert.NotNil(t, tc.err) {
 					ae, ok := err.(*Error)
 					assert.True(t, ok)
@@ -1206,6 +1260,7 @@ func TestAuthorityValidateChallenge(t *testing.T) {
 		id, accID string
 		err       *Error
 		ch        challenge
+		check     func(t *testing.T)
 	}
 	tests := map[string]func(t *testing.T) test{
 		"fail/getChallenge-error": func(t *testing.T) test {
@@ -1293,6 +1348,46 @@ func TestAuthorityValidateChallenge(t *testing.T) {
 				ch:    ch,
 			}
 		},
+		"ok/processing": func(t *testing.T) test {
+			ch, err := newHTTPCh()
+			assert.FatalError(t, err)
+			b, err := json.Marshal(ch)
+			assert.FatalError(t, err)
+			upd := ch.clone()
+			auth, err := NewAuthority(&db.MockNoSQLDB{
+				MGet: func(bucket, key []byte) ([]byte, error) {
+					assert.Equals(t, bucket, challengeTable)
+					assert.Equals(t, key, []byte(ch.getID()))
+					return b, nil
+				},
+				MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+					assert.Equals(t, bucket, challengeTable)
+					assert.Equals(t, key, []byte(ch.getID()))
+					assert.Equals(t, old, b)
+					assert.FatalError(t, json.Unmarshal(newval, upd))
+					assert.Equals(t, upd.Status, StatusProcessing)
+					return nil, true, nil
+				},
+			}, "ca.smallstep.com", "acme", nil)
+			assert.FatalError(t, err)
+			// Use a pool without workers to check the submitted job.
+			vp := newValidationPool(auth.db, nil)
+			auth.validationPools = map[string]*validationPool{prov.GetID(): vp}
+			return test{
+				auth:  auth,
+				id:    ch.getID(),
+				accID: ch.getAccountID(),
+				ch:    &http01Challenge{upd},
+				check: func(t *testing.T) {
+					select {
+					case job := <-vp.jobs:
+						assert.Equals(t, ch.getID(), job.chID)
+					case <-time.After(time.Second):
+						t.Error("validation job not submitted")
+					}
+				},
+			}
+		},
 	}
 	for name, run := range tests {
 		t.Run(name, func(t *testing.T) {
@@ -1316,6 +1411,9 @@ func TestAuthorityValidateChallenge(t *testing.T) {
 					assert.FatalError(t, err)
 
 					assert.Equals(t, expb, gotb)
+					if tc.check != nil {
+						tc.check(t)
+					}
 				}
 			}
 		})
@@ -1531,3 +1629,237 @@ func TestAuthorityDeactivateAccount(t *testing.T) {
 		})
 	}
 }
+
+func TestAuthorityKeyChange(t *testing.T) {
+	prov := newProv()
+	type test struct {
+		auth *Authority
+		id   string
+		key  *jose.JSONWebKey
+		acc  *account
+		err  *Error
+	}
+	tests := map[string]func(t *testing.T) test{
+		"fail/key-in-use": func(t *testing.T) test {
+			key, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
+			assert.FatalError(t, err)
+			kid, err := keyToID(key)
+			assert.FatalError(t, err)
+			auth, err := NewAuthority(&db.MockNoSQLDB{
+				MGet: func(bucket, key []byte) ([]byte, error) {
+					assert.Equals(t, bucket, accountByKeyIDTable)
+					assert.Equals(t, key, []byte(kid))
+					return []byte("other"), nil
+				},
+			}, "ca.smallstep.com", "acme", nil)
+			assert.FatalError(t, err)
+			err409 := MalformedErr(errors.New("new key is already in use by another account"))
+			err409.Status = 409
+			return test{
+				auth: auth,
+				id:   "foo",
+				key:  key,
+				err:  err409,
+			}
+		},
+		"fail/getAccount-error": func(t *testing.T) test {
+			key, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
+			assert.FatalError(t, err)
+			auth, err := NewAuthority(&db.MockNoSQLDB{
+				MGet: func(bucket, key []byte) ([]byte, error) {
+					if string(bucket) == string(accountByKeyIDTable) {
+						return nil, database.ErrNotFound
+					}
+					return nil, errors.New("force")
+				},
+			}, "ca.smallstep.com", "acme", nil)
+			assert.FatalError(t, err)
+			return test{
+				auth: auth,
+				id:   "foo",
+				key:  key,
+				err:  ServerInternalErr(errors.New("error loading account foo: force")),
+			}
+		},
+		"ok": func(t *testing.T) test {
+			acc, err := newAcc()
+			assert.FatalError(t, err)
+			b, err := json.Marshal(acc)
+			assert.FatalError(t, err)
+			key, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
+			assert.FatalError(t, err)
+
+			_acc := *acc
+			clone := &_acc
+			clone.Key = key
+			auth, err := NewAuthority(&db.MockNoSQLDB{
+				MGet: func(bucket, key []byte) ([]byte, error) {
+					if string(bucket) == string(accountByKeyIDTable) {
+						return nil, database.ErrNotFound
+					}
+					return b, nil
+				},
+				MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+					return newval, true, nil
+				},
+			}, "ca.smallstep.com", "acme", nil)
+			assert.FatalError(t, err)
+			return test{
+				auth: auth,
+				id:   acc.ID,
+				key:  key,
+				acc:  clone,
+			}
+		},
+	}
+	for name, run := range tests {
+		t.Run(name, func(t *testing.T) {
+			tc := run(t)
+			if acmeAcc, err := tc.auth.KeyChange(prov, tc.id, tc.key); err != nil {
+				if assert.NotNil(t, tc.err) {
+					ae, ok := err.(*Error)
+					assert.True(t, ok)
+					assert.HasPrefix(t, ae.Error(), tc.err.Error())
+					assert.Equals(t, ae.StatusCode(), tc.err.StatusCode())
+					assert.Equals(t, ae.Type, tc.err.Type)
+				}
+			} else {
+				if assert.Nil(t, tc.err) {
+					assert.Equals(t, tc.key, acmeAcc.Key)
+					assert.Equals(t, tc.acc.ID, acmeAcc.ID)
+				}
+			}
+		})
+	}
+}
+
+func TestAuthorityRevokeCertificate(t *testing.T) {
+	prov := newProv()
+	cert, err := newcert()
+	assert.FatalError(t, err)
+	certb, err := json.Marshal(cert)
+	assert.FatalError(t, err)
+	ops, err := defaultCertOps()
+	assert.FatalError(t, err)
+	crt := ops.Leaf
+	o, err := newO()
+	assert.FatalError(t, err)
+	o.Certificate = cert.ID
+	ob, err := json.Marshal(o)
+	assert.FatalError(t, err)
+	oidsb, err := json.Marshal([]string{o.ID})
+	assert.FatalError(t, err)
+	mockdb := &db.MockNoSQLDB{
+		MGet: func(bucket, key []byte) ([]byte, error) {
+			switch string(bucket) {
+			case string(ordersByAccountIDTable):
+				assert.Equals(t, key, []byte("accID"))
+				return oidsb, nil
+			case string(orderTable):
+				assert.Equals(t, key, []byte(o.ID))
+				return ob, nil
+			case string(certTable):
+				assert.Equals(t, key, []byte(cert.ID))
+				return certb, nil
+			default:
+				return nil, errors.Errorf("unexpected bucket %s", bucket)
+			}
+		},
+	}
+	otherKey, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
+	assert.FatalError(t, err)
+
+	type test struct {
+		auth  *Authority
+		accID string
+		jwk   *jose.JSONWebKey
+		err   *Error
+	}
+	tests := map[string]func(t *testing.T) test{
+		"fail/account-not-owner": func(t *testing.T) test {
+			auth, err := NewAuthority(&db.MockNoSQLDB{
+				MGet: func(bucket, key []byte) ([]byte, error) {
+					return nil, database.ErrNotFound
+				},
+			}, "ca.smallstep.com", "acme", &mockSignAuth{})
+			assert.FatalError(t, err)
+			return test{
+				auth:  auth,
+				accID: "accID",
+				err:   UnauthorizedErr(errors.New("account does not own certificate")),
+			}
+		},
+		"fail/key-mismatch": func(t *testing.T) test {
+			auth, err := NewAuthority(mockdb, "ca.smallstep.com", "acme", &mockSignAuth{})
+			assert.FatalError(t, err)
+			return test{
+				auth: auth,
+				jwk:  otherKey,
+				err:  UnauthorizedErr(errors.New("jwk does not match the certificate key")),
+			}
+		},
+		"fail/already-revoked": func(t *testing.T) test {
+			auth, err := NewAuthority(mockdb, "ca.smallstep.com", "acme", &mockSignAuth{
+				err: errs.BadRequest("certificate has already been revoked"),
+			})
+			assert.FatalError(t, err)
+			return test{
+				auth:  auth,
+				accID: "accID",
+				err:   AlreadyRevokedErr(errors.New("certificate has already been revoked")),
+			}
+		},
+		"fail/revoke-error": func(t *testing.T) test {
+			auth, err := NewAuthority(mockdb, "ca.smallstep.com", "acme", &mockSignAuth{
+				err: errors.New("force"),
+			})
+			assert.FatalError(t, err)
+			return test{
+				auth:  auth,
+				accID: "accID",
+				err:   ServerInternalErr(errors.New("error revoking certificate: force")),
+			}
+		},
+		"ok/account": func(t *testing.T) test {
+			auth, err := NewAuthority(mockdb, "ca.smallstep.com", "acme", &mockSignAuth{
+				revoke: func(ctx context.Context, opts *authority.RevokeOptions) error {
+					assert.Equals(t, provisioner.RevokeMethod, provisioner.MethodFromContext(ctx))
+					assert.Equals(t, crt.SerialNumber.String(), opts.Serial)
+					assert.Equals(t, 1, opts.ReasonCode)
+					assert.True(t, opts.ACME)
+					assert.Equals(t, crt, opts.Crt)
+					return nil
+				},
+			})
+			assert.FatalError(t, err)
+			return test{
+				auth:  auth,
+				accID: "accID",
+			}
+		},
+		"ok/certificate-key": func(t *testing.T) test {
+			auth, err := NewAuthority(mockdb, "ca.smallstep.com", "acme", &mockSignAuth{})
+			assert.FatalError(t, err)
+			return test{
+				auth: auth,
+				jwk:  &jose.JSONWebKey{Key: crt.PublicKey},
+			}
+		},
+	}
+	for name, run := range tests {
+		t.Run(name, func(t *testing.T) {
+			tc := run(t)
+			if err := tc.auth.RevokeCertificate(prov, tc.accID, tc.jwk, crt, 1); err != nil {
+				if assert.NotNil(t, tc.err) {
+					ae, ok := err.(*Error)
+					assert.True(t, ok)
+					assert.HasPrefix(t, ae.Error(), tc.err.Error())
+					assert.Equals(t, ae.StatusCode(), tc.err.StatusCode())
+					assert.Equals(t, ae.Type, tc.err.Type)
+				}
+			} else {
+				assert.Nil(t, tc.err)
+			}
+		})
+	}
+}
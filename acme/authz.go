@@ -2,6 +2,7 @@ package acme
 
 import (
 	"encoding/json"
+	"net"
 	"strings"
 	"time"
 
@@ -196,6 +197,9 @@ func (ba *baseAuthz) clone() *baseAuthz {
 }
 
 func (ba *baseAuthz) parent() authz {
+	if ba.Identifier.Type == "ip" {
+		return &ipAuthz{ba}
+	}
 	return &dnsAuthz{ba}
 }
 
@@ -218,7 +222,10 @@ func (ba *baseAuthz) updateStatus(db nosql.DB) (authz, error) {
 			break
 		}
 
-		var isValid = false
+		var (
+			isValid   = false
+			invalidCh challenge
+		)
 		for _, chID := range ba.Challenges {
 			ch, err := getChallenge(db, chID)
 			if err != nil {
@@ -228,13 +235,23 @@ func (ba *baseAuthz) updateStatus(db nosql.DB) (authz, error) {
 				isValid = true
 				break
 			}
+			if ch.getStatus() == StatusInvalid && invalidCh == nil {
+				invalidCh = ch
+			}
 		}
 
-		if !isValid {
+		switch {
+		case isValid:
+			newAuthz.Status = StatusValid
+			newAuthz.Error = nil
+		case invalidCh != nil:
+			// RFC8555: If the validation of a challenge fails, the
+			// authorization is marked as invalid.
+			newAuthz.Status = StatusInvalid
+			newAuthz.Error = RejectedIdentifierErr(errors.Errorf("challenge %s is invalid", invalidCh.getID()))
+		default:
 			return ba.parent(), nil
 		}
-		newAuthz.Status = StatusValid
-		newAuthz.Error = nil
 	default:
 		return nil, ServerInternalErr(errors.Errorf("unrecognized authz status: %s", ba.Status))
 	}
@@ -261,6 +278,12 @@ func unmarshalAuthz(data []byte) (authz, error) {
 			return nil, ServerInternalErr(errors.Wrap(err, "error unmarshaling authz type into dnsAuthz"))
 		}
 		return &dnsAuthz{&ba}, nil
+	case "ip":
+		var ba baseAuthz
+		if err := json.Unmarshal(data, &ba); err != nil {
+			return nil, ServerInternalErr(errors.Wrap(err, "error unmarshaling authz type into ipAuthz"))
+		}
+		return &ipAuthz{&ba}, nil
 	default:
 		return nil, ServerInternalErr(errors.Errorf("unexpected authz type %s",
 			getType.Identifier.Type))
@@ -278,6 +301,8 @@ func newAuthz(db nosql.DB, accID string, identifier Identifier) (a authz, err er
 	switch identifier.Type {
 	case "dns":
 		a, err = newDNSAuthz(db, accID, identifier)
+	case "ip":
+		a, err = newIPAuthz(db, accID, identifier)
 	default:
 		err = MalformedErr(errors.Errorf("unexpected authz type %s",
 			identifier.Type))
@@ -331,6 +356,48 @@ func newDNSAuthz(db nosql.DB, accID string, identifier Identifier) (authz, error
 	return da, nil
 }
 
+// ipAuthz represents an ip acme authorization (RFC 8738).
+type ipAuthz struct {
+	*baseAuthz
+}
+
+// newIPAuthz returns a new ip acme authorization object. The dns-01 challenge
+// is not allowed for ip identifiers.
+func newIPAuthz(db nosql.DB, accID string, identifier Identifier) (authz, error) {
+	if net.ParseIP(identifier.Value) == nil {
+		return nil, MalformedErr(errors.Errorf("invalid IP address %s", identifier.Value))
+	}
+
+	ba, err := newBaseAuthz(accID, identifier)
+	if err != nil {
+		return nil, err
+	}
+
+	ch1, err := newHTTP01Challenge(db, ChallengeOptions{
+		AccountID:  accID,
+		AuthzID:    ba.ID,
+		Identifier: ba.Identifier})
+	if err != nil {
+		return nil, Wrap(err, "error creating http challenge")
+	}
+	ch2, err := newTLSALPN01Challenge(db, ChallengeOptions{
+		AccountID:  accID,
+		AuthzID:    ba.ID,
+		Identifier: ba.Identifier,
+	})
+	if err != nil {
+		return nil, Wrap(err, "error creating alpn challenge")
+	}
+	ba.Challenges = []string{ch1.getID(), ch2.getID()}
+
+	ia := &ipAuthz{ba}
+	if err := ia.save(db, nil); err != nil {
+		return nil, err
+	}
+
+	return ia, nil
+}
+
 // getAuthz retrieves and unmarshals an ACME authz type from the database.
 func getAuthz(db nosql.DB, id string) (authz, error) {
 	b, err := db.Get(authzTable, []byte(id))
@@ -296,6 +296,57 @@ func TestNewAuthz(t *testing.T) {
 				resChs: chs,
 			}
 		},
+		"fail/invalid-ip": func(t *testing.T) test {
+			return test{
+				iden: Identifier{Type: "ip", Value: "acme.example.com"},
+				err:  MalformedErr(errors.New("invalid IP address acme.example.com")),
+			}
+		},
+		"ok/ip": func(t *testing.T) test {
+			chs := &([]string{})
+			count := 0
+			_iden := Identifier{Type: "ip", Value: "192.168.0.1"}
+			return test{
+				iden: _iden,
+				db: &db.MockNoSQLDB{
+					MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+						switch count {
+						case 0:
+							ch, err := unmarshalChallenge(newval)
+							assert.FatalError(t, err)
+							assert.Equals(t, ch.getType(), "http-01")
+							assert.Equals(t, ch.getValue(), _iden.Value)
+						case 1:
+							ch, err := unmarshalChallenge(newval)
+							assert.FatalError(t, err)
+							assert.Equals(t, ch.getType(), "tls-alpn-01")
+							assert.Equals(t, ch.getValue(), _iden.Value)
+						case 2:
+							assert.Equals(t, bucket, authzTable)
+							assert.Equals(t, old, nil)
+
+							az, err := unmarshalAuthz(newval)
+							assert.FatalError(t, err)
+							_, ok := az.(*ipAuthz)
+							assert.True(t, ok)
+
+							assert.Equals(t, az.getID(), string(key))
+							assert.Equals(t, az.getAccountID(), accID)
+							assert.Equals(t, az.getStatus(), StatusPending)
+							assert.Equals(t, az.getIdentifier(), _iden)
+							assert.Equals(t, az.getWildcard(), false)
+
+							*chs = az.getChallenges()
+							// Verify that there is no dns-01 challenge.
+							assert.True(t, len(*chs) == 2)
+						}
+						count++
+						return nil, true, nil
+					},
+				},
+				resChs: chs,
+			}
+		},
 	}
 	for name, run := range tests {
 		tc := run(t)
@@ -312,7 +363,7 @@ func TestNewAuthz(t *testing.T) {
 			} else {
 				if assert.Nil(t, tc.err) {
 					assert.Equals(t, az.getAccountID(), accID)
-					assert.Equals(t, az.getType(), "dns")
+					assert.Equals(t, az.getType(), tc.iden.Type)
 					assert.Equals(t, az.getStatus(), StatusPending)
 
 					assert.True(t, az.getCreated().Before(time.Now().UTC().Add(time.Minute)))
@@ -584,6 +635,22 @@ func TestAuthzUnmarshal(t *testing.T) {
 				azb: b,
 			}
 		},
+		"ok/ip": func(t *testing.T) test {
+			az, err := newIPAuthz(&db.MockNoSQLDB{
+				MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+					return nil, true, nil
+				},
+			}, "1234", Identifier{
+				Type: "ip", Value: "10.0.0.1",
+			})
+			assert.FatalError(t, err)
+			b, err := json.Marshal(az)
+			assert.FatalError(t, err)
+			return test{
+				az:  az,
+				azb: b,
+			}
+		},
 	}
 	for name, run := range tests {
 		t.Run(name, func(t *testing.T) {
@@ -805,6 +872,57 @@ func TestAuthzUpdateStatus(t *testing.T) {
 				},
 			}
 		},
+		"ok/invalid-challenge": func(t *testing.T) test {
+			var ch1Bytes, ch2Bytes = &([]byte{}), &([]byte{})
+			var ch1ID string
+
+			count := 0
+			mockdb := &db.MockNoSQLDB{
+				MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+					if count == 0 {
+						ch, err := unmarshalChallenge(newval)
+						assert.FatalError(t, err)
+						upd := ch.clone()
+						upd.Status = StatusInvalid
+						*ch1Bytes, err = json.Marshal(upd)
+						assert.FatalError(t, err)
+						ch1ID = ch.getID()
+					} else if count == 1 {
+						*ch2Bytes = newval
+					}
+					count++
+					return nil, true, nil
+				},
+			}
+			iden := Identifier{
+				Type: "dns", Value: "acme.example.com",
+			}
+			az, err := newAuthz(mockdb, "1234", iden)
+			assert.FatalError(t, err)
+
+			clone := az.clone()
+			clone.Status = StatusInvalid
+			clone.Error = RejectedIdentifierErr(errors.Errorf("challenge %s is invalid", ch1ID))
+
+			count = 0
+			return test{
+				az:  az,
+				res: clone.parent(),
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						if count == 0 {
+							count++
+							return *ch1Bytes, nil
+						}
+						count++
+						return *ch2Bytes, nil
+					},
+					MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+						return nil, true, nil
+					},
+				},
+			}
+		},
 	}
 	for name, run := range tests {
 		t.Run(name, func(t *testing.T) {
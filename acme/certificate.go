@@ -1,6 +1,7 @@
 package acme
 
 import (
+	"bytes"
 	"crypto/x509"
 	"encoding/json"
 	"encoding/pem"
@@ -87,3 +88,29 @@ func getCert(db nosql.DB, id string) (*certificate, error) {
 	}
 	return &cert, nil
 }
+
+// accountOwnsCertificate returns true if the given certificate has been issued
+// to the account through one of its orders.
+func accountOwnsCertificate(db nosql.DB, accID string, crt *x509.Certificate) (bool, error) {
+	oids, err := getOrderIDsByAccount(db, accID)
+	if err != nil {
+		return false, err
+	}
+	for _, oid := range oids {
+		o, err := getOrder(db, oid)
+		if err != nil {
+			return false, err
+		}
+		if o.Certificate == "" {
+			continue
+		}
+		cert, err := getCert(db, o.Certificate)
+		if err != nil {
+			return false, err
+		}
+		if block, _ := pem.Decode(cert.Leaf); block != nil && bytes.Equal(block.Bytes, crt.Raw) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
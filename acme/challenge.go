@@ -25,14 +25,15 @@ import (
 // Challenge is a subset of the challenge type containing only those attributes
 // required for responses in the ACME protocol.
 type Challenge struct {
-	Type      string  `json:"type"`
-	Status    string  `json:"status"`
-	Token     string  `json:"token"`
-	Validated string  `json:"validated,omitempty"`
-	URL       string  `json:"url"`
-	Error     *AError `json:"error,omitempty"`
-	ID        string  `json:"-"`
-	AuthzID   string  `json:"-"`
+	Type       string    `json:"type"`
+	Status     string    `json:"status"`
+	Token      string    `json:"token"`
+	Validated  string    `json:"validated,omitempty"`
+	URL        string    `json:"url"`
+	Error      *AError   `json:"error,omitempty"`
+	ID         string    `json:"-"`
+	AuthzID    string    `json:"-"`
+	RetryAfter time.Time `json:"-"`
 }
 
 // ToLog enables response logging.
@@ -79,6 +80,7 @@ type challenge interface {
 	getAccountID() string
 	getValidated() time.Time
 	getCreated() time.Time
+	getRetryAfter() time.Time
 	toACME(nosql.DB, *directory, provisioner.Interface) (*Challenge, error)
 }
 
@@ -91,16 +93,17 @@ type ChallengeOptions struct {
 
 // baseChallenge is the base Challenge type that others build from.
 type baseChallenge struct {
-	ID        string    `json:"id"`
-	AccountID string    `json:"accountID"`
-	AuthzID   string    `json:"authzID"`
-	Type      string    `json:"type"`
-	Status    string    `json:"status"`
-	Token     string    `json:"token"`
-	Value     string    `json:"value"`
-	Validated time.Time `json:"validated"`
-	Created   time.Time `json:"created"`
-	Error     *AError   `json:"error"`
+	ID         string    `json:"id"`
+	AccountID  string    `json:"accountID"`
+	AuthzID    string    `json:"authzID"`
+	Type       string    `json:"type"`
+	Status     string    `json:"status"`
+	Token      string    `json:"token"`
+	Value      string    `json:"value"`
+	Validated  time.Time `json:"validated"`
+	Created    time.Time `json:"created"`
+	Error      *AError   `json:"error"`
+	RetryAfter time.Time `json:"retryAfter"`
 }
 
 func newBaseChallenge(accountID, authzID string) (*baseChallenge, error) {
@@ -168,6 +171,12 @@ func (bc *baseChallenge) getCreated() time.Time {
 	return bc.Created
 }
 
+// getRetryAfter returns the time of the next validation attempt of the
+// baseChallenge.
+func (bc *baseChallenge) getRetryAfter() time.Time {
+	return bc.RetryAfter
+}
+
 // getCreated returns the created time of the baseChallenge.
 func (bc *baseChallenge) getError() *AError {
 	return bc.Error
@@ -190,6 +199,9 @@ func (bc *baseChallenge) toACME(db nosql.DB, dir *directory, p provisioner.Inter
 	if bc.Error != nil {
 		ac.Error = bc.Error
 	}
+	if bc.Status == StatusProcessing {
+		ac.RetryAfter = bc.RetryAfter
+	}
 	return ac, nil
 }
 
@@ -306,7 +318,11 @@ func (hc *http01Challenge) validate(db nosql.DB, jwk *jose.JSONWebKey, vo valida
 	if hc.getStatus() == StatusValid || hc.getStatus() == StatusInvalid {
 		return hc, nil
 	}
-	url := fmt.Sprintf("http://%s/.well-known/acme-challenge/%s", hc.Value, hc.Token)
+	host := hc.Value
+	if ip := net.ParseIP(host); ip != nil && ip.To4() == nil {
+		host = "[" + host + "]"
+	}
+	url := fmt.Sprintf("http://%s/.well-known/acme-challenge/%s", host, hc.Token)
 
 	resp, err := vo.httpGet(url)
 	if err != nil {
@@ -384,9 +400,17 @@ func (tc *tlsALPN01Challenge) validate(db nosql.DB, jwk *jose.JSONWebKey, vo val
 		return tc, nil
 	}
 
+	// RFC 8738: for ip identifiers the SNI is the reverse dns name of the
+	// address.
+	serverName := tc.Value
+	ip := net.ParseIP(tc.Value)
+	if ip != nil {
+		serverName = reverseAddr(ip)
+	}
+
 	config := &tls.Config{
 		NextProtos:         []string{"acme-tls/1"},
-		ServerName:         tc.Value,
+		ServerName:         serverName,
 		InsecureSkipVerify: true, // we expect a self-signed challenge certificate
 	}
 
@@ -425,7 +449,16 @@ func (tc *tlsALPN01Challenge) validate(db nosql.DB, jwk *jose.JSONWebKey, vo val
 
 	leafCert := certs[0]
 
-	if len(leafCert.DNSNames) != 1 || !strings.EqualFold(leafCert.DNSNames[0], tc.Value) {
+	if ip != nil {
+		if len(leafCert.IPAddresses) != 1 || len(leafCert.DNSNames) != 0 || !leafCert.IPAddresses[0].Equal(ip) {
+			if err = tc.storeError(db,
+				RejectedIdentifierErr(errors.Errorf("incorrect certificate for tls-alpn-01 challenge: "+
+					"leaf certificate must contain a single IP address, %v", tc.Value))); err != nil {
+				return nil, err
+			}
+			return tc, nil
+		}
+	} else if len(leafCert.DNSNames) != 1 || !strings.EqualFold(leafCert.DNSNames[0], tc.Value) {
 		if err = tc.storeError(db,
 			RejectedIdentifierErr(errors.Errorf("incorrect certificate for tls-alpn-01 challenge: "+
 				"leaf certificate must contain a single DNS name, %v", tc.Value))); err != nil {
@@ -531,6 +564,20 @@ func newDNS01Challenge(db nosql.DB, ops ChallengeOptions) (challenge, error) {
 	return dc, nil
 }
 
+// reverseAddr returns the in-addr.arpa or ip6.arpa name of the given IP
+// address.
+func reverseAddr(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return fmt.Sprintf("%d.%d.%d.%d.in-addr.arpa", ip4[3], ip4[2], ip4[1], ip4[0])
+	}
+	const hexDigits = "0123456789abcdef"
+	b := make([]byte, 0, len(ip)*4+len("ip6.arpa"))
+	for i := len(ip) - 1; i >= 0; i-- {
+		b = append(b, hexDigits[ip[i]&0x0f], '.', hexDigits[ip[i]>>4], '.')
+	}
+	return string(append(b, "ip6.arpa"...))
+}
+
 // KeyAuthorization creates the ACME key authorization value from a token
 // and a jwk.
 func KeyAuthorization(token string, jwk *jose.JSONWebKey) (string, error) {
@@ -1,11 +1,13 @@
 package acme
 
 import (
+	"context"
 	"crypto/x509"
 	"net/url"
 	"time"
 
 	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/authority"
 	"github.com/smallstep/certificates/authority/provisioner"
 	"github.com/smallstep/cli/crypto/randutil"
 )
@@ -14,6 +16,7 @@ import (
 type SignAuthority interface {
 	Sign(cr *x509.CertificateRequest, opts provisioner.Options, signOpts ...provisioner.SignOption) ([]*x509.Certificate, error)
 	LoadProvisionerByID(string) (provisioner.Interface, error)
+	Revoke(context.Context, *authority.RevokeOptions) error
 }
 
 // Identifier encodes the type that an order pertains to.
@@ -33,9 +36,11 @@ var (
 	StatusDeactivated = "deactivated"
 	// StatusReady -- ready; e.g. for an Order that is ready to be finalized.
 	StatusReady = "ready"
+	// StatusProcessing -- processing; e.g. for a Challenge that is being
+	// validated.
+	StatusProcessing = "processing"
 	//statusExpired     = "expired"
 	//statusActive      = "active"
-	//statusProcessing  = "processing"
 )
 
 var idLen = 32
@@ -9,12 +9,18 @@ import (
 
 // Directory represents an ACME directory for configuring clients.
 type Directory struct {
-	NewNonce   string `json:"newNonce,omitempty"`
-	NewAccount string `json:"newAccount,omitempty"`
-	NewOrder   string `json:"newOrder,omitempty"`
-	NewAuthz   string `json:"newAuthz,omitempty"`
-	RevokeCert string `json:"revokeCert,omitempty"`
-	KeyChange  string `json:"keyChange,omitempty"`
+	NewNonce   string         `json:"newNonce,omitempty"`
+	NewAccount string         `json:"newAccount,omitempty"`
+	NewOrder   string         `json:"newOrder,omitempty"`
+	NewAuthz   string         `json:"newAuthz,omitempty"`
+	RevokeCert string         `json:"revokeCert,omitempty"`
+	KeyChange  string         `json:"keyChange,omitempty"`
+	Meta       *DirectoryMeta `json:"meta,omitempty"`
+}
+
+// DirectoryMeta represents the metadata of an ACME directory.
+type DirectoryMeta struct {
+	ExternalAccountRequired bool `json:"externalAccountRequired,omitempty"`
 }
 
 // ToLog enables response logging for the Directory type.
@@ -0,0 +1,121 @@
+package acme
+
+import (
+	"encoding/json"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/cli/jose"
+	"github.com/smallstep/nosql"
+)
+
+// eabProvisioner is the interface implemented by provisioners that support
+// external account bindings.
+type eabProvisioner interface {
+	IsExternalAccountRequired() bool
+	GetExternalAccountKey(kid string) ([]byte, bool)
+}
+
+// verifyExternalAccountBinding validates the external account binding (RFC
+// 8555 section 7.3.4) of a new account request. It returns the key id of the
+// external account if the provisioner requires an external account binding,
+// or an empty string otherwise.
+func (a *Authority) verifyExternalAccountBinding(p provisioner.Interface, ao AccountOptions) (string, error) {
+	prov, ok := p.(eabProvisioner)
+	if !ok || !prov.IsExternalAccountRequired() {
+		return "", nil
+	}
+	if len(ao.ExternalAccountBinding) == 0 {
+		return "", ExternalAccountRequiredErr(nil)
+	}
+
+	jws, err := jose.ParseJWS(string(ao.ExternalAccountBinding))
+	if err != nil {
+		return "", MalformedErr(errors.Wrap(err, "error parsing externalAccountBinding"))
+	}
+	if len(jws.Signatures) != 1 {
+		return "", MalformedErr(errors.New("externalAccountBinding must have one signature"))
+	}
+
+	hdr := jws.Signatures[0].Protected
+	switch hdr.Algorithm {
+	case jose.HS256, jose.HS384, jose.HS512:
+	default:
+		return "", MalformedErr(errors.Errorf("unsupported externalAccountBinding algorithm %s", hdr.Algorithm))
+	}
+	if hdr.KeyID == "" {
+		return "", MalformedErr(errors.New("externalAccountBinding kid cannot be empty"))
+	}
+	if hdr.Nonce != "" {
+		return "", MalformedErr(errors.New("externalAccountBinding must not contain a nonce"))
+	}
+	u, ok := hdr.ExtraHeaders["url"].(string)
+	if !ok || u != a.dir.getLink(NewAccountLink, URLSafeProvisionerName(p), true) {
+		return "", MalformedErr(errors.New("externalAccountBinding url does not match the newAccount url"))
+	}
+
+	key, ok := prov.GetExternalAccountKey(hdr.KeyID)
+	if !ok {
+		return "", UnauthorizedErr(errors.Errorf("external account %s not found", hdr.KeyID))
+	}
+	payload, err := jws.Verify(key)
+	if err != nil {
+		return "", UnauthorizedErr(errors.Wrap(err, "error verifying externalAccountBinding"))
+	}
+
+	// The payload must be the account key.
+	var jwk jose.JSONWebKey
+	if err := json.Unmarshal(payload, &jwk); err != nil {
+		return "", MalformedErr(errors.Wrap(err, "error unmarshaling externalAccountBinding payload"))
+	}
+	kid, err := keyToID(&jwk)
+	if err != nil {
+		return "", err
+	}
+	accKid, err := keyToID(ao.Key)
+	if err != nil {
+		return "", err
+	}
+	if kid != accKid {
+		return "", UnauthorizedErr(errors.New("externalAccountBinding payload does not match the account key"))
+	}
+
+	// An external account can only be bound to one acme account.
+	if _, err := a.db.Get(accountByEABKeyIDTable, eabIndexKey(p, hdr.KeyID)); err == nil {
+		return "", UnauthorizedErr(errors.Errorf("external account %s is already bound to an account", hdr.KeyID))
+	} else if !nosql.IsErrNotFound(err) {
+		return "", ServerInternalErr(errors.Wrap(err, "error loading external account binding"))
+	}
+	return hdr.KeyID, nil
+}
+
+// bindExternalAccountKey stores the external account key id to account id
+// index. If the external account has been bound in the meantime, the new
+// account is removed.
+func (a *account) bindExternalAccountKey(db nosql.DB, p provisioner.Interface) error {
+	_, swapped, err := db.CmpAndSwap(accountByEABKeyIDTable, eabIndexKey(p, a.EABKeyID), nil, []byte(a.ID))
+	switch {
+	case err != nil:
+		a.remove(db)
+		return ServerInternalErr(errors.Wrap(err, "error setting external account to account-id index"))
+	case !swapped:
+		a.remove(db)
+		return UnauthorizedErr(errors.Errorf("external account %s is already bound to an account", a.EABKeyID))
+	default:
+		return nil
+	}
+}
+
+// remove deletes a new account and its key-id to account-id index.
+func (a *account) remove(db nosql.DB) {
+	if kid, err := keyToID(a.Key); err == nil {
+		db.Del(accountByKeyIDTable, []byte(kid))
+	}
+	db.Del(accountTable, []byte(a.ID))
+}
+
+// eabIndexKey returns the key used to index an external account, external
+// account key ids are scoped by provisioner.
+func eabIndexKey(p provisioner.Interface, kid string) []byte {
+	return []byte(p.GetID() + "/" + kid)
+}
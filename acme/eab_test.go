@@ -0,0 +1,326 @@
+package acme
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/db"
+	"github.com/smallstep/cli/jose"
+	"github.com/smallstep/nosql/database"
+)
+
+func newEABProv(t *testing.T, keys map[string][]byte) provisioner.Interface {
+	eabKeys := make(map[string]string)
+	for kid, key := range keys {
+		eabKeys[kid] = base64.RawURLEncoding.EncodeToString(key)
+	}
+	p := &provisioner.ACME{
+		Type:       "ACME",
+		Name:       "test@acme-eab-provisioner.com",
+		RequireEAB: true,
+		EABKeys:    eabKeys,
+	}
+	assert.FatalError(t, p.Init(provisioner.Config{Claims: globalProvisionerClaims}))
+	return p
+}
+
+func newEAB(t *testing.T, alg jose.SignatureAlgorithm, kid, url string, key interface{}, payload *jose.JSONWebKey) []byte {
+	b, err := json.Marshal(payload)
+	assert.FatalError(t, err)
+	so := new(jose.SignerOptions)
+	so.WithHeader("kid", kid)
+	so.WithHeader("url", url)
+	signer, err := jose.NewSigner(jose.SigningKey{
+		Algorithm: alg,
+		Key:       key,
+	}, so)
+	assert.FatalError(t, err)
+	jws, err := signer.Sign(b)
+	assert.FatalError(t, err)
+	return []byte(jws.FullSerialize())
+}
+
+func TestAuthorityVerifyExternalAccountBinding(t *testing.T) {
+	jwk, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
+	assert.FatalError(t, err)
+	pub := jwk.Public()
+	other, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
+	assert.FatalError(t, err)
+	otherPub := other.Public()
+
+	secret := []byte("a-very-secret-hmac-key")
+	prov := newEABProv(t, map[string][]byte{"kid": secret})
+	url := "https://ca.smallstep.com/acme/" + URLSafeProvisionerName(prov) + "/new-account"
+
+	notFound := &db.MockNoSQLDB{
+		MGet: func(bucket, key []byte) ([]byte, error) {
+			assert.Equals(t, bucket, accountByEABKeyIDTable)
+			assert.Equals(t, key, []byte(prov.GetID()+"/kid"))
+			return nil, database.ErrNotFound
+		},
+	}
+
+	type test struct {
+		db   *db.MockNoSQLDB
+		prov provisioner.Interface
+		ops  AccountOptions
+		kid  string
+		err  *Error
+	}
+	tests := map[string]func(t *testing.T) test{
+		"ok/not-required": func(t *testing.T) test {
+			return test{
+				db:   notFound,
+				prov: newProv(),
+				ops:  AccountOptions{Key: &pub},
+			}
+		},
+		"fail/missing": func(t *testing.T) test {
+			return test{
+				db:   notFound,
+				prov: prov,
+				ops:  AccountOptions{Key: &pub},
+				err:  ExternalAccountRequiredErr(nil),
+			}
+		},
+		"fail/parse": func(t *testing.T) test {
+			return test{
+				db:   notFound,
+				prov: prov,
+				ops:  AccountOptions{Key: &pub, ExternalAccountBinding: []byte(`"foo"`)},
+				err:  MalformedErr(errors.New("error parsing externalAccountBinding")),
+			}
+		},
+		"fail/alg": func(t *testing.T) test {
+			return test{
+				db:   notFound,
+				prov: prov,
+				ops: AccountOptions{
+					Key:                    &pub,
+					ExternalAccountBinding: newEAB(t, jose.ES256, "kid", url, jwk.Key, &pub),
+				},
+				err: MalformedErr(errors.New("unsupported externalAccountBinding algorithm ES256")),
+			}
+		},
+		"fail/url": func(t *testing.T) test {
+			return test{
+				db:   notFound,
+				prov: prov,
+				ops: AccountOptions{
+					Key:                    &pub,
+					ExternalAccountBinding: newEAB(t, jose.HS256, "kid", "https://foo.bar/new-account", secret, &pub),
+				},
+				err: MalformedErr(errors.New("externalAccountBinding url does not match the newAccount url")),
+			}
+		},
+		"fail/unknown-kid": func(t *testing.T) test {
+			return test{
+				db:   notFound,
+				prov: prov,
+				ops: AccountOptions{
+					Key:                    &pub,
+					ExternalAccountBinding: newEAB(t, jose.HS256, "foo", url, secret, &pub),
+				},
+				err: UnauthorizedErr(errors.New("external account foo not found")),
+			}
+		},
+		"fail/signature": func(t *testing.T) test {
+			return test{
+				db:   notFound,
+				prov: prov,
+				ops: AccountOptions{
+					Key:                    &pub,
+					ExternalAccountBinding: newEAB(t, jose.HS256, "kid", url, []byte("another-secret"), &pub),
+				},
+				err: UnauthorizedErr(errors.New("error verifying externalAccountBinding")),
+			}
+		},
+		"fail/payload": func(t *testing.T) test {
+			return test{
+				db:   notFound,
+				prov: prov,
+				ops: AccountOptions{
+					Key:                    &pub,
+					ExternalAccountBinding: newEAB(t, jose.HS256, "kid", url, secret, &otherPub),
+				},
+				err: UnauthorizedErr(errors.New("externalAccountBinding payload does not match the account key")),
+			}
+		},
+		"fail/already-bound": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						return []byte("accID"), nil
+					},
+				},
+				prov: prov,
+				ops: AccountOptions{
+					Key:                    &pub,
+					ExternalAccountBinding: newEAB(t, jose.HS256, "kid", url, secret, &pub),
+				},
+				err: UnauthorizedErr(errors.New("external account kid is already bound to an account")),
+			}
+		},
+		"fail/db-error": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						return nil, errors.New("force")
+					},
+				},
+				prov: prov,
+				ops: AccountOptions{
+					Key:                    &pub,
+					ExternalAccountBinding: newEAB(t, jose.HS256, "kid", url, secret, &pub),
+				},
+				err: ServerInternalErr(errors.New("error loading external account binding: force")),
+			}
+		},
+		"ok": func(t *testing.T) test {
+			return test{
+				db:   notFound,
+				prov: prov,
+				ops: AccountOptions{
+					Key:                    &pub,
+					ExternalAccountBinding: newEAB(t, jose.HS256, "kid", url, secret, &pub),
+				},
+				kid: "kid",
+			}
+		},
+		"ok/hs512": func(t *testing.T) test {
+			return test{
+				db:   notFound,
+				prov: prov,
+				ops: AccountOptions{
+					Key:                    &pub,
+					ExternalAccountBinding: newEAB(t, jose.HS512, "kid", url, secret, &pub),
+				},
+				kid: "kid",
+			}
+		},
+	}
+	for name, run := range tests {
+		t.Run(name, func(t *testing.T) {
+			tc := run(t)
+			auth, err := NewAuthority(tc.db, "ca.smallstep.com", "acme", nil)
+			assert.FatalError(t, err)
+			kid, err := auth.verifyExternalAccountBinding(tc.prov, tc.ops)
+			if err != nil {
+				if assert.NotNil(t, tc.err) {
+					ae, ok := err.(*Error)
+					assert.True(t, ok)
+					assert.HasPrefix(t, ae.Error(), tc.err.Error())
+					assert.Equals(t, ae.StatusCode(), tc.err.StatusCode())
+					assert.Equals(t, ae.Type, tc.err.Type)
+				}
+			} else if assert.Nil(t, tc.err) {
+				assert.Equals(t, tc.kid, kid)
+			}
+		})
+	}
+}
+
+func TestAuthorityNewAccountWithEAB(t *testing.T) {
+	jwk, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
+	assert.FatalError(t, err)
+	pub := jwk.Public()
+	secret := []byte("a-very-secret-hmac-key")
+	prov := newEABProv(t, map[string][]byte{"kid": secret})
+	url := "https://ca.smallstep.com/acme/" + URLSafeProvisionerName(prov) + "/new-account"
+	ops := AccountOptions{
+		Key:                    &pub,
+		ExternalAccountBinding: newEAB(t, jose.HS256, "kid", url, secret, &pub),
+	}
+
+	type test struct {
+		db      *db.MockNoSQLDB
+		deleted int
+		err     *Error
+	}
+	tests := map[string]func(t *testing.T) test{
+		"fail/bind-race": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						return nil, database.ErrNotFound
+					},
+					MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+						if string(bucket) == string(accountByEABKeyIDTable) {
+							return []byte("otherAccID"), false, nil
+						}
+						return nil, true, nil
+					},
+				},
+				deleted: 2,
+				err:     UnauthorizedErr(errors.New("external account kid is already bound to an account")),
+			}
+		},
+		"fail/bind-error": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						return nil, database.ErrNotFound
+					},
+					MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+						if string(bucket) == string(accountByEABKeyIDTable) {
+							return nil, false, errors.New("force")
+						}
+						return nil, true, nil
+					},
+				},
+				deleted: 2,
+				err:     ServerInternalErr(errors.New("error setting external account to account-id index: force")),
+			}
+		},
+		"ok": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						return nil, database.ErrNotFound
+					},
+					MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+						if string(bucket) == string(accountTable) {
+							var acc *account
+							assert.FatalError(t, json.Unmarshal(newval, &acc))
+							assert.Equals(t, "kid", acc.EABKeyID)
+						}
+						if string(bucket) == string(accountByEABKeyIDTable) {
+							assert.Equals(t, key, []byte(prov.GetID()+"/kid"))
+							assert.Nil(t, old)
+						}
+						return nil, true, nil
+					},
+				},
+			}
+		},
+	}
+	for name, run := range tests {
+		t.Run(name, func(t *testing.T) {
+			tc := run(t)
+			var deleted int
+			tc.db.MDel = func(bucket, key []byte) error {
+				deleted++
+				return nil
+			}
+			auth, err := NewAuthority(tc.db, "ca.smallstep.com", "acme", nil)
+			assert.FatalError(t, err)
+			acc, err := auth.NewAccount(prov, ops)
+			assert.Equals(t, tc.deleted, deleted)
+			if err != nil {
+				if assert.NotNil(t, tc.err) {
+					ae, ok := err.(*Error)
+					assert.True(t, ok)
+					assert.HasPrefix(t, ae.Error(), tc.err.Error())
+					assert.Equals(t, ae.StatusCode(), tc.err.StatusCode())
+					assert.Equals(t, ae.Type, tc.err.Type)
+				}
+			} else if assert.Nil(t, tc.err) {
+				assert.Equals(t, StatusValid, acc.Status)
+			}
+		})
+	}
+}
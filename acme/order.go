@@ -4,6 +4,7 @@ import (
 	"context"
 	"crypto/x509"
 	"encoding/json"
+	"net"
 	"sort"
 	"strings"
 	"time"
@@ -258,14 +259,23 @@ func (o *order) finalize(db nosql.DB, csr *x509.CertificateRequest, auth SignAut
 	// identifiers as the initial newOrder request. Identifiers of type "dns"
 	// MUST appear either in the commonName portion of the requested subject
 	// name or in an extensionRequest attribute [RFC2985] requesting a
-	// subjectAltName extension, or both.
+	// subjectAltName extension, or both. RFC8738: Identifiers of type "ip"
+	// MUST appear in an iPAddress subjectAltName.
 	if csr.Subject.CommonName != "" {
-		csr.DNSNames = append(csr.DNSNames, csr.Subject.CommonName)
+		if ip := net.ParseIP(csr.Subject.CommonName); ip != nil {
+			csr.IPAddresses = append(csr.IPAddresses, ip)
+		} else {
+			csr.DNSNames = append(csr.DNSNames, csr.Subject.CommonName)
+		}
 	}
 	csr.DNSNames = uniqueLowerNames(csr.DNSNames)
-	orderNames := make([]string, len(o.Identifiers))
-	for i, n := range o.Identifiers {
-		orderNames[i] = n.Value
+	var orderNames, orderIPs []string
+	for _, n := range o.Identifiers {
+		if n.Type == "ip" {
+			orderIPs = append(orderIPs, n.Value)
+		} else {
+			orderNames = append(orderNames, n.Value)
+		}
 	}
 	orderNames = uniqueLowerNames(orderNames)
 
@@ -279,6 +289,22 @@ func (o *order) finalize(db nosql.DB, csr *x509.CertificateRequest, auth SignAut
 		}
 	}
 
+	// Validate identifier IPs against CSR IP addresses.
+	csrIPs := make([]string, len(csr.IPAddresses))
+	for i, ip := range csr.IPAddresses {
+		csrIPs[i] = ip.String()
+	}
+	csrIPs = uniqueIPs(csrIPs)
+	orderIPs = uniqueIPs(orderIPs)
+	if len(csrIPs) != len(orderIPs) {
+		return nil, BadCSRErr(errors.Errorf("CSR IP addresses do not match identifiers exactly: CSR IPs = %v, Order IPs = %v", csrIPs, orderIPs))
+	}
+	for i := range csrIPs {
+		if csrIPs[i] != orderIPs[i] {
+			return nil, BadCSRErr(errors.Errorf("CSR IP addresses do not match identifiers exactly: CSR IPs = %v, Order IPs = %v", csrIPs, orderIPs))
+		}
+	}
+
 	// Get authorizations from the ACME provisioner.
 	ctx := provisioner.NewContextWithMethod(context.Background(), provisioner.SignMethod)
 	signOps, err := p.AuthorizeSign(ctx, "")
@@ -286,6 +312,10 @@ func (o *order) finalize(db nosql.DB, csr *x509.CertificateRequest, auth SignAut
 		return nil, ServerInternalErr(errors.Wrapf(err, "error retrieving authorization options from ACME provisioner"))
 	}
 
+	// The templates of the provisioner can modify the names of the certificate,
+	// the issued certificate must still contain only the order identifiers.
+	signOps = append(signOps, &identifiersValidator{dnsNames: orderNames, ips: orderIPs})
+
 	// Create and store a new certificate.
 	certChain, err := auth.Sign(csr, provisioner.Options{
 		NotBefore: provisioner.NewTimeDuration(o.NotBefore),
@@ -315,6 +345,52 @@ func (o *order) finalize(db nosql.DB, csr *x509.CertificateRequest, auth SignAut
 	return newOrder, nil
 }
 
+// identifiersValidator is a provisioner.CertificateValidator that validates
+// that the names of the certificate, after applying the provisioner templates,
+// are exactly the identifiers of the order.
+type identifiersValidator struct {
+	dnsNames []string
+	ips      []string
+}
+
+// Valid implements the provisioner.CertificateValidator interface.
+func (v *identifiersValidator) Valid(cert *x509.Certificate, _ provisioner.Options) error {
+	dnsNames := append([]string{}, cert.DNSNames...)
+	ips := make([]string, len(cert.IPAddresses))
+	for i, ip := range cert.IPAddresses {
+		ips[i] = ip.String()
+	}
+	if cn := cert.Subject.CommonName; cn != "" {
+		if ip := net.ParseIP(cn); ip != nil {
+			ips = append(ips, ip.String())
+		} else {
+			dnsNames = append(dnsNames, cn)
+		}
+	}
+	dnsNames = uniqueLowerNames(dnsNames)
+	ips = uniqueIPs(ips)
+	if !equalNames(dnsNames, v.dnsNames) {
+		return errors.Errorf("certificate names do not match identifiers exactly: certificate names = %v, order names = %v", dnsNames, v.dnsNames)
+	}
+	if !equalNames(ips, v.ips) {
+		return errors.Errorf("certificate IP addresses do not match identifiers exactly: certificate IPs = %v, order IPs = %v", ips, v.ips)
+	}
+	return nil
+}
+
+// equalNames returns true if both sorted lists contain the same names.
+func equalNames(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
 // getOrder retrieves and unmarshals an ACME Order type from the database.
 func getOrder(db nosql.DB, id string) (*order, error) {
 	b, err := db.Get(orderTable, []byte(id))
@@ -354,6 +430,17 @@ func (o *order) toACME(db nosql.DB, dir *directory, p provisioner.Interface) (*O
 	return ao, nil
 }
 
+// uniqueIPs returns the sorted set of all unique IP addresses in the input
+// using their canonical form.
+func uniqueIPs(ips []string) []string {
+	for i, s := range ips {
+		if ip := net.ParseIP(s); ip != nil {
+			ips[i] = ip.String()
+		}
+	}
+	return uniqueLowerNames(ips)
+}
+
 // uniqueLowerNames returns the set of all unique names in the input after all
 // of them are lowercased. The returned names will be in their lowercased form
 // and sorted alphabetically.
@@ -6,11 +6,13 @@ import (
 	"crypto/x509/pkix"
 	"encoding/json"
 	"fmt"
+	"net"
 	"testing"
 	"time"
 
 	"github.com/pkg/errors"
 	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/authority"
 	"github.com/smallstep/certificates/authority/provisioner"
 	"github.com/smallstep/certificates/db"
 	"github.com/smallstep/nosql"
@@ -827,6 +829,7 @@ func TestOrderUpdateStatus(t *testing.T) {
 type mockSignAuth struct {
 	sign                func(csr *x509.CertificateRequest, signOpts provisioner.Options, extraOpts ...provisioner.SignOption) ([]*x509.Certificate, error)
 	loadProvisionerByID func(string) (provisioner.Interface, error)
+	revoke              func(context.Context, *authority.RevokeOptions) error
 	ret1, ret2          interface{}
 	err                 error
 }
@@ -847,6 +850,13 @@ func (m *mockSignAuth) LoadProvisionerByID(id string) (provisioner.Interface, er
 	return m.ret1.(provisioner.Interface), m.err
 }
 
+func (m *mockSignAuth) Revoke(ctx context.Context, opts *authority.RevokeOptions) error {
+	if m.revoke != nil {
+		return m.revoke(ctx, opts)
+	}
+	return m.err
+}
+
 func TestOrderFinalize(t *testing.T) {
 	prov := newProv()
 	type test struct {
@@ -987,6 +997,47 @@ func TestOrderFinalize(t *testing.T) {
 				err: BadCSRErr(errors.Errorf("CSR names do not match identifiers exactly")),
 			}
 		},
+		"fail/ready/csr-ips-match-error": func(t *testing.T) test {
+			o, err := newO()
+			assert.FatalError(t, err)
+			o.Status = StatusReady
+			o.Identifiers = []Identifier{
+				{Type: "dns", Value: "step.example.com"},
+				{Type: "ip", Value: "10.0.0.1"},
+			}
+
+			csr := &x509.CertificateRequest{
+				Subject: pkix.Name{
+					CommonName: "step.example.com",
+				},
+				IPAddresses: []net.IP{net.ParseIP("10.0.0.2")},
+			}
+			return test{
+				o:   o,
+				csr: csr,
+				err: BadCSRErr(errors.Errorf("CSR IP addresses do not match identifiers exactly")),
+			}
+		},
+		"fail/ready/csr-ips-missing": func(t *testing.T) test {
+			o, err := newO()
+			assert.FatalError(t, err)
+			o.Status = StatusReady
+			o.Identifiers = []Identifier{
+				{Type: "dns", Value: "step.example.com"},
+			}
+
+			csr := &x509.CertificateRequest{
+				Subject: pkix.Name{
+					CommonName: "step.example.com",
+				},
+				IPAddresses: []net.IP{net.ParseIP("10.0.0.1")},
+			}
+			return test{
+				o:   o,
+				csr: csr,
+				err: BadCSRErr(errors.Errorf("CSR IP addresses do not match identifiers exactly")),
+			}
+		},
 		"fail/ready/provisioner-auth-sign-error": func(t *testing.T) test {
 			o, err := newO()
 			assert.FatalError(t, err)
@@ -1137,7 +1188,11 @@ func TestOrderFinalize(t *testing.T) {
 				csr: csr,
 				sa: &mockSignAuth{
 					sign: func(csr *x509.CertificateRequest, pops provisioner.Options, signOps ...provisioner.SignOption) ([]*x509.Certificate, error) {
-						assert.Equals(t, len(signOps), 4)
+						assert.Equals(t, len(signOps), 5)
+						assert.Equals(t, signOps[4], &identifiersValidator{
+							dnsNames: []string{"acme.example.com", "step.example.com"},
+							ips:      []string{},
+						})
 						return []*x509.Certificate{crt, inter}, nil
 					},
 				},
@@ -1186,7 +1241,59 @@ func TestOrderFinalize(t *testing.T) {
 				csr: csr,
 				sa: &mockSignAuth{
 					sign: func(csr *x509.CertificateRequest, pops provisioner.Options, signOps ...provisioner.SignOption) ([]*x509.Certificate, error) {
-						assert.Equals(t, len(signOps), 4)
+						assert.Equals(t, len(signOps), 5)
+						return []*x509.Certificate{crt, inter}, nil
+					},
+				},
+				db: &db.MockNoSQLDB{
+					MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+						if count == 0 {
+							clone.Certificate = string(key)
+						}
+						count++
+						return nil, true, nil
+					},
+				},
+			}
+		},
+		"ok/ready/ip": func(t *testing.T) test {
+			o, err := newO()
+			assert.FatalError(t, err)
+			o.Status = StatusReady
+			o.Identifiers = []Identifier{
+				{Type: "ip", Value: "10.0.0.1"},
+				{Type: "ip", Value: "2001:DB8::1"},
+			}
+
+			csr := &x509.CertificateRequest{
+				Subject: pkix.Name{
+					CommonName: "10.0.0.1",
+				},
+				IPAddresses: []net.IP{net.ParseIP("2001:db8::1")},
+			}
+			crt := &x509.Certificate{
+				Subject: pkix.Name{
+					CommonName: "10.0.0.1",
+				},
+				IPAddresses: []net.IP{net.ParseIP("10.0.0.1"), net.ParseIP("2001:db8::1")},
+			}
+			inter := &x509.Certificate{
+				Subject: pkix.Name{
+					CommonName: "intermediate",
+				},
+			}
+
+			clone := *o
+			clone.Status = StatusValid
+			count := 0
+			return test{
+				o:   o,
+				res: &clone,
+				csr: csr,
+				sa: &mockSignAuth{
+					sign: func(csr *x509.CertificateRequest, pops provisioner.Options, signOps ...provisioner.SignOption) ([]*x509.Certificate, error) {
+						assert.Equals(t, len(csr.DNSNames), 0)
+						assert.Equals(t, len(csr.IPAddresses), 2)
 						return []*x509.Certificate{crt, inter}, nil
 					},
 				},
@@ -1233,7 +1340,7 @@ func TestOrderFinalize(t *testing.T) {
 				csr: csr,
 				sa: &mockSignAuth{
 					sign: func(csr *x509.CertificateRequest, pops provisioner.Options, signOps ...provisioner.SignOption) ([]*x509.Certificate, error) {
-						assert.Equals(t, len(signOps), 4)
+						assert.Equals(t, len(signOps), 5)
 						return []*x509.Certificate{crt, inter}, nil
 					},
 				},
@@ -1277,3 +1384,48 @@ func TestOrderFinalize(t *testing.T) {
 		})
 	}
 }
+
+func Test_identifiersValidator_Valid(t *testing.T) {
+	v := &identifiersValidator{
+		dnsNames: []string{"acme.example.com", "step.example.com"},
+		ips:      []string{"10.0.0.1"},
+	}
+	tests := map[string]struct {
+		cert *x509.Certificate
+		err  error
+	}{
+		"ok": {&x509.Certificate{
+			Subject:     pkix.Name{CommonName: "acme.example.com"},
+			DNSNames:    []string{"Step.example.com", "acme.example.com"},
+			IPAddresses: []net.IP{net.ParseIP("10.0.0.1")},
+		}, nil},
+		"ok/ip-common-name": {&x509.Certificate{
+			Subject:  pkix.Name{CommonName: "10.0.0.1"},
+			DNSNames: []string{"acme.example.com", "step.example.com"},
+		}, nil},
+		"fail/common-name": {&x509.Certificate{
+			Subject:     pkix.Name{CommonName: "foo.example.com"},
+			DNSNames:    []string{"acme.example.com", "step.example.com"},
+			IPAddresses: []net.IP{net.ParseIP("10.0.0.1")},
+		}, errors.New("certificate names do not match identifiers exactly: certificate names = [acme.example.com foo.example.com step.example.com], order names = [acme.example.com step.example.com]")},
+		"fail/dns": {&x509.Certificate{
+			DNSNames:    []string{"acme.example.com"},
+			IPAddresses: []net.IP{net.ParseIP("10.0.0.1")},
+		}, errors.New("certificate names do not match identifiers exactly: certificate names = [acme.example.com], order names = [acme.example.com step.example.com]")},
+		"fail/ip": {&x509.Certificate{
+			DNSNames:    []string{"acme.example.com", "step.example.com"},
+			IPAddresses: []net.IP{net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.2")},
+		}, errors.New("certificate IP addresses do not match identifiers exactly: certificate IPs = [10.0.0.1 10.0.0.2], order IPs = [10.0.0.1]")},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if err := v.Valid(tc.cert, provisioner.Options{}); err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.Equals(t, tc.err.Error(), err.Error())
+				}
+			} else {
+				assert.Nil(t, tc.err)
+			}
+		})
+	}
+}
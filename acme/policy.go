@@ -0,0 +1,24 @@
+package acme
+
+import "github.com/smallstep/certificates/authority/provisioner"
+
+// identifierPolicy is the interface implemented by provisioners that restrict
+// the identifiers that can be ordered.
+type identifierPolicy interface {
+	AuthorizeIdentifier(typ, value string) error
+}
+
+// authorizeIdentifiers returns a rejectedIdentifier error if the policy of the
+// provisioner does not allow one of the given identifiers.
+func authorizeIdentifiers(p provisioner.Interface, identifiers []Identifier) error {
+	policy, ok := p.(identifierPolicy)
+	if !ok {
+		return nil
+	}
+	for _, id := range identifiers {
+		if err := policy.AuthorizeIdentifier(id.Type, id.Value); err != nil {
+			return RejectedIdentifierErr(err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+package acme
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"math/rand"
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// maxCNAMEChain is the maximum number of CNAME records followed in a lookup.
+const maxCNAMEChain = 8
+
+// resolver is the DNS resolver used in the validation of the challenges of a
+// provisioner. If the provisioner does not configure any nameserver, the
+// system resolver is used.
+type resolver struct {
+	*net.Resolver
+	nameservers []string
+	network     string
+	followCNAME bool
+	timeout     time.Duration
+	next        uint32
+}
+
+// newResolver creates the resolver with the given options.
+func newResolver(opts *provisioner.ACMEResolver, timeout time.Duration) *resolver {
+	r := &resolver{
+		Resolver:    net.DefaultResolver,
+		nameservers: opts.GetNameservers(),
+		network:     opts.GetNetwork(),
+		timeout:     timeout,
+	}
+	if len(r.nameservers) == 0 {
+		return r
+	}
+	// CNAME records are followed by recursive resolvers, we only need to do
+	// it if we are querying authoritative nameservers.
+	r.followCNAME = opts.FollowCNAME
+	r.Resolver = &net.Resolver{
+		PreferGo: true,
+		Dial:     r.dial,
+	}
+	return r
+}
+
+// dial connects to the next configured nameserver using the given network.
+// The resolver uses udp, and retries with tcp if the answer is truncated, tcp
+// is only forced if it is the configured network.
+func (r *resolver) dial(ctx context.Context, network, address string) (net.Conn, error) {
+	if len(r.nameservers) > 0 {
+		i := atomic.AddUint32(&r.next, 1)
+		address = r.nameservers[int(i)%len(r.nameservers)]
+	}
+	if r.network == "tcp" {
+		network = "tcp"
+	}
+	d := net.Dialer{
+		Timeout: r.timeout,
+	}
+	return d.DialContext(ctx, network, address)
+}
+
+// dialer returns a dialer that uses the resolver.
+func (r *resolver) dialer() *net.Dialer {
+	return &net.Dialer{
+		Timeout:  r.timeout,
+		Resolver: r.Resolver,
+	}
+}
+
+// lookupTXT returns the TXT records of the given name.
+func (r *resolver) lookupTXT(name string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+	if r.followCNAME {
+		for i := 0; i < maxCNAMEChain; i++ {
+			cname, err := r.lookupCNAME(ctx, name)
+			if err != nil {
+				return nil, err
+			}
+			if cname == "" {
+				break
+			}
+			name = cname
+		}
+	}
+	return r.LookupTXT(ctx, name)
+}
+
+// lookupCNAME queries the CNAME record of the given name. It returns an empty
+// string if the name does not have a CNAME record.
+//
+// The standard library does not provide a method to query CNAME records
+// directly, net.LookupCNAME requires the name to have address records.
+func (r *resolver) lookupCNAME(ctx context.Context, name string) (string, error) {
+	if !strings.HasSuffix(name, ".") {
+		name += "."
+	}
+	n, err := dnsmessage.NewName(name)
+	if err != nil {
+		return "", errors.Wrapf(err, "error looking up CNAME for %s", name)
+	}
+	id := uint16(rand.Uint32())
+	req := dnsmessage.Message{
+		Header: dnsmessage.Header{ID: id, RecursionDesired: true},
+		Questions: []dnsmessage.Question{
+			{Name: n, Type: dnsmessage.TypeCNAME, Class: dnsmessage.ClassINET},
+		},
+	}
+	b, err := req.Pack()
+	if err != nil {
+		return "", errors.Wrapf(err, "error looking up CNAME for %s", name)
+	}
+
+	conn, err := r.dial(ctx, r.network, "")
+	if err != nil {
+		return "", errors.Wrapf(err, "error looking up CNAME for %s", name)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if b, err = dnsRoundTrip(conn, b); err != nil {
+		return "", errors.Wrapf(err, "error looking up CNAME for %s", name)
+	}
+
+	var res dnsmessage.Message
+	if err := res.Unpack(b); err != nil {
+		return "", errors.Wrapf(err, "error looking up CNAME for %s", name)
+	}
+	if res.ID != id || !res.Response {
+		return "", errors.Errorf("error looking up CNAME for %s: invalid response", name)
+	}
+	switch res.RCode {
+	case dnsmessage.RCodeSuccess, dnsmessage.RCodeNameError:
+	default:
+		return "", errors.Errorf("error looking up CNAME for %s: %s", name, res.RCode)
+	}
+	for _, a := range res.Answers {
+		if c, ok := a.Body.(*dnsmessage.CNAMEResource); ok && strings.EqualFold(a.Header.Name.String(), name) {
+			return c.CNAME.String(), nil
+		}
+	}
+	return "", nil
+}
+
+// dnsRoundTrip sends a DNS message and reads the response. Messages sent over
+// stream connections are prefixed with their length.
+func dnsRoundTrip(conn net.Conn, b []byte) ([]byte, error) {
+	if _, ok := conn.(net.PacketConn); ok {
+		if _, err := conn.Write(b); err != nil {
+			return nil, err
+		}
+		buf := make([]byte, 65535)
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	}
+
+	msg := make([]byte, 2+len(b))
+	binary.BigEndian.PutUint16(msg, uint16(len(b)))
+	copy(msg[2:], b)
+	if _, err := conn.Write(msg); err != nil {
+		return nil, err
+	}
+	var l [2]byte
+	if _, err := io.ReadFull(conn, l[:]); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, binary.BigEndian.Uint16(l[:]))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
@@ -0,0 +1,252 @@
+package acme
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// dnsAnswer returns the answer to the given query with the given CNAME and TXT
+// records.
+func dnsAnswer(b []byte, cnames map[string]string, txts map[string]string) ([]byte, bool) {
+	var req dnsmessage.Message
+	if err := req.Unpack(b); err != nil || len(req.Questions) != 1 {
+		return nil, false
+	}
+	q := req.Questions[0]
+	name := strings.ToLower(q.Name.String())
+	res := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: req.ID, Response: true, Authoritative: true},
+		Questions: req.Questions,
+	}
+	hdr := dnsmessage.ResourceHeader{Name: q.Name, Class: dnsmessage.ClassINET, TTL: 60}
+	if cname, ok := cnames[name]; ok {
+		hdr.Type = dnsmessage.TypeCNAME
+		res.Answers = append(res.Answers, dnsmessage.Resource{
+			Header: hdr,
+			Body:   &dnsmessage.CNAMEResource{CNAME: dnsmessage.MustNewName(cname)},
+		})
+	} else if txt, ok := txts[name]; ok && q.Type == dnsmessage.TypeTXT {
+		hdr.Type = dnsmessage.TypeTXT
+		res.Answers = append(res.Answers, dnsmessage.Resource{
+			Header: hdr,
+			Body:   &dnsmessage.TXTResource{TXT: []string{txt}},
+		})
+	} else if !ok {
+		res.RCode = dnsmessage.RCodeNameError
+	}
+	b, err := res.Pack()
+	if err != nil {
+		return nil, false
+	}
+	return b, true
+}
+
+// startDNSServer starts an udp dns server that answers with the given CNAME
+// and TXT records.
+func startDNSServer(t *testing.T, cnames map[string]string, txts map[string]string) (string, func()) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.FatalError(t, err)
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if b, ok := dnsAnswer(buf[:n], cnames, txts); ok {
+				conn.WriteTo(b, addr)
+			}
+		}
+	}()
+	return conn.LocalAddr().String(), func() {
+		conn.Close()
+	}
+}
+
+// startTruncatingDNSServer starts a dns server that answers with truncated
+// messages over udp, and with the given TXT records over tcp. It returns the
+// address and the number of tcp queries.
+func startTruncatingDNSServer(t *testing.T, txts map[string]string) (string, *int32, func()) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.FatalError(t, err)
+	conn, err := net.ListenPacket("udp", ln.Addr().String())
+	if err != nil {
+		ln.Close()
+		t.Skipf("cannot listen on udp %s: %v", ln.Addr(), err)
+	}
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			var req dnsmessage.Message
+			if err := req.Unpack(buf[:n]); err != nil {
+				continue
+			}
+			res := dnsmessage.Message{
+				Header:    dnsmessage.Header{ID: req.ID, Response: true, Truncated: true},
+				Questions: req.Questions,
+			}
+			if b, err := res.Pack(); err == nil {
+				conn.WriteTo(b, addr)
+			}
+		}
+	}()
+	var queries int32
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(&queries, 1)
+			go func(c net.Conn) {
+				defer c.Close()
+				var l [2]byte
+				if _, err := io.ReadFull(c, l[:]); err != nil {
+					return
+				}
+				buf := make([]byte, binary.BigEndian.Uint16(l[:]))
+				if _, err := io.ReadFull(c, buf); err != nil {
+					return
+				}
+				if b, ok := dnsAnswer(buf, nil, txts); ok {
+					msg := make([]byte, 2+len(b))
+					binary.BigEndian.PutUint16(msg, uint16(len(b)))
+					copy(msg[2:], b)
+					c.Write(msg)
+				}
+			}(c)
+		}
+	}()
+	return ln.Addr().String(), &queries, func() {
+		conn.Close()
+		ln.Close()
+	}
+}
+
+func TestNewResolver(t *testing.T) {
+	r := newResolver(nil, time.Second)
+	assert.Equals(t, net.DefaultResolver, r.Resolver)
+	assert.False(t, r.followCNAME)
+	assert.Equals(t, time.Second, r.dialer().Timeout)
+
+	r = newResolver(&provisioner.ACMEResolver{FollowCNAME: true}, time.Second)
+	assert.Equals(t, net.DefaultResolver, r.Resolver)
+	assert.False(t, r.followCNAME)
+
+	r = newResolver(&provisioner.ACMEResolver{Network: "tcp"}, time.Second)
+	assert.Equals(t, net.DefaultResolver, r.Resolver)
+
+	r = newResolver(&provisioner.ACMEResolver{
+		Nameservers: []string{"127.0.0.1"},
+		Network:     "tcp",
+		FollowCNAME: true,
+	}, time.Second)
+	assert.True(t, r.followCNAME)
+	assert.Equals(t, "tcp", r.network)
+	assert.Equals(t, []string{"127.0.0.1:53"}, r.nameservers)
+	assert.True(t, r.Resolver.PreferGo)
+}
+
+func TestResolver_lookupTXT(t *testing.T) {
+	addr, stop := startDNSServer(t, map[string]string{
+		"_acme-challenge.example.com.":   "_acme-challenge.delegated.net.",
+		"_acme-challenge.loop.com.":      "_acme-challenge.loop.com.",
+		"_acme-challenge.delegated.net.": "_acme-challenge.other.net.",
+	}, map[string]string{
+		"_acme-challenge.other.net.":  "other-token",
+		"_acme-challenge.direct.com.": "direct-token",
+	})
+	defer stop()
+
+	type test struct {
+		followCNAME bool
+		name        string
+		txt         []string
+		err         bool
+	}
+	tests := map[string]test{
+		"ok/direct":          {false, "_acme-challenge.direct.com", []string{"direct-token"}, false},
+		"ok/follow-direct":   {true, "_acme-challenge.direct.com", []string{"direct-token"}, false},
+		"ok/follow-cname":    {true, "_acme-challenge.example.com", []string{"other-token"}, false},
+		"fail/no-follow":     {false, "_acme-challenge.example.com", nil, true},
+		"fail/cname-loop":    {true, "_acme-challenge.loop.com", nil, true},
+		"fail/not-found":     {true, "_acme-challenge.missing.com", nil, true},
+		"ok/follow-absolute": {true, "_acme-challenge.example.com.", []string{"other-token"}, false},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			r := newResolver(&provisioner.ACMEResolver{
+				Nameservers: []string{addr},
+				FollowCNAME: tc.followCNAME,
+			}, 5*time.Second)
+			txt, err := r.lookupTXT(tc.name)
+			if tc.err {
+				assert.NotNil(t, err)
+			} else {
+				assert.FatalError(t, err)
+				assert.Equals(t, tc.txt, txt)
+			}
+		})
+	}
+}
+
+func TestResolver_dial(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.FatalError(t, err)
+	defer ln.Close()
+
+	type test struct {
+		network    string
+		dial       string
+		wantPacket bool
+	}
+	tests := map[string]test{
+		"ok/udp":           {"udp", "udp", true},
+		"ok/udp-retry-tcp": {"udp", "tcp", false},
+		"ok/tcp":           {"tcp", "tcp", false},
+		"ok/tcp-force":     {"tcp", "udp", false},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			r := newResolver(&provisioner.ACMEResolver{
+				Nameservers: []string{ln.Addr().String()},
+				Network:     tc.network,
+			}, time.Second)
+			conn, err := r.dial(context.Background(), tc.dial, "10.0.0.53:53")
+			assert.FatalError(t, err)
+			defer conn.Close()
+			_, ok := conn.(net.PacketConn)
+			assert.Equals(t, tc.wantPacket, ok)
+			assert.Equals(t, ln.Addr().String(), conn.RemoteAddr().String())
+		})
+	}
+}
+
+func TestResolver_lookupTXT_truncated(t *testing.T) {
+	addr, queries, stop := startTruncatingDNSServer(t, map[string]string{
+		"_acme-challenge.example.com.": "the-token",
+	})
+	defer stop()
+
+	r := newResolver(&provisioner.ACMEResolver{
+		Nameservers: []string{addr},
+	}, 5*time.Second)
+	txt, err := r.lookupTXT("_acme-challenge.example.com")
+	assert.FatalError(t, err)
+	assert.Equals(t, []string{"the-token"}, txt)
+	assert.True(t, atomic.LoadInt32(queries) > 0)
+}
@@ -0,0 +1,300 @@
+package acme
+
+import (
+	"crypto/tls"
+	"log"
+	"net/http"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/cli/jose"
+	"github.com/smallstep/nosql"
+)
+
+// validationJob is a challenge validation scheduled in a validationPool.
+type validationJob struct {
+	chID    string
+	jwk     *jose.JSONWebKey
+	attempt int
+}
+
+// validationPool is a pool of workers that validate the challenges of a
+// provisioner. Failed validations are retried with an exponential backoff
+// until the authorization of the challenge expires.
+type validationPool struct {
+	db         nosql.DB
+	config     *provisioner.ACMEValidation
+	jobs       chan *validationJob
+	stop       chan struct{}
+	wg         sync.WaitGroup
+	options    validateOptions
+	timeout    time.Duration
+	minBackoff time.Duration
+	maxBackoff time.Duration
+}
+
+// newValidationPool creates a new validation pool using the given options. The
+// workers must be started using the start method.
+func newValidationPool(db nosql.DB, v *provisioner.ACMEValidation) *validationPool {
+	timeout := v.GetTimeout()
+	res := newResolver(v.GetResolver(), timeout)
+	dialer := res.dialer()
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	client := &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+	}
+	return &validationPool{
+		db:     db,
+		config: v,
+		jobs:   make(chan *validationJob, v.GetWorkers()),
+		stop:   make(chan struct{}),
+		options: validateOptions{
+			httpGet:   client.Get,
+			lookupTxt: res.lookupTXT,
+			tlsDial: func(network, addr string, config *tls.Config) (*tls.Conn, error) {
+				return tls.DialWithDialer(dialer, network, addr, config)
+			},
+		},
+		timeout:    timeout,
+		minBackoff: v.GetMinBackoff(),
+		maxBackoff: v.GetMaxBackoff(),
+	}
+}
+
+// start starts the given number of workers.
+func (vp *validationPool) start(workers int) {
+	for i := 0; i < workers; i++ {
+		vp.wg.Add(1)
+		go func() {
+			defer vp.wg.Done()
+			for {
+				select {
+				case <-vp.stop:
+					return
+				case job := <-vp.jobs:
+					vp.process(job)
+				}
+			}
+		}()
+	}
+}
+
+// shutdown stops the workers. Pending retries are discarded.
+func (vp *validationPool) shutdown() {
+	close(vp.stop)
+	vp.wg.Wait()
+}
+
+// submit queues a validation job without blocking the caller.
+func (vp *validationPool) submit(job *validationJob) {
+	select {
+	case vp.jobs <- job:
+	default:
+		go func() {
+			select {
+			case vp.jobs <- job:
+			case <-vp.stop:
+			}
+		}()
+	}
+}
+
+// backoff returns the time to wait before the next attempt.
+func (vp *validationPool) backoff(attempt int) time.Duration {
+	d := vp.minBackoff
+	for i := 0; i < attempt && d < vp.maxBackoff; i++ {
+		d *= 2
+	}
+	if d > vp.maxBackoff {
+		return vp.maxBackoff
+	}
+	return d
+}
+
+// isStale returns true if a challenge in the processing state has not been
+// attempted for a long time, e.g. because the CA has been restarted.
+func (vp *validationPool) isStale(ch challenge) bool {
+	return clock.Now().After(ch.getRetryAfter().Add(2*vp.timeout + vp.maxBackoff))
+}
+
+// resume resubmits the validation of a stale challenge. The retry time is
+// updated first, so only one of the concurrent requests polling the challenge
+// resumes it.
+func (vp *validationPool) resume(ch challenge, jwk *jose.JSONWebKey) error {
+	upd := ch.clone()
+	upd.RetryAfter = clock.Now()
+	if err := upd.save(vp.db, ch); err != nil {
+		return err
+	}
+	vp.submit(&validationJob{chID: ch.getID(), jwk: jwk})
+	return nil
+}
+
+// process runs a validation job and logs the errors.
+func (vp *validationPool) process(job *validationJob) {
+	if err := vp.validate(job); err != nil {
+		log.Printf("error validating acme challenge %s: %v", job.chID, err)
+	}
+}
+
+// validate runs a validation attempt. If the validation fails, a new attempt
+// is scheduled if the authorization has not expired, if it has, the challenge
+// is marked as invalid.
+func (vp *validationPool) validate(job *validationJob) error {
+	ch, err := getChallenge(vp.db, job.chID)
+	if err != nil {
+		return err
+	}
+	if ch.getStatus() != StatusProcessing {
+		return nil
+	}
+	az, err := getAuthz(vp.db, ch.getAuthzID())
+	if err != nil {
+		return err
+	}
+
+	now := clock.Now()
+	if !now.Before(az.getExpiry()) {
+		return invalidateChallenge(vp.db, ch)
+	}
+
+	// Errors are stored in the challenge and retried.
+	_, validateErr := ch.validate(vp.db, job.jwk, vp.options)
+	if ch, err = getChallenge(vp.db, job.chID); err != nil {
+		return err
+	}
+	if ch.getStatus() != StatusProcessing {
+		return validateErr
+	}
+
+	d := vp.backoff(job.attempt)
+	next := now.Add(d)
+	if !next.Before(az.getExpiry()) {
+		if err := invalidateChallenge(vp.db, ch); err != nil {
+			return err
+		}
+		return validateErr
+	}
+
+	upd := ch.clone()
+	upd.RetryAfter = next
+	if err := upd.save(vp.db, ch); err != nil {
+		return err
+	}
+	job.attempt++
+	time.AfterFunc(d, func() {
+		vp.submit(job)
+	})
+	return validateErr
+}
+
+// invalidateChallenge marks a challenge as invalid, keeping the error of the
+// last validation attempt.
+func invalidateChallenge(db nosql.DB, ch challenge) error {
+	upd := ch.clone()
+	upd.Status = StatusInvalid
+	upd.RetryAfter = time.Time{}
+	if upd.Error == nil {
+		upd.Error = MalformedErr(errors.New("authz has expired")).ToACME()
+	}
+	return upd.save(db, ch)
+}
+
+// validationConfig returns the validation options of the given provisioner.
+func validationConfig(p provisioner.Interface) *provisioner.ACMEValidation {
+	if prov, ok := p.(*provisioner.ACME); ok {
+		return prov.Validation
+	}
+	return nil
+}
+
+// getValidationPool returns the validation pool of the given provisioner,
+// starting a new one if necessary. The pool is replaced if the validation
+// options of the provisioner have changed, and the pools of the provisioners
+// that have been removed are stopped. The validations discarded by a stopped
+// pool are resumed when the clients poll their authz.
+func (a *Authority) getValidationPool(p provisioner.Interface) *validationPool {
+	a.validationMutex.Lock()
+	defer a.validationMutex.Unlock()
+	a.removeValidationPools(p.GetID())
+	v := validationConfig(p)
+	if vp, ok := a.validationPools[p.GetID()]; ok {
+		if reflect.DeepEqual(vp.config, v) {
+			return vp
+		}
+		go vp.shutdown()
+	}
+	if a.validationPools == nil {
+		a.validationPools = make(map[string]*validationPool)
+	}
+	vp := newValidationPool(a.db, v)
+	vp.start(v.GetWorkers())
+	a.validationPools[p.GetID()] = vp
+	return vp
+}
+
+// removeValidationPools stops the validation pools of the provisioners that
+// cannot be loaded anymore, except the one with the given id. It must be
+// called with the validation mutex held.
+func (a *Authority) removeValidationPools(id string) {
+	for provID, vp := range a.validationPools {
+		if provID == id {
+			continue
+		}
+		if _, err := a.signAuth.LoadProvisionerByID(provID); err != nil {
+			go vp.shutdown()
+			delete(a.validationPools, provID)
+		}
+	}
+}
+
+// resumeValidations resumes the validation of the stale challenges of a
+// pending authz, e.g. validations interrupted by a restart of the CA. It's
+// called when clients poll the authz or its order, so errors are only logged.
+func (a *Authority) resumeValidations(p provisioner.Interface, az authz) {
+	if az.getStatus() != StatusPending {
+		return
+	}
+	var jwk *jose.JSONWebKey
+	for _, chID := range az.getChallenges() {
+		ch, err := getChallenge(a.db, chID)
+		if err != nil {
+			log.Printf("error resuming acme challenge %s: %v", chID, err)
+			continue
+		}
+		if ch.getStatus() != StatusProcessing {
+			continue
+		}
+		vp := a.getValidationPool(p)
+		if !vp.isStale(ch) {
+			continue
+		}
+		// The account key is only required to resume a validation.
+		if jwk == nil {
+			acc, err := getAccountByID(a.db, az.getAccountID())
+			if err != nil {
+				log.Printf("error resuming acme challenge %s: %v", chID, err)
+				return
+			}
+			jwk = acc.Key
+		}
+		if err := vp.resume(ch, jwk); err != nil {
+			log.Printf("error resuming acme challenge %s: %v", chID, err)
+		}
+	}
+}
+
+// Shutdown stops the challenge validation workers.
+func (a *Authority) Shutdown() {
+	a.validationMutex.Lock()
+	defer a.validationMutex.Unlock()
+	for _, vp := range a.validationPools {
+		vp.shutdown()
+	}
+	a.validationPools = nil
+}
@@ -0,0 +1,357 @@
+package acme
+
+import (
+	"bytes"
+	"crypto"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/db"
+	"github.com/smallstep/cli/jose"
+	"github.com/smallstep/nosql/database"
+)
+
+// newValidationDB returns a mock database that stores the values in a map.
+func newValidationDB(t *testing.T, values map[string]interface{}) (*db.MockNoSQLDB, map[string][]byte) {
+	store := make(map[string][]byte)
+	for k, v := range values {
+		b, err := json.Marshal(v)
+		assert.FatalError(t, err)
+		store[k] = b
+	}
+	return &db.MockNoSQLDB{
+		MGet: func(bucket, key []byte) ([]byte, error) {
+			if b, ok := store[string(bucket)+"/"+string(key)]; ok {
+				return b, nil
+			}
+			return nil, database.ErrNotFound
+		},
+		MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+			k := string(bucket) + "/" + string(key)
+			if !bytes.Equal(store[k], old) {
+				return store[k], false, nil
+			}
+			store[k] = newval
+			return newval, true, nil
+		},
+	}, store
+}
+
+func TestValidationPool_backoff(t *testing.T) {
+	vp := newValidationPool(nil, &provisioner.ACMEValidation{
+		MinBackoff: &provisioner.Duration{Duration: time.Second},
+		MaxBackoff: &provisioner.Duration{Duration: 10 * time.Second},
+	})
+	assert.Equals(t, time.Second, vp.backoff(0))
+	assert.Equals(t, 2*time.Second, vp.backoff(1))
+	assert.Equals(t, 4*time.Second, vp.backoff(2))
+	assert.Equals(t, 8*time.Second, vp.backoff(3))
+	assert.Equals(t, 10*time.Second, vp.backoff(4))
+	assert.Equals(t, 10*time.Second, vp.backoff(100))
+}
+
+func TestValidationPool_validate(t *testing.T) {
+	jwk, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
+	assert.FatalError(t, err)
+
+	newAuthzAndChallenge := func(t *testing.T, status string, expires time.Time) (*dnsAuthz, *http01Challenge) {
+		az, err := newAz()
+		assert.FatalError(t, err)
+		_az, ok := az.(*dnsAuthz)
+		assert.Fatal(t, ok)
+		_az.Expires = expires
+		ch, err := newHTTPCh()
+		assert.FatalError(t, err)
+		_ch, ok := ch.(*http01Challenge)
+		assert.Fatal(t, ok)
+		_ch.AuthzID = az.getID()
+		_ch.Status = status
+		return _az, _ch
+	}
+	key := func(bucket []byte, id string) string {
+		return string(bucket) + "/" + id
+	}
+	okGet := func(t *testing.T, ch challenge) httpGetter {
+		return func(url string) (*http.Response, error) {
+			keyAuth, err := KeyAuthorization(ch.getToken(), jwk)
+			assert.FatalError(t, err)
+			return &http.Response{
+				Body: ioutil.NopCloser(bytes.NewBufferString(keyAuth)),
+			}, nil
+		}
+	}
+	failGet := func(url string) (*http.Response, error) {
+		return nil, errors.New("force")
+	}
+
+	type test struct {
+		vp      *validationPool
+		store   map[string][]byte
+		ch      challenge
+		status  string
+		retry   bool
+		attempt int
+		err     error
+	}
+	tests := map[string]func(t *testing.T) test{
+		"fail/not-found": func(t *testing.T) test {
+			_, ch := newAuthzAndChallenge(t, StatusProcessing, clock.Now().Add(time.Hour))
+			mockdb, store := newValidationDB(t, nil)
+			return test{
+				vp:    newValidationPool(mockdb, nil),
+				store: store,
+				ch:    ch,
+				err:   errors.Errorf("challenge %s not found", ch.getID()),
+			}
+		},
+		"ok/not-processing": func(t *testing.T) test {
+			az, ch := newAuthzAndChallenge(t, StatusValid, clock.Now().Add(time.Hour))
+			mockdb, store := newValidationDB(t, map[string]interface{}{
+				key(challengeTable, ch.getID()): ch,
+				key(authzTable, az.getID()):     az,
+			})
+			return test{
+				vp:     newValidationPool(mockdb, nil),
+				store:  store,
+				ch:     ch,
+				status: StatusValid,
+			}
+		},
+		"ok/authz-expired": func(t *testing.T) test {
+			az, ch := newAuthzAndChallenge(t, StatusProcessing, clock.Now().Add(-time.Minute))
+			mockdb, store := newValidationDB(t, map[string]interface{}{
+				key(challengeTable, ch.getID()): ch,
+				key(authzTable, az.getID()):     az,
+			})
+			return test{
+				vp:     newValidationPool(mockdb, nil),
+				store:  store,
+				ch:     ch,
+				status: StatusInvalid,
+			}
+		},
+		"ok/valid": func(t *testing.T) test {
+			az, ch := newAuthzAndChallenge(t, StatusProcessing, clock.Now().Add(time.Hour))
+			mockdb, store := newValidationDB(t, map[string]interface{}{
+				key(challengeTable, ch.getID()): ch,
+				key(authzTable, az.getID()):     az,
+			})
+			vp := newValidationPool(mockdb, nil)
+			vp.options.httpGet = okGet(t, ch)
+			return test{
+				vp:     vp,
+				store:  store,
+				ch:     ch,
+				status: StatusValid,
+			}
+		},
+		"ok/retry": func(t *testing.T) test {
+			az, ch := newAuthzAndChallenge(t, StatusProcessing, clock.Now().Add(time.Hour))
+			mockdb, store := newValidationDB(t, map[string]interface{}{
+				key(challengeTable, ch.getID()): ch,
+				key(authzTable, az.getID()):     az,
+			})
+			vp := newValidationPool(mockdb, &provisioner.ACMEValidation{
+				MinBackoff: &provisioner.Duration{Duration: 30 * time.Minute},
+				MaxBackoff: &provisioner.Duration{Duration: 30 * time.Minute},
+			})
+			vp.options.httpGet = failGet
+			return test{
+				vp:      vp,
+				store:   store,
+				ch:      ch,
+				status:  StatusProcessing,
+				retry:   true,
+				attempt: 1,
+			}
+		},
+		"ok/retry-after-expiry": func(t *testing.T) test {
+			az, ch := newAuthzAndChallenge(t, StatusProcessing, clock.Now().Add(time.Minute))
+			mockdb, store := newValidationDB(t, map[string]interface{}{
+				key(challengeTable, ch.getID()): ch,
+				key(authzTable, az.getID()):     az,
+			})
+			vp := newValidationPool(mockdb, &provisioner.ACMEValidation{
+				MinBackoff: &provisioner.Duration{Duration: time.Hour},
+				MaxBackoff: &provisioner.Duration{Duration: time.Hour},
+			})
+			vp.options.httpGet = failGet
+			return test{
+				vp:     vp,
+				store:  store,
+				ch:     ch,
+				status: StatusInvalid,
+			}
+		},
+	}
+	for name, run := range tests {
+		t.Run(name, func(t *testing.T) {
+			tc := run(t)
+			defer tc.vp.shutdown()
+
+			job := &validationJob{chID: tc.ch.getID(), jwk: jwk}
+			if err := tc.vp.validate(job); err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+				return
+			}
+			assert.Nil(t, tc.err)
+			assert.Equals(t, tc.attempt, job.attempt)
+
+			ch, err := unmarshalChallenge(tc.store[key(challengeTable, tc.ch.getID())])
+			assert.FatalError(t, err)
+			assert.Equals(t, tc.status, ch.getStatus())
+			switch tc.status {
+			case StatusInvalid:
+				assert.NotNil(t, ch.getError())
+			case StatusProcessing:
+				assert.NotNil(t, ch.getError())
+				if tc.retry {
+					assert.True(t, ch.getRetryAfter().After(clock.Now()))
+				}
+			}
+		})
+	}
+}
+
+func TestAuthority_resumeValidations(t *testing.T) {
+	prov := newProv()
+	acc, err := newAcc()
+	assert.FatalError(t, err)
+
+	newAuthzAndChallenge := func(t *testing.T, azStatus, chStatus string, retryAfter time.Time) (*dnsAuthz, *http01Challenge) {
+		az, err := newAz()
+		assert.FatalError(t, err)
+		_az, ok := az.(*dnsAuthz)
+		assert.Fatal(t, ok)
+		ch, err := newHTTPCh()
+		assert.FatalError(t, err)
+		_ch, ok := ch.(*http01Challenge)
+		assert.Fatal(t, ok)
+		_az.AccountID = acc.ID
+		_az.Status = azStatus
+		_az.Challenges = []string{ch.getID()}
+		_ch.AccountID = acc.ID
+		_ch.AuthzID = az.getID()
+		_ch.Status = chStatus
+		_ch.RetryAfter = retryAfter
+		return _az, _ch
+	}
+	key := func(bucket []byte, id string) string {
+		return string(bucket) + "/" + id
+	}
+
+	stale := clock.Now().Add(-time.Hour)
+	tests := map[string]struct {
+		azStatus   string
+		chStatus   string
+		retryAfter time.Time
+		resumed    bool
+	}{
+		"ok/stale":             {StatusPending, StatusProcessing, stale, true},
+		"ok/recent":            {StatusPending, StatusProcessing, clock.Now(), false},
+		"ok/pending-challenge": {StatusPending, StatusPending, stale, false},
+		"ok/valid-authz":       {StatusValid, StatusProcessing, stale, false},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			az, ch := newAuthzAndChallenge(t, tc.azStatus, tc.chStatus, tc.retryAfter)
+			mockdb, store := newValidationDB(t, map[string]interface{}{
+				key(accountTable, acc.ID):       acc,
+				key(authzTable, az.getID()):     az,
+				key(challengeTable, ch.getID()): ch,
+			})
+			auth, err := NewAuthority(mockdb, "ca.smallstep.com", "acme", nil)
+			assert.FatalError(t, err)
+			// The workers are not started, jobs are kept in the queue.
+			vp := newValidationPool(mockdb, nil)
+			auth.validationPools = map[string]*validationPool{prov.GetID(): vp}
+
+			auth.resumeValidations(prov, az)
+			if !tc.resumed {
+				assert.Equals(t, 0, len(vp.jobs))
+				return
+			}
+
+			assert.Equals(t, 1, len(vp.jobs))
+			job := <-vp.jobs
+			assert.Equals(t, ch.getID(), job.chID)
+			want, err := acc.Key.Thumbprint(crypto.SHA256)
+			assert.FatalError(t, err)
+			got, err := job.jwk.Thumbprint(crypto.SHA256)
+			assert.FatalError(t, err)
+			assert.Equals(t, want, got)
+
+			upd, err := unmarshalChallenge(store[key(challengeTable, ch.getID())])
+			assert.FatalError(t, err)
+			assert.Equals(t, StatusProcessing, upd.getStatus())
+			assert.True(t, upd.getRetryAfter().After(tc.retryAfter))
+
+			// The resumed validation is not stale anymore.
+			auth.resumeValidations(prov, az)
+			assert.Equals(t, 0, len(vp.jobs))
+		})
+	}
+}
+
+func TestAuthority_getValidationPool(t *testing.T) {
+	newACMEProv := func(name string, v *provisioner.ACMEValidation) *provisioner.ACME {
+		return &provisioner.ACME{Type: "ACME", Name: name, Validation: v}
+	}
+	isStopped := func(vp *validationPool) bool {
+		select {
+		case <-vp.stop:
+			return true
+		case <-time.After(time.Second):
+			return false
+		}
+	}
+
+	removed := map[string]bool{}
+	auth, err := NewAuthority(&db.MockNoSQLDB{}, "ca.smallstep.com", "acme", &mockSignAuth{
+		loadProvisionerByID: func(id string) (provisioner.Interface, error) {
+			if removed[id] {
+				return nil, errors.Errorf("provisioner %s not found", id)
+			}
+			return nil, nil
+		},
+	})
+	assert.FatalError(t, err)
+	defer auth.Shutdown()
+
+	p1 := newACMEProv("p1", &provisioner.ACMEValidation{Workers: 1})
+	p2 := newACMEProv("p2", nil)
+	vp1 := auth.getValidationPool(p1)
+	vp2 := auth.getValidationPool(p2)
+	assert.Equals(t, 2, len(auth.validationPools))
+
+	// Same options, the provisioner might have been reloaded.
+	assert.True(t, vp1 == auth.getValidationPool(newACMEProv("p1", &provisioner.ACMEValidation{Workers: 1})))
+	assert.True(t, vp2 == auth.getValidationPool(p2))
+
+	// Updated options
+	updated := newACMEProv("p1", &provisioner.ACMEValidation{
+		Workers:  1,
+		Resolver: &provisioner.ACMEResolver{Nameservers: []string{"10.0.0.53"}},
+	})
+	vp := auth.getValidationPool(updated)
+	assert.False(t, vp1 == vp)
+	assert.Equals(t, updated.Validation, vp.config)
+	assert.True(t, isStopped(vp1))
+	assert.True(t, vp == auth.getValidationPool(updated))
+
+	// Removed provisioner
+	removed[p2.GetID()] = true
+	assert.True(t, vp == auth.getValidationPool(updated))
+	assert.True(t, isStopped(vp2))
+	assert.Equals(t, 1, len(auth.validationPools))
+	_, ok := auth.validationPools[p2.GetID()]
+	assert.False(t, ok)
+}
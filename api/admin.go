@@ -0,0 +1,238 @@
+package api
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/errs"
+)
+
+// AdminProvisionersResponse is the response object that returns the list of
+// provisioners managed with the admin API.
+type AdminProvisionersResponse struct {
+	Provisioners provisioner.List `json:"provisioners"`
+}
+
+// AdminResponse is the response object of the admin requests without a
+// resource.
+type AdminResponse struct {
+	Status string `json:"status"`
+}
+
+// AdminRolloverRequest is the request body to promote a new intermediate
+// certificate and key.
+type AdminRolloverRequest struct {
+	Certificate Certificate `json:"crt"`
+	Key         string      `json:"key"`
+}
+
+// Validate checks the fields of the AdminRolloverRequest.
+func (r *AdminRolloverRequest) Validate() error {
+	switch {
+	case r.Certificate.Certificate == nil:
+		return errs.BadRequest("missing crt")
+	case r.Key == "":
+		return errs.BadRequest("missing key")
+	default:
+		return nil
+	}
+}
+
+// AdminSSHKeyRequest is the request body to create a new SSH CA signing key.
+type AdminSSHKeyRequest struct {
+	Name string `json:"name"`
+}
+
+// Validate checks the fields of the AdminSSHKeyRequest.
+func (r *AdminSSHKeyRequest) Validate() error {
+	if r.Name == "" {
+		return errs.BadRequest("missing name")
+	}
+	return nil
+}
+
+// AdminSSHKeyResponse is the response object with the name of a new SSH CA
+// signing key in the KMS and its public key.
+type AdminSSHKeyResponse struct {
+	Name      string        `json:"name"`
+	PublicKey *SSHPublicKey `json:"publicKey"`
+}
+
+// authorizeAdmin authorizes an admin request using the bearer token in the
+// Authorization header, or the client certificate.
+func (h *caHandler) authorizeAdmin(r *http.Request) error {
+	var token string
+	if s := r.Header.Get("Authorization"); strings.HasPrefix(s, "Bearer ") {
+		token = strings.TrimSpace(strings.TrimPrefix(s, "Bearer "))
+	}
+	var cert *x509.Certificate
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		cert = r.TLS.PeerCertificates[0]
+	}
+	return h.Authority.AuthorizeAdmin(r.Context(), token, cert)
+}
+
+// adminProvisionerID returns the unescaped provisioner id in the url.
+func adminProvisionerID(r *http.Request) (string, error) {
+	id, err := url.PathUnescape(chi.URLParam(r, "id"))
+	if err != nil {
+		return "", errs.Wrap(http.StatusBadRequest, err, "error parsing provisioner id")
+	}
+	return id, nil
+}
+
+// AdminProvisioners is an HTTP handler that returns the provisioners managed
+// with the admin API.
+func (h *caHandler) AdminProvisioners(w http.ResponseWriter, r *http.Request) {
+	if err := h.authorizeAdmin(r); err != nil {
+		WriteError(w, err)
+		return
+	}
+	p, err := h.Authority.GetAdminProvisioners()
+	if err != nil {
+		WriteError(w, errs.InternalServerErr(err))
+		return
+	}
+	JSON(w, &AdminProvisionersResponse{
+		Provisioners: p,
+	})
+}
+
+// AdminProvisioner is an HTTP handler that returns a provisioner managed with
+// the admin API.
+func (h *caHandler) AdminProvisioner(w http.ResponseWriter, r *http.Request) {
+	if err := h.authorizeAdmin(r); err != nil {
+		WriteError(w, err)
+		return
+	}
+	id, err := adminProvisionerID(r)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	p, err := h.Authority.GetAdminProvisioner(id)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	JSON(w, p)
+}
+
+// CreateProvisioner is an HTTP handler that creates a new provisioner with
+// the JSON representation in the body.
+func (h *caHandler) CreateProvisioner(w http.ResponseWriter, r *http.Request) {
+	if err := h.authorizeAdmin(r); err != nil {
+		WriteError(w, err)
+		return
+	}
+	var body json.RawMessage
+	if err := ReadJSON(r.Body, &body); err != nil {
+		WriteError(w, errs.Wrap(http.StatusBadRequest, err, "error reading request body"))
+		return
+	}
+	p, err := h.Authority.CreateProvisioner(body)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	JSONStatus(w, p, http.StatusCreated)
+}
+
+// UpdateProvisioner is an HTTP handler that replaces a provisioner with the
+// JSON representation in the body.
+func (h *caHandler) UpdateProvisioner(w http.ResponseWriter, r *http.Request) {
+	if err := h.authorizeAdmin(r); err != nil {
+		WriteError(w, err)
+		return
+	}
+	id, err := adminProvisionerID(r)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	var body json.RawMessage
+	if err := ReadJSON(r.Body, &body); err != nil {
+		WriteError(w, errs.Wrap(http.StatusBadRequest, err, "error reading request body"))
+		return
+	}
+	p, err := h.Authority.UpdateProvisioner(id, body)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	JSON(w, p)
+}
+
+// DeleteProvisioner is an HTTP handler that deletes a provisioner.
+func (h *caHandler) DeleteProvisioner(w http.ResponseWriter, r *http.Request) {
+	if err := h.authorizeAdmin(r); err != nil {
+		WriteError(w, err)
+		return
+	}
+	id, err := adminProvisionerID(r)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	if err := h.Authority.DeleteProvisioner(id); err != nil {
+		WriteError(w, err)
+		return
+	}
+	JSON(w, &AdminResponse{Status: "ok"})
+}
+
+// RolloverIntermediate is an HTTP handler that promotes a new intermediate
+// certificate and key. The previous intermediate is retired, but the
+// certificates issued by it can still be renewed.
+func (h *caHandler) RolloverIntermediate(w http.ResponseWriter, r *http.Request) {
+	if err := h.authorizeAdmin(r); err != nil {
+		WriteError(w, err)
+		return
+	}
+	var body AdminRolloverRequest
+	if err := ReadJSON(r.Body, &body); err != nil {
+		WriteError(w, errs.Wrap(http.StatusBadRequest, err, "error reading request body"))
+		return
+	}
+	if err := body.Validate(); err != nil {
+		WriteError(w, err)
+		return
+	}
+	if err := h.Authority.RolloverIntermediate(body.Certificate.Certificate, body.Key); err != nil {
+		WriteError(w, err)
+		return
+	}
+	JSON(w, &AdminResponse{Status: "ok"})
+}
+
+// CreateSSHKey is an HTTP handler that creates a new key in the KMS to be used
+// in the rotation of the SSH CA signing keys.
+func (h *caHandler) CreateSSHKey(w http.ResponseWriter, r *http.Request) {
+	if err := h.authorizeAdmin(r); err != nil {
+		WriteError(w, err)
+		return
+	}
+	var body AdminSSHKeyRequest
+	if err := ReadJSON(r.Body, &body); err != nil {
+		WriteError(w, errs.Wrap(http.StatusBadRequest, err, "error reading request body"))
+		return
+	}
+	if err := body.Validate(); err != nil {
+		WriteError(w, err)
+		return
+	}
+	name, key, err := h.Authority.CreateSSHKey(body.Name)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	JSONStatus(w, &AdminSSHKeyResponse{
+		Name:      name,
+		PublicKey: &SSHPublicKey{PublicKey: key},
+	}, http.StatusCreated)
+}
@@ -0,0 +1,253 @@
+package api
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi"
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/errs"
+	"github.com/smallstep/cli/jose"
+	"golang.org/x/crypto/ssh"
+)
+
+func Test_caHandler_authorizeAdmin(t *testing.T) {
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "admin"}}
+	type test struct {
+		header string
+		tls    *tls.ConnectionState
+		token  string
+		cert   *x509.Certificate
+	}
+	tests := map[string]test{
+		"token":       {"Bearer the-token", nil, "the-token", nil},
+		"certificate": {"", &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}, "", cert},
+		"both": {"Bearer the-token", &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}},
+			"the-token", cert},
+		"basic":   {"Basic Zm9vOmJhcg==", nil, "", nil},
+		"missing": {"", &tls.ConnectionState{}, "", nil},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			h := &caHandler{Authority: &mockAuthority{
+				authorizeAdmin: func(ctx context.Context, token string, c *x509.Certificate) error {
+					assert.Equals(t, tc.token, token)
+					assert.Equals(t, tc.cert, c)
+					return nil
+				},
+			}}
+			req := httptest.NewRequest("GET", "http://example.com/admin/provisioners", nil)
+			if tc.header != "" {
+				req.Header.Set("Authorization", tc.header)
+			}
+			req.TLS = tc.tls
+			assert.FatalError(t, h.authorizeAdmin(req))
+		})
+	}
+}
+
+func Test_caHandler_Admin(t *testing.T) {
+	var key jose.JSONWebKey
+	assert.FatalError(t, json.Unmarshal([]byte(pubKey), &key))
+	prov := &provisioner.JWK{
+		Type: "JWK",
+		Name: "team",
+		Key:  &key,
+	}
+	id := prov.GetID()
+	escapedID := strings.Replace(id, ":", "%3A", -1)
+	body := `{"type":"JWK","name":"team"}`
+	crt := parseCertificate(rootPEM)
+	rolloverBody, err := json.Marshal(map[string]string{"crt": rootPEM, "key": "awskms:key-id=intermediate"})
+	assert.FatalError(t, err)
+	rolloverNoKeyBody, err := json.Marshal(map[string]string{"crt": rootPEM})
+	assert.FatalError(t, err)
+	sshKey, err := ssh.NewPublicKey(sshUserKey.Public())
+	assert.FatalError(t, err)
+	sshKeyBody := `{"name":"ssh-user-key-2"}`
+
+	authorized := func(ctx context.Context, token string, cert *x509.Certificate) error {
+		return nil
+	}
+	unauthorized := func(ctx context.Context, token string, cert *x509.Certificate) error {
+		return errs.Unauthorized("force")
+	}
+
+	type test struct {
+		method     string
+		path       string
+		body       string
+		auth       *mockAuthority
+		statusCode int
+		expected   interface{}
+	}
+	tests := map[string]test{
+		"ok/list": {"GET", "/admin/provisioners", "", &mockAuthority{
+			authorizeAdmin: authorized,
+			getAdminProvisioners: func() (provisioner.List, error) {
+				return provisioner.List{prov}, nil
+			},
+		}, http.StatusOK, &AdminProvisionersResponse{Provisioners: provisioner.List{prov}}},
+		"ok/get": {"GET", "/admin/provisioners/" + escapedID, "", &mockAuthority{
+			authorizeAdmin: authorized,
+			getAdminProvisioner: func(s string) (provisioner.Interface, error) {
+				assert.Equals(t, id, s)
+				return prov, nil
+			},
+		}, http.StatusOK, prov},
+		"ok/create": {"POST", "/admin/provisioners", body, &mockAuthority{
+			authorizeAdmin: authorized,
+			createProvisioner: func(data []byte) (provisioner.Interface, error) {
+				assert.Equals(t, body, string(data))
+				return prov, nil
+			},
+		}, http.StatusCreated, prov},
+		"ok/update": {"PUT", "/admin/provisioners/" + escapedID, body, &mockAuthority{
+			authorizeAdmin: authorized,
+			updateProvisioner: func(s string, data []byte) (provisioner.Interface, error) {
+				assert.Equals(t, id, s)
+				assert.Equals(t, body, string(data))
+				return prov, nil
+			},
+		}, http.StatusOK, prov},
+		"ok/delete": {"DELETE", "/admin/provisioners/" + escapedID, "", &mockAuthority{
+			authorizeAdmin: authorized,
+			deleteProvisioner: func(s string) error {
+				assert.Equals(t, id, s)
+				return nil
+			},
+		}, http.StatusOK, &AdminResponse{Status: "ok"}},
+		"ok/rollover": {"POST", "/admin/intermediates/rollover", string(rolloverBody), &mockAuthority{
+			authorizeAdmin: authorized,
+			rolloverIntermediate: func(c *x509.Certificate, key string) error {
+				assert.Equals(t, crt, c)
+				assert.Equals(t, "awskms:key-id=intermediate", key)
+				return nil
+			},
+		}, http.StatusOK, &AdminResponse{Status: "ok"}},
+		"ok/ssh-key": {"POST", "/admin/ssh/keys", sshKeyBody, &mockAuthority{
+			authorizeAdmin: authorized,
+			createSSHKey: func(name string) (string, ssh.PublicKey, error) {
+				assert.Equals(t, "ssh-user-key-2", name)
+				return "awskms:key-id=ssh-user-key-2", sshKey, nil
+			},
+		}, http.StatusCreated, &AdminSSHKeyResponse{
+			Name:      "awskms:key-id=ssh-user-key-2",
+			PublicKey: &SSHPublicKey{PublicKey: sshKey},
+		}},
+		"fail/list-unauthorized": {"GET", "/admin/provisioners", "", &mockAuthority{
+			authorizeAdmin: unauthorized,
+		}, http.StatusUnauthorized, nil},
+		"fail/get-unauthorized": {"GET", "/admin/provisioners/" + escapedID, "", &mockAuthority{
+			authorizeAdmin: unauthorized,
+		}, http.StatusUnauthorized, nil},
+		"fail/create-unauthorized": {"POST", "/admin/provisioners", body, &mockAuthority{
+			authorizeAdmin: unauthorized,
+		}, http.StatusUnauthorized, nil},
+		"fail/update-unauthorized": {"PUT", "/admin/provisioners/" + escapedID, body, &mockAuthority{
+			authorizeAdmin: unauthorized,
+		}, http.StatusUnauthorized, nil},
+		"fail/delete-unauthorized": {"DELETE", "/admin/provisioners/" + escapedID, "", &mockAuthority{
+			authorizeAdmin: unauthorized,
+		}, http.StatusUnauthorized, nil},
+		"fail/rollover-unauthorized": {"POST", "/admin/intermediates/rollover", string(rolloverBody), &mockAuthority{
+			authorizeAdmin: unauthorized,
+		}, http.StatusUnauthorized, nil},
+		"fail/ssh-key-unauthorized": {"POST", "/admin/ssh/keys", sshKeyBody, &mockAuthority{
+			authorizeAdmin: unauthorized,
+		}, http.StatusUnauthorized, nil},
+		"fail/not-enabled": {"GET", "/admin/provisioners", "", &mockAuthority{
+			authorizeAdmin: func(ctx context.Context, token string, cert *x509.Certificate) error {
+				return errs.NotImplemented("force")
+			},
+		}, http.StatusNotImplemented, nil},
+		"fail/get": {"GET", "/admin/provisioners/" + escapedID, "", &mockAuthority{
+			authorizeAdmin: authorized,
+			getAdminProvisioner: func(s string) (provisioner.Interface, error) {
+				return nil, errs.NotFound("force")
+			},
+		}, http.StatusNotFound, nil},
+		"fail/create-body": {"POST", "/admin/provisioners", "{", &mockAuthority{
+			authorizeAdmin: authorized,
+		}, http.StatusBadRequest, nil},
+		"fail/create": {"POST", "/admin/provisioners", body, &mockAuthority{
+			authorizeAdmin: authorized,
+			createProvisioner: func(data []byte) (provisioner.Interface, error) {
+				return nil, errs.BadRequest("force")
+			},
+		}, http.StatusBadRequest, nil},
+		"fail/update-body": {"PUT", "/admin/provisioners/" + escapedID, "", &mockAuthority{
+			authorizeAdmin: authorized,
+		}, http.StatusBadRequest, nil},
+		"fail/update": {"PUT", "/admin/provisioners/" + escapedID, body, &mockAuthority{
+			authorizeAdmin: authorized,
+			updateProvisioner: func(s string, data []byte) (provisioner.Interface, error) {
+				return nil, errs.Forbidden("force")
+			},
+		}, http.StatusForbidden, nil},
+		"fail/delete": {"DELETE", "/admin/provisioners/" + escapedID, "", &mockAuthority{
+			authorizeAdmin: authorized,
+			deleteProvisioner: func(s string) error {
+				return errs.Forbidden("force")
+			},
+		}, http.StatusForbidden, nil},
+		"fail/rollover-body": {"POST", "/admin/intermediates/rollover", "{", &mockAuthority{
+			authorizeAdmin: authorized,
+		}, http.StatusBadRequest, nil},
+		"fail/rollover-missing-crt": {"POST", "/admin/intermediates/rollover", `{"key":"intermediate"}`, &mockAuthority{
+			authorizeAdmin: authorized,
+		}, http.StatusBadRequest, nil},
+		"fail/rollover-missing-key": {"POST", "/admin/intermediates/rollover", string(rolloverNoKeyBody), &mockAuthority{
+			authorizeAdmin: authorized,
+		}, http.StatusBadRequest, nil},
+		"fail/rollover": {"POST", "/admin/intermediates/rollover", string(rolloverBody), &mockAuthority{
+			authorizeAdmin: authorized,
+			rolloverIntermediate: func(c *x509.Certificate, key string) error {
+				return errs.BadRequest("force")
+			},
+		}, http.StatusBadRequest, nil},
+		"fail/ssh-key-body": {"POST", "/admin/ssh/keys", "{", &mockAuthority{
+			authorizeAdmin: authorized,
+		}, http.StatusBadRequest, nil},
+		"fail/ssh-key-missing-name": {"POST", "/admin/ssh/keys", "{}", &mockAuthority{
+			authorizeAdmin: authorized,
+		}, http.StatusBadRequest, nil},
+		"fail/ssh-key": {"POST", "/admin/ssh/keys", sshKeyBody, &mockAuthority{
+			authorizeAdmin: authorized,
+			createSSHKey: func(name string) (string, ssh.PublicKey, error) {
+				return "", nil, errs.NotImplemented("force")
+			},
+		}, http.StatusNotImplemented, nil},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			r := chi.NewRouter()
+			New(tc.auth).Route(r)
+			req := httptest.NewRequest(tc.method, "http://example.com"+tc.path, strings.NewReader(tc.body))
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			res := w.Result()
+
+			assert.Equals(t, tc.statusCode, res.StatusCode)
+			assert.Equals(t, "application/json", res.Header.Get("Content-Type"))
+
+			b, err := ioutil.ReadAll(res.Body)
+			res.Body.Close()
+			assert.FatalError(t, err)
+			if tc.expected != nil {
+				expected, err := json.Marshal(tc.expected)
+				assert.FatalError(t, err)
+				assert.Equals(t, expected, b[:len(b)-1])
+			}
+		})
+	}
+}
@@ -23,6 +23,8 @@ import (
 	"github.com/smallstep/certificates/errs"
 	"github.com/smallstep/certificates/logging"
 	"github.com/smallstep/cli/crypto/tlsutil"
+	"golang.org/x/crypto/ocsp"
+	"golang.org/x/crypto/ssh"
 )
 
 // Authority is the interface implemented by a CA authority.
@@ -39,10 +41,22 @@ type Authority interface {
 	LoadProvisionerByID(string) (provisioner.Interface, error)
 	GetProvisioners(cursor string, limit int) (provisioner.List, string, error)
 	Revoke(context.Context, *authority.RevokeOptions) error
+	OCSP(req *ocsp.Request) ([]byte, error)
+	GetCertificateRevocationList(issuerID string) ([]byte, error)
 	GetEncryptedKey(kid string) (string, error)
 	GetRoots() (federation []*x509.Certificate, err error)
 	GetFederation() ([]*x509.Certificate, error)
 	Version() authority.Version
+	AuthorizeAdmin(ctx context.Context, token string, cert *x509.Certificate) error
+	GetAdminProvisioners() (provisioner.List, error)
+	GetAdminProvisioner(id string) (provisioner.Interface, error)
+	CreateProvisioner(data []byte) (provisioner.Interface, error)
+	UpdateProvisioner(id string, data []byte) (provisioner.Interface, error)
+	DeleteProvisioner(id string) error
+	RolloverIntermediate(crt *x509.Certificate, key string) error
+	CreateSSHKey(name string) (string, ssh.PublicKey, error)
+	StartDeviceAuthorization(ctx context.Context, name string) (*provisioner.DeviceAuthorization, error)
+	PollDeviceAuthorization(ctx context.Context, name, deviceCode string) (string, error)
 }
 
 // TimeDuration is an alias of provisioner.TimeDuration
@@ -250,14 +264,22 @@ func (h *caHandler) Route(r Router) {
 	r.MethodFunc("POST", "/sign", h.Sign)
 	r.MethodFunc("POST", "/renew", h.Renew)
 	r.MethodFunc("POST", "/revoke", h.Revoke)
+	r.MethodFunc("POST", "/ocsp", h.OCSP)
+	r.MethodFunc("GET", "/ocsp/*", h.OCSP)
+	r.MethodFunc("GET", "/crl", h.CRL)
+	r.MethodFunc("GET", "/crl/{id}", h.CRL)
 	r.MethodFunc("GET", "/provisioners", h.Provisioners)
 	r.MethodFunc("GET", "/provisioners/{kid}/encrypted-key", h.ProvisionerKey)
 	r.MethodFunc("GET", "/roots", h.Roots)
 	r.MethodFunc("GET", "/federation", h.Federation)
+	// OIDC device authorization grant
+	r.MethodFunc("POST", "/oidc/device/authorize", h.DeviceAuthorization)
+	r.MethodFunc("POST", "/oidc/device/token", h.DeviceToken)
 	// SSH CA
 	r.MethodFunc("POST", "/ssh/sign", h.SSHSign)
 	r.MethodFunc("POST", "/ssh/renew", h.SSHRenew)
 	r.MethodFunc("POST", "/ssh/revoke", h.SSHRevoke)
+	r.MethodFunc("GET", "/ssh/krl", h.SSHKRL)
 	r.MethodFunc("POST", "/ssh/rekey", h.SSHRekey)
 	r.MethodFunc("GET", "/ssh/roots", h.SSHRoots)
 	r.MethodFunc("GET", "/ssh/federation", h.SSHFederation)
@@ -266,6 +288,14 @@ func (h *caHandler) Route(r Router) {
 	r.MethodFunc("POST", "/ssh/check-host", h.SSHCheckHost)
 	r.MethodFunc("GET", "/ssh/hosts", h.SSHGetHosts)
 	r.MethodFunc("POST", "/ssh/bastion", h.SSHBastion)
+	// Admin API
+	r.MethodFunc("GET", "/admin/provisioners", h.AdminProvisioners)
+	r.MethodFunc("POST", "/admin/provisioners", h.CreateProvisioner)
+	r.MethodFunc("GET", "/admin/provisioners/{id}", h.AdminProvisioner)
+	r.MethodFunc("PUT", "/admin/provisioners/{id}", h.UpdateProvisioner)
+	r.MethodFunc("DELETE", "/admin/provisioners/{id}", h.DeleteProvisioner)
+	r.MethodFunc("POST", "/admin/intermediates/rollover", h.RolloverIntermediate)
+	r.MethodFunc("POST", "/admin/ssh/keys", h.CreateSSHKey)
 
 	// For compatibility with old code:
 	r.MethodFunc("POST", "/re-sign", h.Renew)
@@ -324,11 +354,26 @@ func (h *caHandler) Provisioners(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	JSON(w, &ProvisionersResponse{
-		Provisioners: p,
+		Provisioners: redactProvisioners(p),
 		NextCursor:   next,
 	})
 }
 
+// redactProvisioners removes the secrets that might be present in the
+// configuration of the provisioners, like the ACME external account keys.
+func redactProvisioners(list provisioner.List) provisioner.List {
+	ret := make(provisioner.List, len(list))
+	for i, p := range list {
+		if ap, ok := p.(*provisioner.ACME); ok && len(ap.EABKeys) > 0 {
+			c := *ap
+			c.EABKeys = nil
+			p = &c
+		}
+		ret[i] = p
+	}
+	return ret
+}
+
 // ProvisionerKey returns the encrypted key of a provisioner by it's key id.
 func (h *caHandler) ProvisionerKey(w http.ResponseWriter, r *http.Request) {
 	kid := chi.URLParam(r, "kid")
@@ -34,6 +34,7 @@ import (
 	"github.com/smallstep/certificates/templates"
 	"github.com/smallstep/cli/crypto/tlsutil"
 	"github.com/smallstep/cli/jose"
+	"golang.org/x/crypto/ocsp"
 	"golang.org/x/crypto/ssh"
 )
 
@@ -555,6 +556,8 @@ type mockAuthority struct {
 	loadProvisionerByID          func(provID string) (provisioner.Interface, error)
 	getProvisioners              func(nextCursor string, limit int) (provisioner.List, string, error)
 	revoke                       func(context.Context, *authority.RevokeOptions) error
+	ocsp                         func(req *ocsp.Request) ([]byte, error)
+	getCertificateRevocationList func(issuerID string) ([]byte, error)
 	getEncryptedKey              func(kid string) (string, error)
 	getRoots                     func() ([]*x509.Certificate, error)
 	getFederation                func() ([]*x509.Certificate, error)
@@ -568,7 +571,18 @@ type mockAuthority struct {
 	getSSHConfig                 func(ctx context.Context, typ string, data map[string]string) ([]templates.Output, error)
 	checkSSHHost                 func(ctx context.Context, principal, token string) (bool, error)
 	getSSHBastion                func(ctx context.Context, user string, hostname string) (*authority.Bastion, error)
+	getSSHKeyRevocationList      func(ctx context.Context) ([]byte, error)
 	version                      func() authority.Version
+	authorizeAdmin               func(ctx context.Context, token string, cert *x509.Certificate) error
+	getAdminProvisioners         func() (provisioner.List, error)
+	getAdminProvisioner          func(id string) (provisioner.Interface, error)
+	createProvisioner            func(data []byte) (provisioner.Interface, error)
+	updateProvisioner            func(id string, data []byte) (provisioner.Interface, error)
+	deleteProvisioner            func(id string) error
+	rolloverIntermediate         func(crt *x509.Certificate, key string) error
+	createSSHKey                 func(name string) (string, ssh.PublicKey, error)
+	startDeviceAuthorization     func(ctx context.Context, name string) (*provisioner.DeviceAuthorization, error)
+	pollDeviceAuthorization      func(ctx context.Context, name, deviceCode string) (string, error)
 }
 
 // TODO: remove once Authorize is deprecated.
@@ -639,6 +653,20 @@ func (m *mockAuthority) Revoke(ctx context.Context, opts *authority.RevokeOption
 	return m.err
 }
 
+func (m *mockAuthority) OCSP(req *ocsp.Request) ([]byte, error) {
+	if m.ocsp != nil {
+		return m.ocsp(req)
+	}
+	return m.ret1.([]byte), m.err
+}
+
+func (m *mockAuthority) GetCertificateRevocationList(issuerID string) ([]byte, error) {
+	if m.getCertificateRevocationList != nil {
+		return m.getCertificateRevocationList(issuerID)
+	}
+	return m.ret1.([]byte), m.err
+}
+
 func (m *mockAuthority) GetEncryptedKey(kid string) (string, error) {
 	if m.getEncryptedKey != nil {
 		return m.getEncryptedKey(kid)
@@ -730,6 +758,13 @@ func (m *mockAuthority) GetSSHBastion(ctx context.Context, user string, hostname
 	return m.ret1.(*authority.Bastion), m.err
 }
 
+func (m *mockAuthority) GetSSHKeyRevocationList(ctx context.Context) ([]byte, error) {
+	if m.getSSHKeyRevocationList != nil {
+		return m.getSSHKeyRevocationList(ctx)
+	}
+	return m.ret1.([]byte), m.err
+}
+
 func (m *mockAuthority) Version() authority.Version {
 	if m.version != nil {
 		return m.version()
@@ -737,6 +772,76 @@ func (m *mockAuthority) Version() authority.Version {
 	return m.ret1.(authority.Version)
 }
 
+func (m *mockAuthority) AuthorizeAdmin(ctx context.Context, token string, cert *x509.Certificate) error {
+	if m.authorizeAdmin != nil {
+		return m.authorizeAdmin(ctx, token, cert)
+	}
+	return m.err
+}
+
+func (m *mockAuthority) GetAdminProvisioners() (provisioner.List, error) {
+	if m.getAdminProvisioners != nil {
+		return m.getAdminProvisioners()
+	}
+	return m.ret1.(provisioner.List), m.err
+}
+
+func (m *mockAuthority) GetAdminProvisioner(id string) (provisioner.Interface, error) {
+	if m.getAdminProvisioner != nil {
+		return m.getAdminProvisioner(id)
+	}
+	return m.ret1.(provisioner.Interface), m.err
+}
+
+func (m *mockAuthority) CreateProvisioner(data []byte) (provisioner.Interface, error) {
+	if m.createProvisioner != nil {
+		return m.createProvisioner(data)
+	}
+	return m.ret1.(provisioner.Interface), m.err
+}
+
+func (m *mockAuthority) UpdateProvisioner(id string, data []byte) (provisioner.Interface, error) {
+	if m.updateProvisioner != nil {
+		return m.updateProvisioner(id, data)
+	}
+	return m.ret1.(provisioner.Interface), m.err
+}
+
+func (m *mockAuthority) DeleteProvisioner(id string) error {
+	if m.deleteProvisioner != nil {
+		return m.deleteProvisioner(id)
+	}
+	return m.err
+}
+
+func (m *mockAuthority) RolloverIntermediate(crt *x509.Certificate, key string) error {
+	if m.rolloverIntermediate != nil {
+		return m.rolloverIntermediate(crt, key)
+	}
+	return m.err
+}
+
+func (m *mockAuthority) CreateSSHKey(name string) (string, ssh.PublicKey, error) {
+	if m.createSSHKey != nil {
+		return m.createSSHKey(name)
+	}
+	return m.ret1.(string), m.ret2.(ssh.PublicKey), m.err
+}
+
+func (m *mockAuthority) StartDeviceAuthorization(ctx context.Context, name string) (*provisioner.DeviceAuthorization, error) {
+	if m.startDeviceAuthorization != nil {
+		return m.startDeviceAuthorization(ctx, name)
+	}
+	return m.ret1.(*provisioner.DeviceAuthorization), m.err
+}
+
+func (m *mockAuthority) PollDeviceAuthorization(ctx context.Context, name, deviceCode string) (string, error) {
+	if m.pollDeviceAuthorization != nil {
+		return m.pollDeviceAuthorization(ctx, name, deviceCode)
+	}
+	return m.ret1.(string), m.err
+}
+
 func Test_caHandler_Route(t *testing.T) {
 	type fields struct {
 		Authority Authority
@@ -1056,6 +1161,23 @@ func Test_caHandler_Provisioners(t *testing.T) {
 	}
 }
 
+func Test_redactProvisioners(t *testing.T) {
+	jwk := &provisioner.JWK{Type: "JWK", Name: "max", EncryptedKey: "abc"}
+	ap := &provisioner.ACME{Type: "ACME", Name: "acme", RequireEAB: true, EABKeys: map[string]string{
+		"kid": "c2VjcmV0",
+	}}
+	got := redactProvisioners(provisioner.List{jwk, ap})
+	assert.Len(t, 2, got)
+	assert.Equals(t, jwk, got[0])
+	if p, ok := got[1].(*provisioner.ACME); assert.True(t, ok) {
+		assert.Equals(t, "acme", p.Name)
+		assert.True(t, p.RequireEAB)
+		assert.Nil(t, p.EABKeys)
+	}
+	// The original provisioner must not be modified.
+	assert.Equals(t, map[string]string{"kid": "c2VjcmV0"}, ap.EABKeys)
+}
+
 func Test_caHandler_ProvisionerKey(t *testing.T) {
 	type fields struct {
 		Authority Authority
@@ -0,0 +1,42 @@
+package api
+
+import (
+	"encoding/pem"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi"
+	"github.com/smallstep/certificates/errs"
+)
+
+// CRL is an HTTP handler that returns the current certificate revocation list
+// of the intermediate with the id in the path, or the one of the active
+// intermediate if no id is given. The CRL is returned in DER format unless the
+// query parameter pem is set to true.
+func (h *caHandler) CRL(w http.ResponseWriter, r *http.Request) {
+	var usePEM bool
+	if v := r.URL.Query().Get("pem"); v != "" {
+		var err error
+		if usePEM, err = strconv.ParseBool(v); err != nil {
+			WriteError(w, errs.Wrap(http.StatusBadRequest, err, "error parsing pem query parameter"))
+			return
+		}
+	}
+
+	der, err := h.Authority.GetCertificateRevocationList(chi.URLParam(r, "id"))
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	if usePEM {
+		w.Header().Set("Content-Type", "application/x-pem-file")
+		w.WriteHeader(http.StatusOK)
+		pem.Encode(w, &pem.Block{Type: "X509 CRL", Bytes: der})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/pkix-crl")
+	w.WriteHeader(http.StatusOK)
+	w.Write(der)
+}
@@ -0,0 +1,112 @@
+package api
+
+import (
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi"
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/errs"
+)
+
+func Test_caHandler_CRL(t *testing.T) {
+	der := []byte("crl")
+	okAuthority := &mockAuthority{
+		getCertificateRevocationList: func(issuerID string) ([]byte, error) {
+			if issuerID != "" && issuerID != "abcd" {
+				return nil, errs.NotFound("not found")
+			}
+			return der, nil
+		},
+	}
+
+	type test struct {
+		path        string
+		auth        Authority
+		statusCode  int
+		contentType string
+		expected    []byte
+	}
+	tests := map[string]test{
+		"ok/der": {
+			path:        "/crl",
+			auth:        okAuthority,
+			statusCode:  http.StatusOK,
+			contentType: "application/pkix-crl",
+			expected:    der,
+		},
+		"ok/pem": {
+			path:        "/crl?pem=true",
+			auth:        okAuthority,
+			statusCode:  http.StatusOK,
+			contentType: "application/x-pem-file",
+			expected:    pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der}),
+		},
+		"ok/pem-false": {
+			path:        "/crl?pem=false",
+			auth:        okAuthority,
+			statusCode:  http.StatusOK,
+			contentType: "application/pkix-crl",
+			expected:    der,
+		},
+		"ok/issuer": {
+			path:        "/crl/abcd",
+			auth:        okAuthority,
+			statusCode:  http.StatusOK,
+			contentType: "application/pkix-crl",
+			expected:    der,
+		},
+		"ok/issuer-pem": {
+			path:        "/crl/abcd?pem=true",
+			auth:        okAuthority,
+			statusCode:  http.StatusOK,
+			contentType: "application/x-pem-file",
+			expected:    pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der}),
+		},
+		"fail/issuer-not-found": {
+			path:        "/crl/efgh",
+			auth:        okAuthority,
+			statusCode:  http.StatusNotFound,
+			contentType: "application/json",
+		},
+		"fail/pem": {
+			path:        "/crl?pem=foo",
+			auth:        okAuthority,
+			statusCode:  http.StatusBadRequest,
+			contentType: "application/json",
+		},
+		"fail/not-enabled": {
+			path: "/crl",
+			auth: &mockAuthority{
+				getCertificateRevocationList: func(issuerID string) ([]byte, error) {
+					return nil, errs.NotFound("force")
+				},
+			},
+			statusCode:  http.StatusNotFound,
+			contentType: "application/json",
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			r := chi.NewRouter()
+			New(tc.auth).Route(r)
+			req := httptest.NewRequest("GET", "http://example.com"+tc.path, nil)
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			res := w.Result()
+
+			assert.Equals(t, tc.statusCode, res.StatusCode)
+			assert.Equals(t, tc.contentType, res.Header.Get("Content-Type"))
+
+			body, err := ioutil.ReadAll(res.Body)
+			res.Body.Close()
+			assert.FatalError(t, err)
+			if tc.statusCode == http.StatusOK {
+				assert.Equals(t, tc.expected, body)
+			}
+		})
+	}
+}
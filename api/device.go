@@ -0,0 +1,166 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/errs"
+)
+
+// Status of a device authorization in a DeviceTokenResponse.
+const (
+	DeviceStatusPending  = "authorization_pending"
+	DeviceStatusSlowDown = "slow_down"
+	DeviceStatusComplete = "complete"
+)
+
+// DeviceAuthorizationRequest is the request body used to start an OAuth 2.0
+// device authorization grant with an OIDC provisioner.
+type DeviceAuthorizationRequest struct {
+	Provisioner string `json:"provisioner"`
+}
+
+// Validate checks the fields of the DeviceAuthorizationRequest.
+func (r *DeviceAuthorizationRequest) Validate() error {
+	if r.Provisioner == "" {
+		return errs.BadRequest("missing provisioner")
+	}
+	return nil
+}
+
+// DeviceAuthorizationResponse is the response object of a device authorization
+// request. The user must visit the verification uri and enter the user code,
+// and the client must poll the token endpoint using the device code every
+// interval seconds.
+type DeviceAuthorizationResponse struct {
+	DeviceCode              string `json:"deviceCode"`
+	UserCode                string `json:"userCode"`
+	VerificationURI         string `json:"verificationURI"`
+	VerificationURIComplete string `json:"verificationURIComplete,omitempty"`
+	ExpiresIn               int    `json:"expiresIn"`
+	Interval                int    `json:"interval"`
+}
+
+// DeviceTokenRequest is the request body used to poll a device authorization
+// grant. Once the user completes the authorization, the ID token is used as
+// the one-time-token of the sign or sshSign requests; the ott in those
+// requests is ignored.
+type DeviceTokenRequest struct {
+	Provisioner string          `json:"provisioner"`
+	DeviceCode  string          `json:"deviceCode"`
+	Sign        *SignRequest    `json:"sign,omitempty"`
+	SSHSign     *SSHSignRequest `json:"sshSign,omitempty"`
+}
+
+// Validate checks the fields of the DeviceTokenRequest.
+func (r *DeviceTokenRequest) Validate() error {
+	switch {
+	case r.Provisioner == "":
+		return errs.BadRequest("missing provisioner")
+	case r.DeviceCode == "":
+		return errs.BadRequest("missing deviceCode")
+	case r.Sign == nil && r.SSHSign == nil:
+		return errs.BadRequest("missing sign or sshSign")
+	case r.Sign != nil && r.SSHSign != nil:
+		return errs.BadRequest("sign and sshSign cannot be used together")
+	}
+
+	// The ott is not known yet, validate the rest of the fields before
+	// polling the authorization.
+	if r.Sign != nil {
+		req := *r.Sign
+		req.OTT = r.DeviceCode
+		return req.Validate()
+	}
+	req := *r.SSHSign
+	req.OTT = r.DeviceCode
+	if err := req.Validate(); err != nil {
+		return errs.BadRequestErr(err)
+	}
+	return nil
+}
+
+// DeviceTokenResponse is the response object of a device token request. While
+// the authorization is pending, only the status is set, and the client must
+// poll again.
+type DeviceTokenResponse struct {
+	Status  string           `json:"status"`
+	Sign    *SignResponse    `json:"sign,omitempty"`
+	SSHSign *SSHSignResponse `json:"sshSign,omitempty"`
+}
+
+// DeviceAuthorization is an HTTP handler that starts an OAuth 2.0 device
+// authorization grant with the identity provider of an OIDC provisioner.
+func (h *caHandler) DeviceAuthorization(w http.ResponseWriter, r *http.Request) {
+	var body DeviceAuthorizationRequest
+	if err := ReadJSON(r.Body, &body); err != nil {
+		WriteError(w, errs.Wrap(http.StatusBadRequest, err, "error reading request body"))
+		return
+	}
+	if err := body.Validate(); err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	da, err := h.Authority.StartDeviceAuthorization(r.Context(), body.Provisioner)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	JSON(w, &DeviceAuthorizationResponse{
+		DeviceCode:              da.DeviceCode,
+		UserCode:                da.UserCode,
+		VerificationURI:         da.VerificationURI,
+		VerificationURIComplete: da.VerificationURIComplete,
+		ExpiresIn:               da.ExpiresIn,
+		Interval:                da.Interval,
+	})
+}
+
+// DeviceToken is an HTTP handler that polls a device authorization grant and,
+// once the user has completed it, signs an X.509 or SSH certificate using the
+// ID token returned by the identity provider.
+func (h *caHandler) DeviceToken(w http.ResponseWriter, r *http.Request) {
+	var body DeviceTokenRequest
+	if err := ReadJSON(r.Body, &body); err != nil {
+		WriteError(w, errs.Wrap(http.StatusBadRequest, err, "error reading request body"))
+		return
+	}
+	if err := body.Validate(); err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	token, err := h.Authority.PollDeviceAuthorization(r.Context(), body.Provisioner, body.DeviceCode)
+	switch err {
+	case nil:
+	case provisioner.ErrAuthorizationPending:
+		JSONStatus(w, &DeviceTokenResponse{Status: DeviceStatusPending}, http.StatusAccepted)
+		return
+	case provisioner.ErrSlowDown:
+		JSONStatus(w, &DeviceTokenResponse{Status: DeviceStatusSlowDown}, http.StatusAccepted)
+		return
+	default:
+		WriteError(w, err)
+		return
+	}
+
+	logOtt(w, token)
+	resp := &DeviceTokenResponse{Status: DeviceStatusComplete}
+	if body.Sign != nil {
+		body.Sign.OTT = token
+		if resp.Sign, err = h.signCertificate(body.Sign); err != nil {
+			WriteError(w, err)
+			return
+		}
+		logCertificate(w, resp.Sign.ServerPEM.Certificate)
+	} else {
+		body.SSHSign.OTT = token
+		if resp.SSHSign, err = h.signSSHCertificate(r.Context(), body.SSHSign); err != nil {
+			WriteError(w, err)
+			return
+		}
+	}
+	JSONStatus(w, resp, http.StatusCreated)
+}
@@ -0,0 +1,216 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/errs"
+	"github.com/smallstep/certificates/logging"
+	"github.com/smallstep/cli/crypto/tlsutil"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestDeviceTokenRequest_Validate(t *testing.T) {
+	csr := parseCertificateRequest(csrPEM)
+	user, err := getSignedUserCertificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		req     DeviceTokenRequest
+		wantErr bool
+	}{
+		{"ok-sign", DeviceTokenRequest{Provisioner: "oidc", DeviceCode: "code", Sign: &SignRequest{CsrPEM: CertificateRequest{csr}}}, false},
+		{"ok-sshSign", DeviceTokenRequest{Provisioner: "oidc", DeviceCode: "code", SSHSign: &SSHSignRequest{PublicKey: user.Key.Marshal()}}, false},
+		{"fail-provisioner", DeviceTokenRequest{DeviceCode: "code", Sign: &SignRequest{CsrPEM: CertificateRequest{csr}}}, true},
+		{"fail-deviceCode", DeviceTokenRequest{Provisioner: "oidc", Sign: &SignRequest{CsrPEM: CertificateRequest{csr}}}, true},
+		{"fail-missing", DeviceTokenRequest{Provisioner: "oidc", DeviceCode: "code"}, true},
+		{"fail-both", DeviceTokenRequest{Provisioner: "oidc", DeviceCode: "code", Sign: &SignRequest{CsrPEM: CertificateRequest{csr}}, SSHSign: &SSHSignRequest{PublicKey: user.Key.Marshal()}}, true},
+		{"fail-csr", DeviceTokenRequest{Provisioner: "oidc", DeviceCode: "code", Sign: &SignRequest{}}, true},
+		{"fail-publicKey", DeviceTokenRequest{Provisioner: "oidc", DeviceCode: "code", SSHSign: &SSHSignRequest{}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.req.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("DeviceTokenRequest.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_caHandler_DeviceAuthorization(t *testing.T) {
+	da := &provisioner.DeviceAuthorization{
+		DeviceCode:      "device-code",
+		UserCode:        "ABCD-EFGH",
+		VerificationURI: "https://idp.example.com/device",
+		ExpiresIn:       600,
+		Interval:        5,
+	}
+	expected := []byte(`{"deviceCode":"device-code","userCode":"ABCD-EFGH","verificationURI":"https://idp.example.com/device","expiresIn":600,"interval":5}`)
+
+	tests := []struct {
+		name       string
+		req        string
+		da         *provisioner.DeviceAuthorization
+		err        error
+		body       []byte
+		statusCode int
+	}{
+		{"ok", `{"provisioner":"oidc"}`, da, nil, expected, http.StatusOK},
+		{"fail-body", `{`, nil, nil, nil, http.StatusBadRequest},
+		{"fail-validate", `{}`, nil, nil, nil, http.StatusBadRequest},
+		{"fail-notFound", `{"provisioner":"oidc"}`, nil, errs.NotFound("not found"), nil, http.StatusNotFound},
+		{"fail-disabled", `{"provisioner":"oidc"}`, nil, errs.Unauthorized("disabled"), nil, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := New(&mockAuthority{
+				startDeviceAuthorization: func(ctx context.Context, name string) (*provisioner.DeviceAuthorization, error) {
+					if name != "oidc" {
+						t.Errorf("caHandler.DeviceAuthorization provisioner = %s, wants oidc", name)
+					}
+					return tt.da, tt.err
+				},
+			}).(*caHandler)
+
+			req := httptest.NewRequest("POST", "http://example.com/oidc/device/authorize", strings.NewReader(tt.req))
+			w := httptest.NewRecorder()
+			h.DeviceAuthorization(logging.NewResponseLogger(w), req)
+			res := w.Result()
+
+			if res.StatusCode != tt.statusCode {
+				t.Errorf("caHandler.DeviceAuthorization StatusCode = %d, wants %d", res.StatusCode, tt.statusCode)
+			}
+
+			body, err := ioutil.ReadAll(res.Body)
+			res.Body.Close()
+			if err != nil {
+				t.Errorf("caHandler.DeviceAuthorization unexpected error = %v", err)
+			}
+			if tt.statusCode < http.StatusBadRequest {
+				if !bytes.Equal(bytes.TrimSpace(body), tt.body) {
+					t.Errorf("caHandler.DeviceAuthorization Body = %s, wants %s", body, tt.body)
+				}
+			}
+		})
+	}
+}
+
+func Test_caHandler_DeviceToken(t *testing.T) {
+	csr := parseCertificateRequest(csrPEM)
+	user, err := getSignedUserCertificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	userB64 := base64.StdEncoding.EncodeToString(user.Marshal())
+
+	signReq, err := json.Marshal(DeviceTokenRequest{
+		Provisioner: "oidc",
+		DeviceCode:  "device-code",
+		Sign:        &SignRequest{CsrPEM: CertificateRequest{csr}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sshSignReq, err := json.Marshal(DeviceTokenRequest{
+		Provisioner: "oidc",
+		DeviceCode:  "device-code",
+		SSHSign:     &SSHSignRequest{PublicKey: user.Key.Marshal()},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedSign := []byte(`{"status":"complete","sign":{"crt":"` + strings.Replace(certPEM, "\n", `\n`, -1) + `\n","ca":"` + strings.Replace(rootPEM, "\n", `\n`, -1) + `\n","certChain":["` + strings.Replace(certPEM, "\n", `\n`, -1) + `\n","` + strings.Replace(rootPEM, "\n", `\n`, -1) + `\n"]}}`)
+	expectedSSHSign := []byte(fmt.Sprintf(`{"status":"complete","sshSign":{"crt":"%s"}}`, userB64))
+
+	tests := []struct {
+		name       string
+		req        []byte
+		pollErr    error
+		authErr    error
+		signErr    error
+		body       []byte
+		statusCode int
+	}{
+		{"ok-sign", signReq, nil, nil, nil, expectedSign, http.StatusCreated},
+		{"ok-sshSign", sshSignReq, nil, nil, nil, expectedSSHSign, http.StatusCreated},
+		{"ok-pending", signReq, provisioner.ErrAuthorizationPending, nil, nil, []byte(`{"status":"authorization_pending"}`), http.StatusAccepted},
+		{"ok-slowDown", sshSignReq, provisioner.ErrSlowDown, nil, nil, []byte(`{"status":"slow_down"}`), http.StatusAccepted},
+		{"fail-body", []byte("{"), nil, nil, nil, nil, http.StatusBadRequest},
+		{"fail-validate", []byte(`{"provisioner":"oidc","deviceCode":"device-code"}`), nil, nil, nil, nil, http.StatusBadRequest},
+		{"fail-poll", signReq, errs.Unauthorized("access_denied"), nil, nil, nil, http.StatusUnauthorized},
+		{"fail-authorize", signReq, nil, fmt.Errorf("an error"), nil, nil, http.StatusUnauthorized},
+		{"fail-sign", signReq, nil, nil, fmt.Errorf("an error"), nil, http.StatusForbidden},
+		{"fail-sshAuthorize", sshSignReq, nil, fmt.Errorf("an error"), nil, nil, http.StatusUnauthorized},
+		{"fail-signSSH", sshSignReq, nil, nil, fmt.Errorf("an error"), nil, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := New(&mockAuthority{
+				pollDeviceAuthorization: func(ctx context.Context, name, deviceCode string) (string, error) {
+					if name != "oidc" || deviceCode != "device-code" {
+						t.Errorf("caHandler.DeviceToken provisioner = %s, deviceCode = %s", name, deviceCode)
+					}
+					if tt.pollErr != nil {
+						return "", tt.pollErr
+					}
+					return "id-token", nil
+				},
+				authorizeSign: func(ott string) ([]provisioner.SignOption, error) {
+					if ott != "id-token" {
+						t.Errorf("caHandler.DeviceToken ott = %s, wants id-token", ott)
+					}
+					return []provisioner.SignOption{}, tt.authErr
+				},
+				sign: func(cr *x509.CertificateRequest, opts provisioner.Options, signOpts ...provisioner.SignOption) ([]*x509.Certificate, error) {
+					if tt.signErr != nil {
+						return nil, tt.signErr
+					}
+					return []*x509.Certificate{parseCertificate(certPEM), parseCertificate(rootPEM)}, nil
+				},
+				signSSH: func(ctx context.Context, key ssh.PublicKey, opts provisioner.SSHOptions, signOpts ...provisioner.SignOption) (*ssh.Certificate, error) {
+					if tt.signErr != nil {
+						return nil, tt.signErr
+					}
+					return user, nil
+				},
+				getTLSOptions: func() *tlsutil.TLSOptions {
+					return nil
+				},
+			}).(*caHandler)
+
+			req := httptest.NewRequest("POST", "http://example.com/oidc/device/token", bytes.NewReader(tt.req))
+			w := httptest.NewRecorder()
+			h.DeviceToken(logging.NewResponseLogger(w), req)
+			res := w.Result()
+
+			if res.StatusCode != tt.statusCode {
+				t.Errorf("caHandler.DeviceToken StatusCode = %d, wants %d", res.StatusCode, tt.statusCode)
+			}
+
+			body, err := ioutil.ReadAll(res.Body)
+			res.Body.Close()
+			if err != nil {
+				t.Errorf("caHandler.DeviceToken unexpected error = %v", err)
+			}
+			if tt.statusCode < http.StatusBadRequest {
+				if !bytes.Equal(bytes.TrimSpace(body), tt.body) {
+					t.Errorf("caHandler.DeviceToken Body = %s, wants %s", body, tt.body)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package api
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+
+	"github.com/go-chi/chi"
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/errs"
+	"github.com/smallstep/certificates/logging"
+	"golang.org/x/crypto/ocsp"
+)
+
+// maxOCSPRequestSize is the maximum size of an OCSP request body.
+const maxOCSPRequestSize = 10 * 1024
+
+// OCSP is an HTTP handler that implements an OCSP responder (RFC 6960). A POST
+// request must contain the DER encoded OCSP request in the body, while a GET
+// request must have it base64 and url encoded in the path.
+//
+// Following the RFC, errors are returned as unsigned OCSP responses with a 200
+// status code.
+func (h *caHandler) OCSP(w http.ResponseWriter, r *http.Request) {
+	var (
+		der []byte
+		err error
+	)
+	switch r.Method {
+	case http.MethodGet:
+		der, err = readOCSPRequestPath(chi.URLParam(r, "*"))
+	default:
+		der, err = ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxOCSPRequestSize))
+	}
+	if err != nil {
+		writeOCSPError(w, errs.Wrap(http.StatusBadRequest, err, "error reading ocsp request"))
+		return
+	}
+
+	req, err := ocsp.ParseRequest(der)
+	if err != nil {
+		writeOCSPError(w, errs.Wrap(http.StatusBadRequest, err, "error parsing ocsp request"))
+		return
+	}
+
+	b, err := h.Authority.OCSP(req)
+	if err != nil {
+		writeOCSPError(w, err)
+		return
+	}
+
+	logOCSP(w, req)
+	w.Header().Set("Content-Type", "application/ocsp-response")
+	w.WriteHeader(http.StatusOK)
+	w.Write(b)
+}
+
+// readOCSPRequestPath decodes the OCSP request sent in the path of a GET
+// request.
+func readOCSPRequestPath(s string) ([]byte, error) {
+	if s == "" {
+		return nil, errors.New("missing ocsp request")
+	}
+	s, err := url.PathUnescape(s)
+	if err != nil {
+		return nil, errors.Wrap(err, "error unescaping ocsp request")
+	}
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errors.Wrap(err, "error decoding ocsp request")
+	}
+	return b, nil
+}
+
+// writeOCSPError writes the OCSP error response that corresponds to the
+// status code of the given error.
+func writeOCSPError(w http.ResponseWriter, err error) {
+	LogError(w, err)
+
+	var b []byte
+	sc, ok := err.(errs.StatusCoder)
+	if !ok {
+		sc, ok = errors.Cause(err).(errs.StatusCoder)
+	}
+	switch {
+	case !ok:
+		b = ocsp.InternalErrorErrorResponse
+	case sc.StatusCode() == http.StatusBadRequest:
+		b = ocsp.MalformedRequestErrorResponse
+	case sc.StatusCode() == http.StatusUnauthorized || sc.StatusCode() == http.StatusForbidden:
+		b = ocsp.UnauthorizedErrorResponse
+	default:
+		b = ocsp.InternalErrorErrorResponse
+	}
+
+	w.Header().Set("Content-Type", "application/ocsp-response")
+	w.WriteHeader(http.StatusOK)
+	w.Write(b)
+}
+
+func logOCSP(w http.ResponseWriter, req *ocsp.Request) {
+	if rl, ok := w.(logging.ResponseLogger); ok {
+		rl.WithFields(map[string]interface{}{
+			"serial": req.SerialNumber.String(),
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package api
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/go-chi/chi"
+	"github.com/pkg/errors"
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/errs"
+	"golang.org/x/crypto/ocsp"
+)
+
+func Test_caHandler_OCSP(t *testing.T) {
+	crt := parseCertificate(certPEM)
+	issuer := parseCertificate(rootPEM)
+	der, err := ocsp.CreateRequest(crt, issuer, nil)
+	assert.FatalError(t, err)
+
+	okAuthority := &mockAuthority{
+		ocsp: func(req *ocsp.Request) ([]byte, error) {
+			assert.Equals(t, crt.SerialNumber, req.SerialNumber)
+			return []byte("ocsp-response"), nil
+		},
+	}
+
+	type test struct {
+		method   string
+		path     string
+		body     []byte
+		auth     Authority
+		expected []byte
+	}
+	tests := map[string]test{
+		"ok/post": {
+			method:   "POST",
+			path:     "/ocsp",
+			body:     der,
+			auth:     okAuthority,
+			expected: []byte("ocsp-response"),
+		},
+		"ok/get": {
+			method:   "GET",
+			path:     "/ocsp/" + url.PathEscape(base64.StdEncoding.EncodeToString(der)),
+			auth:     okAuthority,
+			expected: []byte("ocsp-response"),
+		},
+		"fail/get-base64": {
+			method:   "GET",
+			path:     "/ocsp/foo",
+			auth:     okAuthority,
+			expected: ocsp.MalformedRequestErrorResponse,
+		},
+		"fail/post-malformed": {
+			method:   "POST",
+			path:     "/ocsp",
+			body:     []byte("foo"),
+			auth:     okAuthority,
+			expected: ocsp.MalformedRequestErrorResponse,
+		},
+		"fail/unauthorized": {
+			method: "POST",
+			path:   "/ocsp",
+			body:   der,
+			auth: &mockAuthority{
+				ocsp: func(req *ocsp.Request) ([]byte, error) {
+					return nil, errs.Unauthorized("force")
+				},
+			},
+			expected: ocsp.UnauthorizedErrorResponse,
+		},
+		"fail/internal": {
+			method: "POST",
+			path:   "/ocsp",
+			body:   der,
+			auth: &mockAuthority{
+				ocsp: func(req *ocsp.Request) ([]byte, error) {
+					return nil, errors.New("force")
+				},
+			},
+			expected: ocsp.InternalErrorErrorResponse,
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			r := chi.NewRouter()
+			New(tc.auth).Route(r)
+			req := httptest.NewRequest(tc.method, "http://example.com"+tc.path, bytes.NewReader(tc.body))
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			res := w.Result()
+
+			assert.Equals(t, http.StatusOK, res.StatusCode)
+			assert.Equals(t, "application/ocsp-response", res.Header.Get("Content-Type"))
+
+			body, err := ioutil.ReadAll(res.Body)
+			res.Body.Close()
+			assert.FatalError(t, err)
+			assert.Equals(t, tc.expected, body)
+		})
+	}
+}
@@ -58,6 +58,18 @@ func (h *caHandler) Sign(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	resp, err := h.signCertificate(&body)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	logCertificate(w, resp.ServerPEM.Certificate)
+	JSONStatus(w, resp, http.StatusCreated)
+}
+
+// signCertificate authorizes the given sign request and returns the signed
+// certificate chain.
+func (h *caHandler) signCertificate(body *SignRequest) (*SignResponse, error) {
 	opts := provisioner.Options{
 		NotBefore: body.NotBefore,
 		NotAfter:  body.NotAfter,
@@ -65,25 +77,22 @@ func (h *caHandler) Sign(w http.ResponseWriter, r *http.Request) {
 
 	signOpts, err := h.Authority.AuthorizeSign(body.OTT)
 	if err != nil {
-		WriteError(w, errs.UnauthorizedErr(err))
-		return
+		return nil, errs.UnauthorizedErr(err)
 	}
 
 	certChain, err := h.Authority.Sign(body.CsrPEM.CertificateRequest, opts, signOpts...)
 	if err != nil {
-		WriteError(w, errs.ForbiddenErr(err))
-		return
+		return nil, errs.ForbiddenErr(err)
 	}
 	certChainPEM := certChainToPEM(certChain)
 	var caPEM Certificate
 	if len(certChainPEM) > 1 {
 		caPEM = certChainPEM[1]
 	}
-	logCertificate(w, certChain[0])
-	JSONStatus(w, &SignResponse{
+	return &SignResponse{
 		ServerPEM:    certChainPEM[0],
 		CaPEM:        caPEM,
 		CertChainPEM: certChainPEM,
 		TLSOptions:   h.Authority.GetTLSOptions(),
-	}, http.StatusCreated)
+	}, nil
 }
@@ -29,6 +29,7 @@ type SSHAuthority interface {
 	CheckSSHHost(ctx context.Context, principal string, token string) (bool, error)
 	GetSSHHosts(ctx context.Context, cert *x509.Certificate) ([]sshutil.Host, error)
 	GetSSHBastion(ctx context.Context, user string, hostname string) (*authority.Bastion, error)
+	GetSSHKeyRevocationList(ctx context.Context) ([]byte, error)
 }
 
 // SSHSignRequest is the request body of an SSH certificate request.
@@ -259,18 +260,27 @@ func (h *caHandler) SSHSign(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	publicKey, err := ssh.ParsePublicKey(body.PublicKey)
+	resp, err := h.signSSHCertificate(r.Context(), &body)
 	if err != nil {
-		WriteError(w, errs.Wrap(http.StatusBadRequest, err, "error parsing publicKey"))
+		WriteError(w, err)
 		return
 	}
+	JSONStatus(w, resp, http.StatusCreated)
+}
+
+// signSSHCertificate authorizes the given SSH sign request and returns the
+// signed SSH certificates and the identity certificate if requested.
+func (h *caHandler) signSSHCertificate(ctx context.Context, body *SSHSignRequest) (*SSHSignResponse, error) {
+	publicKey, err := ssh.ParsePublicKey(body.PublicKey)
+	if err != nil {
+		return nil, errs.Wrap(http.StatusBadRequest, err, "error parsing publicKey")
+	}
 
 	var addUserPublicKey ssh.PublicKey
 	if body.AddUserPublicKey != nil {
 		addUserPublicKey, err = ssh.ParsePublicKey(body.AddUserPublicKey)
 		if err != nil {
-			WriteError(w, errs.Wrap(http.StatusBadRequest, err, "error parsing addUserPublicKey"))
-			return
+			return nil, errs.Wrap(http.StatusBadRequest, err, "error parsing addUserPublicKey")
 		}
 	}
 
@@ -282,25 +292,22 @@ func (h *caHandler) SSHSign(w http.ResponseWriter, r *http.Request) {
 		ValidAfter:  body.ValidAfter,
 	}
 
-	ctx := provisioner.NewContextWithMethod(r.Context(), provisioner.SSHSignMethod)
+	ctx = provisioner.NewContextWithMethod(ctx, provisioner.SSHSignMethod)
 	signOpts, err := h.Authority.Authorize(ctx, body.OTT)
 	if err != nil {
-		WriteError(w, errs.UnauthorizedErr(err))
-		return
+		return nil, errs.UnauthorizedErr(err)
 	}
 
 	cert, err := h.Authority.SignSSH(ctx, publicKey, opts, signOpts...)
 	if err != nil {
-		WriteError(w, errs.ForbiddenErr(err))
-		return
+		return nil, errs.ForbiddenErr(err)
 	}
 
 	var addUserCertificate *SSHCertificate
 	if addUserPublicKey != nil && cert.CertType == ssh.UserCert && len(cert.ValidPrincipals) == 1 {
 		addUserCert, err := h.Authority.SignSSHAddUser(ctx, addUserPublicKey, cert)
 		if err != nil {
-			WriteError(w, errs.ForbiddenErr(err))
-			return
+			return nil, errs.ForbiddenErr(err)
 		}
 		addUserCertificate = &SSHCertificate{addUserCert}
 	}
@@ -308,12 +315,11 @@ func (h *caHandler) SSHSign(w http.ResponseWriter, r *http.Request) {
 	// Sign identity certificate if available.
 	var identityCertificate []Certificate
 	if cr := body.IdentityCSR.CertificateRequest; cr != nil {
-		ctx := authority.NewContextWithSkipTokenReuse(r.Context())
+		ctx := authority.NewContextWithSkipTokenReuse(ctx)
 		ctx = provisioner.NewContextWithMethod(ctx, provisioner.SignMethod)
 		signOpts, err := h.Authority.Authorize(ctx, body.OTT)
 		if err != nil {
-			WriteError(w, errs.UnauthorizedErr(err))
-			return
+			return nil, errs.UnauthorizedErr(err)
 		}
 
 		// Enforce the same duration as ssh certificate.
@@ -324,17 +330,16 @@ func (h *caHandler) SSHSign(w http.ResponseWriter, r *http.Request) {
 
 		certChain, err := h.Authority.Sign(cr, provisioner.Options{}, signOpts...)
 		if err != nil {
-			WriteError(w, errs.ForbiddenErr(err))
-			return
+			return nil, errs.ForbiddenErr(err)
 		}
 		identityCertificate = certChainToPEM(certChain)
 	}
 
-	JSONStatus(w, &SSHSignResponse{
+	return &SSHSignResponse{
 		Certificate:         SSHCertificate{cert},
 		AddUserCertificate:  addUserCertificate,
 		IdentityCertificate: identityCertificate,
-	}, http.StatusCreated)
+	}, nil
 }
 
 // SSHRoots is an HTTP handler that returns the SSH public keys for user and host
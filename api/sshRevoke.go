@@ -22,6 +22,7 @@ type SSHRevokeRequest struct {
 	ReasonCode int    `json:"reasonCode"`
 	Reason     string `json:"reason"`
 	Passive    bool   `json:"passive"`
+	KeyID      string `json:"keyID,omitempty"`
 }
 
 // Validate checks the fields of the RevokeRequest and returns nil if they are ok
@@ -62,6 +63,7 @@ func (h *caHandler) SSHRevoke(w http.ResponseWriter, r *http.Request) {
 		Reason:      body.Reason,
 		ReasonCode:  body.ReasonCode,
 		PassiveOnly: body.Passive,
+		KeyID:       body.KeyID,
 	}
 
 	ctx := provisioner.NewContextWithMethod(r.Context(), provisioner.SSHRevokeMethod)
@@ -83,6 +85,20 @@ func (h *caHandler) SSHRevoke(w http.ResponseWriter, r *http.Request) {
 	JSON(w, &SSHRevokeResponse{Status: "ok"})
 }
 
+// SSHKRL is an HTTP handler that returns the OpenSSH key revocation list (KRL)
+// with the revoked SSH certificates.
+func (h *caHandler) SSHKRL(w http.ResponseWriter, r *http.Request) {
+	krl, err := h.Authority.GetSSHKeyRevocationList(r.Context())
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.WriteHeader(http.StatusOK)
+	w.Write(krl)
+}
+
 func logSSHRevoke(w http.ResponseWriter, ri *authority.RevokeOptions) {
 	if rl, ok := w.(logging.ResponseLogger); ok {
 		rl.WithFields(map[string]interface{}{
@@ -91,6 +107,7 @@ func logSSHRevoke(w http.ResponseWriter, ri *authority.RevokeOptions) {
 			"reason":      ri.Reason,
 			"passiveOnly": ri.PassiveOnly,
 			"mTLS":        ri.MTLS,
+			"keyID":       ri.KeyID,
 			"ssh":         true,
 		})
 	}
@@ -18,9 +18,11 @@ import (
 	"testing"
 	"time"
 
+	"github.com/go-chi/chi"
 	"github.com/smallstep/assert"
 	"github.com/smallstep/certificates/authority"
 	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/errs"
 	"github.com/smallstep/certificates/logging"
 	"github.com/smallstep/certificates/sshutil"
 	"github.com/smallstep/certificates/templates"
@@ -674,6 +676,52 @@ func Test_caHandler_SSHBastion(t *testing.T) {
 	}
 }
 
+func Test_caHandler_SSHKRL(t *testing.T) {
+	krl := []byte("SSHKRL\n\x00")
+	tests := []struct {
+		name        string
+		krl         []byte
+		err         error
+		statusCode  int
+		contentType string
+	}{
+		{"ok", krl, nil, http.StatusOK, "application/octet-stream"},
+		{"not configured", nil, errs.NotFound("force"), http.StatusNotFound, "application/json"},
+		{"not implemented", nil, errs.NotImplemented("force"), http.StatusNotImplemented, "application/json"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := chi.NewRouter()
+			New(&mockAuthority{
+				getSSHKeyRevocationList: func(ctx context.Context) ([]byte, error) {
+					return tt.krl, tt.err
+				},
+			}).Route(r)
+
+			req := httptest.NewRequest("GET", "http://example.com/ssh/krl", nil)
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			res := w.Result()
+
+			if res.StatusCode != tt.statusCode {
+				t.Errorf("caHandler.SSHKRL StatusCode = %d, wants %d", res.StatusCode, tt.statusCode)
+			}
+			if ct := res.Header.Get("Content-Type"); ct != tt.contentType {
+				t.Errorf("caHandler.SSHKRL Content-Type = %s, wants %s", ct, tt.contentType)
+			}
+
+			body, err := ioutil.ReadAll(res.Body)
+			res.Body.Close()
+			if err != nil {
+				t.Errorf("caHandler.SSHKRL unexpected error = %v", err)
+			}
+			if tt.statusCode == http.StatusOK && !bytes.Equal(body, tt.krl) {
+				t.Errorf("caHandler.SSHKRL Body = %x, wants %x", body, tt.krl)
+			}
+		})
+	}
+}
+
 func TestSSHPublicKey_MarshalJSON(t *testing.T) {
 	key, err := ssh.NewPublicKey(sshUserKey.Public())
 	assert.FatalError(t, err)
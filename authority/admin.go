@@ -0,0 +1,284 @@
+package authority
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"net/http"
+	"sort"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/db"
+	"github.com/smallstep/certificates/errs"
+)
+
+// AdminConfig represents the configuration of the admin API. Admin requests
+// must be authenticated with a token generated by one of the JWK provisioners
+// in Provisioners, or with a client certificate issued by one of the
+// Provisioners with one of the Subjects as common name or subject alternative
+// name.
+type AdminConfig struct {
+	Provisioners []string `json:"provisioners,omitempty"`
+	Subjects     []string `json:"subjects,omitempty"`
+}
+
+// Validate checks the fields in AdminConfig.
+func (c *AdminConfig) Validate() error {
+	switch {
+	case c == nil:
+		return nil
+	case len(c.Provisioners) == 0:
+		return errors.New("admin.provisioners cannot be empty")
+	default:
+		return nil
+	}
+}
+
+// isProvisioner returns true if the provisioner with the given name can
+// authorize admin requests.
+func (c *AdminConfig) isProvisioner(name string) bool {
+	for _, s := range c.Provisioners {
+		if s == name {
+			return true
+		}
+	}
+	return false
+}
+
+// isSubject returns true if one of the names of the certificate is an admin
+// subject.
+func (c *AdminConfig) isSubject(cert *x509.Certificate) bool {
+	names := append([]string{cert.Subject.CommonName}, cert.DNSNames...)
+	names = append(names, cert.EmailAddresses...)
+	for _, u := range cert.URIs {
+		names = append(names, u.String())
+	}
+	for _, name := range names {
+		for _, s := range c.Subjects {
+			if name != "" && name == s {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// loadAdminProvisioners initializes and stores the provisioners managed with
+// the admin API.
+func (a *Authority) loadAdminProvisioners() error {
+	list, err := a.db.GetProvisioners()
+	if err != nil {
+		return errors.Wrap(err, "error loading provisioners")
+	}
+	a.adminProvisioners = make(map[string]bool, len(list))
+	for _, data := range list {
+		p, err := a.newProvisioner(data)
+		if err != nil {
+			return err
+		}
+		if err := a.provisioners.Store(p); err != nil {
+			return err
+		}
+		a.adminProvisioners[p.GetID()] = true
+	}
+	return nil
+}
+
+// AuthorizeAdmin authorizes a request to the admin API using a token or the
+// client certificate.
+func (a *Authority) AuthorizeAdmin(ctx context.Context, token string, cert *x509.Certificate) error {
+	admin := a.config.Admin
+	if admin == nil {
+		return errs.NotImplemented("authority.AuthorizeAdmin; admin API is not enabled")
+	}
+
+	switch {
+	case token != "":
+		p, err := a.authorizeToken(ctx, token)
+		if err != nil {
+			return errs.Wrap(http.StatusUnauthorized, err, "authority.AuthorizeAdmin")
+		}
+		jwk, ok := p.(*provisioner.JWK)
+		if !ok || !admin.isProvisioner(jwk.GetName()) {
+			return errs.Forbidden("authority.AuthorizeAdmin; provisioner %s is not an admin provisioner", p.GetName())
+		}
+		return errs.Wrap(http.StatusUnauthorized, jwk.AuthorizeAdmin(ctx, token), "authority.AuthorizeAdmin")
+	case cert != nil:
+		var opts = []interface{}{errs.WithKeyVal("serialNumber", cert.SerialNumber.String())}
+		isRevoked, err := a.db.IsRevoked(cert.SerialNumber.String())
+		if err != nil {
+			return errs.Wrap(http.StatusInternalServerError, err, "authority.AuthorizeAdmin", opts...)
+		}
+		if isRevoked {
+			return errs.Unauthorized("authority.AuthorizeAdmin; certificate has been revoked", opts...)
+		}
+		// Certificates without the provisioner extension load the noop
+		// provisioner.
+		p, ok := a.provisioners.LoadByCertificate(cert)
+		if !ok || p.GetType() == 0 || !admin.isProvisioner(p.GetName()) {
+			return errs.Forbidden("authority.AuthorizeAdmin; certificate was not issued by an admin provisioner", opts...)
+		}
+		if !admin.isSubject(cert) {
+			return errs.Forbidden("authority.AuthorizeAdmin; certificate is not an admin certificate", opts...)
+		}
+		return nil
+	default:
+		return errs.Unauthorized("authority.AuthorizeAdmin; missing token or client certificate")
+	}
+}
+
+// GetAdminProvisioners returns the provisioners managed with the admin API.
+func (a *Authority) GetAdminProvisioners() (provisioner.List, error) {
+	a.adminMutex.Lock()
+	defer a.adminMutex.Unlock()
+
+	ids := make([]string, 0, len(a.adminProvisioners))
+	for id := range a.adminProvisioners {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	list := make(provisioner.List, 0, len(ids))
+	for _, id := range ids {
+		if p, ok := a.provisioners.Load(id); ok {
+			list = append(list, p)
+		}
+	}
+	return list, nil
+}
+
+// GetAdminProvisioner returns the provisioner managed with the admin API with
+// the given id.
+func (a *Authority) GetAdminProvisioner(id string) (provisioner.Interface, error) {
+	a.adminMutex.Lock()
+	defer a.adminMutex.Unlock()
+	return a.loadAdminProvisioner(id, "authority.GetAdminProvisioner")
+}
+
+// CreateProvisioner initializes and stores a new provisioner from its JSON
+// representation. The provisioner is available as soon as it is created.
+func (a *Authority) CreateProvisioner(data []byte) (provisioner.Interface, error) {
+	a.adminMutex.Lock()
+	defer a.adminMutex.Unlock()
+
+	p, err := a.newProvisioner(data)
+	if err != nil {
+		return nil, errs.Wrap(http.StatusBadRequest, err, "authority.CreateProvisioner")
+	}
+	if _, ok := a.provisioners.Load(p.GetID()); ok {
+		return nil, errs.BadRequest("authority.CreateProvisioner; provisioner %s already exists", p.GetID())
+	}
+	if err := a.storeProvisioner(p); err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.CreateProvisioner")
+	}
+	if err := a.provisioners.Store(p); err != nil {
+		a.db.DeleteProvisioner(p.GetID())
+		return nil, errs.Wrap(http.StatusBadRequest, err, "authority.CreateProvisioner")
+	}
+	a.adminProvisioners[p.GetID()] = true
+	a.updateSSHRetirement()
+	return p, nil
+}
+
+// UpdateProvisioner replaces the provisioner with the given id with the one
+// in the JSON representation. Only the provisioners created with the admin API
+// can be updated, and the id of the provisioner cannot change.
+func (a *Authority) UpdateProvisioner(id string, data []byte) (provisioner.Interface, error) {
+	a.adminMutex.Lock()
+	defer a.adminMutex.Unlock()
+
+	if _, err := a.loadAdminProvisioner(id, "authority.UpdateProvisioner"); err != nil {
+		return nil, err
+	}
+	p, err := a.newProvisioner(data)
+	if err != nil {
+		return nil, errs.Wrap(http.StatusBadRequest, err, "authority.UpdateProvisioner")
+	}
+	if p.GetID() != id {
+		return nil, errs.BadRequest("authority.UpdateProvisioner; provisioner id %s does not match %s", p.GetID(), id)
+	}
+	if err := a.storeProvisioner(p); err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.UpdateProvisioner")
+	}
+	if err := a.provisioners.Update(p); err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.UpdateProvisioner")
+	}
+	a.updateSSHRetirement()
+	return p, nil
+}
+
+// DeleteProvisioner deletes the provisioner with the given id. Only the
+// provisioners created with the admin API can be deleted.
+func (a *Authority) DeleteProvisioner(id string) error {
+	a.adminMutex.Lock()
+	defer a.adminMutex.Unlock()
+
+	if _, err := a.loadAdminProvisioner(id, "authority.DeleteProvisioner"); err != nil {
+		return err
+	}
+	if err := a.db.DeleteProvisioner(id); err != nil && err != db.ErrNotFound {
+		return errs.Wrap(http.StatusInternalServerError, err, "authority.DeleteProvisioner")
+	}
+	if err := a.provisioners.Remove(id); err != nil {
+		return errs.Wrap(http.StatusInternalServerError, err, "authority.DeleteProvisioner")
+	}
+	delete(a.adminProvisioners, id)
+	return nil
+}
+
+// loadAdminProvisioner returns the provisioner with the given id if it is
+// managed with the admin API. Provisioners in the configuration file cannot
+// be managed with the admin API.
+func (a *Authority) loadAdminProvisioner(id, op string) (provisioner.Interface, error) {
+	p, ok := a.provisioners.Load(id)
+	if !ok {
+		return nil, errs.NotFound("%s; provisioner %s not found", op, id)
+	}
+	if !a.adminProvisioners[id] {
+		return nil, errs.Forbidden("%s; provisioner %s is defined in the configuration file", op, id)
+	}
+	return p, nil
+}
+
+// newProvisioner unmarshals and initializes the provisioner in the given JSON
+// representation.
+func (a *Authority) newProvisioner(data []byte) (provisioner.Interface, error) {
+	p, err := unmarshalProvisioner(data)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.Init(a.provisionerConfig); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// storeProvisioner stores the provisioner in the database.
+func (a *Authority) storeProvisioner(p provisioner.Interface) error {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return errors.Wrap(err, "error marshaling provisioner")
+	}
+	if err := a.db.StoreProvisioner(p.GetID(), data); err != nil {
+		if err == db.ErrNotImplemented {
+			return errs.NotImplemented("no persistence layer configured")
+		}
+		return err
+	}
+	return nil
+}
+
+// unmarshalProvisioner returns the provisioner in the given JSON
+// representation.
+func unmarshalProvisioner(data []byte) (provisioner.Interface, error) {
+	var list provisioner.List
+	b := append(append([]byte("["), data...), ']')
+	if err := json.Unmarshal(b, &list); err != nil {
+		return nil, errors.Wrap(err, "error unmarshaling provisioner")
+	}
+	if len(list) != 1 {
+		return nil, errors.New("error unmarshaling provisioner: unsupported provisioner type")
+	}
+	return list[0], nil
+}
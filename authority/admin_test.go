@@ -0,0 +1,348 @@
+package authority
+
+import (
+	"context"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/db"
+	"github.com/smallstep/certificates/errs"
+	"github.com/smallstep/cli/jose"
+)
+
+func TestAdminConfig_Validate(t *testing.T) {
+	tests := map[string]struct {
+		c   *AdminConfig
+		err error
+	}{
+		"ok/nil":          {nil, nil},
+		"ok/provisioners": {&AdminConfig{Provisioners: []string{"admin"}}, nil},
+		"ok/subjects":     {&AdminConfig{Provisioners: []string{"admin"}, Subjects: []string{"admin"}}, nil},
+		"fail/subjects":   {&AdminConfig{Subjects: []string{"admin"}}, errors.New("admin.provisioners cannot be empty")},
+		"fail/empty":      {&AdminConfig{}, errors.New("admin.provisioners cannot be empty")},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if err := tc.c.Validate(); err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.Equals(t, tc.err.Error(), err.Error())
+				}
+			} else {
+				assert.Nil(t, tc.err)
+			}
+		})
+	}
+}
+
+func TestAuthority_AuthorizeAdmin(t *testing.T) {
+	jwk, err := jose.ParseKey("testdata/secrets/step_cli_key_priv.jwk", jose.WithPassword([]byte("pass")))
+	assert.FatalError(t, err)
+
+	mustToken := func(aud string) string {
+		tok, err := generateToken("admin", "step-cli", aud, nil, time.Now(), jwk)
+		assert.FatalError(t, err)
+		return tok
+	}
+	mustURL := func(s string) *url.URL {
+		u, err := url.Parse(s)
+		assert.FatalError(t, err)
+		return u
+	}
+	mustExtension := func(name, kid string) pkix.Extension {
+		b, err := asn1.Marshal(stepProvisionerASN1{
+			Type:         provisionerTypeJWK,
+			Name:         []byte(name),
+			CredentialID: []byte(kid),
+		})
+		assert.FatalError(t, err)
+		return pkix.Extension{Id: stepOIDProvisioner, Value: b}
+	}
+	maxjwk, err := jose.ParseKey("testdata/secrets/max_pub.jwk")
+	assert.FatalError(t, err)
+	newCert := func(cn string, fn func(*x509.Certificate)) *x509.Certificate {
+		cert := &x509.Certificate{
+			SerialNumber: big.NewInt(1234),
+			Subject:      pkix.Name{CommonName: cn},
+			Extensions:   []pkix.Extension{mustExtension("step-cli", jwk.KeyID)},
+		}
+		if fn != nil {
+			fn(cert)
+		}
+		return cert
+	}
+	adminConfig := &AdminConfig{
+		Provisioners: []string{"step-cli"},
+		Subjects:     []string{"admin", "admin@example.com", "spiffe://example.com/admin"},
+	}
+
+	type test struct {
+		admin *AdminConfig
+		db    db.AuthDB
+		token string
+		cert  *x509.Certificate
+		err   error
+		code  int
+	}
+	tests := map[string]test{
+		"ok/token": {
+			admin: adminConfig,
+			token: mustToken("https://example.com/admin"),
+		},
+		"ok/token-1.0": {
+			admin: adminConfig,
+			token: mustToken("https://example.com/1.0/admin"),
+		},
+		"ok/cert-common-name": {
+			admin: adminConfig,
+			cert:  newCert("admin", nil),
+		},
+		"ok/cert-email": {
+			admin: adminConfig,
+			cert: newCert("Jane", func(c *x509.Certificate) {
+				c.EmailAddresses = []string{"jane@example.com", "admin@example.com"}
+			}),
+		},
+		"ok/cert-uri": {
+			admin: adminConfig,
+			cert: newCert("", func(c *x509.Certificate) {
+				c.URIs = []*url.URL{mustURL("spiffe://example.com/admin")}
+			}),
+		},
+		"fail/not-enabled": {
+			token: mustToken("https://example.com/admin"),
+			err:   errors.New("authority.AuthorizeAdmin; admin API is not enabled"),
+			code:  http.StatusNotImplemented,
+		},
+		"fail/missing": {
+			admin: adminConfig,
+			err:   errors.New("authority.AuthorizeAdmin; missing token or client certificate"),
+			code:  http.StatusUnauthorized,
+		},
+		"fail/token-audience": {
+			admin: adminConfig,
+			token: mustToken("https://example.com/sign"),
+			err:   errors.New("authority.AuthorizeAdmin: jwk.AuthorizeAdmin: jwk.authorizeToken; invalid jwk token audience claim (aud)"),
+			code:  http.StatusUnauthorized,
+		},
+		"fail/token-invalid": {
+			admin: adminConfig,
+			token: "foo",
+			err:   errors.New("authority.AuthorizeAdmin: authority.authorizeToken: error parsing token"),
+			code:  http.StatusUnauthorized,
+		},
+		"fail/token-provisioner": {
+			admin: &AdminConfig{Provisioners: []string{"Max"}},
+			token: mustToken("https://example.com/admin"),
+			err:   errors.New("authority.AuthorizeAdmin; provisioner step-cli is not an admin provisioner"),
+			code:  http.StatusForbidden,
+		},
+		"fail/cert-subject": {
+			admin: adminConfig,
+			cert: newCert("jane", func(c *x509.Certificate) {
+				c.DNSNames = []string{"jane.example.com"}
+			}),
+			err:  errors.New("authority.AuthorizeAdmin; certificate is not an admin certificate"),
+			code: http.StatusForbidden,
+		},
+		"fail/cert-no-provisioner": {
+			admin: adminConfig,
+			cert: newCert("admin", func(c *x509.Certificate) {
+				c.Extensions = nil
+			}),
+			err:  errors.New("authority.AuthorizeAdmin; certificate was not issued by an admin provisioner"),
+			code: http.StatusForbidden,
+		},
+		"fail/cert-provisioner": {
+			admin: adminConfig,
+			cert: newCert("admin", func(c *x509.Certificate) {
+				c.Extensions = []pkix.Extension{mustExtension("Max", maxjwk.KeyID)}
+			}),
+			err:  errors.New("authority.AuthorizeAdmin; certificate was not issued by an admin provisioner"),
+			code: http.StatusForbidden,
+		},
+		"fail/cert-unknown-provisioner": {
+			admin: adminConfig,
+			cert: newCert("admin", func(c *x509.Certificate) {
+				c.Extensions = []pkix.Extension{mustExtension("step-cli", "foo")}
+			}),
+			err:  errors.New("authority.AuthorizeAdmin; certificate was not issued by an admin provisioner"),
+			code: http.StatusForbidden,
+		},
+		"fail/cert-revoked": {
+			admin: adminConfig,
+			db: &db.MockAuthDB{
+				MIsRevoked: func(sn string) (bool, error) {
+					assert.Equals(t, "1234", sn)
+					return true, nil
+				},
+			},
+			cert: newCert("admin", nil),
+			err:  errors.New("authority.AuthorizeAdmin; certificate has been revoked"),
+			code: http.StatusUnauthorized,
+		},
+		"fail/cert-db": {
+			admin: adminConfig,
+			db: &db.MockAuthDB{
+				MIsRevoked: func(sn string) (bool, error) {
+					return false, errors.New("force")
+				},
+			},
+			cert: newCert("admin", nil),
+			err:  errors.New("authority.AuthorizeAdmin: force"),
+			code: http.StatusInternalServerError,
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			var opts []Option
+			if tc.db != nil {
+				opts = append(opts, WithDatabase(tc.db))
+			}
+			a := testAuthority(t, opts...)
+			a.config.Admin = tc.admin
+			if err := a.AuthorizeAdmin(context.Background(), tc.token, tc.cert); err != nil {
+				if assert.NotNil(t, tc.err) {
+					sc, ok := err.(errs.StatusCoder)
+					assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+					assert.Equals(t, tc.code, sc.StatusCode())
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+			} else {
+				assert.Nil(t, tc.err)
+			}
+		})
+	}
+}
+
+// memoryProvisionersDB returns a MockAuthDB that stores the provisioners in
+// the given map.
+func memoryProvisionersDB(m map[string]json.RawMessage) *db.MockAuthDB {
+	return &db.MockAuthDB{
+		MGetProvisioners: func() ([]json.RawMessage, error) {
+			var list []json.RawMessage
+			for _, v := range m {
+				list = append(list, v)
+			}
+			return list, nil
+		},
+		MStoreProvisioner: func(id string, data json.RawMessage) error {
+			m[id] = data
+			return nil
+		},
+		MDeleteProvisioner: func(id string) error {
+			if _, ok := m[id]; !ok {
+				return db.ErrNotFound
+			}
+			delete(m, id)
+			return nil
+		},
+	}
+}
+
+func TestAuthority_provisionersAdmin(t *testing.T) {
+	key, err := ioutil.ReadFile("testdata/secrets/max_pub.jwk")
+	assert.FatalError(t, err)
+	jwk, err := jose.ParseKey("testdata/secrets/max_pub.jwk")
+	assert.FatalError(t, err)
+
+	teamID := "team:" + jwk.KeyID
+	team := []byte(`{"type":"JWK","name":"team","key":` + string(key) + `}`)
+	teamUpdate := []byte(`{"type":"JWK","name":"team","key":` + string(key) + `,"claims":{"maxTLSCertDuration":"1h","defaultTLSCertDuration":"1h"}}`)
+
+	stored := map[string]json.RawMessage{}
+	a := testAuthority(t, WithDatabase(memoryProvisionersDB(stored)))
+	n := len(a.config.AuthorityConfig.Provisioners)
+
+	// Create
+	p, err := a.CreateProvisioner(team)
+	assert.FatalError(t, err)
+	assert.Equals(t, teamID, p.GetID())
+	assert.Len(t, 1, stored)
+	_, ok := a.provisioners.Load(teamID)
+	assert.True(t, ok)
+	list, _ := a.provisioners.Find("", 100)
+	assert.Len(t, n+1, list)
+
+	_, err = a.CreateProvisioner(team)
+	assert.Equals(t, "authority.CreateProvisioner; provisioner "+teamID+" already exists", err.Error())
+	_, err = a.CreateProvisioner([]byte(`{"type":"foo"}`))
+	assert.HasPrefix(t, err.Error(), "authority.CreateProvisioner: error unmarshaling provisioner")
+	_, err = a.CreateProvisioner([]byte(`{"type":"JWK","name":"other"}`))
+	assert.Equals(t, http.StatusBadRequest, err.(errs.StatusCoder).StatusCode())
+
+	// Get
+	list, err = a.GetAdminProvisioners()
+	assert.FatalError(t, err)
+	assert.Equals(t, provisioner.List{p}, list)
+	got, err := a.GetAdminProvisioner(teamID)
+	assert.FatalError(t, err)
+	assert.Equals(t, p, got)
+
+	_, err = a.GetAdminProvisioner("foo")
+	assert.Equals(t, http.StatusNotFound, err.(errs.StatusCoder).StatusCode())
+	maxID := a.config.AuthorityConfig.Provisioners[0].GetID()
+	_, err = a.GetAdminProvisioner(maxID)
+	assert.Equals(t, http.StatusForbidden, err.(errs.StatusCoder).StatusCode())
+
+	// Update
+	p, err = a.UpdateProvisioner(teamID, teamUpdate)
+	assert.FatalError(t, err)
+	assert.Equals(t, time.Hour, p.(*provisioner.JWK).Claims.MaxTLSDur.Duration)
+	got, ok = a.provisioners.Load(teamID)
+	assert.True(t, ok)
+	assert.Equals(t, p, got)
+	assert.Equals(t, json.RawMessage(mustMarshal(t, p)), stored[teamID])
+
+	_, err = a.UpdateProvisioner(maxID, teamUpdate)
+	assert.Equals(t, http.StatusForbidden, err.(errs.StatusCoder).StatusCode())
+	_, err = a.UpdateProvisioner(teamID, []byte(`{"type":"JWK","name":"other","key":`+string(key)+`}`))
+	assert.Equals(t, http.StatusBadRequest, err.(errs.StatusCoder).StatusCode())
+
+	// Reload the authority with the stored provisioners
+	b := testAuthority(t, WithDatabase(memoryProvisionersDB(stored)))
+	list, err = b.GetAdminProvisioners()
+	assert.FatalError(t, err)
+	assert.Len(t, 1, list)
+	assert.Equals(t, teamID, list[0].GetID())
+
+	// Delete
+	assert.FatalError(t, a.DeleteProvisioner(teamID))
+	assert.Len(t, 0, stored)
+	_, ok = a.provisioners.Load(teamID)
+	assert.False(t, ok)
+	list, _ = a.provisioners.Find("", 100)
+	assert.Len(t, n, list)
+
+	err = a.DeleteProvisioner(teamID)
+	assert.Equals(t, http.StatusNotFound, err.(errs.StatusCoder).StatusCode())
+	err = a.DeleteProvisioner(maxID)
+	assert.Equals(t, http.StatusForbidden, err.(errs.StatusCoder).StatusCode())
+}
+
+func TestAuthority_CreateProvisioner_noDB(t *testing.T) {
+	key, err := ioutil.ReadFile("testdata/secrets/max_pub.jwk")
+	assert.FatalError(t, err)
+
+	a := testAuthority(t)
+	_, err = a.CreateProvisioner([]byte(`{"type":"JWK","name":"team","key":` + string(key) + `}`))
+	if assert.NotNil(t, err) {
+		assert.Equals(t, http.StatusNotImplemented, err.(errs.StatusCoder).StatusCode())
+	}
+}
+
+func mustMarshal(t *testing.T, v interface{}) []byte {
+	b, err := json.Marshal(v)
+	assert.FatalError(t, err)
+	return b
+}
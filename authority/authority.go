@@ -34,10 +34,26 @@ type Authority struct {
 	// X509 CA
 	rootX509Certs      []*x509.Certificate
 	federatedX509Certs []*x509.Certificate
+	x509Mutex          sync.RWMutex
 	x509Signer         crypto.Signer
 	x509Issuer         *x509.Certificate
+	x509Intermediates  []*x509.Certificate
+	x509RetiredSigners map[string]*issuerSigner
 	certificates       *sync.Map
 
+	// OCSP responder
+	ocspSigner      crypto.Signer
+	ocspCertificate *x509.Certificate
+
+	// CRL generation
+	crlMutex   sync.Mutex
+	crlStopper chan struct{}
+
+	// Provisioners managed with the admin API
+	adminMutex        sync.Mutex
+	adminProvisioners map[string]bool
+	provisionerConfig provisioner.Config
+
 	// SSH CA
 	sshCAUserCertSignKey    ssh.Signer
 	sshCAHostCertSignKey    ssh.Signer
@@ -45,6 +61,8 @@ type Authority struct {
 	sshCAHostCerts          []ssh.PublicKey
 	sshCAUserFederatedCerts []ssh.PublicKey
 	sshCAHostFederatedCerts []ssh.PublicKey
+	sshUserRotation         *sshKeyRotation
+	sshHostRotation         *sshKeyRotation
 
 	// Do not re-initialize
 	initOnce  bool
@@ -163,6 +181,21 @@ func (a *Authority) init() error {
 		a.x509Issuer = crt
 	}
 
+	// Read retired intermediates.
+	if err := a.initIntermediates(); err != nil {
+		return err
+	}
+
+	// Load the delegated OCSP responder.
+	if err := a.initOCSP(); err != nil {
+		return err
+	}
+
+	// Generate the first CRL and start the periodic generation.
+	if err := a.initCRL(); err != nil {
+		return err
+	}
+
 	// Decrypt and load SSH keys
 	if a.config.SSH != nil {
 		if a.config.SSH.HostKey != "" {
@@ -217,6 +250,11 @@ func (a *Authority) init() error {
 				return errors.Errorf("unsupported type %s", key.Type)
 			}
 		}
+
+		// Load the keys that will replace the signing keys
+		if err := a.initSSHRotation(); err != nil {
+			return err
+		}
 	}
 
 	// Merge global and configuration claims
@@ -231,6 +269,11 @@ func (a *Authority) init() error {
 	if err != nil {
 		return err
 	}
+	// During a rotation the certificates signed by the previous and the new
+	// keys must be valid.
+	sshKeys.UserKeys = a.sshUserRotation.appendKeys(sshKeys.UserKeys, a.sshCAUserCertSignKey)
+	sshKeys.HostKeys = a.sshHostRotation.appendKeys(sshKeys.HostKeys, a.sshCAHostCertSignKey)
+
 	// Initialize provisioners
 	config := provisioner.Config{
 		Claims:    claimer.Claims(),
@@ -241,6 +284,7 @@ func (a *Authority) init() error {
 			HostKeys: sshKeys.HostKeys,
 		},
 		GetIdentityFunc: a.getIdentityFunc,
+		NameConstraints: a.config.AuthorityConfig.NameConstraints,
 	}
 	// Store all the provisioners
 	for _, p := range a.config.AuthorityConfig.Provisioners {
@@ -251,6 +295,15 @@ func (a *Authority) init() error {
 			return err
 		}
 	}
+	// Store the provisioners created with the admin API
+	a.provisionerConfig = config
+	if err := a.loadAdminProvisioners(); err != nil {
+		return err
+	}
+
+	// Set the retirement of the rotated SSH keys using the provisioners
+	// claims.
+	a.initSSHRetirement()
 
 	// Configure protected template variables:
 	if t := a.config.Templates; t != nil {
@@ -259,14 +312,7 @@ func (a *Authority) init() error {
 		}
 		var vars templates.Step
 		if a.config.SSH != nil {
-			if a.sshCAHostCertSignKey != nil {
-				vars.SSH.HostKey = a.sshCAHostCertSignKey.PublicKey()
-				vars.SSH.HostFederatedKeys = append(vars.SSH.HostFederatedKeys, a.sshCAHostFederatedCerts[1:]...)
-			}
-			if a.sshCAUserCertSignKey != nil {
-				vars.SSH.UserKey = a.sshCAUserCertSignKey.PublicKey()
-				vars.SSH.UserFederatedKeys = append(vars.SSH.UserFederatedKeys, a.sshCAUserFederatedCerts[1:]...)
-			}
+			vars = a.getSSHTemplateVars()
 		}
 		t.Data["Step"] = vars
 	}
@@ -288,5 +334,12 @@ func (a *Authority) GetDatabase() db.AuthDB {
 
 // Shutdown safely shuts down any clients, databases, etc. held by the Authority.
 func (a *Authority) Shutdown() error {
+	a.stopCRL()
 	return a.db.Shutdown()
 }
+
+// CloseForReload stops the background tasks of the Authority without closing
+// the database, so it can be reused by the new Authority on graceful reloads.
+func (a *Authority) CloseForReload() {
+	a.stopCRL()
+}
@@ -31,6 +31,7 @@ var testAudiences = provisioner.Audiences{
 	SSHRevoke: []string{"https://example.com/1.0/ssh/revoke"},
 	SSHRenew:  []string{"https://example.com/1.0/ssh/renew"},
 	SSHRekey:  []string{"https://example.com/1.0/ssh/rekey"},
+	Admin:     []string{"https://example.com/1.0/admin"},
 }
 
 type tokOption func(*jose.SignerOptions) error
@@ -449,7 +450,7 @@ func TestAuthority_authorizeSign(t *testing.T) {
 				}
 			} else {
 				if assert.Nil(t, tc.err) {
-					assert.Len(t, 8, got)
+					assert.Len(t, 10, got)
 				}
 			}
 		})
@@ -961,7 +962,7 @@ func TestAuthority_authorizeSSHSign(t *testing.T) {
 				}
 			} else {
 				if assert.Nil(t, tc.err) {
-					assert.Len(t, 11, got)
+					assert.Len(t, 12, got)
 				}
 			}
 		})
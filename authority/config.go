@@ -49,30 +49,35 @@ var (
 
 // Config represents the CA configuration and it's mapped to a JSON object.
 type Config struct {
-	Root             multiString          `json:"root"`
-	FederatedRoots   []string             `json:"federatedRoots"`
-	IntermediateCert string               `json:"crt"`
-	IntermediateKey  string               `json:"key"`
-	Address          string               `json:"address"`
-	DNSNames         []string             `json:"dnsNames"`
-	KMS              *kms.Options         `json:"kms,omitempty"`
-	SSH              *SSHConfig           `json:"ssh,omitempty"`
-	Logger           json.RawMessage      `json:"logger,omitempty"`
-	DB               *db.Config           `json:"db,omitempty"`
-	Monitoring       json.RawMessage      `json:"monitoring,omitempty"`
-	AuthorityConfig  *AuthConfig          `json:"authority,omitempty"`
-	TLS              *tlsutil.TLSOptions  `json:"tls,omitempty"`
-	Password         string               `json:"password,omitempty"`
-	Templates        *templates.Templates `json:"templates,omitempty"`
+	Root             multiString           `json:"root"`
+	FederatedRoots   []string              `json:"federatedRoots"`
+	IntermediateCert string                `json:"crt"`
+	IntermediateKey  string                `json:"key"`
+	Intermediates    []*IntermediateConfig `json:"intermediates,omitempty"`
+	Address          string                `json:"address"`
+	DNSNames         []string              `json:"dnsNames"`
+	KMS              *kms.Options          `json:"kms,omitempty"`
+	SSH              *SSHConfig            `json:"ssh,omitempty"`
+	OCSP             *OCSPConfig           `json:"ocsp,omitempty"`
+	CRL              *CRLConfig            `json:"crl,omitempty"`
+	Logger           json.RawMessage       `json:"logger,omitempty"`
+	DB               *db.Config            `json:"db,omitempty"`
+	Monitoring       json.RawMessage       `json:"monitoring,omitempty"`
+	AuthorityConfig  *AuthConfig           `json:"authority,omitempty"`
+	TLS              *tlsutil.TLSOptions   `json:"tls,omitempty"`
+	Password         string                `json:"password,omitempty"`
+	Templates        *templates.Templates  `json:"templates,omitempty"`
+	Admin            *AdminConfig          `json:"admin,omitempty"`
 }
 
 // AuthConfig represents the configuration options for the authority.
 type AuthConfig struct {
-	Provisioners         provisioner.List      `json:"provisioners"`
-	Template             *x509util.ASN1DN      `json:"template,omitempty"`
-	Claims               *provisioner.Claims   `json:"claims,omitempty"`
-	DisableIssuedAtCheck bool                  `json:"disableIssuedAtCheck,omitempty"`
-	Backdate             *provisioner.Duration `json:"backdate,omitempty"`
+	Provisioners         provisioner.List             `json:"provisioners"`
+	Template             *x509util.ASN1DN             `json:"template,omitempty"`
+	Claims               *provisioner.Claims          `json:"claims,omitempty"`
+	DisableIssuedAtCheck bool                         `json:"disableIssuedAtCheck,omitempty"`
+	Backdate             *provisioner.Duration        `json:"backdate,omitempty"`
+	NameConstraints      *provisioner.NameConstraints `json:"nameConstraints,omitempty"`
 }
 
 // Validate validates the authority configuration.
@@ -106,6 +111,10 @@ func (c *AuthConfig) Validate(audiences provisioner.Audiences) error {
 		}
 	}
 
+	if err := c.NameConstraints.Init(); err != nil {
+		return errors.Wrap(err, "authority.nameConstraints")
+	}
+
 	return nil
 }
 
@@ -181,6 +190,13 @@ func (c *Config) Validate() error {
 		c.TLS.Renegotiation = c.TLS.Renegotiation || DefaultTLSOptions.Renegotiation
 	}
 
+	// Validate retired intermediates
+	for _, ic := range c.Intermediates {
+		if err := ic.Validate(); err != nil {
+			return err
+		}
+	}
+
 	// Validate KMS options, nil is ok.
 	if err := c.KMS.Validate(); err != nil {
 		return err
@@ -191,11 +207,26 @@ func (c *Config) Validate() error {
 		return err
 	}
 
+	// Validate ocsp: nil is ok
+	if err := c.OCSP.Validate(); err != nil {
+		return err
+	}
+
+	// Validate crl: nil is ok
+	if err := c.CRL.Validate(); err != nil {
+		return err
+	}
+
 	// Validate templates: nil is ok
 	if err := c.Templates.Validate(); err != nil {
 		return err
 	}
 
+	// Validate admin: nil is ok
+	if err := c.Admin.Validate(); err != nil {
+		return err
+	}
+
 	return c.AuthorityConfig.Validate(c.getAudiences())
 }
 
@@ -209,6 +240,7 @@ func (c *Config) getAudiences() provisioner.Audiences {
 		SSHSign:   []string{},
 		SSHRevoke: []string{},
 		SSHRenew:  []string{},
+		Admin:     []string{},
 	}
 
 	for _, name := range c.DNSNames {
@@ -234,6 +266,9 @@ func (c *Config) getAudiences() provisioner.Audiences {
 		audiences.SSHRekey = append(audiences.SSHRekey,
 			fmt.Sprintf("https://%s/1.0/ssh/rekey", name),
 			fmt.Sprintf("https://%s/ssh/rekey", name))
+		audiences.Admin = append(audiences.Admin,
+			fmt.Sprintf("https://%s/1.0/admin", name),
+			fmt.Sprintf("https://%s/admin", name))
 	}
 
 	return audiences
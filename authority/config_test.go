@@ -117,6 +117,21 @@ func TestConfigValidate(t *testing.T) {
 				err: errors.New("dnsNames cannot be empty"),
 			}
 		},
+		"empty-intermediates-crt": func(t *testing.T) ConfigValidateTest {
+			return ConfigValidateTest{
+				config: &Config{
+					Address:          "127.0.0.1:443",
+					Root:             []string{"testdata/secrets/root_ca.crt"},
+					IntermediateCert: "testdata/secrets/intermediate_ca.crt",
+					IntermediateKey:  "testdata/secrets/intermediate_ca_key",
+					Intermediates:    []*IntermediateConfig{{}},
+					DNSNames:         []string{"test.smallstep.com"},
+					Password:         "pass",
+					AuthorityConfig:  ac,
+				},
+				err: errors.New("intermediates.crt cannot be empty"),
+			}
+		},
 		"empty-TLS": func(t *testing.T) ConfigValidateTest {
 			return ConfigValidateTest{
 				config: &Config{
@@ -278,6 +293,29 @@ func TestAuthConfigValidate(t *testing.T) {
 				asn1dn: asn1dn,
 			}
 		},
+		"fail-name-constraints": func(t *testing.T) AuthConfigValidateTest {
+			return AuthConfigValidateTest{
+				ac: &AuthConfig{
+					Provisioners: p,
+					NameConstraints: &provisioner.NameConstraints{
+						PermittedIPRanges: []string{"10.0.0.1"},
+					},
+				},
+				err: errors.New("authority.nameConstraints: error parsing nameConstraints IP range 10.0.0.1: invalid CIDR address: 10.0.0.1"),
+			}
+		},
+		"ok-name-constraints": func(t *testing.T) AuthConfigValidateTest {
+			return AuthConfigValidateTest{
+				ac: &AuthConfig{
+					Provisioners: p,
+					NameConstraints: &provisioner.NameConstraints{
+						PermittedDNSDomains: []string{"example.com"},
+						PermittedIPRanges:   []string{"10.0.0.0/8"},
+					},
+				},
+				asn1dn: x509util.ASN1DN{},
+			}
+		},
 	}
 
 	for name, get := range tests {
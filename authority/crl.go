@@ -0,0 +1,387 @@
+package authority
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/db"
+	"github.com/smallstep/certificates/errs"
+	"github.com/smallstep/cli/crypto/x509util"
+	"golang.org/x/crypto/ed25519"
+)
+
+var defaultCRLLifetime = 24 * time.Hour
+
+var (
+	oidExtensionCRLNumber = asn1.ObjectIdentifier{2, 5, 29, 20}
+	oidExtensionReason    = asn1.ObjectIdentifier{2, 5, 29, 21}
+
+	oidSignatureSHA256WithRSA   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 11}
+	oidSignatureECDSAWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+	oidSignatureECDSAWithSHA384 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 3}
+	oidSignatureECDSAWithSHA512 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 4}
+	oidSignatureEd25519         = asn1.ObjectIdentifier{1, 3, 101, 112}
+)
+
+// CRLConfig represents the configuration of the certificate revocation list
+// (CRL) generation.
+type CRLConfig struct {
+	Enabled     bool                  `json:"enabled"`
+	Lifetime    *provisioner.Duration `json:"lifetime,omitempty"`
+	RenewPeriod *provisioner.Duration `json:"renewPeriod,omitempty"`
+	URLs        []string              `json:"urls,omitempty"`
+}
+
+// IsEnabled returns if the CRL generation is enabled.
+func (c *CRLConfig) IsEnabled() bool {
+	return c != nil && c.Enabled
+}
+
+// Validate checks the fields in CRLConfig.
+func (c *CRLConfig) Validate() error {
+	switch {
+	case c == nil:
+		return nil
+	case c.Lifetime != nil && c.Lifetime.Duration <= 0:
+		return errors.New("crl.lifetime must be greater than 0")
+	case c.RenewPeriod != nil && c.RenewPeriod.Duration <= 0:
+		return errors.New("crl.renewPeriod must be greater than 0")
+	case c.RenewPeriod != nil && c.RenewPeriod.Duration >= c.lifetime():
+		return errors.New("crl.renewPeriod must be less than crl.lifetime")
+	default:
+		return nil
+	}
+}
+
+// lifetime returns the time between the generation of a CRL and its next
+// update.
+func (c *CRLConfig) lifetime() time.Duration {
+	if c == nil || c.Lifetime == nil {
+		return defaultCRLLifetime
+	}
+	return c.Lifetime.Duration
+}
+
+// renewPeriod returns how often a new CRL is generated. By default it is two
+// thirds of the lifetime.
+func (c *CRLConfig) renewPeriod() time.Duration {
+	if c == nil || c.RenewPeriod == nil {
+		return c.lifetime() * 2 / 3
+	}
+	return c.RenewPeriod.Duration
+}
+
+// withCRLDistributionPoints is an x509util option that adds the CRL
+// distribution points extension.
+func withCRLDistributionPoints(urls []string) x509util.WithOption {
+	return func(p x509util.Profile) error {
+		crt := p.Subject()
+		crt.CRLDistributionPoints = append([]string(nil), urls...)
+		return nil
+	}
+}
+
+// getCRLDistributionPoints returns the configured CRL urls, or the default
+// one using the first DNS name of the CA and the id of the issuer, so the
+// certificates of a retired intermediate keep pointing to its CRL.
+func (c *Config) getCRLDistributionPoints(issuerID string) []string {
+	if len(c.CRL.URLs) > 0 {
+		return c.CRL.URLs
+	}
+	host := c.DNSNames[0]
+	if u, err := url.Parse("https://" + c.Address); err == nil {
+		if port := u.Port(); port != "" && port != "443" {
+			host = fmt.Sprintf("%s:%s", host, port)
+		}
+	}
+	return []string{"https://" + host + "/1.0/crl/" + issuerID}
+}
+
+// initCRL generates the first CRLs and starts the periodic generation of
+// CRLs.
+func (a *Authority) initCRL() error {
+	if !a.config.CRL.IsEnabled() || a.crlStopper != nil {
+		return nil
+	}
+	if err := a.generateCRLs(); err != nil {
+		return errors.Wrap(err, "error generating certificate revocation list")
+	}
+
+	a.crlStopper = make(chan struct{})
+	ticker := time.NewTicker(a.config.CRL.renewPeriod())
+	go func(stopper chan struct{}) {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := a.generateCRLs(); err != nil {
+					log.Printf("error generating certificate revocation list: %v", err)
+				}
+			case <-stopper:
+				return
+			}
+		}
+	}(a.crlStopper)
+	return nil
+}
+
+// stopCRL stops the periodic generation of CRLs.
+func (a *Authority) stopCRL() {
+	if a.crlStopper != nil {
+		close(a.crlStopper)
+		a.crlStopper = nil
+	}
+}
+
+// GetCertificateRevocationList returns the current certificate revocation
+// list of the intermediate with the given id in DER format, or the one of the
+// active intermediate if the id is empty. A new CRL is generated if the stored
+// one has expired.
+func (a *Authority) GetCertificateRevocationList(issuerID string) ([]byte, error) {
+	if !a.config.CRL.IsEnabled() {
+		return nil, errs.NotFound("authority.GetCertificateRevocationList; certificate revocation lists are not enabled")
+	}
+
+	s := a.getIssuerSigner(issuerID)
+	if s == nil {
+		return nil, errs.NotFound("authority.GetCertificateRevocationList; certificate revocation list %s not found", issuerID)
+	}
+
+	crl, err := a.db.GetCRL(x509IssuerID(s.issuer))
+	switch err {
+	case nil:
+		if time.Now().Before(crl.ExpiresAt) {
+			return crl.DER, nil
+		}
+	case db.ErrNotFound:
+	case db.ErrNotImplemented:
+		return nil, errs.NotImplemented("authority.GetCertificateRevocationList; no persistence layer configured")
+	default:
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.GetCertificateRevocationList")
+	}
+
+	a.crlMutex.Lock()
+	defer a.crlMutex.Unlock()
+	revokedCerts, err := a.getRevokedCertificates()
+	if err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.GetCertificateRevocationList")
+	}
+	if crl, err = a.generateCRL(s, revokedCerts); err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.GetCertificateRevocationList")
+	}
+	return crl.DER, nil
+}
+
+// GenerateCertificateRevocationList generates and stores a new certificate
+// revocation list with all the revoked certificates for the active
+// intermediate and for each retired intermediate with a key.
+func (a *Authority) GenerateCertificateRevocationList() error {
+	if !a.config.CRL.IsEnabled() {
+		return errs.NotFound("authority.GenerateCertificateRevocationList; certificate revocation lists are not enabled")
+	}
+	if err := a.generateCRLs(); err != nil {
+		if err == db.ErrNotImplemented {
+			return errs.NotImplemented("authority.GenerateCertificateRevocationList; no persistence layer configured")
+		}
+		return errs.Wrap(http.StatusInternalServerError, err, "authority.GenerateCertificateRevocationList")
+	}
+	return nil
+}
+
+// generateCRLs creates and stores a new CRL for the active intermediate and
+// for each retired intermediate with a key. Serial numbers are unique across
+// intermediates, so all the CRLs contain all the revoked certificates.
+func (a *Authority) generateCRLs() error {
+	a.crlMutex.Lock()
+	defer a.crlMutex.Unlock()
+
+	revokedCerts, err := a.getRevokedCertificates()
+	if err != nil {
+		return err
+	}
+	for _, s := range a.getIssuerSigners() {
+		if _, err := a.generateCRL(s, revokedCerts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getRevokedCertificates returns the list of revoked certificates to include
+// in the CRLs.
+func (a *Authority) getRevokedCertificates() ([]pkix.RevokedCertificate, error) {
+	revoked, err := a.db.GetRevokedCertificates()
+	if err != nil {
+		return nil, err
+	}
+
+	revokedCerts := make([]pkix.RevokedCertificate, 0, len(revoked))
+	for _, rci := range revoked {
+		sn, ok := new(big.Int).SetString(rci.Serial, 10)
+		if !ok {
+			return nil, errors.Errorf("error parsing serial number %s", rci.Serial)
+		}
+		rc := pkix.RevokedCertificate{
+			SerialNumber:   sn,
+			RevocationTime: rci.RevokedAt.UTC(),
+		}
+		// The reason code unspecified should not be used.
+		if rci.ReasonCode > 0 {
+			b, err := asn1.Marshal(asn1.Enumerated(rci.ReasonCode))
+			if err != nil {
+				return nil, errors.Wrap(err, "error marshaling reason code")
+			}
+			rc.Extensions = []pkix.Extension{{Id: oidExtensionReason, Value: b}}
+		}
+		revokedCerts = append(revokedCerts, rc)
+	}
+	return revokedCerts, nil
+}
+
+// generateCRL creates a new CRL signed by the given intermediate with its next
+// CRL number and stores it in the database. It must be called with the
+// crlMutex locked.
+func (a *Authority) generateCRL(s *issuerSigner, revokedCerts []pkix.RevokedCertificate) (*db.CertificateRevocationListInfo, error) {
+	id := x509IssuerID(s.issuer)
+
+	var number int64
+	switch prev, err := a.db.GetCRL(id); err {
+	case nil:
+		number = prev.Number
+	case db.ErrNotFound:
+	default:
+		return nil, err
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	crl := &db.CertificateRevocationListInfo{
+		Number:    number + 1,
+		ExpiresAt: now.Add(a.config.CRL.lifetime()),
+	}
+	var err error
+	crl.DER, err = createCRL(s.issuer, s.signer, revokedCerts, crl.Number, now, crl.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	if err := a.db.StoreCRL(id, crl); err != nil {
+		return nil, err
+	}
+	return crl, nil
+}
+
+type tbsCertificateList struct {
+	Version             int `asn1:"optional,default:0"`
+	Signature           pkix.AlgorithmIdentifier
+	Issuer              asn1.RawValue
+	ThisUpdate          time.Time
+	NextUpdate          time.Time                 `asn1:"optional"`
+	RevokedCertificates []pkix.RevokedCertificate `asn1:"optional"`
+	Extensions          []pkix.Extension          `asn1:"tag:0,optional,explicit"`
+}
+
+type certificateList struct {
+	TBSCertList        asn1.RawValue
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	SignatureValue     asn1.BitString
+}
+
+type authorityKeyID struct {
+	ID []byte `asn1:"optional,tag:0"`
+}
+
+// createCRL creates a version 2 CRL (RFC 5280) with the CRL number and the
+// authority key identifier extensions.
+func createCRL(issuer *x509.Certificate, signer crypto.Signer, revokedCerts []pkix.RevokedCertificate, number int64, thisUpdate, nextUpdate time.Time) ([]byte, error) {
+	hashFunc, sigAlg, err := signingParamsForPublicKey(signer.Public())
+	if err != nil {
+		return nil, err
+	}
+
+	crlNumber, err := asn1.Marshal(big.NewInt(number))
+	if err != nil {
+		return nil, errors.Wrap(err, "error marshaling crl number")
+	}
+	extensions := []pkix.Extension{{Id: oidExtensionCRLNumber, Value: crlNumber}}
+	if len(issuer.SubjectKeyId) > 0 {
+		aki, err := asn1.Marshal(authorityKeyID{ID: issuer.SubjectKeyId})
+		if err != nil {
+			return nil, errors.Wrap(err, "error marshaling authority key identifier")
+		}
+		extensions = append(extensions, pkix.Extension{Id: oidAuthorityKeyIdentifier, Value: aki})
+	}
+
+	tbs, err := asn1.Marshal(tbsCertificateList{
+		Version:             1,
+		Signature:           sigAlg,
+		Issuer:              asn1.RawValue{FullBytes: issuer.RawSubject},
+		ThisUpdate:          thisUpdate,
+		NextUpdate:          nextUpdate,
+		RevokedCertificates: revokedCerts,
+		Extensions:          extensions,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "error marshaling crl")
+	}
+
+	digest := tbs
+	if hashFunc != 0 {
+		h := hashFunc.New()
+		h.Write(tbs)
+		digest = h.Sum(nil)
+	}
+	signature, err := signer.Sign(rand.Reader, digest, hashFunc)
+	if err != nil {
+		return nil, errors.Wrap(err, "error signing crl")
+	}
+
+	b, err := asn1.Marshal(certificateList{
+		TBSCertList:        asn1.RawValue{FullBytes: tbs},
+		SignatureAlgorithm: sigAlg,
+		SignatureValue:     asn1.BitString{Bytes: signature, BitLength: len(signature) * 8},
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "error marshaling crl")
+	}
+	return b, nil
+}
+
+// signingParamsForPublicKey returns the hash function and signature algorithm
+// to use with the given public key.
+func signingParamsForPublicKey(pub crypto.PublicKey) (crypto.Hash, pkix.AlgorithmIdentifier, error) {
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		return crypto.SHA256, pkix.AlgorithmIdentifier{
+			Algorithm:  oidSignatureSHA256WithRSA,
+			Parameters: asn1.NullRawValue,
+		}, nil
+	case *ecdsa.PublicKey:
+		switch k.Curve {
+		case elliptic.P256():
+			return crypto.SHA256, pkix.AlgorithmIdentifier{Algorithm: oidSignatureECDSAWithSHA256}, nil
+		case elliptic.P384():
+			return crypto.SHA384, pkix.AlgorithmIdentifier{Algorithm: oidSignatureECDSAWithSHA384}, nil
+		case elliptic.P521():
+			return crypto.SHA512, pkix.AlgorithmIdentifier{Algorithm: oidSignatureECDSAWithSHA512}, nil
+		default:
+			return 0, pkix.AlgorithmIdentifier{}, errors.New("unsupported elliptic curve")
+		}
+	case ed25519.PublicKey:
+		return 0, pkix.AlgorithmIdentifier{Algorithm: oidSignatureEd25519}, nil
+	default:
+		return 0, pkix.AlgorithmIdentifier{}, errors.Errorf("unsupported public key type %T", pub)
+	}
+}
@@ -0,0 +1,289 @@
+package authority
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"math/big"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/db"
+	"github.com/smallstep/certificates/errs"
+)
+
+func TestCRLConfig_Validate(t *testing.T) {
+	tests := map[string]struct {
+		config *CRLConfig
+		err    error
+	}{
+		"ok/nil":   {nil, nil},
+		"ok/empty": {&CRLConfig{}, nil},
+		"ok/enabled": {&CRLConfig{
+			Enabled:     true,
+			Lifetime:    &provisioner.Duration{Duration: time.Hour},
+			RenewPeriod: &provisioner.Duration{Duration: 30 * time.Minute},
+		}, nil},
+		"fail/negative-lifetime":    {&CRLConfig{Lifetime: &provisioner.Duration{Duration: -time.Minute}}, errors.New("crl.lifetime must be greater than 0")},
+		"fail/negative-renewPeriod": {&CRLConfig{RenewPeriod: &provisioner.Duration{Duration: -time.Minute}}, errors.New("crl.renewPeriod must be greater than 0")},
+		"fail/renewPeriod": {&CRLConfig{
+			Lifetime:    &provisioner.Duration{Duration: time.Hour},
+			RenewPeriod: &provisioner.Duration{Duration: time.Hour},
+		}, errors.New("crl.renewPeriod must be less than crl.lifetime")},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if err := tc.config.Validate(); err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.Equals(t, tc.err.Error(), err.Error())
+				}
+			} else {
+				assert.Nil(t, tc.err)
+			}
+		})
+	}
+}
+
+func TestConfig_getCRLDistributionPoints(t *testing.T) {
+	tests := map[string]struct {
+		config *Config
+		want   []string
+	}{
+		"configured": {&Config{Address: ":443", DNSNames: []string{"ca.smallstep.com"}, CRL: &CRLConfig{
+			URLs: []string{"http://crl.smallstep.com/ca.crl"},
+		}}, []string{"http://crl.smallstep.com/ca.crl"}},
+		"default": {&Config{Address: ":443", DNSNames: []string{"ca.smallstep.com"}, CRL: &CRLConfig{}},
+			[]string{"https://ca.smallstep.com/1.0/crl/abcd"}},
+		"default-port": {&Config{Address: "127.0.0.1:9000", DNSNames: []string{"ca.smallstep.com"}, CRL: &CRLConfig{}},
+			[]string{"https://ca.smallstep.com:9000/1.0/crl/abcd"}},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.Equals(t, tc.want, tc.config.getCRLDistributionPoints("abcd"))
+		})
+	}
+}
+
+func TestAuthority_GetCertificateRevocationList(t *testing.T) {
+	revokedAt := time.Now().Add(-time.Hour).UTC().Truncate(time.Second)
+	_, retired, retiredSigner := testIntermediate(t, "retired-intermediate")
+	retiredID := x509IssuerID(retired)
+
+	type test struct {
+		auth     *Authority
+		issuerID string
+		issuer   *x509.Certificate
+		number   int64
+		serial   []string
+		der      []byte
+		err      error
+		code     int
+	}
+	tests := map[string]func(t *testing.T) test{
+		"fail/not-enabled": func(t *testing.T) test {
+			return test{
+				auth: testAuthority(t),
+				err:  errors.New("authority.GetCertificateRevocationList; certificate revocation lists are not enabled"),
+				code: http.StatusNotFound,
+			}
+		},
+		"fail/no-db": func(t *testing.T) test {
+			a := testAuthority(t)
+			a.config.CRL = &CRLConfig{Enabled: true}
+			return test{
+				auth: a,
+				err:  errors.New("authority.GetCertificateRevocationList; no persistence layer configured"),
+				code: http.StatusNotImplemented,
+			}
+		},
+		"fail/db-error": func(t *testing.T) test {
+			a := testAuthority(t, WithDatabase(&db.MockAuthDB{
+				MGetCRL: func(issuerID string) (*db.CertificateRevocationListInfo, error) {
+					return nil, errors.New("force")
+				},
+			}))
+			a.config.CRL = &CRLConfig{Enabled: true}
+			return test{
+				auth: a,
+				err:  errors.New("authority.GetCertificateRevocationList: force"),
+				code: http.StatusInternalServerError,
+			}
+		},
+		"fail/bad-serial": func(t *testing.T) test {
+			a := testAuthority(t, WithDatabase(&db.MockAuthDB{
+				MGetCRL: func(issuerID string) (*db.CertificateRevocationListInfo, error) {
+					return nil, db.ErrNotFound
+				},
+				MGetRevokedCertificates: func() ([]db.RevokedCertificateInfo, error) {
+					return []db.RevokedCertificateInfo{{Serial: "foo"}}, nil
+				},
+			}))
+			a.config.CRL = &CRLConfig{Enabled: true}
+			return test{
+				auth: a,
+				err:  errors.New("authority.GetCertificateRevocationList: error parsing serial number foo"),
+				code: http.StatusInternalServerError,
+			}
+		},
+		"fail/unknown-issuer": func(t *testing.T) test {
+			a := testAuthority(t)
+			a.config.CRL = &CRLConfig{Enabled: true}
+			return test{
+				auth:     a,
+				issuerID: retiredID,
+				err:      errors.New("authority.GetCertificateRevocationList; certificate revocation list " + retiredID + " not found"),
+				code:     http.StatusNotFound,
+			}
+		},
+		"fail/retired-without-key": func(t *testing.T) test {
+			a := testAuthority(t)
+			a.config.CRL = &CRLConfig{Enabled: true}
+			a.x509Intermediates = []*x509.Certificate{retired}
+			return test{
+				auth:     a,
+				issuerID: retiredID,
+				err:      errors.New("authority.GetCertificateRevocationList; certificate revocation list " + retiredID + " not found"),
+				code:     http.StatusNotFound,
+			}
+		},
+		"ok/stored": func(t *testing.T) test {
+			a := testAuthority(t, WithDatabase(&db.MockAuthDB{
+				MGetCRL: func(issuerID string) (*db.CertificateRevocationListInfo, error) {
+					return &db.CertificateRevocationListInfo{
+						Number:    10,
+						ExpiresAt: time.Now().Add(time.Hour),
+						DER:       []byte("crl"),
+					}, nil
+				},
+			}))
+			a.config.CRL = &CRLConfig{Enabled: true}
+			return test{
+				auth: a,
+				der:  []byte("crl"),
+			}
+		},
+		"ok/first": func(t *testing.T) test {
+			a := testAuthority(t, WithDatabase(&db.MockAuthDB{
+				MGetCRL: func(issuerID string) (*db.CertificateRevocationListInfo, error) {
+					return nil, db.ErrNotFound
+				},
+				MGetRevokedCertificates: func() ([]db.RevokedCertificateInfo, error) {
+					return nil, nil
+				},
+				MStoreCRL: func(issuerID string, crl *db.CertificateRevocationListInfo) error {
+					assert.Equals(t, int64(1), crl.Number)
+					return nil
+				},
+			}))
+			a.config.CRL = &CRLConfig{Enabled: true}
+			return test{
+				auth:   a,
+				number: 1,
+			}
+		},
+		"ok/expired": func(t *testing.T) test {
+			a := testAuthority(t, WithDatabase(&db.MockAuthDB{
+				MGetCRL: func(issuerID string) (*db.CertificateRevocationListInfo, error) {
+					return &db.CertificateRevocationListInfo{
+						Number:    10,
+						ExpiresAt: time.Now().Add(-time.Hour),
+						DER:       []byte("crl"),
+					}, nil
+				},
+				MGetRevokedCertificates: func() ([]db.RevokedCertificateInfo, error) {
+					return []db.RevokedCertificateInfo{
+						{Serial: "1234", RevokedAt: revokedAt},
+						{Serial: "5678", RevokedAt: revokedAt, ReasonCode: 1},
+					}, nil
+				},
+				MStoreCRL: func(issuerID string, crl *db.CertificateRevocationListInfo) error {
+					assert.Equals(t, int64(11), crl.Number)
+					return nil
+				},
+			}))
+			a.config.CRL = &CRLConfig{Enabled: true}
+			return test{
+				auth:   a,
+				number: 11,
+				serial: []string{"1234", "5678"},
+			}
+		},
+		"ok/retired": func(t *testing.T) test {
+			a := testAuthority(t, WithDatabase(&db.MockAuthDB{
+				MGetCRL: func(issuerID string) (*db.CertificateRevocationListInfo, error) {
+					assert.Equals(t, retiredID, issuerID)
+					return nil, db.ErrNotFound
+				},
+				MGetRevokedCertificates: func() ([]db.RevokedCertificateInfo, error) {
+					return []db.RevokedCertificateInfo{{Serial: "1234", RevokedAt: revokedAt}}, nil
+				},
+				MStoreCRL: func(issuerID string, crl *db.CertificateRevocationListInfo) error {
+					assert.Equals(t, retiredID, issuerID)
+					assert.Equals(t, int64(1), crl.Number)
+					return nil
+				},
+			}))
+			a.config.CRL = &CRLConfig{Enabled: true}
+			a.x509Intermediates = []*x509.Certificate{retired}
+			a.x509RetiredSigners = map[string]*issuerSigner{
+				retiredID: {issuer: retired, signer: retiredSigner},
+			}
+			return test{
+				auth:     a,
+				issuerID: retiredID,
+				issuer:   retired,
+				number:   1,
+				serial:   []string{"1234"},
+			}
+		},
+	}
+	for name, genTestCase := range tests {
+		t.Run(name, func(t *testing.T) {
+			tc := genTestCase(t)
+			der, err := tc.auth.GetCertificateRevocationList(tc.issuerID)
+			if err != nil {
+				if assert.NotNil(t, tc.err) {
+					sc, ok := err.(errs.StatusCoder)
+					assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+					assert.Equals(t, sc.StatusCode(), tc.code)
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+				return
+			}
+			assert.Nil(t, tc.err)
+			if tc.der != nil {
+				assert.Equals(t, tc.der, der)
+				return
+			}
+
+			crl, err := x509.ParseDERCRL(der)
+			assert.FatalError(t, err)
+			issuer := tc.issuer
+			if issuer == nil {
+				issuer = tc.auth.x509Issuer
+			}
+			assert.FatalError(t, issuer.CheckCRLSignature(crl))
+			assert.Equals(t, 1, crl.TBSCertList.Version)
+			assert.Len(t, len(tc.serial), crl.TBSCertList.RevokedCertificates)
+			for i, rc := range crl.TBSCertList.RevokedCertificates {
+				assert.Equals(t, tc.serial[i], rc.SerialNumber.String())
+				assert.True(t, rc.RevocationTime.Equal(revokedAt))
+			}
+
+			var number *big.Int
+			for _, ext := range crl.TBSCertList.Extensions {
+				if ext.Id.Equal(oidExtensionCRLNumber) {
+					number = new(big.Int)
+					_, err := asn1.Unmarshal(ext.Value, &number)
+					assert.FatalError(t, err)
+				}
+			}
+			if assert.NotNil(t, number) {
+				assert.Equals(t, tc.number, number.Int64())
+			}
+		})
+	}
+}
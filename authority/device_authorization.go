@@ -0,0 +1,62 @@
+package authority
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/errs"
+)
+
+// StartDeviceAuthorization starts an OAuth 2.0 device authorization grant with
+// the identity provider of the OIDC provisioner with the given name.
+func (a *Authority) StartDeviceAuthorization(ctx context.Context, name string) (*provisioner.DeviceAuthorization, error) {
+	p, err := a.loadOIDCProvisioner(name)
+	if err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.StartDeviceAuthorization")
+	}
+	da, err := p.StartDeviceAuthorization(ctx)
+	if err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.StartDeviceAuthorization")
+	}
+	return da, nil
+}
+
+// PollDeviceAuthorization polls the device authorization grant with the given
+// device code, and returns the ID token once the user has completed it. The
+// errors provisioner.ErrAuthorizationPending and provisioner.ErrSlowDown are
+// returned unwrapped while the authorization is pending.
+func (a *Authority) PollDeviceAuthorization(ctx context.Context, name, deviceCode string) (string, error) {
+	p, err := a.loadOIDCProvisioner(name)
+	if err != nil {
+		return "", errs.Wrap(http.StatusInternalServerError, err, "authority.PollDeviceAuthorization")
+	}
+	token, err := p.PollDeviceAuthorization(ctx, deviceCode)
+	switch err {
+	case nil:
+		return token, nil
+	case provisioner.ErrAuthorizationPending, provisioner.ErrSlowDown:
+		return "", err
+	default:
+		return "", errs.Wrap(http.StatusInternalServerError, err, "authority.PollDeviceAuthorization")
+	}
+}
+
+// loadOIDCProvisioner returns the OIDC provisioner with the given name.
+func (a *Authority) loadOIDCProvisioner(name string) (*provisioner.OIDC, error) {
+	if name == "" {
+		return nil, errs.BadRequest("provisioner name cannot be empty")
+	}
+	var list provisioner.List
+	for cursor := ""; ; {
+		list, cursor = a.provisioners.Find(cursor, provisioner.DefaultProvisionersMax)
+		for _, p := range list {
+			if o, ok := p.(*provisioner.OIDC); ok && o.GetName() == name {
+				return o, nil
+			}
+		}
+		if cursor == "" {
+			return nil, errs.NotFound("oidc provisioner %s was not found", name)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package authority
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/errs"
+)
+
+func TestAuthority_loadOIDCProvisioner(t *testing.T) {
+	a := testAuthority(t)
+	oidc := &provisioner.OIDC{
+		Name:     "oidc",
+		Type:     "OIDC",
+		ClientID: "client-id",
+	}
+	assert.FatalError(t, a.provisioners.Store(oidc))
+
+	tests := []struct {
+		name     string
+		provName string
+		want     *provisioner.OIDC
+		code     int
+	}{
+		{"ok", "oidc", oidc, 0},
+		{"fail-empty", "", nil, http.StatusBadRequest},
+		{"fail-notFound", "foo", nil, http.StatusNotFound},
+		{"fail-notOIDC", "Max", nil, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := a.loadOIDCProvisioner(tt.provName)
+			if tt.code == 0 {
+				assert.FatalError(t, err)
+				assert.Equals(t, tt.want, got)
+				return
+			}
+			if assert.Error(t, err) {
+				sc, ok := err.(errs.StatusCoder)
+				assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+				assert.Equals(t, tt.code, sc.StatusCode())
+			}
+			assert.Nil(t, got)
+		})
+	}
+}
+
+func TestAuthority_DeviceAuthorization_disabled(t *testing.T) {
+	a := testAuthority(t)
+	assert.FatalError(t, a.provisioners.Store(&provisioner.OIDC{
+		Name:     "oidc",
+		Type:     "OIDC",
+		ClientID: "client-id",
+	}))
+
+	da, err := a.StartDeviceAuthorization(context.Background(), "oidc")
+	if assert.Error(t, err) {
+		sc, ok := err.(errs.StatusCoder)
+		assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+		assert.Equals(t, http.StatusUnauthorized, sc.StatusCode())
+	}
+	assert.Nil(t, da)
+
+	token, err := a.PollDeviceAuthorization(context.Background(), "oidc", "device-code")
+	if assert.Error(t, err) {
+		sc, ok := err.(errs.StatusCoder)
+		assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+		assert.Equals(t, http.StatusUnauthorized, sc.StatusCode())
+	}
+	assert.Equals(t, "", token)
+
+	_, err = a.PollDeviceAuthorization(context.Background(), "foo", "device-code")
+	if assert.Error(t, err) {
+		sc, ok := err.(errs.StatusCoder)
+		assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+		assert.Equals(t, http.StatusNotFound, sc.StatusCode())
+	}
+}
@@ -0,0 +1,248 @@
+package authority
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/errs"
+	kmsapi "github.com/smallstep/certificates/kms/apiv1"
+	"github.com/smallstep/cli/crypto/pemutil"
+)
+
+// IntermediateConfig represents a retired intermediate certificate.
+//
+// Retired intermediates are not used to sign new certificates, but the
+// certificates issued by them are still accepted for renewal and revocation
+// until they expire. The active intermediate is always the one configured in
+// the crt and key properties. If the key of a retired intermediate is
+// configured, it is used to sign the OCSP responses and the CRL of the
+// certificates issued by it.
+type IntermediateConfig struct {
+	Certificate string `json:"crt"`
+	Key         string `json:"key,omitempty"`
+}
+
+// Validate checks the fields in IntermediateConfig.
+func (c *IntermediateConfig) Validate() error {
+	switch {
+	case c == nil:
+		return errors.New("intermediates cannot contain an empty value")
+	case c.Certificate == "":
+		return errors.New("intermediates.crt cannot be empty")
+	default:
+		return nil
+	}
+}
+
+// issuerSigner contains an intermediate certificate and the signers used to
+// sign the OCSP responses and the CRL of the certificates issued by it.
+type issuerSigner struct {
+	issuer          *x509.Certificate
+	signer          crypto.Signer
+	ocspCertificate *x509.Certificate
+	ocspSigner      crypto.Signer
+}
+
+// x509IssuerID returns the id used to identify the CRL of an intermediate, the
+// hex encoded SHA-256 hash of the certificate.
+func x509IssuerID(crt *x509.Certificate) string {
+	sum := sha256.Sum256(crt.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// initIntermediates loads the retired intermediates in the configuration.
+func (a *Authority) initIntermediates() error {
+	if len(a.x509Intermediates) > 0 {
+		return nil
+	}
+	for _, c := range a.config.Intermediates {
+		crt, err := pemutil.ReadCertificate(c.Certificate)
+		if err != nil {
+			return err
+		}
+		if !crt.IsCA {
+			return errors.Errorf("intermediate %s is not a certificate authority", c.Certificate)
+		}
+		if c.Key != "" {
+			signer, err := a.keyManager.CreateSigner(&kmsapi.CreateSignerRequest{
+				SigningKey: c.Key,
+				Password:   []byte(a.config.Password),
+			})
+			if err != nil {
+				return err
+			}
+			if ok, err := equalPublicKeys(crt.PublicKey, signer.Public()); err != nil {
+				return err
+			} else if !ok {
+				return errors.Errorf("intermediate %s does not match the key %s", c.Certificate, c.Key)
+			}
+			if a.x509RetiredSigners == nil {
+				a.x509RetiredSigners = make(map[string]*issuerSigner)
+			}
+			a.x509RetiredSigners[x509IssuerID(crt)] = &issuerSigner{issuer: crt, signer: signer}
+		}
+		a.x509Intermediates = append(a.x509Intermediates, crt)
+	}
+	return nil
+}
+
+// getX509Signer returns the active intermediate certificate and the signer
+// used to sign X.509 certificates.
+func (a *Authority) getX509Signer() (*x509.Certificate, crypto.Signer) {
+	a.x509Mutex.RLock()
+	defer a.x509Mutex.RUnlock()
+	return a.x509Issuer, a.x509Signer
+}
+
+// GetIntermediateCertificates returns the active intermediate certificate
+// followed by the retired ones.
+func (a *Authority) GetIntermediateCertificates() []*x509.Certificate {
+	a.x509Mutex.RLock()
+	defer a.x509Mutex.RUnlock()
+	return append([]*x509.Certificate{a.x509Issuer}, a.x509Intermediates...)
+}
+
+// getIssuerSigners returns the signers of the active intermediate followed by
+// the ones of the retired intermediates with a key. Expired intermediates are
+// not returned.
+func (a *Authority) getIssuerSigners() []*issuerSigner {
+	a.x509Mutex.RLock()
+	defer a.x509Mutex.RUnlock()
+	signers := []*issuerSigner{{
+		issuer:          a.x509Issuer,
+		signer:          a.x509Signer,
+		ocspCertificate: a.ocspCertificate,
+		ocspSigner:      a.ocspSigner,
+	}}
+	now := time.Now()
+	for _, crt := range a.x509Intermediates {
+		if s, ok := a.x509RetiredSigners[x509IssuerID(crt)]; ok && now.Before(crt.NotAfter) {
+			signers = append(signers, s)
+		}
+	}
+	return signers
+}
+
+// getIssuerSigner returns the signers of the intermediate with the given id,
+// or the ones of the active intermediate if the id is empty. It returns nil if
+// the intermediate is not found.
+func (a *Authority) getIssuerSigner(id string) *issuerSigner {
+	signers := a.getIssuerSigners()
+	if id == "" {
+		return signers[0]
+	}
+	for _, s := range signers {
+		if x509IssuerID(s.issuer) == id {
+			return s
+		}
+	}
+	return nil
+}
+
+// RolloverIntermediate promotes the given certificate and key to be the
+// active intermediate. The key is loaded using the configured key manager, and
+// the previous intermediate is retired, so certificates issued by it can
+// still be renewed until they expire.
+//
+// The rollover only affects the running authority, the configuration file
+// must be updated for the new intermediate to survive a restart or a reload.
+func (a *Authority) RolloverIntermediate(crt *x509.Certificate, key string) error {
+	if crt == nil {
+		return errs.BadRequest("authority.RolloverIntermediate; certificate cannot be empty")
+	}
+	if key == "" {
+		return errs.BadRequest("authority.RolloverIntermediate; key cannot be empty")
+	}
+	if !crt.IsCA {
+		return errs.BadRequest("authority.RolloverIntermediate; certificate is not a certificate authority")
+	}
+
+	// The new intermediate must be signed by one of the roots.
+	pool := x509.NewCertPool()
+	for _, root := range a.rootX509Certs {
+		pool.AddCert(root)
+	}
+	if _, err := crt.Verify(x509.VerifyOptions{
+		Roots:     pool,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}); err != nil {
+		return errs.Wrap(http.StatusBadRequest, err, "authority.RolloverIntermediate; error verifying certificate")
+	}
+
+	signer, err := a.keyManager.CreateSigner(&kmsapi.CreateSignerRequest{
+		SigningKey: key,
+		Password:   []byte(a.config.Password),
+	})
+	if err != nil {
+		return errs.Wrap(http.StatusBadRequest, err, "authority.RolloverIntermediate; error loading key")
+	}
+	if ok, err := equalPublicKeys(crt.PublicKey, signer.Public()); err != nil {
+		return errs.Wrap(http.StatusBadRequest, err, "authority.RolloverIntermediate")
+	} else if !ok {
+		return errs.BadRequest("authority.RolloverIntermediate; certificate does not match the key")
+	}
+
+	a.x509Mutex.Lock()
+	if bytes.Equal(a.x509Issuer.Raw, crt.Raw) {
+		a.x509Mutex.Unlock()
+		return errs.BadRequest("authority.RolloverIntermediate; certificate is already the active intermediate")
+	}
+	intermediates := []*x509.Certificate{a.x509Issuer}
+	for _, c := range a.x509Intermediates {
+		if !bytes.Equal(c.Raw, crt.Raw) {
+			intermediates = append(intermediates, c)
+		}
+	}
+	// The previous intermediate and its OCSP responder keep signing the OCSP
+	// responses and the CRL of the certificates issued by it.
+	if a.x509RetiredSigners == nil {
+		a.x509RetiredSigners = make(map[string]*issuerSigner)
+	}
+	a.x509RetiredSigners[x509IssuerID(a.x509Issuer)] = &issuerSigner{
+		issuer:          a.x509Issuer,
+		signer:          a.x509Signer,
+		ocspCertificate: a.ocspCertificate,
+		ocspSigner:      a.ocspSigner,
+	}
+	delete(a.x509RetiredSigners, x509IssuerID(crt))
+	a.x509Intermediates = intermediates
+	a.x509Issuer = crt
+	a.x509Signer = signer
+	// A delegated OCSP responder signed by the previous intermediate cannot
+	// sign responses for the new one, responses will be signed by the
+	// intermediate until a new responder is configured.
+	if a.ocspCertificate != nil && a.ocspCertificate.CheckSignatureFrom(crt) != nil {
+		a.ocspCertificate = nil
+		a.ocspSigner = nil
+	}
+	a.x509Mutex.Unlock()
+
+	// Sign a new CRL with the new intermediate.
+	if a.config.CRL.IsEnabled() {
+		if err := a.generateCRLs(); err != nil {
+			log.Printf("error generating certificate revocation list: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// equalPublicKeys returns true if both public keys are the same.
+func equalPublicKeys(a, b crypto.PublicKey) (bool, error) {
+	ab, err := x509.MarshalPKIXPublicKey(a)
+	if err != nil {
+		return false, errors.Wrap(err, "error marshaling public key")
+	}
+	bb, err := x509.MarshalPKIXPublicKey(b)
+	if err != nil {
+		return false, errors.Wrap(err, "error marshaling public key")
+	}
+	return bytes.Equal(ab, bb), nil
+}
@@ -0,0 +1,313 @@
+package authority
+
+import (
+	"crypto"
+	"crypto/x509"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/errs"
+	kmsapi "github.com/smallstep/certificates/kms/apiv1"
+	"github.com/smallstep/cli/crypto/keys"
+	"github.com/smallstep/cli/crypto/pemutil"
+	"github.com/smallstep/cli/crypto/x509util"
+)
+
+type mockKeyManager struct {
+	getPublicKey func(req *kmsapi.GetPublicKeyRequest) (crypto.PublicKey, error)
+	createKey    func(req *kmsapi.CreateKeyRequest) (*kmsapi.CreateKeyResponse, error)
+	createSigner func(req *kmsapi.CreateSignerRequest) (crypto.Signer, error)
+}
+
+func (m *mockKeyManager) GetPublicKey(req *kmsapi.GetPublicKeyRequest) (crypto.PublicKey, error) {
+	return m.getPublicKey(req)
+}
+
+func (m *mockKeyManager) CreateKey(req *kmsapi.CreateKeyRequest) (*kmsapi.CreateKeyResponse, error) {
+	return m.createKey(req)
+}
+
+func (m *mockKeyManager) CreateSigner(req *kmsapi.CreateSignerRequest) (crypto.Signer, error) {
+	return m.createSigner(req)
+}
+
+func (m *mockKeyManager) Close() error {
+	return nil
+}
+
+// testIntermediate creates a new root and an intermediate signed by it.
+func testIntermediate(t *testing.T, name string) (*x509.Certificate, *x509.Certificate, crypto.Signer) {
+	rootProfile, err := x509util.NewRootProfile(name + "-root")
+	assert.FatalError(t, err)
+	rootBytes, err := rootProfile.CreateCertificate()
+	assert.FatalError(t, err)
+	root, err := x509.ParseCertificate(rootBytes)
+	assert.FatalError(t, err)
+
+	profile, err := x509util.NewIntermediateProfile(name, root, rootProfile.SubjectPrivateKey())
+	assert.FatalError(t, err)
+	crtBytes, err := profile.CreateCertificate()
+	assert.FatalError(t, err)
+	crt, err := x509.ParseCertificate(crtBytes)
+	assert.FatalError(t, err)
+	return root, crt, profile.SubjectPrivateKey().(crypto.Signer)
+}
+
+func TestIntermediateConfig_Validate(t *testing.T) {
+	tests := map[string]struct {
+		config *IntermediateConfig
+		err    error
+	}{
+		"ok":         {&IntermediateConfig{Certificate: "intermediate.crt"}, nil},
+		"fail/nil":   {nil, errors.New("intermediates cannot contain an empty value")},
+		"fail/empty": {&IntermediateConfig{}, errors.New("intermediates.crt cannot be empty")},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := tc.config.Validate()
+			if tc.err == nil {
+				assert.Nil(t, err)
+			} else if assert.NotNil(t, err) {
+				assert.Equals(t, tc.err.Error(), err.Error())
+			}
+		})
+	}
+}
+
+func TestAuthority_initIntermediates(t *testing.T) {
+	intermediate, err := pemutil.ReadCertificate("testdata/certs/intermediate_ca.crt")
+	assert.FatalError(t, err)
+	_, _, otherSigner := testIntermediate(t, "other-intermediate")
+
+	tests := map[string]struct {
+		intermediates []*IntermediateConfig
+		want          []*x509.Certificate
+		wantSigner    bool
+		wantErr       bool
+	}{
+		"ok":                {[]*IntermediateConfig{{Certificate: "testdata/certs/intermediate_ca.crt"}}, []*x509.Certificate{intermediate}, false, false},
+		"ok/key":            {[]*IntermediateConfig{{Certificate: "testdata/certs/intermediate_ca.crt", Key: "intermediate"}}, []*x509.Certificate{intermediate}, true, false},
+		"ok/empty":          {nil, nil, false, false},
+		"fail/missing":      {[]*IntermediateConfig{{Certificate: "testdata/certs/missing.crt"}}, nil, false, true},
+		"fail/not-ca":       {[]*IntermediateConfig{{Certificate: "testdata/certs/foo.crt"}}, nil, false, true},
+		"fail/missing-key":  {[]*IntermediateConfig{{Certificate: "testdata/certs/intermediate_ca.crt", Key: "missing"}}, nil, false, true},
+		"fail/key-mismatch": {[]*IntermediateConfig{{Certificate: "testdata/certs/intermediate_ca.crt", Key: "other-intermediate"}}, nil, false, true},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			a := testAuthority(t)
+			signer := a.x509Signer
+			a.keyManager = &mockKeyManager{
+				createSigner: func(req *kmsapi.CreateSignerRequest) (crypto.Signer, error) {
+					switch req.SigningKey {
+					case "intermediate":
+						return signer, nil
+					case "other-intermediate":
+						return otherSigner, nil
+					default:
+						return nil, errors.New("key not found")
+					}
+				},
+			}
+			a.config.Intermediates = tc.intermediates
+			if err := a.initIntermediates(); (err != nil) != tc.wantErr {
+				t.Errorf("Authority.initIntermediates() error = %v, wantErr %v", err, tc.wantErr)
+				return
+			}
+			if !tc.wantErr {
+				assert.Equals(t, tc.want, a.x509Intermediates)
+				s, ok := a.x509RetiredSigners[x509IssuerID(intermediate)]
+				assert.Equals(t, tc.wantSigner, ok)
+				if tc.wantSigner {
+					assert.Equals(t, intermediate, s.issuer)
+					assert.Equals(t, signer, s.signer)
+				}
+			}
+		})
+	}
+}
+
+func TestAuthority_RolloverIntermediate(t *testing.T) {
+	root, crt, signer := testIntermediate(t, "new-intermediate")
+	_, otherCrt, otherSigner := testIntermediate(t, "other-intermediate")
+	leaf, err := pemutil.ReadCertificate("testdata/certs/foo.crt")
+	assert.FatalError(t, err)
+
+	keyManager := &mockKeyManager{
+		createSigner: func(req *kmsapi.CreateSignerRequest) (crypto.Signer, error) {
+			switch req.SigningKey {
+			case "new-intermediate":
+				return signer, nil
+			case "other-intermediate":
+				return otherSigner, nil
+			default:
+				return nil, errors.New("key not found")
+			}
+		},
+	}
+
+	type test struct {
+		auth *Authority
+		crt  *x509.Certificate
+		key  string
+		err  error
+		code int
+	}
+	tests := map[string]func(t *testing.T) *test{
+		"ok": func(t *testing.T) *test {
+			a := testAuthority(t, WithX509RootCerts(root))
+			a.keyManager = keyManager
+			return &test{auth: a, crt: crt, key: "new-intermediate"}
+		},
+		"fail/nil-crt": func(t *testing.T) *test {
+			return &test{
+				auth: testAuthority(t), key: "new-intermediate",
+				err:  errors.New("authority.RolloverIntermediate; certificate cannot be empty"),
+				code: http.StatusBadRequest,
+			}
+		},
+		"fail/empty-key": func(t *testing.T) *test {
+			return &test{
+				auth: testAuthority(t), crt: crt,
+				err:  errors.New("authority.RolloverIntermediate; key cannot be empty"),
+				code: http.StatusBadRequest,
+			}
+		},
+		"fail/not-ca": func(t *testing.T) *test {
+			return &test{
+				auth: testAuthority(t), crt: leaf, key: "new-intermediate",
+				err:  errors.New("authority.RolloverIntermediate; certificate is not a certificate authority"),
+				code: http.StatusBadRequest,
+			}
+		},
+		"fail/verify": func(t *testing.T) *test {
+			a := testAuthority(t, WithX509RootCerts(root))
+			a.keyManager = keyManager
+			return &test{
+				auth: a, crt: otherCrt, key: "other-intermediate",
+				err:  errors.New("authority.RolloverIntermediate; error verifying certificate"),
+				code: http.StatusBadRequest,
+			}
+		},
+		"fail/create-signer": func(t *testing.T) *test {
+			a := testAuthority(t, WithX509RootCerts(root))
+			a.keyManager = keyManager
+			return &test{
+				auth: a, crt: crt, key: "missing",
+				err:  errors.New("authority.RolloverIntermediate; error loading key"),
+				code: http.StatusBadRequest,
+			}
+		},
+		"fail/key-mismatch": func(t *testing.T) *test {
+			a := testAuthority(t, WithX509RootCerts(root))
+			a.keyManager = keyManager
+			return &test{
+				auth: a, crt: crt, key: "other-intermediate",
+				err:  errors.New("authority.RolloverIntermediate; certificate does not match the key"),
+				code: http.StatusBadRequest,
+			}
+		},
+		"fail/already-active": func(t *testing.T) *test {
+			a := testAuthority(t, WithX509RootCerts(root), WithX509Signer(crt, signer))
+			a.keyManager = keyManager
+			return &test{
+				auth: a, crt: crt, key: "new-intermediate",
+				err:  errors.New("authority.RolloverIntermediate; certificate is already the active intermediate"),
+				code: http.StatusBadRequest,
+			}
+		},
+	}
+	for name, genTestCase := range tests {
+		t.Run(name, func(t *testing.T) {
+			tc := genTestCase(t)
+			issuer, previousSigner := tc.auth.getX509Signer()
+
+			err := tc.auth.RolloverIntermediate(tc.crt, tc.key)
+			if err != nil {
+				if assert.NotNil(t, tc.err, err.Error()) {
+					sc, ok := err.(errs.StatusCoder)
+					assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+					assert.Equals(t, sc.StatusCode(), tc.code)
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+				// The active intermediate does not change on errors.
+				assert.Equals(t, []*x509.Certificate{issuer}, tc.auth.GetIntermediateCertificates())
+			} else if assert.Nil(t, tc.err) {
+				newIssuer, newSigner := tc.auth.getX509Signer()
+				assert.Equals(t, tc.crt, newIssuer)
+				assert.Equals(t, signer, newSigner)
+				assert.Equals(t, []*x509.Certificate{tc.crt, issuer}, tc.auth.GetIntermediateCertificates())
+				// The retired intermediate keeps its signer for OCSP and CRLs.
+				if s := tc.auth.getIssuerSigner(x509IssuerID(issuer)); assert.NotNil(t, s) {
+					assert.Equals(t, issuer, s.issuer)
+					assert.Equals(t, previousSigner, s.signer)
+				}
+				assert.Equals(t, tc.crt, tc.auth.getIssuerSigner("").issuer)
+			}
+		})
+	}
+}
+
+func TestAuthority_Renew_retiredIntermediate(t *testing.T) {
+	root, crt, signer := testIntermediate(t, "new-intermediate")
+	retiredRoot, err := pemutil.ReadCertificate("testdata/certs/root_ca.crt")
+	assert.FatalError(t, err)
+	a := testAuthority(t, WithX509RootCerts(retiredRoot, root))
+	a.keyManager = &mockKeyManager{
+		createSigner: func(req *kmsapi.CreateSignerRequest) (crypto.Signer, error) {
+			return signer, nil
+		},
+	}
+
+	// Issue a certificate with the current intermediate and retire it.
+	pub, _, err := keys.GenerateDefaultKeyPair()
+	assert.FatalError(t, err)
+	now := time.Now()
+	kid := a.config.AuthorityConfig.Provisioners[0].(*provisioner.JWK).Key.KeyID
+	profile, err := x509util.NewLeafProfile("renew", a.x509Issuer, a.x509Signer,
+		x509util.WithNotBeforeAfterDuration(now, now.Add(time.Hour), 0),
+		x509util.WithPublicKey(pub), x509util.WithHosts("test.smallstep.com"),
+		withProvisionerOID("Max", kid))
+	assert.FatalError(t, err)
+	b, err := profile.CreateCertificate()
+	assert.FatalError(t, err)
+	cert, err := x509.ParseCertificate(b)
+	assert.FatalError(t, err)
+
+	retired := a.x509Issuer
+	assert.FatalError(t, a.RolloverIntermediate(crt, "new-intermediate"))
+
+	// Certificates issued by the retired intermediate can be verified with
+	// the roots used by the CA for client certificates and the chain sent by
+	// the client.
+	roots := x509.NewCertPool()
+	for _, c := range a.GetRootCertificates() {
+		roots.AddCert(c)
+	}
+	intermediates := x509.NewCertPool()
+	intermediates.AddCert(retired)
+	_, err = cert.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	assert.FatalError(t, err)
+
+	p, err := a.LoadProvisionerByCertificate(cert)
+	assert.FatalError(t, err)
+	assert.Equals(t, "Max", p.GetName())
+
+	chain, err := a.Renew(cert)
+	assert.FatalError(t, err)
+	assert.Len(t, 2, chain)
+	assert.Equals(t, crt, chain[1])
+	assert.Equals(t, crt.Subject, chain[0].Issuer)
+	assert.Equals(t, crt.SubjectKeyId, chain[0].AuthorityKeyId)
+	assert.FatalError(t, chain[0].CheckSignatureFrom(crt))
+	assert.NotNil(t, chain[0].CheckSignatureFrom(retired))
+	assert.Equals(t, cert.PublicKey, chain[0].PublicKey)
+}
@@ -0,0 +1,190 @@
+package authority
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/smallstep/certificates/db"
+	"github.com/smallstep/certificates/errs"
+	"github.com/smallstep/certificates/templates"
+	"golang.org/x/crypto/ssh"
+)
+
+// Constants of the OpenSSH key revocation list format, see PROTOCOL.krl in
+// the OpenSSH sources.
+const (
+	krlMagic                 = 0x5353484b524c0a00
+	krlFormatVersion         = 1
+	krlSectionCertificates   = 0x01
+	krlSectionCertSerialList = 0x20
+	krlSectionCertKeyID      = 0x23
+)
+
+// GetSSHKeyRevocationList returns an OpenSSH key revocation list (KRL) with
+// all the revoked SSH certificates. The KRL can be used in the RevokedKeys
+// option of sshd.
+func (a *Authority) GetSSHKeyRevocationList(context.Context) ([]byte, error) {
+	if a.sshCAUserCertSignKey == nil && a.sshCAHostCertSignKey == nil {
+		return nil, errs.NotFound("authority.GetSSHKeyRevocationList; ssh is not configured")
+	}
+	krl, err := a.generateKRL()
+	switch err {
+	case nil:
+		return krl, nil
+	case db.ErrNotImplemented:
+		return nil, errs.NotImplemented("authority.GetSSHKeyRevocationList; no persistence layer configured")
+	default:
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.GetSSHKeyRevocationList")
+	}
+}
+
+// generateKRL creates a new KRL with the revoked SSH certificates signed by
+// any of the SSH CA keys.
+func (a *Authority) generateKRL() ([]byte, error) {
+	revoked, err := a.db.GetRevokedSSHCertificates()
+	if err != nil {
+		return nil, err
+	}
+	return createKRL(a.getSSHCAKeys(), revoked, time.Now()), nil
+}
+
+// getSSHCAKeys returns the user and host keys used, or that will be used,
+// to sign SSH certificates.
+func (a *Authority) getSSHCAKeys() []ssh.PublicKey {
+	roots, _ := a.GetSSHRoots(context.Background())
+	var keys []ssh.PublicKey
+	candidates := append(append([]ssh.PublicKey{}, roots.UserKeys...), roots.HostKeys...)
+	for _, s := range []ssh.Signer{a.sshCAUserCertSignKey, a.sshCAHostCertSignKey} {
+		if s != nil {
+			candidates = append(candidates, s.PublicKey())
+		}
+	}
+	for _, k := range candidates {
+		if !containsSSHKey(keys, k) {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// addSSHKeyRevocationList returns a copy of the templates data with the
+// current KRL in the SSH variables. If there is no persistence layer
+// configured, an empty KRL is used.
+func (a *Authority) addSSHKeyRevocationList(data map[string]interface{}) (map[string]interface{}, error) {
+	vars, ok := data["Step"].(templates.Step)
+	if !ok {
+		return data, nil
+	}
+	krl, err := a.generateKRL()
+	switch err {
+	case nil:
+	case db.ErrNotImplemented:
+		krl = createKRL(nil, nil, time.Now())
+	default:
+		return nil, err
+	}
+	m := make(map[string]interface{}, len(data))
+	for k, v := range data {
+		m[k] = v
+	}
+	vars.SSH.KRL = krl
+	m["Step"] = vars
+	return m, nil
+}
+
+// createKRL creates an OpenSSH key revocation list in binary format. The
+// revoked certificates are added by serial number, and by key id if it was
+// set in the revocation, to the certificates section of each CA key. The
+// version of the KRL is the time of the last revocation.
+func createKRL(caKeys []ssh.PublicKey, revoked []db.RevokedCertificateInfo, now time.Time) []byte {
+	var version int64
+	var serials []uint64
+	var keyIDs []string
+	for _, rci := range revoked {
+		if t := rci.RevokedAt.Unix(); t > version {
+			version = t
+		}
+		// Serial 0 is not valid in a KRL.
+		sn, err := strconv.ParseUint(rci.Serial, 10, 64)
+		if err != nil || sn == 0 {
+			log.Printf("error adding ssh certificate with serial %s to the key revocation list: invalid serial", rci.Serial)
+		} else {
+			serials = append(serials, sn)
+		}
+		if rci.KeyID != "" {
+			keyIDs = append(keyIDs, rci.KeyID)
+		}
+	}
+	sort.Slice(serials, func(i, j int) bool { return serials[i] < serials[j] })
+	sort.Strings(keyIDs)
+
+	var section krlBuffer
+	if len(serials) > 0 {
+		var b krlBuffer
+		for _, sn := range serials {
+			b.putUint64(sn)
+		}
+		section.putByte(krlSectionCertSerialList)
+		section.putString(b.Bytes())
+	}
+	if len(keyIDs) > 0 {
+		var b krlBuffer
+		for _, id := range keyIDs {
+			b.putString([]byte(id))
+		}
+		section.putByte(krlSectionCertKeyID)
+		section.putString(b.Bytes())
+	}
+
+	var krl krlBuffer
+	krl.putUint64(krlMagic)
+	krl.putUint32(krlFormatVersion)
+	krl.putUint64(uint64(version))
+	krl.putUint64(uint64(now.Unix()))
+	krl.putUint64(0)   // flags
+	krl.putString(nil) // reserved
+	krl.putString(nil) // comment
+	if section.Len() > 0 {
+		for _, key := range caKeys {
+			var b krlBuffer
+			b.putString(key.Marshal())
+			b.putString(nil) // reserved
+			b.Write(section.Bytes())
+			krl.putByte(krlSectionCertificates)
+			krl.putString(b.Bytes())
+		}
+	}
+	return krl.Bytes()
+}
+
+// krlBuffer is a bytes.Buffer with methods to write the SSH wire types.
+type krlBuffer struct {
+	bytes.Buffer
+}
+
+func (b *krlBuffer) putByte(v byte) {
+	b.WriteByte(v)
+}
+
+func (b *krlBuffer) putUint32(v uint32) {
+	var buf [4]byte
+	binary.BigEndian.PutUint32(buf[:], v)
+	b.Write(buf[:])
+}
+
+func (b *krlBuffer) putUint64(v uint64) {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], v)
+	b.Write(buf[:])
+}
+
+func (b *krlBuffer) putString(v []byte) {
+	b.putUint32(uint32(len(v)))
+	b.Write(v)
+}
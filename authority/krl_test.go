@@ -0,0 +1,273 @@
+package authority
+
+import (
+	"context"
+	"encoding/binary"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/db"
+	"github.com/smallstep/certificates/errs"
+	"github.com/smallstep/certificates/templates"
+	"golang.org/x/crypto/ssh"
+)
+
+// testKRL is the parsed representation of a KRL.
+type testKRL struct {
+	version   uint64
+	generated uint64
+	sections  map[string]testKRLSection
+}
+
+// testKRLSection contains the revoked certificates of a CA key.
+type testKRLSection struct {
+	serials []uint64
+	keyIDs  []string
+}
+
+type testKRLReader struct {
+	t *testing.T
+	b []byte
+}
+
+func (r *testKRLReader) uint32() uint32 {
+	assert.Fatal(r.t, len(r.b) >= 4, "unexpected end of krl")
+	v := binary.BigEndian.Uint32(r.b)
+	r.b = r.b[4:]
+	return v
+}
+
+func (r *testKRLReader) uint64() uint64 {
+	assert.Fatal(r.t, len(r.b) >= 8, "unexpected end of krl")
+	v := binary.BigEndian.Uint64(r.b)
+	r.b = r.b[8:]
+	return v
+}
+
+func (r *testKRLReader) byte() byte {
+	assert.Fatal(r.t, len(r.b) >= 1, "unexpected end of krl")
+	v := r.b[0]
+	r.b = r.b[1:]
+	return v
+}
+
+func (r *testKRLReader) string() []byte {
+	n := int(r.uint32())
+	assert.Fatal(r.t, len(r.b) >= n, "unexpected end of krl")
+	v := r.b[:n]
+	r.b = r.b[n:]
+	return v
+}
+
+// parseTestKRL parses a KRL following the format in PROTOCOL.krl.
+func parseTestKRL(t *testing.T, b []byte) *testKRL {
+	r := &testKRLReader{t: t, b: b}
+	assert.Equals(t, uint64(krlMagic), r.uint64())
+	assert.Equals(t, uint32(krlFormatVersion), r.uint32())
+	krl := &testKRL{
+		version:   r.uint64(),
+		generated: r.uint64(),
+		sections:  make(map[string]testKRLSection),
+	}
+	assert.Equals(t, uint64(0), r.uint64()) // flags
+	assert.Equals(t, []byte{}, r.string())  // reserved
+	assert.Equals(t, []byte{}, r.string())  // comment
+	for len(r.b) > 0 {
+		assert.Equals(t, byte(krlSectionCertificates), r.byte())
+		sr := &testKRLReader{t: t, b: r.string()}
+		caKey := sr.string()
+		_, err := ssh.ParsePublicKey(caKey)
+		assert.FatalError(t, err)
+		assert.Equals(t, []byte{}, sr.string()) // reserved
+		var section testKRLSection
+		for len(sr.b) > 0 {
+			typ := sr.byte()
+			data := &testKRLReader{t: t, b: sr.string()}
+			switch typ {
+			case krlSectionCertSerialList:
+				for len(data.b) > 0 {
+					section.serials = append(section.serials, data.uint64())
+				}
+			case krlSectionCertKeyID:
+				for len(data.b) > 0 {
+					section.keyIDs = append(section.keyIDs, string(data.string()))
+				}
+			default:
+				t.Fatalf("unexpected certificate section %x", typ)
+			}
+		}
+		krl.sections[string(caKey)] = section
+	}
+	return krl
+}
+
+func Test_createKRL(t *testing.T) {
+	user, _ := mustSSHSigner(t)
+	host, _ := mustSSHSigner(t)
+	now := time.Now()
+	revokedAt := now.Add(-time.Hour)
+
+	revoked := []db.RevokedCertificateInfo{
+		{Serial: "200", RevokedAt: revokedAt.Add(-time.Hour)},
+		{Serial: "100", RevokedAt: revokedAt, KeyID: "bob@smallstep.com"},
+		{Serial: "0", RevokedAt: revokedAt.Add(-2 * time.Hour)},
+		{Serial: "not-a-number", RevokedAt: revokedAt.Add(-2 * time.Hour), KeyID: "alice@smallstep.com"},
+	}
+
+	type test struct {
+		caKeys   []ssh.PublicKey
+		revoked  []db.RevokedCertificateInfo
+		version  uint64
+		sections map[string]testKRLSection
+	}
+	tests := map[string]test{
+		"ok": {[]ssh.PublicKey{user.PublicKey(), host.PublicKey()}, revoked, uint64(revokedAt.Unix()), map[string]testKRLSection{
+			string(user.PublicKey().Marshal()): {[]uint64{100, 200}, []string{"alice@smallstep.com", "bob@smallstep.com"}},
+			string(host.PublicKey().Marshal()): {[]uint64{100, 200}, []string{"alice@smallstep.com", "bob@smallstep.com"}},
+		}},
+		"ok/serials": {[]ssh.PublicKey{user.PublicKey()}, revoked[:1], uint64(revokedAt.Add(-time.Hour).Unix()), map[string]testKRLSection{
+			string(user.PublicKey().Marshal()): {[]uint64{200}, nil},
+		}},
+		"ok/empty":   {[]ssh.PublicKey{user.PublicKey()}, nil, 0, map[string]testKRLSection{}},
+		"ok/no-keys": {nil, revoked, uint64(revokedAt.Unix()), map[string]testKRLSection{}},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			krl := parseTestKRL(t, createKRL(tc.caKeys, tc.revoked, now))
+			assert.Equals(t, tc.version, krl.version)
+			assert.Equals(t, uint64(now.Unix()), krl.generated)
+			if !reflect.DeepEqual(tc.sections, krl.sections) {
+				t.Errorf("createKRL() sections = %v, want %v", krl.sections, tc.sections)
+			}
+		})
+	}
+}
+
+func TestAuthority_GetSSHKeyRevocationList(t *testing.T) {
+	user, _ := mustSSHSigner(t)
+	host, _ := mustSSHSigner(t)
+	revoked := []db.RevokedCertificateInfo{
+		{Serial: "1234", RevokedAt: time.Now(), KeyID: "bob@smallstep.com"},
+	}
+
+	type test struct {
+		db       db.AuthDB
+		user     ssh.Signer
+		host     ssh.Signer
+		sections map[string]testKRLSection
+		err      error
+		code     int
+	}
+	tests := map[string]test{
+		"ok": {&db.MockAuthDB{Ret1: revoked}, user, host, map[string]testKRLSection{
+			string(user.PublicKey().Marshal()): {[]uint64{1234}, []string{"bob@smallstep.com"}},
+			string(host.PublicKey().Marshal()): {[]uint64{1234}, []string{"bob@smallstep.com"}},
+		}, nil, 0},
+		"ok/user": {&db.MockAuthDB{Ret1: revoked}, user, nil, map[string]testKRLSection{
+			string(user.PublicKey().Marshal()): {[]uint64{1234}, []string{"bob@smallstep.com"}},
+		}, nil, 0},
+		"fail/not-configured": {&db.MockAuthDB{Ret1: revoked}, nil, nil, nil,
+			errors.New("authority.GetSSHKeyRevocationList; ssh is not configured"), http.StatusNotFound},
+		"fail/nil-db": {nil, user, host, nil,
+			errors.New("authority.GetSSHKeyRevocationList; no persistence layer configured"), http.StatusNotImplemented},
+		"fail/db-error": {&db.MockAuthDB{Err: errors.New("force")}, user, host, nil,
+			errors.New("authority.GetSSHKeyRevocationList: force"), http.StatusInternalServerError},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			var opts []Option
+			if tc.db != nil {
+				opts = append(opts, WithDatabase(tc.db))
+			}
+			a := testAuthority(t, opts...)
+			a.sshCAUserCertSignKey = tc.user
+			a.sshCAHostCertSignKey = tc.host
+			a.sshCAUserCerts, a.sshCAHostCerts = nil, nil
+			if tc.user != nil {
+				a.sshCAUserCerts = []ssh.PublicKey{tc.user.PublicKey()}
+			}
+			if tc.host != nil {
+				a.sshCAHostCerts = []ssh.PublicKey{tc.host.PublicKey()}
+			}
+
+			b, err := a.GetSSHKeyRevocationList(context.Background())
+			if err != nil {
+				if assert.NotNil(t, tc.err, err.Error()) {
+					sc, ok := err.(errs.StatusCoder)
+					assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+					assert.Equals(t, sc.StatusCode(), tc.code)
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+			} else if assert.Nil(t, tc.err) {
+				krl := parseTestKRL(t, b)
+				if !reflect.DeepEqual(tc.sections, krl.sections) {
+					t.Errorf("Authority.GetSSHKeyRevocationList() sections = %v, want %v", krl.sections, tc.sections)
+				}
+			}
+		})
+	}
+}
+
+func TestAuthority_GetSSHConfig_krl(t *testing.T) {
+	user, _ := mustSSHSigner(t)
+	host, _ := mustSSHSigner(t)
+	revoked := []db.RevokedCertificateInfo{
+		{Serial: "1234", RevokedAt: time.Now()},
+	}
+
+	tmpl := &templates.Templates{
+		SSH: &templates.SSHTemplates{
+			Host: []templates.Template{
+				{Name: "revoked_keys.tpl", Type: templates.File, TemplatePath: "./testdata/templates/revoked_keys.tpl", Path: "/etc/ssh/revoked_keys", Comment: "#"},
+			},
+		},
+		Data: map[string]interface{}{
+			"Step": templates.Step{},
+		},
+	}
+
+	tests := map[string]struct {
+		db       db.AuthDB
+		sections map[string]testKRLSection
+		wantErr  bool
+	}{
+		"ok": {&db.MockAuthDB{Ret1: revoked}, map[string]testKRLSection{
+			string(user.PublicKey().Marshal()): {[]uint64{1234}, nil},
+			string(host.PublicKey().Marshal()): {[]uint64{1234}, nil},
+		}, false},
+		"ok/nil-db":     {nil, map[string]testKRLSection{}, false},
+		"fail/db-error": {&db.MockAuthDB{Err: errors.New("force")}, nil, true},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			var opts []Option
+			if tc.db != nil {
+				opts = append(opts, WithDatabase(tc.db))
+			}
+			a := testAuthority(t, opts...)
+			a.config.Templates = tmpl
+			a.sshCAUserCertSignKey = user
+			a.sshCAHostCertSignKey = host
+			a.sshCAUserCerts = []ssh.PublicKey{user.PublicKey()}
+			a.sshCAHostCerts = []ssh.PublicKey{host.PublicKey()}
+
+			got, err := a.GetSSHConfig(context.Background(), "host", nil)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("Authority.GetSSHConfig() error = %v, wantErr %v", err, tc.wantErr)
+			}
+			if !tc.wantErr {
+				assert.Len(t, 1, got)
+				krl := parseTestKRL(t, got[0].Content)
+				if !reflect.DeepEqual(tc.sections, krl.sections) {
+					t.Errorf("Authority.GetSSHConfig() sections = %v, want %v", krl.sections, tc.sections)
+				}
+				// The configured data is not modified.
+				assert.Equals(t, templates.Step{}, tmpl.Data["Step"])
+			}
+		})
+	}
+}
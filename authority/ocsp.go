@@ -0,0 +1,217 @@
+package authority
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/db"
+	"github.com/smallstep/certificates/errs"
+	kmsapi "github.com/smallstep/certificates/kms/apiv1"
+	"github.com/smallstep/cli/crypto/pemutil"
+	"github.com/smallstep/cli/crypto/x509util"
+	"golang.org/x/crypto/ocsp"
+)
+
+var defaultOCSPLifetime = time.Hour
+
+// OCSPConfig represents the configuration of the OCSP responder.
+//
+// By default OCSP responses are signed by the intermediate. If a certificate
+// and key are configured, responses will be signed by this delegated
+// responder. The certificate must be signed by the intermediate and it must
+// contain the OCSP signing extended key usage.
+type OCSPConfig struct {
+	Certificate string                `json:"crt,omitempty"`
+	Key         string                `json:"key,omitempty"`
+	URLs        []string              `json:"urls,omitempty"`
+	Lifetime    *provisioner.Duration `json:"lifetime,omitempty"`
+}
+
+// Validate checks the fields in OCSPConfig.
+func (c *OCSPConfig) Validate() error {
+	switch {
+	case c == nil:
+		return nil
+	case c.Certificate != "" && c.Key == "":
+		return errors.New("ocsp.key cannot be empty if ocsp.crt is set")
+	case c.Certificate == "" && c.Key != "":
+		return errors.New("ocsp.crt cannot be empty if ocsp.key is set")
+	case c.Lifetime != nil && c.Lifetime.Duration <= 0:
+		return errors.New("ocsp.lifetime must be greater than 0")
+	default:
+		return nil
+	}
+}
+
+// lifetime returns the validity of the OCSP responses.
+func (c *OCSPConfig) lifetime() time.Duration {
+	if c == nil || c.Lifetime == nil {
+		return defaultOCSPLifetime
+	}
+	return c.Lifetime.Duration
+}
+
+// withOCSPServers is an x509util option that adds the OCSP responder urls to
+// the authority information access extension.
+func withOCSPServers(urls []string) x509util.WithOption {
+	return func(p x509util.Profile) error {
+		crt := p.Subject()
+		crt.OCSPServer = append([]string(nil), urls...)
+		return nil
+	}
+}
+
+// initOCSP loads the delegated OCSP responder if one is configured.
+func (a *Authority) initOCSP() error {
+	c := a.config.OCSP
+	if c == nil || c.Certificate == "" || a.ocspSigner != nil {
+		return nil
+	}
+
+	crt, err := pemutil.ReadCertificate(c.Certificate)
+	if err != nil {
+		return err
+	}
+	if err := crt.CheckSignatureFrom(a.x509Issuer); err != nil {
+		return errors.Wrap(err, "ocsp certificate is not signed by the intermediate")
+	}
+	var ok bool
+	for _, eku := range crt.ExtKeyUsage {
+		if eku == x509.ExtKeyUsageOCSPSigning {
+			ok = true
+			break
+		}
+	}
+	if !ok {
+		return errors.New("ocsp certificate does not have the OCSP signing extended key usage")
+	}
+
+	signer, err := a.keyManager.CreateSigner(&kmsapi.CreateSignerRequest{
+		SigningKey: c.Key,
+		Password:   []byte(a.config.Password),
+	})
+	if err != nil {
+		return err
+	}
+
+	a.ocspCertificate = crt
+	a.ocspSigner = signer
+	return nil
+}
+
+// OCSP creates a signed OCSP response (RFC 6960) for the given request. The
+// status of the certificate is calculated using the certificates and
+// revocations stored in the database. Requests for a retired intermediate are
+// signed with its key or its delegated responder.
+func (a *Authority) OCSP(req *ocsp.Request) ([]byte, error) {
+	var opts []interface{}
+	if req.SerialNumber != nil {
+		opts = append(opts, errs.WithKeyVal("serialNumber", req.SerialNumber.String()))
+	}
+
+	// The request can be for the active intermediate or for a retired one
+	// with a key.
+	var s *issuerSigner
+	for _, is := range a.getIssuerSigners() {
+		ok, err := matchOCSPIssuer(req, is.issuer)
+		if err != nil {
+			return nil, errs.Wrap(http.StatusBadRequest, err, "authority.OCSP", opts...)
+		}
+		if ok {
+			s = is
+			break
+		}
+	}
+	if s == nil {
+		return nil, errs.Unauthorized("authority.OCSP; request issuer does not match the certificate authority", opts...)
+	}
+
+	sn := req.SerialNumber.String()
+	now := time.Now().Truncate(time.Minute)
+	template := ocsp.Response{
+		Status:       ocsp.Good,
+		SerialNumber: req.SerialNumber,
+		ThisUpdate:   now,
+		NextUpdate:   now.Add(a.config.OCSP.lifetime()),
+		IssuerHash:   req.HashAlgorithm,
+	}
+
+	// Only certificates issued by this CA can be good or revoked.
+	switch _, err := a.db.GetCertificate(sn); err {
+	case nil:
+	case db.ErrNotFound:
+		template.Status = ocsp.Unknown
+	case db.ErrNotImplemented:
+		return nil, errs.NotImplemented("authority.OCSP; no persistence layer configured", opts...)
+	default:
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.OCSP", opts...)
+	}
+
+	if template.Status == ocsp.Good {
+		isRevoked, err := a.db.IsRevoked(sn)
+		if err != nil {
+			return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.OCSP", opts...)
+		}
+		if isRevoked {
+			rci, err := a.db.GetRevokedCertificate(sn)
+			if err != nil {
+				return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.OCSP", opts...)
+			}
+			template.Status = ocsp.Revoked
+			template.RevokedAt = rci.RevokedAt
+			template.RevocationReason = rci.ReasonCode
+		}
+	}
+
+	// Sign with the delegated responder if configured, or with the
+	// intermediate otherwise.
+	responder, signer := s.issuer, s.signer
+	if s.ocspSigner != nil {
+		responder = s.ocspCertificate
+		signer = s.ocspSigner
+		template.Certificate = s.ocspCertificate
+	}
+
+	b, err := ocsp.CreateResponse(s.issuer, responder, template, signer)
+	if err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err,
+			"authority.OCSP; error creating ocsp response", opts...)
+	}
+	return b, nil
+}
+
+// matchOCSPIssuer returns true if the issuer name and key hashes in the OCSP
+// request match the given issuer.
+func matchOCSPIssuer(req *ocsp.Request, issuer *x509.Certificate) (bool, error) {
+	if req.SerialNumber == nil {
+		return false, errors.New("ocsp request does not contain a serial number")
+	}
+	if req.HashAlgorithm == 0 || !req.HashAlgorithm.Available() {
+		return false, errors.New("ocsp request uses an unsupported hash algorithm")
+	}
+
+	var spki struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(issuer.RawSubjectPublicKeyInfo, &spki); err != nil {
+		return false, errors.Wrap(err, "error parsing issuer public key")
+	}
+
+	nameHash := hashBytes(req.HashAlgorithm, issuer.RawSubject)
+	keyHash := hashBytes(req.HashAlgorithm, spki.PublicKey.RightAlign())
+	return bytes.Equal(nameHash, req.IssuerNameHash) && bytes.Equal(keyHash, req.IssuerKeyHash), nil
+}
+
+func hashBytes(h crypto.Hash, b []byte) []byte {
+	hh := h.New()
+	hh.Write(b)
+	return hh.Sum(nil)
+}
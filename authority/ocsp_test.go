@@ -0,0 +1,211 @@
+package authority
+
+import (
+	"crypto"
+	"crypto/x509"
+	"math/big"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/db"
+	"github.com/smallstep/certificates/errs"
+	"github.com/smallstep/cli/crypto/pemutil"
+	"golang.org/x/crypto/ocsp"
+)
+
+func TestOCSPConfig_Validate(t *testing.T) {
+	tests := map[string]struct {
+		config *OCSPConfig
+		err    error
+	}{
+		"ok/nil":   {nil, nil},
+		"ok/empty": {&OCSPConfig{}, nil},
+		"ok/delegated": {&OCSPConfig{
+			Certificate: "ocsp.crt",
+			Key:         "ocsp.key",
+		}, nil},
+		"fail/missing-key":       {&OCSPConfig{Certificate: "ocsp.crt"}, errors.New("ocsp.key cannot be empty if ocsp.crt is set")},
+		"fail/missing-crt":       {&OCSPConfig{Key: "ocsp.key"}, errors.New("ocsp.crt cannot be empty if ocsp.key is set")},
+		"fail/negative-lifetime": {&OCSPConfig{Lifetime: &provisioner.Duration{Duration: -time.Minute}}, errors.New("ocsp.lifetime must be greater than 0")},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if err := tc.config.Validate(); err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.Equals(t, tc.err.Error(), err.Error())
+				}
+			} else {
+				assert.Nil(t, tc.err)
+			}
+		})
+	}
+}
+
+func TestAuthority_OCSP(t *testing.T) {
+	a := testAuthority(t)
+	serial := big.NewInt(1234)
+	newRequest := func(t *testing.T, issuer *x509.Certificate, h crypto.Hash) *ocsp.Request {
+		der, err := ocsp.CreateRequest(&x509.Certificate{SerialNumber: serial}, issuer, &ocsp.RequestOptions{Hash: h})
+		assert.FatalError(t, err)
+		req, err := ocsp.ParseRequest(der)
+		assert.FatalError(t, err)
+		return req
+	}
+	root, err := pemutil.ReadCertificate("testdata/certs/root_ca.crt")
+	assert.FatalError(t, err)
+	revokedAt := time.Now().Add(-time.Hour).UTC().Truncate(time.Second)
+	_, retired, retiredSigner := testIntermediate(t, "retired-intermediate")
+	goodDB := &db.MockAuthDB{
+		MGetCertificate: func(sn string) (*x509.Certificate, error) {
+			return &x509.Certificate{SerialNumber: serial}, nil
+		},
+		MIsRevoked: func(sn string) (bool, error) {
+			return false, nil
+		},
+	}
+
+	type test struct {
+		auth   *Authority
+		req    *ocsp.Request
+		issuer *x509.Certificate
+		status int
+		reason int
+		err    error
+		code   int
+	}
+	tests := map[string]func(t *testing.T) test{
+		"fail/bad-issuer": func(t *testing.T) test {
+			return test{
+				auth: a,
+				req:  newRequest(t, root, crypto.SHA1),
+				err:  errors.New("authority.OCSP; request issuer does not match the certificate authority"),
+				code: http.StatusUnauthorized,
+			}
+		},
+		"fail/retired-without-key": func(t *testing.T) test {
+			a := testAuthority(t, WithDatabase(goodDB))
+			a.x509Intermediates = []*x509.Certificate{retired}
+			return test{
+				auth: a,
+				req:  newRequest(t, retired, crypto.SHA1),
+				err:  errors.New("authority.OCSP; request issuer does not match the certificate authority"),
+				code: http.StatusUnauthorized,
+			}
+		},
+		"fail/no-db": func(t *testing.T) test {
+			return test{
+				auth: a,
+				req:  newRequest(t, a.x509Issuer, crypto.SHA1),
+				err:  errors.New("authority.OCSP; no persistence layer configured"),
+				code: http.StatusNotImplemented,
+			}
+		},
+		"fail/db-error": func(t *testing.T) test {
+			return test{
+				auth: testAuthority(t, WithDatabase(&db.MockAuthDB{
+					MGetCertificate: func(sn string) (*x509.Certificate, error) {
+						return nil, errors.New("force")
+					},
+				})),
+				req:  newRequest(t, a.x509Issuer, crypto.SHA1),
+				err:  errors.New("authority.OCSP: force"),
+				code: http.StatusInternalServerError,
+			}
+		},
+		"ok/unknown": func(t *testing.T) test {
+			return test{
+				auth: testAuthority(t, WithDatabase(&db.MockAuthDB{
+					MGetCertificate: func(sn string) (*x509.Certificate, error) {
+						return nil, db.ErrNotFound
+					},
+				})),
+				req:    newRequest(t, a.x509Issuer, crypto.SHA1),
+				status: ocsp.Unknown,
+			}
+		},
+		"ok/good": func(t *testing.T) test {
+			return test{
+				auth: testAuthority(t, WithDatabase(&db.MockAuthDB{
+					MGetCertificate: func(sn string) (*x509.Certificate, error) {
+						assert.Equals(t, "1234", sn)
+						return &x509.Certificate{SerialNumber: serial}, nil
+					},
+					MIsRevoked: func(sn string) (bool, error) {
+						return false, nil
+					},
+				})),
+				req:    newRequest(t, a.x509Issuer, crypto.SHA256),
+				status: ocsp.Good,
+			}
+		},
+		"ok/revoked": func(t *testing.T) test {
+			return test{
+				auth: testAuthority(t, WithDatabase(&db.MockAuthDB{
+					MGetCertificate: func(sn string) (*x509.Certificate, error) {
+						return &x509.Certificate{SerialNumber: serial}, nil
+					},
+					MIsRevoked: func(sn string) (bool, error) {
+						return true, nil
+					},
+					MGetRevokedCertificate: func(sn string) (*db.RevokedCertificateInfo, error) {
+						return &db.RevokedCertificateInfo{
+							Serial:     sn,
+							ReasonCode: ocsp.KeyCompromise,
+							RevokedAt:  revokedAt,
+						}, nil
+					},
+				})),
+				req:    newRequest(t, a.x509Issuer, crypto.SHA1),
+				status: ocsp.Revoked,
+				reason: ocsp.KeyCompromise,
+			}
+		},
+		"ok/retired": func(t *testing.T) test {
+			a := testAuthority(t, WithDatabase(goodDB))
+			a.x509Intermediates = []*x509.Certificate{retired}
+			a.x509RetiredSigners = map[string]*issuerSigner{
+				x509IssuerID(retired): {issuer: retired, signer: retiredSigner},
+			}
+			return test{
+				auth:   a,
+				req:    newRequest(t, retired, crypto.SHA256),
+				issuer: retired,
+				status: ocsp.Good,
+			}
+		},
+	}
+	for name, genTestCase := range tests {
+		t.Run(name, func(t *testing.T) {
+			tc := genTestCase(t)
+			b, err := tc.auth.OCSP(tc.req)
+			if err != nil {
+				if assert.NotNil(t, tc.err) {
+					sc, ok := err.(errs.StatusCoder)
+					assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+					assert.Equals(t, sc.StatusCode(), tc.code)
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+				return
+			}
+			assert.Nil(t, tc.err)
+
+			issuer := tc.issuer
+			if issuer == nil {
+				issuer = tc.auth.x509Issuer
+			}
+			res, err := ocsp.ParseResponse(b, issuer)
+			assert.FatalError(t, err)
+			assert.Equals(t, tc.status, res.Status)
+			assert.Equals(t, serial, res.SerialNumber)
+			assert.True(t, res.NextUpdate.After(res.ThisUpdate))
+			if tc.status == ocsp.Revoked {
+				assert.Equals(t, tc.reason, res.RevocationReason)
+				assert.Equals(t, revokedAt, res.RevokedAt)
+			}
+		})
+	}
+}
@@ -3,19 +3,285 @@ package provisioner
 import (
 	"context"
 	"crypto/x509"
+	"encoding/base64"
+	"net"
+	"regexp"
+	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/smallstep/certificates/errs"
 )
 
+// Default options used in the validation of ACME challenges.
+var (
+	DefaultACMEValidationWorkers    = 10
+	DefaultACMEValidationTimeout    = 30 * time.Second
+	DefaultACMEValidationMinBackoff = 5 * time.Second
+	DefaultACMEValidationMaxBackoff = 2 * time.Minute
+)
+
+// ACMEValidation contains the options used to validate the ACME challenges.
+// Challenges are validated asynchronously by a pool of workers, failed
+// validations are retried with an exponential backoff until the authorization
+// expires.
+//
+// The DNS lookups done during the validation use the system resolver unless a
+// Resolver is configured.
+type ACMEValidation struct {
+	Workers    int           `json:"workers,omitempty"`
+	Timeout    *Duration     `json:"timeout,omitempty"`
+	MinBackoff *Duration     `json:"minBackoff,omitempty"`
+	MaxBackoff *Duration     `json:"maxBackoff,omitempty"`
+	Resolver   *ACMEResolver `json:"resolver,omitempty"`
+}
+
+// Validate validates the ACME validation options.
+func (v *ACMEValidation) Validate() error {
+	switch {
+	case v == nil:
+		return nil
+	case v.Workers < 0:
+		return errors.New("validation workers cannot be negative")
+	case v.Timeout != nil && v.Timeout.Duration <= 0:
+		return errors.New("validation timeout must be greater than 0")
+	case v.MinBackoff != nil && v.MinBackoff.Duration <= 0:
+		return errors.New("validation minBackoff must be greater than 0")
+	case v.MaxBackoff != nil && v.MaxBackoff.Duration <= 0:
+		return errors.New("validation maxBackoff must be greater than 0")
+	case v.GetMinBackoff() > v.GetMaxBackoff():
+		return errors.New("validation minBackoff cannot be greater than maxBackoff")
+	default:
+		return v.Resolver.Validate()
+	}
+}
+
+// GetWorkers returns the number of workers used to validate challenges.
+func (v *ACMEValidation) GetWorkers() int {
+	if v == nil || v.Workers == 0 {
+		return DefaultACMEValidationWorkers
+	}
+	return v.Workers
+}
+
+// GetTimeout returns the timeout of every validation attempt.
+func (v *ACMEValidation) GetTimeout() time.Duration {
+	if v == nil || v.Timeout == nil {
+		return DefaultACMEValidationTimeout
+	}
+	return v.Timeout.Duration
+}
+
+// GetMinBackoff returns the time to wait before the first retry of a failed
+// validation.
+func (v *ACMEValidation) GetMinBackoff() time.Duration {
+	if v == nil || v.MinBackoff == nil {
+		return DefaultACMEValidationMinBackoff
+	}
+	return v.MinBackoff.Duration
+}
+
+// GetMaxBackoff returns the maximum time to wait between retries of a failed
+// validation.
+func (v *ACMEValidation) GetMaxBackoff() time.Duration {
+	if v == nil || v.MaxBackoff == nil {
+		return DefaultACMEValidationMaxBackoff
+	}
+	return v.MaxBackoff.Duration
+}
+
+// GetResolver returns the DNS resolver options used in the validation, it
+// returns nil if the system resolver must be used.
+func (v *ACMEValidation) GetResolver() *ACMEResolver {
+	if v == nil {
+		return nil
+	}
+	return v.Resolver
+}
+
+// ACMEResolver contains the options of the DNS resolver used in the
+// validation of ACME challenges.
+//
+// Nameservers is the list of DNS servers to query, using the given Network,
+// "udp" or "tcp", by default "udp". The port 53 is used if a nameserver does
+// not specify one. If FollowCNAME is set, the dns-01 challenge will follow
+// CNAME records, allowing the delegation of the _acme-challenge records to a
+// different zone.
+type ACMEResolver struct {
+	Nameservers []string `json:"nameservers,omitempty"`
+	Network     string   `json:"network,omitempty"`
+	FollowCNAME bool     `json:"followCNAME,omitempty"`
+}
+
+// Validate validates the resolver options.
+func (r *ACMEResolver) Validate() error {
+	if r == nil {
+		return nil
+	}
+	switch r.Network {
+	case "", "udp", "tcp":
+	default:
+		return errors.Errorf("validation resolver network %s is not valid, it must be udp or tcp", r.Network)
+	}
+	for _, ns := range r.Nameservers {
+		if ns == "" {
+			return errors.New("validation resolver nameservers cannot contain empty values")
+		}
+		host, _, err := net.SplitHostPort(nameserverAddress(ns))
+		if err != nil || (strings.Contains(host, ":") && net.ParseIP(host) == nil) {
+			return errors.Errorf("validation resolver nameserver %s is not valid", ns)
+		}
+	}
+	return nil
+}
+
+// GetNetwork returns the network used to connect to the nameservers.
+func (r *ACMEResolver) GetNetwork() string {
+	if r == nil || r.Network == "" {
+		return "udp"
+	}
+	return r.Network
+}
+
+// GetNameservers returns the addresses of the nameservers, adding the default
+// port 53 if necessary.
+func (r *ACMEResolver) GetNameservers() []string {
+	if r == nil {
+		return nil
+	}
+	addrs := make([]string, len(r.Nameservers))
+	for i, ns := range r.Nameservers {
+		addrs[i] = nameserverAddress(ns)
+	}
+	return addrs
+}
+
+// nameserverAddress returns the address of a nameserver, adding the default
+// port 53 if the port is missing.
+func nameserverAddress(ns string) string {
+	if _, _, err := net.SplitHostPort(ns); err == nil {
+		return ns
+	}
+	return net.JoinHostPort(strings.Trim(ns, "[]"), "53")
+}
+
+// ACMEPolicy contains the rules that the identifiers of an ACME order must
+// satisfy.
+//
+// AllowedDomains and DeniedDomains are lists of domain suffixes, "example.com"
+// matches example.com and all its subdomains, while ".example.com" only
+// matches the subdomains. AllowedRegexes and DeniedRegexes are regular
+// expressions matched against the full identifier value, for both dns and ip
+// identifiers. Denied rules have precedence, and if any allowed rule is
+// configured, identifiers must match at least one of them. Wildcard names are
+// rejected if DenyWildcards is set.
+type ACMEPolicy struct {
+	AllowedDomains []string `json:"allowedDomains,omitempty"`
+	DeniedDomains  []string `json:"deniedDomains,omitempty"`
+	AllowedRegexes []string `json:"allowedRegexes,omitempty"`
+	DeniedRegexes  []string `json:"deniedRegexes,omitempty"`
+	DenyWildcards  bool     `json:"denyWildcards,omitempty"`
+	allowedRegexes []*regexp.Regexp
+	deniedRegexes  []*regexp.Regexp
+}
+
+// Init validates the policy and compiles the regular expressions.
+func (p *ACMEPolicy) Init() (err error) {
+	if p == nil {
+		return nil
+	}
+	for _, d := range append(append([]string{}, p.AllowedDomains...), p.DeniedDomains...) {
+		if d = normalizeDomain(d); d == "" || d == "." {
+			return errors.New("policy domains cannot contain empty values")
+		}
+	}
+	if p.allowedRegexes, err = compileRegexes(p.AllowedRegexes); err != nil {
+		return err
+	}
+	if p.deniedRegexes, err = compileRegexes(p.DeniedRegexes); err != nil {
+		return err
+	}
+	return nil
+}
+
+// AuthorizeIdentifier returns an error if the policy does not allow an
+// identifier with the given type and value.
+func (p *ACMEPolicy) AuthorizeIdentifier(typ, value string) error {
+	if p == nil {
+		return nil
+	}
+	isDNS := typ == "dns"
+	name := value
+	if isDNS {
+		name = normalizeDomain(value)
+		if p.DenyWildcards && strings.HasPrefix(name, "*.") {
+			return errors.Errorf("wildcard name %s is not allowed", value)
+		}
+		for _, d := range p.DeniedDomains {
+			if matchDomain(name, d) || matchWildcardParent(name, d) {
+				return errors.Errorf("%s %s is denied", typ, value)
+			}
+		}
+	}
+	for _, re := range p.deniedRegexes {
+		if re.MatchString(name) {
+			return errors.Errorf("%s %s is denied", typ, value)
+		}
+	}
+
+	if len(p.AllowedDomains) == 0 && len(p.allowedRegexes) == 0 {
+		return nil
+	}
+	if isDNS {
+		for _, d := range p.AllowedDomains {
+			if matchDomain(name, d) {
+				return nil
+			}
+		}
+	}
+	for _, re := range p.allowedRegexes {
+		if re.MatchString(name) {
+			return nil
+		}
+	}
+	return errors.Errorf("%s %s is not allowed", typ, value)
+}
+
+// compileRegexes compiles a list of regular expressions.
+func compileRegexes(exprs []string) ([]*regexp.Regexp, error) {
+	res := make([]*regexp.Regexp, len(exprs))
+	for i, expr := range exprs {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error compiling policy regex %s", expr)
+		}
+		res[i] = re
+	}
+	return res, nil
+}
+
 // ACME is the acme provisioner type, an entity that can authorize the ACME
 // provisioning flow.
+//
+// If RequireEAB is set, new ACME accounts must contain an external account
+// binding (RFC 8555 §7.3.4) signed with one of the EABKeys. EABKeys is a map
+// between the key identifier and the base64url encoded HMAC key.
+//
+// Policy restricts the identifiers that can be ordered using the provisioner.
 type ACME struct {
 	*base
-	Type    string  `json:"type"`
-	Name    string  `json:"name"`
-	Claims  *Claims `json:"claims,omitempty"`
-	claimer *Claimer
+	Type            string            `json:"type"`
+	Name            string            `json:"name"`
+	RequireEAB      bool              `json:"requireEAB,omitempty"`
+	EABKeys         map[string]string `json:"eabKeys,omitempty"`
+	Validation      *ACMEValidation   `json:"validation,omitempty"`
+	Policy          *ACMEPolicy       `json:"policy,omitempty"`
+	Claims          *Claims           `json:"claims,omitempty"`
+	NameConstraints *NameConstraints  `json:"nameConstraints,omitempty"`
+	Templates       *Templates        `json:"templates,omitempty"`
+	claimer         *Claimer
+	nameConstraints nameConstraintsValidator
+	eabKeys         map[string][]byte
 }
 
 // GetID returns the provisioner unique identifier.
@@ -52,6 +318,40 @@ func (p *ACME) Init(config Config) (err error) {
 		return errors.New("provisioner name cannot be empty")
 	}
 
+	// Decode the external account binding keys
+	p.eabKeys = make(map[string][]byte, len(p.EABKeys))
+	for kid, key := range p.EABKeys {
+		b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(key, "="))
+		if err != nil {
+			return errors.Wrapf(err, "error decoding eab key %s", kid)
+		}
+		if len(b) == 0 {
+			return errors.Errorf("eab key %s cannot be empty", kid)
+		}
+		p.eabKeys[kid] = b
+	}
+	if p.RequireEAB && len(p.eabKeys) == 0 {
+		return errors.New("provisioner eabKeys cannot be empty if requireEAB is set")
+	}
+
+	if err := p.Validation.Validate(); err != nil {
+		return err
+	}
+	if err := p.Policy.Init(); err != nil {
+		return err
+	}
+
+	// Initialize the name constraints with the global ones
+	if err = p.NameConstraints.Init(); err != nil {
+		return err
+	}
+	p.nameConstraints = newNameConstraintsValidator(p.NameConstraints, config.NameConstraints)
+
+	// Initialize the certificate templates
+	if err = p.Templates.Init(); err != nil {
+		return err
+	}
+
 	// Update claims with global ones
 	if p.claimer, err = NewClaimer(p.Claims, config.Claims); err != nil {
 		return err
@@ -60,6 +360,25 @@ func (p *ACME) Init(config Config) (err error) {
 	return err
 }
 
+// IsExternalAccountRequired returns true if new accounts must contain an
+// external account binding.
+func (p *ACME) IsExternalAccountRequired() bool {
+	return p.RequireEAB
+}
+
+// GetExternalAccountKey returns the HMAC key used to verify the external
+// account bindings with the given key identifier.
+func (p *ACME) GetExternalAccountKey(kid string) ([]byte, bool) {
+	key, ok := p.eabKeys[kid]
+	return key, ok
+}
+
+// AuthorizeIdentifier returns an error if the policy of the provisioner does
+// not allow an identifier with the given type and value.
+func (p *ACME) AuthorizeIdentifier(typ, value string) error {
+	return p.Policy.AuthorizeIdentifier(typ, value)
+}
+
 // AuthorizeSign does not do any validation, because all validation is handled
 // in the ACME protocol. This method returns a list of modifiers / constraints
 // on the resulting certificate.
@@ -71,6 +390,9 @@ func (p *ACME) AuthorizeSign(ctx context.Context, token string) ([]SignOption, e
 		// validators
 		defaultPublicKeyValidator{},
 		newValidityValidator(p.claimer.MinTLSCertDuration(), p.claimer.MaxTLSCertDuration()),
+		p.nameConstraints,
+		// template modifiers
+		newX509TemplateModifier(p.Templates, token),
 	}, nil
 }
 
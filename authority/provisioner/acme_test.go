@@ -64,11 +64,71 @@ func TestACME_Init(t *testing.T) {
 				err: errors.New("claims: DefaultTLSCertDuration must be greater than 0"),
 			}
 		},
+		"fail-bad-eab-key": func(t *testing.T) ProvisionerValidateTest {
+			return ProvisionerValidateTest{
+				p:   &ACME{Name: "foo", Type: "bar", EABKeys: map[string]string{"kid": "!!"}},
+				err: errors.New("error decoding eab key kid: illegal base64 data at input byte 0"),
+			}
+		},
+		"fail-empty-eab-key": func(t *testing.T) ProvisionerValidateTest {
+			return ProvisionerValidateTest{
+				p:   &ACME{Name: "foo", Type: "bar", EABKeys: map[string]string{"kid": ""}},
+				err: errors.New("eab key kid cannot be empty"),
+			}
+		},
+		"fail-require-eab": func(t *testing.T) ProvisionerValidateTest {
+			return ProvisionerValidateTest{
+				p:   &ACME{Name: "foo", Type: "bar", RequireEAB: true},
+				err: errors.New("provisioner eabKeys cannot be empty if requireEAB is set"),
+			}
+		},
+		"fail-validation": func(t *testing.T) ProvisionerValidateTest {
+			return ProvisionerValidateTest{
+				p:   &ACME{Name: "foo", Type: "bar", Validation: &ACMEValidation{Workers: -1}},
+				err: errors.New("validation workers cannot be negative"),
+			}
+		},
+		"fail-policy-domain": func(t *testing.T) ProvisionerValidateTest {
+			return ProvisionerValidateTest{
+				p:   &ACME{Name: "foo", Type: "bar", Policy: &ACMEPolicy{AllowedDomains: []string{"."}}},
+				err: errors.New("policy domains cannot contain empty values"),
+			}
+		},
+		"fail-policy-regex": func(t *testing.T) ProvisionerValidateTest {
+			return ProvisionerValidateTest{
+				p:   &ACME{Name: "foo", Type: "bar", Policy: &ACMEPolicy{DeniedRegexes: []string{"["}}},
+				err: errors.New("error compiling policy regex [: error parsing regexp: missing closing ]: `[`"),
+			}
+		},
 		"ok": func(t *testing.T) ProvisionerValidateTest {
 			return ProvisionerValidateTest{
 				p: &ACME{Name: "foo", Type: "bar"},
 			}
 		},
+		"ok-policy": func(t *testing.T) ProvisionerValidateTest {
+			return ProvisionerValidateTest{
+				p: &ACME{Name: "foo", Type: "bar", Policy: &ACMEPolicy{
+					AllowedDomains: []string{"example.com"},
+					AllowedRegexes: []string{`^10\.`},
+					DenyWildcards:  true,
+				}},
+			}
+		},
+		"ok-validation": func(t *testing.T) ProvisionerValidateTest {
+			return ProvisionerValidateTest{
+				p: &ACME{Name: "foo", Type: "bar", Validation: &ACMEValidation{
+					Workers: 2, Timeout: &Duration{time.Second},
+				}},
+			}
+		},
+		"ok-eab": func(t *testing.T) ProvisionerValidateTest {
+			return ProvisionerValidateTest{
+				p: &ACME{Name: "foo", Type: "bar", RequireEAB: true, EABKeys: map[string]string{
+					"kid1": "c2VjcmV0",
+					"kid2": "c2VjcmV0Mg==",
+				}},
+			}
+		},
 	}
 
 	config := Config{
@@ -90,6 +150,21 @@ func TestACME_Init(t *testing.T) {
 	}
 }
 
+func TestACME_GetExternalAccountKey(t *testing.T) {
+	p := &ACME{Name: "foo", Type: "ACME", RequireEAB: true, EABKeys: map[string]string{
+		"kid": "c2VjcmV0",
+	}}
+	assert.FatalError(t, p.Init(Config{Claims: globalProvisionerClaims, Audiences: testAudiences}))
+	assert.True(t, p.IsExternalAccountRequired())
+
+	key, ok := p.GetExternalAccountKey("kid")
+	assert.True(t, ok)
+	assert.Equals(t, []byte("secret"), key)
+
+	_, ok = p.GetExternalAccountKey("foo")
+	assert.False(t, ok)
+}
+
 func TestACME_AuthorizeRenew(t *testing.T) {
 	type test struct {
 		p    *ACME
@@ -168,7 +243,7 @@ func TestACME_AuthorizeSign(t *testing.T) {
 				}
 			} else {
 				if assert.Nil(t, tc.err) && assert.NotNil(t, opts) {
-					assert.Len(t, 4, opts)
+					assert.Len(t, 6, opts)
 					for _, o := range opts {
 						switch v := o.(type) {
 						case *provisionerExtensionOption:
@@ -179,6 +254,8 @@ func TestACME_AuthorizeSign(t *testing.T) {
 						case profileDefaultDuration:
 							assert.Equals(t, time.Duration(v), tc.p.claimer.DefaultTLSCertDuration())
 						case defaultPublicKeyValidator:
+						case nameConstraintsValidator:
+						case *x509TemplateModifier:
 						case *validityValidator:
 							assert.Equals(t, v.min, tc.p.claimer.MinTLSCertDuration())
 							assert.Equals(t, v.max, tc.p.claimer.MaxTLSCertDuration())
@@ -191,3 +268,168 @@ func TestACME_AuthorizeSign(t *testing.T) {
 		})
 	}
 }
+
+func TestACMEValidation_Validate(t *testing.T) {
+	tests := map[string]struct {
+		v   *ACMEValidation
+		err error
+	}{
+		"ok/nil":     {nil, nil},
+		"ok/empty":   {&ACMEValidation{}, nil},
+		"ok/backoff": {&ACMEValidation{MinBackoff: &Duration{time.Second}, MaxBackoff: &Duration{time.Minute}}, nil},
+		"fail/workers": {&ACMEValidation{Workers: -1},
+			errors.New("validation workers cannot be negative")},
+		"fail/timeout": {&ACMEValidation{Timeout: &Duration{-time.Second}},
+			errors.New("validation timeout must be greater than 0")},
+		"fail/min-backoff": {&ACMEValidation{MinBackoff: &Duration{0}},
+			errors.New("validation minBackoff must be greater than 0")},
+		"fail/max-backoff": {&ACMEValidation{MaxBackoff: &Duration{-time.Minute}},
+			errors.New("validation maxBackoff must be greater than 0")},
+		"fail/backoff": {&ACMEValidation{MinBackoff: &Duration{time.Hour}},
+			errors.New("validation minBackoff cannot be greater than maxBackoff")},
+		"ok/resolver": {&ACMEValidation{Resolver: &ACMEResolver{Nameservers: []string{"1.1.1.1", "[::1]:5353"}, Network: "tcp"}}, nil},
+		"fail/resolver-network": {&ACMEValidation{Resolver: &ACMEResolver{Network: "ip"}},
+			errors.New("validation resolver network ip is not valid, it must be udp or tcp")},
+		"fail/resolver-empty": {&ACMEValidation{Resolver: &ACMEResolver{Nameservers: []string{""}}},
+			errors.New("validation resolver nameservers cannot contain empty values")},
+		"fail/resolver-nameserver": {&ACMEValidation{Resolver: &ACMEResolver{Nameservers: []string{"1.1.1.1:53:53"}}},
+			errors.New("validation resolver nameserver 1.1.1.1:53:53 is not valid")},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if err := tc.v.Validate(); err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.Equals(t, tc.err.Error(), err.Error())
+				}
+			} else {
+				assert.Nil(t, tc.err)
+			}
+		})
+	}
+}
+
+func TestACMEValidation_Getters(t *testing.T) {
+	var v *ACMEValidation
+	assert.Equals(t, DefaultACMEValidationWorkers, v.GetWorkers())
+	assert.Equals(t, DefaultACMEValidationTimeout, v.GetTimeout())
+	assert.Equals(t, DefaultACMEValidationMinBackoff, v.GetMinBackoff())
+	assert.Equals(t, DefaultACMEValidationMaxBackoff, v.GetMaxBackoff())
+
+	v = &ACMEValidation{
+		Workers:    3,
+		Timeout:    &Duration{time.Second},
+		MinBackoff: &Duration{2 * time.Second},
+		MaxBackoff: &Duration{time.Minute},
+	}
+	assert.Equals(t, 3, v.GetWorkers())
+	assert.Equals(t, time.Second, v.GetTimeout())
+	assert.Equals(t, 2*time.Second, v.GetMinBackoff())
+	assert.Equals(t, time.Minute, v.GetMaxBackoff())
+	assert.Nil(t, v.GetResolver())
+}
+
+func TestACMEResolver_Getters(t *testing.T) {
+	var r *ACMEResolver
+	assert.Equals(t, "udp", r.GetNetwork())
+	assert.Len(t, 0, r.GetNameservers())
+
+	r = &ACMEResolver{
+		Nameservers: []string{"1.1.1.1", "1.1.1.1:5353", "ns.example.com", "::1", "[::1]", "[::1]:5353"},
+		Network:     "tcp",
+	}
+	assert.Equals(t, "tcp", r.GetNetwork())
+	assert.Equals(t, []string{"1.1.1.1:53", "1.1.1.1:5353", "ns.example.com:53", "[::1]:53", "[::1]:53", "[::1]:5353"}, r.GetNameservers())
+}
+
+func TestACMEPolicy_AuthorizeIdentifier(t *testing.T) {
+	newPolicy := func(t *testing.T, p *ACMEPolicy) *ACMEPolicy {
+		assert.FatalError(t, p.Init())
+		return p
+	}
+	type test struct {
+		policy *ACMEPolicy
+		typ    string
+		value  string
+		err    error
+	}
+	tests := map[string]func(t *testing.T) test{
+		"ok/nil": func(t *testing.T) test {
+			return test{nil, "dns", "foo.bar.com", nil}
+		},
+		"ok/empty": func(t *testing.T) test {
+			return test{newPolicy(t, &ACMEPolicy{}), "dns", "*.foo.bar.com", nil}
+		},
+		"ok/allowed-domain": func(t *testing.T) test {
+			p := newPolicy(t, &ACMEPolicy{AllowedDomains: []string{"example.com"}})
+			return test{p, "dns", "example.com", nil}
+		},
+		"ok/allowed-subdomain": func(t *testing.T) test {
+			p := newPolicy(t, &ACMEPolicy{AllowedDomains: []string{"Example.com."}})
+			return test{p, "dns", "*.Foo.example.COM", nil}
+		},
+		"ok/allowed-only-subdomain": func(t *testing.T) test {
+			p := newPolicy(t, &ACMEPolicy{AllowedDomains: []string{".example.com"}})
+			return test{p, "dns", "foo.example.com", nil}
+		},
+		"ok/allowed-regex": func(t *testing.T) test {
+			p := newPolicy(t, &ACMEPolicy{AllowedDomains: []string{"example.com"}, AllowedRegexes: []string{`^10\.0\.`}})
+			return test{p, "ip", "10.0.0.1", nil}
+		},
+		"ok/not-denied": func(t *testing.T) test {
+			p := newPolicy(t, &ACMEPolicy{DeniedDomains: []string{"example.com"}})
+			return test{p, "dns", "badexample.com", nil}
+		},
+		"ok/denied-wildcard-sibling": func(t *testing.T) test {
+			p := newPolicy(t, &ACMEPolicy{DeniedDomains: []string{"admin.example.com"}})
+			return test{p, "dns", "*.foo.example.com", nil}
+		},
+		"ok/denied-domain-ip": func(t *testing.T) test {
+			p := newPolicy(t, &ACMEPolicy{DeniedDomains: []string{"1"}})
+			return test{p, "ip", "10.0.0.1", nil}
+		},
+		"fail/not-allowed": func(t *testing.T) test {
+			p := newPolicy(t, &ACMEPolicy{AllowedDomains: []string{"example.com"}})
+			return test{p, "dns", "badexample.com", errors.New("dns badexample.com is not allowed")}
+		},
+		"fail/not-allowed-only-subdomain": func(t *testing.T) test {
+			p := newPolicy(t, &ACMEPolicy{AllowedDomains: []string{".example.com"}})
+			return test{p, "dns", "example.com", errors.New("dns example.com is not allowed")}
+		},
+		"fail/not-allowed-ip": func(t *testing.T) test {
+			p := newPolicy(t, &ACMEPolicy{AllowedDomains: []string{"example.com"}})
+			return test{p, "ip", "10.0.0.1", errors.New("ip 10.0.0.1 is not allowed")}
+		},
+		"fail/denied-domain": func(t *testing.T) test {
+			p := newPolicy(t, &ACMEPolicy{AllowedDomains: []string{"example.com"}, DeniedDomains: []string{"internal.example.com"}})
+			return test{p, "dns", "foo.internal.example.com", errors.New("dns foo.internal.example.com is denied")}
+		},
+		"fail/denied-wildcard": func(t *testing.T) test {
+			p := newPolicy(t, &ACMEPolicy{AllowedDomains: []string{"example.com"}, DeniedDomains: []string{"admin.example.com"}})
+			return test{p, "dns", "*.example.com", errors.New("dns *.example.com is denied")}
+		},
+		"fail/denied-wildcard-parent": func(t *testing.T) test {
+			p := newPolicy(t, &ACMEPolicy{DeniedDomains: []string{".internal.example.com"}})
+			return test{p, "dns", "*.example.com", errors.New("dns *.example.com is denied")}
+		},
+		"fail/denied-regex": func(t *testing.T) test {
+			p := newPolicy(t, &ACMEPolicy{AllowedRegexes: []string{`.*`}, DeniedRegexes: []string{`^admin\.`}})
+			return test{p, "dns", "admin.example.com", errors.New("dns admin.example.com is denied")}
+		},
+		"fail/wildcard": func(t *testing.T) test {
+			p := newPolicy(t, &ACMEPolicy{AllowedDomains: []string{"example.com"}, DenyWildcards: true})
+			return test{p, "dns", "*.example.com", errors.New("wildcard name *.example.com is not allowed")}
+		},
+	}
+	for name, run := range tests {
+		t.Run(name, func(t *testing.T) {
+			tc := run(t)
+			if err := tc.policy.AuthorizeIdentifier(tc.typ, tc.value); err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.Equals(t, tc.err.Error(), err.Error())
+				}
+			} else {
+				assert.Nil(t, tc.err)
+			}
+		})
+	}
+}
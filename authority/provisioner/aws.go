@@ -60,6 +60,8 @@ type awsConfig struct {
 	signatureURL       string
 	certificate        *x509.Certificate
 	signatureAlgorithm x509.SignatureAlgorithm
+	ec2Client          func(region string) awsEC2Client
+	iamClient          func(region string) awsIAMClient
 }
 
 func newAWSConfig() (*awsConfig, error) {
@@ -121,18 +123,37 @@ type awsInstanceIdentityDocument struct {
 // If InstanceAge is set, only the instances with a pendingTime within the given
 // period will be accepted.
 //
+// If IAMRoles, Tags or VPCs are set, the CA will look up the instance using the
+// EC2 and IAM APIs, and only the instances with one of the given roles, all the
+// given tags, and in one of the given VPCs will be accepted. The values of the
+// tags in SANTags will be accepted as SANs and SSH principals, in addition to
+// the internal DNS and IP, if DisableCustomSANs is true. MetadataLookup
+// configures the credentials used in these lookups.
+//
+// If SPIFFE is set, the instance is also looked up to get its IAM role, used
+// in the default path of the SPIFFE ID.
+//
 // Amazon Identity docs are available at
 // https://docs.aws.amazon.com/AWSEC2/latest/UserGuide/instance-identity-documents.html
 type AWS struct {
 	*base
-	Type                   string   `json:"type"`
-	Name                   string   `json:"name"`
-	Accounts               []string `json:"accounts"`
-	DisableCustomSANs      bool     `json:"disableCustomSANs"`
-	DisableTrustOnFirstUse bool     `json:"disableTrustOnFirstUse"`
-	InstanceAge            Duration `json:"instanceAge,omitempty"`
-	Claims                 *Claims  `json:"claims,omitempty"`
+	Type                   string              `json:"type"`
+	Name                   string              `json:"name"`
+	Accounts               []string            `json:"accounts"`
+	DisableCustomSANs      bool                `json:"disableCustomSANs"`
+	DisableTrustOnFirstUse bool                `json:"disableTrustOnFirstUse"`
+	InstanceAge            Duration            `json:"instanceAge,omitempty"`
+	IAMRoles               []string            `json:"iamRoles,omitempty"`
+	Tags                   map[string][]string `json:"tags,omitempty"`
+	VPCs                   []string            `json:"vpcs,omitempty"`
+	SANTags                []string            `json:"sanTags,omitempty"`
+	MetadataLookup         *AWSMetadataLookup  `json:"metadataLookup,omitempty"`
+	Claims                 *Claims             `json:"claims,omitempty"`
+	NameConstraints        *NameConstraints    `json:"nameConstraints,omitempty"`
+	Templates              *Templates          `json:"templates,omitempty"`
+	SPIFFE                 *SPIFFE             `json:"spiffe,omitempty"`
 	claimer                *Claimer
+	nameConstraints        nameConstraintsValidator
 	config                 *awsConfig
 	audiences              Audiences
 }
@@ -255,6 +276,27 @@ func (p *AWS) Init(config Config) (err error) {
 	case p.InstanceAge.Value() < 0:
 		return errors.New("provisioner instanceAge cannot be negative")
 	}
+	for key := range p.Tags {
+		if key == "" {
+			return errors.New("provisioner tags cannot contain an empty key")
+		}
+	}
+	// Initialize the name constraints with the global ones
+	if err = p.NameConstraints.Init(); err != nil {
+		return err
+	}
+	p.nameConstraints = newNameConstraintsValidator(p.NameConstraints, config.NameConstraints)
+
+	// Initialize the certificate templates
+	if err = p.Templates.Init(); err != nil {
+		return err
+	}
+
+	// Initialize the SPIFFE mode
+	if err = p.SPIFFE.Init(spiffeAWSPath); err != nil {
+		return err
+	}
+
 	// Update claims with global ones
 	if p.claimer, err = NewClaimer(p.Claims, config.Claims); err != nil {
 		return err
@@ -263,10 +305,37 @@ func (p *AWS) Init(config Config) (err error) {
 	if p.config, err = newAWSConfig(); err != nil {
 		return err
 	}
+	// Add the clients used to look up the instance metadata
+	if p.requiresMetadata() {
+		if p.config.ec2Client, p.config.iamClient, err = newAWSMetadataClients(p.MetadataLookup); err != nil {
+			return err
+		}
+	}
 	p.audiences = config.Audiences.WithFragment(p.GetID())
 	return nil
 }
 
+// identity returns the verified identity of the given instance. It is used to
+// render the SPIFFE ID and the certificate templates. The IAM role, VPC and
+// tags are only available if the instance metadata is looked up, and the role
+// only if the instance has one.
+func (p *AWS) identity(doc awsInstanceIdentityDocument, meta *awsInstanceMetadata) map[string]interface{} {
+	data := map[string]interface{}{
+		"AccountID":        doc.AccountID,
+		"InstanceID":       doc.InstanceID,
+		"Region":           doc.Region,
+		"AvailabilityZone": doc.AvailabilityZone,
+	}
+	if meta != nil {
+		if role := p.instanceRole(meta); role != "" {
+			data["IAMRole"] = role
+		}
+		data["VPCID"] = meta.VPCID
+		data["Tags"] = meta.Tags
+	}
+	return data
+}
+
 // AuthorizeSign validates the given token and returns the sign options that
 // will be used on certificate creation.
 func (p *AWS) AuthorizeSign(ctx context.Context, token string) ([]SignOption, error) {
@@ -274,13 +343,21 @@ func (p *AWS) AuthorizeSign(ctx context.Context, token string) ([]SignOption, er
 	if err != nil {
 		return nil, errs.Wrap(http.StatusInternalServerError, err, "aws.AuthorizeSign")
 	}
+	meta, err := p.authorizeInstance(ctx, payload)
+	if err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "aws.AuthorizeSign")
+	}
 
 	doc := payload.document
+	identity := p.identity(doc, meta)
 	// Enforce known CN and default DNS and IP if configured.
 	// By default we'll accept the CN and SANs in the CSR.
 	// There's no way to trust them other than TOFU.
 	var so []SignOption
-	if p.DisableCustomSANs {
+	switch {
+	case p.DisableCustomSANs && len(p.SANTags) > 0:
+		so = append(so, sansAllowlistValidator(p.instanceNames(doc, meta)))
+	case p.DisableCustomSANs:
 		so = append(so, dnsNamesValidator([]string{
 			fmt.Sprintf("ip-%s.%s.compute.internal", strings.Replace(doc.PrivateIP, ".", "-", -1), doc.Region),
 		}))
@@ -289,6 +366,17 @@ func (p *AWS) AuthorizeSign(ctx context.Context, token string) ([]SignOption, er
 		}))
 	}
 
+	// Issue an X.509-SVID with the account and the IAM role of the instance.
+	// The role is not part of the signed identity document, it's looked up
+	// using the IAM API, and it's only available if the instance has one.
+	if p.SPIFFE != nil {
+		id, err := p.SPIFFE.ID(identity)
+		if err != nil {
+			return nil, errs.Wrap(http.StatusUnauthorized, err, "aws.AuthorizeSign")
+		}
+		so = append(so, &spiffeSVIDEnforcer{id: id, nameConstraints: p.nameConstraints})
+	}
+
 	return append(so,
 		// modifiers / withOptions
 		newProvisionerExtensionOption(TypeAWS, p.Name, doc.AccountID, "InstanceID", doc.InstanceID),
@@ -297,6 +385,9 @@ func (p *AWS) AuthorizeSign(ctx context.Context, token string) ([]SignOption, er
 		defaultPublicKeyValidator{},
 		commonNameValidator(payload.Claims.Subject),
 		newValidityValidator(p.claimer.MinTLSCertDuration(), p.claimer.MaxTLSCertDuration()),
+		p.nameConstraints,
+		// template modifiers
+		newX509TemplateModifier(p.Templates, token).withIdentity(identity),
 	), nil
 }
 
@@ -402,8 +493,10 @@ func (p *AWS) authorizeToken(token string) (*awsPayload, error) {
 		return nil, errs.Unauthorized("aws.authorizeToken; invalid token - invalid audience claim (aud)")
 	}
 
-	// Validate subject, it has to be known if disableCustomSANs is enabled
-	if p.DisableCustomSANs {
+	// Validate subject, it has to be known if disableCustomSANs is enabled.
+	// If SANTags is set, the subject is validated by authorizeInstance after
+	// looking up the tags.
+	if p.DisableCustomSANs && len(p.SANTags) == 0 {
 		if payload.Subject != doc.InstanceID &&
 			payload.Subject != doc.PrivateIP &&
 			payload.Subject != fmt.Sprintf("ip-%s.%s.compute.internal", strings.Replace(doc.PrivateIP, ".", "-", -1), doc.Region) {
@@ -445,6 +538,10 @@ func (p *AWS) AuthorizeSSHSign(ctx context.Context, token string) ([]SignOption,
 	if err != nil {
 		return nil, errs.Wrap(http.StatusInternalServerError, err, "aws.AuthorizeSSHSign")
 	}
+	meta, err := p.authorizeInstance(ctx, claims)
+	if err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "aws.AuthorizeSSHSign")
+	}
 
 	doc := claims.document
 
@@ -456,10 +553,7 @@ func (p *AWS) AuthorizeSSHSign(ctx context.Context, token string) ([]SignOption,
 	// Only enforce known principals if disable custom sans is true.
 	var principals []string
 	if p.DisableCustomSANs {
-		principals = []string{
-			doc.PrivateIP,
-			fmt.Sprintf("ip-%s.%s.compute.internal", strings.Replace(doc.PrivateIP, ".", "-", -1), doc.Region),
-		}
+		principals = p.instanceNames(doc, meta)
 	}
 
 	// Default to cert type to host
@@ -476,6 +570,8 @@ func (p *AWS) AuthorizeSSHSign(ctx context.Context, token string) ([]SignOption,
 	return append(signOptions,
 		// Set the default extensions.
 		&sshDefaultExtensionModifier{},
+		// Set the template options
+		newSSHTemplateModifier(p.Templates, token).withIdentity(p.identity(doc, meta)),
 		// Set the validity bounds if not set.
 		&sshDefaultDuration{p.claimer},
 		// Validate public key
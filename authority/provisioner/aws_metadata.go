@@ -0,0 +1,234 @@
+package provisioner
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/errs"
+)
+
+// AWSMetadataLookup configures the credentials and endpoint used to look up
+// the IAM roles, tags and VPC of an instance using the EC2 and IAM APIs. The
+// credentials are loaded from the default credential chain, or from the given
+// profile and credentials file if set. The endpoint can be used to point the
+// lookups to a different, or local, implementation of the AWS APIs.
+type AWSMetadataLookup struct {
+	Profile         string `json:"profile,omitempty"`
+	CredentialsFile string `json:"credentialsFile,omitempty"`
+	Endpoint        string `json:"endpoint,omitempty"`
+}
+
+// awsEC2Client defines the methods on the AWS EC2 client used to get the
+// instance metadata.
+type awsEC2Client interface {
+	DescribeInstancesWithContext(ctx aws.Context, input *ec2.DescribeInstancesInput, opts ...request.Option) (*ec2.DescribeInstancesOutput, error)
+}
+
+// awsIAMClient defines the methods on the AWS IAM client used to get the roles
+// of an instance profile.
+type awsIAMClient interface {
+	GetInstanceProfileWithContext(ctx aws.Context, input *iam.GetInstanceProfileInput, opts ...request.Option) (*iam.GetInstanceProfileOutput, error)
+}
+
+// awsInstanceMetadata contains the properties of an instance that are not
+// available in the instance identity document.
+type awsInstanceMetadata struct {
+	VPCID    string
+	IAMRoles []string
+	Tags     map[string]string
+}
+
+// newAWSMetadataClients returns the functions used to create the EC2 and IAM
+// clients for the region of an instance.
+func newAWSMetadataClients(l *AWSMetadataLookup) (func(string) awsEC2Client, func(string) awsIAMClient, error) {
+	o := session.Options{}
+	if l != nil {
+		if l.Endpoint != "" {
+			o.Config.Endpoint = aws.String(l.Endpoint)
+		}
+		if l.Profile != "" {
+			o.SharedConfigState = session.SharedConfigEnable
+			o.Profile = l.Profile
+		}
+		if l.CredentialsFile != "" {
+			o.SharedConfigState = session.SharedConfigEnable
+			o.SharedConfigFiles = append(o.SharedConfigFiles, l.CredentialsFile)
+		}
+	}
+
+	sess, err := session.NewSessionWithOptions(o)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "error creating AWS session")
+	}
+
+	// IAM is a global service, any region in the partition resolves to the
+	// global endpoint.
+	newEC2 := func(region string) awsEC2Client {
+		return ec2.New(sess, aws.NewConfig().WithRegion(region))
+	}
+	newIAM := func(region string) awsIAMClient {
+		return iam.New(sess, aws.NewConfig().WithRegion(region))
+	}
+	return newEC2, newIAM, nil
+}
+
+// requiresMetadata returns true if the provisioner needs to look up the
+// instance metadata. The SPIFFE mode requires the IAM role of the instance.
+func (p *AWS) requiresMetadata() bool {
+	return len(p.IAMRoles) > 0 || len(p.Tags) > 0 || len(p.VPCs) > 0 || len(p.SANTags) > 0 || p.SPIFFE != nil
+}
+
+// authorizeInstance looks up the metadata of the instance in the given payload
+// and validates it against the iamRoles, tags and vpcs constraints. It returns
+// nil if the provisioner does not require the instance metadata.
+func (p *AWS) authorizeInstance(ctx context.Context, payload *awsPayload) (*awsInstanceMetadata, error) {
+	if !p.requiresMetadata() {
+		return nil, nil
+	}
+
+	doc := payload.document
+	meta, err := p.lookupInstance(ctx, doc)
+	if err != nil {
+		return nil, errs.Wrapf(http.StatusUnauthorized, err, "aws.authorizeInstance; error looking up instance %s", doc.InstanceID)
+	}
+
+	// validate vpcs
+	if len(p.VPCs) > 0 && !containsString(p.VPCs, meta.VPCID) {
+		return nil, errs.Unauthorized("aws.authorizeInstance; aws instance vpc is not valid")
+	}
+
+	// validate iam roles, by name or arn
+	if len(p.IAMRoles) > 0 && p.instanceRole(meta) == "" {
+		return nil, errs.Unauthorized("aws.authorizeInstance; aws instance iam role is not valid")
+	}
+
+	// validate tags, all of them must be present and have one of the values
+	for key, values := range p.Tags {
+		v, ok := meta.Tags[key]
+		if !ok {
+			return nil, errs.Unauthorized("aws.authorizeInstance; aws instance tag %s is missing", key)
+		}
+		if len(values) > 0 && !containsString(values, v) {
+			return nil, errs.Unauthorized("aws.authorizeInstance; aws instance tag %s is not valid", key)
+		}
+	}
+
+	// Validate subject with the names in the tags, see authorizeToken.
+	if p.DisableCustomSANs && len(p.SANTags) > 0 {
+		if payload.Subject != doc.InstanceID && !containsString(p.instanceNames(doc, meta), payload.Subject) {
+			return nil, errs.Unauthorized("aws.authorizeInstance; invalid token - invalid subject claim (sub)")
+		}
+	}
+
+	return meta, nil
+}
+
+// lookupInstance returns the VPC, the IAM roles and the tags of the instance
+// in the identity document.
+func (p *AWS) lookupInstance(ctx context.Context, doc awsInstanceIdentityDocument) (*awsInstanceMetadata, error) {
+	out, err := p.config.ec2Client(doc.Region).DescribeInstancesWithContext(ctx, &ec2.DescribeInstancesInput{
+		InstanceIds: []*string{aws.String(doc.InstanceID)},
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "error describing instance")
+	}
+
+	var instance *ec2.Instance
+	for _, r := range out.Reservations {
+		for _, i := range r.Instances {
+			if aws.StringValue(i.InstanceId) == doc.InstanceID {
+				instance = i
+			}
+		}
+	}
+	if instance == nil {
+		return nil, errors.New("instance not found")
+	}
+
+	meta := &awsInstanceMetadata{
+		VPCID: aws.StringValue(instance.VpcId),
+		Tags:  make(map[string]string, len(instance.Tags)),
+	}
+	for _, t := range instance.Tags {
+		meta.Tags[aws.StringValue(t.Key)] = aws.StringValue(t.Value)
+	}
+
+	// The instance profile is only required to validate the roles, and to
+	// render the SPIFFE ID.
+	if (len(p.IAMRoles) > 0 || p.SPIFFE != nil) && instance.IamInstanceProfile != nil {
+		arn := aws.StringValue(instance.IamInstanceProfile.Arn)
+		out, err := p.config.iamClient(doc.Region).GetInstanceProfileWithContext(ctx, &iam.GetInstanceProfileInput{
+			InstanceProfileName: aws.String(awsResourceName(arn)),
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, "error getting instance profile")
+		}
+		if out.InstanceProfile != nil {
+			for _, r := range out.InstanceProfile.Roles {
+				meta.IAMRoles = append(meta.IAMRoles, aws.StringValue(r.Arn))
+			}
+		}
+	}
+
+	return meta, nil
+}
+
+// instanceNames returns the names that an instance can use as SANs or SSH
+// principals when DisableCustomSANs is set. These are the private IP and
+// internal DNS name of the instance, and the comma-separated values of the tags
+// in SANTags.
+func (p *AWS) instanceNames(doc awsInstanceIdentityDocument, meta *awsInstanceMetadata) []string {
+	names := []string{
+		doc.PrivateIP,
+		fmt.Sprintf("ip-%s.%s.compute.internal", strings.Replace(doc.PrivateIP, ".", "-", -1), doc.Region),
+	}
+	if meta == nil {
+		return names
+	}
+	for _, key := range p.SANTags {
+		for _, name := range strings.Split(meta.Tags[key], ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = appendMissing(names, name)
+			}
+		}
+	}
+	return names
+}
+
+// instanceRole returns the name of the IAM role of the instance, or an empty
+// string if the instance does not have one. If IAMRoles is set, the role must
+// be one of them. An instance profile can only contain one role.
+func (p *AWS) instanceRole(meta *awsInstanceMetadata) string {
+	if meta == nil {
+		return ""
+	}
+	for _, role := range meta.IAMRoles {
+		if len(p.IAMRoles) == 0 || containsString(p.IAMRoles, role) || containsString(p.IAMRoles, awsResourceName(role)) {
+			return awsResourceName(role)
+		}
+	}
+	return ""
+}
+
+// awsResourceName returns the name of the resource in the given IAM ARN, e.g.
+// arn:aws:iam::123456789012:role/path/name returns name.
+func awsResourceName(arn string) string {
+	return arn[strings.LastIndex(arn, "/")+1:]
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,349 @@
+package provisioner
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/pkg/errors"
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/errs"
+)
+
+type mockAWSEC2Client struct {
+	describeInstancesWithContext func(ctx aws.Context, input *ec2.DescribeInstancesInput, opts ...request.Option) (*ec2.DescribeInstancesOutput, error)
+}
+
+func (m *mockAWSEC2Client) DescribeInstancesWithContext(ctx aws.Context, input *ec2.DescribeInstancesInput, opts ...request.Option) (*ec2.DescribeInstancesOutput, error) {
+	return m.describeInstancesWithContext(ctx, input, opts...)
+}
+
+type mockAWSIAMClient struct {
+	getInstanceProfileWithContext func(ctx aws.Context, input *iam.GetInstanceProfileInput, opts ...request.Option) (*iam.GetInstanceProfileOutput, error)
+}
+
+func (m *mockAWSIAMClient) GetInstanceProfileWithContext(ctx aws.Context, input *iam.GetInstanceProfileInput, opts ...request.Option) (*iam.GetInstanceProfileOutput, error) {
+	return m.getInstanceProfileWithContext(ctx, input, opts...)
+}
+
+// withAWSMetadata sets mocked EC2 and IAM clients that return an instance with
+// the given metadata. If meta is nil the instance is not found.
+func withAWSMetadata(p *AWS, meta *awsInstanceMetadata, err error) {
+	config := *p.config
+	config.ec2Client = func(region string) awsEC2Client {
+		return &mockAWSEC2Client{
+			describeInstancesWithContext: func(ctx aws.Context, input *ec2.DescribeInstancesInput, opts ...request.Option) (*ec2.DescribeInstancesOutput, error) {
+				if region != "us-west-1" {
+					return nil, errors.Errorf("unexpected region %s", region)
+				}
+				if err != nil {
+					return nil, err
+				}
+				if meta == nil {
+					return &ec2.DescribeInstancesOutput{}, nil
+				}
+				instance := &ec2.Instance{
+					InstanceId: input.InstanceIds[0],
+					VpcId:      aws.String(meta.VPCID),
+				}
+				if len(meta.IAMRoles) > 0 {
+					instance.IamInstanceProfile = &ec2.IamInstanceProfile{
+						Arn: aws.String("arn:aws:iam::123456789012:instance-profile/path/the-profile"),
+					}
+				}
+				for k, v := range meta.Tags {
+					instance.Tags = append(instance.Tags, &ec2.Tag{Key: aws.String(k), Value: aws.String(v)})
+				}
+				return &ec2.DescribeInstancesOutput{
+					Reservations: []*ec2.Reservation{{Instances: []*ec2.Instance{instance}}},
+				}, nil
+			},
+		}
+	}
+	config.iamClient = func(region string) awsIAMClient {
+		return &mockAWSIAMClient{
+			getInstanceProfileWithContext: func(ctx aws.Context, input *iam.GetInstanceProfileInput, opts ...request.Option) (*iam.GetInstanceProfileOutput, error) {
+				if aws.StringValue(input.InstanceProfileName) != "the-profile" {
+					return nil, errors.Errorf("unexpected instance profile %s", aws.StringValue(input.InstanceProfileName))
+				}
+				profile := &iam.InstanceProfile{}
+				for _, arn := range meta.IAMRoles {
+					profile.Roles = append(profile.Roles, &iam.Role{Arn: aws.String(arn)})
+				}
+				return &iam.GetInstanceProfileOutput{InstanceProfile: profile}, nil
+			},
+		}
+	}
+	p.config = &config
+}
+
+func TestAWS_Init_metadata(t *testing.T) {
+	config := Config{
+		Claims: globalProvisionerClaims,
+	}
+
+	p := &AWS{Type: "AWS", Name: "name"}
+	assert.FatalError(t, p.Init(config))
+	assert.Nil(t, p.config.ec2Client)
+	assert.Nil(t, p.config.iamClient)
+
+	p = &AWS{Type: "AWS", Name: "name", Tags: map[string][]string{"Environment": {"production"}}}
+	assert.FatalError(t, p.Init(config))
+	assert.NotNil(t, p.config.ec2Client)
+	assert.NotNil(t, p.config.iamClient)
+
+	p = &AWS{Type: "AWS", Name: "name", IAMRoles: []string{"the-role"}, MetadataLookup: &AWSMetadataLookup{Endpoint: "http://127.0.0.1:4566"}}
+	assert.FatalError(t, p.Init(config))
+	assert.NotNil(t, p.config.ec2Client)
+	assert.NotNil(t, p.config.iamClient)
+
+	p = &AWS{Type: "AWS", Name: "name", SPIFFE: &SPIFFE{TrustDomain: "example.com"}}
+	assert.FatalError(t, p.Init(config))
+	assert.NotNil(t, p.config.ec2Client)
+	assert.NotNil(t, p.config.iamClient)
+
+	p = &AWS{Type: "AWS", Name: "name", Tags: map[string][]string{"": nil}}
+	assert.Equals(t, errors.New("provisioner tags cannot contain an empty key"), p.Init(config))
+}
+
+func TestAWS_authorizeInstance(t *testing.T) {
+	p, srv, err := generateAWSWithServer()
+	assert.FatalError(t, err)
+	defer srv.Close()
+
+	token, err := p.GetIdentityToken("instance-id", "https://ca.smallstep.com")
+	assert.FatalError(t, err)
+	hostToken, err := p.GetIdentityToken("host.example.com", "https://ca.smallstep.com")
+	assert.FatalError(t, err)
+
+	meta := &awsInstanceMetadata{
+		VPCID:    "vpc-1234",
+		IAMRoles: []string{"arn:aws:iam::123456789012:role/path/the-role"},
+		Tags: map[string]string{
+			"Environment": "production",
+			"Hostname":    "host.example.com, 10.0.0.1",
+		},
+	}
+
+	type test struct {
+		p     *AWS
+		token string
+		meta  *awsInstanceMetadata
+		err   error
+		code  int
+	}
+	newAWS := func(fn func(p *AWS)) *AWS {
+		c := *p
+		fn(&c)
+		return &c
+	}
+	tests := map[string]test{
+		"ok/none":      {p, token, nil, nil, 0},
+		"ok/vpc":       {newAWS(func(p *AWS) { p.VPCs = []string{"vpc-0000", "vpc-1234"} }), token, meta, nil, 0},
+		"ok/role-name": {newAWS(func(p *AWS) { p.IAMRoles = []string{"the-role"} }), token, meta, nil, 0},
+		"ok/role-arn":  {newAWS(func(p *AWS) { p.IAMRoles = []string{"arn:aws:iam::123456789012:role/path/the-role"} }), token, meta, nil, 0},
+		"ok/tags":      {newAWS(func(p *AWS) { p.Tags = map[string][]string{"Environment": {"staging", "production"}} }), token, meta, nil, 0},
+		"ok/tags-any":  {newAWS(func(p *AWS) { p.Tags = map[string][]string{"Hostname": nil} }), token, meta, nil, 0},
+		"ok/all": {newAWS(func(p *AWS) {
+			p.VPCs = []string{"vpc-1234"}
+			p.IAMRoles = []string{"the-role"}
+			p.Tags = map[string][]string{"Environment": {"production"}}
+		}), token, meta, nil, 0},
+		"ok/sanTags-subject": {newAWS(func(p *AWS) {
+			p.DisableCustomSANs = true
+			p.SANTags = []string{"Hostname"}
+		}), hostToken, meta, nil, 0},
+		"ok/sanTags-instanceID": {newAWS(func(p *AWS) {
+			p.DisableCustomSANs = true
+			p.SANTags = []string{"Hostname"}
+		}), token, meta, nil, 0},
+		"fail/vpc": {newAWS(func(p *AWS) { p.VPCs = []string{"vpc-0000"} }), token, meta,
+			errors.New("aws.authorizeInstance; aws instance vpc is not valid"), http.StatusUnauthorized},
+		"fail/role": {newAWS(func(p *AWS) { p.IAMRoles = []string{"other-role"} }), token, meta,
+			errors.New("aws.authorizeInstance; aws instance iam role is not valid"), http.StatusUnauthorized},
+		"fail/role-profile": {newAWS(func(p *AWS) { p.IAMRoles = []string{"the-role"} }), token, &awsInstanceMetadata{VPCID: "vpc-1234"},
+			errors.New("aws.authorizeInstance; aws instance iam role is not valid"), http.StatusUnauthorized},
+		"fail/tag-missing": {newAWS(func(p *AWS) { p.Tags = map[string][]string{"Team": nil} }), token, meta,
+			errors.New("aws.authorizeInstance; aws instance tag Team is missing"), http.StatusUnauthorized},
+		"fail/tag-value": {newAWS(func(p *AWS) { p.Tags = map[string][]string{"Environment": {"staging"}} }), token, meta,
+			errors.New("aws.authorizeInstance; aws instance tag Environment is not valid"), http.StatusUnauthorized},
+		"fail/not-found": {newAWS(func(p *AWS) { p.VPCs = []string{"vpc-1234"} }), token, nil,
+			errors.New("aws.authorizeInstance; error looking up instance instance-id: instance not found"), http.StatusUnauthorized},
+		"fail/sanTags-subject": {newAWS(func(p *AWS) {
+			p.DisableCustomSANs = true
+			p.SANTags = []string{"Environment"}
+		}), hostToken, meta, errors.New("aws.authorizeInstance; invalid token - invalid subject claim (sub)"), http.StatusUnauthorized},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			withAWSMetadata(tc.p, tc.meta, nil)
+			payload, err := tc.p.authorizeToken(tc.token)
+			assert.FatalError(t, err)
+			got, err := tc.p.authorizeInstance(context.Background(), payload)
+			if err != nil {
+				if assert.NotNil(t, tc.err) {
+					sc, ok := err.(errs.StatusCoder)
+					assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+					assert.Equals(t, sc.StatusCode(), tc.code)
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+			} else {
+				assert.Nil(t, tc.err)
+				if tc.p.requiresMetadata() {
+					assert.Equals(t, tc.meta.VPCID, got.VPCID)
+					assert.Equals(t, tc.meta.Tags, got.Tags)
+				} else {
+					assert.Nil(t, got)
+				}
+			}
+		})
+	}
+
+	// EC2 API error
+	p2 := newAWS(func(p *AWS) { p.VPCs = []string{"vpc-1234"} })
+	withAWSMetadata(p2, meta, errors.New("force"))
+	payload, err := p2.authorizeToken(token)
+	assert.FatalError(t, err)
+	_, err = p2.authorizeInstance(context.Background(), payload)
+	if assert.Error(t, err) {
+		assert.HasPrefix(t, err.Error(), "aws.authorizeInstance; error looking up instance instance-id: error describing instance: force")
+	}
+}
+
+func TestAWS_AuthorizeSign_metadata(t *testing.T) {
+	p, srv, err := generateAWSWithServer()
+	assert.FatalError(t, err)
+	defer srv.Close()
+	p.DisableCustomSANs = true
+	p.SANTags = []string{"Hostname"}
+	p.Tags = map[string][]string{"Environment": {"production"}}
+	withAWSMetadata(p, &awsInstanceMetadata{
+		VPCID: "vpc-1234",
+		Tags: map[string]string{
+			"Environment": "production",
+			"Hostname":    "host.example.com,10.0.0.1",
+		},
+	}, nil)
+
+	token, err := p.GetIdentityToken("host.example.com", "https://ca.smallstep.com")
+	assert.FatalError(t, err)
+
+	opts, err := p.AuthorizeSign(context.Background(), token)
+	assert.FatalError(t, err)
+	assert.Len(t, 8, opts)
+
+	var validator sansAllowlistValidator
+	for _, o := range opts {
+		switch v := o.(type) {
+		case sansAllowlistValidator:
+			validator = v
+		case *x509TemplateModifier:
+			assert.Equals(t, map[string]interface{}{
+				"AccountID":        p.Accounts[0],
+				"InstanceID":       "instance-id",
+				"Region":           "us-west-1",
+				"AvailabilityZone": "us-west-2b",
+				"VPCID":            "vpc-1234",
+				"Tags": map[string]string{
+					"Environment": "production",
+					"Hostname":    "host.example.com,10.0.0.1",
+				},
+			}, v.identity)
+		}
+	}
+	assert.Equals(t, sansAllowlistValidator{"127.0.0.1", "ip-127-0-0-1.us-west-1.compute.internal", "host.example.com", "10.0.0.1"}, validator)
+	assert.NoError(t, validator.Valid(&x509.CertificateRequest{
+		DNSNames:    []string{"host.example.com"},
+		IPAddresses: []net.IP{net.ParseIP("10.0.0.1")},
+	}))
+	assert.Error(t, validator.Valid(&x509.CertificateRequest{
+		DNSNames: []string{"host.example.com", "other.example.com"},
+	}))
+
+	// Constraints are validated
+	withAWSMetadata(p, &awsInstanceMetadata{
+		VPCID: "vpc-1234",
+		Tags: map[string]string{
+			"Environment": "staging",
+			"Hostname":    "host.example.com",
+		},
+	}, nil)
+	_, err = p.AuthorizeSign(context.Background(), token)
+	if assert.Error(t, err) {
+		sc, ok := err.(errs.StatusCoder)
+		assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+		assert.Equals(t, http.StatusUnauthorized, sc.StatusCode())
+	}
+}
+
+func TestAWS_AuthorizeSSHSign_metadata(t *testing.T) {
+	tm, fn := mockNow()
+	defer fn()
+
+	p, srv, err := generateAWSWithServer()
+	assert.FatalError(t, err)
+	defer srv.Close()
+	p.DisableCustomSANs = true
+	p.SANTags = []string{"Hostname"}
+	withAWSMetadata(p, &awsInstanceMetadata{
+		VPCID: "vpc-1234",
+		Tags: map[string]string{
+			"Hostname": "host.example.com",
+		},
+	}, nil)
+
+	token, err := p.GetIdentityToken("host.example.com", "https://ca.smallstep.com")
+	assert.FatalError(t, err)
+	key, err := generateJSONWebKey()
+	assert.FatalError(t, err)
+	signer, err := generateJSONWebKey()
+	assert.FatalError(t, err)
+
+	opts, err := p.AuthorizeSSHSign(context.Background(), token)
+	assert.FatalError(t, err)
+	for _, o := range opts {
+		if v, ok := o.(*sshTemplateModifier); ok {
+			assert.Equals(t, "vpc-1234", v.identity["VPCID"])
+			assert.Equals(t, map[string]string{"Hostname": "host.example.com"}, v.identity["Tags"])
+		}
+	}
+
+	hostDuration := p.claimer.DefaultHostSSHCertDuration()
+	tests := []struct {
+		name     string
+		sshOpts  SSHOptions
+		expected *SSHOptions
+		wantErr  bool
+	}{
+		{"ok", SSHOptions{}, &SSHOptions{
+			CertType: "host", Principals: []string{"127.0.0.1", "ip-127-0-0-1.us-west-1.compute.internal", "host.example.com"},
+			ValidAfter: NewTimeDuration(tm), ValidBefore: NewTimeDuration(tm.Add(hostDuration)),
+		}, false},
+		{"ok-principal", SSHOptions{Principals: []string{"host.example.com"}}, &SSHOptions{
+			CertType: "host", Principals: []string{"host.example.com"},
+			ValidAfter: NewTimeDuration(tm), ValidBefore: NewTimeDuration(tm.Add(hostDuration)),
+		}, false},
+		{"fail-principal", SSHOptions{Principals: []string{"other.example.com"}}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts, err := p.AuthorizeSSHSign(context.Background(), token)
+			assert.FatalError(t, err)
+			cert, err := signSSHCertificate(key.Public().Key, tt.sshOpts, opts, signer.Key.(crypto.Signer))
+			if tt.wantErr {
+				assert.Error(t, err)
+				assert.Nil(t, cert)
+			} else {
+				assert.FatalError(t, err)
+				assert.NoError(t, validateSSHCertificate(cert, tt.expected))
+			}
+		})
+	}
+}
@@ -455,6 +455,25 @@ func TestAWS_AuthorizeSign(t *testing.T) {
 	assert.FatalError(t, err)
 	p3.config = p1.config
 
+	p4, err := generateAWS()
+	assert.FatalError(t, err)
+	p4.Accounts = p1.Accounts
+	p4.config = p1.config
+	p4.SPIFFE = &SPIFFE{TrustDomain: "example.com"}
+	assert.FatalError(t, p4.SPIFFE.Init(spiffeAWSPath))
+	withAWSMetadata(p4, &awsInstanceMetadata{
+		IAMRoles: []string{"arn:aws:iam::123456789012:role/path/the-role"},
+	}, nil)
+
+	// SPIFFE without an IAM role
+	p5, err := generateAWS()
+	assert.FatalError(t, err)
+	p5.Accounts = p1.Accounts
+	p5.config = p1.config
+	p5.SPIFFE = &SPIFFE{TrustDomain: "example.com"}
+	assert.FatalError(t, p5.SPIFFE.Init(spiffeAWSPath))
+	withAWSMetadata(p5, &awsInstanceMetadata{}, nil)
+
 	t1, err := p1.GetIdentityToken("foo.local", "https://ca.smallstep.com")
 	assert.FatalError(t, err)
 	t2, err := p2.GetIdentityToken("instance-id", "https://ca.smallstep.com")
@@ -462,6 +481,10 @@ func TestAWS_AuthorizeSign(t *testing.T) {
 	assert.FatalError(t, err)
 	t3, err := p3.GetIdentityToken("foo.local", "https://ca.smallstep.com")
 	assert.FatalError(t, err)
+	t5, err := p4.GetIdentityToken("foo.local", "https://ca.smallstep.com")
+	assert.FatalError(t, err)
+	t6, err := p5.GetIdentityToken("foo.local", "https://ca.smallstep.com")
+	assert.FatalError(t, err)
 
 	// Alternative common names with DisableCustomSANs = true
 	t2PrivateIP, err := p2.GetIdentityToken("127.0.0.1", "https://ca.smallstep.com")
@@ -539,12 +562,14 @@ func TestAWS_AuthorizeSign(t *testing.T) {
 		code    int
 		wantErr bool
 	}{
-		{"ok", p1, args{t1}, 5, http.StatusOK, false},
-		{"ok", p2, args{t2}, 7, http.StatusOK, false},
-		{"ok", p2, args{t2Hostname}, 7, http.StatusOK, false},
-		{"ok", p2, args{t2PrivateIP}, 7, http.StatusOK, false},
-		{"ok", p1, args{t4}, 5, http.StatusOK, false},
+		{"ok", p1, args{t1}, 7, http.StatusOK, false},
+		{"ok", p2, args{t2}, 9, http.StatusOK, false},
+		{"ok", p2, args{t2Hostname}, 9, http.StatusOK, false},
+		{"ok", p2, args{t2PrivateIP}, 9, http.StatusOK, false},
+		{"ok", p1, args{t4}, 7, http.StatusOK, false},
+		{"ok spiffe", p4, args{t5}, 8, http.StatusOK, false},
 		{"fail account", p3, args{t3}, 0, http.StatusUnauthorized, true},
+		{"fail spiffe role", p5, args{t6}, 0, http.StatusUnauthorized, true},
 		{"fail token", p1, args{"token"}, 0, http.StatusUnauthorized, true},
 		{"fail subject", p1, args{failSubject}, 0, http.StatusUnauthorized, true},
 		{"fail issuer", p1, args{failIssuer}, 0, http.StatusUnauthorized, true},
@@ -571,6 +596,17 @@ func TestAWS_AuthorizeSign(t *testing.T) {
 				assert.Equals(t, sc.StatusCode(), tt.code)
 			} else {
 				assert.Len(t, tt.wantLen, got)
+				for _, o := range got {
+					switch v := o.(type) {
+					case *spiffeSVIDEnforcer:
+						assert.Equals(t, "spiffe://example.com/aws/"+tt.aws.Accounts[0]+"/role/the-role", v.id.String())
+					case *x509TemplateModifier:
+						assert.Equals(t, "instance-id", v.identity["InstanceID"])
+						if tt.aws.SPIFFE != nil {
+							assert.Equals(t, "the-role", v.identity["IAMRole"])
+						}
+					}
+				}
 			}
 		})
 	}
@@ -30,15 +30,27 @@ const azureDefaultAudience = "https://management.azure.com/"
 // Using case insensitive as resourceGroups appears as resourcegroups.
 var azureXMSMirIDRegExp = regexp.MustCompile(`(?i)^/subscriptions/([^/]+)/resourceGroups/([^/]+)/providers/Microsoft.Compute/virtualMachines/([^/]+)$`)
 
+// azureXMSMirIDScaleSetRegExp is the regular expression used to parse the
+// xms_mirid claim of a virtual machine scale set. The claim is the same for all
+// the instances of the scale set.
+var azureXMSMirIDScaleSetRegExp = regexp.MustCompile(`(?i)^/subscriptions/([^/]+)/resourceGroups/([^/]+)/providers/Microsoft.Compute/virtualMachineScaleSets/([^/]+)$`)
+
+// azureXMSMirIDIdentityRegExp is the regular expression used to parse the
+// xms_mirid claim of a user-assigned managed identity.
+var azureXMSMirIDIdentityRegExp = regexp.MustCompile(`(?i)^/subscriptions/([^/]+)/resourceGroups/([^/]+)/providers/Microsoft.ManagedIdentity/userAssignedIdentities/([^/]+)$`)
+
 type azureConfig struct {
-	oidcDiscoveryURL string
-	identityTokenURL string
+	oidcDiscoveryURL    string
+	identityTokenURL    string
+	attestedDocumentURL string
+	attestedRoots       *x509.CertPool
 }
 
 func newAzureConfig(tenantID string) *azureConfig {
 	return &azureConfig{
-		oidcDiscoveryURL: azureOIDCBaseURL + "/" + tenantID + "/.well-known/openid-configuration",
-		identityTokenURL: azureIdentityTokenURL,
+		oidcDiscoveryURL:    azureOIDCBaseURL + "/" + tenantID + "/.well-known/openid-configuration",
+		identityTokenURL:    azureIdentityTokenURL,
+		attestedDocumentURL: azureAttestedDocumentURL,
 	}
 }
 
@@ -63,6 +75,56 @@ type azurePayload struct {
 	TenantID         string `json:"tid"`
 	Version          string `json:"ver"`
 	XMSMirID         string `json:"xms_mirid"`
+	resource         *azureResource
+}
+
+// azureResource is the Azure resource in the xms_mirid claim. It is a virtual
+// machine, a virtual machine scale set, or a user-assigned managed identity.
+// The InstanceID is the vmId in the attested document of the instance.
+type azureResource struct {
+	SubscriptionID  string
+	ResourceGroup   string
+	VirtualMachine  string
+	ScaleSet        string
+	InstanceID      string
+	ManagedIdentity string
+}
+
+// parseAzureResource parses the given xms_mirid claim. It returns false if the
+// claim does not contain one of the supported resources.
+func parseAzureResource(xmsMirID string) (*azureResource, bool) {
+	if re := azureXMSMirIDRegExp.FindStringSubmatch(xmsMirID); len(re) == 4 {
+		return &azureResource{SubscriptionID: re[1], ResourceGroup: re[2], VirtualMachine: re[3]}, true
+	}
+	if re := azureXMSMirIDScaleSetRegExp.FindStringSubmatch(xmsMirID); len(re) == 4 {
+		return &azureResource{SubscriptionID: re[1], ResourceGroup: re[2], ScaleSet: re[3]}, true
+	}
+	if re := azureXMSMirIDIdentityRegExp.FindStringSubmatch(xmsMirID); len(re) == 4 {
+		return &azureResource{SubscriptionID: re[1], ResourceGroup: re[2], ManagedIdentity: re[3]}, true
+	}
+	return nil, false
+}
+
+// Name returns the name used as the identity of the resource. The name of a
+// scale set instance is its instance id.
+func (r *azureResource) Name() string {
+	switch {
+	case r.VirtualMachine != "":
+		return r.VirtualMachine
+	case r.InstanceID != "":
+		return r.InstanceID
+	case r.ScaleSet != "":
+		return r.ScaleSet
+	default:
+		return r.ManagedIdentity
+	}
+}
+
+// isShared returns true if the xms_mirid of the resource is shared by multiple
+// virtual machines, all the instances of a scale set, or all the resources
+// with a user-assigned managed identity.
+func (r *azureResource) isShared() bool {
+	return r.VirtualMachine == ""
 }
 
 // Azure is the provisioner that supports identity tokens created from the
@@ -77,23 +139,46 @@ type azurePayload struct {
 // with the same instance will be accepted. By default only the first request
 // will be accepted.
 //
+// If SubscriptionIDs, ResourceGroups or ObjectIDs are set, the subscription and
+// resource group in the xms_mirid claim, and the oid claim must be one of the
+// given values. If VirtualMachines or VirtualMachineScaleSets are set, only the
+// given virtual machines or scale sets will be accepted. Tokens of scale sets
+// are only accepted if VirtualMachineScaleSets is set, and tokens of
+// user-assigned managed identities only if ObjectIDs is set. The tokens of scale
+// sets and user-assigned managed identities do not identify the instance, so
+// they also require DisableTrustOnFirstUse.
+//
+// The instances of a scale set are identified by the vmId in their attested
+// document. If VirtualMachineScaleSets is set, the identity token will wrap the
+// access token with the attested document, signed by Azure and bound to the
+// access token with its nonce, and tokens of scale sets without it will be
+// rejected.
+//
 // Microsoft Azure identity docs are available at
 // https://docs.microsoft.com/en-us/azure/active-directory/managed-identities-azure-resources/how-to-use-vm-token
 // and https://docs.microsoft.com/en-us/azure/virtual-machines/windows/instance-metadata-service
 type Azure struct {
 	*base
-	Type                   string   `json:"type"`
-	Name                   string   `json:"name"`
-	TenantID               string   `json:"tenantId"`
-	ResourceGroups         []string `json:"resourceGroups"`
-	Audience               string   `json:"audience,omitempty"`
-	DisableCustomSANs      bool     `json:"disableCustomSANs"`
-	DisableTrustOnFirstUse bool     `json:"disableTrustOnFirstUse"`
-	Claims                 *Claims  `json:"claims,omitempty"`
-	claimer                *Claimer
-	config                 *azureConfig
-	oidcConfig             openIDConfiguration
-	keyStore               *keyStore
+	Type                    string           `json:"type"`
+	Name                    string           `json:"name"`
+	TenantID                string           `json:"tenantId"`
+	ResourceGroups          []string         `json:"resourceGroups"`
+	SubscriptionIDs         []string         `json:"subscriptionIds,omitempty"`
+	VirtualMachines         []string         `json:"virtualMachines,omitempty"`
+	VirtualMachineScaleSets []string         `json:"virtualMachineScaleSets,omitempty"`
+	ObjectIDs               []string         `json:"objectIds,omitempty"`
+	Audience                string           `json:"audience,omitempty"`
+	DisableCustomSANs       bool             `json:"disableCustomSANs"`
+	DisableTrustOnFirstUse  bool             `json:"disableTrustOnFirstUse"`
+	Claims                  *Claims          `json:"claims,omitempty"`
+	NameConstraints         *NameConstraints `json:"nameConstraints,omitempty"`
+	Templates               *Templates       `json:"templates,omitempty"`
+	SPIFFE                  *SPIFFE          `json:"spiffe,omitempty"`
+	claimer                 *Claimer
+	nameConstraints         nameConstraintsValidator
+	config                  *azureConfig
+	oidcConfig              openIDConfiguration
+	keyStore                *keyStore
 }
 
 // GetID returns the provisioner unique identifier.
@@ -103,8 +188,14 @@ func (p *Azure) GetID() string {
 
 // GetTokenID returns the identifier of the token. The default value for Azure
 // the SHA256 of "xms_mirid", but if DisableTrustOnFirstUse is set to true, then
-// it will be the token kid.
+// it will be the token kid. The xms_mirid of a scale set or a user-assigned
+// managed identity is shared by multiple instances, and those tokens are only
+// accepted if DisableTrustOnFirstUse is set.
 func (p *Azure) GetTokenID(token string) (string, error) {
+	token, _, err := unwrapAzureToken(token)
+	if err != nil {
+		return "", errors.Wrap(err, "error parsing token")
+	}
 	jwt, err := jose.ParseSigned(token)
 	if err != nil {
 		return "", errors.Wrap(err, "error parsing token")
@@ -143,7 +234,8 @@ func (p *Azure) GetEncryptedKey() (kid string, key string, ok bool) {
 }
 
 // GetIdentityToken retrieves from the metadata service the identity token and
-// returns it.
+// returns it. If the provisioner accepts scale sets, the identity token is
+// wrapped with the attested document of the instance.
 func (p *Azure) GetIdentityToken(subject, caURL string) (string, error) {
 	// Initialize the config if this method is used from the cli.
 	p.assertConfig()
@@ -172,6 +264,9 @@ func (p *Azure) GetIdentityToken(subject, caURL string) (string, error) {
 		return "", errors.Wrap(err, "error unmarshaling identity token response")
 	}
 
+	if len(p.VirtualMachineScaleSets) > 0 {
+		return p.getAttestedToken(identityToken.AccessToken)
+	}
 	return identityToken.AccessToken, nil
 }
 
@@ -184,12 +279,30 @@ func (p *Azure) Init(config Config) (err error) {
 		return errors.New("provisioner name cannot be empty")
 	case p.TenantID == "":
 		return errors.New("provisioner tenantId cannot be empty")
+	case len(p.VirtualMachineScaleSets) > 0 && !p.DisableTrustOnFirstUse:
+		return errors.New("provisioner virtualMachineScaleSets requires disableTrustOnFirstUse")
 	case p.Audience == "": // use default audience
 		p.Audience = azureDefaultAudience
 	}
 	// Initialize config
 	p.assertConfig()
 
+	// Initialize the name constraints with the global ones
+	if err = p.NameConstraints.Init(); err != nil {
+		return err
+	}
+	p.nameConstraints = newNameConstraintsValidator(p.NameConstraints, config.NameConstraints)
+
+	// Initialize the certificate templates
+	if err = p.Templates.Init(); err != nil {
+		return err
+	}
+
+	// Initialize the SPIFFE mode
+	if err = p.SPIFFE.Init(spiffeAzurePath); err != nil {
+		return err
+	}
+
 	// Update claims with global ones
 	if p.claimer, err = NewClaimer(p.Claims, config.Claims); err != nil {
 		return err
@@ -212,6 +325,10 @@ func (p *Azure) Init(config Config) (err error) {
 
 // authorizeToken returns the claims, name, group, error.
 func (p *Azure) authorizeToken(token string) (*azurePayload, string, string, error) {
+	token, document, err := unwrapAzureToken(token)
+	if err != nil {
+		return nil, "", "", err
+	}
 	jwt, err := jose.ParseSigned(token)
 	if err != nil {
 		return nil, "", "", errs.Wrap(http.StatusUnauthorized, err, "azure.authorizeToken; error parsing azure token")
@@ -246,35 +363,88 @@ func (p *Azure) authorizeToken(token string) (*azurePayload, string, string, err
 		return nil, "", "", errs.Unauthorized("azure.authorizeToken; azure token validation failed - invalid tenant id claim (tid)")
 	}
 
-	re := azureXMSMirIDRegExp.FindStringSubmatch(claims.XMSMirID)
-	if len(re) != 4 {
+	res, ok := parseAzureResource(claims.XMSMirID)
+	if !ok {
 		return nil, "", "", errs.Unauthorized("azure.authorizeToken; error parsing xms_mirid claim - %s", claims.XMSMirID)
 	}
-	group, name := re[2], re[3]
-	return &claims, name, group, nil
+
+	// Filter by subscription, resource group and object id
+	switch {
+	case len(p.SubscriptionIDs) > 0 && !containsString(p.SubscriptionIDs, res.SubscriptionID):
+		return nil, "", "", errs.Unauthorized("azure.authorizeToken; azure token validation failed - invalid subscription id")
+	case len(p.ResourceGroups) > 0 && !containsString(p.ResourceGroups, res.ResourceGroup):
+		return nil, "", "", errs.Unauthorized("azure.authorizeToken; azure token validation failed - invalid resource group")
+	case len(p.ObjectIDs) > 0 && !containsString(p.ObjectIDs, claims.ObjectID):
+		return nil, "", "", errs.Unauthorized("azure.authorizeToken; azure token validation failed - invalid object id claim (oid)")
+	}
+
+	// The attested document identifies the instance.
+	if document != nil {
+		doc, err := p.verifyAttestedDocument(document, token)
+		if err != nil {
+			return nil, "", "", errs.Wrap(http.StatusUnauthorized, err, "azure.authorizeToken; azure token validation failed - invalid attested document")
+		}
+		if !strings.EqualFold(doc.SubscriptionID, res.SubscriptionID) {
+			return nil, "", "", errs.Unauthorized("azure.authorizeToken; azure token validation failed - invalid attested document subscription id")
+		}
+		res.InstanceID = doc.VMID
+	}
+
+	// Filter by virtual machine or scale set. Scale sets and user-assigned
+	// managed identities are only accepted if explicitly allowed.
+	computeAllowlist := len(p.VirtualMachines) > 0 || len(p.VirtualMachineScaleSets) > 0
+	switch {
+	case res.VirtualMachine != "":
+		if computeAllowlist && !containsString(p.VirtualMachines, res.VirtualMachine) {
+			return nil, "", "", errs.Unauthorized("azure.authorizeToken; azure token validation failed - invalid virtual machine")
+		}
+	case res.ScaleSet != "":
+		if !containsString(p.VirtualMachineScaleSets, res.ScaleSet) {
+			return nil, "", "", errs.Unauthorized("azure.authorizeToken; azure token validation failed - invalid virtual machine scale set")
+		}
+		if res.InstanceID == "" {
+			return nil, "", "", errs.Unauthorized("azure.authorizeToken; azure token validation failed - virtual machine scale set %s requires an attested document", res.ScaleSet)
+		}
+	default:
+		if computeAllowlist || len(p.ObjectIDs) == 0 {
+			return nil, "", "", errs.Unauthorized("azure.authorizeToken; azure token validation failed - invalid user-assigned managed identity")
+		}
+	}
+
+	// The identity of scale sets and user-assigned managed identities is
+	// shared by multiple instances, the first one would lock out the rest.
+	if res.isShared() && !p.DisableTrustOnFirstUse {
+		return nil, "", "", errs.Unauthorized("azure.authorizeToken; azure token validation failed - shared identity %s requires disableTrustOnFirstUse", res.Name())
+	}
+
+	claims.resource = res
+	return &claims, res.Name(), res.ResourceGroup, nil
+}
+
+// identity returns the verified identity of the given claims. It is used to
+// render the SPIFFE ID and the certificate templates.
+func (p *Azure) identity(claims *azurePayload) map[string]interface{} {
+	res := claims.resource
+	return map[string]interface{}{
+		"TenantID":        claims.TenantID,
+		"ObjectID":        claims.ObjectID,
+		"SubscriptionID":  res.SubscriptionID,
+		"ResourceGroup":   res.ResourceGroup,
+		"VirtualMachine":  res.Name(),
+		"ScaleSet":        res.ScaleSet,
+		"InstanceID":      res.InstanceID,
+		"ManagedIdentity": res.ManagedIdentity,
+	}
 }
 
 // AuthorizeSign validates the given token and returns the sign options that
 // will be used on certificate creation.
 func (p *Azure) AuthorizeSign(ctx context.Context, token string) ([]SignOption, error) {
-	_, name, group, err := p.authorizeToken(token)
+	claims, name, _, err := p.authorizeToken(token)
 	if err != nil {
 		return nil, errs.Wrap(http.StatusInternalServerError, err, "azure.AuthorizeSign")
 	}
-
-	// Filter by resource group
-	if len(p.ResourceGroups) > 0 {
-		var found bool
-		for _, g := range p.ResourceGroups {
-			if g == group {
-				found = true
-				break
-			}
-		}
-		if !found {
-			return nil, errs.Unauthorized("azure.AuthorizeSign; azure token validation failed - invalid resource group")
-		}
-	}
+	identity := p.identity(claims)
 
 	// Enforce known common name and default DNS if configured.
 	// By default we'll accept the CN and SANs in the CSR.
@@ -286,6 +456,15 @@ func (p *Azure) AuthorizeSign(ctx context.Context, token string) ([]SignOption,
 		so = append(so, dnsNamesValidator([]string{name}))
 	}
 
+	// Issue an X.509-SVID with the tenant, resource group and virtual machine
+	if p.SPIFFE != nil {
+		id, err := p.SPIFFE.ID(identity)
+		if err != nil {
+			return nil, errs.Wrap(http.StatusUnauthorized, err, "azure.AuthorizeSign")
+		}
+		so = append(so, &spiffeSVIDEnforcer{id: id, nameConstraints: p.nameConstraints})
+	}
+
 	return append(so,
 		// modifiers / withOptions
 		newProvisionerExtensionOption(TypeAzure, p.Name, p.TenantID),
@@ -293,6 +472,9 @@ func (p *Azure) AuthorizeSign(ctx context.Context, token string) ([]SignOption,
 		// validators
 		defaultPublicKeyValidator{},
 		newValidityValidator(p.claimer.MinTLSCertDuration(), p.claimer.MaxTLSCertDuration()),
+		p.nameConstraints,
+		// template modifiers
+		newX509TemplateModifier(p.Templates, token).withIdentity(identity),
 	), nil
 }
 
@@ -313,7 +495,7 @@ func (p *Azure) AuthorizeSSHSign(ctx context.Context, token string) ([]SignOptio
 		return nil, errs.Unauthorized("azure.AuthorizeSSHSign; sshCA is disabled for provisioner %s", p.GetID())
 	}
 
-	_, name, _, err := p.authorizeToken(token)
+	claims, name, _, err := p.authorizeToken(token)
 	if err != nil {
 		return nil, errs.Wrap(http.StatusInternalServerError, err, "azure.AuthorizeSSHSign")
 	}
@@ -341,6 +523,8 @@ func (p *Azure) AuthorizeSSHSign(ctx context.Context, token string) ([]SignOptio
 	return append(signOptions,
 		// Set the default extensions.
 		&sshDefaultExtensionModifier{},
+		// Set the template options
+		newSSHTemplateModifier(p.Templates, token).withIdentity(p.identity(claims)),
 		// Set the validity bounds if not set.
 		&sshDefaultDuration{p.claimer},
 		// Validate public key
@@ -0,0 +1,421 @@
+package provisioner
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/errs"
+	"github.com/smallstep/cli/jose"
+)
+
+// azureAttestedDocumentURL is the URL to get the attested document of an
+// instance, the nonce is appended to it.
+const azureAttestedDocumentURL = "http://169.254.169.254/metadata/attested/document?api-version=2020-09-01&nonce="
+
+// azureAttestedDocumentDomain is the domain of the certificate that signs the
+// attested documents.
+const azureAttestedDocumentDomain = "metadata.azure.com"
+
+// azureAttestedTimeLayout is the layout of the timestamps in the attested
+// document.
+const azureAttestedTimeLayout = "01/02/06 15:04:05 -0700"
+
+var (
+	oidPKCS7Data          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidPKCS7SignedData    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidPKCS7MessageDigest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	oidDigestSHA1         = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidDigestSHA256       = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidDigestSHA384       = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 2}
+	oidDigestSHA512       = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 3}
+)
+
+// azureAttestedResponse is the response of the attested document endpoint.
+type azureAttestedResponse struct {
+	Encoding  string `json:"encoding"`
+	Signature string `json:"signature"`
+}
+
+// azureAttestedDocument is the signed content of the attested document. The
+// vmId is the unique identifier of the virtual machine or the scale set
+// instance.
+type azureAttestedDocument struct {
+	Nonce          string `json:"nonce"`
+	SubscriptionID string `json:"subscriptionId"`
+	VMID           string `json:"vmId"`
+	TimeStamp      struct {
+		CreatedOn string `json:"createdOn"`
+		ExpiresOn string `json:"expiresOn"`
+	} `json:"timeStamp"`
+}
+
+// azureAttestedPayload is the payload of the token that wraps the Azure access
+// token with the attested document of the instance. The tid and aud claims are
+// the ones in the access token, so it's loaded by the same provisioner.
+type azureAttestedPayload struct {
+	jose.Claims
+	TenantID string              `json:"tid"`
+	Azure    *azureAttestedClaim `json:"azure,omitempty"`
+}
+
+type azureAttestedClaim struct {
+	AccessToken string `json:"accessToken"`
+	Document    []byte `json:"document"`
+}
+
+type pkcs7ContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,optional,tag:0"`
+}
+
+type pkcs7SignedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	ContentInfo      pkcs7ContentInfo
+	Certificates     asn1.RawValue     `asn1:"optional,tag:0"`
+	CRLs             asn1.RawValue     `asn1:"optional,tag:1"`
+	SignerInfos      []pkcs7SignerInfo `asn1:"set"`
+}
+
+type pkcs7IssuerAndSerial struct {
+	Issuer       asn1.RawValue
+	SerialNumber *big.Int
+}
+
+type pkcs7SignerInfo struct {
+	Version                   int
+	IssuerAndSerialNumber     pkcs7IssuerAndSerial
+	DigestAlgorithm           pkix.AlgorithmIdentifier
+	AuthenticatedAttributes   asn1.RawValue `asn1:"optional,tag:0"`
+	DigestEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedDigest           []byte
+	UnauthenticatedAttributes asn1.RawValue `asn1:"optional,tag:1"`
+}
+
+type pkcs7Attribute struct {
+	Type  asn1.ObjectIdentifier
+	Value asn1.RawValue `asn1:"set"`
+}
+
+// azureAttestedNonce returns the nonce used to bind the attested document to
+// the given access token. The attested document only accepts nonces of up to
+// 10 digits.
+func azureAttestedNonce(accessToken string) string {
+	sum := sha256.Sum256([]byte(accessToken))
+	return fmt.Sprintf("%010d", binary.BigEndian.Uint64(sum[:8])%1e10)
+}
+
+// getAttestedToken retrieves the attested document of the instance bound to
+// the given access token, and returns a token that wraps both.
+func (p *Azure) getAttestedToken(accessToken string) (string, error) {
+	req, err := http.NewRequest("GET", p.config.attestedDocumentURL+azureAttestedNonce(accessToken), http.NoBody)
+	if err != nil {
+		return "", errors.Wrap(err, "error creating request")
+	}
+	req.Header.Set("Metadata", "true")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", errors.Wrap(err, "error getting attested document, are you in a Azure VM?")
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", errors.Wrap(err, "error reading attested document response")
+	}
+	if resp.StatusCode >= 400 {
+		return "", errors.Errorf("error getting attested document: status=%d, response=%s", resp.StatusCode, b)
+	}
+
+	var attested azureAttestedResponse
+	if err := json.Unmarshal(b, &attested); err != nil {
+		return "", errors.Wrap(err, "error unmarshaling attested document response")
+	}
+	if attested.Encoding != "pkcs7" {
+		return "", errors.Errorf("error getting attested document: unsupported encoding %s", attested.Encoding)
+	}
+	document, err := base64.StdEncoding.DecodeString(attested.Signature)
+	if err != nil {
+		return "", errors.Wrap(err, "error decoding attested document")
+	}
+
+	jwt, err := jose.ParseSigned(accessToken)
+	if err != nil {
+		return "", errors.Wrap(err, "error parsing identity token")
+	}
+	var claims azurePayload
+	if err := jwt.UnsafeClaimsWithoutVerification(&claims); err != nil {
+		return "", errors.Wrap(err, "error parsing identity token claims")
+	}
+
+	// Create a JWT from the access token and the attested document
+	signer, err := jose.NewSigner(
+		jose.SigningKey{Algorithm: jose.HS256, Key: document},
+		new(jose.SignerOptions).WithType("JWT"),
+	)
+	if err != nil {
+		return "", errors.Wrap(err, "error creating signer")
+	}
+
+	now := time.Now()
+	payload := azureAttestedPayload{
+		Claims: jose.Claims{
+			Subject:   claims.Subject,
+			Audience:  claims.Audience,
+			Expiry:    claims.Expiry,
+			NotBefore: jose.NewNumericDate(now),
+			IssuedAt:  jose.NewNumericDate(now),
+			ID:        claims.ID,
+		},
+		TenantID: claims.TenantID,
+		Azure: &azureAttestedClaim{
+			AccessToken: accessToken,
+			Document:    document,
+		},
+	}
+
+	tok, err := jose.Signed(signer).Claims(payload).CompactSerialize()
+	if err != nil {
+		return "", errors.Wrap(err, "error serializing token")
+	}
+	return tok, nil
+}
+
+// unwrapAzureToken returns the access token and the attested document in the
+// given token. Tokens without an attested document are plain access tokens,
+// and they are returned as they are.
+func unwrapAzureToken(token string) (string, []byte, error) {
+	jwt, err := jose.ParseSigned(token)
+	if err != nil {
+		return "", nil, errs.Wrap(http.StatusUnauthorized, err, "azure.authorizeToken; error parsing azure token")
+	}
+	var claims azureAttestedPayload
+	if err := jwt.UnsafeClaimsWithoutVerification(&claims); err != nil || claims.Azure == nil {
+		return token, nil, nil
+	}
+	if err := jwt.Claims(claims.Azure.Document, &claims); err != nil {
+		return "", nil, errs.Wrap(http.StatusUnauthorized, err, "azure.authorizeToken; error verifying attested token")
+	}
+	if err := claims.ValidateWithLeeway(jose.Expected{
+		Time: time.Now(),
+	}, 1*time.Minute); err != nil {
+		return "", nil, errs.Wrap(http.StatusUnauthorized, err, "azure.authorizeToken; failed to validate attested token payload")
+	}
+	return claims.Azure.AccessToken, claims.Azure.Document, nil
+}
+
+// verifyAttestedDocument verifies the PKCS #7 signature of the attested
+// document and its certificate chain, and returns the attested document if it
+// is bound to the given access token.
+//
+// Azure attested data docs are available at
+// https://docs.microsoft.com/en-us/azure/virtual-machines/windows/instance-metadata-service#attested-data
+func (p *Azure) verifyAttestedDocument(document []byte, accessToken string) (*azureAttestedDocument, error) {
+	content, certs, err := p.verifyPKCS7(document)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc azureAttestedDocument
+	if err := json.Unmarshal(content, &doc); err != nil {
+		return nil, errors.Wrap(err, "error unmarshaling attested document")
+	}
+	if doc.Nonce != azureAttestedNonce(accessToken) {
+		return nil, errors.New("attested document nonce does not match the token")
+	}
+	if doc.VMID == "" {
+		return nil, errors.New("attested document vmId cannot be empty")
+	}
+	expiresOn, err := time.Parse(azureAttestedTimeLayout, doc.TimeStamp.ExpiresOn)
+	if err != nil {
+		return nil, errors.Wrap(err, "error parsing attested document expiresOn")
+	}
+	if time.Now().After(expiresOn) {
+		return nil, errors.Errorf("attested document expired on %s", expiresOn)
+	}
+
+	// Verify the certificate chain of the signer
+	leaf := certs[0]
+	if !isAzureAttestedDocumentSigner(leaf) {
+		return nil, errors.Errorf("attested document is not signed by %s", azureAttestedDocumentDomain)
+	}
+	intermediates := x509.NewCertPool()
+	for _, crt := range certs[1:] {
+		intermediates.AddCert(crt)
+	}
+	// The intermediate is not always in the document, Azure recommends to get
+	// it from the authority information access extension.
+	if len(certs) == 1 && len(leaf.IssuingCertificateURL) > 0 {
+		crt, err := getCertificate(leaf.IssuingCertificateURL[0])
+		if err != nil {
+			return nil, err
+		}
+		intermediates.AddCert(crt)
+	}
+	if _, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         p.config.attestedRoots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}); err != nil {
+		return nil, errors.Wrap(err, "error verifying attested document certificate")
+	}
+
+	return &doc, nil
+}
+
+// verifyPKCS7 verifies the signature of the given PKCS #7 signed data and
+// returns the signed content and the certificates, the first one is the
+// signer.
+func (p *Azure) verifyPKCS7(b []byte) ([]byte, []*x509.Certificate, error) {
+	var ci pkcs7ContentInfo
+	if _, err := asn1.Unmarshal(b, &ci); err != nil {
+		return nil, nil, errors.Wrap(err, "error parsing attested document")
+	}
+	if !ci.ContentType.Equal(oidPKCS7SignedData) {
+		return nil, nil, errors.New("error parsing attested document: content is not signed data")
+	}
+	var sd pkcs7SignedData
+	if _, err := asn1.Unmarshal(ci.Content.Bytes, &sd); err != nil {
+		return nil, nil, errors.Wrap(err, "error parsing attested document signed data")
+	}
+	if !sd.ContentInfo.ContentType.Equal(oidPKCS7Data) {
+		return nil, nil, errors.New("error parsing attested document: signed content is not data")
+	}
+	var content []byte
+	if _, err := asn1.Unmarshal(sd.ContentInfo.Content.Bytes, &content); err != nil {
+		return nil, nil, errors.Wrap(err, "error parsing attested document content")
+	}
+	certs, err := x509.ParseCertificates(sd.Certificates.Bytes)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "error parsing attested document certificates")
+	}
+	if len(sd.SignerInfos) != 1 {
+		return nil, nil, errors.Errorf("error parsing attested document: found %d signers", len(sd.SignerInfos))
+	}
+
+	// Find the certificate of the signer
+	si := sd.SignerInfos[0]
+	var signer *x509.Certificate
+	for i, crt := range certs {
+		if bytes.Equal(crt.RawIssuer, si.IssuerAndSerialNumber.Issuer.FullBytes) &&
+			crt.SerialNumber.Cmp(si.IssuerAndSerialNumber.SerialNumber) == 0 {
+			signer = crt
+			certs[0], certs[i] = certs[i], certs[0]
+			break
+		}
+	}
+	if signer == nil {
+		return nil, nil, errors.New("error parsing attested document: signer certificate not found")
+	}
+
+	hash, sigAlg, err := pkcs7SignatureAlgorithm(si.DigestAlgorithm.Algorithm)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// The signature is over the content, or over the authenticated attributes
+	// if present, and they must contain the digest of the content.
+	signed := content
+	if len(si.AuthenticatedAttributes.Bytes) > 0 {
+		h := hash.New()
+		h.Write(content)
+		digest, err := pkcs7MessageDigest(si.AuthenticatedAttributes.Bytes)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !bytes.Equal(h.Sum(nil), digest) {
+			return nil, nil, errors.New("error verifying attested document: message digest does not match")
+		}
+		// The attributes are signed with the SET OF tag.
+		signed = append([]byte{0x31}, si.AuthenticatedAttributes.FullBytes[1:]...)
+	}
+	if err := signer.CheckSignature(sigAlg, signed, si.EncryptedDigest); err != nil {
+		return nil, nil, errors.Wrap(err, "error verifying attested document signature")
+	}
+
+	return content, certs, nil
+}
+
+// pkcs7SignatureAlgorithm returns the hash and the RSA signature algorithm for
+// the given digest algorithm.
+func pkcs7SignatureAlgorithm(oid asn1.ObjectIdentifier) (crypto.Hash, x509.SignatureAlgorithm, error) {
+	switch {
+	case oid.Equal(oidDigestSHA1):
+		return crypto.SHA1, x509.SHA1WithRSA, nil
+	case oid.Equal(oidDigestSHA256):
+		return crypto.SHA256, x509.SHA256WithRSA, nil
+	case oid.Equal(oidDigestSHA384):
+		return crypto.SHA384, x509.SHA384WithRSA, nil
+	case oid.Equal(oidDigestSHA512):
+		return crypto.SHA512, x509.SHA512WithRSA, nil
+	default:
+		return 0, 0, errors.Errorf("error parsing attested document: unsupported digest algorithm %s", oid)
+	}
+}
+
+// pkcs7MessageDigest returns the message digest in the given authenticated
+// attributes.
+func pkcs7MessageDigest(b []byte) ([]byte, error) {
+	for len(b) > 0 {
+		var attr pkcs7Attribute
+		rest, err := asn1.Unmarshal(b, &attr)
+		if err != nil {
+			return nil, errors.Wrap(err, "error parsing attested document attributes")
+		}
+		if attr.Type.Equal(oidPKCS7MessageDigest) {
+			var digest []byte
+			if _, err := asn1.Unmarshal(attr.Value.Bytes, &digest); err != nil {
+				return nil, errors.Wrap(err, "error parsing attested document message digest")
+			}
+			return digest, nil
+		}
+		b = rest
+	}
+	return nil, errors.New("error parsing attested document: message digest not found")
+}
+
+// isAzureAttestedDocumentSigner returns true if the certificate is issued to
+// metadata.azure.com or one of its subdomains.
+func isAzureAttestedDocumentSigner(crt *x509.Certificate) bool {
+	for _, name := range crt.DNSNames {
+		if name == azureAttestedDocumentDomain || strings.HasSuffix(name, "."+azureAttestedDocumentDomain) {
+			return true
+		}
+	}
+	return false
+}
+
+// getCertificate downloads the DER encoded certificate in the given url.
+func getCertificate(uri string) (*x509.Certificate, error) {
+	resp, err := http.Get(uri)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to connect to %s", uri)
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading %s", uri)
+	}
+	if resp.StatusCode >= 400 {
+		return nil, errors.Errorf("error getting %s: status=%d", uri, resp.StatusCode)
+	}
+	crt, err := x509.ParseCertificate(b)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error parsing %s", uri)
+	}
+	return crt, nil
+}
@@ -0,0 +1,191 @@
+package provisioner
+
+import (
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/errs"
+	"github.com/smallstep/cli/jose"
+)
+
+func TestAzure_verifyAttestedDocument(t *testing.T) {
+	var aia *azureAttestedCerts
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/intermediate":
+			w.Write(aia.intermediate.Raw)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	certs, err := generateAzureAttestedCerts("metadata.azure.com", srv.URL+"/intermediate")
+	assert.FatalError(t, err)
+	aia = certs
+	badCerts, err := generateAzureAttestedCerts("metadata.azure.com", srv.URL+"/not-found")
+	assert.FatalError(t, err)
+	otherCerts, err := generateAzureAttestedCerts("attested.example.com", srv.URL+"/intermediate")
+	assert.FatalError(t, err)
+
+	p, err := generateAzure()
+	assert.FatalError(t, err)
+	p.config.attestedRoots = certs.roots()
+
+	newDocument := func(fn func(*azureAttestedDocument)) azureAttestedDocument {
+		var doc azureAttestedDocument
+		doc.Nonce = azureAttestedNonce("access-token")
+		doc.SubscriptionID = "sub"
+		doc.VMID = "vm-id"
+		doc.TimeStamp.CreatedOn = time.Now().UTC().Format(azureAttestedTimeLayout)
+		doc.TimeStamp.ExpiresOn = time.Now().Add(6 * time.Hour).UTC().Format(azureAttestedTimeLayout)
+		if fn != nil {
+			fn(&doc)
+		}
+		return doc
+	}
+	sign := func(doc azureAttestedDocument, c *azureAttestedCerts, bag []*x509.Certificate, withAttributes bool) []byte {
+		b, err := generateAzureAttestedDocument(doc, c.leaf, c.key, bag, withAttributes)
+		assert.FatalError(t, err)
+		return b
+	}
+	tamper := func(b []byte) []byte {
+		b[len(b)-1] ^= 0xff
+		return b
+	}
+
+	tests := []struct {
+		name        string
+		document    []byte
+		accessToken string
+		want        string
+		err         error
+	}{
+		{"ok", sign(newDocument(nil), certs, []*x509.Certificate{certs.leaf, certs.intermediate}, true), "access-token", "vm-id", nil},
+		{"ok signer last", sign(newDocument(nil), certs, []*x509.Certificate{certs.intermediate, certs.leaf}, true), "access-token", "vm-id", nil},
+		{"ok without attributes", sign(newDocument(nil), certs, []*x509.Certificate{certs.leaf, certs.intermediate}, false), "access-token", "vm-id", nil},
+		{"ok issuing certificate url", sign(newDocument(nil), certs, []*x509.Certificate{certs.leaf}, true), "access-token", "vm-id", nil},
+		{"fail parse", []byte("not-a-document"), "access-token", "",
+			errors.New("error parsing attested document")},
+		{"fail signature", tamper(sign(newDocument(nil), certs, []*x509.Certificate{certs.leaf, certs.intermediate}, true)), "access-token", "",
+			errors.New("error verifying attested document signature")},
+		{"fail signer", sign(newDocument(nil), certs, []*x509.Certificate{certs.intermediate}, true), "access-token", "",
+			errors.New("error parsing attested document: signer certificate not found")},
+		{"fail nonce", sign(newDocument(nil), certs, []*x509.Certificate{certs.leaf, certs.intermediate}, true), "other-token", "",
+			errors.New("attested document nonce does not match the token")},
+		{"fail vmId", sign(newDocument(func(doc *azureAttestedDocument) { doc.VMID = "" }), certs, []*x509.Certificate{certs.leaf, certs.intermediate}, true), "access-token", "",
+			errors.New("attested document vmId cannot be empty")},
+		{"fail expired", sign(newDocument(func(doc *azureAttestedDocument) {
+			doc.TimeStamp.ExpiresOn = time.Now().Add(-time.Minute).UTC().Format(azureAttestedTimeLayout)
+		}), certs, []*x509.Certificate{certs.leaf, certs.intermediate}, true), "access-token", "",
+			errors.New("attested document expired on")},
+		{"fail domain", sign(newDocument(nil), otherCerts, []*x509.Certificate{otherCerts.leaf, otherCerts.intermediate}, true), "access-token", "",
+			errors.New("attested document is not signed by metadata.azure.com")},
+		{"fail issuing certificate url", sign(newDocument(nil), badCerts, []*x509.Certificate{badCerts.leaf}, true), "access-token", "",
+			errors.New("error getting " + srv.URL + "/not-found: status=404")},
+		{"fail chain", sign(newDocument(nil), badCerts, []*x509.Certificate{badCerts.leaf, badCerts.intermediate}, true), "access-token", "",
+			errors.New("error verifying attested document certificate")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := p.verifyAttestedDocument(tt.document, tt.accessToken)
+			if tt.err != nil {
+				if assert.Error(t, err) {
+					assert.HasPrefix(t, err.Error(), tt.err.Error())
+				}
+				assert.Nil(t, doc)
+				return
+			}
+			assert.FatalError(t, err)
+			assert.Equals(t, tt.want, doc.VMID)
+			assert.Equals(t, "sub", doc.SubscriptionID)
+		})
+	}
+}
+
+func TestAzure_authorizeToken_attested(t *testing.T) {
+	p, srv, err := generateAzureWithServer()
+	assert.FatalError(t, err)
+	defer srv.Close()
+	p.DisableTrustOnFirstUse = true
+	p.VirtualMachines = []string{"vm"}
+	p.VirtualMachineScaleSets = []string{"vmss"}
+
+	certs, err := generateAzureAttestedCerts("metadata.azure.com", "")
+	assert.FatalError(t, err)
+	p.config.attestedRoots = certs.roots()
+
+	const (
+		vm   = "/subscriptions/sub/resourceGroups/group/providers/Microsoft.Compute/virtualMachines/vm"
+		vmss = "/subscriptions/sub/resourceGroups/group/providers/Microsoft.Compute/virtualMachineScaleSets/vmss"
+	)
+	accessToken := func(xmsMirID string) string {
+		tok, err := generateAzureResourceToken(p, "oid", xmsMirID)
+		assert.FatalError(t, err)
+		return tok
+	}
+	attested := func(tok, subscriptionID string) string {
+		tok, err := generateAzureAttestedToken(tok, subscriptionID, "instance-id", certs)
+		assert.FatalError(t, err)
+		return tok
+	}
+	vmssToken := accessToken(vmss)
+	otherDocument, err := generateAzureAttestedToken(accessToken(vmss), "sub", "instance-id", certs)
+	assert.FatalError(t, err)
+	_, document, err := unwrapAzureToken(otherDocument)
+	assert.FatalError(t, err)
+	replayed, err := wrapAzureAttestedToken(vmssToken, document)
+	assert.FatalError(t, err)
+	sig, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.HS256, Key: []byte("the-key")}, nil)
+	assert.FatalError(t, err)
+	badSignature, err := jose.Signed(sig).Claims(azureAttestedPayload{
+		Claims:   jose.Claims{Audience: []string{azureDefaultAudience}},
+		TenantID: p.TenantID,
+		Azure:    &azureAttestedClaim{AccessToken: vmssToken, Document: document},
+	}).CompactSerialize()
+	assert.FatalError(t, err)
+
+	tests := []struct {
+		name           string
+		token          string
+		wantName       string
+		wantInstanceID string
+		err            error
+	}{
+		{"ok scale set", attested(vmssToken, "sub"), "instance-id", "instance-id", nil},
+		{"ok virtual machine", attested(accessToken(vm), "sub"), "vm", "instance-id", nil},
+		{"ok virtual machine without attested document", accessToken(vm), "vm", "", nil},
+		{"fail scale set without attested document", vmssToken, "", "",
+			errors.New("azure.authorizeToken; azure token validation failed - virtual machine scale set vmss requires an attested document")},
+		{"fail subscription id", attested(vmssToken, "other"), "", "",
+			errors.New("azure.authorizeToken; azure token validation failed - invalid attested document subscription id")},
+		{"fail replayed document", replayed, "", "",
+			errors.New("azure.authorizeToken; azure token validation failed - invalid attested document")},
+		{"fail attested token signature", badSignature, "", "",
+			errors.New("azure.authorizeToken; error verifying attested token")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, name, group, err := p.authorizeToken(tt.token)
+			if tt.err != nil {
+				if assert.Error(t, err) {
+					sc, ok := err.(errs.StatusCoder)
+					assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+					assert.Equals(t, http.StatusUnauthorized, sc.StatusCode())
+					assert.HasPrefix(t, err.Error(), tt.err.Error())
+				}
+				assert.Nil(t, claims)
+				return
+			}
+			assert.FatalError(t, err)
+			assert.Equals(t, tt.wantName, name)
+			assert.Equals(t, "group", group)
+			assert.Equals(t, tt.wantInstanceID, claims.resource.InstanceID)
+		})
+	}
+}
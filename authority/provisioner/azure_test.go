@@ -212,6 +212,42 @@ func TestAzure_Init(t *testing.T) {
 	}
 }
 
+func TestAzure_Init_scaleSets(t *testing.T) {
+	p1, srv, err := generateAzureWithServer()
+	assert.FatalError(t, err)
+	defer srv.Close()
+
+	config := Config{
+		Claims: globalProvisionerClaims,
+	}
+	tests := []struct {
+		name                   string
+		disableTrustOnFirstUse bool
+		err                    error
+	}{
+		{"ok", true, nil},
+		{"fail tofu", false, errors.New("provisioner virtualMachineScaleSets requires disableTrustOnFirstUse")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &Azure{
+				Type:                    p1.Type,
+				Name:                    p1.Name,
+				TenantID:                p1.TenantID,
+				VirtualMachineScaleSets: []string{"vmss"},
+				DisableTrustOnFirstUse:  tt.disableTrustOnFirstUse,
+				config:                  p1.config,
+			}
+			err := p.Init(config)
+			if tt.err == nil {
+				assert.FatalError(t, err)
+			} else if assert.Error(t, err) {
+				assert.Equals(t, tt.err.Error(), err.Error())
+			}
+		})
+	}
+}
+
 func TestAzure_authorizeToken(t *testing.T) {
 	type test struct {
 		p     *Azure
@@ -431,9 +467,9 @@ func TestAzure_AuthorizeSign(t *testing.T) {
 		code    int
 		wantErr bool
 	}{
-		{"ok", p1, args{t1}, 4, http.StatusOK, false},
-		{"ok", p2, args{t2}, 6, http.StatusOK, false},
-		{"ok", p1, args{t11}, 4, http.StatusOK, false},
+		{"ok", p1, args{t1}, 6, http.StatusOK, false},
+		{"ok", p2, args{t2}, 8, http.StatusOK, false},
+		{"ok", p1, args{t11}, 6, http.StatusOK, false},
 		{"fail tenant", p3, args{t3}, 0, http.StatusUnauthorized, true},
 		{"fail resource group", p4, args{t4}, 0, http.StatusUnauthorized, true},
 		{"fail token", p1, args{"token"}, 0, http.StatusUnauthorized, true},
@@ -627,3 +663,209 @@ func TestAzure_assertConfig(t *testing.T) {
 		})
 	}
 }
+
+func generateAzureResourceToken(p *Azure, objectID, xmsMirID string) (string, error) {
+	jwk := &p.keyStore.keySet.Keys[0]
+	sig, err := jose.NewSigner(
+		jose.SigningKey{Algorithm: jose.ES256, Key: jwk.Key},
+		new(jose.SignerOptions).WithType("JWT").WithHeader("kid", jwk.KeyID),
+	)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	claims := azurePayload{
+		Claims: jose.Claims{
+			Subject:   "subject",
+			Issuer:    p.oidcConfig.Issuer,
+			IssuedAt:  jose.NewNumericDate(now),
+			NotBefore: jose.NewNumericDate(now),
+			Expiry:    jose.NewNumericDate(now.Add(5 * time.Minute)),
+			Audience:  []string{azureDefaultAudience},
+			ID:        "the-jti",
+		},
+		ObjectID: objectID,
+		TenantID: p.TenantID,
+		XMSMirID: xmsMirID,
+	}
+	return jose.Signed(sig).Claims(claims).CompactSerialize()
+}
+
+func Test_parseAzureResource(t *testing.T) {
+	tests := []struct {
+		name     string
+		xmsMirID string
+		want     *azureResource
+		wantName string
+		wantOk   bool
+	}{
+		{"ok vm", "/subscriptions/sub/resourceGroups/group/providers/Microsoft.Compute/virtualMachines/vm",
+			&azureResource{SubscriptionID: "sub", ResourceGroup: "group", VirtualMachine: "vm"}, "vm", true},
+		{"ok vm lowercase", "/subscriptions/sub/resourcegroups/group/providers/Microsoft.Compute/virtualMachines/vm",
+			&azureResource{SubscriptionID: "sub", ResourceGroup: "group", VirtualMachine: "vm"}, "vm", true},
+		{"ok scale set", "/subscriptions/sub/resourceGroups/group/providers/Microsoft.Compute/virtualMachineScaleSets/vmss",
+			&azureResource{SubscriptionID: "sub", ResourceGroup: "group", ScaleSet: "vmss"}, "vmss", true},
+		{"ok managed identity", "/subscriptions/sub/resourceGroups/group/providers/Microsoft.ManagedIdentity/userAssignedIdentities/identity",
+			&azureResource{SubscriptionID: "sub", ResourceGroup: "group", ManagedIdentity: "identity"}, "identity", true},
+		{"fail empty", "", nil, "", false},
+		{"fail vm instance", "/subscriptions/sub/resourceGroups/group/providers/Microsoft.Compute/virtualMachines/vm/virtualMachines/3", nil, "", false},
+		{"fail scale set instance", "/subscriptions/sub/resourceGroups/group/providers/Microsoft.Compute/virtualMachineScaleSets/vmss/virtualMachines/3", nil, "", false},
+		{"fail provider", "/subscriptions/sub/resourceGroups/group/providers/Microsoft.Web/sites/site", nil, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseAzureResource(tt.xmsMirID)
+			assert.Equals(t, tt.wantOk, ok)
+			assert.Equals(t, tt.want, got)
+			if ok {
+				assert.Equals(t, tt.wantName, got.Name())
+			}
+		})
+	}
+}
+
+func TestAzure_authorizeToken_allowlists(t *testing.T) {
+	p, srv, err := generateAzureWithServer()
+	assert.FatalError(t, err)
+	defer srv.Close()
+
+	const (
+		vm       = "/subscriptions/sub/resourceGroups/group/providers/Microsoft.Compute/virtualMachines/vm"
+		vmss     = "/subscriptions/sub/resourceGroups/group/providers/Microsoft.Compute/virtualMachineScaleSets/vmss"
+		identity = "/subscriptions/sub/resourceGroups/group/providers/Microsoft.ManagedIdentity/userAssignedIdentities/identity"
+	)
+
+	with := func(fn func(*Azure)) *Azure {
+		az := *p
+		fn(&az)
+		return &az
+	}
+
+	tests := []struct {
+		name      string
+		p         *Azure
+		objectID  string
+		xmsMirID  string
+		wantName  string
+		wantGroup string
+		err       error
+	}{
+		{"ok vm", p, "oid", vm, "vm", "group", nil},
+		{"ok subscription", with(func(az *Azure) { az.SubscriptionIDs = []string{"other", "sub"} }), "oid", vm, "vm", "group", nil},
+		{"ok object id", with(func(az *Azure) { az.ObjectIDs = []string{"oid"} }), "oid", vm, "vm", "group", nil},
+		{"ok virtual machine", with(func(az *Azure) { az.VirtualMachines = []string{"vm"} }), "oid", vm, "vm", "group", nil},
+		{"ok managed identity", with(func(az *Azure) {
+			az.ObjectIDs = []string{"oid"}
+			az.DisableTrustOnFirstUse = true
+		}), "oid", identity, "identity", "group", nil},
+		{"fail subscription", with(func(az *Azure) { az.SubscriptionIDs = []string{"other"} }), "oid", vm, "", "",
+			errors.New("azure.authorizeToken; azure token validation failed - invalid subscription id")},
+		{"fail resource group", with(func(az *Azure) { az.ResourceGroups = []string{"other"} }), "oid", vm, "", "",
+			errors.New("azure.authorizeToken; azure token validation failed - invalid resource group")},
+		{"fail object id", with(func(az *Azure) { az.ObjectIDs = []string{"other"} }), "oid", vm, "", "",
+			errors.New("azure.authorizeToken; azure token validation failed - invalid object id claim (oid)")},
+		{"fail virtual machine", with(func(az *Azure) { az.VirtualMachines = []string{"other"} }), "oid", vm, "", "",
+			errors.New("azure.authorizeToken; azure token validation failed - invalid virtual machine")},
+		{"fail virtual machine with scale sets", with(func(az *Azure) { az.VirtualMachineScaleSets = []string{"vmss"} }), "oid", vm, "", "",
+			errors.New("azure.authorizeToken; azure token validation failed - invalid virtual machine")},
+		{"fail scale set without attested document", with(func(az *Azure) {
+			az.VirtualMachineScaleSets = []string{"vmss"}
+			az.DisableTrustOnFirstUse = true
+		}), "oid", vmss, "", "",
+			errors.New("azure.authorizeToken; azure token validation failed - virtual machine scale set vmss requires an attested document")},
+		{"fail scale set tofu", with(func(az *Azure) { az.VirtualMachineScaleSets = []string{"vmss"} }), "oid", vmss, "", "",
+			errors.New("azure.authorizeToken; azure token validation failed - shared identity vmss requires disableTrustOnFirstUse")},
+		{"fail managed identity tofu", with(func(az *Azure) { az.ObjectIDs = []string{"oid"} }), "oid", identity, "", "",
+			errors.New("azure.authorizeToken; azure token validation failed - shared identity identity requires disableTrustOnFirstUse")},
+		{"fail scale set", p, "oid", vmss, "", "",
+			errors.New("azure.authorizeToken; azure token validation failed - invalid virtual machine scale set")},
+		{"fail other scale set", with(func(az *Azure) { az.VirtualMachineScaleSets = []string{"other"} }), "oid", vmss, "", "",
+			errors.New("azure.authorizeToken; azure token validation failed - invalid virtual machine scale set")},
+		{"fail managed identity", p, "oid", identity, "", "",
+			errors.New("azure.authorizeToken; azure token validation failed - invalid user-assigned managed identity")},
+		{"fail managed identity with virtual machines", with(func(az *Azure) {
+			az.ObjectIDs = []string{"oid"}
+			az.VirtualMachines = []string{"vm"}
+		}), "oid", identity, "", "",
+			errors.New("azure.authorizeToken; azure token validation failed - invalid user-assigned managed identity")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := generateAzureResourceToken(tt.p, tt.objectID, tt.xmsMirID)
+			assert.FatalError(t, err)
+			claims, name, group, err := tt.p.authorizeToken(token)
+			if tt.err != nil {
+				if assert.Error(t, err) {
+					sc, ok := err.(errs.StatusCoder)
+					assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+					assert.Equals(t, http.StatusUnauthorized, sc.StatusCode())
+					assert.HasPrefix(t, err.Error(), tt.err.Error())
+				}
+				assert.Nil(t, claims)
+				return
+			}
+			assert.FatalError(t, err)
+			assert.Equals(t, tt.wantName, name)
+			assert.Equals(t, tt.wantGroup, group)
+		})
+	}
+}
+
+func TestAzure_AuthorizeSign_scaleSet(t *testing.T) {
+	p, srv, err := generateAzureWithServer()
+	assert.FatalError(t, err)
+	defer srv.Close()
+	p.DisableCustomSANs = true
+	p.DisableTrustOnFirstUse = true
+	p.VirtualMachineScaleSets = []string{"vmss"}
+	p.SPIFFE = &SPIFFE{TrustDomain: "example.com"}
+	assert.FatalError(t, p.SPIFFE.Init(spiffeAzurePath))
+
+	certs, err := generateAzureAttestedCerts("metadata.azure.com", "")
+	assert.FatalError(t, err)
+	p.config.attestedRoots = certs.roots()
+
+	accessToken, err := generateAzureResourceToken(p, "oid",
+		"/subscriptions/sub/resourceGroups/group/providers/Microsoft.Compute/virtualMachineScaleSets/vmss")
+	assert.FatalError(t, err)
+	token, err := generateAzureAttestedToken(accessToken, "sub", "instance-id", certs)
+	assert.FatalError(t, err)
+
+	opts, err := p.AuthorizeSign(context.Background(), token)
+	assert.FatalError(t, err)
+	assert.Len(t, 9, opts)
+	for _, o := range opts {
+		switch v := o.(type) {
+		case commonNameValidator:
+			assert.Equals(t, "instance-id", string(v))
+		case dnsNamesValidator:
+			assert.Equals(t, []string{"instance-id"}, []string(v))
+		case *spiffeSVIDEnforcer:
+			assert.Equals(t, "spiffe://example.com/azure/"+p.TenantID+"/group/instance-id", v.id.String())
+		case *x509TemplateModifier:
+			assert.Equals(t, map[string]interface{}{
+				"TenantID":        p.TenantID,
+				"ObjectID":        "oid",
+				"SubscriptionID":  "sub",
+				"ResourceGroup":   "group",
+				"VirtualMachine":  "instance-id",
+				"ScaleSet":        "vmss",
+				"InstanceID":      "instance-id",
+				"ManagedIdentity": "",
+			}, v.identity)
+		}
+	}
+
+	sshOpts, err := p.AuthorizeSSHSign(context.Background(), token)
+	assert.FatalError(t, err)
+	for _, o := range sshOpts {
+		switch v := o.(type) {
+		case sshCertKeyIDModifier:
+			assert.Equals(t, "instance-id", string(v))
+		case sshCertDefaultsModifier:
+			assert.Equals(t, []string{"instance-id"}, v.Principals)
+		case *sshTemplateModifier:
+			assert.Equals(t, "instance-id", v.identity["InstanceID"])
+		}
+	}
+}
@@ -56,6 +56,15 @@ func (c *Claimer) Claims() Claims {
 	}
 }
 
+// WithClaims returns a new claimer where the given claims take precedence over
+// the ones in the claimer. It returns the same claimer if claims is nil.
+func (c *Claimer) WithClaims(claims *Claims) (*Claimer, error) {
+	if claims == nil {
+		return c, nil
+	}
+	return NewClaimer(claims, c.Claims())
+}
+
 // DefaultTLSCertDuration returns the default TLS cert duration for the
 // provisioner. If the default is not set within the provisioner, then the global
 // default from the authority configuration will be used.
@@ -111,6 +120,21 @@ func (c *Claimer) DefaultSSHCertDuration(certType uint32) (time.Duration, error)
 	}
 }
 
+// MaxSSHCertDuration returns the maximum SSH certificate duration for the
+// given certificate type.
+func (c *Claimer) MaxSSHCertDuration(certType uint32) (time.Duration, error) {
+	switch certType {
+	case ssh.UserCert:
+		return c.MaxUserSSHCertDuration(), nil
+	case ssh.HostCert:
+		return c.MaxHostSSHCertDuration(), nil
+	case 0:
+		return 0, errors.New("ssh certificate type has not been set")
+	default:
+		return 0, errors.Errorf("ssh certificate has an unknown type: %d", certType)
+	}
+}
+
 // DefaultUserSSHCertDuration returns the default SSH user cert duration for the
 // provisioner. If the default is not set within the provisioner, then the
 // global default from the authority configuration will be used.
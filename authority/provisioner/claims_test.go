@@ -49,3 +49,88 @@ func TestClaimer_DefaultSSHCertDuration(t *testing.T) {
 		})
 	}
 }
+
+func TestClaimer_MaxSSHCertDuration(t *testing.T) {
+	duration := Duration{
+		Duration: time.Hour,
+	}
+	type fields struct {
+		global Claims
+		claims *Claims
+	}
+	type args struct {
+		certType uint32
+	}
+	tests := []struct {
+		name    string
+		fields  fields
+		args    args
+		want    time.Duration
+		wantErr bool
+	}{
+		{"user", fields{globalProvisionerClaims, &Claims{MaxUserSSHDur: &duration}}, args{1}, time.Hour, false},
+		{"user global", fields{globalProvisionerClaims, nil}, args{ssh.UserCert}, 24 * time.Hour, false},
+		{"host", fields{globalProvisionerClaims, &Claims{MaxHostSSHDur: &duration}}, args{2}, time.Hour, false},
+		{"host global", fields{globalProvisionerClaims, nil}, args{ssh.HostCert}, 30 * 24 * time.Hour, false},
+		{"invalid", fields{globalProvisionerClaims, nil}, args{0}, 0, true},
+		{"invalid global", fields{globalProvisionerClaims, nil}, args{3}, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Claimer{
+				global: tt.fields.global,
+				claims: tt.fields.claims,
+			}
+			got, err := c.MaxSSHCertDuration(tt.args.certType)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Claimer.MaxSSHCertDuration() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("Claimer.MaxSSHCertDuration() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClaimer_WithClaims(t *testing.T) {
+	claimer, err := NewClaimer(&Claims{MaxUserSSHDur: &Duration{8 * time.Hour}}, globalProvisionerClaims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		claims      *Claims
+		wantUserMax time.Duration
+		wantHostMax time.Duration
+		wantTLSMax  time.Duration
+		wantErr     bool
+	}{
+		{"nil", nil, 8 * time.Hour, 30 * 24 * time.Hour, 24 * time.Hour, false},
+		{"user", &Claims{MaxUserSSHDur: &Duration{time.Hour}}, time.Hour, 30 * 24 * time.Hour, 24 * time.Hour, false},
+		{"tls", &Claims{MaxTLSDur: &Duration{12 * time.Hour}, DefaultTLSDur: &Duration{time.Hour}}, 8 * time.Hour, 30 * 24 * time.Hour, 12 * time.Hour, false},
+		{"fail", &Claims{MinTLSDur: &Duration{48 * time.Hour}}, 0, 0, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := claimer.WithClaims(tt.claims)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Claimer.WithClaims() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			if d := got.MaxUserSSHCertDuration(); d != tt.wantUserMax {
+				t.Errorf("Claimer.WithClaims().MaxUserSSHCertDuration() = %v, want %v", d, tt.wantUserMax)
+			}
+			if d := got.MaxHostSSHCertDuration(); d != tt.wantHostMax {
+				t.Errorf("Claimer.WithClaims().MaxHostSSHCertDuration() = %v, want %v", d, tt.wantHostMax)
+			}
+			if d := got.MaxTLSCertDuration(); d != tt.wantTLSMax {
+				t.Errorf("Claimer.WithClaims().MaxTLSCertDuration() = %v, want %v", d, tt.wantTLSMax)
+			}
+		})
+	}
+}
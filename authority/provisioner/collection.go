@@ -46,7 +46,9 @@ type loadByTokenPayload struct {
 type Collection struct {
 	byID      *sync.Map
 	byKey     *sync.Map
+	mutex     sync.RWMutex
 	sorted    provisionerSlice
+	next      uint32
 	audiences Audiences
 }
 
@@ -184,10 +186,13 @@ func (c *Collection) Store(p Interface) error {
 	// Use the first 4 bytes (32bit) of the sum to insert the order
 	// Using big endian format to get the strings sorted:
 	// 0x00000000, 0x00000001, 0x00000002, ...
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
 	bi := make([]byte, 4)
 	sum := provisionerSum(p)
-	binary.BigEndian.PutUint32(bi, uint32(c.sorted.Len()))
+	binary.BigEndian.PutUint32(bi, c.next)
 	sum[0], sum[1], sum[2], sum[3] = bi[0], bi[1], bi[2], bi[3]
+	c.next++
 	c.sorted = append(c.sorted, uidProvisioner{
 		provisioner: p,
 		uid:         hex.EncodeToString(sum),
@@ -196,6 +201,51 @@ func (c *Collection) Store(p Interface) error {
 	return nil
 }
 
+// Update replaces a provisioner in the collection with a new one with the
+// same ID.
+func (c *Collection) Update(p Interface) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	old, ok := loadProvisioner(c.byID, p.GetID())
+	if !ok {
+		return errors.Errorf("provisioner %s not found", p.GetID())
+	}
+	c.byID.Store(p.GetID(), p)
+	if kid, _, ok := old.GetEncryptedKey(); ok {
+		c.byKey.Delete(kid)
+	}
+	if kid, _, ok := p.GetEncryptedKey(); ok {
+		c.byKey.Store(kid, p)
+	}
+	for i := range c.sorted {
+		if c.sorted[i].provisioner.GetID() == p.GetID() {
+			c.sorted[i].provisioner = p
+		}
+	}
+	return nil
+}
+
+// Remove deletes the provisioner with the given ID from the collection.
+func (c *Collection) Remove(id string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	p, ok := loadProvisioner(c.byID, id)
+	if !ok {
+		return errors.Errorf("provisioner %s not found", id)
+	}
+	c.byID.Delete(id)
+	if kid, _, ok := p.GetEncryptedKey(); ok {
+		c.byKey.Delete(kid)
+	}
+	for i := range c.sorted {
+		if c.sorted[i].provisioner.GetID() == id {
+			c.sorted = append(c.sorted[:i], c.sorted[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
 // Find implements pagination on a list of sorted provisioners.
 func (c *Collection) Find(cursor string, limit int) (List, string) {
 	switch {
@@ -205,6 +255,9 @@ func (c *Collection) Find(cursor string, limit int) (List, string) {
 		limit = DefaultProvisionersMax
 	}
 
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
 	n := c.sorted.Len()
 	cursor = fmt.Sprintf("%040s", cursor)
 	i := sort.Search(n, func(i int) bool { return c.sorted[i].uid >= cursor })
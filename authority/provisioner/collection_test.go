@@ -292,6 +292,61 @@ func TestCollection_Store(t *testing.T) {
 	}
 }
 
+func TestCollection_Update(t *testing.T) {
+	c := NewCollection(testAudiences)
+	p1, err := generateJWK()
+	assert.FatalError(t, err)
+	p2, err := generateJWK()
+	assert.FatalError(t, err)
+	assert.FatalError(t, c.Store(p1))
+	assert.FatalError(t, c.Store(p2))
+
+	kid, _, _ := p1.GetEncryptedKey()
+	updated := *p1
+	updated.EncryptedKey = "updated"
+	assert.FatalError(t, c.Update(&updated))
+
+	p, ok := c.Load(p1.GetID())
+	assert.True(t, ok)
+	assert.Equals(t, &updated, p)
+	key, ok := c.LoadEncryptedKey(kid)
+	assert.True(t, ok)
+	assert.Equals(t, "updated", key)
+	list, _ := c.Find("", 10)
+	assert.Equals(t, List{&updated, p2}, list)
+
+	p3, err := generateJWK()
+	assert.FatalError(t, err)
+	assert.Equals(t, "provisioner "+p3.GetID()+" not found", c.Update(p3).Error())
+}
+
+func TestCollection_Remove(t *testing.T) {
+	c := NewCollection(testAudiences)
+	p1, err := generateJWK()
+	assert.FatalError(t, err)
+	p2, err := generateJWK()
+	assert.FatalError(t, err)
+	assert.FatalError(t, c.Store(p1))
+	assert.FatalError(t, c.Store(p2))
+
+	assert.FatalError(t, c.Remove(p1.GetID()))
+	_, ok := c.Load(p1.GetID())
+	assert.False(t, ok)
+	kid, _, _ := p1.GetEncryptedKey()
+	_, ok = c.LoadEncryptedKey(kid)
+	assert.False(t, ok)
+	list, _ := c.Find("", 10)
+	assert.Equals(t, List{p2}, list)
+	assert.Equals(t, "provisioner "+p1.GetID()+" not found", c.Remove(p1.GetID()).Error())
+
+	// The order is kept after removing provisioners
+	p3, err := generateJWK()
+	assert.FatalError(t, err)
+	assert.FatalError(t, c.Store(p3))
+	list, _ = c.Find("", 10)
+	assert.Equals(t, List{p2, p3}, list)
+}
+
 func TestCollection_Find(t *testing.T) {
 	c, err := generateCollection(10, 10)
 	assert.FatalError(t, err)
@@ -48,8 +48,9 @@ type gcpComputeEnginePayload struct {
 }
 
 type gcpConfig struct {
-	CertsURL    string
-	IdentityURL string
+	CertsURL      string
+	IdentityURL   string
+	computeClient gcpComputeClient
 }
 
 func newGCPConfig() *gcpConfig {
@@ -72,19 +73,39 @@ func newGCPConfig() *gcpConfig {
 // If InstanceAge is set, only the instances with an instance_creation_timestamp
 // within the given period will be accepted.
 //
+// If Zones is set, only the instances in one of the given zones will be
+// accepted. If Labels is set, the labels of the instance are looked up using
+// the Compute Engine API, and every label key must be present in the instance
+// with one of the given values, or any value if the list is empty.
+//
+// If EnableWorkloadIdentity is true, the provisioner will also accept Google
+// identity tokens without a compute_engine block, like the ones created by GKE
+// Workload Identity or Cloud Run. Those tokens are validated using the service
+// account email, and the certificate will only contain the service account
+// email as the common name and SAN. They require ServiceAccounts or ProjectIDs
+// to be set, and they will be rejected if Zones or Labels are set.
+//
 // Google Identity docs are available at
 // https://cloud.google.com/compute/docs/instances/verifying-instance-identity
 type GCP struct {
 	*base
-	Type                   string   `json:"type"`
-	Name                   string   `json:"name"`
-	ServiceAccounts        []string `json:"serviceAccounts"`
-	ProjectIDs             []string `json:"projectIDs"`
-	DisableCustomSANs      bool     `json:"disableCustomSANs"`
-	DisableTrustOnFirstUse bool     `json:"disableTrustOnFirstUse"`
-	InstanceAge            Duration `json:"instanceAge,omitempty"`
-	Claims                 *Claims  `json:"claims,omitempty"`
+	Type                   string              `json:"type"`
+	Name                   string              `json:"name"`
+	ServiceAccounts        []string            `json:"serviceAccounts"`
+	ProjectIDs             []string            `json:"projectIDs"`
+	DisableCustomSANs      bool                `json:"disableCustomSANs"`
+	DisableTrustOnFirstUse bool                `json:"disableTrustOnFirstUse"`
+	EnableWorkloadIdentity bool                `json:"enableWorkloadIdentity,omitempty"`
+	InstanceAge            Duration            `json:"instanceAge,omitempty"`
+	Zones                  []string            `json:"zones,omitempty"`
+	Labels                 map[string][]string `json:"labels,omitempty"`
+	MetadataLookup         *GCPMetadataLookup  `json:"metadataLookup,omitempty"`
+	Claims                 *Claims             `json:"claims,omitempty"`
+	NameConstraints        *NameConstraints    `json:"nameConstraints,omitempty"`
+	Templates              *Templates          `json:"templates,omitempty"`
+	SPIFFE                 *SPIFFE             `json:"spiffe,omitempty"`
 	claimer                *Claimer
+	nameConstraints        nameConstraintsValidator
 	config                 *gcpConfig
 	keyStore               *keyStore
 	audiences              Audiences
@@ -98,7 +119,8 @@ func (p *GCP) GetID() string {
 
 // GetTokenID returns the identifier of the token. The default value for GCP the
 // SHA256 of "provisioner_id.instance_id", but if DisableTrustOnFirstUse is set
-// to true, then it will be the SHA256 of the token.
+// to true, or the token is a workload identity token, then it will be the
+// SHA256 of the token.
 func (p *GCP) GetTokenID(token string) (string, error) {
 	jwt, err := jose.ParseSigned(token)
 	if err != nil {
@@ -117,6 +139,13 @@ func (p *GCP) GetTokenID(token string) (string, error) {
 		return "", errors.Wrap(err, "error verifying claims")
 	}
 
+	// Workload identity tokens are not bound to an instance, create an ID for
+	// the token, so it cannot be reused.
+	if claims.Google.ComputeEngine.isWorkloadIdentity() {
+		sum := sha256.Sum256([]byte(token))
+		return strings.ToLower(hex.EncodeToString(sum[:])), nil
+	}
+
 	// Create unique ID for Trust On First Use (TOFU). Only the first instance
 	// per provisioner is allowed as we don't have a way to trust the given
 	// sans.
@@ -189,9 +218,32 @@ func (p *GCP) Init(config Config) error {
 		return errors.New("provisioner name cannot be empty")
 	case p.InstanceAge.Value() < 0:
 		return errors.New("provisioner instanceAge cannot be negative")
+	case p.EnableWorkloadIdentity && len(p.ServiceAccounts) == 0 && len(p.ProjectIDs) == 0:
+		return errors.New("provisioner serviceAccounts or projectIDs are required with enableWorkloadIdentity")
+	}
+	for key := range p.Labels {
+		if key == "" {
+			return errors.New("provisioner labels cannot contain an empty key")
+		}
 	}
 	// Initialize config
 	p.assertConfig()
+	// Initialize the name constraints with the global ones
+	if err = p.NameConstraints.Init(); err != nil {
+		return err
+	}
+	p.nameConstraints = newNameConstraintsValidator(p.NameConstraints, config.NameConstraints)
+
+	// Initialize the certificate templates
+	if err = p.Templates.Init(); err != nil {
+		return err
+	}
+
+	// Initialize the SPIFFE mode
+	if err = p.SPIFFE.Init(spiffeGCPPath); err != nil {
+		return err
+	}
+
 	// Update claims with global ones
 	if p.claimer, err = NewClaimer(p.Claims, config.Claims); err != nil {
 		return err
@@ -201,6 +253,12 @@ func (p *GCP) Init(config Config) error {
 	if err != nil {
 		return err
 	}
+	// Initialize the compute client if required
+	if len(p.Labels) > 0 && p.config.computeClient == nil {
+		if p.config.computeClient, err = newGCPComputeClient(p.MetadataLookup); err != nil {
+			return err
+		}
+	}
 
 	p.audiences = config.Audiences.WithFragment(p.GetID())
 	return nil
@@ -215,6 +273,13 @@ func (p *GCP) AuthorizeSign(ctx context.Context, token string) ([]SignOption, er
 	}
 
 	ce := claims.Google.ComputeEngine
+	if ce.isWorkloadIdentity() {
+		return p.authorizeWorkloadSign(claims, token)
+	}
+	if err := p.authorizeInstance(ctx, claims); err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "gcp.AuthorizeSign")
+	}
+
 	// Enforce known common name and default DNS if configured.
 	// By default we we'll accept the CN and SANs in the CSR.
 	// There's no way to trust them other than TOFU.
@@ -230,6 +295,22 @@ func (p *GCP) AuthorizeSign(ctx context.Context, token string) ([]SignOption, er
 		}))
 	}
 
+	// Issue an X.509-SVID with the project and service account
+	if p.SPIFFE != nil {
+		id, err := p.SPIFFE.ID(map[string]interface{}{
+			"ProjectID":        ce.ProjectID,
+			"ServiceAccount":   claims.Email,
+			"ServiceAccountID": claims.Subject,
+			"InstanceID":       ce.InstanceID,
+			"InstanceName":     ce.InstanceName,
+			"Zone":             ce.Zone,
+		})
+		if err != nil {
+			return nil, errs.Wrap(http.StatusUnauthorized, err, "gcp.AuthorizeSign")
+		}
+		so = append(so, &spiffeSVIDEnforcer{id: id, nameConstraints: p.nameConstraints})
+	}
+
 	return append(so,
 		// modifiers / withOptions
 		newProvisionerExtensionOption(TypeGCP, p.Name, claims.Subject, "InstanceID", ce.InstanceID, "InstanceName", ce.InstanceName),
@@ -237,6 +318,45 @@ func (p *GCP) AuthorizeSign(ctx context.Context, token string) ([]SignOption, er
 		// validators
 		defaultPublicKeyValidator{},
 		newValidityValidator(p.claimer.MinTLSCertDuration(), p.claimer.MaxTLSCertDuration()),
+		p.nameConstraints,
+		// template modifiers
+		newX509TemplateModifier(p.Templates, token),
+	), nil
+}
+
+// authorizeWorkloadSign returns the sign options for a workload identity
+// token. The only identity that can be trusted is the service account, so the
+// certificate must contain the service account email as the common name and
+// the only SAN.
+func (p *GCP) authorizeWorkloadSign(claims *gcpPayload, token string) ([]SignOption, error) {
+	so := []SignOption{
+		commonNameValidator(claims.Email),
+		emailOnlyIdentity(claims.Email),
+	}
+
+	// Issue an X.509-SVID with the project and service account
+	if p.SPIFFE != nil {
+		id, err := p.SPIFFE.ID(map[string]interface{}{
+			"ProjectID":        gcpServiceAccountProject(claims.Email),
+			"ServiceAccount":   claims.Email,
+			"ServiceAccountID": claims.Subject,
+		})
+		if err != nil {
+			return nil, errs.Wrap(http.StatusUnauthorized, err, "gcp.AuthorizeSign")
+		}
+		so = append(so, &spiffeSVIDEnforcer{id: id, nameConstraints: p.nameConstraints})
+	}
+
+	return append(so,
+		// modifiers / withOptions
+		newProvisionerExtensionOption(TypeGCP, p.Name, claims.Subject, "ServiceAccount", claims.Email),
+		profileDefaultDuration(p.claimer.DefaultTLSCertDuration()),
+		// validators
+		defaultPublicKeyValidator{},
+		newValidityValidator(p.claimer.MinTLSCertDuration(), p.claimer.MaxTLSCertDuration()),
+		p.nameConstraints,
+		// template modifiers
+		newX509TemplateModifier(p.Templates, token),
 	), nil
 }
 
@@ -310,11 +430,28 @@ func (p *GCP) authorizeToken(token string) (*gcpPayload, error) {
 		}
 	}
 
+	// Validate workload identity tokens, they do not contain a compute_engine
+	// block, and the project is the one in the service account email.
+	ce := claims.Google.ComputeEngine
+	if ce.isWorkloadIdentity() && p.EnableWorkloadIdentity {
+		switch {
+		case claims.Email == "":
+			return nil, errs.Unauthorized("gcp.authorizeToken; gcp token email cannot be empty")
+		case !claims.EmailVerified:
+			return nil, errs.Unauthorized("gcp.authorizeToken; gcp token email is not verified")
+		case p.requiresInstance():
+			return nil, errs.Unauthorized("gcp.authorizeToken; gcp token google.compute_engine is required by the provisioner")
+		case len(p.ProjectIDs) > 0 && !containsString(p.ProjectIDs, gcpServiceAccountProject(claims.Email)):
+			return nil, errs.Unauthorized("gcp.authorizeToken; invalid gcp token - invalid project id")
+		}
+		return &claims, nil
+	}
+
 	// validate projects
 	if len(p.ProjectIDs) > 0 {
 		var found bool
 		for _, pi := range p.ProjectIDs {
-			if pi == claims.Google.ComputeEngine.ProjectID {
+			if pi == ce.ProjectID {
 				found = true
 				break
 			}
@@ -324,6 +461,11 @@ func (p *GCP) authorizeToken(token string) (*gcpPayload, error) {
 		}
 	}
 
+	// validate zones
+	if len(p.Zones) > 0 && !containsString(p.Zones, ce.Zone) {
+		return nil, errs.Unauthorized("gcp.authorizeToken; invalid gcp token - invalid zone")
+	}
+
 	// validate instance age
 	if d := p.InstanceAge.Value(); d > 0 {
 		if now.Sub(claims.Google.ComputeEngine.InstanceCreationTimestamp.Time()) > d {
@@ -356,6 +498,12 @@ func (p *GCP) AuthorizeSSHSign(ctx context.Context, token string) ([]SignOption,
 	}
 
 	ce := claims.Google.ComputeEngine
+	if ce.isWorkloadIdentity() {
+		return nil, errs.Unauthorized("gcp.AuthorizeSSHSign; ssh certificates are not supported for workload identity tokens")
+	}
+	if err := p.authorizeInstance(ctx, claims); err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "gcp.AuthorizeSSHSign")
+	}
 
 	signOptions := []SignOption{
 		// set the key id to the instance name
@@ -384,6 +532,8 @@ func (p *GCP) AuthorizeSSHSign(ctx context.Context, token string) ([]SignOption,
 	return append(signOptions,
 		// Set the default extensions
 		&sshDefaultExtensionModifier{},
+		// Set the template options
+		newSSHTemplateModifier(p.Templates, token),
 		// Set the validity bounds if not set.
 		&sshDefaultDuration{p.claimer},
 		// Validate public key
@@ -0,0 +1,116 @@
+package provisioner
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/errs"
+	"google.golang.org/api/compute/v1"
+	"google.golang.org/api/option"
+)
+
+// gcpServiceAccountDomain is the domain of the email of user-managed service
+// accounts, prefixed by the project id.
+const gcpServiceAccountDomain = ".iam.gserviceaccount.com"
+
+// GCPMetadataLookup configures the credentials and endpoint used to look up
+// the labels of an instance using the Compute Engine API. The credentials are
+// loaded from the application default credentials, or from the given
+// credentials file if set. The endpoint can be used to point the lookups to a
+// different, or local, implementation of the Compute Engine API.
+type GCPMetadataLookup struct {
+	CredentialsFile string `json:"credentialsFile,omitempty"`
+	Endpoint        string `json:"endpoint,omitempty"`
+}
+
+// gcpComputeClient defines the methods used to get the instance metadata
+// from the Compute Engine API.
+type gcpComputeClient interface {
+	GetInstanceLabels(ctx context.Context, project, zone, instance string) (map[string]string, error)
+}
+
+// gcpComputeService implements gcpComputeClient using the Compute Engine API.
+type gcpComputeService struct {
+	service *compute.Service
+}
+
+// newGCPComputeClient creates a new Compute Engine client using the given
+// options.
+func newGCPComputeClient(l *GCPMetadataLookup) (gcpComputeClient, error) {
+	var opts []option.ClientOption
+	if l != nil {
+		if l.CredentialsFile != "" {
+			opts = append(opts, option.WithCredentialsFile(l.CredentialsFile))
+		}
+		if l.Endpoint != "" {
+			opts = append(opts, option.WithEndpoint(l.Endpoint))
+		}
+	}
+	service, err := compute.NewService(context.Background(), opts...)
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating compute client")
+	}
+	return &gcpComputeService{service: service}, nil
+}
+
+// GetInstanceLabels returns the labels of the given instance.
+func (s *gcpComputeService) GetInstanceLabels(ctx context.Context, project, zone, instance string) (map[string]string, error) {
+	i, err := s.service.Instances.Get(project, zone, instance).Context(ctx).Do()
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting instance")
+	}
+	return i.Labels, nil
+}
+
+// isWorkloadIdentity returns true if the token does not contain a
+// compute_engine block, this is the case of the tokens created by GKE Workload
+// Identity, Cloud Run or any other non-GCE Google identity.
+func (c *gcpComputeEnginePayload) isWorkloadIdentity() bool {
+	return c.InstanceID == "" && c.InstanceName == "" && c.ProjectID == "" && c.Zone == ""
+}
+
+// gcpServiceAccountProject returns the project id of a user-managed service
+// account, e.g. name@project-id.iam.gserviceaccount.com returns project-id. It
+// returns an empty string for other accounts.
+func gcpServiceAccountProject(email string) string {
+	i := strings.LastIndex(email, "@")
+	if i == -1 || !strings.HasSuffix(email, gcpServiceAccountDomain) {
+		return ""
+	}
+	return strings.TrimSuffix(email[i+1:], gcpServiceAccountDomain)
+}
+
+// requiresInstance returns true if the provisioner has constraints that can
+// only be satisfied by GCE instances.
+func (p *GCP) requiresInstance() bool {
+	return len(p.Zones) > 0 || len(p.Labels) > 0
+}
+
+// authorizeInstance looks up the labels of the instance in the given payload
+// and validates them against the labels constraint. It does nothing if the
+// provisioner does not define labels.
+func (p *GCP) authorizeInstance(ctx context.Context, claims *gcpPayload) error {
+	if len(p.Labels) == 0 {
+		return nil
+	}
+
+	ce := claims.Google.ComputeEngine
+	labels, err := p.config.computeClient.GetInstanceLabels(ctx, ce.ProjectID, ce.Zone, ce.InstanceName)
+	if err != nil {
+		return errs.Wrapf(http.StatusUnauthorized, err, "gcp.authorizeInstance; error looking up instance %s", ce.InstanceID)
+	}
+
+	// validate labels, all of them must be present and have one of the values
+	for key, values := range p.Labels {
+		v, ok := labels[key]
+		if !ok {
+			return errs.Unauthorized("gcp.authorizeInstance; gcp instance label %s is missing", key)
+		}
+		if len(values) > 0 && !containsString(values, v) {
+			return errs.Unauthorized("gcp.authorizeInstance; gcp instance label %s is not valid", key)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,350 @@
+package provisioner
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/errs"
+	"github.com/smallstep/cli/jose"
+)
+
+type mockGCPComputeClient struct {
+	getInstanceLabels func(ctx context.Context, project, zone, instance string) (map[string]string, error)
+}
+
+func (m *mockGCPComputeClient) GetInstanceLabels(ctx context.Context, project, zone, instance string) (map[string]string, error) {
+	return m.getInstanceLabels(ctx, project, zone, instance)
+}
+
+func withGCPLabels(p *GCP, labels map[string]string, err error) {
+	config := *p.config
+	config.computeClient = &mockGCPComputeClient{
+		getInstanceLabels: func(ctx context.Context, project, zone, instance string) (map[string]string, error) {
+			if project != "project-id" || zone != "zone" || instance != "instance-name" {
+				return nil, errors.Errorf("unexpected instance %s/%s/%s", project, zone, instance)
+			}
+			return labels, err
+		},
+	}
+	p.config = &config
+}
+
+func generateGCPWorkloadToken(sub, email, aud string, iat time.Time, jwk *jose.JSONWebKey) (string, error) {
+	sig, err := jose.NewSigner(
+		jose.SigningKey{Algorithm: jose.ES256, Key: jwk.Key},
+		new(jose.SignerOptions).WithType("JWT").WithHeader("kid", jwk.KeyID),
+	)
+	if err != nil {
+		return "", err
+	}
+	aud, err = generateSignAudience("https://ca.smallstep.com", aud)
+	if err != nil {
+		return "", err
+	}
+	claims := gcpPayload{
+		Claims: jose.Claims{
+			Subject:   sub,
+			Issuer:    "https://accounts.google.com",
+			IssuedAt:  jose.NewNumericDate(iat),
+			NotBefore: jose.NewNumericDate(iat),
+			Expiry:    jose.NewNumericDate(iat.Add(5 * time.Minute)),
+			Audience:  []string{aud},
+		},
+		AuthorizedParty: sub,
+		Email:           email,
+		EmailVerified:   email != "",
+	}
+	return jose.Signed(sig).Claims(claims).CompactSerialize()
+}
+
+func Test_gcpServiceAccountProject(t *testing.T) {
+	tests := []struct {
+		email string
+		want  string
+	}{
+		{"foo@project-id.iam.gserviceaccount.com", "project-id"},
+		{"1234567890-compute@developer.gserviceaccount.com", ""},
+		{"foo@bar.com", ""},
+		{"project-id.iam.gserviceaccount.com", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.email, func(t *testing.T) {
+			assert.Equals(t, tt.want, gcpServiceAccountProject(tt.email))
+		})
+	}
+}
+
+func TestGCP_Init_workload(t *testing.T) {
+	srv := generateJWKServer(2)
+	defer srv.Close()
+
+	config := Config{
+		Claims: globalProvisionerClaims,
+	}
+	mock := &mockGCPComputeClient{}
+	tests := []struct {
+		name    string
+		gcp     *GCP
+		wantErr bool
+	}{
+		{"ok workload service accounts", &GCP{Type: "GCP", Name: "name", EnableWorkloadIdentity: true, ServiceAccounts: []string{"sa@project-id.iam.gserviceaccount.com"}}, false},
+		{"ok workload projects", &GCP{Type: "GCP", Name: "name", EnableWorkloadIdentity: true, ProjectIDs: []string{"project-id"}}, false},
+		{"ok zones", &GCP{Type: "GCP", Name: "name", Zones: []string{"us-central1-a"}}, false},
+		{"ok labels", &GCP{Type: "GCP", Name: "name", Labels: map[string][]string{"env": {"prod"}, "team": nil}}, false},
+		{"fail workload", &GCP{Type: "GCP", Name: "name", EnableWorkloadIdentity: true}, true},
+		{"fail labels", &GCP{Type: "GCP", Name: "name", Labels: map[string][]string{"": {"prod"}}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.gcp.config = &gcpConfig{
+				CertsURL:      srv.URL,
+				IdentityURL:   gcpIdentityURL,
+				computeClient: mock,
+			}
+			if err := tt.gcp.Init(config); (err != nil) != tt.wantErr {
+				t.Errorf("GCP.Init() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestGCP_GetTokenID_workload(t *testing.T) {
+	p, err := generateGCP()
+	assert.FatalError(t, err)
+	p.EnableWorkloadIdentity = true
+
+	token, err := generateGCPWorkloadToken("subject", "sa@project-id.iam.gserviceaccount.com", p.GetID(), time.Now(), &p.keyStore.keySet.Keys[0])
+	assert.FatalError(t, err)
+
+	sum := sha256.Sum256([]byte(token))
+	got, err := p.GetTokenID(token)
+	assert.FatalError(t, err)
+	assert.Equals(t, hex.EncodeToString(sum[:]), got)
+}
+
+func TestGCP_authorizeToken_workload(t *testing.T) {
+	p1, err := generateGCP()
+	assert.FatalError(t, err)
+	p1.EnableWorkloadIdentity = true
+	p1.ServiceAccounts = []string{"sa@project-id.iam.gserviceaccount.com"}
+
+	p2, err := generateGCP()
+	assert.FatalError(t, err)
+	p2.EnableWorkloadIdentity = true
+	p2.ServiceAccounts = nil
+	p2.ProjectIDs = []string{"project-id"}
+
+	p3, err := generateGCP()
+	assert.FatalError(t, err)
+	p3.ServiceAccounts = p1.ServiceAccounts
+
+	p4, err := generateGCP()
+	assert.FatalError(t, err)
+	p4.EnableWorkloadIdentity = true
+	p4.ServiceAccounts = p1.ServiceAccounts
+	p4.Zones = []string{"zone"}
+
+	p5, err := generateGCP()
+	assert.FatalError(t, err)
+	p5.EnableWorkloadIdentity = true
+	p5.ServiceAccounts = p1.ServiceAccounts
+	p5.Labels = map[string][]string{"env": nil}
+
+	p6, err := generateGCP()
+	assert.FatalError(t, err)
+	p6.Zones = []string{"zone"}
+
+	gen := func(p *GCP, sub, email string) string {
+		tok, err := generateGCPWorkloadToken(sub, email, p.GetID(), time.Now(), &p.keyStore.keySet.Keys[0])
+		assert.FatalError(t, err)
+		return tok
+	}
+	genInstance := func(p *GCP, zone string) string {
+		tok, err := generateGCPToken(p.ServiceAccounts[0],
+			"https://accounts.google.com", p.GetID(),
+			"instance-id", "instance-name", "project-id", zone,
+			time.Now(), &p.keyStore.keySet.Keys[0])
+		assert.FatalError(t, err)
+		return tok
+	}
+
+	tests := []struct {
+		name  string
+		p     *GCP
+		token string
+		err   error
+	}{
+		{"ok service account", p1, gen(p1, "subject", "sa@project-id.iam.gserviceaccount.com"), nil},
+		{"ok service account subject", p1, gen(p1, "sa@project-id.iam.gserviceaccount.com", "sa@project-id.iam.gserviceaccount.com"), nil},
+		{"ok project", p2, gen(p2, "subject", "other@project-id.iam.gserviceaccount.com"), nil},
+		{"ok zone", p6, genInstance(p6, "zone"), nil},
+		{"fail service account", p1, gen(p1, "subject", "other@project-id.iam.gserviceaccount.com"),
+			errors.New("gcp.authorizeToken; invalid gcp token - invalid subject claim")},
+		{"fail project", p2, gen(p2, "subject", "sa@other-project.iam.gserviceaccount.com"),
+			errors.New("gcp.authorizeToken; invalid gcp token - invalid project id")},
+		{"fail default service account", p2, gen(p2, "subject", "1234567890-compute@developer.gserviceaccount.com"),
+			errors.New("gcp.authorizeToken; invalid gcp token - invalid project id")},
+		{"fail email", p2, gen(p2, "subject", ""),
+			errors.New("gcp.authorizeToken; gcp token email cannot be empty")},
+		{"fail disabled", p3, gen(p3, "subject", "sa@project-id.iam.gserviceaccount.com"),
+			errors.New("gcp.authorizeToken; gcp token google.compute_engine.instance_id cannot be empty")},
+		{"fail zones", p4, gen(p4, "subject", "sa@project-id.iam.gserviceaccount.com"),
+			errors.New("gcp.authorizeToken; gcp token google.compute_engine is required by the provisioner")},
+		{"fail labels", p5, gen(p5, "subject", "sa@project-id.iam.gserviceaccount.com"),
+			errors.New("gcp.authorizeToken; gcp token google.compute_engine is required by the provisioner")},
+		{"fail zone", p6, genInstance(p6, "other-zone"),
+			errors.New("gcp.authorizeToken; invalid gcp token - invalid zone")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := tt.p.authorizeToken(tt.token)
+			if tt.err != nil {
+				if assert.Error(t, err) {
+					sc, ok := err.(errs.StatusCoder)
+					assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+					assert.Equals(t, http.StatusUnauthorized, sc.StatusCode())
+					assert.HasPrefix(t, err.Error(), tt.err.Error())
+				}
+				assert.Nil(t, claims)
+				return
+			}
+			assert.FatalError(t, err)
+			assert.NotNil(t, claims)
+		})
+	}
+}
+
+func TestGCP_authorizeInstance(t *testing.T) {
+	p, err := generateGCP()
+	assert.FatalError(t, err)
+	token, err := generateGCPToken(p.ServiceAccounts[0],
+		"https://accounts.google.com", p.GetID(),
+		"instance-id", "instance-name", "project-id", "zone",
+		time.Now(), &p.keyStore.keySet.Keys[0])
+	assert.FatalError(t, err)
+	claims, err := p.authorizeToken(token)
+	assert.FatalError(t, err)
+
+	tests := []struct {
+		name        string
+		labels      map[string][]string
+		instance    map[string]string
+		instanceErr error
+		err         error
+	}{
+		{"ok no labels", nil, nil, errors.New("force"), nil},
+		{"ok value", map[string][]string{"env": {"dev", "prod"}}, map[string]string{"env": "prod", "team": "foo"}, nil, nil},
+		{"ok any value", map[string][]string{"env": {"prod"}, "team": nil}, map[string]string{"env": "prod", "team": "foo"}, nil, nil},
+		{"fail lookup", map[string][]string{"env": nil}, nil, errors.New("force"),
+			errors.New("gcp.authorizeInstance; error looking up instance instance-id")},
+		{"fail missing", map[string][]string{"env": {"prod"}, "team": nil}, map[string]string{"env": "prod"}, nil,
+			errors.New("gcp.authorizeInstance; gcp instance label team is missing")},
+		{"fail value", map[string][]string{"env": {"prod"}}, map[string]string{"env": "dev"}, nil,
+			errors.New("gcp.authorizeInstance; gcp instance label env is not valid")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p.Labels = tt.labels
+			withGCPLabels(p, tt.instance, tt.instanceErr)
+			err := p.authorizeInstance(context.Background(), claims)
+			if tt.err != nil {
+				if assert.Error(t, err) {
+					sc, ok := err.(errs.StatusCoder)
+					assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+					assert.Equals(t, http.StatusUnauthorized, sc.StatusCode())
+					assert.HasPrefix(t, err.Error(), tt.err.Error())
+				}
+				return
+			}
+			assert.FatalError(t, err)
+		})
+	}
+}
+
+func TestGCP_AuthorizeSign_workload(t *testing.T) {
+	p, err := generateGCP()
+	assert.FatalError(t, err)
+	p.EnableWorkloadIdentity = true
+	p.ServiceAccounts = []string{"sa@project-id.iam.gserviceaccount.com"}
+
+	token, err := generateGCPWorkloadToken("subject", "sa@project-id.iam.gserviceaccount.com", p.GetID(), time.Now(), &p.keyStore.keySet.Keys[0])
+	assert.FatalError(t, err)
+
+	opts, err := p.AuthorizeSign(context.Background(), token)
+	assert.FatalError(t, err)
+	assert.Len(t, 8, opts)
+
+	valid := &x509.CertificateRequest{
+		Subject:        pkix.Name{CommonName: "sa@project-id.iam.gserviceaccount.com"},
+		EmailAddresses: []string{"sa@project-id.iam.gserviceaccount.com"},
+	}
+	invalid := &x509.CertificateRequest{
+		Subject:        pkix.Name{CommonName: "sa@project-id.iam.gserviceaccount.com"},
+		DNSNames:       []string{"foo.bar"},
+		EmailAddresses: []string{"sa@project-id.iam.gserviceaccount.com"},
+	}
+	for _, o := range opts {
+		switch v := o.(type) {
+		case commonNameValidator:
+			assert.NoError(t, v.Valid(valid))
+		case emailOnlyIdentity:
+			assert.NoError(t, v.Valid(valid))
+			assert.Error(t, v.Valid(invalid))
+		case *provisionerExtensionOption:
+			assert.Equals(t, v.Type, int(TypeGCP))
+			assert.Equals(t, v.Name, p.GetName())
+			assert.Equals(t, v.CredentialID, "subject")
+			assert.Equals(t, v.KeyValuePairs, []string{"ServiceAccount", "sa@project-id.iam.gserviceaccount.com"})
+		}
+	}
+
+	// SSH certificates are not supported
+	_, err = p.AuthorizeSSHSign(context.Background(), token)
+	if assert.Error(t, err) {
+		sc, ok := err.(errs.StatusCoder)
+		assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+		assert.Equals(t, http.StatusUnauthorized, sc.StatusCode())
+	}
+}
+
+func TestGCP_AuthorizeSign_labels(t *testing.T) {
+	p, err := generateGCP()
+	assert.FatalError(t, err)
+	p.Labels = map[string][]string{"env": {"prod"}}
+
+	token, err := generateGCPToken(p.ServiceAccounts[0],
+		"https://accounts.google.com", p.GetID(),
+		"instance-id", "instance-name", "project-id", "zone",
+		time.Now(), &p.keyStore.keySet.Keys[0])
+	assert.FatalError(t, err)
+
+	withGCPLabels(p, map[string]string{"env": "prod"}, nil)
+	opts, err := p.AuthorizeSign(context.Background(), token)
+	assert.FatalError(t, err)
+	assert.Len(t, 6, opts)
+
+	withGCPLabels(p, map[string]string{"env": "dev"}, nil)
+	opts, err = p.AuthorizeSign(context.Background(), token)
+	if assert.Error(t, err) {
+		sc, ok := err.(errs.StatusCoder)
+		assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+		assert.Equals(t, http.StatusUnauthorized, sc.StatusCode())
+	}
+	assert.Nil(t, opts)
+
+	_, err = p.AuthorizeSSHSign(context.Background(), token)
+	if assert.Error(t, err) {
+		sc, ok := err.(errs.StatusCoder)
+		assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+		assert.Equals(t, http.StatusUnauthorized, sc.StatusCode())
+	}
+}
@@ -515,9 +515,9 @@ func TestGCP_AuthorizeSign(t *testing.T) {
 		code    int
 		wantErr bool
 	}{
-		{"ok", p1, args{t1}, 4, http.StatusOK, false},
-		{"ok", p2, args{t2}, 6, http.StatusOK, false},
-		{"ok", p3, args{t3}, 4, http.StatusOK, false},
+		{"ok", p1, args{t1}, 6, http.StatusOK, false},
+		{"ok", p2, args{t2}, 8, http.StatusOK, false},
+		{"ok", p3, args{t3}, 6, http.StatusOK, false},
 		{"fail token", p1, args{"token"}, 0, http.StatusUnauthorized, true},
 		{"fail key", p1, args{failKey}, 0, http.StatusUnauthorized, true},
 		{"fail iss", p1, args{failIss}, 0, http.StatusUnauthorized, true},
@@ -550,6 +550,51 @@ func TestGCP_AuthorizeSign(t *testing.T) {
 	}
 }
 
+func TestGCP_AuthorizeSign_spiffe(t *testing.T) {
+	p, err := generateGCP()
+	assert.FatalError(t, err)
+
+	token, err := generateGCPToken(p.ServiceAccounts[0],
+		"https://accounts.google.com", p.GetID(),
+		"instance-id", "instance-name", "project-id", "zone",
+		time.Now(), &p.keyStore.keySet.Keys[0])
+	assert.FatalError(t, err)
+
+	tests := []struct {
+		name string
+		path string
+		want string
+		err  error
+	}{
+		{"ok", "", "spiffe://example.com/gcp/project-id/sa/" + p.ServiceAccounts[0], nil},
+		{"ok instance", "/gcp/{{ .ProjectID }}/{{ .Zone }}/{{ .InstanceName }}", "spiffe://example.com/gcp/project-id/zone/instance-name", nil},
+		{"fail email", "/gcp/{{ .ProjectID }}/sa/{{ .ServiceAccount }}", "",
+			errors.New("gcp.AuthorizeSign: spiffe path /gcp/project-id/sa/foo@developer.gserviceaccount.com is not valid")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p.SPIFFE = &SPIFFE{TrustDomain: "example.com", Path: tt.path}
+			assert.FatalError(t, p.SPIFFE.Init(spiffeGCPPath))
+			opts, err := p.AuthorizeSign(context.Background(), token)
+			if tt.err != nil {
+				if assert.Error(t, err) {
+					assert.HasPrefix(t, err.Error(), tt.err.Error())
+				}
+				return
+			}
+			assert.FatalError(t, err)
+			var found bool
+			for _, o := range opts {
+				if v, ok := o.(*spiffeSVIDEnforcer); ok {
+					found = true
+					assert.Equals(t, tt.want, v.id.String())
+				}
+			}
+			assert.True(t, found)
+		})
+	}
+}
+
 func TestGCP_AuthorizeSSHSign(t *testing.T) {
 	tm, fn := mockNow()
 	defer fn()
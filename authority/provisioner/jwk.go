@@ -27,13 +27,16 @@ type stepPayload struct {
 // signature requests.
 type JWK struct {
 	*base
-	Type         string           `json:"type"`
-	Name         string           `json:"name"`
-	Key          *jose.JSONWebKey `json:"key"`
-	EncryptedKey string           `json:"encryptedKey,omitempty"`
-	Claims       *Claims          `json:"claims,omitempty"`
-	claimer      *Claimer
-	audiences    Audiences
+	Type            string           `json:"type"`
+	Name            string           `json:"name"`
+	Key             *jose.JSONWebKey `json:"key"`
+	EncryptedKey    string           `json:"encryptedKey,omitempty"`
+	Claims          *Claims          `json:"claims,omitempty"`
+	NameConstraints *NameConstraints `json:"nameConstraints,omitempty"`
+	Templates       *Templates       `json:"templates,omitempty"`
+	claimer         *Claimer
+	nameConstraints nameConstraintsValidator
+	audiences       Audiences
 }
 
 // GetID returns the provisioner unique identifier. The name and credential id
@@ -86,6 +89,17 @@ func (p *JWK) Init(config Config) (err error) {
 		return errors.New("provisioner key cannot be empty")
 	}
 
+	// Initialize the name constraints with the global ones
+	if err = p.NameConstraints.Init(); err != nil {
+		return err
+	}
+	p.nameConstraints = newNameConstraintsValidator(p.NameConstraints, config.NameConstraints)
+
+	// Initialize the certificate templates
+	if err = p.Templates.Init(); err != nil {
+		return err
+	}
+
 	// Update claims with global ones
 	if p.claimer, err = NewClaimer(p.Claims, config.Claims); err != nil {
 		return err
@@ -138,6 +152,12 @@ func (p *JWK) AuthorizeRevoke(ctx context.Context, token string) error {
 	return errs.Wrap(http.StatusInternalServerError, err, "jwk.AuthorizeRevoke")
 }
 
+// AuthorizeAdmin returns an error if the token is not valid for the admin API.
+func (p *JWK) AuthorizeAdmin(ctx context.Context, token string) error {
+	_, err := p.authorizeToken(token, p.audiences.Admin)
+	return errs.Wrap(http.StatusInternalServerError, err, "jwk.AuthorizeAdmin")
+}
+
 // AuthorizeSign validates the given token.
 func (p *JWK) AuthorizeSign(ctx context.Context, token string) ([]SignOption, error) {
 	claims, err := p.authorizeToken(token, p.audiences.Sign)
@@ -164,6 +184,9 @@ func (p *JWK) AuthorizeSign(ctx context.Context, token string) ([]SignOption, er
 		emailAddressesValidator(emails),
 		ipAddressesValidator(ips),
 		newValidityValidator(p.claimer.MinTLSCertDuration(), p.claimer.MaxTLSCertDuration()),
+		p.nameConstraints,
+		// template modifiers
+		newX509TemplateModifier(p.Templates, token),
 	}, nil
 }
 
@@ -224,6 +247,8 @@ func (p *JWK) AuthorizeSSHSign(ctx context.Context, token string) ([]SignOption,
 	return append(signOptions,
 		// Set the default extensions.
 		&sshDefaultExtensionModifier{},
+		// Set the template options
+		newSSHTemplateModifier(p.Templates, token),
 		// Set the validity bounds if not set.
 		&sshDefaultDuration{p.claimer},
 		// Validate that the keyID is equivalent to the token subject.
@@ -234,6 +234,42 @@ func TestJWK_AuthorizeRevoke(t *testing.T) {
 	}
 }
 
+func TestJWK_AuthorizeAdmin(t *testing.T) {
+	p1, err := generateJWK()
+	assert.FatalError(t, err)
+	key1, err := decryptJSONWebKey(p1.EncryptedKey)
+	assert.FatalError(t, err)
+	t1, err := generateSimpleToken(p1.Name, testAudiences.Admin[0], key1)
+	assert.FatalError(t, err)
+	t2, err := generateSimpleToken(p1.Name, testAudiences.Sign[0], key1)
+	assert.FatalError(t, err)
+
+	tests := []struct {
+		name  string
+		token string
+		code  int
+		err   error
+	}{
+		{"fail-signature", t1[0 : len(t1)-2], http.StatusUnauthorized, errors.New("jwk.AuthorizeAdmin: jwk.authorizeToken; error parsing jwk claims: square/go-jose: error in cryptographic primitive")},
+		{"fail-audience", t2, http.StatusUnauthorized, errors.New("jwk.AuthorizeAdmin: jwk.authorizeToken; invalid jwk token audience claim (aud)")},
+		{"ok", t1, http.StatusOK, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := p1.AuthorizeAdmin(context.Background(), tt.token); err != nil {
+				if assert.NotNil(t, tt.err) {
+					sc, ok := err.(errs.StatusCoder)
+					assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+					assert.Equals(t, sc.StatusCode(), tt.code)
+					assert.HasPrefix(t, err.Error(), tt.err.Error())
+				}
+			} else {
+				assert.Nil(t, tt.err)
+			}
+		})
+	}
+}
+
 func TestJWK_AuthorizeSign(t *testing.T) {
 	p1, err := generateJWK()
 	assert.FatalError(t, err)
@@ -278,7 +314,7 @@ func TestJWK_AuthorizeSign(t *testing.T) {
 				}
 			} else {
 				if assert.NotNil(t, got) {
-					assert.Len(t, 8, got)
+					assert.Len(t, 10, got)
 					for _, o := range got {
 						switch v := o.(type) {
 						case *provisionerExtensionOption:
@@ -297,6 +333,8 @@ func TestJWK_AuthorizeSign(t *testing.T) {
 							assert.Equals(t, []string(v), tt.emails)
 						case ipAddressesValidator:
 							assert.Equals(t, []net.IP(v), tt.ips)
+						case nameConstraintsValidator:
+						case *x509TemplateModifier:
 						case *validityValidator:
 							assert.Equals(t, v.min, tt.prov.claimer.MinTLSCertDuration())
 							assert.Equals(t, v.max, tt.prov.claimer.MaxTLSCertDuration())
@@ -1,15 +1,23 @@
 package provisioner
 
 import (
+	"bytes"
 	"context"
 	"crypto/ecdsa"
 	"crypto/rsa"
+	"crypto/tls"
 	"crypto/x509"
+	"encoding/json"
 	"encoding/pem"
+	"io/ioutil"
 	"net/http"
+	"net/url"
+	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/smallstep/certificates/errs"
+	"github.com/smallstep/cli/config"
 	"github.com/smallstep/cli/crypto/pemutil"
 	"github.com/smallstep/cli/jose"
 	"golang.org/x/crypto/ed25519"
@@ -25,6 +33,12 @@ const (
 	// K8sSAID is the default ID for kubernetes service account provisioners.
 	K8sSAID     = "k8ssa/" + K8sSAName
 	k8sSAIssuer = "kubernetes/serviceaccount"
+
+	k8sTokenReviewPath      = "/apis/authentication.k8s.io/v1/tokenreviews"
+	k8sTokenReviewTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	k8sServiceAccountPrefix = "system:serviceaccount:"
+	k8sPodNameExtra         = "authentication.kubernetes.io/pod-name"
+	k8sPodUIDExtra          = "authentication.kubernetes.io/pod-uid"
 )
 
 // jwtPayload extends jwt.Claims with step attributes.
@@ -34,20 +48,26 @@ type k8sSAPayload struct {
 	SecretName         string `json:"kubernetes.io/serviceaccount/secret.name,omitempty"`
 	ServiceAccountName string `json:"kubernetes.io/serviceaccount/service-account.name,omitempty"`
 	ServiceAccountUID  string `json:"kubernetes.io/serviceaccount/service-account.uid,omitempty"`
+	PodName            string `json:"-"`
+	PodUID             string `json:"-"`
 }
 
 // K8sSA represents a Kubernetes ServiceAccount provisioner; an
 // entity trusted to make signature requests.
 type K8sSA struct {
 	*base
-	Type      string  `json:"type"`
-	Name      string  `json:"name"`
-	Claims    *Claims `json:"claims,omitempty"`
-	PubKeys   []byte  `json:"publicKeys,omitempty"`
-	claimer   *Claimer
-	audiences Audiences
-	//kauthn    kauthn.AuthenticationV1Interface
-	pubKeys []interface{}
+	Type            string           `json:"type"`
+	Name            string           `json:"name"`
+	Claims          *Claims          `json:"claims,omitempty"`
+	NameConstraints *NameConstraints `json:"nameConstraints,omitempty"`
+	Templates       *Templates       `json:"templates,omitempty"`
+	SPIFFE          *SPIFFE          `json:"spiffe,omitempty"`
+	PubKeys         []byte           `json:"publicKeys,omitempty"`
+	TokenReview     *K8sTokenReview  `json:"tokenReview,omitempty"`
+	claimer         *Claimer
+	nameConstraints nameConstraintsValidator
+	audiences       Audiences
+	pubKeys         []interface{}
 }
 
 // GetID returns the provisioner unique identifier. The name and credential id
@@ -84,6 +104,10 @@ func (p *K8sSA) Init(config Config) (err error) {
 		return errors.New("provisioner type cannot be empty")
 	case p.Name == "":
 		return errors.New("provisioner name cannot be empty")
+	case p.PubKeys != nil && p.TokenReview != nil:
+		return errors.New("K8s Service Account provisioner cannot be initialized with both pub keys and tokenReview")
+	case p.PubKeys == nil && p.TokenReview == nil:
+		return errors.New("K8s Service Account provisioner cannot be initialized without pub keys or tokenReview")
 	}
 
 	if p.PubKeys != nil {
@@ -107,23 +131,28 @@ func (p *K8sSA) Init(config Config) (err error) {
 			}
 			p.pubKeys = append(p.pubKeys, key)
 		}
-	} else {
-		// TODO: Use the TokenReview API if no pub keys provided. This will need to
-		// be configured with additional attributes in the K8sSA struct for
-		// connecting to the kubernetes API server.
-		return errors.New("K8s Service Account provisioner cannot be initialized without pub keys")
-	}
-	/*
-		// NOTE: Not sure if we should be doing this initialization here ...
-		// If you have a k8sSA provisioner defined in your config, but you're not
-		// in a kubernetes pod then your CA will fail to startup. Maybe we just postpone
-		// creating the authn until token validation time?
-		if err := checkAccess(k8s.AuthorizationV1()); err != nil {
-			return errors.Wrapf(err, "error verifying access to kubernetes authz service for provisioner %s", p.GetID())
-		}
+	}
+
+	// Initialize the TokenReview API client
+	if err = p.TokenReview.Init(); err != nil {
+		return err
+	}
+
+	// Initialize the name constraints with the global ones
+	if err = p.NameConstraints.Init(); err != nil {
+		return err
+	}
+	p.nameConstraints = newNameConstraintsValidator(p.NameConstraints, config.NameConstraints)
+
+	// Initialize the certificate templates
+	if err = p.Templates.Init(); err != nil {
+		return err
+	}
 
-		p.kauthn = k8s.AuthenticationV1()
-	*/
+	// Initialize the SPIFFE mode
+	if err = p.SPIFFE.Init(spiffeK8sSAPath); err != nil {
+		return err
+	}
 
 	// Update claims with global ones
 	if p.claimer, err = NewClaimer(p.Claims, config.Claims); err != nil {
@@ -144,32 +173,16 @@ func (p *K8sSA) authorizeToken(token string, audiences []string) (*k8sSAPayload,
 			"k8ssa.authorizeToken; error parsing k8sSA token")
 	}
 
+	// Projected tokens are verified by the API server, it checks the
+	// signature, expiration, audiences and that the bound pod still exists.
+	if p.TokenReview != nil {
+		return p.TokenReview.authorize(token)
+	}
+
 	var (
 		valid  bool
 		claims k8sSAPayload
 	)
-	if p.pubKeys == nil {
-		return nil, errs.Unauthorized("k8ssa.authorizeToken; k8sSA TokenReview API integration not implemented")
-		/* NOTE: We plan to support the TokenReview API in a future release.
-		         Below is some code that should be useful when we prioritize
-				 this integration.
-
-			tr := kauthnApi.TokenReview{Spec: kauthnApi.TokenReviewSpec{Token: string(token)}}
-			rvw, err := p.kauthn.TokenReviews().Create(&tr)
-			if err != nil {
-				return nil, errors.Wrap(err, "error using kubernetes TokenReview API")
-			}
-			if rvw.Status.Error != "" {
-				return nil, errors.Errorf("error from kubernetes TokenReviewAPI: %s", rvw.Status.Error)
-			}
-			if !rvw.Status.Authenticated {
-				return nil, errors.New("error from kubernetes TokenReviewAPI: token could not be authenticated")
-			}
-			if err = jwt.UnsafeClaimsWithoutVerification(&claims); err != nil {
-				return nil, errors.Wrap(err, "error parsing claims")
-			}
-		*/
-	}
 	for _, pk := range p.pubKeys {
 		if err = jwt.Claims(pk, &claims); err == nil {
 			valid = true
@@ -195,6 +208,18 @@ func (p *K8sSA) authorizeToken(token string, audiences []string) (*k8sSAPayload,
 	return &claims, nil
 }
 
+// identity returns the verified identity of the given claims. It is used to
+// render the SPIFFE ID and the certificate templates. The pod name and uid are
+// only available in TokenReview mode.
+func (p *K8sSA) identity(claims *k8sSAPayload) map[string]interface{} {
+	return map[string]interface{}{
+		"Namespace":      claims.Namespace,
+		"ServiceAccount": claims.ServiceAccountName,
+		"PodName":        claims.PodName,
+		"PodUID":         claims.PodUID,
+	}
+}
+
 // AuthorizeRevoke returns an error if the provisioner does not have rights to
 // revoke the certificate with serial number in the `sub` property.
 func (p *K8sSA) AuthorizeRevoke(ctx context.Context, token string) error {
@@ -204,18 +229,33 @@ func (p *K8sSA) AuthorizeRevoke(ctx context.Context, token string) error {
 
 // AuthorizeSign validates the given token.
 func (p *K8sSA) AuthorizeSign(ctx context.Context, token string) ([]SignOption, error) {
-	if _, err := p.authorizeToken(token, p.audiences.Sign); err != nil {
+	claims, err := p.authorizeToken(token, p.audiences.Sign)
+	if err != nil {
 		return nil, errs.Wrap(http.StatusInternalServerError, err, "k8ssa.AuthorizeSign")
 	}
+	identity := p.identity(claims)
 
-	return []SignOption{
+	so := []SignOption{
 		// modifiers / withOptions
 		newProvisionerExtensionOption(TypeK8sSA, p.Name, ""),
 		profileDefaultDuration(p.claimer.DefaultTLSCertDuration()),
 		// validators
 		defaultPublicKeyValidator{},
 		newValidityValidator(p.claimer.MinTLSCertDuration(), p.claimer.MaxTLSCertDuration()),
-	}, nil
+		p.nameConstraints,
+		// template modifiers
+		newX509TemplateModifier(p.Templates, token).withIdentity(identity),
+	}
+
+	// Issue an X.509-SVID with the namespace and service account
+	if p.SPIFFE != nil {
+		id, err := p.SPIFFE.ID(identity)
+		if err != nil {
+			return nil, errs.Wrap(http.StatusUnauthorized, err, "k8ssa.AuthorizeSign")
+		}
+		so = append(so, &spiffeSVIDEnforcer{id: id, nameConstraints: p.nameConstraints})
+	}
+	return so, nil
 }
 
 // AuthorizeRenew returns an error if the renewal is disabled.
@@ -231,7 +271,8 @@ func (p *K8sSA) AuthorizeSSHSign(ctx context.Context, token string) ([]SignOptio
 	if !p.claimer.IsSSHCAEnabled() {
 		return nil, errs.Unauthorized("k8ssa.AuthorizeSSHSign; sshCA is disabled for k8sSA provisioner %s", p.GetID())
 	}
-	if _, err := p.authorizeToken(token, p.audiences.SSHSign); err != nil {
+	claims, err := p.authorizeToken(token, p.audiences.SSHSign)
+	if err != nil {
 		return nil, errs.Wrap(http.StatusInternalServerError, err, "k8ssa.AuthorizeSSHSign")
 	}
 
@@ -241,6 +282,8 @@ func (p *K8sSA) AuthorizeSSHSign(ctx context.Context, token string) ([]SignOptio
 	return append(signOptions,
 		// Set the default extensions.
 		&sshDefaultExtensionModifier{},
+		// Set the template options
+		newSSHTemplateModifier(p.Templates, token).withIdentity(p.identity(claims)),
 		// Set the validity bounds if not set.
 		&sshDefaultDuration{p.claimer},
 		// Validate public key
@@ -252,26 +295,183 @@ func (p *K8sSA) AuthorizeSSHSign(ctx context.Context, token string) ([]SignOptio
 	), nil
 }
 
-/*
-func checkAccess(authz kauthz.AuthorizationV1Interface) error {
-	r := &kauthzApi.SelfSubjectAccessReview{
-		Spec: kauthzApi.SelfSubjectAccessReviewSpec{
-			ResourceAttributes: &kauthzApi.ResourceAttributes{
-				Group:    "authentication.k8s.io",
-				Version:  "v1",
-				Resource: "tokenreviews",
-				Verb:     "create",
-			},
+// K8sTokenReview is the configuration used to verify service account tokens
+// using the TokenReview API of a Kubernetes API server.
+type K8sTokenReview struct {
+	URL       string   `json:"url"`
+	CABundle  []byte   `json:"caBundle,omitempty"`
+	TokenFile string   `json:"tokenFile,omitempty"`
+	Audiences []string `json:"audiences,omitempty"`
+	tokenFile string
+	client    *http.Client
+}
+
+// Init validates the TokenReview configuration and initializes the client
+// used to connect to the API server.
+func (r *K8sTokenReview) Init() error {
+	if r == nil {
+		return nil
+	}
+	if r.URL == "" {
+		return errors.New("tokenReview url cannot be empty")
+	}
+	u, err := url.Parse(r.URL)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return errors.Errorf("tokenReview url %s is not valid", r.URL)
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+	if len(r.CABundle) > 0 {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(r.CABundle) {
+			return errors.New("tokenReview caBundle does not contain any valid certificate")
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if r.TokenFile == "" {
+		r.tokenFile = k8sTokenReviewTokenFile
+	} else {
+		r.tokenFile = config.StepAbs(r.TokenFile)
+	}
+
+	r.client = &http.Client{
+		Timeout: 15 * time.Second,
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: tlsConfig,
+		},
+	}
+	return nil
+}
+
+// k8sTokenReview is the TokenReview object of the authentication.k8s.io/v1
+// API.
+type k8sTokenReview struct {
+	APIVersion string                `json:"apiVersion"`
+	Kind       string                `json:"kind"`
+	Spec       k8sTokenReviewSpec    `json:"spec"`
+	Status     *k8sTokenReviewStatus `json:"status,omitempty"`
+}
+
+type k8sTokenReviewSpec struct {
+	Token     string   `json:"token"`
+	Audiences []string `json:"audiences,omitempty"`
+}
+
+type k8sTokenReviewStatus struct {
+	Authenticated bool     `json:"authenticated"`
+	Audiences     []string `json:"audiences,omitempty"`
+	Error         string   `json:"error,omitempty"`
+	User          struct {
+		Username string              `json:"username"`
+		UID      string              `json:"uid"`
+		Groups   []string            `json:"groups,omitempty"`
+		Extra    map[string][]string `json:"extra,omitempty"`
+	} `json:"user"`
+}
+
+// review sends the token to the TokenReview API and returns the status of the
+// review. The CA authenticates to the API server with the token in the token
+// file, the file is read on every request because projected tokens are
+// rotated by the kubelet.
+func (r *K8sTokenReview) review(token string) (*k8sTokenReviewStatus, error) {
+	bearer, err := ioutil.ReadFile(r.tokenFile)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading %s", r.tokenFile)
+	}
+
+	body, err := json.Marshal(k8sTokenReview{
+		APIVersion: "authentication.k8s.io/v1",
+		Kind:       "TokenReview",
+		Spec: k8sTokenReviewSpec{
+			Token:     token,
+			Audiences: r.Audiences,
 		},
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "error marshaling token review")
 	}
-	rvw, err := authz.SelfSubjectAccessReviews().Create(r)
+
+	req, err := http.NewRequest("POST", strings.TrimSuffix(r.URL, "/")+k8sTokenReviewPath, bytes.NewReader(body))
 	if err != nil {
-		return err
+		return nil, errors.Wrap(err, "error creating request")
 	}
-	if !rvw.Status.Allowed {
-		return fmt.Errorf("Unable to create kubernetes token reviews: %s", rvw.Status.Reason)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(bearer)))
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating token review")
 	}
+	defer resp.Body.Close()
 
-	return nil
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading token review response")
+	}
+	if resp.StatusCode >= 400 {
+		return nil, errors.Errorf("error creating token review: status=%d, response=%s", resp.StatusCode, b)
+	}
+
+	var tr k8sTokenReview
+	if err := json.Unmarshal(b, &tr); err != nil {
+		return nil, errors.Wrap(err, "error unmarshaling token review response")
+	}
+	if tr.Status == nil {
+		return nil, errors.New("error creating token review: response does not contain a status")
+	}
+	return tr.Status, nil
+}
+
+// authorize verifies the token using the TokenReview API and returns the
+// claims of the service account and the pod the token is bound to.
+func (r *K8sTokenReview) authorize(token string) (*k8sSAPayload, error) {
+	status, err := r.review(token)
+	if err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "k8ssa.authorizeToken")
+	}
+
+	switch {
+	case status.Error != "":
+		return nil, errs.Unauthorized("k8ssa.authorizeToken; error validating k8sSA token: %s", status.Error)
+	case !status.Authenticated:
+		return nil, errs.Unauthorized("k8ssa.authorizeToken; k8sSA token is not authenticated")
+	case len(r.Audiences) > 0 && !matchesAudience(status.Audiences, r.Audiences):
+		return nil, errs.Unauthorized("k8ssa.authorizeToken; k8sSA token has invalid audiences; "+
+			"expected %s, but got %s", r.Audiences, status.Audiences)
+	}
+
+	// The username of a service account is system:serviceaccount:<ns>:<name>
+	user := status.User
+	parts := strings.Split(strings.TrimPrefix(user.Username, k8sServiceAccountPrefix), ":")
+	if !strings.HasPrefix(user.Username, k8sServiceAccountPrefix) || len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, errs.Unauthorized("k8ssa.authorizeToken; k8sSA token user %s is not a service account", user.Username)
+	}
+
+	// Only tokens bound to a pod are accepted, the pod is the identity of the
+	// requester.
+	var podName, podUID string
+	if v := user.Extra[k8sPodNameExtra]; len(v) > 0 {
+		podName = v[0]
+	}
+	if v := user.Extra[k8sPodUIDExtra]; len(v) > 0 {
+		podUID = v[0]
+	}
+	if podName == "" || podUID == "" {
+		return nil, errs.Unauthorized("k8ssa.authorizeToken; k8sSA token is not bound to a pod")
+	}
+
+	return &k8sSAPayload{
+		Claims: jose.Claims{
+			Subject:  user.Username,
+			Audience: status.Audiences,
+		},
+		Namespace:          parts[0],
+		ServiceAccountName: parts[1],
+		ServiceAccountUID:  user.UID,
+		PodName:            podName,
+		PodUID:             podUID,
+	}, nil
 }
-*/
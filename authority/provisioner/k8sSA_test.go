@@ -3,7 +3,11 @@ package provisioner
 import (
 	"context"
 	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
+	"os"
 	"testing"
 	"time"
 
@@ -33,12 +37,61 @@ func TestK8sSA_Getters(t *testing.T) {
 	}
 }
 
+func TestK8sTokenReview_Init(t *testing.T) {
+	srv := httptest.NewTLSServer(http.NotFoundHandler())
+	defer srv.Close()
+	caBundle := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+
+	tests := map[string]struct {
+		r   *K8sTokenReview
+		err error
+	}{
+		"ok/nil":         {nil, nil},
+		"ok":             {&K8sTokenReview{URL: srv.URL, CABundle: caBundle}, nil},
+		"ok/system-pool": {&K8sTokenReview{URL: "https://kubernetes.default.svc", TokenFile: "token"}, nil},
+		"fail/empty":     {&K8sTokenReview{}, errors.New("tokenReview url cannot be empty")},
+		"fail/http":      {&K8sTokenReview{URL: "http://kubernetes.default.svc"}, errors.New("tokenReview url http://kubernetes.default.svc is not valid")},
+		"fail/host":      {&K8sTokenReview{URL: "https:///apis"}, errors.New("tokenReview url https:///apis is not valid")},
+		"fail/caBundle":  {&K8sTokenReview{URL: srv.URL, CABundle: []byte("foo")}, errors.New("tokenReview caBundle does not contain any valid certificate")},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if err := tc.r.Init(); err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.Equals(t, tc.err.Error(), err.Error())
+				}
+			} else if assert.Nil(t, tc.err) && tc.r != nil {
+				assert.NotNil(t, tc.r.client)
+				assert.NotEquals(t, "", tc.r.tokenFile)
+			}
+		})
+	}
+}
+
 func TestK8sSA_authorizeToken(t *testing.T) {
+	tokenFile, err := ioutil.TempFile("", "k8ssa-token")
+	assert.FatalError(t, err)
+	defer os.Remove(tokenFile.Name())
+	_, err = tokenFile.WriteString("ca-token\n")
+	assert.FatalError(t, err)
+	assert.FatalError(t, tokenFile.Close())
+
 	type test struct {
-		p     *K8sSA
-		token string
-		err   error
-		code  int
+		p      *K8sSA
+		srv    *httptest.Server
+		token  string
+		claims *k8sSAPayload
+		err    error
+		code   int
+	}
+	tokenReview := func(t *testing.T, audiences []string, status *k8sTokenReviewStatus) test {
+		jwk, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
+		assert.FatalError(t, err)
+		p, srv, err := generateK8sSAWithTokenReview(tokenFile.Name(), audiences, status)
+		assert.FatalError(t, err)
+		tok, err := generateK8sSAToken(jwk, nil)
+		assert.FatalError(t, err)
+		return test{p: p, srv: srv, token: tok}
 	}
 	tests := map[string]func(*testing.T) test{
 		"fail/bad-token": func(t *testing.T) test {
@@ -51,22 +104,6 @@ func TestK8sSA_authorizeToken(t *testing.T) {
 				err:   errors.New("k8ssa.authorizeToken; error parsing k8sSA token"),
 			}
 		},
-		"fail/not-implemented": func(t *testing.T) test {
-			jwk, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
-			assert.FatalError(t, err)
-			p, err := generateK8sSA(nil)
-			assert.FatalError(t, err)
-			tok, err := generateToken("", p.Name, testAudiences.Sign[0], "",
-				[]string{"test.smallstep.com"}, time.Now(), jwk)
-			p.pubKeys = nil
-			assert.FatalError(t, err)
-			return test{
-				p:     p,
-				token: tok,
-				err:   errors.New("k8ssa.authorizeToken; k8sSA TokenReview API integration not implemented"),
-				code:  http.StatusUnauthorized,
-			}
-		},
 		"fail/error-validating-token": func(t *testing.T) test {
 			jwk, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
 			assert.FatalError(t, err)
@@ -110,10 +147,87 @@ func TestK8sSA_authorizeToken(t *testing.T) {
 				token: tok,
 			}
 		},
+		"ok/tokenreview": func(t *testing.T) test {
+			tc := tokenReview(t, []string{"step-ca"}, func() *k8sTokenReviewStatus {
+				status := newK8sTokenReviewStatus()
+				status.Audiences = []string{"step-ca"}
+				return status
+			}())
+			tc.claims = &k8sSAPayload{
+				Claims: jose.Claims{
+					Subject:  "system:serviceaccount:ns-foo:san-foo",
+					Audience: []string{"step-ca"},
+				},
+				Namespace:          "ns-foo",
+				ServiceAccountName: "san-foo",
+				ServiceAccountUID:  "sauid-foo",
+				PodName:            "pod-foo",
+				PodUID:             "poduid-foo",
+			}
+			return tc
+		},
+		"fail/tokenreview-forbidden": func(t *testing.T) test {
+			tc := tokenReview(t, nil, newK8sTokenReviewStatus())
+			tc.p.TokenReview.tokenFile = "./testdata/certs/foo.pub"
+			tc.code = http.StatusInternalServerError
+			tc.err = errors.New("k8ssa.authorizeToken: error creating token review: status=403")
+			return tc
+		},
+		"fail/tokenreview-token-file": func(t *testing.T) test {
+			tc := tokenReview(t, nil, newK8sTokenReviewStatus())
+			tc.p.TokenReview.tokenFile = "./testdata/certs/missing"
+			tc.code = http.StatusInternalServerError
+			tc.err = errors.New("k8ssa.authorizeToken: error reading ./testdata/certs/missing")
+			return tc
+		},
+		"fail/tokenreview-error": func(t *testing.T) test {
+			status := newK8sTokenReviewStatus()
+			status.Authenticated = false
+			status.Error = "token has expired"
+			tc := tokenReview(t, nil, status)
+			tc.code = http.StatusUnauthorized
+			tc.err = errors.New("k8ssa.authorizeToken; error validating k8sSA token: token has expired")
+			return tc
+		},
+		"fail/tokenreview-not-authenticated": func(t *testing.T) test {
+			status := newK8sTokenReviewStatus()
+			status.Authenticated = false
+			tc := tokenReview(t, nil, status)
+			tc.code = http.StatusUnauthorized
+			tc.err = errors.New("k8ssa.authorizeToken; k8sSA token is not authenticated")
+			return tc
+		},
+		"fail/tokenreview-audiences": func(t *testing.T) test {
+			status := newK8sTokenReviewStatus()
+			status.Audiences = []string{"https://kubernetes.default.svc"}
+			tc := tokenReview(t, []string{"step-ca"}, status)
+			tc.code = http.StatusUnauthorized
+			tc.err = errors.New("k8ssa.authorizeToken; k8sSA token has invalid audiences")
+			return tc
+		},
+		"fail/tokenreview-user": func(t *testing.T) test {
+			status := newK8sTokenReviewStatus()
+			status.User.Username = "system:node:node-foo"
+			tc := tokenReview(t, nil, status)
+			tc.code = http.StatusUnauthorized
+			tc.err = errors.New("k8ssa.authorizeToken; k8sSA token user system:node:node-foo is not a service account")
+			return tc
+		},
+		"fail/tokenreview-not-bound": func(t *testing.T) test {
+			status := newK8sTokenReviewStatus()
+			status.User.Extra = nil
+			tc := tokenReview(t, nil, status)
+			tc.code = http.StatusUnauthorized
+			tc.err = errors.New("k8ssa.authorizeToken; k8sSA token is not bound to a pod")
+			return tc
+		},
 	}
 	for name, tt := range tests {
 		t.Run(name, func(t *testing.T) {
 			tc := tt(t)
+			if tc.srv != nil {
+				defer tc.srv.Close()
+			}
 			if claims, err := tc.p.authorizeToken(tc.token, testAudiences.Sign); err != nil {
 				if assert.NotNil(t, tc.err) {
 					sc, ok := err.(errs.StatusCoder)
@@ -122,8 +236,8 @@ func TestK8sSA_authorizeToken(t *testing.T) {
 					assert.HasPrefix(t, err.Error(), tc.err.Error())
 				}
 			} else {
-				if assert.Nil(t, tc.err) {
-					assert.NotNil(t, claims)
+				if assert.Nil(t, tc.err) && assert.NotNil(t, claims) && tc.claims != nil {
+					assert.Equals(t, tc.claims, claims)
 				}
 			}
 		})
@@ -228,11 +342,21 @@ func TestK8sSA_AuthorizeRenew(t *testing.T) {
 }
 
 func TestK8sSA_AuthorizeSign(t *testing.T) {
+	tokenFile, err := ioutil.TempFile("", "k8ssa-token")
+	assert.FatalError(t, err)
+	defer os.Remove(tokenFile.Name())
+	_, err = tokenFile.WriteString("ca-token")
+	assert.FatalError(t, err)
+	assert.FatalError(t, tokenFile.Close())
+
 	type test struct {
-		p     *K8sSA
-		token string
-		code  int
-		err   error
+		p        *K8sSA
+		srv      *httptest.Server
+		token    string
+		spiffeID string
+		identity map[string]interface{}
+		code     int
+		err      error
 	}
 	tests := map[string]func(*testing.T) test{
 		"fail/invalid-token": func(t *testing.T) test {
@@ -257,10 +381,72 @@ func TestK8sSA_AuthorizeSign(t *testing.T) {
 				token: tok,
 			}
 		},
+		"ok/spiffe": func(t *testing.T) test {
+			jwk, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
+			assert.FatalError(t, err)
+			p, err := generateK8sSA(jwk.Public().Key)
+			assert.FatalError(t, err)
+			p.SPIFFE = &SPIFFE{TrustDomain: "example.com"}
+			assert.FatalError(t, p.SPIFFE.Init(spiffeK8sSAPath))
+			tok, err := generateK8sSAToken(jwk, nil)
+			assert.FatalError(t, err)
+			return test{
+				p:        p,
+				token:    tok,
+				spiffeID: "spiffe://example.com/ns/ns-foo/sa/san-foo",
+				identity: map[string]interface{}{
+					"Namespace":      "ns-foo",
+					"ServiceAccount": "san-foo",
+					"PodName":        "",
+					"PodUID":         "",
+				},
+			}
+		},
+		"ok/spiffe-tokenreview": func(t *testing.T) test {
+			jwk, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
+			assert.FatalError(t, err)
+			p, srv, err := generateK8sSAWithTokenReview(tokenFile.Name(), nil, newK8sTokenReviewStatus())
+			assert.FatalError(t, err)
+			p.SPIFFE = &SPIFFE{TrustDomain: "example.com", Path: "/ns/{{ .Namespace }}/pod/{{ .PodName }}/{{ .PodUID }}"}
+			assert.FatalError(t, p.SPIFFE.Init(spiffeK8sSAPath))
+			tok, err := generateK8sSAToken(jwk, nil)
+			assert.FatalError(t, err)
+			return test{
+				p:        p,
+				srv:      srv,
+				token:    tok,
+				spiffeID: "spiffe://example.com/ns/ns-foo/pod/pod-foo/poduid-foo",
+				identity: map[string]interface{}{
+					"Namespace":      "ns-foo",
+					"ServiceAccount": "san-foo",
+					"PodName":        "pod-foo",
+					"PodUID":         "poduid-foo",
+				},
+			}
+		},
+		"fail/spiffe": func(t *testing.T) test {
+			jwk, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
+			assert.FatalError(t, err)
+			p, err := generateK8sSA(jwk.Public().Key)
+			assert.FatalError(t, err)
+			p.SPIFFE = &SPIFFE{TrustDomain: "example.com", Path: "/ns/{{ .Namespace }}/sa/{{ .ServiceAccount }}/{{ .Foo }}"}
+			assert.FatalError(t, p.SPIFFE.Init(spiffeK8sSAPath))
+			tok, err := generateK8sSAToken(jwk, nil)
+			assert.FatalError(t, err)
+			return test{
+				p:     p,
+				token: tok,
+				code:  http.StatusUnauthorized,
+				err:   errors.New("k8ssa.AuthorizeSign: error executing spiffe path"),
+			}
+		},
 	}
 	for name, tt := range tests {
 		t.Run(name, func(t *testing.T) {
 			tc := tt(t)
+			if tc.srv != nil {
+				defer tc.srv.Close()
+			}
 			if opts, err := tc.p.AuthorizeSign(context.Background(), tc.token); err != nil {
 				if assert.NotNil(t, tc.err) {
 					sc, ok := err.(errs.StatusCoder)
@@ -282,15 +468,26 @@ func TestK8sSA_AuthorizeSign(t *testing.T) {
 							case profileDefaultDuration:
 								assert.Equals(t, time.Duration(v), tc.p.claimer.DefaultTLSCertDuration())
 							case defaultPublicKeyValidator:
+							case nameConstraintsValidator:
+							case *x509TemplateModifier:
+								if tc.identity != nil {
+									assert.Equals(t, tc.identity, v.identity)
+								}
 							case *validityValidator:
 								assert.Equals(t, v.min, tc.p.claimer.MinTLSCertDuration())
 								assert.Equals(t, v.max, tc.p.claimer.MaxTLSCertDuration())
+							case *spiffeSVIDEnforcer:
+								assert.Equals(t, tc.spiffeID, v.id.String())
 							default:
 								assert.FatalError(t, errors.Errorf("unexpected sign option of type %T", v))
 							}
 							tot++
 						}
-						assert.Equals(t, tot, 4)
+						if tc.spiffeID != "" {
+							assert.Equals(t, tot, 7)
+						} else {
+							assert.Equals(t, tot, 6)
+						}
 					}
 				}
 			}
@@ -363,6 +560,13 @@ func TestK8sSA_AuthorizeSSHSign(t *testing.T) {
 							case sshCertDefaultsModifier:
 								assert.Equals(t, v.CertType, SSHUserCert)
 							case *sshDefaultExtensionModifier:
+							case *sshTemplateModifier:
+								assert.Equals(t, map[string]interface{}{
+									"Namespace":      "ns-foo",
+									"ServiceAccount": "san-foo",
+									"PodName":        "",
+									"PodUID":         "",
+								}, v.identity)
 							case *sshCertValidityValidator:
 								assert.Equals(t, v.Claimer, tc.p.claimer)
 							case *sshDefaultPublicKeyValidator:
@@ -374,7 +578,7 @@ func TestK8sSA_AuthorizeSSHSign(t *testing.T) {
 							}
 							tot++
 						}
-						assert.Equals(t, tot, 6)
+						assert.Equals(t, tot, 7)
 					}
 				}
 			}
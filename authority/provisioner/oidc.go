@@ -2,7 +2,9 @@ package provisioner
 
 import (
 	"context"
+	"crypto/sha256"
 	"crypto/x509"
+	"encoding/hex"
 	"encoding/json"
 	"net"
 	"net/http"
@@ -19,8 +21,10 @@ import (
 // openIDConfiguration contains the necessary properties in the
 // `/.well-known/openid-configuration` document.
 type openIDConfiguration struct {
-	Issuer    string `json:"issuer"`
-	JWKSetURI string `json:"jwks_uri"`
+	Issuer                      string `json:"issuer"`
+	JWKSetURI                   string `json:"jwks_uri"`
+	TokenEndpoint               string `json:"token_endpoint"`
+	DeviceAuthorizationEndpoint string `json:"device_authorization_endpoint"`
 }
 
 // Validate validates the values in a well-known OpenID configuration endpoint.
@@ -35,6 +39,19 @@ func (c openIDConfiguration) Validate() error {
 	}
 }
 
+// ValidateDeviceAuthorization validates the endpoints required by the device
+// authorization grant.
+func (c openIDConfiguration) ValidateDeviceAuthorization() error {
+	switch {
+	case c.DeviceAuthorizationEndpoint == "":
+		return errors.New("device_authorization_endpoint cannot be empty")
+	case c.TokenEndpoint == "":
+		return errors.New("token_endpoint cannot be empty")
+	default:
+		return nil
+	}
+}
+
 // openIDPayload represents the fields on the id_token JWT payload.
 type openIDPayload struct {
 	jose.Claims
@@ -45,27 +62,43 @@ type openIDPayload struct {
 	Hd              string   `json:"hd"`
 	Nonce           string   `json:"nonce"`
 	Groups          []string `json:"groups"`
+	raw             map[string]interface{}
 }
 
 // OIDC represents an OAuth 2.0 OpenID Connect provider.
 //
 // ClientSecret is mandatory, but it can be an empty string.
+//
+// Rules map the claims in the token to SSH principals, X.509 SANs and claims.
+// If DisableEmailPrincipals is set, the SSH principals of non-admin users are
+// only the ones in the matching rules, instead of the ones derived from the
+// email.
+//
+// If EnableDeviceAuthorization is set, the CA can be used to get an ID token
+// using the device authorization grant, with the given Scopes.
 type OIDC struct {
 	*base
-	Type                  string   `json:"type"`
-	Name                  string   `json:"name"`
-	ClientID              string   `json:"clientID"`
-	ClientSecret          string   `json:"clientSecret"`
-	ConfigurationEndpoint string   `json:"configurationEndpoint"`
-	Admins                []string `json:"admins,omitempty"`
-	Domains               []string `json:"domains,omitempty"`
-	Groups                []string `json:"groups,omitempty"`
-	ListenAddress         string   `json:"listenAddress,omitempty"`
-	Claims                *Claims  `json:"claims,omitempty"`
-	configuration         openIDConfiguration
-	keyStore              *keyStore
-	claimer               *Claimer
-	getIdentityFunc       GetIdentityFunc
+	Type                      string           `json:"type"`
+	Name                      string           `json:"name"`
+	ClientID                  string           `json:"clientID"`
+	ClientSecret              string           `json:"clientSecret"`
+	ConfigurationEndpoint     string           `json:"configurationEndpoint"`
+	Admins                    []string         `json:"admins,omitempty"`
+	Domains                   []string         `json:"domains,omitempty"`
+	Groups                    []string         `json:"groups,omitempty"`
+	Rules                     []*OIDCRule      `json:"rules,omitempty"`
+	DisableEmailPrincipals    bool             `json:"disableEmailPrincipals,omitempty"`
+	EnableDeviceAuthorization bool             `json:"enableDeviceAuthorization,omitempty"`
+	Scopes                    []string         `json:"scopes,omitempty"`
+	ListenAddress             string           `json:"listenAddress,omitempty"`
+	Claims                    *Claims          `json:"claims,omitempty"`
+	NameConstraints           *NameConstraints `json:"nameConstraints,omitempty"`
+	Templates                 *Templates       `json:"templates,omitempty"`
+	configuration             openIDConfiguration
+	keyStore                  *keyStore
+	claimer                   *Claimer
+	nameConstraints           nameConstraintsValidator
+	getIdentityFunc           GetIdentityFunc
 }
 
 // IsAdmin returns true if the given email is in the Admins whitelist, false
@@ -109,6 +142,12 @@ func (o *OIDC) GetTokenID(ott string) (string, error) {
 	if err = token.UnsafeClaimsWithoutVerification(&claims); err != nil {
 		return "", errors.Wrap(err, "error verifying claims")
 	}
+	// Tokens obtained using the device authorization grant do not have a
+	// nonce, use the hash of the token in that case.
+	if claims.Nonce == "" {
+		sum := sha256.Sum256([]byte(ott))
+		return strings.ToLower(hex.EncodeToString(sum[:])), nil
+	}
 	return claims.Nonce, nil
 }
 
@@ -147,11 +186,32 @@ func (o *OIDC) Init(config Config) (err error) {
 		}
 	}
 
+	// Initialize the name constraints with the global ones
+	if err = o.NameConstraints.Init(); err != nil {
+		return err
+	}
+	o.nameConstraints = newNameConstraintsValidator(o.NameConstraints, config.NameConstraints)
+
+	// Initialize the certificate templates
+	if err = o.Templates.Init(); err != nil {
+		return err
+	}
+
 	// Update claims with global ones
 	if o.claimer, err = NewClaimer(o.Claims, config.Claims); err != nil {
 		return err
 	}
 
+	// Initialize the claims mapping rules
+	for _, r := range o.Rules {
+		if err = r.Init(o.claimer); err != nil {
+			return err
+		}
+	}
+	if err = o.validateRules(); err != nil {
+		return err
+	}
+
 	// Decode and validate openid-configuration endpoint
 	u, err := url.Parse(o.ConfigurationEndpoint)
 	if err != nil {
@@ -166,6 +226,11 @@ func (o *OIDC) Init(config Config) (err error) {
 	if err := o.configuration.Validate(); err != nil {
 		return errors.Wrapf(err, "error parsing %s", o.ConfigurationEndpoint)
 	}
+	if o.EnableDeviceAuthorization {
+		if err := o.configuration.ValidateDeviceAuthorization(); err != nil {
+			return errors.Wrapf(err, "error parsing %s", o.ConfigurationEndpoint)
+		}
+	}
 	// Get JWK key set
 	o.keyStore, err = newKeyStore(o.configuration.JWKSetURI)
 	if err != nil {
@@ -246,9 +311,9 @@ func (o *OIDC) authorizeToken(token string) (*openIDPayload, error) {
 			"oidc.AuthorizeToken; error parsing oidc token")
 	}
 
-	// Parse claims to get the kid
+	// Parse claims to get the kid, the raw claims are used in the rules
 	var claims openIDPayload
-	if err := jwt.UnsafeClaimsWithoutVerification(&claims); err != nil {
+	if err := jwt.UnsafeClaimsWithoutVerification(&claims, &claims.raw); err != nil {
 		return nil, errs.Wrap(http.StatusUnauthorized, err,
 			"oidc.AuthorizeToken; error parsing oidc token claims")
 	}
@@ -295,20 +360,31 @@ func (o *OIDC) AuthorizeSign(ctx context.Context, token string) ([]SignOption, e
 	if err != nil {
 		return nil, errs.Wrap(http.StatusInternalServerError, err, "oidc.AuthorizeSign")
 	}
+	rules, err := o.applyRules(claims)
+	if err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "oidc.AuthorizeSign")
+	}
 
 	so := []SignOption{
 		// modifiers / withOptions
 		newProvisionerExtensionOption(TypeOIDC, o.Name, o.ClientID),
-		profileDefaultDuration(o.claimer.DefaultTLSCertDuration()),
+		profileDefaultDuration(rules.claimer.DefaultTLSCertDuration()),
 		// validators
 		defaultPublicKeyValidator{},
-		newValidityValidator(o.claimer.MinTLSCertDuration(), o.claimer.MaxTLSCertDuration()),
+		newValidityValidator(rules.claimer.MinTLSCertDuration(), rules.claimer.MaxTLSCertDuration()),
+		o.nameConstraints,
+		// template modifiers
+		newX509TemplateModifier(o.Templates, token),
 	}
 	// Admins should be able to authorize any SAN
 	if o.IsAdmin(claims.Email) {
 		return so, nil
 	}
 
+	// Non-admin users can use their email and the SANs in the matching rules
+	if len(rules.sans) > 0 {
+		return append(so, sansAllowlistValidator(appendMissing([]string{claims.Email}, rules.sans...))), nil
+	}
 	return append(so, emailOnlyIdentity(claims.Email)), nil
 }
 
@@ -332,6 +408,13 @@ func (o *OIDC) AuthorizeSSHSign(ctx context.Context, token string) ([]SignOption
 	if err != nil {
 		return nil, errs.Wrap(http.StatusInternalServerError, err, "oidc.AuthorizeSSHSign")
 	}
+	rules, err := o.applyRules(claims)
+	if err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "oidc.AuthorizeSSHSign")
+	}
+	if !rules.claimer.IsSSHCAEnabled() {
+		return nil, errs.Unauthorized("oidc.AuthorizeSSHSign; sshCA is disabled by the rules of oidc provisioner %s", o.GetID())
+	}
 	signOptions := []SignOption{
 		// set the key id to the token email
 		sshCertKeyIDModifier(claims.Email),
@@ -339,19 +422,26 @@ func (o *OIDC) AuthorizeSSHSign(ctx context.Context, token string) ([]SignOption
 
 	// Get the identity using either the default identityFunc or one injected
 	// externally.
-	iden, err := o.getIdentityFunc(ctx, o, claims.Email)
-	if err != nil {
-		return nil, errs.Wrap(http.StatusInternalServerError, err, "oidc.AuthorizeSSHSign")
+	var principals []string
+	if !o.DisableEmailPrincipals {
+		iden, err := o.getIdentityFunc(ctx, o, claims.Email)
+		if err != nil {
+			return nil, errs.Wrap(http.StatusInternalServerError, err, "oidc.AuthorizeSSHSign")
+		}
+		principals = iden.Usernames
 	}
 	defaults := SSHOptions{
 		CertType:   SSHUserCert,
-		Principals: iden.Usernames,
+		Principals: appendMissing(principals, rules.principals...),
 	}
 
 	// Admin users can use any principal, and can sign user and host certificates.
-	// Non-admin users can only use principals returned by the identityFunc, and
-	// can only sign user certificates.
+	// Non-admin users can only use principals returned by the identityFunc or
+	// the matching rules, and can only sign user certificates.
 	if !o.IsAdmin(claims.Email) {
+		if len(defaults.Principals) == 0 {
+			return nil, errs.Unauthorized("oidc.AuthorizeSSHSign; no principals allowed for the oidc token")
+		}
 		signOptions = append(signOptions, sshCertOptionsValidator(defaults))
 	}
 
@@ -362,12 +452,14 @@ func (o *OIDC) AuthorizeSSHSign(ctx context.Context, token string) ([]SignOption
 	return append(signOptions,
 		// Set the default extensions
 		&sshDefaultExtensionModifier{},
+		// Set the template options
+		newSSHTemplateModifier(o.Templates, token),
 		// Set the validity bounds if not set.
-		&sshDefaultDuration{o.claimer},
+		&sshDefaultDuration{rules.claimer},
 		// Validate public key
 		&sshDefaultPublicKeyValidator{},
 		// Validate the validity period.
-		&sshCertValidityValidator{o.claimer},
+		&sshCertValidityValidator{rules.claimer},
 		// Require all the fields in the SSH certificate
 		&sshCertDefaultValidator{},
 	), nil
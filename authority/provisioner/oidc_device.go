@@ -0,0 +1,176 @@
+package provisioner
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/errs"
+)
+
+// deviceCodeGrantType is the grant type used to poll the token endpoint in the
+// device authorization grant.
+const deviceCodeGrantType = "urn:ietf:params:oauth:grant-type:device_code"
+
+// defaultDeviceInterval is the default polling interval in seconds.
+const defaultDeviceInterval = 5
+
+var (
+	// ErrAuthorizationPending is the error returned when the user has not yet
+	// completed the device authorization.
+	ErrAuthorizationPending = errors.New("authorization_pending")
+	// ErrSlowDown is the error returned when the client must increase the
+	// polling interval.
+	ErrSlowDown = errors.New("slow_down")
+)
+
+// DeviceAuthorization is the response of a device authorization request as
+// defined in RFC 8628 section 3.2.
+type DeviceAuthorization struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete,omitempty"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval,omitempty"`
+}
+
+// deviceAuthorizationResponse is the response of the device authorization
+// endpoint. Some identity providers, like Google, use verification_url instead
+// of verification_uri.
+type deviceAuthorizationResponse struct {
+	DeviceAuthorization
+	VerificationURL string `json:"verification_url"`
+}
+
+// deviceTokenResponse is the response of the token endpoint.
+type deviceTokenResponse struct {
+	IDToken          string `json:"id_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// StartDeviceAuthorization starts a device authorization grant (RFC 8628)
+// with the identity provider. The user must visit the verification uri and
+// enter the user code, and the client must poll the authorization using the
+// returned device code.
+func (o *OIDC) StartDeviceAuthorization(ctx context.Context) (*DeviceAuthorization, error) {
+	if !o.EnableDeviceAuthorization {
+		return nil, errs.Unauthorized("oidc.StartDeviceAuthorization; device authorization is disabled for oidc provisioner %s", o.GetID())
+	}
+
+	scopes := o.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"openid", "email"}
+	}
+	form := o.deviceForm()
+	form.Set("scope", strings.Join(scopes, " "))
+
+	resp, err := postForm(ctx, o.configuration.DeviceAuthorizationEndpoint, form)
+	if err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "oidc.StartDeviceAuthorization")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var tr deviceTokenResponse
+		if err := json.NewDecoder(resp.Body).Decode(&tr); err != nil || tr.Error == "" {
+			return nil, errs.InternalServer("oidc.StartDeviceAuthorization; device authorization endpoint returned status code %d", resp.StatusCode)
+		}
+		return nil, errs.InternalServer("oidc.StartDeviceAuthorization; device authorization endpoint returned error %s: %s", tr.Error, tr.ErrorDescription)
+	}
+
+	var dr deviceAuthorizationResponse
+	if err := json.NewDecoder(resp.Body).Decode(&dr); err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "oidc.StartDeviceAuthorization; error decoding device authorization response")
+	}
+	if dr.VerificationURI == "" {
+		dr.VerificationURI = dr.VerificationURL
+	}
+	if dr.Interval <= 0 {
+		dr.Interval = defaultDeviceInterval
+	}
+	if dr.DeviceCode == "" || dr.UserCode == "" || dr.VerificationURI == "" {
+		return nil, errs.InternalServer("oidc.StartDeviceAuthorization; device authorization response is missing required fields")
+	}
+	return &dr.DeviceAuthorization, nil
+}
+
+// PollDeviceAuthorization polls the token endpoint of the identity provider
+// using the given device code, and returns the ID token once the user has
+// completed the authorization. It returns ErrAuthorizationPending or
+// ErrSlowDown if the authorization has not been completed yet.
+func (o *OIDC) PollDeviceAuthorization(ctx context.Context, deviceCode string) (string, error) {
+	if !o.EnableDeviceAuthorization {
+		return "", errs.Unauthorized("oidc.PollDeviceAuthorization; device authorization is disabled for oidc provisioner %s", o.GetID())
+	}
+	if deviceCode == "" {
+		return "", errs.BadRequest("oidc.PollDeviceAuthorization; device code cannot be empty")
+	}
+
+	form := o.deviceForm()
+	form.Set("grant_type", deviceCodeGrantType)
+	form.Set("device_code", deviceCode)
+
+	resp, err := postForm(ctx, o.configuration.TokenEndpoint, form)
+	if err != nil {
+		return "", errs.Wrap(http.StatusInternalServerError, err, "oidc.PollDeviceAuthorization")
+	}
+	defer resp.Body.Close()
+
+	var tr deviceTokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&tr); err != nil {
+		return "", errs.Wrap(http.StatusInternalServerError, err, "oidc.PollDeviceAuthorization; error decoding token response")
+	}
+
+	// Some identity providers return the pending errors with other status
+	// codes than the 400 defined in RFC 8628.
+	switch tr.Error {
+	case "":
+	case ErrAuthorizationPending.Error():
+		return "", ErrAuthorizationPending
+	case ErrSlowDown.Error():
+		return "", ErrSlowDown
+	case "access_denied", "expired_token":
+		return "", errs.Unauthorized("oidc.PollDeviceAuthorization; device authorization failed: %s", tr.Error)
+	default:
+		return "", errs.InternalServer("oidc.PollDeviceAuthorization; token endpoint returned error %s: %s", tr.Error, tr.ErrorDescription)
+	}
+
+	switch {
+	case resp.StatusCode != http.StatusOK:
+		return "", errs.InternalServer("oidc.PollDeviceAuthorization; token endpoint returned status code %d", resp.StatusCode)
+	case tr.IDToken == "":
+		return "", errs.InternalServer("oidc.PollDeviceAuthorization; token endpoint did not return an id token")
+	default:
+		return tr.IDToken, nil
+	}
+}
+
+// deviceForm returns the client authentication parameters used in the device
+// authorization grant requests.
+func (o *OIDC) deviceForm() url.Values {
+	form := url.Values{}
+	form.Set("client_id", o.ClientID)
+	if o.ClientSecret != "" {
+		form.Set("client_secret", o.ClientSecret)
+	}
+	return form
+}
+
+func postForm(ctx context.Context, uri string, form url.Values) (*http.Response, error) {
+	req, err := http.NewRequest("POST", uri, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, errors.Wrapf(err, "error creating request for %s", uri)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to connect to %s", uri)
+	}
+	return resp, nil
+}
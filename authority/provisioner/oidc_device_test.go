@@ -0,0 +1,323 @@
+package provisioner
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/errs"
+	"github.com/smallstep/cli/jose"
+)
+
+// generateDeviceIdP returns a mock identity provider that supports the device
+// authorization grant. The token endpoint returns a different response
+// depending on the device code.
+func generateDeviceIdP(clientID string, jwk *jose.JSONWebKey) *httptest.Server {
+	writeJSON := func(w http.ResponseWriter, v interface{}, code int) {
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(v)
+	}
+	writeErr := func(w http.ResponseWriter, e string) {
+		writeJSON(w, map[string]string{"error": e, "error_description": "the " + e + " error"}, http.StatusBadRequest)
+	}
+
+	srv := httptest.NewUnstartedServer(nil)
+	srv.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" && r.FormValue("client_id") != clientID {
+			writeErr(w, "invalid_client")
+			return
+		}
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			writeJSON(w, openIDConfiguration{
+				Issuer:                      srv.URL,
+				JWKSetURI:                   srv.URL + "/jwks",
+				TokenEndpoint:               srv.URL + "/token",
+				DeviceAuthorizationEndpoint: srv.URL + "/device",
+			}, http.StatusOK)
+		case "/jwks":
+			writeJSON(w, jose.JSONWebKeySet{Keys: []jose.JSONWebKey{jwk.Public()}}, http.StatusOK)
+		case "/device":
+			writeJSON(w, map[string]interface{}{
+				"device_code":               "device-code",
+				"user_code":                 "ABCD-EFGH",
+				"verification_uri":          srv.URL + "/activate",
+				"verification_uri_complete": srv.URL + "/activate?user_code=ABCD-EFGH",
+				"expires_in":                600,
+				"interval":                  10,
+				"scope":                     r.FormValue("scope"),
+			}, http.StatusOK)
+		case "/device-google":
+			writeJSON(w, map[string]interface{}{
+				"device_code":      "device-code",
+				"user_code":        "ABCD-EFGH",
+				"verification_url": srv.URL + "/activate",
+				"expires_in":       600,
+			}, http.StatusOK)
+		case "/device-missing":
+			writeJSON(w, map[string]interface{}{"device_code": "device-code"}, http.StatusOK)
+		case "/device-error":
+			writeErr(w, "invalid_scope")
+		case "/device-bad":
+			http.Error(w, "an error", http.StatusInternalServerError)
+		case "/token":
+			if r.FormValue("grant_type") != deviceCodeGrantType {
+				writeErr(w, "unsupported_grant_type")
+				return
+			}
+			switch r.FormValue("device_code") {
+			case "device-code":
+				token, err := generateOIDCToken(srv.URL, clientID, "name@smallstep.com", nil, jwk)
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+				writeJSON(w, map[string]string{"id_token": token}, http.StatusOK)
+			case "pending":
+				writeErr(w, "authorization_pending")
+			case "slow":
+				// Some identity providers return a 200
+				writeJSON(w, map[string]string{"error": "slow_down"}, http.StatusOK)
+			case "denied":
+				writeErr(w, "access_denied")
+			case "expired":
+				writeErr(w, "expired_token")
+			case "no-token":
+				writeJSON(w, map[string]string{"access_token": "token"}, http.StatusOK)
+			case "bad":
+				http.Error(w, "an error", http.StatusInternalServerError)
+			default:
+				writeErr(w, "invalid_grant")
+			}
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	srv.Start()
+	return srv
+}
+
+func generateDeviceOIDC(srv *httptest.Server, clientID string) (*OIDC, error) {
+	p := &OIDC{
+		Name:                      "device",
+		Type:                      "OIDC",
+		ClientID:                  clientID,
+		ConfigurationEndpoint:     srv.URL + "/.well-known/openid-configuration",
+		EnableDeviceAuthorization: true,
+	}
+	if err := p.Init(Config{Claims: globalProvisionerClaims}); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func TestOIDC_Init_deviceAuthorization(t *testing.T) {
+	jwk, err := generateJSONWebKey()
+	assert.FatalError(t, err)
+	srv := generateDeviceIdP("client-id", jwk)
+	defer srv.Close()
+	jwkSrv := generateJWKServer(2)
+	defer jwkSrv.Close()
+
+	p, err := generateDeviceOIDC(srv, "client-id")
+	assert.FatalError(t, err)
+	assert.Equals(t, srv.URL+"/token", p.configuration.TokenEndpoint)
+	assert.Equals(t, srv.URL+"/device", p.configuration.DeviceAuthorizationEndpoint)
+
+	// The configuration must contain the device authorization endpoints
+	p = &OIDC{
+		Name:                      "device",
+		Type:                      "OIDC",
+		ClientID:                  "client-id",
+		ConfigurationEndpoint:     jwkSrv.URL,
+		EnableDeviceAuthorization: true,
+	}
+	err = p.Init(Config{Claims: globalProvisionerClaims})
+	if assert.Error(t, err) {
+		assert.HasSuffix(t, err.Error(), "device_authorization_endpoint cannot be empty")
+	}
+
+	// They are not required if the device authorization is disabled
+	p.EnableDeviceAuthorization = false
+	assert.FatalError(t, p.Init(Config{Claims: globalProvisionerClaims}))
+}
+
+func TestOIDC_StartDeviceAuthorization(t *testing.T) {
+	jwk, err := generateJSONWebKey()
+	assert.FatalError(t, err)
+	srv := generateDeviceIdP("client-id", jwk)
+	defer srv.Close()
+
+	type test struct {
+		p    *OIDC
+		want *DeviceAuthorization
+		err  error
+		code int
+	}
+	tests := map[string]func(*testing.T) test{
+		"ok": func(t *testing.T) test {
+			p, err := generateDeviceOIDC(srv, "client-id")
+			assert.FatalError(t, err)
+			return test{
+				p: p,
+				want: &DeviceAuthorization{
+					DeviceCode:              "device-code",
+					UserCode:                "ABCD-EFGH",
+					VerificationURI:         srv.URL + "/activate",
+					VerificationURIComplete: srv.URL + "/activate?user_code=ABCD-EFGH",
+					ExpiresIn:               600,
+					Interval:                10,
+				},
+			}
+		},
+		"ok/verification_url": func(t *testing.T) test {
+			p, err := generateDeviceOIDC(srv, "client-id")
+			assert.FatalError(t, err)
+			p.configuration.DeviceAuthorizationEndpoint = srv.URL + "/device-google"
+			return test{
+				p: p,
+				want: &DeviceAuthorization{
+					DeviceCode:      "device-code",
+					UserCode:        "ABCD-EFGH",
+					VerificationURI: srv.URL + "/activate",
+					ExpiresIn:       600,
+					Interval:        defaultDeviceInterval,
+				},
+			}
+		},
+		"fail/disabled": func(t *testing.T) test {
+			p, err := generateDeviceOIDC(srv, "client-id")
+			assert.FatalError(t, err)
+			p.EnableDeviceAuthorization = false
+			return test{
+				p:    p,
+				err:  errors.New("oidc.StartDeviceAuthorization; device authorization is disabled for oidc provisioner client-id"),
+				code: http.StatusUnauthorized,
+			}
+		},
+		"fail/client": func(t *testing.T) test {
+			p, err := generateDeviceOIDC(srv, "client-id")
+			assert.FatalError(t, err)
+			p.ClientID = "foo"
+			return test{
+				p:    p,
+				err:  errors.New("oidc.StartDeviceAuthorization; device authorization endpoint returned error invalid_client: the invalid_client error"),
+				code: http.StatusInternalServerError,
+			}
+		},
+		"fail/error": func(t *testing.T) test {
+			p, err := generateDeviceOIDC(srv, "client-id")
+			assert.FatalError(t, err)
+			p.configuration.DeviceAuthorizationEndpoint = srv.URL + "/device-error"
+			return test{
+				p:    p,
+				err:  errors.New("oidc.StartDeviceAuthorization; device authorization endpoint returned error invalid_scope: the invalid_scope error"),
+				code: http.StatusInternalServerError,
+			}
+		},
+		"fail/status": func(t *testing.T) test {
+			p, err := generateDeviceOIDC(srv, "client-id")
+			assert.FatalError(t, err)
+			p.configuration.DeviceAuthorizationEndpoint = srv.URL + "/device-bad"
+			return test{
+				p:    p,
+				err:  errors.New("oidc.StartDeviceAuthorization; device authorization endpoint returned status code 500"),
+				code: http.StatusInternalServerError,
+			}
+		},
+		"fail/missing": func(t *testing.T) test {
+			p, err := generateDeviceOIDC(srv, "client-id")
+			assert.FatalError(t, err)
+			p.configuration.DeviceAuthorizationEndpoint = srv.URL + "/device-missing"
+			return test{
+				p:    p,
+				err:  errors.New("oidc.StartDeviceAuthorization; device authorization response is missing required fields"),
+				code: http.StatusInternalServerError,
+			}
+		},
+	}
+	for name, get := range tests {
+		t.Run(name, func(t *testing.T) {
+			tc := get(t)
+			got, err := tc.p.StartDeviceAuthorization(context.Background())
+			if err != nil {
+				if assert.NotNil(t, tc.err) {
+					sc, ok := err.(errs.StatusCoder)
+					assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+					assert.Equals(t, sc.StatusCode(), tc.code)
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+			} else {
+				assert.Nil(t, tc.err)
+				assert.Equals(t, tc.want, got)
+			}
+		})
+	}
+}
+
+func TestOIDC_PollDeviceAuthorization(t *testing.T) {
+	jwk, err := generateJSONWebKey()
+	assert.FatalError(t, err)
+	srv := generateDeviceIdP("client-id", jwk)
+	defer srv.Close()
+	p, err := generateDeviceOIDC(srv, "client-id")
+	assert.FatalError(t, err)
+	disabled, err := generateDeviceOIDC(srv, "client-id")
+	assert.FatalError(t, err)
+	disabled.EnableDeviceAuthorization = false
+
+	tests := []struct {
+		name       string
+		p          *OIDC
+		deviceCode string
+		err        error
+		code       int
+	}{
+		{"ok", p, "device-code", nil, http.StatusOK},
+		{"pending", p, "pending", ErrAuthorizationPending, 0},
+		{"slow_down", p, "slow", ErrSlowDown, 0},
+		{"fail/disabled", disabled, "device-code", errors.New("oidc.PollDeviceAuthorization; device authorization is disabled for oidc provisioner client-id"), http.StatusUnauthorized},
+		{"fail/empty", p, "", errors.New("oidc.PollDeviceAuthorization; device code cannot be empty"), http.StatusBadRequest},
+		{"fail/denied", p, "denied", errors.New("oidc.PollDeviceAuthorization; device authorization failed: access_denied"), http.StatusUnauthorized},
+		{"fail/expired", p, "expired", errors.New("oidc.PollDeviceAuthorization; device authorization failed: expired_token"), http.StatusUnauthorized},
+		{"fail/invalid", p, "foo", errors.New("oidc.PollDeviceAuthorization; token endpoint returned error invalid_grant: the invalid_grant error"), http.StatusInternalServerError},
+		{"fail/no-token", p, "no-token", errors.New("oidc.PollDeviceAuthorization; token endpoint did not return an id token"), http.StatusInternalServerError},
+		{"fail/bad", p, "bad", errors.New("oidc.PollDeviceAuthorization; error decoding token response"), http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := tt.p.PollDeviceAuthorization(context.Background(), tt.deviceCode)
+			switch {
+			case tt.err == nil:
+				assert.FatalError(t, err)
+				// The token must be accepted by the provisioner
+				opts, err := tt.p.AuthorizeSign(context.Background(), token)
+				assert.FatalError(t, err)
+				assert.Len(t, 7, opts)
+				// Tokens without nonce use the hash of the token as id
+				sum := sha256.Sum256([]byte(token))
+				id, err := tt.p.GetTokenID(token)
+				assert.FatalError(t, err)
+				assert.Equals(t, hex.EncodeToString(sum[:]), id)
+			case tt.code == 0:
+				assert.Equals(t, tt.err, err)
+				assert.Equals(t, "", token)
+			default:
+				if assert.Error(t, err) {
+					sc, ok := err.(errs.StatusCoder)
+					assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+					assert.Equals(t, sc.StatusCode(), tt.code)
+					assert.HasPrefix(t, err.Error(), tt.err.Error())
+				}
+				assert.Equals(t, "", token)
+			}
+		})
+	}
+}
@@ -0,0 +1,211 @@
+package provisioner
+
+import (
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// OIDCRule maps the values of a claim in an OIDC token to SSH principals and
+// X.509 subject alternative names. A rule matches a token if the claim, or one
+// of its elements if the claim is a list, is one of the rule values.
+//
+// The principals and SANs of all the matching rules are added to the ones
+// allowed by the provisioner, and the claims of the matching rules override the
+// provisioner ones. If more than one matching rule sets claims, the most
+// restrictive value of each claim is used, regardless of the order of the
+// rules.
+type OIDCRule struct {
+	Claim      string   `json:"claim"`
+	Values     []string `json:"values"`
+	Principals []string `json:"principals,omitempty"`
+	SANs       []string `json:"sans,omitempty"`
+	Claims     *Claims  `json:"claims,omitempty"`
+	claimer    *Claimer
+}
+
+// Init validates the rule and initializes its claimer with the given one.
+func (r *OIDCRule) Init(claimer *Claimer) (err error) {
+	switch {
+	case r == nil:
+		return errors.New("rules cannot contain an empty value")
+	case r.Claim == "":
+		return errors.New("rules.claim cannot be empty")
+	case len(r.Values) == 0:
+		return errors.New("rules.values cannot be empty")
+	}
+	for _, p := range r.Principals {
+		if p == "" {
+			return errors.New("rules.principals cannot contain empty values")
+		}
+	}
+	for _, s := range r.SANs {
+		if s == "" {
+			return errors.New("rules.sans cannot contain empty values")
+		}
+	}
+	r.claimer, err = claimer.WithClaims(r.Claims)
+	return err
+}
+
+// match returns true if the rule claim in the given token claims contains one
+// of the rule values.
+func (r *OIDCRule) match(claims map[string]interface{}) bool {
+	for _, v := range claimValues(claims[r.Claim]) {
+		for _, value := range r.Values {
+			if v == value {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// claimValues returns the string representation of a claim value, or of its
+// elements if the claim is a list.
+func claimValues(v interface{}) []string {
+	switch v := v.(type) {
+	case string:
+		return []string{v}
+	case bool:
+		return []string{strconv.FormatBool(v)}
+	case float64:
+		return []string{strconv.FormatFloat(v, 'f', -1, 64)}
+	case []interface{}:
+		var values []string
+		for _, e := range v {
+			if _, ok := e.([]interface{}); !ok {
+				values = append(values, claimValues(e)...)
+			}
+		}
+		return values
+	default:
+		return nil
+	}
+}
+
+// oidcRulesResult contains the principals, SANs and claimer resulting from
+// applying the rules that match an OIDC token.
+type oidcRulesResult struct {
+	principals []string
+	sans       []string
+	claimer    *Claimer
+}
+
+// validateRules validates that the claims of any combination of rules can be
+// merged, see mergeClaimers. The merged minimums are the largest of the rule
+// minimums and the merged maximums the smallest of the rule maximums, so
+// checking all the pairs of rules is enough.
+func (o *OIDC) validateRules() error {
+	for i, r := range o.Rules {
+		if r.Claims == nil {
+			continue
+		}
+		for _, rr := range o.Rules[i+1:] {
+			if rr.Claims == nil {
+				continue
+			}
+			if _, err := mergeClaimers(o.claimer, []*Claimer{r.claimer, rr.claimer}); err != nil {
+				return errors.Wrapf(err, "rules for claims %s and %s cannot be combined", r.Claim, rr.Claim)
+			}
+		}
+	}
+	return nil
+}
+
+// applyRules applies the rules that match the given token payload.
+func (o *OIDC) applyRules(p *openIDPayload) (*oidcRulesResult, error) {
+	var claimers []*Claimer
+	res := &oidcRulesResult{claimer: o.claimer}
+	for _, r := range o.Rules {
+		if !r.match(p.raw) {
+			continue
+		}
+		res.principals = appendMissing(res.principals, r.Principals...)
+		res.sans = appendMissing(res.sans, r.SANs...)
+		if r.Claims != nil {
+			claimers = append(claimers, r.claimer)
+		}
+	}
+	if len(claimers) > 0 {
+		var err error
+		if res.claimer, err = mergeClaimers(o.claimer, claimers); err != nil {
+			return nil, errors.Wrap(err, "error applying rules")
+		}
+	}
+	return res, nil
+}
+
+// mergeClaimers returns a claimer with the most restrictive claims of the given
+// claimers: the largest minimum durations, the smallest maximum durations, the
+// smallest default durations within the new limits, renewal disabled if any of
+// them disables it, and the SSH CA enabled only if all of them enable it.
+func mergeClaimers(base *Claimer, claimers []*Claimer) (*Claimer, error) {
+	c := claimers[0].Claims()
+	for _, cl := range claimers[1:] {
+		cc := cl.Claims()
+		c.MinTLSDur = maxDuration(c.MinTLSDur, cc.MinTLSDur)
+		c.MaxTLSDur = minDuration(c.MaxTLSDur, cc.MaxTLSDur)
+		c.DefaultTLSDur = minDuration(c.DefaultTLSDur, cc.DefaultTLSDur)
+		c.MinUserSSHDur = maxDuration(c.MinUserSSHDur, cc.MinUserSSHDur)
+		c.MaxUserSSHDur = minDuration(c.MaxUserSSHDur, cc.MaxUserSSHDur)
+		c.DefaultUserSSHDur = minDuration(c.DefaultUserSSHDur, cc.DefaultUserSSHDur)
+		c.MinHostSSHDur = maxDuration(c.MinHostSSHDur, cc.MinHostSSHDur)
+		c.MaxHostSSHDur = minDuration(c.MaxHostSSHDur, cc.MaxHostSSHDur)
+		c.DefaultHostSSHDur = minDuration(c.DefaultHostSSHDur, cc.DefaultHostSSHDur)
+		if *cc.DisableRenewal {
+			c.DisableRenewal = cc.DisableRenewal
+		}
+		if !*cc.EnableSSHCA {
+			c.EnableSSHCA = cc.EnableSSHCA
+		}
+	}
+
+	// Validate the SSH limits, the TLS ones are validated by the claimer
+	switch {
+	case c.MaxUserSSHDur.Duration < c.MinUserSSHDur.Duration:
+		return nil, errors.Errorf("claims: MaxUserSSHCertDuration cannot be less than MinUserSSHCertDuration: MaxUserSSHCertDuration - %v, MinUserSSHCertDuration - %v", c.MaxUserSSHDur.Duration, c.MinUserSSHDur.Duration)
+	case c.MaxHostSSHDur.Duration < c.MinHostSSHDur.Duration:
+		return nil, errors.Errorf("claims: MaxHostSSHCertDuration cannot be less than MinHostSSHCertDuration: MaxHostSSHCertDuration - %v, MinHostSSHCertDuration - %v", c.MaxHostSSHDur.Duration, c.MinHostSSHDur.Duration)
+	}
+
+	// Keep the defaults within the limits
+	c.DefaultTLSDur = maxDuration(minDuration(c.DefaultTLSDur, c.MaxTLSDur), c.MinTLSDur)
+	c.DefaultUserSSHDur = maxDuration(minDuration(c.DefaultUserSSHDur, c.MaxUserSSHDur), c.MinUserSSHDur)
+	c.DefaultHostSSHDur = maxDuration(minDuration(c.DefaultHostSSHDur, c.MaxHostSSHDur), c.MinHostSSHDur)
+
+	return NewClaimer(&c, base.Claims())
+}
+
+// minDuration returns the smallest of the given durations.
+func minDuration(a, b *Duration) *Duration {
+	if b.Duration < a.Duration {
+		return b
+	}
+	return a
+}
+
+// maxDuration returns the largest of the given durations.
+func maxDuration(a, b *Duration) *Duration {
+	if b.Duration > a.Duration {
+		return b
+	}
+	return a
+}
+
+// appendMissing appends the values that are not already in the given slice.
+func appendMissing(s []string, values ...string) []string {
+	for _, v := range values {
+		found := false
+		for _, e := range s {
+			if e == v {
+				found = true
+				break
+			}
+		}
+		if !found {
+			s = append(s, v)
+		}
+	}
+	return s
+}
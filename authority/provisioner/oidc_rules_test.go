@@ -0,0 +1,329 @@
+package provisioner
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/errs"
+	"github.com/smallstep/cli/jose"
+)
+
+func TestOIDCRule_Init(t *testing.T) {
+	claimer, err := NewClaimer(nil, globalProvisionerClaims)
+	assert.FatalError(t, err)
+
+	tests := []struct {
+		name string
+		rule *OIDCRule
+		err  error
+	}{
+		{"ok", &OIDCRule{Claim: "groups", Values: []string{"admins"}, Principals: []string{"root"}}, nil},
+		{"ok/claims", &OIDCRule{Claim: "groups", Values: []string{"contractors"}, Claims: &Claims{MaxUserSSHDur: &Duration{time.Hour}}}, nil},
+		{"fail/nil", nil, errors.New("rules cannot contain an empty value")},
+		{"fail/claim", &OIDCRule{Values: []string{"admins"}}, errors.New("rules.claim cannot be empty")},
+		{"fail/values", &OIDCRule{Claim: "groups"}, errors.New("rules.values cannot be empty")},
+		{"fail/principals", &OIDCRule{Claim: "groups", Values: []string{"admins"}, Principals: []string{""}}, errors.New("rules.principals cannot contain empty values")},
+		{"fail/sans", &OIDCRule{Claim: "groups", Values: []string{"admins"}, SANs: []string{""}}, errors.New("rules.sans cannot contain empty values")},
+		{"fail/claims", &OIDCRule{Claim: "groups", Values: []string{"admins"}, Claims: &Claims{MinTLSDur: &Duration{48 * time.Hour}}}, errors.New("claims: MaxCertDuration cannot be less than MinCertDuration")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.rule.Init(claimer)
+			if tt.err == nil {
+				assert.FatalError(t, err)
+				assert.NotNil(t, tt.rule.claimer)
+			} else if assert.NotNil(t, err) {
+				assert.HasPrefix(t, err.Error(), tt.err.Error())
+			}
+		})
+	}
+}
+
+func TestOIDCRule_match(t *testing.T) {
+	claims := map[string]interface{}{
+		"email":          "name@smallstep.com",
+		"email_verified": true,
+		"hd":             "smallstep.com",
+		"groups":         []interface{}{"developers", "admins"},
+		"level":          float64(3),
+		"nested":         []interface{}{[]interface{}{"admins"}},
+	}
+	tests := []struct {
+		name string
+		rule *OIDCRule
+		want bool
+	}{
+		{"string", &OIDCRule{Claim: "hd", Values: []string{"example.com", "smallstep.com"}}, true},
+		{"list", &OIDCRule{Claim: "groups", Values: []string{"admins"}}, true},
+		{"bool", &OIDCRule{Claim: "email_verified", Values: []string{"true"}}, true},
+		{"number", &OIDCRule{Claim: "level", Values: []string{"3"}}, true},
+		{"no-match", &OIDCRule{Claim: "groups", Values: []string{"contractors"}}, false},
+		{"missing", &OIDCRule{Claim: "department", Values: []string{"engineering"}}, false},
+		{"nested", &OIDCRule{Claim: "nested", Values: []string{"admins"}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.rule.match(claims); got != tt.want {
+				t.Errorf("OIDCRule.match() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOIDC_applyRules(t *testing.T) {
+	disableRenewal := true
+	p, err := generateOIDC()
+	assert.FatalError(t, err)
+	p.Rules = []*OIDCRule{
+		{Claim: "groups", Values: []string{"developers"}, Principals: []string{"dev", "deploy"}, SANs: []string{"dev.smallstep.com"}},
+		{Claim: "groups", Values: []string{"operators"}, Principals: []string{"deploy", "ops"}, Claims: &Claims{MaxUserSSHDur: &Duration{8 * time.Hour}}},
+		{Claim: "groups", Values: []string{"contractors"}, Claims: &Claims{MaxUserSSHDur: &Duration{time.Hour}}},
+		{Claim: "groups", Values: []string{"interns"}, Claims: &Claims{MaxUserSSHDur: &Duration{4 * time.Hour}, DisableRenewal: &disableRenewal}},
+	}
+	for _, r := range p.Rules {
+		assert.FatalError(t, r.Init(p.claimer))
+	}
+
+	tests := []struct {
+		name               string
+		groups             []interface{}
+		wantPrincipals     []string
+		wantSANs           []string
+		wantMax            time.Duration
+		wantDefault        time.Duration
+		wantDisableRenewal bool
+	}{
+		{"none", nil, nil, nil, 24 * time.Hour, 16 * time.Hour, false},
+		{"developers", []interface{}{"developers"}, []string{"dev", "deploy"}, []string{"dev.smallstep.com"}, 24 * time.Hour, 16 * time.Hour, false},
+		{"operators", []interface{}{"operators", "developers"}, []string{"dev", "deploy", "ops"}, []string{"dev.smallstep.com"}, 8 * time.Hour, 8 * time.Hour, false},
+		{"contractors", []interface{}{"contractors", "operators"}, []string{"deploy", "ops"}, nil, time.Hour, time.Hour, false},
+		{"interns", []interface{}{"interns"}, nil, nil, 4 * time.Hour, 4 * time.Hour, true},
+		{"interns-contractors", []interface{}{"interns", "contractors"}, nil, nil, time.Hour, time.Hour, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := p.applyRules(&openIDPayload{raw: map[string]interface{}{"groups": tt.groups}})
+			assert.FatalError(t, err)
+			assert.Equals(t, tt.wantPrincipals, got.principals)
+			assert.Equals(t, tt.wantSANs, got.sans)
+			assert.Equals(t, tt.wantMax, got.claimer.MaxUserSSHCertDuration())
+			assert.Equals(t, tt.wantDefault, got.claimer.DefaultUserSSHCertDuration())
+			assert.Equals(t, tt.wantDisableRenewal, got.claimer.IsDisableRenewal())
+		})
+	}
+}
+
+func TestOIDC_Init_rules(t *testing.T) {
+	srv := generateJWKServer(2)
+	defer srv.Close()
+
+	tests := []struct {
+		name  string
+		rules []*OIDCRule
+		err   error
+	}{
+		{"ok", []*OIDCRule{
+			{Claim: "groups", Values: []string{"operators"}, Claims: &Claims{MinTLSDur: &Duration{time.Hour}}},
+			{Claim: "groups", Values: []string{"contractors"}, Claims: &Claims{MaxTLSDur: &Duration{2 * time.Hour}, DefaultTLSDur: &Duration{time.Hour}}},
+		}, nil},
+		{"fail/tls", []*OIDCRule{
+			{Claim: "groups", Values: []string{"operators"}, Claims: &Claims{MinTLSDur: &Duration{12 * time.Hour}}},
+			{Claim: "groups", Values: []string{"developers"}},
+			{Claim: "hd", Values: []string{"example.com"}, Claims: &Claims{MaxTLSDur: &Duration{6 * time.Hour}, DefaultTLSDur: &Duration{time.Hour}}},
+		}, errors.New("rules for claims groups and hd cannot be combined: claims: MaxCertDuration cannot be less than MinCertDuration")},
+		{"fail/ssh", []*OIDCRule{
+			{Claim: "groups", Values: []string{"operators"}, Claims: &Claims{MinUserSSHDur: &Duration{12 * time.Hour}}},
+			{Claim: "groups", Values: []string{"contractors"}, Claims: &Claims{MaxUserSSHDur: &Duration{time.Hour}}},
+		}, errors.New("rules for claims groups and groups cannot be combined: claims: MaxUserSSHCertDuration cannot be less than MinUserSSHCertDuration")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := generateOIDC()
+			assert.FatalError(t, err)
+			p.ConfigurationEndpoint = srv.URL + "/.well-known/openid-configuration"
+			p.Rules = tt.rules
+			err = p.Init(Config{Claims: globalProvisionerClaims})
+			if tt.err == nil {
+				assert.FatalError(t, err)
+			} else if assert.NotNil(t, err) {
+				assert.HasPrefix(t, err.Error(), tt.err.Error())
+			}
+		})
+	}
+}
+
+func TestOIDC_AuthorizeSign_rules(t *testing.T) {
+	srv := generateJWKServer(2)
+	defer srv.Close()
+
+	var keys jose.JSONWebKeySet
+	assert.FatalError(t, getAndDecode(srv.URL+"/private", &keys))
+
+	p, err := generateOIDC()
+	assert.FatalError(t, err)
+	p.ConfigurationEndpoint = srv.URL + "/.well-known/openid-configuration"
+	p.Rules = []*OIDCRule{
+		{Claim: "groups", Values: []string{"developers"}, SANs: []string{"dev.smallstep.com"}},
+		{Claim: "groups", Values: []string{"contractors"}, Claims: &Claims{MaxTLSDur: &Duration{time.Hour}, DefaultTLSDur: &Duration{time.Hour}}},
+	}
+	assert.FatalError(t, p.Init(Config{Claims: globalProvisionerClaims}))
+
+	developer, err := generateOIDCToken("the-issuer", p.ClientID, "name@smallstep.com", map[string]interface{}{
+		"groups": []string{"developers"},
+	}, &keys.Keys[0])
+	assert.FatalError(t, err)
+	contractor, err := generateOIDCToken("the-issuer", p.ClientID, "name@smallstep.com", map[string]interface{}{
+		"groups": []string{"contractors"},
+	}, &keys.Keys[0])
+	assert.FatalError(t, err)
+
+	tests := []struct {
+		name    string
+		token   string
+		sans    []string
+		max     time.Duration
+		req     *x509.CertificateRequest
+		wantErr bool
+	}{
+		{"developer", developer, []string{"name@smallstep.com", "dev.smallstep.com"}, 24 * time.Hour,
+			&x509.CertificateRequest{DNSNames: []string{"dev.smallstep.com"}}, false},
+		{"developer/fail", developer, []string{"name@smallstep.com", "dev.smallstep.com"}, 24 * time.Hour,
+			&x509.CertificateRequest{DNSNames: []string{"test.smallstep.com"}}, true},
+		{"contractor", contractor, nil, time.Hour,
+			&x509.CertificateRequest{EmailAddresses: []string{"name@smallstep.com"}}, false},
+		{"contractor/fail", contractor, nil, time.Hour,
+			&x509.CertificateRequest{DNSNames: []string{"dev.smallstep.com"}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := p.AuthorizeSign(context.Background(), tt.token)
+			assert.FatalError(t, err)
+			assert.Len(t, 7, got)
+			for _, o := range got {
+				switch v := o.(type) {
+				case profileDefaultDuration:
+					assert.Equals(t, tt.max, time.Duration(v))
+				case *validityValidator:
+					assert.Equals(t, tt.max, v.max)
+				case sansAllowlistValidator:
+					assert.Equals(t, tt.sans, []string(v))
+					if err := v.Valid(tt.req); (err != nil) != tt.wantErr {
+						t.Errorf("sansAllowlistValidator.Valid() error = %v, wantErr %v", err, tt.wantErr)
+					}
+				case emailOnlyIdentity:
+					assert.Len(t, 0, tt.sans)
+					if err := v.Valid(tt.req); (err != nil) != tt.wantErr {
+						t.Errorf("emailOnlyIdentity.Valid() error = %v, wantErr %v", err, tt.wantErr)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestOIDC_AuthorizeSSHSign_rules(t *testing.T) {
+	tm, fn := mockNow()
+	defer fn()
+
+	srv := generateJWKServer(2)
+	defer srv.Close()
+
+	var keys jose.JSONWebKeySet
+	assert.FatalError(t, getAndDecode(srv.URL+"/private", &keys))
+
+	disable := false
+	rules := []*OIDCRule{
+		{Claim: "groups", Values: []string{"developers"}, Principals: []string{"dev"}},
+		{Claim: "groups", Values: []string{"contractors"}, Principals: []string{"guest"}, Claims: &Claims{MaxUserSSHDur: &Duration{time.Hour}, DefaultUserSSHDur: &Duration{time.Hour}}},
+		{Claim: "hd", Values: []string{"example.com"}, Claims: &Claims{EnableSSHCA: &disable}},
+	}
+
+	p1, err := generateOIDC()
+	assert.FatalError(t, err)
+	p1.ConfigurationEndpoint = srv.URL + "/.well-known/openid-configuration"
+	p1.Rules = rules
+	assert.FatalError(t, p1.Init(Config{Claims: globalProvisionerClaims}))
+
+	// Principals only from the rules
+	p2, err := generateOIDC()
+	assert.FatalError(t, err)
+	p2.ConfigurationEndpoint = srv.URL + "/.well-known/openid-configuration"
+	p2.Rules = rules
+	p2.DisableEmailPrincipals = true
+	assert.FatalError(t, p2.Init(Config{Claims: globalProvisionerClaims}))
+
+	token := func(aud string, claims map[string]interface{}) string {
+		tok, err := generateOIDCToken("the-issuer", aud, "name@smallstep.com", claims, &keys.Keys[0])
+		assert.FatalError(t, err)
+		return tok
+	}
+
+	key, err := generateJSONWebKey()
+	assert.FatalError(t, err)
+	signer, err := generateJSONWebKey()
+	assert.FatalError(t, err)
+	pub := key.Public().Key
+
+	userDuration := p1.claimer.DefaultUserSSHCertDuration()
+	tests := []struct {
+		name        string
+		prov        *OIDC
+		token       string
+		sshOpts     SSHOptions
+		expected    *SSHOptions
+		code        int
+		wantErr     bool
+		wantSignErr bool
+	}{
+		{"ok/developer", p1, token(p1.ClientID, map[string]interface{}{"groups": []string{"developers"}}), SSHOptions{},
+			&SSHOptions{CertType: "user", Principals: []string{"name", "name@smallstep.com", "dev"},
+				ValidAfter: NewTimeDuration(tm), ValidBefore: NewTimeDuration(tm.Add(userDuration))}, http.StatusOK, false, false},
+		{"ok/developer-principal", p1, token(p1.ClientID, map[string]interface{}{"groups": []string{"developers"}}), SSHOptions{Principals: []string{"dev"}},
+			&SSHOptions{CertType: "user", Principals: []string{"dev"},
+				ValidAfter: NewTimeDuration(tm), ValidBefore: NewTimeDuration(tm.Add(userDuration))}, http.StatusOK, false, false},
+		{"ok/contractor", p1, token(p1.ClientID, map[string]interface{}{"groups": []string{"contractors"}}), SSHOptions{},
+			&SSHOptions{CertType: "user", Principals: []string{"name", "name@smallstep.com", "guest"},
+				ValidAfter: NewTimeDuration(tm), ValidBefore: NewTimeDuration(tm.Add(time.Hour))}, http.StatusOK, false, false},
+		{"ok/rules-only", p2, token(p2.ClientID, map[string]interface{}{"groups": []string{"developers", "contractors"}}), SSHOptions{},
+			&SSHOptions{CertType: "user", Principals: []string{"dev", "guest"},
+				ValidAfter: NewTimeDuration(tm), ValidBefore: NewTimeDuration(tm.Add(time.Hour))}, http.StatusOK, false, false},
+		{"fail/contractor-duration", p1, token(p1.ClientID, map[string]interface{}{"groups": []string{"contractors"}}),
+			SSHOptions{ValidBefore: NewTimeDuration(tm.Add(2 * time.Hour))}, nil, http.StatusOK, false, true},
+		{"fail/rules-only-email", p2, token(p2.ClientID, map[string]interface{}{"groups": []string{"developers"}}),
+			SSHOptions{Principals: []string{"name"}}, nil, http.StatusOK, false, true},
+		{"fail/no-principals", p2, token(p2.ClientID, map[string]interface{}{"groups": []string{"operators"}}), SSHOptions{},
+			nil, http.StatusUnauthorized, true, false},
+		{"fail/sshCA-disabled", p1, token(p1.ClientID, map[string]interface{}{"groups": []string{"developers"}, "hd": "example.com"}), SSHOptions{},
+			nil, http.StatusUnauthorized, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.prov.AuthorizeSSHSign(context.Background(), tt.token)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("OIDC.AuthorizeSSHSign() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err != nil {
+				sc, ok := err.(errs.StatusCoder)
+				assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+				assert.Equals(t, sc.StatusCode(), tt.code)
+				assert.Nil(t, got)
+			} else if assert.NotNil(t, got) {
+				cert, err := signSSHCertificate(pub, tt.sshOpts, got, signer.Key.(crypto.Signer))
+				if (err != nil) != tt.wantSignErr {
+					t.Errorf("SignSSH error = %v, wantSignErr %v", err, tt.wantSignErr)
+				} else if tt.wantSignErr {
+					assert.Nil(t, cert)
+				} else {
+					assert.NoError(t, validateSSHCertificate(cert, tt.expected))
+				}
+			}
+		})
+	}
+}
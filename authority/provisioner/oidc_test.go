@@ -310,9 +310,9 @@ func TestOIDC_AuthorizeSign(t *testing.T) {
 			} else {
 				if assert.NotNil(t, got) {
 					if tt.name == "admin" {
-						assert.Len(t, 4, got)
+						assert.Len(t, 6, got)
 					} else {
-						assert.Len(t, 5, got)
+						assert.Len(t, 7, got)
 					}
 					for _, o := range got {
 						switch v := o.(type) {
@@ -324,6 +324,8 @@ func TestOIDC_AuthorizeSign(t *testing.T) {
 						case profileDefaultDuration:
 							assert.Equals(t, time.Duration(v), tt.prov.claimer.DefaultTLSCertDuration())
 						case defaultPublicKeyValidator:
+						case nameConstraintsValidator:
+						case *x509TemplateModifier:
 						case *validityValidator:
 							assert.Equals(t, v.min, tt.prov.claimer.MinTLSCertDuration())
 							assert.Equals(t, v.max, tt.prov.claimer.MaxTLSCertDuration())
@@ -0,0 +1,245 @@
+package provisioner
+
+import (
+	"crypto/x509"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// NameConstraints contains the names that a provisioner is allowed to issue
+// X.509 certificates for.
+//
+// DNS, email and URI domains match the domain and all its subdomains, domains
+// starting with a dot only match the subdomains. A wildcard DNS name is also
+// excluded if an excluded DNS domain is under its base domain. Email rules
+// containing an @ match a single email address. IP ranges are defined using the
+// CIDR notation. If a permitted list is not empty, all the names of that type
+// must match one of its values, excluded values have precedence over the
+// permitted ones.
+type NameConstraints struct {
+	PermittedDNSDomains   []string `json:"permittedDNSDomains,omitempty"`
+	ExcludedDNSDomains    []string `json:"excludedDNSDomains,omitempty"`
+	PermittedIPRanges     []string `json:"permittedIPRanges,omitempty"`
+	ExcludedIPRanges      []string `json:"excludedIPRanges,omitempty"`
+	PermittedEmailDomains []string `json:"permittedEmailDomains,omitempty"`
+	ExcludedEmailDomains  []string `json:"excludedEmailDomains,omitempty"`
+	PermittedURIDomains   []string `json:"permittedURIDomains,omitempty"`
+	ExcludedURIDomains    []string `json:"excludedURIDomains,omitempty"`
+	permittedIPRanges     []*net.IPNet
+	excludedIPRanges      []*net.IPNet
+}
+
+// Init validates the name constraints and parses the IP ranges.
+func (n *NameConstraints) Init() (err error) {
+	if n == nil {
+		return nil
+	}
+	domains := [][]string{
+		n.PermittedDNSDomains, n.ExcludedDNSDomains,
+		n.PermittedEmailDomains, n.ExcludedEmailDomains,
+		n.PermittedURIDomains, n.ExcludedURIDomains,
+	}
+	for _, list := range domains {
+		for _, d := range list {
+			if d = normalizeDomain(d); d == "" {
+				return errors.New("nameConstraints domains cannot contain empty values")
+			}
+		}
+	}
+	if n.permittedIPRanges, err = parseIPRanges(n.PermittedIPRanges); err != nil {
+		return err
+	}
+	if n.excludedIPRanges, err = parseIPRanges(n.ExcludedIPRanges); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Valid returns an error if one of the names of the certificate request is not
+// allowed by the name constraints. The common name is validated if it is an IP
+// address, an email address, or a DNS name.
+func (n *NameConstraints) Valid(req *x509.CertificateRequest) error {
+	if n == nil {
+		return nil
+	}
+	return n.validNames("certificate request", req.Subject.CommonName, req.DNSNames, req.IPAddresses, req.EmailAddresses, req.URIs)
+}
+
+// ValidCertificate returns an error if one of the names of the certificate is
+// not allowed by the name constraints. The common name is validated like in
+// Valid.
+func (n *NameConstraints) ValidCertificate(cert *x509.Certificate) error {
+	if n == nil {
+		return nil
+	}
+	return n.validNames("certificate", cert.Subject.CommonName, cert.DNSNames, cert.IPAddresses, cert.EmailAddresses, cert.URIs)
+}
+
+// validNames validates the given names, kind is the object containing them
+// and it's used in the error messages.
+func (n *NameConstraints) validNames(kind, cn string, dnsNames []string, ips []net.IP, emails []string, uris []*url.URL) error {
+	if cn != "" {
+		switch {
+		case net.ParseIP(cn) != nil:
+			ips = append([]net.IP{net.ParseIP(cn)}, ips...)
+		case strings.Contains(cn, "@"):
+			emails = append([]string{cn}, emails...)
+		case strings.Contains(cn, ".") && !strings.ContainsAny(cn, " /:"):
+			dnsNames = append([]string{cn}, dnsNames...)
+		}
+	}
+	for _, name := range dnsNames {
+		dnsName := normalizeDomain(name)
+		if !matchConstraints(dnsName, n.PermittedDNSDomains, n.ExcludedDNSDomains, matchDomain) {
+			return errors.Errorf("%s contains DNS name %s not allowed by the name constraints", kind, name)
+		}
+		for _, d := range n.ExcludedDNSDomains {
+			if matchWildcardParent(dnsName, d) {
+				return errors.Errorf("%s contains DNS name %s not allowed by the name constraints", kind, name)
+			}
+		}
+	}
+	for _, ip := range ips {
+		if !matchIPRanges(ip, n.permittedIPRanges, n.excludedIPRanges) {
+			return errors.Errorf("%s contains IP address %s not allowed by the name constraints", kind, ip)
+		}
+	}
+	for _, email := range emails {
+		if !matchConstraints(strings.ToLower(email), n.PermittedEmailDomains, n.ExcludedEmailDomains, matchEmail) {
+			return errors.Errorf("%s contains email address %s not allowed by the name constraints", kind, email)
+		}
+	}
+	for _, u := range uris {
+		if !matchConstraints(normalizeDomain(u.Hostname()), n.PermittedURIDomains, n.ExcludedURIDomains, matchURIHost) {
+			return errors.Errorf("%s contains URI %s not allowed by the name constraints", kind, u)
+		}
+	}
+	return nil
+}
+
+// nameConstraintsValidator is a CertificateValidator that validates the names
+// of the certificate with the name constraints of the provisioner and the
+// global ones. The certificate is validated after applying the certificate
+// templates, so the names set by a template are validated too.
+type nameConstraintsValidator []*NameConstraints
+
+// newNameConstraintsValidator returns the validator for the given name
+// constraints, nil values are ignored.
+func newNameConstraintsValidator(constraints ...*NameConstraints) nameConstraintsValidator {
+	var v nameConstraintsValidator
+	for _, c := range constraints {
+		if c != nil {
+			v = append(v, c)
+		}
+	}
+	return v
+}
+
+// Valid validates the certificate with all the name constraints.
+func (v nameConstraintsValidator) Valid(cert *x509.Certificate, _ Options) error {
+	for _, c := range v {
+		if err := c.ValidCertificate(cert); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// matchConstraints returns true if the name is not excluded and is permitted.
+func matchConstraints(name string, permitted, excluded []string, match func(name, constraint string) bool) bool {
+	for _, c := range excluded {
+		if match(name, c) {
+			return false
+		}
+	}
+	if len(permitted) == 0 {
+		return true
+	}
+	for _, c := range permitted {
+		if match(name, c) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchEmail returns true if the email matches the constraint, an email
+// address or a domain.
+func matchEmail(email, constraint string) bool {
+	if strings.Contains(constraint, "@") {
+		return email == strings.ToLower(constraint)
+	}
+	i := strings.LastIndex(email, "@")
+	if i == -1 {
+		return false
+	}
+	return matchDomain(normalizeDomain(email[i+1:]), constraint)
+}
+
+// matchURIHost returns true if the host of an URI matches the domain. URIs
+// without a host never match.
+func matchURIHost(host, domain string) bool {
+	return host != "" && matchDomain(host, domain)
+}
+
+// matchIPRanges returns true if the ip is not in the excluded ranges and, if
+// there are permitted ranges, it is in one of them.
+func matchIPRanges(ip net.IP, permitted, excluded []*net.IPNet) bool {
+	for _, n := range excluded {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	if len(permitted) == 0 {
+		return true
+	}
+	for _, n := range permitted {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseIPRanges parses a list of CIDRs.
+func parseIPRanges(cidrs []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error parsing nameConstraints IP range %s", cidr)
+		}
+		nets[i] = ipNet
+	}
+	return nets, nil
+}
+
+// normalizeDomain returns the lower case version of a domain without the
+// trailing dot.
+func normalizeDomain(d string) string {
+	return strings.ToLower(strings.TrimSuffix(strings.TrimSpace(d), "."))
+}
+
+// matchWildcardParent returns true if the name is a wildcard whose base domain
+// is a parent of the domain. A wildcard like *.example.com can be used for
+// admin.example.com, so it must be rejected if admin.example.com is denied.
+func matchWildcardParent(name, domain string) bool {
+	if !strings.HasPrefix(name, "*.") {
+		return false
+	}
+	domain = strings.TrimPrefix(normalizeDomain(domain), ".")
+	return strings.HasSuffix(domain, name[1:])
+}
+
+// matchDomain returns true if the name is the domain or a subdomain of it.
+// Domains starting with a dot only match subdomains.
+func matchDomain(name, domain string) bool {
+	domain = normalizeDomain(domain)
+	if strings.HasPrefix(domain, ".") {
+		return strings.HasSuffix(name, domain)
+	}
+	return name == domain || strings.HasSuffix(name, "."+domain)
+}
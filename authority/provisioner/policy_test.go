@@ -0,0 +1,203 @@
+package provisioner
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net"
+	"net/url"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/assert"
+)
+
+func TestNameConstraints_Init(t *testing.T) {
+	tests := map[string]struct {
+		n   *NameConstraints
+		err error
+	}{
+		"ok/nil":   {nil, nil},
+		"ok/empty": {&NameConstraints{}, nil},
+		"ok": {&NameConstraints{
+			PermittedDNSDomains:   []string{"example.com", ".internal"},
+			ExcludedDNSDomains:    []string{"admin.example.com"},
+			PermittedIPRanges:     []string{"10.0.0.0/8", "2001:db8::/32"},
+			ExcludedIPRanges:      []string{"10.0.0.0/24"},
+			PermittedEmailDomains: []string{"example.com"},
+			ExcludedEmailDomains:  []string{"root@example.com"},
+			PermittedURIDomains:   []string{"example.com"},
+			ExcludedURIDomains:    []string{"evil.example.com"},
+		}, nil},
+		"fail/dns": {&NameConstraints{PermittedDNSDomains: []string{""}},
+			errors.New("nameConstraints domains cannot contain empty values")},
+		"fail/email": {&NameConstraints{ExcludedEmailDomains: []string{"."}},
+			errors.New("nameConstraints domains cannot contain empty values")},
+		"fail/uri": {&NameConstraints{PermittedURIDomains: []string{" "}},
+			errors.New("nameConstraints domains cannot contain empty values")},
+		"fail/permitted-ip": {&NameConstraints{PermittedIPRanges: []string{"10.0.0.1"}},
+			errors.New("error parsing nameConstraints IP range 10.0.0.1: invalid CIDR address: 10.0.0.1")},
+		"fail/excluded-ip": {&NameConstraints{ExcludedIPRanges: []string{"10.0.0.0/33"}},
+			errors.New("error parsing nameConstraints IP range 10.0.0.0/33: invalid CIDR address: 10.0.0.0/33")},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if err := tc.n.Init(); err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.Equals(t, tc.err.Error(), err.Error())
+				}
+			} else {
+				assert.Nil(t, tc.err)
+			}
+		})
+	}
+}
+
+func TestNameConstraints_Valid(t *testing.T) {
+	n := &NameConstraints{
+		PermittedDNSDomains:   []string{"example.com", ".internal"},
+		ExcludedDNSDomains:    []string{"admin.example.com"},
+		PermittedIPRanges:     []string{"10.0.0.0/8", "2001:db8::/32"},
+		ExcludedIPRanges:      []string{"10.0.0.0/24"},
+		PermittedEmailDomains: []string{"example.com", "root@internal"},
+		ExcludedEmailDomains:  []string{"admin@example.com"},
+		PermittedURIDomains:   []string{"example.com"},
+		ExcludedURIDomains:    []string{"evil.example.com"},
+	}
+	assert.FatalError(t, n.Init())
+
+	mustURL := func(s string) *url.URL {
+		u, err := url.Parse(s)
+		assert.FatalError(t, err)
+		return u
+	}
+
+	tests := map[string]struct {
+		n   *NameConstraints
+		req *x509.CertificateRequest
+		err error
+	}{
+		"ok/nil": {nil, &x509.CertificateRequest{DNSNames: []string{"foo.com"}}, nil},
+		"ok/empty": {&NameConstraints{}, &x509.CertificateRequest{
+			DNSNames: []string{"foo.com"}, IPAddresses: []net.IP{net.ParseIP("1.1.1.1")},
+		}, nil},
+		"ok": {n, &x509.CertificateRequest{
+			Subject:        pkix.Name{CommonName: "Example.com."},
+			DNSNames:       []string{"foo.example.com", "*.foo.example.com", "foo.internal"},
+			IPAddresses:    []net.IP{net.ParseIP("10.1.0.1"), net.ParseIP("2001:db8::1")},
+			EmailAddresses: []string{"jane@example.com", "jane@mail.example.com", "root@internal"},
+			URIs:           []*url.URL{mustURL("spiffe://example.com/foo"), mustURL("https://www.example.com:8443/")},
+		}, nil},
+		"ok/common-name-not-a-name": {n, &x509.CertificateRequest{
+			Subject: pkix.Name{CommonName: "Jane Doe"},
+		}, nil},
+		"fail/common-name": {n, &x509.CertificateRequest{
+			Subject: pkix.Name{CommonName: "foo.bar.com"},
+		}, errors.New("certificate request contains DNS name foo.bar.com not allowed by the name constraints")},
+		"fail/common-name-ip": {n, &x509.CertificateRequest{
+			Subject: pkix.Name{CommonName: "1.1.1.1"},
+		}, errors.New("certificate request contains IP address 1.1.1.1 not allowed by the name constraints")},
+		"fail/dns-not-permitted": {n, &x509.CertificateRequest{
+			DNSNames: []string{"foo.example.com", "example.net"},
+		}, errors.New("certificate request contains DNS name example.net not allowed by the name constraints")},
+		"fail/dns-only-subdomains": {n, &x509.CertificateRequest{
+			DNSNames: []string{"internal"},
+		}, errors.New("certificate request contains DNS name internal not allowed by the name constraints")},
+		"fail/dns-excluded": {n, &x509.CertificateRequest{
+			DNSNames: []string{"foo.admin.example.com"},
+		}, errors.New("certificate request contains DNS name foo.admin.example.com not allowed by the name constraints")},
+		"fail/dns-excluded-wildcard": {n, &x509.CertificateRequest{
+			DNSNames: []string{"*.example.com"},
+		}, errors.New("certificate request contains DNS name *.example.com not allowed by the name constraints")},
+		"fail/ip-not-permitted": {n, &x509.CertificateRequest{
+			IPAddresses: []net.IP{net.ParseIP("192.168.0.1")},
+		}, errors.New("certificate request contains IP address 192.168.0.1 not allowed by the name constraints")},
+		"fail/ip-excluded": {n, &x509.CertificateRequest{
+			IPAddresses: []net.IP{net.ParseIP("10.0.0.1")},
+		}, errors.New("certificate request contains IP address 10.0.0.1 not allowed by the name constraints")},
+		"fail/email-not-permitted": {n, &x509.CertificateRequest{
+			EmailAddresses: []string{"jane@internal"},
+		}, errors.New("certificate request contains email address jane@internal not allowed by the name constraints")},
+		"fail/email-excluded": {n, &x509.CertificateRequest{
+			EmailAddresses: []string{"Admin@example.com"},
+		}, errors.New("certificate request contains email address Admin@example.com not allowed by the name constraints")},
+		"fail/uri-not-permitted": {n, &x509.CertificateRequest{
+			URIs: []*url.URL{mustURL("https://example.net")},
+		}, errors.New("certificate request contains URI https://example.net not allowed by the name constraints")},
+		"fail/uri-excluded": {n, &x509.CertificateRequest{
+			URIs: []*url.URL{mustURL("spiffe://evil.example.com/foo")},
+		}, errors.New("certificate request contains URI spiffe://evil.example.com/foo not allowed by the name constraints")},
+		"fail/uri-without-host": {n, &x509.CertificateRequest{
+			URIs: []*url.URL{mustURL("urn:uuid:c1b8c2f1-5bfa-4b6e-9c8e-6d3d1f1f7b3a")},
+		}, errors.New("certificate request contains URI urn:uuid:c1b8c2f1-5bfa-4b6e-9c8e-6d3d1f1f7b3a not allowed by the name constraints")},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if err := tc.n.Valid(tc.req); err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.Equals(t, tc.err.Error(), err.Error())
+				}
+			} else {
+				assert.Nil(t, tc.err)
+			}
+		})
+	}
+}
+
+func TestNameConstraints_ValidCertificate(t *testing.T) {
+	n := &NameConstraints{
+		PermittedDNSDomains: []string{"example.com"},
+		PermittedURIDomains: []string{"example.com"},
+	}
+	assert.FatalError(t, n.Init())
+
+	tests := map[string]struct {
+		n    *NameConstraints
+		cert *x509.Certificate
+		err  error
+	}{
+		"ok/nil": {nil, &x509.Certificate{DNSNames: []string{"foo.com"}}, nil},
+		"ok": {n, &x509.Certificate{
+			Subject:  pkix.Name{CommonName: "foo.example.com"},
+			DNSNames: []string{"foo.example.com", "bar.example.com"},
+		}, nil},
+		"fail/common-name": {n, &x509.Certificate{
+			Subject: pkix.Name{CommonName: "foo.bar.com"},
+		}, errors.New("certificate contains DNS name foo.bar.com not allowed by the name constraints")},
+		"fail/dns": {n, &x509.Certificate{
+			DNSNames: []string{"foo.example.com", "example.net"},
+		}, errors.New("certificate contains DNS name example.net not allowed by the name constraints")},
+		"fail/uri": {n, &x509.Certificate{
+			URIs: []*url.URL{{Scheme: "spiffe", Host: "example.net", Path: "/foo"}},
+		}, errors.New("certificate contains URI spiffe://example.net/foo not allowed by the name constraints")},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if err := tc.n.ValidCertificate(tc.cert); err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.Equals(t, tc.err.Error(), err.Error())
+				}
+			} else {
+				assert.Nil(t, tc.err)
+			}
+		})
+	}
+}
+
+func Test_nameConstraintsValidator_Valid(t *testing.T) {
+	local := &NameConstraints{PermittedDNSDomains: []string{"example.com"}}
+	global := &NameConstraints{ExcludedDNSDomains: []string{"internal.example.com"}}
+	assert.FatalError(t, local.Init())
+	assert.FatalError(t, global.Init())
+
+	v := newNameConstraintsValidator(nil, nil)
+	assert.Len(t, 0, v)
+	assert.Nil(t, v.Valid(&x509.Certificate{DNSNames: []string{"foo.bar"}}, Options{}))
+
+	v = newNameConstraintsValidator(local, nil, global)
+	assert.Len(t, 2, v)
+	assert.Nil(t, v.Valid(&x509.Certificate{DNSNames: []string{"foo.example.com"}}, Options{}))
+	assert.Equals(t, "certificate contains DNS name foo.bar not allowed by the name constraints",
+		v.Valid(&x509.Certificate{DNSNames: []string{"foo.bar"}}, Options{}).Error())
+	assert.Equals(t, "certificate contains DNS name foo.internal.example.com not allowed by the name constraints",
+		v.Valid(&x509.Certificate{DNSNames: []string{"foo.internal.example.com"}}, Options{}).Error())
+}
@@ -7,6 +7,7 @@ import (
 	"net/url"
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/smallstep/certificates/db"
@@ -39,6 +40,7 @@ type Audiences struct {
 	SSHRevoke []string
 	SSHRenew  []string
 	SSHRekey  []string
+	Admin     []string
 }
 
 // All returns all supported audiences across all request types in one list.
@@ -49,6 +51,7 @@ func (a Audiences) All() (auds []string) {
 	auds = append(auds, a.SSHRevoke...)
 	auds = append(auds, a.SSHRenew...)
 	auds = append(auds, a.SSHRekey...)
+	auds = append(auds, a.Admin...)
 	return
 }
 
@@ -62,6 +65,7 @@ func (a Audiences) WithFragment(fragment string) Audiences {
 		SSHRevoke: make([]string, len(a.SSHRevoke)),
 		SSHRenew:  make([]string, len(a.SSHRenew)),
 		SSHRekey:  make([]string, len(a.SSHRekey)),
+		Admin:     make([]string, len(a.Admin)),
 	}
 	for i, s := range a.Sign {
 		if u, err := url.Parse(s); err == nil {
@@ -105,6 +109,13 @@ func (a Audiences) WithFragment(fragment string) Audiences {
 			ret.SSHRekey[i] = s
 		}
 	}
+	for i, s := range a.Admin {
+		if u, err := url.Parse(s); err == nil {
+			ret.Admin[i] = u.ResolveReference(&url.URL{Fragment: fragment}).String()
+		} else {
+			ret.Admin[i] = s
+		}
+	}
 	return ret
 }
 
@@ -186,6 +197,9 @@ type Config struct {
 	DB db.AuthDB
 	// SSHKeys are the root SSH public keys
 	SSHKeys *SSHKeys
+	// NameConstraints are the global name constraints applied to all the
+	// X.509 certificates.
+	NameConstraints *NameConstraints
 	// GetIdentityFunc is a function that returns an identity that will be
 	// used by the provisioner to populate certificate attributes.
 	GetIdentityFunc GetIdentityFunc
@@ -279,6 +293,52 @@ func SanitizeSSHUserPrincipal(email string) string {
 	}, strings.ToLower(email))
 }
 
+// MaxSSHCertDuration returns the maximum duration of the SSH certificates of
+// the given type that can be signed using the given provisioner. It returns 0
+// if the provisioner cannot sign SSH certificates.
+func MaxSSHCertDuration(p Interface, certType uint32) time.Duration {
+	var claimer *Claimer
+	switch p := p.(type) {
+	case *JWK:
+		claimer = p.claimer
+	case *OIDC:
+		claimer = p.claimer
+	case *GCP:
+		claimer = p.claimer
+	case *AWS:
+		claimer = p.claimer
+	case *Azure:
+		claimer = p.claimer
+	case *X5C:
+		claimer = p.claimer
+	case *K8sSA:
+		claimer = p.claimer
+	case *SSHPOP:
+		claimer = p.claimer
+	}
+	max := maxSSHCertDuration(claimer, certType)
+	// The claims of the OIDC rules can extend the maximum duration.
+	if p, ok := p.(*OIDC); ok {
+		for _, r := range p.Rules {
+			if d := maxSSHCertDuration(r.claimer, certType); d > max {
+				max = d
+			}
+		}
+	}
+	return max
+}
+
+func maxSSHCertDuration(claimer *Claimer, certType uint32) time.Duration {
+	if claimer == nil || !claimer.IsSSHCAEnabled() {
+		return 0
+	}
+	d, err := claimer.MaxSSHCertDuration(certType)
+	if err != nil {
+		return 0
+	}
+	return d
+}
+
 type base struct{}
 
 // AuthorizeSign returns an unimplemented error. Provisioners should overwrite
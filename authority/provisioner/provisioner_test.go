@@ -4,6 +4,7 @@ import (
 	"context"
 	"net/http"
 	"testing"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/smallstep/assert"
@@ -195,3 +196,47 @@ func TestUnimplementedMethods(t *testing.T) {
 		})
 	}
 }
+
+func TestMaxSSHCertDuration(t *testing.T) {
+	jwk, err := generateJWK()
+	assert.FatalError(t, err)
+
+	disabled := false
+	disabledJWK, err := generateJWK()
+	assert.FatalError(t, err)
+	disabledJWK.claimer, err = NewClaimer(&Claims{EnableSSHCA: &disabled}, globalProvisionerClaims)
+	assert.FatalError(t, err)
+
+	oidc, err := generateOIDC()
+	assert.FatalError(t, err)
+	oidc.Rules = []*OIDCRule{
+		{Claim: "groups", Values: []string{"admins"}, Claims: &Claims{MaxUserSSHDur: &Duration{48 * time.Hour}}},
+		{Claim: "groups", Values: []string{"contractors"}, Claims: &Claims{MaxUserSSHDur: &Duration{time.Hour}}},
+	}
+	for _, r := range oidc.Rules {
+		assert.FatalError(t, r.Init(oidc.claimer))
+	}
+
+	tests := []struct {
+		name     string
+		p        Interface
+		certType uint32
+		want     time.Duration
+	}{
+		{"user", jwk, ssh.UserCert, 24 * time.Hour},
+		{"oidc rules", oidc, ssh.UserCert, 48 * time.Hour},
+		{"oidc rules host", oidc, ssh.HostCert, 30 * 24 * time.Hour},
+		{"host", jwk, ssh.HostCert, 30 * 24 * time.Hour},
+		{"invalid type", jwk, 0, 0},
+		{"ssh disabled", disabledJWK, ssh.UserCert, 0},
+		{"not initialized", &JWK{}, ssh.UserCert, 0},
+		{"acme", &ACME{}, ssh.UserCert, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MaxSSHCertDuration(tt.p, tt.certType); got != tt.want {
+				t.Errorf("MaxSSHCertDuration() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -54,6 +54,13 @@ type CertificateEnforcer interface {
 	Enforce(cert *x509.Certificate) error
 }
 
+// TemplateModifier is the interface used to modify a certificate using a
+// certificate template. The template data of the authority is passed in data.
+type TemplateModifier interface {
+	SignOption
+	Modify(cert *x509.Certificate, req *x509.CertificateRequest, data map[string]interface{}) error
+}
+
 // profileWithOption is a wrapper against x509util.WithOption to conform the
 // interface.
 type profileWithOption x509util.WithOption
@@ -87,6 +94,39 @@ func (e emailOnlyIdentity) Valid(req *x509.CertificateRequest) error {
 	}
 }
 
+// sansAllowlistValidator is a CertificateRequestValidator that checks that the
+// certificate request contains at least one SAN, and that all of them are in
+// the list.
+type sansAllowlistValidator []string
+
+func (v sansAllowlistValidator) Valid(req *x509.CertificateRequest) error {
+	allowed := make(map[string]bool, len(v))
+	for _, s := range v {
+		if ip := net.ParseIP(s); ip != nil {
+			s = ip.String()
+		}
+		allowed[s] = true
+	}
+	var sans []string
+	sans = append(sans, req.DNSNames...)
+	sans = append(sans, req.EmailAddresses...)
+	for _, ip := range req.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	for _, u := range req.URIs {
+		sans = append(sans, u.String())
+	}
+	if len(sans) == 0 {
+		return errors.New("certificate request does not contain any subject alternative name")
+	}
+	for _, s := range sans {
+		if !allowed[s] {
+			return errors.Errorf("certificate request contains an unauthorized subject alternative name %s", s)
+		}
+	}
+	return nil
+}
+
 // defaultPublicKeyValidator validates the public key of a certificate request.
 type defaultPublicKeyValidator struct{}
 
@@ -48,6 +48,46 @@ func Test_emailOnlyIdentity_Valid(t *testing.T) {
 	}
 }
 
+func Test_sansAllowlistValidator_Valid(t *testing.T) {
+	uri, err := url.Parse("spiffe://example.com/name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := url.Parse("spiffe://example.com/other")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v := sansAllowlistValidator{"name@smallstep.com", "name.smallstep.com", "::ffff:10.0.0.1", "spiffe://example.com/name"}
+	type args struct {
+		req *x509.CertificateRequest
+	}
+	tests := []struct {
+		name    string
+		args    args
+		wantErr bool
+	}{
+		{"ok", args{&x509.CertificateRequest{EmailAddresses: []string{"name@smallstep.com"}}}, false},
+		{"ok/all", args{&x509.CertificateRequest{
+			DNSNames: []string{"name.smallstep.com"}, EmailAddresses: []string{"name@smallstep.com"},
+			IPAddresses: []net.IP{net.IPv4(10, 0, 0, 1)}, URIs: []*url.URL{uri},
+		}}, false},
+		{"ok/no-email", args{&x509.CertificateRequest{DNSNames: []string{"name.smallstep.com"}}}, false},
+		{"fail/empty", args{&x509.CertificateRequest{}}, true},
+		{"fail/dns", args{&x509.CertificateRequest{DNSNames: []string{"name.smallstep.com", "foo.smallstep.com"}}}, true},
+		{"fail/email", args{&x509.CertificateRequest{EmailAddresses: []string{"foo@smallstep.com"}}}, true},
+		{"fail/ip", args{&x509.CertificateRequest{IPAddresses: []net.IP{net.IPv4(10, 0, 0, 2)}}}, true},
+		{"fail/uri", args{&x509.CertificateRequest{URIs: []*url.URL{other}}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := v.Valid(tt.args.req); (err != nil) != tt.wantErr {
+				t.Errorf("sansAllowlistValidator.Valid() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
 func Test_defaultPublicKeyValidator_Valid(t *testing.T) {
 	_shortRSA, err := pemutil.Read("./testdata/certs/short-rsa.csr")
 	assert.FatalError(t, err)
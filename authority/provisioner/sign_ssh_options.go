@@ -46,6 +46,14 @@ type SSHCertOptionsValidator interface {
 	Valid(got SSHOptions) error
 }
 
+// SSHTemplateModifier is the interface used to modify an SSH certificate
+// using a certificate template. The template data of the authority is passed
+// in data.
+type SSHTemplateModifier interface {
+	SignOption
+	Modify(cert *ssh.Certificate, data map[string]interface{}) error
+}
+
 // sshModifierFunc is an adapter to allow the use of ordinary functions as SSH
 // certificate modifiers.
 type sshModifierFunc func(cert *ssh.Certificate) error
@@ -0,0 +1,118 @@
+package provisioner
+
+import (
+	"bytes"
+	"crypto/x509"
+	"net/url"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+)
+
+// Default SPIFFE ID paths of the provisioners that support SPIFFE mode. The
+// paths are rendered with the verified identity of the requester.
+const (
+	spiffeK8sSAPath = "/ns/{{ .Namespace }}/sa/{{ .ServiceAccount }}"
+	spiffeAWSPath   = "/aws/{{ .AccountID }}/role/{{ .IAMRole }}"
+	spiffeGCPPath   = "/gcp/{{ .ProjectID }}/sa/{{ .ServiceAccountID }}"
+	spiffeAzurePath = "/azure/{{ .TenantID }}/{{ .ResourceGroup }}/{{ .VirtualMachine }}"
+	spiffeX5CPath   = "/x5c/{{ .CommonName }}"
+)
+
+// spiffePathSegmentRegExp is the regular expression that the segments of a
+// SPIFFE ID path must match.
+var spiffePathSegmentRegExp = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// SPIFFE contains the configuration of the SPIFFE mode of a provisioner. In
+// SPIFFE mode a provisioner issues X.509-SVIDs with the SPIFFE ID
+// spiffe://<trustDomain><path>, where path is a template rendered with the
+// identity verified by the provisioner.
+type SPIFFE struct {
+	TrustDomain string `json:"trustDomain"`
+	Path        string `json:"path,omitempty"`
+	path        *template.Template
+}
+
+// Init validates the trust domain and parses the path template, defaultPath
+// is used if the path is not set.
+func (s *SPIFFE) Init(defaultPath string) error {
+	if s == nil {
+		return nil
+	}
+	if err := validateTrustDomain(s.TrustDomain); err != nil {
+		return err
+	}
+	path := s.Path
+	if path == "" {
+		path = defaultPath
+	}
+	if !strings.HasPrefix(path, "/") {
+		return errors.Errorf("spiffe path %s must start with '/'", path)
+	}
+	tmpl, err := template.New("path").Option("missingkey=error").Parse(path)
+	if err != nil {
+		return errors.Wrap(err, "error parsing spiffe path")
+	}
+	s.path = tmpl
+	return nil
+}
+
+// ID returns the SPIFFE ID for the given identity.
+func (s *SPIFFE) ID(data map[string]interface{}) (*url.URL, error) {
+	buf := new(bytes.Buffer)
+	if err := s.path.Execute(buf, data); err != nil {
+		return nil, errors.Wrap(err, "error executing spiffe path")
+	}
+	path := buf.String()
+	for _, seg := range strings.Split(path, "/")[1:] {
+		if seg == "." || seg == ".." || !spiffePathSegmentRegExp.MatchString(seg) {
+			return nil, errors.Errorf("spiffe path %s is not valid", path)
+		}
+	}
+	return &url.URL{
+		Scheme: "spiffe",
+		Host:   s.TrustDomain,
+		Path:   path,
+	}, nil
+}
+
+// validateTrustDomain validates that a trust domain is not empty and only
+// contains lowercase letters, digits, dots, dashes and underscores.
+func validateTrustDomain(td string) error {
+	if td == "" {
+		return errors.New("spiffe trustDomain cannot be empty")
+	}
+	for _, c := range td {
+		switch {
+		case c >= 'a' && c <= 'z', c >= '0' && c <= '9', c == '.', c == '-', c == '_':
+		default:
+			return errors.Errorf("spiffe trustDomain %s is not valid", td)
+		}
+	}
+	return nil
+}
+
+// spiffeSVIDEnforcer is a CertificateEnforcer that modifies a certificate to
+// follow the X.509-SVID profile: the SPIFFE ID as the only URI, no email
+// addresses, digitalSignature key usage, and no CA capabilities. Enforcers run
+// after the certificate validators, so the resulting certificate, including the
+// SPIFFE ID, is validated again with the name constraints.
+type spiffeSVIDEnforcer struct {
+	id              *url.URL
+	nameConstraints nameConstraintsValidator
+}
+
+// Enforce implements the CertificateEnforcer interface.
+func (e *spiffeSVIDEnforcer) Enforce(cert *x509.Certificate) error {
+	cert.URIs = []*url.URL{e.id}
+	cert.EmailAddresses = nil
+	cert.KeyUsage &= x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment | x509.KeyUsageKeyAgreement
+	cert.KeyUsage |= x509.KeyUsageDigitalSignature
+	cert.BasicConstraintsValid = true
+	cert.IsCA = false
+	cert.MaxPathLen = 0
+	cert.MaxPathLenZero = false
+	return e.nameConstraints.Valid(cert, Options{})
+}
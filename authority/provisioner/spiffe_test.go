@@ -0,0 +1,139 @@
+package provisioner
+
+import (
+	"crypto/x509"
+	"net/url"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/assert"
+)
+
+func TestSPIFFE_Init(t *testing.T) {
+	tests := map[string]struct {
+		s   *SPIFFE
+		err error
+	}{
+		"ok/nil":          {nil, nil},
+		"ok/default":      {&SPIFFE{TrustDomain: "example.com"}, nil},
+		"ok/path":         {&SPIFFE{TrustDomain: "prod.example-1.com", Path: "/k8s/{{ .Namespace }}"}, nil},
+		"fail/empty":      {&SPIFFE{}, errors.New("spiffe trustDomain cannot be empty")},
+		"fail/uppercase":  {&SPIFFE{TrustDomain: "Example.com"}, errors.New("spiffe trustDomain Example.com is not valid")},
+		"fail/scheme":     {&SPIFFE{TrustDomain: "spiffe://example.com"}, errors.New("spiffe trustDomain spiffe://example.com is not valid")},
+		"fail/port":       {&SPIFFE{TrustDomain: "example.com:443"}, errors.New("spiffe trustDomain example.com:443 is not valid")},
+		"fail/path-slash": {&SPIFFE{TrustDomain: "example.com", Path: "foo"}, errors.New("spiffe path foo must start with '/'")},
+		"fail/path-parse": {&SPIFFE{TrustDomain: "example.com", Path: "/{{ .Foo"}, errors.New("error parsing spiffe path")},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if err := tc.s.Init("/ns/{{ .Namespace }}"); err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+			} else {
+				assert.Nil(t, tc.err)
+			}
+		})
+	}
+}
+
+func TestSPIFFE_ID(t *testing.T) {
+	tests := map[string]struct {
+		s    *SPIFFE
+		data map[string]interface{}
+		want string
+		err  error
+	}{
+		"ok/k8sSA":     {&SPIFFE{TrustDomain: "example.com"}, map[string]interface{}{"Namespace": "default", "ServiceAccount": "web"}, "spiffe://example.com/ns/default/sa/web", nil},
+		"ok/path":      {&SPIFFE{TrustDomain: "example.com", Path: "/{{ .Namespace }}"}, map[string]interface{}{"Namespace": "default"}, "spiffe://example.com/default", nil},
+		"ok/x5c":       {&SPIFFE{TrustDomain: "example.com", Path: spiffeX5CPath}, map[string]interface{}{"CommonName": "john_doe-1.example"}, "spiffe://example.com/x5c/john_doe-1.example", nil},
+		"fail/missing": {&SPIFFE{TrustDomain: "example.com"}, map[string]interface{}{"Namespace": "default"}, "", errors.New("error executing spiffe path")},
+		"fail/empty":   {&SPIFFE{TrustDomain: "example.com"}, map[string]interface{}{"Namespace": "", "ServiceAccount": "web"}, "", errors.New("spiffe path /ns//sa/web is not valid")},
+		"fail/dots":    {&SPIFFE{TrustDomain: "example.com"}, map[string]interface{}{"Namespace": "..", "ServiceAccount": "web"}, "", errors.New("spiffe path /ns/../sa/web is not valid")},
+		"fail/query":   {&SPIFFE{TrustDomain: "example.com"}, map[string]interface{}{"Namespace": "default", "ServiceAccount": "web?foo"}, "", errors.New("spiffe path /ns/default/sa/web?foo is not valid")},
+		"fail/spaces":  {&SPIFFE{TrustDomain: "example.com", Path: spiffeX5CPath}, map[string]interface{}{"CommonName": "John Doe"}, "", errors.New("spiffe path /x5c/John Doe is not valid")},
+		"fail/unicode": {&SPIFFE{TrustDomain: "example.com", Path: spiffeX5CPath}, map[string]interface{}{"CommonName": "josé"}, "", errors.New("spiffe path /x5c/josé is not valid")},
+		"fail/percent": {&SPIFFE{TrustDomain: "example.com", Path: spiffeX5CPath}, map[string]interface{}{"CommonName": "john%20doe"}, "", errors.New("spiffe path /x5c/john%20doe is not valid")},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.FatalError(t, tc.s.Init(spiffeK8sSAPath))
+			got, err := tc.s.ID(tc.data)
+			if err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+			} else {
+				if assert.Nil(t, tc.err) {
+					assert.Equals(t, tc.want, got.String())
+				}
+			}
+		})
+	}
+}
+
+func Test_spiffeSVIDEnforcer_Enforce(t *testing.T) {
+	id, err := url.Parse("spiffe://example.com/ns/default/sa/web")
+	assert.FatalError(t, err)
+	other, err := url.Parse("https://example.com")
+	assert.FatalError(t, err)
+
+	cert := &x509.Certificate{
+		DNSNames:              []string{"web.default.svc"},
+		EmailAddresses:        []string{"web@example.com"},
+		URIs:                  []*url.URL{other},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLen:            1,
+	}
+	e := &spiffeSVIDEnforcer{id: id}
+	assert.FatalError(t, e.Enforce(cert))
+	assert.Equals(t, &x509.Certificate{
+		DNSNames:              []string{"web.default.svc"},
+		URIs:                  []*url.URL{id},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+	}, cert)
+
+	cert = &x509.Certificate{KeyUsage: x509.KeyUsageKeyAgreement}
+	assert.FatalError(t, e.Enforce(cert))
+	assert.Equals(t, x509.KeyUsageDigitalSignature|x509.KeyUsageKeyAgreement, cert.KeyUsage)
+	assert.Equals(t, []*url.URL{id}, cert.URIs)
+}
+
+func Test_spiffeSVIDEnforcer_Enforce_nameConstraints(t *testing.T) {
+	id, err := url.Parse("spiffe://example.com/ns/default/sa/web")
+	assert.FatalError(t, err)
+
+	tests := map[string]struct {
+		constraints *NameConstraints
+		dnsNames    []string
+		err         error
+	}{
+		"ok/none":      {nil, nil, nil},
+		"ok/permitted": {&NameConstraints{PermittedURIDomains: []string{"example.com"}}, nil, nil},
+		"fail/permitted": {&NameConstraints{PermittedURIDomains: []string{"other.com"}}, nil,
+			errors.New("certificate contains URI spiffe://example.com/ns/default/sa/web not allowed by the name constraints")},
+		"fail/excluded": {&NameConstraints{ExcludedURIDomains: []string{"example.com"}}, nil,
+			errors.New("certificate contains URI spiffe://example.com/ns/default/sa/web not allowed by the name constraints")},
+		"fail/dns": {&NameConstraints{ExcludedDNSDomains: []string{"internal"}}, []string{"web.internal"},
+			errors.New("certificate contains DNS name web.internal not allowed by the name constraints")},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if tc.constraints != nil {
+				assert.FatalError(t, tc.constraints.Init())
+			}
+			e := &spiffeSVIDEnforcer{id: id, nameConstraints: newNameConstraintsValidator(tc.constraints)}
+			err := e.Enforce(&x509.Certificate{DNSNames: tc.dnsNames})
+			if tc.err == nil {
+				assert.FatalError(t, err)
+			} else if assert.Error(t, err) {
+				assert.Equals(t, tc.err.Error(), err.Error())
+			}
+		})
+	}
+}
@@ -46,6 +46,7 @@ func signSSHCertificate(key crypto.PublicKey, opts SSHOptions, signOpts []SignOp
 	}
 
 	var mods []SSHCertModifier
+	var templateMods []SSHTemplateModifier
 	var validators []SSHCertValidator
 
 	for _, op := range signOpts {
@@ -64,6 +65,9 @@ func signSSHCertificate(key crypto.PublicKey, opts SSHOptions, signOpts []SignOp
 			if err := o.Valid(opts); err != nil {
 				return nil, err
 			}
+		// modify the ssh.Certificate using the certificate template
+		case SSHTemplateModifier:
+			templateMods = append(templateMods, o)
 		default:
 			return nil, fmt.Errorf("signSSH: invalid extra option type %T", o)
 		}
@@ -88,6 +92,13 @@ func signSSHCertificate(key crypto.PublicKey, opts SSHOptions, signOpts []SignOp
 		}
 	}
 
+	// Use provisioner templates
+	for _, m := range templateMods {
+		if err := m.Modify(cert, nil); err != nil {
+			return nil, err
+		}
+	}
+
 	// Get signer from authority keys
 	var signer ssh.Signer
 	switch cert.CertType {
@@ -0,0 +1,544 @@
+package provisioner
+
+import (
+	"bytes"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/Masterminds/sprig/v3"
+	"github.com/pkg/errors"
+	"github.com/smallstep/cli/config"
+	"github.com/smallstep/cli/jose"
+	"golang.org/x/crypto/ssh"
+)
+
+// Templates contains the certificate templates of a provisioner.
+type Templates struct {
+	X509 *TemplateOptions `json:"x509,omitempty"`
+	SSH  *TemplateOptions `json:"ssh,omitempty"`
+}
+
+// Init parses the certificate templates.
+func (t *Templates) Init() error {
+	if t == nil {
+		return nil
+	}
+	if err := t.X509.Init(); err != nil {
+		return errors.Wrap(err, "error initializing x509 template")
+	}
+	if err := t.SSH.Init(); err != nil {
+		return errors.Wrap(err, "error initializing ssh template")
+	}
+	return nil
+}
+
+// GetX509 returns the options of the X.509 certificate template.
+func (t *Templates) GetX509() *TemplateOptions {
+	if t == nil {
+		return nil
+	}
+	return t.X509
+}
+
+// GetSSH returns the options of the SSH certificate template.
+func (t *Templates) GetSSH() *TemplateOptions {
+	if t == nil {
+		return nil
+	}
+	return t.SSH
+}
+
+// TemplateOptions defines a certificate template. The template is a JSON
+// document rendered using text/template, it can be defined inline or in a
+// file, and Data contains the provisioner variables available in it.
+type TemplateOptions struct {
+	Template     string                 `json:"template,omitempty"`
+	TemplateFile string                 `json:"templateFile,omitempty"`
+	Data         map[string]interface{} `json:"data,omitempty"`
+	tmpl         *template.Template
+}
+
+// Init loads and parses the template.
+func (o *TemplateOptions) Init() error {
+	if o == nil {
+		return nil
+	}
+	text := o.Template
+	switch {
+	case o.Template != "" && o.TemplateFile != "":
+		return errors.New("template and templateFile cannot be both defined")
+	case o.TemplateFile != "":
+		filename := config.StepAbs(o.TemplateFile)
+		b, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return errors.Wrapf(err, "error reading %s", filename)
+		}
+		text = string(b)
+	case o.Template == "":
+		return errors.New("template or templateFile must be defined")
+	}
+	for _, k := range []string{"Token", "CR", "Cert", "Identity"} {
+		if _, ok := o.Data[k]; ok {
+			return errors.Errorf("template data cannot contain '%s' as a property", k)
+		}
+	}
+
+	tmpl, err := template.New("template").Funcs(templateFuncs()).Parse(text)
+	if err != nil {
+		return errors.Wrap(err, "error parsing template")
+	}
+	o.tmpl = tmpl
+	return nil
+}
+
+// render executes the template with the given data.
+func (o *TemplateOptions) render(data map[string]interface{}) ([]byte, error) {
+	if o.tmpl == nil {
+		if err := o.Init(); err != nil {
+			return nil, err
+		}
+	}
+	buf := new(bytes.Buffer)
+	if err := o.tmpl.Execute(buf, data); err != nil {
+		return nil, errors.Wrap(err, "error executing template")
+	}
+	return buf.Bytes(), nil
+}
+
+// templateFuncs returns the functions available in the certificate templates,
+// the sprig functions without the ones that give access to the environment.
+func templateFuncs() template.FuncMap {
+	funcs := sprig.TxtFuncMap()
+	delete(funcs, "env")
+	delete(funcs, "expandenv")
+	return funcs
+}
+
+// tokenClaims returns the payload of an already validated token. It returns
+// nil if the token is empty.
+func tokenClaims(token string) (map[string]interface{}, error) {
+	if token == "" {
+		return nil, nil
+	}
+	jwt, err := jose.ParseSigned(token)
+	if err != nil {
+		return nil, errors.Wrap(err, "error parsing token")
+	}
+	var claims map[string]interface{}
+	if err := jwt.UnsafeClaimsWithoutVerification(&claims); err != nil {
+		return nil, errors.Wrap(err, "error parsing token claims")
+	}
+	return claims, nil
+}
+
+// x509TemplateModifier is a TemplateModifier that modifies the certificate
+// with the X.509 template of a provisioner. The template is rendered with the
+// template data of the authority, overridden by the provisioner data, the
+// claims of the token as .Token, the certificate request as .CR, and the
+// identity verified by the provisioner, if any, as .Identity.
+type x509TemplateModifier struct {
+	options  *TemplateOptions
+	token    string
+	identity map[string]interface{}
+}
+
+// newX509TemplateModifier returns the modifier for the X.509 template of the
+// given templates. The modifier does nothing if there is no X.509 template.
+func newX509TemplateModifier(t *Templates, token string) *x509TemplateModifier {
+	return &x509TemplateModifier{
+		options: t.GetX509(),
+		token:   token,
+	}
+}
+
+// withIdentity sets the identity verified by the provisioner.
+func (m *x509TemplateModifier) withIdentity(identity map[string]interface{}) *x509TemplateModifier {
+	m.identity = identity
+	return m
+}
+
+// Modify renders the template and applies it to the certificate.
+func (m *x509TemplateModifier) Modify(cert *x509.Certificate, req *x509.CertificateRequest, data map[string]interface{}) error {
+	if m == nil || m.options == nil {
+		return nil
+	}
+	claims, err := tokenClaims(m.token)
+	if err != nil {
+		return err
+	}
+	merged := make(map[string]interface{}, len(data)+len(m.options.Data)+2)
+	for k, v := range data {
+		merged[k] = v
+	}
+	for k, v := range m.options.Data {
+		merged[k] = v
+	}
+	merged["Token"] = claims
+	merged["CR"] = req
+	if m.identity != nil {
+		merged["Identity"] = m.identity
+	}
+
+	b, err := m.options.render(merged)
+	if err != nil {
+		return errors.Wrap(err, "error rendering x509 template")
+	}
+	var t x509Template
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&t); err != nil {
+		return errors.Wrap(err, "error unmarshaling x509 template")
+	}
+	return t.apply(cert)
+}
+
+// x509Template is the JSON representation of a rendered X.509 template. Only
+// the properties present in the template modify the certificate.
+type x509Template struct {
+	Subject          *x509TemplateSubject          `json:"subject"`
+	DNSNames         templateStrings               `json:"dnsNames"`
+	EmailAddresses   templateStrings               `json:"emailAddresses"`
+	IPAddresses      templateStrings               `json:"ipAddresses"`
+	URIs             templateStrings               `json:"uris"`
+	KeyUsage         templateStrings               `json:"keyUsage"`
+	ExtKeyUsage      templateStrings               `json:"extKeyUsage"`
+	BasicConstraints *x509TemplateBasicConstraints `json:"basicConstraints"`
+	Extensions       []x509TemplateExtension       `json:"extensions"`
+}
+
+// x509TemplateSubject is the subject of an X.509 template.
+type x509TemplateSubject struct {
+	CommonName         string          `json:"commonName"`
+	SerialNumber       string          `json:"serialNumber"`
+	Country            templateStrings `json:"country"`
+	Organization       templateStrings `json:"organization"`
+	OrganizationalUnit templateStrings `json:"organizationalUnit"`
+	Locality           templateStrings `json:"locality"`
+	Province           templateStrings `json:"province"`
+	StreetAddress      templateStrings `json:"streetAddress"`
+	PostalCode         templateStrings `json:"postalCode"`
+}
+
+// x509TemplateBasicConstraints is the basic constraints extension of an X.509
+// template. Templates cannot issue CA certificates, so IsCA must be false and
+// MaxPathLen is ignored.
+type x509TemplateBasicConstraints struct {
+	IsCA       bool `json:"isCA"`
+	MaxPathLen *int `json:"maxPathLen"`
+}
+
+// x509TemplateExtension is a custom extension of an X.509 template, the value
+// is the base64 encoding of the DER value.
+type x509TemplateExtension struct {
+	ID       string `json:"id"`
+	Critical bool   `json:"critical"`
+	Value    []byte `json:"value"`
+}
+
+// templateStrings is a list of strings that can be also defined in JSON as a
+// single string.
+type templateStrings []string
+
+// UnmarshalJSON implements the json.Unmarshaler interface.
+func (s *templateStrings) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+	var v string
+	if err := json.Unmarshal(data, &v); err == nil {
+		*s = []string{v}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*s = templateStrings(list)
+	return nil
+}
+
+var keyUsages = map[string]x509.KeyUsage{
+	"digitalsignature":  x509.KeyUsageDigitalSignature,
+	"contentcommitment": x509.KeyUsageContentCommitment,
+	"keyencipherment":   x509.KeyUsageKeyEncipherment,
+	"dataencipherment":  x509.KeyUsageDataEncipherment,
+	"keyagreement":      x509.KeyUsageKeyAgreement,
+	"certsign":          x509.KeyUsageCertSign,
+	"crlsign":           x509.KeyUsageCRLSign,
+	"encipheronly":      x509.KeyUsageEncipherOnly,
+	"decipheronly":      x509.KeyUsageDecipherOnly,
+}
+
+var extKeyUsages = map[string]x509.ExtKeyUsage{
+	"any":                            x509.ExtKeyUsageAny,
+	"serverauth":                     x509.ExtKeyUsageServerAuth,
+	"clientauth":                     x509.ExtKeyUsageClientAuth,
+	"codesigning":                    x509.ExtKeyUsageCodeSigning,
+	"emailprotection":                x509.ExtKeyUsageEmailProtection,
+	"ipsecendsystem":                 x509.ExtKeyUsageIPSECEndSystem,
+	"ipsectunnel":                    x509.ExtKeyUsageIPSECTunnel,
+	"ipsecuser":                      x509.ExtKeyUsageIPSECUser,
+	"timestamping":                   x509.ExtKeyUsageTimeStamping,
+	"ocspsigning":                    x509.ExtKeyUsageOCSPSigning,
+	"microsoftservergatedcrypto":     x509.ExtKeyUsageMicrosoftServerGatedCrypto,
+	"netscapeservergatedcrypto":      x509.ExtKeyUsageNetscapeServerGatedCrypto,
+	"microsoftcommercialcodesigning": x509.ExtKeyUsageMicrosoftCommercialCodeSigning,
+	"microsoftkernelcodesigning":     x509.ExtKeyUsageMicrosoftKernelCodeSigning,
+}
+
+// apply sets the properties defined in the template in the certificate.
+func (t *x509Template) apply(cert *x509.Certificate) error {
+	if s := t.Subject; s != nil {
+		cert.Subject = pkix.Name{
+			CommonName:         s.CommonName,
+			SerialNumber:       s.SerialNumber,
+			Country:            s.Country,
+			Organization:       s.Organization,
+			OrganizationalUnit: s.OrganizationalUnit,
+			Locality:           s.Locality,
+			Province:           s.Province,
+			StreetAddress:      s.StreetAddress,
+			PostalCode:         s.PostalCode,
+		}
+	}
+
+	if t.DNSNames != nil {
+		cert.DNSNames = t.DNSNames
+	}
+	if t.EmailAddresses != nil {
+		cert.EmailAddresses = t.EmailAddresses
+	}
+	if t.IPAddresses != nil {
+		cert.IPAddresses = make([]net.IP, len(t.IPAddresses))
+		for i, s := range t.IPAddresses {
+			if cert.IPAddresses[i] = net.ParseIP(s); cert.IPAddresses[i] == nil {
+				return errors.Errorf("x509 template contains an invalid IP address %s", s)
+			}
+		}
+	}
+	if t.URIs != nil {
+		cert.URIs = make([]*url.URL, len(t.URIs))
+		for i, s := range t.URIs {
+			u, err := url.Parse(s)
+			if err != nil {
+				return errors.Errorf("x509 template contains an invalid URI %s", s)
+			}
+			cert.URIs[i] = u
+		}
+	}
+
+	if t.KeyUsage != nil {
+		cert.KeyUsage = 0
+		for _, s := range t.KeyUsage {
+			ku, ok := keyUsages[strings.ToLower(s)]
+			if !ok {
+				return errors.Errorf("x509 template contains an unsupported key usage %s", s)
+			}
+			cert.KeyUsage |= ku
+		}
+	}
+	if t.ExtKeyUsage != nil {
+		cert.ExtKeyUsage, cert.UnknownExtKeyUsage = nil, nil
+		for _, s := range t.ExtKeyUsage {
+			if eku, ok := extKeyUsages[strings.ToLower(s)]; ok {
+				cert.ExtKeyUsage = append(cert.ExtKeyUsage, eku)
+				continue
+			}
+			oid, err := parseObjectIdentifier(s)
+			if err != nil {
+				return errors.Errorf("x509 template contains an unsupported extended key usage %s", s)
+			}
+			cert.UnknownExtKeyUsage = append(cert.UnknownExtKeyUsage, oid)
+		}
+	}
+
+	if b := t.BasicConstraints; b != nil {
+		if b.IsCA {
+			return errors.New("x509 template cannot set isCA, provisioners cannot issue CA certificates")
+		}
+		cert.BasicConstraintsValid = true
+		cert.IsCA = false
+		cert.MaxPathLen, cert.MaxPathLenZero = -1, false
+	}
+
+	for _, e := range t.Extensions {
+		oid, err := parseObjectIdentifier(e.ID)
+		if err != nil {
+			return errors.Errorf("x509 template contains an extension with an invalid id %s", e.ID)
+		}
+		if oid.Equal(stepOIDProvisioner) {
+			return errors.New("x509 template cannot contain the provisioner extension")
+		}
+		ext := pkix.Extension{Id: oid, Critical: e.Critical, Value: e.Value}
+		replaced := false
+		for i := range cert.ExtraExtensions {
+			if cert.ExtraExtensions[i].Id.Equal(oid) {
+				cert.ExtraExtensions[i], replaced = ext, true
+			}
+		}
+		if !replaced {
+			cert.ExtraExtensions = append(cert.ExtraExtensions, ext)
+		}
+	}
+
+	return nil
+}
+
+// parseObjectIdentifier parses an object identifier in dot notation.
+func parseObjectIdentifier(s string) (asn1.ObjectIdentifier, error) {
+	parts := strings.Split(s, ".")
+	if len(parts) < 2 {
+		return nil, errors.Errorf("invalid object identifier %s", s)
+	}
+	oid := make(asn1.ObjectIdentifier, len(parts))
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return nil, errors.Errorf("invalid object identifier %s", s)
+		}
+		oid[i] = n
+	}
+	return oid, nil
+}
+
+// sshTemplateModifier is an SSHTemplateModifier that modifies the SSH
+// certificate with the SSH template of a provisioner. The template is rendered
+// with the template data of the authority, overridden by the provisioner data,
+// the claims of the token as .Token, the certificate properties set by the
+// request and the provisioner as .Cert, and the identity verified by the
+// provisioner, if any, as .Identity.
+type sshTemplateModifier struct {
+	options  *TemplateOptions
+	token    string
+	identity map[string]interface{}
+}
+
+// newSSHTemplateModifier returns the modifier for the SSH template of the
+// given templates. The modifier does nothing if there is no SSH template.
+func newSSHTemplateModifier(t *Templates, token string) *sshTemplateModifier {
+	return &sshTemplateModifier{
+		options: t.GetSSH(),
+		token:   token,
+	}
+}
+
+// withIdentity sets the identity verified by the provisioner.
+func (m *sshTemplateModifier) withIdentity(identity map[string]interface{}) *sshTemplateModifier {
+	m.identity = identity
+	return m
+}
+
+// Modify renders the template and applies it to the SSH certificate.
+func (m *sshTemplateModifier) Modify(cert *ssh.Certificate, data map[string]interface{}) error {
+	if m == nil || m.options == nil {
+		return nil
+	}
+	claims, err := tokenClaims(m.token)
+	if err != nil {
+		return err
+	}
+	var certType string
+	switch cert.CertType {
+	case ssh.UserCert:
+		certType = SSHUserCert
+	case ssh.HostCert:
+		certType = SSHHostCert
+	}
+	merged := make(map[string]interface{}, len(data)+len(m.options.Data)+2)
+	for k, v := range data {
+		merged[k] = v
+	}
+	for k, v := range m.options.Data {
+		merged[k] = v
+	}
+	merged["Token"] = claims
+	merged["Cert"] = map[string]interface{}{
+		"Type":       certType,
+		"KeyID":      cert.KeyId,
+		"Principals": cert.ValidPrincipals,
+	}
+	if m.identity != nil {
+		merged["Identity"] = m.identity
+	}
+
+	b, err := m.options.render(merged)
+	if err != nil {
+		return errors.Wrap(err, "error rendering ssh template")
+	}
+	var t sshTemplate
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&t); err != nil {
+		return errors.Wrap(err, "error unmarshaling ssh template")
+	}
+	return t.apply(cert)
+}
+
+// sshTemplate is the JSON representation of a rendered SSH template. Only the
+// properties present in the template modify the certificate, and the critical
+// options and extensions present replace all the existing ones.
+type sshTemplate struct {
+	KeyID           *string           `json:"keyId"`
+	Principals      templateStrings   `json:"principals"`
+	CriticalOptions map[string]string `json:"criticalOptions"`
+	Extensions      map[string]string `json:"extensions"`
+}
+
+// apply sets the properties defined in the template in the SSH certificate.
+func (t *sshTemplate) apply(cert *ssh.Certificate) error {
+	if t.KeyID != nil {
+		cert.KeyId = *t.KeyID
+	}
+	if t.Principals != nil {
+		cert.ValidPrincipals = t.Principals
+	}
+
+	if t.CriticalOptions != nil {
+		if cert.CertType == ssh.HostCert && len(t.CriticalOptions) > 0 {
+			return errors.New("ssh template cannot set critical options in host certificates")
+		}
+		for k, v := range t.CriticalOptions {
+			switch k {
+			case "force-command":
+			case "source-address":
+				if err := validateSourceAddress(v); err != nil {
+					return err
+				}
+			default:
+				return errors.Errorf("ssh template contains an unsupported critical option %s", k)
+			}
+		}
+		cert.CriticalOptions = t.CriticalOptions
+	}
+	if t.Extensions != nil {
+		if cert.CertType == ssh.HostCert && len(t.Extensions) > 0 {
+			return errors.New("ssh template cannot set extensions in host certificates")
+		}
+		cert.Extensions = t.Extensions
+	}
+
+	return nil
+}
+
+// validateSourceAddress validates the value of the source-address critical
+// option, a comma-separated list of addresses in CIDR notation.
+func validateSourceAddress(s string) error {
+	for _, addr := range strings.Split(s, ",") {
+		addr = strings.TrimSpace(addr)
+		if _, _, err := net.ParseCIDR(addr); err == nil {
+			continue
+		}
+		if net.ParseIP(addr) == nil {
+			return errors.Errorf("ssh template contains an invalid source-address %s", addr)
+		}
+	}
+	return nil
+}
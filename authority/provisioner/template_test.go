@@ -0,0 +1,476 @@
+package provisioner
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestTemplateOptions_Init(t *testing.T) {
+	f, err := ioutil.TempFile("", "template")
+	assert.FatalError(t, err)
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(`{"subject":{"commonName":"{{ .Token.sub }}"}}`)
+	assert.FatalError(t, err)
+	assert.FatalError(t, f.Close())
+
+	tests := map[string]struct {
+		o   *TemplateOptions
+		err error
+	}{
+		"ok/nil":        {nil, nil},
+		"ok/template":   {&TemplateOptions{Template: `{"subject":{"commonName":"{{ .Token.sub }}"}}`}, nil},
+		"ok/file":       {&TemplateOptions{TemplateFile: f.Name()}, nil},
+		"ok/sprig":      {&TemplateOptions{Template: `{"dnsNames":{{ toJson (list "foo" "bar") }}}`}, nil},
+		"fail/both":     {&TemplateOptions{Template: "{}", TemplateFile: f.Name()}, errors.New("template and templateFile cannot be both defined")},
+		"fail/empty":    {&TemplateOptions{}, errors.New("template or templateFile must be defined")},
+		"fail/file":     {&TemplateOptions{TemplateFile: "/missing/template.tpl"}, errors.New("error reading /missing/template.tpl")},
+		"fail/parse":    {&TemplateOptions{Template: "{{ .Token"}, errors.New("error parsing template")},
+		"fail/env":      {&TemplateOptions{Template: `{{ env "HOME" }}`}, errors.New("error parsing template")},
+		"fail/token":    {&TemplateOptions{Template: "{}", Data: map[string]interface{}{"Token": "foo"}}, errors.New("template data cannot contain 'Token' as a property")},
+		"fail/cr":       {&TemplateOptions{Template: "{}", Data: map[string]interface{}{"CR": "foo"}}, errors.New("template data cannot contain 'CR' as a property")},
+		"fail/cert":     {&TemplateOptions{Template: "{}", Data: map[string]interface{}{"Cert": "foo"}}, errors.New("template data cannot contain 'Cert' as a property")},
+		"fail/identity": {&TemplateOptions{Template: "{}", Data: map[string]interface{}{"Identity": "foo"}}, errors.New("template data cannot contain 'Identity' as a property")},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if err := tc.o.Init(); err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+			} else {
+				assert.Nil(t, tc.err)
+			}
+		})
+	}
+}
+
+func Test_x509TemplateModifier_Modify(t *testing.T) {
+	jwk, err := generateJSONWebKey()
+	assert.FatalError(t, err)
+	token, err := generateSimpleToken("the-issuer", "the-audience", jwk)
+	assert.FatalError(t, err)
+
+	mustURL := func(s string) *url.URL {
+		u, err := url.Parse(s)
+		assert.FatalError(t, err)
+		return u
+	}
+	customExt := pkix.Extension{Id: asn1.ObjectIdentifier{1, 2, 3, 4}, Value: []byte("bar")}
+	newCert := func() *x509.Certificate {
+		return &x509.Certificate{
+			Subject:         pkix.Name{CommonName: "foo"},
+			DNSNames:        []string{"foo.example.com"},
+			ExtKeyUsage:     []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+			KeyUsage:        x509.KeyUsageDigitalSignature,
+			ExtraExtensions: []pkix.Extension{customExt},
+		}
+	}
+	modCert := func(fn func(*x509.Certificate)) *x509.Certificate {
+		cert := newCert()
+		fn(cert)
+		return cert
+	}
+	csr := &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: "foo"},
+		DNSNames: []string{"foo.example.com", "bar.example.com"},
+	}
+
+	type test struct {
+		templates *Templates
+		token     string
+		data      map[string]interface{}
+		identity  map[string]interface{}
+		want      *x509.Certificate
+		err       error
+	}
+	tests := map[string]test{
+		"ok/no-templates": {
+			want: newCert(),
+		},
+		"ok/no-x509": {
+			templates: &Templates{},
+			want:      newCert(),
+		},
+		"ok/empty": {
+			templates: &Templates{X509: &TemplateOptions{Template: "{}"}},
+			token:     token,
+			want:      newCert(),
+		},
+		"ok/identity": {
+			templates: &Templates{X509: &TemplateOptions{
+				Template: `{"subject":{"commonName":{{ toJson .Identity.VirtualMachine }}},"dnsNames":[{{ toJson .Identity.VirtualMachine }}]}`,
+			}},
+			token:    token,
+			identity: map[string]interface{}{"VirtualMachine": "scale-set_3"},
+			want: modCert(func(c *x509.Certificate) {
+				c.Subject = pkix.Name{CommonName: "scale-set_3"}
+				c.DNSNames = []string{"scale-set_3"}
+			}),
+		},
+		"ok/client-auth": {
+			templates: &Templates{X509: &TemplateOptions{
+				Template: `{"subject":{"commonName":"{{ .Token.sub }}"},"dnsNames":[],"extKeyUsage":"clientAuth"}`,
+			}},
+			token: token,
+			want: modCert(func(c *x509.Certificate) {
+				c.Subject = pkix.Name{CommonName: "subject"}
+				c.DNSNames = []string{}
+				c.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+			}),
+		},
+		"ok/smime": {
+			templates: &Templates{X509: &TemplateOptions{
+				Template: `{
+					"subject": {"commonName": {{ toJson .Token.email }}, "organization": {{ toJson .Organization }}},
+					"dnsNames": [],
+					"emailAddresses": {{ toJson .Token.email }},
+					"keyUsage": ["digitalSignature", "keyEncipherment"],
+					"extKeyUsage": ["emailProtection"]
+				}`,
+				Data: map[string]interface{}{"Organization": "Smallstep"},
+			}},
+			token: token,
+			data:  map[string]interface{}{"Organization": "Acme"},
+			want: modCert(func(c *x509.Certificate) {
+				c.Subject = pkix.Name{CommonName: "name@smallstep.com", Organization: []string{"Smallstep"}}
+				c.DNSNames = []string{}
+				c.EmailAddresses = []string{"name@smallstep.com"}
+				c.KeyUsage = x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment
+				c.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageEmailProtection}
+			}),
+		},
+		"ok/spiffe": {
+			templates: &Templates{X509: &TemplateOptions{
+				Template: `{"subject":{},"dnsNames":[],"uris":"spiffe://{{ .TrustDomain }}/{{ .Token.sub }}","extKeyUsage":["serverAuth","clientAuth"]}`,
+			}},
+			token: token,
+			data:  map[string]interface{}{"TrustDomain": "example.com"},
+			want: modCert(func(c *x509.Certificate) {
+				c.Subject = pkix.Name{}
+				c.DNSNames = []string{}
+				c.URIs = []*url.URL{mustURL("spiffe://example.com/subject")}
+			}),
+		},
+		"ok/csr": {
+			templates: &Templates{X509: &TemplateOptions{
+				Template: `{"dnsNames":{{ toJson .CR.DNSNames }},"ipAddresses":["127.0.0.1","::1"]}`,
+			}},
+			want: modCert(func(c *x509.Certificate) {
+				c.DNSNames = []string{"foo.example.com", "bar.example.com"}
+				c.IPAddresses = []net.IP{net.ParseIP("127.0.0.1"), net.ParseIP("::1")}
+			}),
+		},
+		"ok/extensions": {
+			templates: &Templates{X509: &TemplateOptions{
+				Template: `{
+					"basicConstraints": {"isCA": false},
+					"extKeyUsage": ["1.3.6.1.5.5.7.3.21"],
+					"extensions": [
+						{"id": "1.2.3.4", "critical": true, "value": "DANmb28="},
+						{"id": "1.2.3.5", "value": "DANiYXI="}
+					]
+				}`,
+			}},
+			token: token,
+			want: modCert(func(c *x509.Certificate) {
+				c.BasicConstraintsValid = true
+				c.MaxPathLen = -1
+				c.ExtKeyUsage = nil
+				c.UnknownExtKeyUsage = []asn1.ObjectIdentifier{{1, 3, 6, 1, 5, 5, 7, 3, 21}}
+				c.ExtraExtensions = []pkix.Extension{
+					{Id: asn1.ObjectIdentifier{1, 2, 3, 4}, Critical: true, Value: []byte{0x0c, 0x03, 'f', 'o', 'o'}},
+					{Id: asn1.ObjectIdentifier{1, 2, 3, 5}, Value: []byte{0x0c, 0x03, 'b', 'a', 'r'}},
+				}
+			}),
+		},
+		"ok/not-ca": {
+			templates: &Templates{X509: &TemplateOptions{
+				Template: `{"basicConstraints": {"isCA": false, "maxPathLen": 2}}`,
+			}},
+			want: modCert(func(c *x509.Certificate) {
+				c.BasicConstraintsValid = true
+				c.MaxPathLen = -1
+			}),
+		},
+		"fail/execute": {
+			templates: &Templates{X509: &TemplateOptions{Template: `{{ .Token.sub.foo }}`}},
+			token:     token,
+			err:       errors.New("error rendering x509 template: error executing template"),
+		},
+		"fail/token": {
+			templates: &Templates{X509: &TemplateOptions{Template: "{}"}},
+			token:     "foo",
+			err:       errors.New("error parsing token"),
+		},
+		"fail/json": {
+			templates: &Templates{X509: &TemplateOptions{Template: `{"subject":`}},
+			token:     token,
+			err:       errors.New("error unmarshaling x509 template"),
+		},
+		"fail/unknown-field": {
+			templates: &Templates{X509: &TemplateOptions{Template: `{"foo":"bar"}`}},
+			token:     token,
+			err:       errors.New("error unmarshaling x509 template"),
+		},
+		"fail/ca": {
+			templates: &Templates{X509: &TemplateOptions{Template: `{"basicConstraints": {"isCA": true, "maxPathLen": 0}}`}},
+			err:       errors.New("x509 template cannot set isCA, provisioners cannot issue CA certificates"),
+		},
+		"fail/ip": {
+			templates: &Templates{X509: &TemplateOptions{Template: `{"ipAddresses":["foo"]}`}},
+			err:       errors.New("x509 template contains an invalid IP address foo"),
+		},
+		"fail/uri": {
+			templates: &Templates{X509: &TemplateOptions{Template: `{"uris":["%%"]}`}},
+			err:       errors.New("x509 template contains an invalid URI %%"),
+		},
+		"fail/key-usage": {
+			templates: &Templates{X509: &TemplateOptions{Template: `{"keyUsage":["foo"]}`}},
+			err:       errors.New("x509 template contains an unsupported key usage foo"),
+		},
+		"fail/ext-key-usage": {
+			templates: &Templates{X509: &TemplateOptions{Template: `{"extKeyUsage":["foo"]}`}},
+			err:       errors.New("x509 template contains an unsupported extended key usage foo"),
+		},
+		"fail/extension-id": {
+			templates: &Templates{X509: &TemplateOptions{Template: `{"extensions":[{"id":"foo"}]}`}},
+			err:       errors.New("x509 template contains an extension with an invalid id foo"),
+		},
+		"fail/provisioner-extension": {
+			templates: &Templates{X509: &TemplateOptions{Template: `{"extensions":[{"id":"1.3.6.1.4.1.37476.9000.64.1"}]}`}},
+			err:       errors.New("x509 template cannot contain the provisioner extension"),
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.FatalError(t, tc.templates.Init())
+			cert := newCert()
+			m := newX509TemplateModifier(tc.templates, tc.token).withIdentity(tc.identity)
+			if err := m.Modify(cert, csr, tc.data); err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+			} else {
+				if assert.Nil(t, tc.err) {
+					assert.Equals(t, tc.want, cert)
+				}
+			}
+		})
+	}
+}
+
+func Test_parseObjectIdentifier(t *testing.T) {
+	tests := map[string]struct {
+		s    string
+		want asn1.ObjectIdentifier
+		err  error
+	}{
+		"ok":            {"1.2.3.4", asn1.ObjectIdentifier{1, 2, 3, 4}, nil},
+		"fail/empty":    {"", nil, errors.New("invalid object identifier ")},
+		"fail/single":   {"1", nil, errors.New("invalid object identifier 1")},
+		"fail/negative": {"1.-2", nil, errors.New("invalid object identifier 1.-2")},
+		"fail/name":     {"clientAuth", nil, errors.New("invalid object identifier clientAuth")},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := parseObjectIdentifier(tc.s)
+			if err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.Equals(t, tc.err.Error(), err.Error())
+				}
+			} else {
+				if assert.Nil(t, tc.err) {
+					assert.Equals(t, tc.want, got)
+				}
+			}
+		})
+	}
+}
+
+func Test_sshTemplateModifier_Modify(t *testing.T) {
+	jwk, err := generateJSONWebKey()
+	assert.FatalError(t, err)
+	token, err := generateSimpleToken("the-issuer", "the-audience", jwk)
+	assert.FatalError(t, err)
+
+	newCert := func(certType uint32) *ssh.Certificate {
+		cert := &ssh.Certificate{
+			CertType:        certType,
+			KeyId:           "foo",
+			ValidPrincipals: []string{"foo"},
+		}
+		if certType == ssh.UserCert {
+			cert.Permissions.Extensions = map[string]string{
+				"permit-X11-forwarding":   "",
+				"permit-agent-forwarding": "",
+				"permit-port-forwarding":  "",
+				"permit-pty":              "",
+				"permit-user-rc":          "",
+			}
+		}
+		return cert
+	}
+	modCert := func(certType uint32, fn func(*ssh.Certificate)) *ssh.Certificate {
+		cert := newCert(certType)
+		fn(cert)
+		return cert
+	}
+
+	type test struct {
+		templates *Templates
+		certType  uint32
+		data      map[string]interface{}
+		identity  map[string]interface{}
+		want      *ssh.Certificate
+		err       error
+	}
+	tests := map[string]test{
+		"ok/no-templates": {
+			certType: ssh.UserCert,
+			want:     newCert(ssh.UserCert),
+		},
+		"ok/no-ssh": {
+			templates: &Templates{X509: &TemplateOptions{Template: `{"dnsNames":[]}`}},
+			certType:  ssh.UserCert,
+			want:      newCert(ssh.UserCert),
+		},
+		"ok/empty": {
+			templates: &Templates{SSH: &TemplateOptions{Template: "{}"}},
+			certType:  ssh.UserCert,
+			want:      newCert(ssh.UserCert),
+		},
+		"ok/principals": {
+			templates: &Templates{SSH: &TemplateOptions{
+				Template: `{"keyId":{{ toJson .Token.email }},"principals":{{ toJson (append .Cert.Principals (splitList "@" .Token.email | first)) }}}`,
+			}},
+			certType: ssh.UserCert,
+			want: modCert(ssh.UserCert, func(c *ssh.Certificate) {
+				c.KeyId = "name@smallstep.com"
+				c.ValidPrincipals = []string{"foo", "name"}
+			}),
+		},
+		"ok/identity": {
+			templates: &Templates{SSH: &TemplateOptions{
+				Template: `{"keyId":{{ toJson .Identity.VirtualMachine }},"principals":{{ toJson (list .Identity.VirtualMachine) }}}`,
+			}},
+			certType: ssh.HostCert,
+			identity: map[string]interface{}{"VirtualMachine": "scale-set_3"},
+			want: modCert(ssh.HostCert, func(c *ssh.Certificate) {
+				c.KeyId = "scale-set_3"
+				c.ValidPrincipals = []string{"scale-set_3"}
+			}),
+		},
+		"ok/no-port-forwarding": {
+			templates: &Templates{SSH: &TemplateOptions{
+				Template: `{
+					"extensions": {
+						"permit-pty": "",
+						"permit-agent-forwarding": ""
+					}
+				}`,
+			}},
+			certType: ssh.UserCert,
+			want: modCert(ssh.UserCert, func(c *ssh.Certificate) {
+				c.Permissions.Extensions = map[string]string{
+					"permit-pty":              "",
+					"permit-agent-forwarding": "",
+				}
+			}),
+		},
+		"ok/bastion": {
+			templates: &Templates{SSH: &TemplateOptions{
+				Template: `{
+					"principals": "bastion",
+					"criticalOptions": {
+						"force-command": {{ toJson .Command }},
+						"source-address": "10.0.0.0/8, 192.168.1.1"
+					},
+					"extensions": {"permit-port-forwarding": ""}
+				}`,
+				Data: map[string]interface{}{"Command": "/usr/bin/tunnel"},
+			}},
+			certType: ssh.UserCert,
+			data:     map[string]interface{}{"Command": "/bin/false"},
+			want: modCert(ssh.UserCert, func(c *ssh.Certificate) {
+				c.ValidPrincipals = []string{"bastion"}
+				c.Permissions.CriticalOptions = map[string]string{
+					"force-command":  "/usr/bin/tunnel",
+					"source-address": "10.0.0.0/8, 192.168.1.1",
+				}
+				c.Permissions.Extensions = map[string]string{"permit-port-forwarding": ""}
+			}),
+		},
+		"ok/host": {
+			templates: &Templates{SSH: &TemplateOptions{
+				Template: `{{ if eq .Cert.Type "host" }}{"principals":["{{ .Domain }}"],"extensions":{}}{{ else }}{}{{ end }}`,
+			}},
+			certType: ssh.HostCert,
+			data:     map[string]interface{}{"Domain": "internal.example.com"},
+			want: modCert(ssh.HostCert, func(c *ssh.Certificate) {
+				c.ValidPrincipals = []string{"internal.example.com"}
+				c.Permissions.Extensions = map[string]string{}
+			}),
+		},
+		"fail/execute": {
+			templates: &Templates{SSH: &TemplateOptions{Template: `{{ .Token.sub.foo }}`}},
+			certType:  ssh.UserCert,
+			err:       errors.New("error rendering ssh template: error executing template"),
+		},
+		"fail/json": {
+			templates: &Templates{SSH: &TemplateOptions{Template: `{"principals":`}},
+			certType:  ssh.UserCert,
+			err:       errors.New("error unmarshaling ssh template"),
+		},
+		"fail/unknown-field": {
+			templates: &Templates{SSH: &TemplateOptions{Template: `{"foo":"bar"}`}},
+			certType:  ssh.UserCert,
+			err:       errors.New("error unmarshaling ssh template"),
+		},
+		"fail/critical-option": {
+			templates: &Templates{SSH: &TemplateOptions{Template: `{"criticalOptions":{"foo":"bar"}}`}},
+			certType:  ssh.UserCert,
+			err:       errors.New("ssh template contains an unsupported critical option foo"),
+		},
+		"fail/source-address": {
+			templates: &Templates{SSH: &TemplateOptions{Template: `{"criticalOptions":{"source-address":"10.0.0.0/8,foo"}}`}},
+			certType:  ssh.UserCert,
+			err:       errors.New("ssh template contains an invalid source-address foo"),
+		},
+		"fail/host-critical-options": {
+			templates: &Templates{SSH: &TemplateOptions{Template: `{"criticalOptions":{"force-command":"ls"}}`}},
+			certType:  ssh.HostCert,
+			err:       errors.New("ssh template cannot set critical options in host certificates"),
+		},
+		"fail/host-extensions": {
+			templates: &Templates{SSH: &TemplateOptions{Template: `{"extensions":{"permit-pty":""}}`}},
+			certType:  ssh.HostCert,
+			err:       errors.New("ssh template cannot set extensions in host certificates"),
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.FatalError(t, tc.templates.Init())
+			cert := newCert(tc.certType)
+			m := newSSHTemplateModifier(tc.templates, token).withIdentity(tc.identity)
+			if err := m.Modify(cert, tc.data); err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+			} else {
+				if assert.Nil(t, tc.err) {
+					assert.Equals(t, tc.want, cert)
+				}
+			}
+		})
+	}
+}
@@ -3,16 +3,21 @@ package provisioner
 import (
 	"crypto"
 	"crypto/rand"
+	"crypto/rsa"
 	"crypto/sha256"
 	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
 	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
 	"encoding/pem"
 	"fmt"
 	"io/ioutil"
+	"math/big"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"time"
 
 	"github.com/pkg/errors"
@@ -45,6 +50,7 @@ var (
 		SSHRevoke: []string{"https://ca.smallstep.com/1.0/ssh/revoke"},
 		SSHRenew:  []string{"https://ca.smallstep.com/1.0/ssh/renew"},
 		SSHRekey:  []string{"https://ca.smallstep.com/1.0/ssh/rekey"},
+		Admin:     []string{"https://ca.smallstep.com/1.0/admin"},
 	}
 )
 
@@ -223,6 +229,78 @@ func generateK8sSA(inputPubKey interface{}) (*K8sSA, error) {
 	}, nil
 }
 
+// generateK8sSAWithTokenReview returns a K8sSA provisioner that verifies
+// tokens using a TokenReview API stand-in that authenticates the CA with the
+// token in tokenFile and replies with the given status.
+func generateK8sSAWithTokenReview(tokenFile string, audiences []string, status *k8sTokenReviewStatus) (*K8sSA, *httptest.Server, error) {
+	bearer, err := ioutil.ReadFile(tokenFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method != "POST" || r.URL.Path != k8sTokenReviewPath:
+			http.NotFound(w, r)
+			return
+		case r.Header.Get("Authorization") != "Bearer "+strings.TrimSpace(string(bearer)):
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		var tr k8sTokenReview
+		if err := json.NewDecoder(r.Body).Decode(&tr); err != nil || tr.Kind != "TokenReview" || tr.Spec.Token == "" {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		tr.Status = status
+		b, err := json.Marshal(tr)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		w.Write(b)
+	}))
+
+	claimer, err := NewClaimer(nil, globalProvisionerClaims)
+	if err != nil {
+		srv.Close()
+		return nil, nil, err
+	}
+	tr := &K8sTokenReview{
+		URL:       srv.URL,
+		CABundle:  pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}),
+		TokenFile: tokenFile,
+		Audiences: audiences,
+	}
+	if err := tr.Init(); err != nil {
+		srv.Close()
+		return nil, nil, err
+	}
+	return &K8sSA{
+		Name:        K8sSAName,
+		Type:        "K8sSA",
+		Claims:      &globalProvisionerClaims,
+		TokenReview: tr,
+		audiences:   testAudiences,
+		claimer:     claimer,
+	}, srv, nil
+}
+
+// newK8sTokenReviewStatus returns the status of an authenticated token of
+// the service account ns-foo/san-foo bound to the pod pod-foo.
+func newK8sTokenReviewStatus() *k8sTokenReviewStatus {
+	status := &k8sTokenReviewStatus{Authenticated: true}
+	status.User.Username = "system:serviceaccount:ns-foo:san-foo"
+	status.User.UID = "sauid-foo"
+	status.User.Groups = []string{"system:serviceaccounts", "system:serviceaccounts:ns-foo"}
+	status.User.Extra = map[string][]string{
+		k8sPodNameExtra: {"pod-foo"},
+		k8sPodUIDExtra:  {"poduid-foo"},
+	}
+	return status
+}
+
 func generateSSHPOP() (*SSHPOP, error) {
 	name, err := randutil.Alphanumeric(10)
 	if err != nil {
@@ -576,6 +654,200 @@ func generateAzureWithServer() (*Azure, *httptest.Server, error) {
 	return az, srv, nil
 }
 
+// azureAttestedCerts are the certificates used to sign test attested
+// documents.
+type azureAttestedCerts struct {
+	root         *x509.Certificate
+	intermediate *x509.Certificate
+	leaf         *x509.Certificate
+	key          *rsa.PrivateKey
+}
+
+func (c *azureAttestedCerts) roots() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(c.root)
+	return pool
+}
+
+func generateAzureAttestedCerts(dnsName, issuingCertificateURL string) (*azureAttestedCerts, error) {
+	now := time.Now()
+	create := func(tmpl, parent *x509.Certificate, pub, priv interface{}) (*x509.Certificate, error) {
+		b, err := x509.CreateCertificate(rand.Reader, tmpl, parent, pub, priv)
+		if err != nil {
+			return nil, err
+		}
+		return x509.ParseCertificate(b)
+	}
+	rootKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		return nil, err
+	}
+	intKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		return nil, err
+	}
+	leafKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		return nil, err
+	}
+	caTemplate := func(serial int64, cn string) *x509.Certificate {
+		return &x509.Certificate{
+			SerialNumber:          big.NewInt(serial),
+			Subject:               pkix.Name{CommonName: cn},
+			NotBefore:             now.Add(-time.Minute),
+			NotAfter:              now.Add(time.Hour),
+			KeyUsage:              x509.KeyUsageCertSign,
+			BasicConstraintsValid: true,
+			IsCA:                  true,
+		}
+	}
+	root, err := create(caTemplate(1, "Test Root"), caTemplate(1, "Test Root"), rootKey.Public(), rootKey)
+	if err != nil {
+		return nil, err
+	}
+	intermediate, err := create(caTemplate(2, "Test Intermediate"), root, intKey.Public(), rootKey)
+	if err != nil {
+		return nil, err
+	}
+	leafTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(3),
+		Subject:      pkix.Name{CommonName: dnsName},
+		DNSNames:     []string{dnsName},
+		NotBefore:    now.Add(-time.Minute),
+		NotAfter:     now.Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	if issuingCertificateURL != "" {
+		leafTemplate.IssuingCertificateURL = []string{issuingCertificateURL}
+	}
+	leaf, err := create(leafTemplate, intermediate, leafKey.Public(), intKey)
+	if err != nil {
+		return nil, err
+	}
+	return &azureAttestedCerts{
+		root:         root,
+		intermediate: intermediate,
+		leaf:         leaf,
+		key:          leafKey,
+	}, nil
+}
+
+// generateAzureAttestedDocument returns a PKCS #7 signed attested document.
+// The signature is over the authenticated attributes if withAttributes is
+// true.
+func generateAzureAttestedDocument(doc azureAttestedDocument, signer *x509.Certificate, key *rsa.PrivateKey, certs []*x509.Certificate, withAttributes bool) ([]byte, error) {
+	content, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	octets, err := asn1.Marshal(content)
+	if err != nil {
+		return nil, err
+	}
+
+	var attributes asn1.RawValue
+	signed := content
+	if withAttributes {
+		sum := sha256.Sum256(content)
+		digest, err := asn1.Marshal(sum[:])
+		if err != nil {
+			return nil, err
+		}
+		attr, err := asn1.Marshal(pkcs7Attribute{
+			Type:  oidPKCS7MessageDigest,
+			Value: asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: digest},
+		})
+		if err != nil {
+			return nil, err
+		}
+		attributes = asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: attr}
+		if signed, err = asn1.Marshal(asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: attr}); err != nil {
+			return nil, err
+		}
+	}
+
+	sum := sha256.Sum256(signed)
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, sum[:])
+	if err != nil {
+		return nil, err
+	}
+
+	var raw []byte
+	for _, crt := range certs {
+		raw = append(raw, crt.Raw...)
+	}
+	sha256AlgorithmIdentifier := pkix.AlgorithmIdentifier{Algorithm: oidDigestSHA256}
+	sd, err := asn1.Marshal(pkcs7SignedData{
+		Version:          1,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{sha256AlgorithmIdentifier},
+		ContentInfo: pkcs7ContentInfo{
+			ContentType: oidPKCS7Data,
+			Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: octets},
+		},
+		Certificates: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: raw},
+		SignerInfos: []pkcs7SignerInfo{{
+			Version: 1,
+			IssuerAndSerialNumber: pkcs7IssuerAndSerial{
+				Issuer:       asn1.RawValue{FullBytes: signer.RawIssuer},
+				SerialNumber: signer.SerialNumber,
+			},
+			DigestAlgorithm:           sha256AlgorithmIdentifier,
+			AuthenticatedAttributes:   attributes,
+			DigestEncryptionAlgorithm: pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}},
+			EncryptedDigest:           signature,
+		}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(pkcs7ContentInfo{
+		ContentType: oidPKCS7SignedData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: sd},
+	})
+}
+
+// generateAzureAttestedToken wraps the given access token with an attested
+// document of the given instance.
+func generateAzureAttestedToken(accessToken, subscriptionID, vmID string, certs *azureAttestedCerts) (string, error) {
+	var doc azureAttestedDocument
+	doc.Nonce = azureAttestedNonce(accessToken)
+	doc.SubscriptionID = subscriptionID
+	doc.VMID = vmID
+	doc.TimeStamp.CreatedOn = time.Now().UTC().Format(azureAttestedTimeLayout)
+	doc.TimeStamp.ExpiresOn = time.Now().Add(6 * time.Hour).UTC().Format(azureAttestedTimeLayout)
+	document, err := generateAzureAttestedDocument(doc, certs.leaf, certs.key, []*x509.Certificate{certs.leaf, certs.intermediate}, true)
+	if err != nil {
+		return "", err
+	}
+	return wrapAzureAttestedToken(accessToken, document)
+}
+
+func wrapAzureAttestedToken(accessToken string, document []byte) (string, error) {
+	sig, err := jose.NewSigner(
+		jose.SigningKey{Algorithm: jose.HS256, Key: document},
+		new(jose.SignerOptions).WithType("JWT"),
+	)
+	if err != nil {
+		return "", err
+	}
+	jwt, err := jose.ParseSigned(accessToken)
+	if err != nil {
+		return "", err
+	}
+	var claims azurePayload
+	if err := jwt.UnsafeClaimsWithoutVerification(&claims); err != nil {
+		return "", err
+	}
+	return jose.Signed(sig).Claims(azureAttestedPayload{
+		Claims:   claims.Claims,
+		TenantID: claims.TenantID,
+		Azure: &azureAttestedClaim{
+			AccessToken: accessToken,
+			Document:    document,
+		},
+	}).CompactSerialize()
+}
+
 func generateCollection(nJWK, nOIDC int) (*Collection, error) {
 	col := NewCollection(testAudiences)
 	for i := 0; i < nJWK; i++ {
@@ -660,6 +932,40 @@ func generateToken(sub, iss, aud string, email string, sans []string, iat time.T
 	return jose.Signed(sig).Claims(claims).CompactSerialize()
 }
 
+func generateOIDCToken(iss, aud, email string, extra map[string]interface{}, jwk *jose.JSONWebKey) (string, error) {
+	so := new(jose.SignerOptions)
+	so.WithType("JWT")
+	so.WithHeader("kid", jwk.KeyID)
+
+	sig, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.ES256, Key: jwk.Key}, so)
+	if err != nil {
+		return "", err
+	}
+
+	id, err := randutil.ASCII(64)
+	if err != nil {
+		return "", err
+	}
+
+	iat := time.Now()
+	claims := struct {
+		jose.Claims
+		Email string `json:"email"`
+	}{
+		Claims: jose.Claims{
+			ID:        id,
+			Subject:   "subject",
+			Issuer:    iss,
+			IssuedAt:  jose.NewNumericDate(iat),
+			NotBefore: jose.NewNumericDate(iat),
+			Expiry:    jose.NewNumericDate(iat.Add(5 * time.Minute)),
+			Audience:  []string{aud},
+		},
+		Email: email,
+	}
+	return jose.Signed(sig).Claims(claims).Claims(extra).CompactSerialize()
+}
+
 func generateX5CSSHToken(jwk *jose.JSONWebKey, claims *x5cPayload, tokOpts ...tokOption) (string, error) {
 	so := new(jose.SignerOptions)
 	so.WithType("JWT")
@@ -25,13 +25,17 @@ type x5cPayload struct {
 // signature requests.
 type X5C struct {
 	*base
-	Type      string  `json:"type"`
-	Name      string  `json:"name"`
-	Roots     []byte  `json:"roots"`
-	Claims    *Claims `json:"claims,omitempty"`
-	claimer   *Claimer
-	audiences Audiences
-	rootPool  *x509.CertPool
+	Type            string           `json:"type"`
+	Name            string           `json:"name"`
+	Roots           []byte           `json:"roots"`
+	Claims          *Claims          `json:"claims,omitempty"`
+	NameConstraints *NameConstraints `json:"nameConstraints,omitempty"`
+	Templates       *Templates       `json:"templates,omitempty"`
+	SPIFFE          *SPIFFE          `json:"spiffe,omitempty"`
+	claimer         *Claimer
+	nameConstraints nameConstraintsValidator
+	audiences       Audiences
+	rootPool        *x509.CertPool
 }
 
 // GetID returns the provisioner unique identifier. The name and credential id
@@ -107,8 +111,24 @@ func (p *X5C) Init(config Config) error {
 		return errors.Errorf("no x509 certificates found in roots attribute for provisioner %s", p.GetName())
 	}
 
-	// Update claims with global ones
 	var err error
+	// Initialize the name constraints with the global ones
+	if err = p.NameConstraints.Init(); err != nil {
+		return err
+	}
+	p.nameConstraints = newNameConstraintsValidator(p.NameConstraints, config.NameConstraints)
+
+	// Initialize the certificate templates
+	if err = p.Templates.Init(); err != nil {
+		return err
+	}
+
+	// Initialize the SPIFFE mode
+	if err = p.SPIFFE.Init(spiffeX5CPath); err != nil {
+		return err
+	}
+
+	// Update claims with global ones
 	if p.claimer, err = NewClaimer(p.Claims, config.Claims); err != nil {
 		return err
 	}
@@ -196,7 +216,7 @@ func (p *X5C) AuthorizeSign(ctx context.Context, token string) ([]SignOption, er
 
 	dnsNames, ips, emails := x509util.SplitSANs(claims.SANs)
 
-	return []SignOption{
+	so := []SignOption{
 		// modifiers / withOptions
 		newProvisionerExtensionOption(TypeX5C, p.Name, ""),
 		profileLimitDuration{p.claimer.DefaultTLSCertDuration(), claims.chains[0][0].NotAfter},
@@ -207,7 +227,24 @@ func (p *X5C) AuthorizeSign(ctx context.Context, token string) ([]SignOption, er
 		emailAddressesValidator(emails),
 		ipAddressesValidator(ips),
 		newValidityValidator(p.claimer.MinTLSCertDuration(), p.claimer.MaxTLSCertDuration()),
-	}, nil
+		p.nameConstraints,
+		// template modifiers
+		newX509TemplateModifier(p.Templates, token),
+	}
+
+	// Issue an X.509-SVID with the subject of the leaf certificate
+	if p.SPIFFE != nil {
+		leaf := claims.chains[0][0]
+		id, err := p.SPIFFE.ID(map[string]interface{}{
+			"CommonName": leaf.Subject.CommonName,
+			"Subject":    claims.Subject,
+		})
+		if err != nil {
+			return nil, errs.Wrap(http.StatusUnauthorized, err, "x5c.AuthorizeSign")
+		}
+		so = append(so, &spiffeSVIDEnforcer{id: id, nameConstraints: p.nameConstraints})
+	}
+	return so, nil
 }
 
 // AuthorizeRenew returns an error if the renewal is disabled.
@@ -265,6 +302,8 @@ func (p *X5C) AuthorizeSSHSign(ctx context.Context, token string) ([]SignOption,
 	return append(signOptions,
 		// Set the default extensions.
 		&sshDefaultExtensionModifier{},
+		// Set the template options
+		newSSHTemplateModifier(p.Templates, token),
 		// Checks the validity bounds, and set the validity if has not been set.
 		&sshLimitDuration{p.claimer, claims.chains[0][0].NotAfter},
 		// set the key id to the token subject
@@ -493,6 +493,8 @@ func TestX5C_AuthorizeSign(t *testing.T) {
 								assert.Equals(t, []string(v), tc.emails)
 							case ipAddressesValidator:
 								assert.Equals(t, []net.IP(v), tc.ips)
+							case nameConstraintsValidator:
+							case *x509TemplateModifier:
 							case *validityValidator:
 								assert.Equals(t, v.min, tc.p.claimer.MinTLSCertDuration())
 								assert.Equals(t, v.max, tc.p.claimer.MaxTLSCertDuration())
@@ -501,7 +503,7 @@ func TestX5C_AuthorizeSign(t *testing.T) {
 							}
 							tot++
 						}
-						assert.Equals(t, tot, 8)
+						assert.Equals(t, tot, 10)
 					}
 				}
 			}
@@ -790,7 +792,7 @@ func TestX5C_AuthorizeSSHSign(t *testing.T) {
 							case *sshCertValidityValidator:
 								assert.Equals(t, v.Claimer, tc.p.claimer)
 							case *sshDefaultExtensionModifier, *sshDefaultPublicKeyValidator,
-								*sshCertDefaultValidator:
+								*sshCertDefaultValidator, *sshTemplateModifier:
 							case sshCertKeyIDValidator:
 								assert.Equals(t, string(v), "foo")
 							default:
@@ -799,9 +801,9 @@ func TestX5C_AuthorizeSSHSign(t *testing.T) {
 							tot++
 						}
 						if len(tc.claims.Step.SSH.CertType) > 0 {
-							assert.Equals(t, tot, 13)
+							assert.Equals(t, tot, 14)
 						} else {
-							assert.Equals(t, tot, 9)
+							assert.Equals(t, tot, 10)
 						}
 					}
 				}
@@ -40,6 +40,7 @@ type SSHConfig struct {
 	AddUserPrincipal string          `json:"addUserPrincipal,omitempty"`
 	AddUserCommand   string          `json:"addUserCommand,omitempty"`
 	Bastion          *Bastion        `json:"bastion,omitempty"`
+	Rotation         *SSHRotation    `json:"rotation,omitempty"`
 }
 
 // Bastion contains the custom properties used on bastion.
@@ -61,7 +62,7 @@ func (c *SSHConfig) Validate() error {
 			return err
 		}
 	}
-	return nil
+	return c.Rotation.Validate(c)
 }
 
 // SSHPublicKey contains a public key used by federated CAs to keep old signing
@@ -103,19 +104,26 @@ type SSHKeys struct {
 	HostKeys []ssh.PublicKey
 }
 
-// GetSSHRoots returns the SSH User and Host public keys.
+// GetSSHRoots returns the SSH User and Host public keys. During a key
+// rotation, the new keys are included before they are used to sign
+// certificates, and the previous keys are kept until the certificates signed
+// by them expire.
 func (a *Authority) GetSSHRoots(context.Context) (*SSHKeys, error) {
+	now := time.Now()
 	return &SSHKeys{
-		HostKeys: a.sshCAHostCerts,
-		UserKeys: a.sshCAUserCerts,
+		HostKeys: a.sshHostRotation.publicKeys(a.sshCAHostCerts, now),
+		UserKeys: a.sshUserRotation.publicKeys(a.sshCAUserCerts, now),
 	}, nil
 }
 
-// GetSSHFederation returns the public keys for federated SSH signers.
+// GetSSHFederation returns the public keys for federated SSH signers. During a
+// key rotation, the previous keys are kept until the certificates signed by
+// them expire.
 func (a *Authority) GetSSHFederation(context.Context) (*SSHKeys, error) {
+	now := time.Now()
 	return &SSHKeys{
-		HostKeys: a.sshCAHostFederatedCerts,
-		UserKeys: a.sshCAUserFederatedCerts,
+		HostKeys: a.sshHostRotation.publicKeys(a.sshCAHostFederatedCerts, now),
+		UserKeys: a.sshUserRotation.publicKeys(a.sshCAUserFederatedCerts, now),
 	}, nil
 }
 
@@ -141,13 +149,22 @@ func (a *Authority) GetSSHConfig(ctx context.Context, typ string, data map[strin
 
 	// Merge user and default data
 	var mergedData map[string]interface{}
+	templatesData := a.getTemplatesData()
+
+	// Hosts get the key revocation list to use in sshd
+	if typ == provisioner.SSHHostCert && len(ts) > 0 {
+		var err error
+		if templatesData, err = a.addSSHKeyRevocationList(templatesData); err != nil {
+			return nil, errs.Wrap(http.StatusInternalServerError, err, "getSSHConfig: error generating key revocation list")
+		}
+	}
 
 	if len(data) == 0 {
-		mergedData = a.config.Templates.Data
+		mergedData = templatesData
 	} else {
-		mergedData = make(map[string]interface{}, len(a.config.Templates.Data)+1)
+		mergedData = make(map[string]interface{}, len(templatesData)+1)
 		mergedData["User"] = data
-		for k, v := range a.config.Templates.Data {
+		for k, v := range templatesData {
 			mergedData[k] = v
 		}
 	}
@@ -183,6 +200,7 @@ func (a *Authority) GetSSHBastion(ctx context.Context, user string, hostname str
 // SignSSH creates a signed SSH certificate with the given public key and options.
 func (a *Authority) SignSSH(ctx context.Context, key ssh.PublicKey, opts provisioner.SSHOptions, signOpts ...provisioner.SignOption) (*ssh.Certificate, error) {
 	var mods []provisioner.SSHCertModifier
+	var templateMods []provisioner.SSHTemplateModifier
 	var validators []provisioner.SSHCertValidator
 
 	// Set backdate with the configured value
@@ -204,6 +222,9 @@ func (a *Authority) SignSSH(ctx context.Context, key ssh.PublicKey, opts provisi
 			if err := o.Valid(opts); err != nil {
 				return nil, errs.Wrap(http.StatusForbidden, err, "signSSH")
 			}
+		// modify the ssh.Certificate using the certificate template
+		case provisioner.SSHTemplateModifier:
+			templateMods = append(templateMods, o)
 		default:
 			return nil, errs.InternalServer("signSSH: invalid extra option type %T", o)
 		}
@@ -238,6 +259,14 @@ func (a *Authority) SignSSH(ctx context.Context, key ssh.PublicKey, opts provisi
 		}
 	}
 
+	// Use provisioner templates
+	tplData := a.getTemplatesData()
+	for _, m := range templateMods {
+		if err := m.Modify(cert, tplData); err != nil {
+			return nil, errs.Wrap(http.StatusForbidden, err, "signSSH")
+		}
+	}
+
 	// Get signer from authority keys
 	var signer ssh.Signer
 	switch cert.CertType {
@@ -245,12 +274,12 @@ func (a *Authority) SignSSH(ctx context.Context, key ssh.PublicKey, opts provisi
 		if a.sshCAUserCertSignKey == nil {
 			return nil, errs.NotImplemented("signSSH: user certificate signing is not enabled")
 		}
-		signer = a.sshCAUserCertSignKey
+		signer = a.getSSHUserSigner()
 	case ssh.HostCert:
 		if a.sshCAHostCertSignKey == nil {
 			return nil, errs.NotImplemented("signSSH: host certificate signing is not enabled")
 		}
-		signer = a.sshCAHostCertSignKey
+		signer = a.getSSHHostSigner()
 	default:
 		return nil, errs.InternalServer("signSSH: unexpected ssh certificate type: %d", cert.CertType)
 	}
@@ -323,12 +352,12 @@ func (a *Authority) RenewSSH(ctx context.Context, oldCert *ssh.Certificate) (*ss
 		if a.sshCAUserCertSignKey == nil {
 			return nil, errs.NotImplemented("renewSSH: user certificate signing is not enabled")
 		}
-		signer = a.sshCAUserCertSignKey
+		signer = a.getSSHUserSigner()
 	case ssh.HostCert:
 		if a.sshCAHostCertSignKey == nil {
 			return nil, errs.NotImplemented("renewSSH: host certificate signing is not enabled")
 		}
-		signer = a.sshCAHostCertSignKey
+		signer = a.getSSHHostSigner()
 	default:
 		return nil, errs.InternalServer("renewSSH: unexpected ssh certificate type: %d", cert.CertType)
 	}
@@ -406,12 +435,12 @@ func (a *Authority) RekeySSH(ctx context.Context, oldCert *ssh.Certificate, pub
 		if a.sshCAUserCertSignKey == nil {
 			return nil, errs.NotImplemented("rekeySSH; user certificate signing is not enabled")
 		}
-		signer = a.sshCAUserCertSignKey
+		signer = a.getSSHUserSigner()
 	case ssh.HostCert:
 		if a.sshCAHostCertSignKey == nil {
 			return nil, errs.NotImplemented("rekeySSH; host certificate signing is not enabled")
 		}
-		signer = a.sshCAHostCertSignKey
+		signer = a.getSSHHostSigner()
 	default:
 		return nil, errs.BadRequest("rekeySSH; unexpected ssh certificate type: %d", cert.CertType)
 	}
@@ -464,7 +493,7 @@ func (a *Authority) SignSSHAddUser(ctx context.Context, key ssh.PublicKey, subje
 		return nil, errs.Wrap(http.StatusInternalServerError, err, "signSSHAddUser: error reading random number")
 	}
 
-	signer := a.sshCAUserCertSignKey
+	signer := a.getSSHUserSigner()
 	principal := subject.ValidPrincipals[0]
 	addUserPrincipal := a.getAddUserPrincipal()
 
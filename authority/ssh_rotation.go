@@ -0,0 +1,323 @@
+package authority
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/errs"
+	kmsapi "github.com/smallstep/certificates/kms/apiv1"
+	"github.com/smallstep/certificates/templates"
+	"golang.org/x/crypto/ssh"
+)
+
+// SSHRotation contains the keys that will replace the SSH CA signing keys.
+type SSHRotation struct {
+	User *SSHKeyRotation `json:"user,omitempty"`
+	Host *SSHKeyRotation `json:"host,omitempty"`
+}
+
+// SSHKeyRotation represents the rotation of an SSH CA signing key.
+//
+// The new key is published in the SSH roots and federation as soon as it is
+// configured, and it is used to sign certificates from the switchAt time.
+// After the switch, the previous key is kept in the roots and federation until
+// the longest-lived certificate signed by it expires.
+type SSHKeyRotation struct {
+	Key      string    `json:"key"`
+	SwitchAt time.Time `json:"switchAt"`
+}
+
+// Validate checks the fields in SSHRotation.
+func (r *SSHRotation) Validate(c *SSHConfig) error {
+	switch {
+	case r == nil:
+		return nil
+	case r.User != nil && c.UserKey == "":
+		return errors.New("ssh.rotation.user cannot be set if ssh.userKey is empty")
+	case r.Host != nil && c.HostKey == "":
+		return errors.New("ssh.rotation.host cannot be set if ssh.hostKey is empty")
+	}
+	if err := r.User.Validate(); err != nil {
+		return errors.Wrap(err, "ssh.rotation.user")
+	}
+	if err := r.Host.Validate(); err != nil {
+		return errors.Wrap(err, "ssh.rotation.host")
+	}
+	return nil
+}
+
+// Validate checks the fields in SSHKeyRotation.
+func (r *SSHKeyRotation) Validate() error {
+	switch {
+	case r == nil:
+		return nil
+	case r.Key == "":
+		return errors.New("key cannot be empty")
+	case r.SwitchAt.IsZero():
+		return errors.New("switchAt cannot be empty")
+	default:
+		return nil
+	}
+}
+
+// sshKeyRotation holds the new signing key and the times to switch to it and
+// to retire the previous one. The retirement can be extended while the
+// authority is running, see updateSSHRetirement.
+type sshKeyRotation struct {
+	signer   ssh.Signer
+	switchAt time.Time
+	mutex    sync.RWMutex
+	retireAt time.Time
+}
+
+// isSwitched returns true if the new key must be used to sign certificates.
+func (r *sshKeyRotation) isSwitched(now time.Time) bool {
+	return r != nil && !now.Before(r.switchAt)
+}
+
+// isRetired returns true if the previous key can be removed from the roots
+// and federation.
+func (r *sshKeyRotation) isRetired(now time.Time) bool {
+	return r != nil && !now.Before(r.retirement())
+}
+
+// retirement returns the time when the previous key is retired.
+func (r *sshKeyRotation) retirement() time.Time {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.retireAt
+}
+
+// extendRetirement retires the previous key the given duration after the
+// switch, if that is later than the current retirement. It returns true if the
+// retirement has changed.
+func (r *sshKeyRotation) extendRetirement(d time.Duration) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if t := r.switchAt.Add(d); t.After(r.retireAt) {
+		r.retireAt = t
+		return true
+	}
+	return false
+}
+
+// publicKeys returns the given roots or federated keys, where the first key is
+// the current signing key, with the new key added. After the switch the new
+// key goes first, and the previous one is removed once it is retired.
+func (r *sshKeyRotation) publicKeys(keys []ssh.PublicKey, now time.Time) []ssh.PublicKey {
+	switch {
+	case r == nil || len(keys) == 0:
+		return keys
+	case r.isRetired(now):
+		return append([]ssh.PublicKey{r.signer.PublicKey()}, keys[1:]...)
+	case r.isSwitched(now):
+		return append([]ssh.PublicKey{r.signer.PublicKey()}, keys...)
+	default:
+		return append([]ssh.PublicKey{keys[0], r.signer.PublicKey()}, keys[1:]...)
+	}
+}
+
+// appendKeys appends the current and the new signing keys to the given list
+// if they are not already in it.
+func (r *sshKeyRotation) appendKeys(keys []ssh.PublicKey, current ssh.Signer) []ssh.PublicKey {
+	if r == nil {
+		return keys
+	}
+	for _, s := range []ssh.Signer{current, r.signer} {
+		if s != nil && !containsSSHKey(keys, s.PublicKey()) {
+			keys = append(keys, s.PublicKey())
+		}
+	}
+	return keys
+}
+
+// initSSHRotation loads the signing keys that will replace the current SSH CA
+// keys.
+func (a *Authority) initSSHRotation() error {
+	r := a.config.SSH.Rotation
+	if r == nil {
+		return nil
+	}
+	load := func(kr *SSHKeyRotation) (*sshKeyRotation, error) {
+		if kr == nil {
+			return nil, nil
+		}
+		signer, err := a.keyManager.CreateSigner(&kmsapi.CreateSignerRequest{
+			SigningKey: kr.Key,
+			Password:   []byte(a.config.Password),
+		})
+		if err != nil {
+			return nil, err
+		}
+		sshSigner, err := ssh.NewSignerFromSigner(signer)
+		if err != nil {
+			return nil, errors.Wrap(err, "error creating ssh signer")
+		}
+		return &sshKeyRotation{
+			signer:   sshSigner,
+			switchAt: kr.SwitchAt,
+		}, nil
+	}
+
+	var err error
+	if a.sshUserRotation, err = load(r.User); err != nil {
+		return err
+	}
+	if a.sshHostRotation, err = load(r.Host); err != nil {
+		return err
+	}
+	return nil
+}
+
+// initSSHRetirement sets the time when the previous SSH CA keys are removed
+// from the roots and federation. It must be called after loading the
+// provisioners.
+func (a *Authority) initSSHRetirement() {
+	if r := a.sshUserRotation; r != nil {
+		r.extendRetirement(a.maxSSHCertDuration(ssh.UserCert))
+		log.Printf("ssh user key rotation: switching at %s, retiring the previous key at %s",
+			r.switchAt.Format(time.RFC3339), r.retirement().Format(time.RFC3339))
+	}
+	if r := a.sshHostRotation; r != nil {
+		r.extendRetirement(a.maxSSHCertDuration(ssh.HostCert))
+		log.Printf("ssh host key rotation: switching at %s, retiring the previous key at %s",
+			r.switchAt.Format(time.RFC3339), r.retirement().Format(time.RFC3339))
+	}
+}
+
+// updateSSHRetirement extends the retirement of the previous SSH CA keys if a
+// provisioner created or updated with the admin API allows longer
+// certificates. The previous keys only sign certificates before the switch, so
+// the retirement does not change after it, and it's never moved earlier, as a
+// deleted provisioner might have signed long-lived certificates.
+func (a *Authority) updateSSHRetirement() {
+	now := time.Now()
+	if r := a.sshUserRotation; r != nil && !r.isSwitched(now) {
+		if r.extendRetirement(a.maxSSHCertDuration(ssh.UserCert)) {
+			log.Printf("ssh user key rotation: retiring the previous key at %s", r.retirement().Format(time.RFC3339))
+		}
+	}
+	if r := a.sshHostRotation; r != nil && !r.isSwitched(now) {
+		if r.extendRetirement(a.maxSSHCertDuration(ssh.HostCert)) {
+			log.Printf("ssh host key rotation: retiring the previous key at %s", r.retirement().Format(time.RFC3339))
+		}
+	}
+}
+
+// maxSSHCertDuration returns the maximum duration of the SSH certificates of
+// the given type that the authority can sign.
+func (a *Authority) maxSSHCertDuration(certType uint32) time.Duration {
+	var max time.Duration
+	if claimer, err := provisioner.NewClaimer(a.config.AuthorityConfig.Claims, globalProvisionerClaims); err == nil {
+		max, _ = claimer.MaxSSHCertDuration(certType)
+	}
+	var list provisioner.List
+	for cursor := ""; ; {
+		list, cursor = a.provisioners.Find(cursor, provisioner.DefaultProvisionersMax)
+		for _, p := range list {
+			if d := provisioner.MaxSSHCertDuration(p, certType); d > max {
+				max = d
+			}
+		}
+		if cursor == "" {
+			return max
+		}
+	}
+}
+
+// getSSHUserSigner returns the key used to sign SSH user certificates.
+func (a *Authority) getSSHUserSigner() ssh.Signer {
+	if r := a.sshUserRotation; r.isSwitched(time.Now()) {
+		return r.signer
+	}
+	return a.sshCAUserCertSignKey
+}
+
+// getSSHHostSigner returns the key used to sign SSH host certificates.
+func (a *Authority) getSSHHostSigner() ssh.Signer {
+	if r := a.sshHostRotation; r.isSwitched(time.Now()) {
+		return r.signer
+	}
+	return a.sshCAHostCertSignKey
+}
+
+// getSSHTemplateVars returns the protected SSH variables used in the
+// templates.
+func (a *Authority) getSSHTemplateVars() templates.Step {
+	var vars templates.Step
+	now := time.Now()
+	if a.sshCAHostCertSignKey != nil {
+		keys := a.sshHostRotation.publicKeys(a.sshCAHostFederatedCerts, now)
+		vars.SSH.HostKey = keys[0]
+		vars.SSH.HostFederatedKeys = append(vars.SSH.HostFederatedKeys, keys[1:]...)
+	}
+	if a.sshCAUserCertSignKey != nil {
+		keys := a.sshUserRotation.publicKeys(a.sshCAUserFederatedCerts, now)
+		vars.SSH.UserKey = keys[0]
+		vars.SSH.UserFederatedKeys = append(vars.SSH.UserFederatedKeys, keys[1:]...)
+	}
+	return vars
+}
+
+// getTemplatesData returns the data used in the templates. During a key
+// rotation the SSH variables are computed on each call, so templates render
+// the keys in use at that time.
+func (a *Authority) getTemplatesData() map[string]interface{} {
+	if a.config.Templates == nil {
+		return nil
+	}
+	data := a.config.Templates.Data
+	if a.sshUserRotation == nil && a.sshHostRotation == nil {
+		return data
+	}
+	m := make(map[string]interface{}, len(data))
+	for k, v := range data {
+		m[k] = v
+	}
+	m["Step"] = a.getSSHTemplateVars()
+	return m
+}
+
+// CreateSSHKey creates a new key in the configured KMS to be used as the next
+// SSH CA signing key. It returns the name of the key in the KMS, to be used in
+// the ssh.rotation configuration, and its public key.
+func (a *Authority) CreateSSHKey(name string) (string, ssh.PublicKey, error) {
+	if name == "" {
+		return "", nil, errs.BadRequest("authority.CreateSSHKey; name cannot be empty")
+	}
+	if a.sshCAUserCertSignKey == nil && a.sshCAHostCertSignKey == nil {
+		return "", nil, errs.NotFound("authority.CreateSSHKey; ssh is not configured")
+	}
+
+	resp, err := a.keyManager.CreateKey(&kmsapi.CreateKeyRequest{
+		Name: name,
+	})
+	if err != nil {
+		return "", nil, errs.Wrap(http.StatusInternalServerError, err, "authority.CreateSSHKey")
+	}
+	// Keys not stored in a KMS cannot be loaded again.
+	if resp.PrivateKey != nil {
+		return "", nil, errs.NotImplemented("authority.CreateSSHKey; the configured kms does not store keys")
+	}
+	pub, err := ssh.NewPublicKey(resp.PublicKey)
+	if err != nil {
+		return "", nil, errs.Wrap(http.StatusInternalServerError, err, "authority.CreateSSHKey; error creating ssh public key")
+	}
+	return resp.Name, pub, nil
+}
+
+// containsSSHKey returns true if the list of keys contains the given key.
+func containsSSHKey(keys []ssh.PublicKey, key ssh.PublicKey) bool {
+	b := key.Marshal()
+	for _, k := range keys {
+		if bytes.Equal(k.Marshal(), b) {
+			return true
+		}
+	}
+	return false
+}
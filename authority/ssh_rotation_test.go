@@ -0,0 +1,343 @@
+package authority
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/errs"
+	kmsapi "github.com/smallstep/certificates/kms/apiv1"
+	"github.com/smallstep/certificates/templates"
+	"github.com/smallstep/cli/jose"
+	"golang.org/x/crypto/ssh"
+)
+
+func mustSSHSigner(t *testing.T) (ssh.Signer, crypto.Signer) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.FatalError(t, err)
+	signer, err := ssh.NewSignerFromSigner(key)
+	assert.FatalError(t, err)
+	return signer, key
+}
+
+func TestSSHRotation_Validate(t *testing.T) {
+	switchAt := time.Now().Add(24 * time.Hour)
+	config := &SSHConfig{UserKey: "ssh_user_ca_key", HostKey: "ssh_host_ca_key"}
+
+	tests := map[string]struct {
+		rotation *SSHRotation
+		config   *SSHConfig
+		err      error
+	}{
+		"ok/nil":   {nil, config, nil},
+		"ok/empty": {&SSHRotation{}, config, nil},
+		"ok": {&SSHRotation{
+			User: &SSHKeyRotation{Key: "ssh_user_ca_key_2", SwitchAt: switchAt},
+			Host: &SSHKeyRotation{Key: "ssh_host_ca_key_2", SwitchAt: switchAt},
+		}, config, nil},
+		"fail/no-user-key": {&SSHRotation{
+			User: &SSHKeyRotation{Key: "ssh_user_ca_key_2", SwitchAt: switchAt},
+		}, &SSHConfig{HostKey: "ssh_host_ca_key"}, errors.New("ssh.rotation.user cannot be set if ssh.userKey is empty")},
+		"fail/no-host-key": {&SSHRotation{
+			Host: &SSHKeyRotation{Key: "ssh_host_ca_key_2", SwitchAt: switchAt},
+		}, &SSHConfig{UserKey: "ssh_user_ca_key"}, errors.New("ssh.rotation.host cannot be set if ssh.hostKey is empty")},
+		"fail/user-key": {&SSHRotation{
+			User: &SSHKeyRotation{SwitchAt: switchAt},
+		}, config, errors.New("ssh.rotation.user: key cannot be empty")},
+		"fail/host-switchAt": {&SSHRotation{
+			Host: &SSHKeyRotation{Key: "ssh_host_ca_key_2"},
+		}, config, errors.New("ssh.rotation.host: switchAt cannot be empty")},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := tc.rotation.Validate(tc.config)
+			if tc.err == nil {
+				assert.Nil(t, err)
+			} else if assert.NotNil(t, err) {
+				assert.Equals(t, tc.err.Error(), err.Error())
+			}
+		})
+	}
+}
+
+func Test_sshKeyRotation_keys(t *testing.T) {
+	current, _ := mustSSHSigner(t)
+	next, _ := mustSSHSigner(t)
+	other, _ := mustSSHSigner(t)
+	keys := []ssh.PublicKey{current.PublicKey(), other.PublicKey()}
+
+	now := time.Now()
+	r := &sshKeyRotation{
+		signer:   next,
+		switchAt: now.Add(time.Hour),
+		retireAt: now.Add(25 * time.Hour),
+	}
+
+	tests := map[string]struct {
+		rotation *sshKeyRotation
+		now      time.Time
+		want     []ssh.PublicKey
+	}{
+		"nil": {nil, now, keys},
+		"before-switch": {r, now,
+			[]ssh.PublicKey{current.PublicKey(), next.PublicKey(), other.PublicKey()}},
+		"after-switch": {r, now.Add(2 * time.Hour),
+			[]ssh.PublicKey{next.PublicKey(), current.PublicKey(), other.PublicKey()}},
+		"after-retire": {r, now.Add(26 * time.Hour),
+			[]ssh.PublicKey{next.PublicKey(), other.PublicKey()}},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.Equals(t, tc.want, tc.rotation.publicKeys(keys, tc.now))
+			// The original list is not modified.
+			assert.Equals(t, []ssh.PublicKey{current.PublicKey(), other.PublicKey()}, keys)
+		})
+	}
+}
+
+func TestAuthority_sshRotation(t *testing.T) {
+	userSigner, _ := mustSSHSigner(t)
+	hostSigner, _ := mustSSHSigner(t)
+	nextUserSigner, nextUserKey := mustSSHSigner(t)
+	nextHostSigner, nextHostKey := mustSSHSigner(t)
+
+	newAuthority := func(t *testing.T, switchAt time.Time, opts ...Option) *Authority {
+		a := testAuthority(t, opts...)
+		a.sshCAUserCertSignKey = userSigner
+		a.sshCAHostCertSignKey = hostSigner
+		a.sshCAUserCerts = []ssh.PublicKey{userSigner.PublicKey()}
+		a.sshCAHostCerts = []ssh.PublicKey{hostSigner.PublicKey()}
+		a.sshCAUserFederatedCerts = []ssh.PublicKey{userSigner.PublicKey()}
+		a.sshCAHostFederatedCerts = []ssh.PublicKey{hostSigner.PublicKey()}
+		a.config.SSH = &SSHConfig{
+			UserKey: "ssh_user_ca_key",
+			HostKey: "ssh_host_ca_key",
+			Rotation: &SSHRotation{
+				User: &SSHKeyRotation{Key: "ssh_user_ca_key_2", SwitchAt: switchAt},
+				Host: &SSHKeyRotation{Key: "ssh_host_ca_key_2", SwitchAt: switchAt},
+			},
+		}
+		a.keyManager = &mockKeyManager{
+			createSigner: func(req *kmsapi.CreateSignerRequest) (crypto.Signer, error) {
+				switch req.SigningKey {
+				case "ssh_user_ca_key_2":
+					return nextUserKey, nil
+				case "ssh_host_ca_key_2":
+					return nextHostKey, nil
+				default:
+					return nil, errors.New("key not found")
+				}
+			},
+		}
+		assert.FatalError(t, a.initSSHRotation())
+		a.initSSHRetirement()
+		return a
+	}
+
+	t.Run("before-switch", func(t *testing.T) {
+		switchAt := time.Now().Add(time.Hour)
+		a := newAuthority(t, switchAt)
+		assert.Equals(t, switchAt.Add(24*time.Hour), a.sshUserRotation.retireAt)
+		assert.Equals(t, switchAt.Add(30*24*time.Hour), a.sshHostRotation.retireAt)
+		assert.Equals(t, userSigner, a.getSSHUserSigner())
+		assert.Equals(t, hostSigner, a.getSSHHostSigner())
+
+		roots, err := a.GetSSHRoots(context.Background())
+		assert.FatalError(t, err)
+		assert.Equals(t, []ssh.PublicKey{userSigner.PublicKey(), nextUserSigner.PublicKey()}, roots.UserKeys)
+		assert.Equals(t, []ssh.PublicKey{hostSigner.PublicKey(), nextHostSigner.PublicKey()}, roots.HostKeys)
+
+		vars := a.getSSHTemplateVars()
+		assert.Equals(t, userSigner.PublicKey(), vars.SSH.UserKey)
+		assert.Equals(t, []ssh.PublicKey{nextUserSigner.PublicKey()}, vars.SSH.UserFederatedKeys)
+		assert.Equals(t, hostSigner.PublicKey(), vars.SSH.HostKey)
+		assert.Equals(t, []ssh.PublicKey{nextHostSigner.PublicKey()}, vars.SSH.HostFederatedKeys)
+	})
+
+	t.Run("after-switch", func(t *testing.T) {
+		a := newAuthority(t, time.Now().Add(-time.Hour))
+		assert.Equals(t, nextUserSigner.PublicKey(), a.getSSHUserSigner().PublicKey())
+		assert.Equals(t, nextHostSigner.PublicKey(), a.getSSHHostSigner().PublicKey())
+
+		roots, err := a.GetSSHRoots(context.Background())
+		assert.FatalError(t, err)
+		assert.Equals(t, []ssh.PublicKey{nextUserSigner.PublicKey(), userSigner.PublicKey()}, roots.UserKeys)
+		assert.Equals(t, []ssh.PublicKey{nextHostSigner.PublicKey(), hostSigner.PublicKey()}, roots.HostKeys)
+
+		federation, err := a.GetSSHFederation(context.Background())
+		assert.FatalError(t, err)
+		assert.Equals(t, []ssh.PublicKey{nextUserSigner.PublicKey(), userSigner.PublicKey()}, federation.UserKeys)
+		assert.Equals(t, []ssh.PublicKey{nextHostSigner.PublicKey(), hostSigner.PublicKey()}, federation.HostKeys)
+
+		a.config.Templates = &templates.Templates{Data: map[string]interface{}{"Step": templates.Step{}}}
+		data := a.getTemplatesData()
+		vars, ok := data["Step"].(templates.Step)
+		assert.Fatal(t, ok)
+		assert.Equals(t, nextUserSigner.PublicKey(), vars.SSH.UserKey)
+		assert.Equals(t, []ssh.PublicKey{userSigner.PublicKey()}, vars.SSH.UserFederatedKeys)
+		// The configured data is not modified.
+		assert.Equals(t, templates.Step{}, a.config.Templates.Data["Step"])
+	})
+
+	t.Run("after-retire", func(t *testing.T) {
+		a := newAuthority(t, time.Now().Add(-25*time.Hour))
+		roots, err := a.GetSSHRoots(context.Background())
+		assert.FatalError(t, err)
+		assert.Equals(t, []ssh.PublicKey{nextUserSigner.PublicKey()}, roots.UserKeys)
+
+		federation, err := a.GetSSHFederation(context.Background())
+		assert.FatalError(t, err)
+		assert.Equals(t, []ssh.PublicKey{nextUserSigner.PublicKey()}, federation.UserKeys)
+		// Host certificates can be valid for 30 days.
+		assert.Equals(t, []ssh.PublicKey{nextHostSigner.PublicKey(), hostSigner.PublicKey()}, federation.HostKeys)
+	})
+
+	t.Run("update-retirement", func(t *testing.T) {
+		key, err := ioutil.ReadFile("testdata/secrets/max_pub.jwk")
+		assert.FatalError(t, err)
+		jwk, err := jose.ParseKey("testdata/secrets/max_pub.jwk")
+		assert.FatalError(t, err)
+		teamID := "team:" + jwk.KeyID
+		team := []byte(`{"type":"JWK","name":"team","key":` + string(key) + `,"claims":{"enableSSHCA":true,"maxUserSSHCertDuration":"48h"}}`)
+		teamUpdate := []byte(`{"type":"JWK","name":"team","key":` + string(key) + `,"claims":{"enableSSHCA":true,"maxUserSSHCertDuration":"72h"}}`)
+
+		// Before the switch the retirement is extended, but never shortened
+		switchAt := time.Now().Add(time.Hour)
+		a := newAuthority(t, switchAt, WithDatabase(memoryProvisionersDB(map[string]json.RawMessage{})))
+		_, err = a.CreateProvisioner(team)
+		assert.FatalError(t, err)
+		assert.Equals(t, switchAt.Add(48*time.Hour), a.sshUserRotation.retirement())
+		assert.Equals(t, switchAt.Add(30*24*time.Hour), a.sshHostRotation.retirement())
+		_, err = a.UpdateProvisioner(teamID, teamUpdate)
+		assert.FatalError(t, err)
+		assert.Equals(t, switchAt.Add(72*time.Hour), a.sshUserRotation.retirement())
+		assert.FatalError(t, a.DeleteProvisioner(teamID))
+		assert.Equals(t, switchAt.Add(72*time.Hour), a.sshUserRotation.retirement())
+
+		// After the switch the previous key does not sign certificates
+		switchAt = time.Now().Add(-time.Hour)
+		a = newAuthority(t, switchAt, WithDatabase(memoryProvisionersDB(map[string]json.RawMessage{})))
+		_, err = a.CreateProvisioner(team)
+		assert.FatalError(t, err)
+		assert.Equals(t, switchAt.Add(24*time.Hour), a.sshUserRotation.retirement())
+	})
+
+	t.Run("fail/create-signer", func(t *testing.T) {
+		a := testAuthority(t)
+		a.config.SSH = &SSHConfig{
+			UserKey: "ssh_user_ca_key",
+			Rotation: &SSHRotation{
+				User: &SSHKeyRotation{Key: "missing", SwitchAt: time.Now()},
+			},
+		}
+		a.keyManager = &mockKeyManager{
+			createSigner: func(req *kmsapi.CreateSignerRequest) (crypto.Signer, error) {
+				return nil, errors.New("key not found")
+			},
+		}
+		assert.NotNil(t, a.initSSHRotation())
+	})
+}
+
+func TestAuthority_CreateSSHKey(t *testing.T) {
+	signer, key := mustSSHSigner(t)
+
+	type test struct {
+		auth *Authority
+		name string
+		want string
+		err  error
+		code int
+	}
+	tests := map[string]func(t *testing.T) *test{
+		"ok": func(t *testing.T) *test {
+			a := testAuthority(t)
+			a.sshCAUserCertSignKey = signer
+			a.keyManager = &mockKeyManager{
+				createKey: func(req *kmsapi.CreateKeyRequest) (*kmsapi.CreateKeyResponse, error) {
+					assert.Equals(t, "ssh-user-key-2", req.Name)
+					return &kmsapi.CreateKeyResponse{
+						Name:      "awskms:key-id=ssh-user-key-2",
+						PublicKey: key.Public(),
+					}, nil
+				},
+			}
+			return &test{auth: a, name: "ssh-user-key-2", want: "awskms:key-id=ssh-user-key-2"}
+		},
+		"fail/empty-name": func(t *testing.T) *test {
+			return &test{
+				auth: testAuthority(t),
+				err:  errors.New("authority.CreateSSHKey; name cannot be empty"),
+				code: http.StatusBadRequest,
+			}
+		},
+		"fail/not-configured": func(t *testing.T) *test {
+			a := testAuthority(t)
+			a.sshCAUserCertSignKey = nil
+			a.sshCAHostCertSignKey = nil
+			return &test{
+				auth: a, name: "ssh-user-key-2",
+				err:  errors.New("authority.CreateSSHKey; ssh is not configured"),
+				code: http.StatusNotFound,
+			}
+		},
+		"fail/create-key": func(t *testing.T) *test {
+			a := testAuthority(t)
+			a.sshCAUserCertSignKey = signer
+			a.keyManager = &mockKeyManager{
+				createKey: func(req *kmsapi.CreateKeyRequest) (*kmsapi.CreateKeyResponse, error) {
+					return nil, errors.New("force")
+				},
+			}
+			return &test{
+				auth: a, name: "ssh-user-key-2",
+				err:  errors.New("authority.CreateSSHKey: force"),
+				code: http.StatusInternalServerError,
+			}
+		},
+		"fail/softkms": func(t *testing.T) *test {
+			a := testAuthority(t)
+			a.sshCAUserCertSignKey = signer
+			a.keyManager = &mockKeyManager{
+				createKey: func(req *kmsapi.CreateKeyRequest) (*kmsapi.CreateKeyResponse, error) {
+					return &kmsapi.CreateKeyResponse{
+						Name:       "ssh-user-key-2",
+						PublicKey:  key.Public(),
+						PrivateKey: key,
+					}, nil
+				},
+			}
+			return &test{
+				auth: a, name: "ssh-user-key-2",
+				err:  errors.New("authority.CreateSSHKey; the configured kms does not store keys"),
+				code: http.StatusNotImplemented,
+			}
+		},
+	}
+	for name, genTestCase := range tests {
+		t.Run(name, func(t *testing.T) {
+			tc := genTestCase(t)
+			name, pub, err := tc.auth.CreateSSHKey(tc.name)
+			if err != nil {
+				if assert.NotNil(t, tc.err, err.Error()) {
+					sc, ok := err.(errs.StatusCoder)
+					assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+					assert.Equals(t, sc.StatusCode(), tc.code)
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+			} else if assert.Nil(t, tc.err) {
+				assert.Equals(t, tc.want, name)
+				assert.Equals(t, signer.PublicKey(), pub)
+			}
+		})
+	}
+}
@@ -78,6 +78,16 @@ func (v sshTestOptionsValidator) Valid(opts provisioner.SSHOptions) error {
 	return fmt.Errorf(string(v))
 }
 
+type sshTestTemplateModifier string
+
+func (m sshTestTemplateModifier) Modify(cert *ssh.Certificate, data map[string]interface{}) error {
+	if m == "" {
+		cert.ValidPrincipals = []string{"template"}
+		return nil
+	}
+	return fmt.Errorf(string(m))
+}
+
 type sshTestOptionsModifier string
 
 func (m sshTestOptionsModifier) Option(opts provisioner.SSHOptions) provisioner.SSHCertModifier {
@@ -137,11 +147,13 @@ func TestAuthority_SignSSH(t *testing.T) {
 		{"ok-cert-modifier", fields{signer, signer}, args{pub, provisioner.SSHOptions{}, []provisioner.SignOption{userOptions, sshTestCertModifier("")}}, want{CertType: ssh.UserCert}, false},
 		{"ok-opts-validator", fields{signer, signer}, args{pub, provisioner.SSHOptions{}, []provisioner.SignOption{userOptions, sshTestOptionsValidator("")}}, want{CertType: ssh.UserCert}, false},
 		{"ok-opts-modifier", fields{signer, signer}, args{pub, provisioner.SSHOptions{}, []provisioner.SignOption{userOptions, sshTestOptionsModifier("")}}, want{CertType: ssh.UserCert}, false},
+		{"ok-template-modifier", fields{signer, signer}, args{pub, provisioner.SSHOptions{Principals: []string{"user"}}, []provisioner.SignOption{userOptions, sshTestTemplateModifier("")}}, want{CertType: ssh.UserCert, Principals: []string{"template"}}, false},
 		{"fail-opts-type", fields{signer, signer}, args{pub, provisioner.SSHOptions{CertType: "foo"}, []provisioner.SignOption{}}, want{}, true},
 		{"fail-cert-validator", fields{signer, signer}, args{pub, provisioner.SSHOptions{}, []provisioner.SignOption{userOptions, sshTestCertValidator("an error")}}, want{}, true},
 		{"fail-cert-modifier", fields{signer, signer}, args{pub, provisioner.SSHOptions{}, []provisioner.SignOption{userOptions, sshTestCertModifier("an error")}}, want{}, true},
 		{"fail-opts-validator", fields{signer, signer}, args{pub, provisioner.SSHOptions{}, []provisioner.SignOption{userOptions, sshTestOptionsValidator("an error")}}, want{}, true},
 		{"fail-opts-modifier", fields{signer, signer}, args{pub, provisioner.SSHOptions{}, []provisioner.SignOption{userOptions, sshTestOptionsModifier("an error")}}, want{}, true},
+		{"fail-template-modifier", fields{signer, signer}, args{pub, provisioner.SSHOptions{}, []provisioner.SignOption{userOptions, sshTestTemplateModifier("an error")}}, want{}, true},
 		{"fail-bad-sign-options", fields{signer, signer}, args{pub, provisioner.SSHOptions{}, []provisioner.SignOption{userOptions, "wrong type"}}, want{}, true},
 		{"fail-no-user-key", fields{nil, signer}, args{pub, provisioner.SSHOptions{CertType: "user"}, []provisioner.SignOption{}}, want{}, true},
 		{"fail-no-host-key", fields{signer, nil}, args{pub, provisioner.SSHOptions{CertType: "host"}, []provisioner.SignOption{}}, want{}, true},
@@ -533,6 +545,12 @@ func TestSSHConfig_Validate(t *testing.T) {
 		{"ok", &SSHConfig{Keys: []*SSHPublicKey{{Type: "host", Key: key.Public()}}}, false},
 		{"badType", &SSHConfig{Keys: []*SSHPublicKey{{Type: "bad", Key: key.Public()}}}, true},
 		{"badKey", &SSHConfig{Keys: []*SSHPublicKey{{Type: "user", Key: *key}}}, true},
+		{"okRotation", &SSHConfig{UserKey: "ssh_user_ca_key", Rotation: &SSHRotation{
+			User: &SSHKeyRotation{Key: "ssh_user_ca_key_2", SwitchAt: time.Now()},
+		}}, false},
+		{"badRotation", &SSHConfig{Rotation: &SSHRotation{
+			Host: &SSHKeyRotation{Key: "ssh_host_ca_key_2", SwitchAt: time.Now()},
+		}}, true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
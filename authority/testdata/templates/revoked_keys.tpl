@@ -0,0 +1 @@
+{{.Step.SSH.KRL | toString}}
\ No newline at end of file
@@ -1,12 +1,14 @@
 package authority
 
 import (
+	"bytes"
 	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/asn1"
 	"encoding/base64"
 	"encoding/pem"
+	"log"
 	"net/http"
 	"strings"
 	"time"
@@ -65,11 +67,17 @@ func (a *Authority) Sign(csr *x509.CertificateRequest, signOpts provisioner.Opti
 		mods            = []x509util.WithOption{withDefaultASN1DN(a.config.AuthorityConfig.Template)}
 		certValidators  = []provisioner.CertificateValidator{}
 		forcedModifiers = []provisioner.CertificateEnforcer{}
+		templateMods    = []provisioner.TemplateModifier{}
 	)
 
 	// Set backdate with the configured value
 	signOpts.Backdate = a.config.AuthorityConfig.Backdate.Duration
 
+	// Add the OCSP responder urls if configured
+	if a.config.OCSP != nil && len(a.config.OCSP.URLs) > 0 {
+		mods = append(mods, withOCSPServers(a.config.OCSP.URLs))
+	}
+
 	for _, op := range extraOpts {
 		switch k := op.(type) {
 		case provisioner.CertificateValidator:
@@ -82,6 +90,8 @@ func (a *Authority) Sign(csr *x509.CertificateRequest, signOpts provisioner.Opti
 			mods = append(mods, k.Option(signOpts))
 		case provisioner.CertificateEnforcer:
 			forcedModifiers = append(forcedModifiers, k)
+		case provisioner.TemplateModifier:
+			templateMods = append(templateMods, k)
 		default:
 			return nil, errs.InternalServer("authority.Sign; invalid extra option type %T", append([]interface{}{k}, opts...)...)
 		}
@@ -91,11 +101,29 @@ func (a *Authority) Sign(csr *x509.CertificateRequest, signOpts provisioner.Opti
 		return nil, errs.Wrap(http.StatusBadRequest, err, "authority.Sign; invalid certificate request", opts...)
 	}
 
-	leaf, err := x509util.NewLeafProfileWithCSR(csr, a.x509Issuer, a.x509Signer, mods...)
+	issuer, signer := a.getX509Signer()
+
+	// Add the CRL distribution point of the issuer if enabled
+	if a.config.CRL.IsEnabled() {
+		mods = append(mods, withCRLDistributionPoints(a.config.getCRLDistributionPoints(x509IssuerID(issuer))))
+	}
+
+	leaf, err := x509util.NewLeafProfileWithCSR(csr, issuer, signer, mods...)
 	if err != nil {
 		return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.Sign", opts...)
 	}
 
+	// Certificate templates
+	var data map[string]interface{}
+	if a.config.Templates != nil {
+		data = a.config.Templates.Data
+	}
+	for _, m := range templateMods {
+		if err := m.Modify(leaf.Subject(), csr, data); err != nil {
+			return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.Sign", opts...)
+		}
+	}
+
 	// Certificate validation
 	for _, v := range certValidators {
 		if err := v.Valid(leaf.Subject(), signOpts); err != nil {
@@ -129,7 +157,7 @@ func (a *Authority) Sign(csr *x509.CertificateRequest, signOpts provisioner.Opti
 		}
 	}
 
-	return []*x509.Certificate{serverCert, a.x509Issuer}, nil
+	return []*x509.Certificate{serverCert, issuer}, nil
 }
 
 // Renew creates a new Certificate identical to the old certificate, except
@@ -142,6 +170,10 @@ func (a *Authority) Renew(oldCert *x509.Certificate) ([]*x509.Certificate, error
 		return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.Renew", opts...)
 	}
 
+	// Certificates issued by a retired intermediate are renewed with the
+	// active one.
+	issuer, signer := a.getX509Signer()
+
 	// Durations
 	backdate := a.config.AuthorityConfig.Backdate.Duration
 	duration := oldCert.NotAfter.Sub(oldCert.NotBefore)
@@ -149,7 +181,7 @@ func (a *Authority) Renew(oldCert *x509.Certificate) ([]*x509.Certificate, error
 
 	newCert := &x509.Certificate{
 		PublicKey:                   oldCert.PublicKey,
-		Issuer:                      a.x509Issuer.Subject,
+		Issuer:                      issuer.Subject,
 		Subject:                     oldCert.Subject,
 		NotBefore:                   now.Add(-1 * backdate),
 		NotAfter:                    now.Add(duration - backdate),
@@ -189,7 +221,7 @@ func (a *Authority) Renew(oldCert *x509.Certificate) ([]*x509.Certificate, error
 		}
 	}
 
-	leaf, err := x509util.NewLeafProfileWithTemplate(newCert, a.x509Issuer, a.x509Signer)
+	leaf, err := x509util.NewLeafProfileWithTemplate(newCert, issuer, signer)
 	if err != nil {
 		return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.Renew", opts...)
 	}
@@ -211,7 +243,7 @@ func (a *Authority) Renew(oldCert *x509.Certificate) ([]*x509.Certificate, error
 		}
 	}
 
-	return []*x509.Certificate{serverCert, a.x509Issuer}, nil
+	return []*x509.Certificate{serverCert, issuer}, nil
 }
 
 // RevokeOptions are the options for the Revoke API.
@@ -221,16 +253,16 @@ type RevokeOptions struct {
 	ReasonCode  int
 	PassiveOnly bool
 	MTLS        bool
+	ACME        bool
 	Crt         *x509.Certificate
 	OTT         string
+	KeyID       string
 }
 
 // Revoke revokes a certificate.
 //
-// NOTE: Only supports passive revocation - prevent existing certificates from
-// being renewed.
-//
-// TODO: Add OCSP and CRL support.
+// NOTE: Revoked certificates cannot be renewed, and their status will be
+// reported as revoked by the OCSP responder and in the CRL if enabled.
 func (a *Authority) Revoke(ctx context.Context, revokeOpts *RevokeOptions) error {
 	opts := []interface{}{
 		errs.WithKeyVal("serialNumber", revokeOpts.Serial),
@@ -238,9 +270,11 @@ func (a *Authority) Revoke(ctx context.Context, revokeOpts *RevokeOptions) error
 		errs.WithKeyVal("reason", revokeOpts.Reason),
 		errs.WithKeyVal("passiveOnly", revokeOpts.PassiveOnly),
 		errs.WithKeyVal("MTLS", revokeOpts.MTLS),
+		errs.WithKeyVal("ACME", revokeOpts.ACME),
+		errs.WithKeyVal("keyID", revokeOpts.KeyID),
 		errs.WithKeyVal("context", string(provisioner.MethodFromContext(ctx))),
 	}
-	if revokeOpts.MTLS {
+	if revokeOpts.MTLS || revokeOpts.ACME {
 		opts = append(opts, errs.WithKeyVal("certificate", base64.StdEncoding.EncodeToString(revokeOpts.Crt.Raw)))
 	} else {
 		opts = append(opts, errs.WithKeyVal("token", revokeOpts.OTT))
@@ -251,6 +285,7 @@ func (a *Authority) Revoke(ctx context.Context, revokeOpts *RevokeOptions) error
 		ReasonCode: revokeOpts.ReasonCode,
 		Reason:     revokeOpts.Reason,
 		MTLS:       revokeOpts.MTLS,
+		ACME:       revokeOpts.ACME,
 		RevokedAt:  time.Now().UTC(),
 	}
 
@@ -258,8 +293,8 @@ func (a *Authority) Revoke(ctx context.Context, revokeOpts *RevokeOptions) error
 		p   provisioner.Interface
 		err error
 	)
-	// If not mTLS then get the TokenID of the token.
-	if !revokeOpts.MTLS {
+	// If not mTLS nor ACME then get the TokenID of the token.
+	if !revokeOpts.MTLS && !revokeOpts.ACME {
 		token, err := jose.ParseSigned(revokeOpts.OTT)
 		if err != nil {
 			return errs.Wrap(http.StatusUnauthorized, err,
@@ -285,6 +320,21 @@ func (a *Authority) Revoke(ctx context.Context, revokeOpts *RevokeOptions) error
 		}
 		opts = append(opts, errs.WithKeyVal("tokenID", rci.TokenID))
 	} else {
+		// ACME requests are authorized using the account or the certificate
+		// key, so we need to make sure that the certificate was issued by us.
+		if revokeOpts.ACME {
+			crt, err := a.db.GetCertificate(revokeOpts.Serial)
+			switch {
+			case err == db.ErrNotImplemented:
+				return errs.NotImplemented("authority.Revoke; no persistence layer configured", opts...)
+			case err == db.ErrNotFound:
+				return errs.Unauthorized("authority.Revoke; certificate not issued by this authority", opts...)
+			case err != nil:
+				return errs.Wrap(http.StatusInternalServerError, err, "authority.Revoke", opts...)
+			case !bytes.Equal(crt.Raw, revokeOpts.Crt.Raw):
+				return errs.Unauthorized("authority.Revoke; certificate not issued by this authority", opts...)
+			}
+		}
 		// Load the Certificate provisioner if one exists.
 		p, err = a.LoadProvisionerByCertificate(revokeOpts.Crt)
 		if err != nil {
@@ -296,9 +346,33 @@ func (a *Authority) Revoke(ctx context.Context, revokeOpts *RevokeOptions) error
 	opts = append(opts, errs.WithKeyVal("provisionerID", rci.ProvisionerID))
 
 	if provisioner.MethodFromContext(ctx) == provisioner.SSHRevokeMethod {
+		// The key id is added to the key revocation list, and it revokes all
+		// the certificates with it, it must match the revoked certificate.
+		if revokeOpts.KeyID != "" {
+			crt, err := a.db.GetSSHCertificate(revokeOpts.Serial)
+			switch {
+			case err == db.ErrNotImplemented:
+				return errs.NotImplemented("authority.Revoke; no persistence layer configured", opts...)
+			case err == db.ErrNotFound:
+				return errs.BadRequest("authority.Revoke; ssh certificate with serial "+
+					"number %s not found", append([]interface{}{revokeOpts.Serial}, opts...)...)
+			case err != nil:
+				return errs.Wrap(http.StatusInternalServerError, err, "authority.Revoke", opts...)
+			case crt.KeyId != revokeOpts.KeyID:
+				return errs.BadRequest("authority.Revoke; key id %s does not match the ssh certificate",
+					append([]interface{}{revokeOpts.KeyID}, opts...)...)
+			}
+			rci.KeyID = revokeOpts.KeyID
+		}
 		err = a.db.RevokeSSH(rci)
 	} else { // default to revoke x509
 		err = a.db.Revoke(rci)
+		// Publish the revocation in a new CRL.
+		if err == nil && a.config.CRL.IsEnabled() {
+			if err := a.generateCRLs(); err != nil {
+				log.Printf("error generating certificate revocation list: %v", err)
+			}
+		}
 	}
 	switch err {
 	case nil:
@@ -315,7 +389,8 @@ func (a *Authority) Revoke(ctx context.Context, revokeOpts *RevokeOptions) error
 
 // GetTLSCertificate creates a new leaf certificate to be used by the CA HTTPS server.
 func (a *Authority) GetTLSCertificate() (*tls.Certificate, error) {
-	profile, err := x509util.NewLeafProfile("Step Online CA", a.x509Issuer, a.x509Signer,
+	issuer, signer := a.getX509Signer()
+	profile, err := x509util.NewLeafProfile("Step Online CA", issuer, signer,
 		x509util.WithHosts(strings.Join(a.config.DNSNames, ",")))
 	if err != nil {
 		return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.GetTLSCertificate")
@@ -338,7 +413,7 @@ func (a *Authority) GetTLSCertificate() (*tls.Certificate, error) {
 
 	// Load the x509 key pair (combining server and intermediate blocks)
 	// to a tls.Certificate.
-	intermediatePEM, err := pemutil.Serialize(a.x509Issuer)
+	intermediatePEM, err := pemutil.Serialize(issuer)
 	if err != nil {
 		return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.GetTLSCertificate")
 	}
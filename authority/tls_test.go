@@ -20,11 +20,13 @@ import (
 	"github.com/smallstep/certificates/authority/provisioner"
 	"github.com/smallstep/certificates/db"
 	"github.com/smallstep/certificates/errs"
+	"github.com/smallstep/certificates/templates"
 	"github.com/smallstep/cli/crypto/keys"
 	"github.com/smallstep/cli/crypto/pemutil"
 	"github.com/smallstep/cli/crypto/tlsutil"
 	"github.com/smallstep/cli/crypto/x509util"
 	"github.com/smallstep/cli/jose"
+	"golang.org/x/crypto/ssh"
 	"gopkg.in/square/go-jose.v2/jwt"
 )
 
@@ -52,6 +54,12 @@ func (m *certificateDurationEnforcer) Enforce(cert *x509.Certificate) error {
 	return nil
 }
 
+type certificateTemplateModifier func(cert *x509.Certificate, req *x509.CertificateRequest, data map[string]interface{}) error
+
+func (m certificateTemplateModifier) Modify(cert *x509.Certificate, req *x509.CertificateRequest, data map[string]interface{}) error {
+	return m(cert, req, data)
+}
+
 func withProvisionerOID(name, kid string) x509util.WithOption {
 	return func(p x509util.Profile) error {
 		crt := p.Subject()
@@ -252,6 +260,26 @@ ZYtQ9Ot36qc=
 				code:      http.StatusInternalServerError,
 			}
 		},
+		"fail template modifier": func(t *testing.T) *signTest {
+			csr := getCSR(t, priv)
+			_a := testAuthority(t)
+			_a.config.Templates = &templates.Templates{
+				Data: map[string]interface{}{"Foo": "Bar"},
+			}
+			return &signTest{
+				auth: _a,
+				csr:  csr,
+				extraOpts: append(extraOpts, certificateTemplateModifier(func(cert *x509.Certificate, req *x509.CertificateRequest, data map[string]interface{}) error {
+					assert.Equals(t, csr, req)
+					assert.Equals(t, "smallstep test", cert.Subject.CommonName)
+					assert.Equals(t, map[string]interface{}{"Foo": "Bar"}, data)
+					return errors.New("force")
+				})),
+				signOpts: signOpts,
+				err:      errors.New("authority.Sign: force"),
+				code:     http.StatusInternalServerError,
+			}
+		},
 		"ok": func(t *testing.T) *signTest {
 			csr := getCSR(t, priv)
 			_a := testAuthority(t)
@@ -799,6 +827,79 @@ func TestAuthority_Revoke(t *testing.T) {
 				},
 			}
 		},
+		"fail/acme/not-issued": func() test {
+			_a := testAuthority(t, WithDatabase(&db.MockAuthDB{
+				MGetCertificate: func(sn string) (*x509.Certificate, error) {
+					return nil, db.ErrNotFound
+				},
+			}))
+
+			crt, err := pemutil.ReadCertificate("./testdata/certs/foo.crt")
+			assert.FatalError(t, err)
+
+			return test{
+				auth: _a,
+				opts: &RevokeOptions{
+					Crt:        crt,
+					Serial:     "102012593071130646873265215610956555026",
+					ReasonCode: reasonCode,
+					Reason:     reason,
+					ACME:       true,
+				},
+				err:  errors.New("authority.Revoke; certificate not issued by this authority"),
+				code: http.StatusUnauthorized,
+			}
+		},
+		"fail/acme/mismatch": func() test {
+			_a := testAuthority(t, WithDatabase(&db.MockAuthDB{
+				MGetCertificate: func(sn string) (*x509.Certificate, error) {
+					return &x509.Certificate{Raw: []byte("foo")}, nil
+				},
+			}))
+
+			crt, err := pemutil.ReadCertificate("./testdata/certs/foo.crt")
+			assert.FatalError(t, err)
+
+			return test{
+				auth: _a,
+				opts: &RevokeOptions{
+					Crt:        crt,
+					Serial:     "102012593071130646873265215610956555026",
+					ReasonCode: reasonCode,
+					Reason:     reason,
+					ACME:       true,
+				},
+				err:  errors.New("authority.Revoke; certificate not issued by this authority"),
+				code: http.StatusUnauthorized,
+			}
+		},
+		"ok/acme": func() test {
+			crt, err := pemutil.ReadCertificate("./testdata/certs/foo.crt")
+			assert.FatalError(t, err)
+
+			_a := testAuthority(t, WithDatabase(&db.MockAuthDB{
+				MGetCertificate: func(sn string) (*x509.Certificate, error) {
+					assert.Equals(t, "102012593071130646873265215610956555026", sn)
+					return crt, nil
+				},
+				MRevoke: func(rci *db.RevokedCertificateInfo) error {
+					assert.True(t, rci.ACME)
+					assert.Equals(t, reasonCode, rci.ReasonCode)
+					return nil
+				},
+			}))
+
+			return test{
+				auth: _a,
+				opts: &RevokeOptions{
+					Crt:        crt,
+					Serial:     "102012593071130646873265215610956555026",
+					ReasonCode: reasonCode,
+					Reason:     reason,
+					ACME:       true,
+				},
+			}
+		},
 	}
 	for name, f := range tests {
 		tc := f()
@@ -829,3 +930,87 @@ func TestAuthority_Revoke(t *testing.T) {
 		})
 	}
 }
+
+func TestAuthority_Revoke_sshKeyID(t *testing.T) {
+	now := time.Now().UTC()
+	jwk, err := jose.ParseKey("testdata/secrets/step_cli_key_priv.jwk", jose.WithPassword([]byte("pass")))
+	assert.FatalError(t, err)
+	sig, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.ES256, Key: jwk.Key},
+		(&jose.SignerOptions{}).WithType("JWT").WithHeader("kid", jwk.KeyID))
+	assert.FatalError(t, err)
+	raw, err := jwt.Signed(sig).Claims(jwt.Claims{
+		Subject:   "1234",
+		Issuer:    "step-cli",
+		NotBefore: jwt.NewNumericDate(now),
+		Expiry:    jwt.NewNumericDate(now.Add(time.Minute)),
+		Audience:  testAudiences.SSHRevoke,
+		ID:        "44",
+	}).CompactSerialize()
+	assert.FatalError(t, err)
+
+	cert := &ssh.Certificate{Serial: 1234, KeyId: "bob@smallstep.com"}
+	getSSHCertificate := func(sn string) (*ssh.Certificate, error) {
+		assert.Equals(t, "1234", sn)
+		return cert, nil
+	}
+
+	tests := map[string]struct {
+		db    db.AuthDB
+		keyID string
+		err   error
+		code  int
+	}{
+		"ok": {&db.MockAuthDB{
+			MGetSSHCertificate: getSSHCertificate,
+			MRevokeSSH: func(rci *db.RevokedCertificateInfo) error {
+				assert.Equals(t, "1234", rci.Serial)
+				assert.Equals(t, "bob@smallstep.com", rci.KeyID)
+				return nil
+			},
+		}, "bob@smallstep.com", nil, 0},
+		"ok/no-key-id": {&db.MockAuthDB{
+			MRevokeSSH: func(rci *db.RevokedCertificateInfo) error {
+				assert.Equals(t, "", rci.KeyID)
+				return nil
+			},
+		}, "", nil, 0},
+		"fail/mismatch": {&db.MockAuthDB{
+			MGetSSHCertificate: getSSHCertificate,
+		}, "alice@smallstep.com", errors.New("authority.Revoke; key id alice@smallstep.com does not match the ssh certificate"), http.StatusBadRequest},
+		"fail/not-found": {&db.MockAuthDB{
+			Err: db.ErrNotFound,
+		}, "bob@smallstep.com", errors.New("authority.Revoke; ssh certificate with serial number 1234 not found"), http.StatusBadRequest},
+		"fail/db-error": {&db.MockAuthDB{
+			Err: errors.New("force"),
+		}, "bob@smallstep.com", errors.New("authority.Revoke: force"), http.StatusInternalServerError},
+		"fail/nil-db": {nil, "bob@smallstep.com", errors.New("authority.Revoke; no persistence layer configured"), http.StatusNotImplemented},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			var opts []Option
+			if tc.db != nil {
+				opts = append(opts, WithDatabase(tc.db))
+			}
+			a := testAuthority(t, opts...)
+			ctx := provisioner.NewContextWithMethod(context.Background(), provisioner.SSHRevokeMethod)
+			err := a.Revoke(ctx, &RevokeOptions{
+				Serial:      "1234",
+				ReasonCode:  1,
+				Reason:      "bob was let go",
+				PassiveOnly: true,
+				OTT:         raw,
+				KeyID:       tc.keyID,
+			})
+			if err != nil {
+				if assert.NotNil(t, tc.err, fmt.Sprintf("unexpected error: %s", err)) {
+					sc, ok := err.(errs.StatusCoder)
+					assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+					assert.Equals(t, sc.StatusCode(), tc.code)
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+			} else {
+				assert.Nil(t, tc.err)
+			}
+		})
+	}
+}
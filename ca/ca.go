@@ -63,11 +63,12 @@ func WithDatabase(db db.AuthDB) Option {
 // CA is the type used to build the complete certificate authority. It builds
 // the HTTP server, set ups the middlewares and the HTTP handlers.
 type CA struct {
-	auth    *authority.Authority
-	config  *authority.Config
-	srv     *server.Server
-	opts    *options
-	renewer *TLSRenewer
+	auth     *authority.Authority
+	acmeAuth *acme.Authority
+	config   *authority.Config
+	srv      *server.Server
+	opts     *options
+	renewer  *TLSRenewer
 }
 
 // New creates and initializes the CA with the given configuration and options.
@@ -168,6 +169,7 @@ func (ca *CA) Init(config *authority.Config) (*CA, error) {
 	}
 
 	ca.auth = auth
+	ca.acmeAuth = acmeAuth
 	ca.srv = server.New(config.Address, handler, tlsConfig)
 	return ca, nil
 }
@@ -180,6 +182,7 @@ func (ca *CA) Run() error {
 // Stop stops the CA calling to the server Shutdown method.
 func (ca *CA) Stop() error {
 	ca.renewer.Stop()
+	ca.acmeAuth.Shutdown()
 	if err := ca.auth.Shutdown(); err != nil {
 		log.Printf("error stopping ca.Authority: %+v\n", err)
 	}
@@ -221,11 +224,14 @@ func (ca *CA) Reload() error {
 		return errors.Wrap(err, "error reloading server")
 	}
 
-	// 1. Stop previous renewer
+	// 1. Stop previous renewer and authority background tasks
 	// 2. Replace ca properties
 	// Do not replace ca.srv
 	ca.renewer.Stop()
+	ca.auth.CloseForReload()
+	ca.acmeAuth.Shutdown()
 	ca.auth = newCA.auth
+	ca.acmeAuth = newCA.acmeAuth
 	ca.config = newCA.config
 	ca.opts = newCA.opts
 	ca.renewer = newCA.renewer
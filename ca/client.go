@@ -2,6 +2,7 @@ package ca
 
 import (
 	"bytes"
+	"context"
 	"crypto"
 	"crypto/ecdsa"
 	"crypto/elliptic"
@@ -20,6 +21,7 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/smallstep/certificates/api"
@@ -1030,6 +1032,94 @@ retry:
 	return &bastion, nil
 }
 
+// DeviceAuthorization performs the POST /oidc/device/authorize request to the
+// CA and returns the api.DeviceAuthorizationResponse struct. The user must
+// visit the verification uri and enter the user code, while the client polls
+// the CA using DeviceToken or WaitDeviceToken.
+func (c *Client) DeviceAuthorization(req *api.DeviceAuthorizationRequest) (*api.DeviceAuthorizationResponse, error) {
+	var retried bool
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "client.DeviceAuthorization; error marshaling request")
+	}
+	u := c.endpoint.ResolveReference(&url.URL{Path: "/oidc/device/authorize"})
+retry:
+	resp, err := c.client.Post(u.String(), "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, errors.Wrapf(err, "client.DeviceAuthorization; client POST %s failed", u)
+	}
+	if resp.StatusCode >= 400 {
+		if !retried && c.retryOnError(resp) {
+			retried = true
+			goto retry
+		}
+		return nil, readError(resp.Body)
+	}
+	var da api.DeviceAuthorizationResponse
+	if err := readJSON(resp.Body, &da); err != nil {
+		return nil, errors.Wrapf(err, "client.DeviceAuthorization; error reading %s", u)
+	}
+	return &da, nil
+}
+
+// DeviceToken performs the POST /oidc/device/token request to the CA and
+// returns the api.DeviceTokenResponse struct. If the user has not completed
+// the authorization yet, the response will only contain the status
+// api.DeviceStatusPending or api.DeviceStatusSlowDown.
+func (c *Client) DeviceToken(req *api.DeviceTokenRequest) (*api.DeviceTokenResponse, error) {
+	var retried bool
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "client.DeviceToken; error marshaling request")
+	}
+	u := c.endpoint.ResolveReference(&url.URL{Path: "/oidc/device/token"})
+retry:
+	resp, err := c.client.Post(u.String(), "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, errors.Wrapf(err, "client.DeviceToken; client POST %s failed", u)
+	}
+	if resp.StatusCode >= 400 {
+		if !retried && c.retryOnError(resp) {
+			retried = true
+			goto retry
+		}
+		return nil, readError(resp.Body)
+	}
+	var token api.DeviceTokenResponse
+	if err := readJSON(resp.Body, &token); err != nil {
+		return nil, errors.Wrapf(err, "client.DeviceToken; error reading %s", u)
+	}
+	return &token, nil
+}
+
+// WaitDeviceToken polls the CA using DeviceToken every interval seconds until
+// the user completes the authorization, the authorization fails, or the
+// context is done. As defined in RFC 8628, the interval is increased by 5
+// seconds every time the CA responds with api.DeviceStatusSlowDown.
+func (c *Client) WaitDeviceToken(ctx context.Context, req *api.DeviceTokenRequest, interval int) (*api.DeviceTokenResponse, error) {
+	if interval <= 0 {
+		interval = 5
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, errors.Wrap(ctx.Err(), "client.WaitDeviceToken; device authorization was not completed")
+		case <-time.After(time.Duration(interval) * time.Second):
+		}
+		resp, err := c.DeviceToken(req)
+		if err != nil {
+			return nil, err
+		}
+		switch resp.Status {
+		case api.DeviceStatusPending:
+		case api.DeviceStatusSlowDown:
+			interval += 5
+		default:
+			return resp, nil
+		}
+	}
+}
+
 // RootFingerprint is a helper method that returns the current root fingerprint.
 // It does an health connection and gets the fingerprint from the TLS verified
 // chains.
@@ -2,6 +2,7 @@ package ca
 
 import (
 	"bytes"
+	"context"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
@@ -991,3 +992,182 @@ func TestClient_SSHBastion(t *testing.T) {
 		})
 	}
 }
+
+func TestClient_DeviceAuthorization(t *testing.T) {
+	ok := &api.DeviceAuthorizationResponse{
+		DeviceCode:      "device-code",
+		UserCode:        "ABCD-EFGH",
+		VerificationURI: "https://idp.example.com/device",
+		ExpiresIn:       600,
+		Interval:        5,
+	}
+
+	tests := []struct {
+		name         string
+		request      *api.DeviceAuthorizationRequest
+		response     interface{}
+		responseCode int
+		wantErr      bool
+		err          error
+	}{
+		{"ok", &api.DeviceAuthorizationRequest{Provisioner: "oidc"}, ok, 200, false, nil},
+		{"bad-response", &api.DeviceAuthorizationRequest{Provisioner: "oidc"}, "bad json", 200, true, nil},
+		{"bad-request", &api.DeviceAuthorizationRequest{}, errs.BadRequest("force"), 400, true, errors.New(errs.BadRequestDefaultMsg)},
+		{"not-found", &api.DeviceAuthorizationRequest{Provisioner: "foo"}, errs.NotFound("force"), 404, true, errors.New(errs.NotFoundDefaultMsg)},
+	}
+
+	srv := httptest.NewServer(nil)
+	defer srv.Close()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := NewClient(srv.URL, WithTransport(http.DefaultTransport))
+			if err != nil {
+				t.Errorf("NewClient() error = %v", err)
+				return
+			}
+
+			srv.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				if req.URL.Path != "/oidc/device/authorize" {
+					t.Errorf("Client.DeviceAuthorization() path = %s, want /oidc/device/authorize", req.URL.Path)
+				}
+				api.JSONStatus(w, tt.response, tt.responseCode)
+			})
+
+			got, err := c.DeviceAuthorization(tt.request)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Client.DeviceAuthorization() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+
+			switch {
+			case err != nil:
+				if got != nil {
+					t.Errorf("Client.DeviceAuthorization() = %v, want nil", got)
+				}
+				if tt.responseCode != 200 {
+					sc, ok := err.(errs.StatusCoder)
+					assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+					assert.Equals(t, sc.StatusCode(), tt.responseCode)
+					assert.HasPrefix(t, tt.err.Error(), err.Error())
+				}
+			default:
+				if !reflect.DeepEqual(got, tt.response) {
+					t.Errorf("Client.DeviceAuthorization() = %v, want %v", got, tt.response)
+				}
+			}
+		})
+	}
+}
+
+func TestClient_DeviceToken(t *testing.T) {
+	ok := &api.DeviceTokenResponse{
+		Status: api.DeviceStatusComplete,
+		Sign: &api.SignResponse{
+			ServerPEM: api.Certificate{Certificate: parseCertificate(certPEM)},
+			CaPEM:     api.Certificate{Certificate: parseCertificate(rootPEM)},
+			CertChainPEM: []api.Certificate{
+				{Certificate: parseCertificate(certPEM)},
+				{Certificate: parseCertificate(rootPEM)},
+			},
+		},
+	}
+	pending := &api.DeviceTokenResponse{Status: api.DeviceStatusPending}
+	request := &api.DeviceTokenRequest{
+		Provisioner: "oidc",
+		DeviceCode:  "device-code",
+		Sign: &api.SignRequest{
+			CsrPEM: api.CertificateRequest{CertificateRequest: parseCertificateRequest(csrPEM)},
+		},
+	}
+
+	tests := []struct {
+		name         string
+		request      *api.DeviceTokenRequest
+		response     interface{}
+		responseCode int
+		wantErr      bool
+		err          error
+	}{
+		{"ok", request, ok, 201, false, nil},
+		{"ok-pending", request, pending, 202, false, nil},
+		{"bad-response", request, "bad json", 201, true, nil},
+		{"bad-request", &api.DeviceTokenRequest{}, errs.BadRequest("force"), 400, true, errors.New(errs.BadRequestDefaultMsg)},
+		{"unauthorized", request, errs.Unauthorized("force"), 401, true, errors.New(errs.UnauthorizedDefaultMsg)},
+	}
+
+	srv := httptest.NewServer(nil)
+	defer srv.Close()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := NewClient(srv.URL, WithTransport(http.DefaultTransport))
+			if err != nil {
+				t.Errorf("NewClient() error = %v", err)
+				return
+			}
+
+			srv.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				if req.URL.Path != "/oidc/device/token" {
+					t.Errorf("Client.DeviceToken() path = %s, want /oidc/device/token", req.URL.Path)
+				}
+				api.JSONStatus(w, tt.response, tt.responseCode)
+			})
+
+			got, err := c.DeviceToken(tt.request)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Client.DeviceToken() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+
+			switch {
+			case err != nil:
+				if got != nil {
+					t.Errorf("Client.DeviceToken() = %v, want nil", got)
+				}
+				if tt.responseCode >= 400 {
+					sc, ok := err.(errs.StatusCoder)
+					assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+					assert.Equals(t, sc.StatusCode(), tt.responseCode)
+					assert.HasPrefix(t, tt.err.Error(), err.Error())
+				}
+			default:
+				if !reflect.DeepEqual(got, tt.response) {
+					t.Errorf("Client.DeviceToken() = %v, want %v", got, tt.response)
+				}
+			}
+		})
+	}
+}
+
+func TestClient_WaitDeviceToken(t *testing.T) {
+	ok := &api.DeviceTokenResponse{Status: api.DeviceStatusComplete}
+	request := &api.DeviceTokenRequest{Provisioner: "oidc", DeviceCode: "device-code"}
+
+	var calls int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		calls++
+		if calls == 1 {
+			api.JSONStatus(w, &api.DeviceTokenResponse{Status: api.DeviceStatusPending}, http.StatusAccepted)
+			return
+		}
+		api.JSONStatus(w, ok, http.StatusCreated)
+	}))
+	defer srv.Close()
+
+	c, err := NewClient(srv.URL, WithTransport(http.DefaultTransport))
+	assert.FatalError(t, err)
+
+	got, err := c.WaitDeviceToken(context.Background(), request, 1)
+	assert.FatalError(t, err)
+	assert.Equals(t, ok, got)
+	assert.Equals(t, 2, calls)
+
+	// Cancelled context
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	got, err = c.WaitDeviceToken(ctx, request, 1)
+	assert.Error(t, err)
+	assert.Nil(t, got)
+	assert.Equals(t, 2, calls)
+}
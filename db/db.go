@@ -17,17 +17,22 @@ var (
 	certsTable             = []byte("x509_certs")
 	revokedCertsTable      = []byte("revoked_x509_certs")
 	revokedSSHCertsTable   = []byte("revoked_ssh_certs")
+	crlTable               = []byte("x509_crl")
 	usedOTTTable           = []byte("used_ott")
 	sshCertsTable          = []byte("ssh_certs")
 	sshHostsTable          = []byte("ssh_hosts")
 	sshUsersTable          = []byte("ssh_users")
 	sshHostPrincipalsTable = []byte("ssh_host_principals")
+	provisionersTable      = []byte("provisioners")
 )
 
 // ErrAlreadyExists can be returned if the DB attempts to set a key that has
 // been previously set.
 var ErrAlreadyExists = errors.New("already exists")
 
+// ErrNotFound is returned if the DB does not contain the requested key.
+var ErrNotFound = errors.New("not found")
+
 // Config represents the JSON attributes used for configuring a step-ca DB.
 type Config struct {
 	Type       string `json:"type"`
@@ -47,11 +52,21 @@ type AuthDB interface {
 	IsSSHRevoked(sn string) (bool, error)
 	Revoke(rci *RevokedCertificateInfo) error
 	RevokeSSH(rci *RevokedCertificateInfo) error
+	GetRevokedCertificate(sn string) (*RevokedCertificateInfo, error)
+	GetRevokedCertificates() ([]RevokedCertificateInfo, error)
+	GetRevokedSSHCertificates() ([]RevokedCertificateInfo, error)
+	GetCRL(issuerID string) (*CertificateRevocationListInfo, error)
+	StoreCRL(issuerID string, crl *CertificateRevocationListInfo) error
+	GetCertificate(serialNumber string) (*x509.Certificate, error)
 	StoreCertificate(crt *x509.Certificate) error
 	UseToken(id, tok string) (bool, error)
 	IsSSHHost(name string) (bool, error)
+	GetSSHCertificate(serial string) (*ssh.Certificate, error)
 	StoreSSHCertificate(crt *ssh.Certificate) error
 	GetSSHHostPrincipals() ([]string, error)
+	GetProvisioners() ([]json.RawMessage, error)
+	StoreProvisioner(id string, data json.RawMessage) error
+	DeleteProvisioner(id string) error
 	Shutdown() error
 }
 
@@ -81,7 +96,7 @@ func New(c *Config) (AuthDB, error) {
 	tables := [][]byte{
 		revokedCertsTable, certsTable, usedOTTTable,
 		sshCertsTable, sshHostsTable, sshHostPrincipalsTable, sshUsersTable,
-		revokedSSHCertsTable,
+		revokedSSHCertsTable, crlTable, provisionersTable,
 	}
 	for _, b := range tables {
 		if err := db.CreateTable(b); err != nil {
@@ -103,6 +118,16 @@ type RevokedCertificateInfo struct {
 	RevokedAt     time.Time
 	TokenID       string
 	MTLS          bool
+	ACME          bool
+	KeyID         string
+}
+
+// CertificateRevocationListInfo contains a certificate revocation list (CRL)
+// in DER format and its associated metadata.
+type CertificateRevocationListInfo struct {
+	Number    int64
+	ExpiresAt time.Time
+	DER       []byte
 }
 
 // IsRevoked returns whether or not a certificate with the given identifier
@@ -187,6 +212,107 @@ func (db *DB) RevokeSSH(rci *RevokedCertificateInfo) error {
 	}
 }
 
+// GetRevokedCertificate returns the revocation information of the certificate
+// with the given serial number. It returns ErrNotFound if the certificate has
+// not been revoked.
+func (db *DB) GetRevokedCertificate(sn string) (*RevokedCertificateInfo, error) {
+	b, err := db.Get(revokedCertsTable, []byte(sn))
+	if err != nil {
+		if nosql.IsErrNotFound(err) {
+			return nil, ErrNotFound
+		}
+		return nil, errors.Wrap(err, "error checking revocation bucket")
+	}
+	rci := new(RevokedCertificateInfo)
+	if err := json.Unmarshal(b, rci); err != nil {
+		return nil, errors.Wrap(err, "error unmarshaling revoked certificate info")
+	}
+	return rci, nil
+}
+
+// GetRevokedCertificates returns the revocation information of all the revoked
+// X509 certificates.
+func (db *DB) GetRevokedCertificates() ([]RevokedCertificateInfo, error) {
+	entries, err := db.List(revokedCertsTable)
+	if err != nil {
+		return nil, errors.Wrap(err, "database List error")
+	}
+	revoked := make([]RevokedCertificateInfo, 0, len(entries))
+	for _, e := range entries {
+		var rci RevokedCertificateInfo
+		if err := json.Unmarshal(e.Value, &rci); err != nil {
+			return nil, errors.Wrapf(err, "error unmarshaling revoked certificate info %s", e.Key)
+		}
+		revoked = append(revoked, rci)
+	}
+	return revoked, nil
+}
+
+// GetRevokedSSHCertificates returns the revocation information of all the
+// revoked SSH certificates.
+func (db *DB) GetRevokedSSHCertificates() ([]RevokedCertificateInfo, error) {
+	entries, err := db.List(revokedSSHCertsTable)
+	if err != nil {
+		return nil, errors.Wrap(err, "database List error")
+	}
+	revoked := make([]RevokedCertificateInfo, 0, len(entries))
+	for _, e := range entries {
+		var rci RevokedCertificateInfo
+		if err := json.Unmarshal(e.Value, &rci); err != nil {
+			return nil, errors.Wrapf(err, "error unmarshaling revoked ssh certificate info %s", e.Key)
+		}
+		revoked = append(revoked, rci)
+	}
+	return revoked, nil
+}
+
+// GetCRL returns the last certificate revocation list stored for the issuer
+// with the given id. It returns ErrNotFound if a CRL has not been stored yet.
+func (db *DB) GetCRL(issuerID string) (*CertificateRevocationListInfo, error) {
+	b, err := db.Get(crlTable, []byte(issuerID))
+	if err != nil {
+		if nosql.IsErrNotFound(err) {
+			return nil, ErrNotFound
+		}
+		return nil, errors.Wrap(err, "database Get error")
+	}
+	crl := new(CertificateRevocationListInfo)
+	if err := json.Unmarshal(b, crl); err != nil {
+		return nil, errors.Wrap(err, "error unmarshaling certificate revocation list info")
+	}
+	return crl, nil
+}
+
+// StoreCRL stores the certificate revocation list of the issuer with the given
+// id, replacing the previous one.
+func (db *DB) StoreCRL(issuerID string, crl *CertificateRevocationListInfo) error {
+	b, err := json.Marshal(crl)
+	if err != nil {
+		return errors.Wrap(err, "error marshaling certificate revocation list info")
+	}
+	if err := db.Set(crlTable, []byte(issuerID), b); err != nil {
+		return errors.Wrap(err, "database Set error")
+	}
+	return nil
+}
+
+// GetCertificate retrieves a certificate by the serial number. It returns
+// ErrNotFound if the certificate has not been issued by this CA.
+func (db *DB) GetCertificate(serialNumber string) (*x509.Certificate, error) {
+	asn1Data, err := db.Get(certsTable, []byte(serialNumber))
+	if err != nil {
+		if nosql.IsErrNotFound(err) {
+			return nil, ErrNotFound
+		}
+		return nil, errors.Wrap(err, "database Get error")
+	}
+	cert, err := x509.ParseCertificate(asn1Data)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error parsing certificate with serial number %s", serialNumber)
+	}
+	return cert, nil
+}
+
 // StoreCertificate stores a certificate PEM.
 func (db *DB) StoreCertificate(crt *x509.Certificate) error {
 	if err := db.Set(certsTable, []byte(crt.SerialNumber.String()), crt.Raw); err != nil {
@@ -222,6 +348,27 @@ type sshHostPrincipalData struct {
 	Expiry uint64
 }
 
+// GetSSHCertificate retrieves an SSH certificate by the serial number. It
+// returns ErrNotFound if the certificate has not been issued by this CA.
+func (db *DB) GetSSHCertificate(serial string) (*ssh.Certificate, error) {
+	b, err := db.Get(sshCertsTable, []byte(serial))
+	if err != nil {
+		if nosql.IsErrNotFound(err) {
+			return nil, ErrNotFound
+		}
+		return nil, errors.Wrap(err, "database Get error")
+	}
+	pub, err := ssh.ParsePublicKey(b)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error parsing ssh certificate with serial number %s", serial)
+	}
+	cert, ok := pub.(*ssh.Certificate)
+	if !ok {
+		return nil, errors.Errorf("error parsing ssh certificate with serial number %s: %T is not a certificate", serial, pub)
+	}
+	return cert, nil
+}
+
 // StoreSSHCertificate stores an SSH certificate.
 func (db *DB) StoreSSHCertificate(crt *ssh.Certificate) error {
 	serial := strconv.FormatUint(crt.Serial, 10)
@@ -269,6 +416,44 @@ func (db *DB) GetSSHHostPrincipals() ([]string, error) {
 	return principals, nil
 }
 
+// GetProvisioners returns the JSON representation of the provisioners stored
+// in the database.
+func (db *DB) GetProvisioners() ([]json.RawMessage, error) {
+	entries, err := db.List(provisionersTable)
+	if err != nil {
+		return nil, errors.Wrap(err, "database List error")
+	}
+	provisioners := make([]json.RawMessage, len(entries))
+	for i, e := range entries {
+		provisioners[i] = json.RawMessage(e.Value)
+	}
+	return provisioners, nil
+}
+
+// StoreProvisioner stores the JSON representation of a provisioner, replacing
+// the previous one with the same id.
+func (db *DB) StoreProvisioner(id string, data json.RawMessage) error {
+	if err := db.Set(provisionersTable, []byte(id), data); err != nil {
+		return errors.Wrap(err, "database Set error")
+	}
+	return nil
+}
+
+// DeleteProvisioner deletes the provisioner with the given id. It returns
+// ErrNotFound if the provisioner is not in the database.
+func (db *DB) DeleteProvisioner(id string) error {
+	if _, err := db.Get(provisionersTable, []byte(id)); err != nil {
+		if nosql.IsErrNotFound(err) {
+			return ErrNotFound
+		}
+		return errors.Wrap(err, "database Get error")
+	}
+	if err := db.Del(provisionersTable, []byte(id)); err != nil {
+		return errors.Wrap(err, "database Del error")
+	}
+	return nil
+}
+
 // Shutdown sends a shutdown message to the database.
 func (db *DB) Shutdown() error {
 	if db.isUp {
@@ -282,18 +467,28 @@ func (db *DB) Shutdown() error {
 
 // MockAuthDB mocks the AuthDB interface. //
 type MockAuthDB struct {
-	Err                   error
-	Ret1                  interface{}
-	MIsRevoked            func(string) (bool, error)
-	MIsSSHRevoked         func(string) (bool, error)
-	MRevoke               func(rci *RevokedCertificateInfo) error
-	MRevokeSSH            func(rci *RevokedCertificateInfo) error
-	MStoreCertificate     func(crt *x509.Certificate) error
-	MUseToken             func(id, tok string) (bool, error)
-	MIsSSHHost            func(principal string) (bool, error)
-	MStoreSSHCertificate  func(crt *ssh.Certificate) error
-	MGetSSHHostPrincipals func() ([]string, error)
-	MShutdown             func() error
+	Err                        error
+	Ret1                       interface{}
+	MIsRevoked                 func(string) (bool, error)
+	MIsSSHRevoked              func(string) (bool, error)
+	MRevoke                    func(rci *RevokedCertificateInfo) error
+	MRevokeSSH                 func(rci *RevokedCertificateInfo) error
+	MGetRevokedCertificate     func(sn string) (*RevokedCertificateInfo, error)
+	MGetRevokedCertificates    func() ([]RevokedCertificateInfo, error)
+	MGetRevokedSSHCertificates func() ([]RevokedCertificateInfo, error)
+	MGetCRL                    func(issuerID string) (*CertificateRevocationListInfo, error)
+	MStoreCRL                  func(issuerID string, crl *CertificateRevocationListInfo) error
+	MGetCertificate            func(serialNumber string) (*x509.Certificate, error)
+	MStoreCertificate          func(crt *x509.Certificate) error
+	MUseToken                  func(id, tok string) (bool, error)
+	MIsSSHHost                 func(principal string) (bool, error)
+	MGetSSHCertificate         func(serial string) (*ssh.Certificate, error)
+	MStoreSSHCertificate       func(crt *ssh.Certificate) error
+	MGetSSHHostPrincipals      func() ([]string, error)
+	MGetProvisioners           func() ([]json.RawMessage, error)
+	MStoreProvisioner          func(id string, data json.RawMessage) error
+	MDeleteProvisioner         func(id string) error
+	MShutdown                  func() error
 }
 
 // IsRevoked mock.
@@ -339,6 +534,69 @@ func (m *MockAuthDB) RevokeSSH(rci *RevokedCertificateInfo) error {
 	return m.Err
 }
 
+// GetRevokedCertificate mock.
+func (m *MockAuthDB) GetRevokedCertificate(sn string) (*RevokedCertificateInfo, error) {
+	if m.MGetRevokedCertificate != nil {
+		return m.MGetRevokedCertificate(sn)
+	}
+	if m.Ret1 == nil {
+		return nil, m.Err
+	}
+	return m.Ret1.(*RevokedCertificateInfo), m.Err
+}
+
+// GetRevokedCertificates mock.
+func (m *MockAuthDB) GetRevokedCertificates() ([]RevokedCertificateInfo, error) {
+	if m.MGetRevokedCertificates != nil {
+		return m.MGetRevokedCertificates()
+	}
+	if m.Ret1 == nil {
+		return nil, m.Err
+	}
+	return m.Ret1.([]RevokedCertificateInfo), m.Err
+}
+
+// GetRevokedSSHCertificates mock.
+func (m *MockAuthDB) GetRevokedSSHCertificates() ([]RevokedCertificateInfo, error) {
+	if m.MGetRevokedSSHCertificates != nil {
+		return m.MGetRevokedSSHCertificates()
+	}
+	if m.Ret1 == nil {
+		return nil, m.Err
+	}
+	return m.Ret1.([]RevokedCertificateInfo), m.Err
+}
+
+// GetCRL mock.
+func (m *MockAuthDB) GetCRL(issuerID string) (*CertificateRevocationListInfo, error) {
+	if m.MGetCRL != nil {
+		return m.MGetCRL(issuerID)
+	}
+	if m.Ret1 == nil {
+		return nil, m.Err
+	}
+	return m.Ret1.(*CertificateRevocationListInfo), m.Err
+}
+
+// StoreCRL mock.
+func (m *MockAuthDB) StoreCRL(issuerID string, crl *CertificateRevocationListInfo) error {
+	if m.MStoreCRL != nil {
+		return m.MStoreCRL(issuerID, crl)
+	}
+	return m.Err
+}
+
+// GetCertificate mock.
+func (m *MockAuthDB) GetCertificate(serialNumber string) (*x509.Certificate, error) {
+	if m.MGetCertificate != nil {
+		return m.MGetCertificate(serialNumber)
+	}
+	if m.Ret1 == nil {
+		return nil, m.Err
+	}
+	return m.Ret1.(*x509.Certificate), m.Err
+}
+
 // StoreCertificate mock.
 func (m *MockAuthDB) StoreCertificate(crt *x509.Certificate) error {
 	if m.MStoreCertificate != nil {
@@ -355,6 +613,17 @@ func (m *MockAuthDB) IsSSHHost(principal string) (bool, error) {
 	return m.Ret1.(bool), m.Err
 }
 
+// GetSSHCertificate mock.
+func (m *MockAuthDB) GetSSHCertificate(serial string) (*ssh.Certificate, error) {
+	if m.MGetSSHCertificate != nil {
+		return m.MGetSSHCertificate(serial)
+	}
+	if m.Ret1 == nil {
+		return nil, m.Err
+	}
+	return m.Ret1.(*ssh.Certificate), m.Err
+}
+
 // StoreSSHCertificate mock.
 func (m *MockAuthDB) StoreSSHCertificate(crt *ssh.Certificate) error {
 	if m.MStoreSSHCertificate != nil {
@@ -371,6 +640,33 @@ func (m *MockAuthDB) GetSSHHostPrincipals() ([]string, error) {
 	return m.Ret1.([]string), m.Err
 }
 
+// GetProvisioners mock.
+func (m *MockAuthDB) GetProvisioners() ([]json.RawMessage, error) {
+	if m.MGetProvisioners != nil {
+		return m.MGetProvisioners()
+	}
+	if m.Ret1 == nil {
+		return nil, m.Err
+	}
+	return m.Ret1.([]json.RawMessage), m.Err
+}
+
+// StoreProvisioner mock.
+func (m *MockAuthDB) StoreProvisioner(id string, data json.RawMessage) error {
+	if m.MStoreProvisioner != nil {
+		return m.MStoreProvisioner(id, data)
+	}
+	return m.Err
+}
+
+// DeleteProvisioner mock.
+func (m *MockAuthDB) DeleteProvisioner(id string) error {
+	if m.MDeleteProvisioner != nil {
+		return m.MDeleteProvisioner(id)
+	}
+	return m.Err
+}
+
 // Shutdown mock.
 func (m *MockAuthDB) Shutdown() error {
 	if m.MShutdown != nil {
@@ -470,6 +766,9 @@ func (m *MockNoSQLDB) List(bucket []byte) ([]*database.Entry, error) {
 	if m.MList != nil {
 		return m.MList(bucket)
 	}
+	if m.Ret1 == nil {
+		return nil, m.Err
+	}
 	return m.Ret1.([]*database.Entry), m.Err
 }
 
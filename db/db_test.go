@@ -1,11 +1,16 @@
 package db
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
 	"errors"
 	"testing"
 
 	"github.com/smallstep/assert"
 	"github.com/smallstep/nosql/database"
+	"golang.org/x/crypto/ssh"
 )
 
 func TestIsRevoked(t *testing.T) {
@@ -94,6 +99,312 @@ func TestRevoke(t *testing.T) {
 	}
 }
 
+func TestGetRevokedCertificate(t *testing.T) {
+	rci := &RevokedCertificateInfo{Serial: "sn", ReasonCode: 1, Reason: "key compromise"}
+	b, err := json.Marshal(rci)
+	assert.FatalError(t, err)
+	tests := map[string]struct {
+		db   *DB
+		want *RevokedCertificateInfo
+		err  error
+	}{
+		"fail/not-found": {
+			db:  &DB{&MockNoSQLDB{Err: database.ErrNotFound}, true},
+			err: ErrNotFound,
+		},
+		"fail/get-error": {
+			db:  &DB{&MockNoSQLDB{Err: errors.New("force")}, true},
+			err: errors.New("error checking revocation bucket: force"),
+		},
+		"fail/unmarshal-error": {
+			db:  &DB{&MockNoSQLDB{Ret1: []byte("foo")}, true},
+			err: errors.New("error unmarshaling revoked certificate info"),
+		},
+		"ok": {
+			db:   &DB{&MockNoSQLDB{Ret1: b}, true},
+			want: rci,
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := tc.db.GetRevokedCertificate("sn")
+			if err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+			} else {
+				assert.Nil(t, tc.err)
+				assert.Equals(t, tc.want, got)
+			}
+		})
+	}
+}
+
+func TestGetRevokedCertificates(t *testing.T) {
+	rci := RevokedCertificateInfo{Serial: "sn", ReasonCode: 1, Reason: "key compromise"}
+	b, err := json.Marshal(rci)
+	assert.FatalError(t, err)
+	tests := map[string]struct {
+		db   *DB
+		want []RevokedCertificateInfo
+		err  error
+	}{
+		"fail/list-error": {
+			db:  &DB{&MockNoSQLDB{Err: errors.New("force")}, true},
+			err: errors.New("database List error: force"),
+		},
+		"fail/unmarshal-error": {
+			db: &DB{&MockNoSQLDB{Ret1: []*database.Entry{
+				{Bucket: revokedCertsTable, Key: []byte("sn"), Value: []byte("foo")},
+			}}, true},
+			err: errors.New("error unmarshaling revoked certificate info sn"),
+		},
+		"ok/empty": {
+			db:   &DB{&MockNoSQLDB{Ret1: []*database.Entry{}}, true},
+			want: []RevokedCertificateInfo{},
+		},
+		"ok": {
+			db: &DB{&MockNoSQLDB{Ret1: []*database.Entry{
+				{Bucket: revokedCertsTable, Key: []byte("sn"), Value: b},
+			}}, true},
+			want: []RevokedCertificateInfo{rci},
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := tc.db.GetRevokedCertificates()
+			if err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+			} else {
+				assert.Nil(t, tc.err)
+				assert.Equals(t, tc.want, got)
+			}
+		})
+	}
+}
+
+func TestGetRevokedSSHCertificates(t *testing.T) {
+	rci := RevokedCertificateInfo{Serial: "1234", ReasonCode: 1, Reason: "key compromise", KeyID: "mariano@smallstep.com"}
+	b, err := json.Marshal(rci)
+	assert.FatalError(t, err)
+	tests := map[string]struct {
+		db   *DB
+		want []RevokedCertificateInfo
+		err  error
+	}{
+		"fail/list-error": {
+			db:  &DB{&MockNoSQLDB{Err: errors.New("force")}, true},
+			err: errors.New("database List error: force"),
+		},
+		"fail/unmarshal-error": {
+			db: &DB{&MockNoSQLDB{Ret1: []*database.Entry{
+				{Bucket: revokedSSHCertsTable, Key: []byte("1234"), Value: []byte("foo")},
+			}}, true},
+			err: errors.New("error unmarshaling revoked ssh certificate info 1234"),
+		},
+		"ok/empty": {
+			db:   &DB{&MockNoSQLDB{Ret1: []*database.Entry{}}, true},
+			want: []RevokedCertificateInfo{},
+		},
+		"ok": {
+			db: &DB{&MockNoSQLDB{
+				MList: func(bucket []byte) ([]*database.Entry, error) {
+					assert.Equals(t, revokedSSHCertsTable, bucket)
+					return []*database.Entry{
+						{Bucket: revokedSSHCertsTable, Key: []byte("1234"), Value: b},
+					}, nil
+				},
+			}, true},
+			want: []RevokedCertificateInfo{rci},
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := tc.db.GetRevokedSSHCertificates()
+			if err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+			} else {
+				assert.Nil(t, tc.err)
+				assert.Equals(t, tc.want, got)
+			}
+		})
+	}
+}
+
+func TestGetCRL(t *testing.T) {
+	crl := &CertificateRevocationListInfo{Number: 1, DER: []byte("der")}
+	b, err := json.Marshal(crl)
+	assert.FatalError(t, err)
+	tests := map[string]struct {
+		db   *DB
+		want *CertificateRevocationListInfo
+		err  error
+	}{
+		"fail/not-found": {
+			db:  &DB{&MockNoSQLDB{Err: database.ErrNotFound}, true},
+			err: ErrNotFound,
+		},
+		"fail/get-error": {
+			db:  &DB{&MockNoSQLDB{Err: errors.New("force")}, true},
+			err: errors.New("database Get error: force"),
+		},
+		"fail/unmarshal-error": {
+			db:  &DB{&MockNoSQLDB{Ret1: []byte("foo")}, true},
+			err: errors.New("error unmarshaling certificate revocation list info"),
+		},
+		"ok": {
+			db:   &DB{&MockNoSQLDB{Ret1: b}, true},
+			want: crl,
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := tc.db.GetCRL("id")
+			if err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+			} else {
+				assert.Nil(t, tc.err)
+				assert.Equals(t, tc.want.Number, got.Number)
+				assert.Equals(t, tc.want.DER, got.DER)
+			}
+		})
+	}
+}
+
+func TestStoreCRL(t *testing.T) {
+	tests := map[string]struct {
+		db  *DB
+		err error
+	}{
+		"fail/set-error": {
+			db:  &DB{&MockNoSQLDB{Err: errors.New("force")}, true},
+			err: errors.New("database Set error: force"),
+		},
+		"ok": {
+			db: &DB{&MockNoSQLDB{
+				MSet: func(bucket, key, value []byte) error {
+					assert.Equals(t, crlTable, bucket)
+					assert.Equals(t, []byte("id"), key)
+					return nil
+				},
+			}, true},
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := tc.db.StoreCRL("id", &CertificateRevocationListInfo{Number: 1})
+			if err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+			} else {
+				assert.Nil(t, tc.err)
+			}
+		})
+	}
+}
+
+func TestGetCertificate(t *testing.T) {
+	tests := map[string]struct {
+		db  *DB
+		err error
+	}{
+		"fail/not-found": {
+			db:  &DB{&MockNoSQLDB{Err: database.ErrNotFound}, true},
+			err: ErrNotFound,
+		},
+		"fail/get-error": {
+			db:  &DB{&MockNoSQLDB{Err: errors.New("force")}, true},
+			err: errors.New("database Get error: force"),
+		},
+		"fail/parse-error": {
+			db:  &DB{&MockNoSQLDB{Ret1: []byte("foo")}, true},
+			err: errors.New("error parsing certificate with serial number sn"),
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			crt, err := tc.db.GetCertificate("sn")
+			if err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+			} else {
+				assert.Nil(t, tc.err)
+				assert.NotNil(t, crt)
+			}
+		})
+	}
+}
+
+func TestGetSSHCertificate(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.FatalError(t, err)
+	pub, err := ssh.NewPublicKey(key.Public())
+	assert.FatalError(t, err)
+	signer, err := ssh.NewSignerFromSigner(key)
+	assert.FatalError(t, err)
+	cert := &ssh.Certificate{
+		Key:      pub,
+		Serial:   1234,
+		CertType: ssh.UserCert,
+		KeyId:    "mariano@smallstep.com",
+	}
+	assert.FatalError(t, cert.SignCert(rand.Reader, signer))
+
+	tests := map[string]struct {
+		db   *DB
+		want *ssh.Certificate
+		err  error
+	}{
+		"fail/not-found": {
+			db:  &DB{&MockNoSQLDB{Err: database.ErrNotFound}, true},
+			err: ErrNotFound,
+		},
+		"fail/get-error": {
+			db:  &DB{&MockNoSQLDB{Err: errors.New("force")}, true},
+			err: errors.New("database Get error: force"),
+		},
+		"fail/parse-error": {
+			db:  &DB{&MockNoSQLDB{Ret1: []byte("foo")}, true},
+			err: errors.New("error parsing ssh certificate with serial number 1234"),
+		},
+		"fail/not-certificate": {
+			db:  &DB{&MockNoSQLDB{Ret1: pub.Marshal()}, true},
+			err: errors.New("error parsing ssh certificate with serial number 1234"),
+		},
+		"ok": {
+			db: &DB{&MockNoSQLDB{
+				MGet: func(bucket, key []byte) ([]byte, error) {
+					assert.Equals(t, sshCertsTable, bucket)
+					assert.Equals(t, []byte("1234"), key)
+					return cert.Marshal(), nil
+				},
+			}, true},
+			want: cert,
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := tc.db.GetSSHCertificate("1234")
+			if err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+			} else if assert.Nil(t, tc.err) {
+				assert.Equals(t, tc.want.Marshal(), got.Marshal())
+				assert.Equals(t, "mariano@smallstep.com", got.KeyId)
+			}
+		})
+	}
+}
+
 func TestUseToken(t *testing.T) {
 	type result struct {
 		err error
@@ -158,3 +469,130 @@ func TestUseToken(t *testing.T) {
 		})
 	}
 }
+
+func TestGetProvisioners(t *testing.T) {
+	tests := map[string]struct {
+		db   *DB
+		want []json.RawMessage
+		err  error
+	}{
+		"fail/list-error": {
+			db:  &DB{&MockNoSQLDB{Err: errors.New("force")}, true},
+			err: errors.New("database List error: force"),
+		},
+		"ok/empty": {
+			db:   &DB{&MockNoSQLDB{Ret1: []*database.Entry{}}, true},
+			want: []json.RawMessage{},
+		},
+		"ok": {
+			db: &DB{&MockNoSQLDB{
+				MList: func(bucket []byte) ([]*database.Entry, error) {
+					assert.Equals(t, provisionersTable, bucket)
+					return []*database.Entry{
+						{Bucket: provisionersTable, Key: []byte("foo"), Value: []byte(`{"type":"JWK","name":"foo"}`)},
+						{Bucket: provisionersTable, Key: []byte("bar"), Value: []byte(`{"type":"ACME","name":"bar"}`)},
+					}, nil
+				},
+			}, true},
+			want: []json.RawMessage{
+				json.RawMessage(`{"type":"JWK","name":"foo"}`),
+				json.RawMessage(`{"type":"ACME","name":"bar"}`),
+			},
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := tc.db.GetProvisioners()
+			if err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+			} else {
+				assert.Nil(t, tc.err)
+				assert.Equals(t, tc.want, got)
+			}
+		})
+	}
+}
+
+func TestStoreProvisioner(t *testing.T) {
+	data := json.RawMessage(`{"type":"JWK","name":"foo"}`)
+	tests := map[string]struct {
+		db  *DB
+		err error
+	}{
+		"fail/set-error": {
+			db:  &DB{&MockNoSQLDB{Err: errors.New("force")}, true},
+			err: errors.New("database Set error: force"),
+		},
+		"ok": {
+			db: &DB{&MockNoSQLDB{
+				MSet: func(bucket, key, value []byte) error {
+					assert.Equals(t, provisionersTable, bucket)
+					assert.Equals(t, []byte("foo:kid"), key)
+					assert.Equals(t, []byte(data), value)
+					return nil
+				},
+			}, true},
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := tc.db.StoreProvisioner("foo:kid", data)
+			if err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+			} else {
+				assert.Nil(t, tc.err)
+			}
+		})
+	}
+}
+
+func TestDeleteProvisioner(t *testing.T) {
+	tests := map[string]struct {
+		db  *DB
+		err error
+	}{
+		"fail/not-found": {
+			db:  &DB{&MockNoSQLDB{Err: database.ErrNotFound}, true},
+			err: ErrNotFound,
+		},
+		"fail/get-error": {
+			db:  &DB{&MockNoSQLDB{Err: errors.New("force")}, true},
+			err: errors.New("database Get error: force"),
+		},
+		"fail/del-error": {
+			db: &DB{&MockNoSQLDB{
+				Ret1: []byte("{}"),
+				MDel: func(bucket, key []byte) error {
+					return errors.New("force")
+				},
+			}, true},
+			err: errors.New("database Del error: force"),
+		},
+		"ok": {
+			db: &DB{&MockNoSQLDB{
+				Ret1: []byte("{}"),
+				MDel: func(bucket, key []byte) error {
+					assert.Equals(t, provisionersTable, bucket)
+					assert.Equals(t, []byte("foo:kid"), key)
+					return nil
+				},
+			}, true},
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := tc.db.DeleteProvisioner("foo:kid")
+			if err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+			} else {
+				assert.Nil(t, tc.err)
+			}
+		})
+	}
+}
@@ -2,6 +2,7 @@ package db
 
 import (
 	"crypto/x509"
+	"encoding/json"
 	"sync"
 	"time"
 
@@ -46,6 +47,52 @@ func (s *SimpleDB) RevokeSSH(rci *RevokedCertificateInfo) error {
 	return ErrNotImplemented
 }
 
+// GetRevokedCertificate returns a "NotImplemented" error.
+func (s *SimpleDB) GetRevokedCertificate(sn string) (*RevokedCertificateInfo, error) {
+	return nil, ErrNotImplemented
+}
+
+// GetRevokedCertificates returns a "NotImplemented" error.
+func (s *SimpleDB) GetRevokedCertificates() ([]RevokedCertificateInfo, error) {
+	return nil, ErrNotImplemented
+}
+
+// GetRevokedSSHCertificates returns a "NotImplemented" error.
+func (s *SimpleDB) GetRevokedSSHCertificates() ([]RevokedCertificateInfo, error) {
+	return nil, ErrNotImplemented
+}
+
+// GetCRL returns a "NotImplemented" error.
+func (s *SimpleDB) GetCRL(issuerID string) (*CertificateRevocationListInfo, error) {
+	return nil, ErrNotImplemented
+}
+
+// StoreCRL returns a "NotImplemented" error.
+func (s *SimpleDB) StoreCRL(issuerID string, crl *CertificateRevocationListInfo) error {
+	return ErrNotImplemented
+}
+
+// GetProvisioners returns an empty list, provisioners cannot be stored in a
+// SimpleDB.
+func (s *SimpleDB) GetProvisioners() ([]json.RawMessage, error) {
+	return nil, nil
+}
+
+// StoreProvisioner returns a "NotImplemented" error.
+func (s *SimpleDB) StoreProvisioner(id string, data json.RawMessage) error {
+	return ErrNotImplemented
+}
+
+// DeleteProvisioner returns a "NotImplemented" error.
+func (s *SimpleDB) DeleteProvisioner(id string) error {
+	return ErrNotImplemented
+}
+
+// GetCertificate returns a "NotImplemented" error.
+func (s *SimpleDB) GetCertificate(serialNumber string) (*x509.Certificate, error) {
+	return nil, ErrNotImplemented
+}
+
 // StoreCertificate returns a "NotImplemented" error.
 func (s *SimpleDB) StoreCertificate(crt *x509.Certificate) error {
 	return ErrNotImplemented
@@ -74,6 +121,11 @@ func (s *SimpleDB) IsSSHHost(principal string) (bool, error) {
 	return false, ErrNotImplemented
 }
 
+// GetSSHCertificate returns a "NotImplemented" error.
+func (s *SimpleDB) GetSSHCertificate(serial string) (*ssh.Certificate, error) {
+	return nil, ErrNotImplemented
+}
+
 // StoreSSHCertificate returns a "NotImplemented" error.
 func (s *SimpleDB) StoreSSHCertificate(crt *ssh.Certificate) error {
 	return ErrNotImplemented
@@ -18,9 +18,36 @@ func TestSimpleDB(t *testing.T) {
 	assert.False(t, isRevoked)
 	assert.Nil(t, err)
 
+	// GetRevokedCertificate
+	_, err = db.GetRevokedCertificate("foo")
+	assert.Equals(t, ErrNotImplemented, err)
+
+	// GetRevokedCertificates
+	_, err = db.GetRevokedCertificates()
+	assert.Equals(t, ErrNotImplemented, err)
+
+	// GetRevokedSSHCertificates
+	_, err = db.GetRevokedSSHCertificates()
+	assert.Equals(t, ErrNotImplemented, err)
+
+	// GetCRL
+	_, err = db.GetCRL("id")
+	assert.Equals(t, ErrNotImplemented, err)
+
+	// StoreCRL
+	assert.Equals(t, ErrNotImplemented, db.StoreCRL("id", nil))
+
+	// GetCertificate
+	_, err = db.GetCertificate("foo")
+	assert.Equals(t, ErrNotImplemented, err)
+
 	// StoreCertificate
 	assert.Equals(t, ErrNotImplemented, db.StoreCertificate(nil))
 
+	// GetSSHCertificate
+	_, err = db.GetSSHCertificate("foo")
+	assert.Equals(t, ErrNotImplemented, err)
+
 	// UseToken
 	ok, err := db.UseToken("foo", "bar")
 	assert.True(t, ok)
@@ -32,20 +32,53 @@ const (
 	AmazonKMS Type = "awskms"
 	// PKCS11 is a KMS implementation using the PKCS11 standard.
 	PKCS11 Type = "pkcs11"
+	// VaultTransit is a KMS implementation using HashiCorp Vault's transit
+	// secrets engine.
+	VaultTransit Type = "vaulttransit"
 )
 
 type Options struct {
 	// The type of the KMS to use.
 	Type string `json:"type"`
 
-	// Path to the credentials file used in CloudKMS.
+	// Path to the credentials file used in CloudKMS and AmazonKMS.
 	CredentialsFile string `json:"credentialsFile"`
 
 	// Path to the module used with PKCS11 KMS.
 	Module string `json:"module"`
 
+	// URI is a PKCS #11 URI used with PKCS11 KMS to select the module, the
+	// token and the pin, e.g.
+	//   pkcs11:module-path=/usr/local/lib/softhsm/libsofthsm2.so;token=smallstep?pin-value=password
+	URI string `json:"uri,omitempty"`
+
 	// Pin used to access the PKCS11 module.
 	Pin string `json:"pin"`
+
+	// Address of the Vault server used with VaultTransit KMS, defaults to the
+	// VAULT_ADDR environment variable.
+	Address string `json:"address,omitempty"`
+
+	// Mount path of the transit secrets engine used with VaultTransit KMS,
+	// defaults to transit.
+	Mount string `json:"mount,omitempty"`
+
+	// Token used to authenticate with Vault, defaults to the VAULT_TOKEN
+	// environment variable.
+	Token string `json:"token,omitempty"`
+
+	// RoleID and SecretID used to authenticate with Vault using AppRole.
+	RoleID   string `json:"roleID,omitempty"`
+	SecretID string `json:"secretID,omitempty"`
+
+	// Path to the PEM bundle used to verify the Vault server, defaults to the
+	// VAULT_CACERT environment variable.
+	CACertFile string `json:"caCertFile,omitempty"`
+
+	// Region and Profile used with AmazonKMS, they default to the ones in the
+	// AWS environment variables and shared configuration.
+	Region  string `json:"region,omitempty"`
+	Profile string `json:"profile,omitempty"`
 }
 
 // Validate checks the fields in Options.
@@ -55,11 +88,7 @@ func (o *Options) Validate() error {
 	}
 
 	switch Type(strings.ToLower(o.Type)) {
-	case DefaultKMS, SoftKMS, CloudKMS:
-	case AmazonKMS:
-		return ErrNotImplemented{"support for AmazonKMS is not yet implemented"}
-	case PKCS11:
-		return ErrNotImplemented{"support for PKCS11 is not yet implemented"}
+	case DefaultKMS, SoftKMS, CloudKMS, AmazonKMS, VaultTransit, PKCS11:
 	default:
 		return errors.Errorf("unsupported kms type %s", o.Type)
 	}
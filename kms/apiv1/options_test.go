@@ -13,8 +13,9 @@ func TestOptions_Validate(t *testing.T) {
 		{"nil", nil, false},
 		{"softkms", &Options{Type: "softkms"}, false},
 		{"cloudkms", &Options{Type: "cloudkms"}, false},
-		{"awskms", &Options{Type: "awskms"}, true},
-		{"pkcs11", &Options{Type: "pkcs11"}, true},
+		{"vaulttransit", &Options{Type: "vaulttransit"}, false},
+		{"awskms", &Options{Type: "awskms"}, false},
+		{"pkcs11", &Options{Type: "pkcs11"}, false},
 		{"unsupported", &Options{Type: "unsupported"}, true},
 	}
 	for _, tt := range tests {
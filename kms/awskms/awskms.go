@@ -0,0 +1,274 @@
+package awskms
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/kms/apiv1"
+	"github.com/smallstep/certificates/kms/uri"
+)
+
+// Scheme is the scheme used in the AWS KMS key names, e.g.
+// awskms:key-id=1234abcd-12ab-34cd-56ef-1234567890ab.
+const Scheme = "awskms"
+
+// customerMasterKeySpecMapping is a mapping between the step signature
+// algorithm, and bits for RSA keys, with the AWS KMS key spec.
+//
+// AWS KMS does not support PureEd25519.
+var customerMasterKeySpecMapping = map[apiv1.SignatureAlgorithm]interface{}{
+	apiv1.UnspecifiedSignAlgorithm: kms.CustomerMasterKeySpecEccNistP256,
+	apiv1.SHA256WithRSA:            rsaKeySpecs,
+	apiv1.SHA384WithRSA:            rsaKeySpecs,
+	apiv1.SHA512WithRSA:            rsaKeySpecs,
+	apiv1.SHA256WithRSAPSS:         rsaKeySpecs,
+	apiv1.SHA384WithRSAPSS:         rsaKeySpecs,
+	apiv1.SHA512WithRSAPSS:         rsaKeySpecs,
+	apiv1.ECDSAWithSHA256:          kms.CustomerMasterKeySpecEccNistP256,
+	apiv1.ECDSAWithSHA384:          kms.CustomerMasterKeySpecEccNistP384,
+	apiv1.ECDSAWithSHA512:          kms.CustomerMasterKeySpecEccNistP521,
+}
+
+var rsaKeySpecs = map[int]string{
+	0:    kms.CustomerMasterKeySpecRsa3072,
+	2048: kms.CustomerMasterKeySpecRsa2048,
+	3072: kms.CustomerMasterKeySpecRsa3072,
+	4096: kms.CustomerMasterKeySpecRsa4096,
+}
+
+// KeyManagementClient defines the methods on the AWS KMS client that this
+// package will use. This interface will be used for unit testing.
+type KeyManagementClient interface {
+	GetPublicKeyWithContext(ctx aws.Context, input *kms.GetPublicKeyInput, opts ...request.Option) (*kms.GetPublicKeyOutput, error)
+	CreateKeyWithContext(ctx aws.Context, input *kms.CreateKeyInput, opts ...request.Option) (*kms.CreateKeyOutput, error)
+	CreateAliasWithContext(ctx aws.Context, input *kms.CreateAliasInput, opts ...request.Option) (*kms.CreateAliasOutput, error)
+	UpdateAliasWithContext(ctx aws.Context, input *kms.UpdateAliasInput, opts ...request.Option) (*kms.UpdateAliasOutput, error)
+	SignWithContext(ctx aws.Context, input *kms.SignInput, opts ...request.Option) (*kms.SignOutput, error)
+}
+
+// KMS implements a KMS using AWS Key Management Service.
+type KMS struct {
+	service KeyManagementClient
+}
+
+// New creates a new AWS KMS client. The credentials are loaded from the
+// default credential chain, or from the given credentials file if set.
+func New(ctx context.Context, opts apiv1.Options) (*KMS, error) {
+	o := session.Options{}
+	if opts.Region != "" {
+		o.Config.Region = aws.String(opts.Region)
+	}
+	if opts.Profile != "" {
+		o.SharedConfigState = session.SharedConfigEnable
+		o.Profile = opts.Profile
+	}
+	if opts.CredentialsFile != "" {
+		o.SharedConfigState = session.SharedConfigEnable
+		o.SharedConfigFiles = append(o.SharedConfigFiles, opts.CredentialsFile)
+	}
+
+	sess, err := session.NewSessionWithOptions(o)
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating AWS session")
+	}
+
+	return &KMS{
+		service: kms.New(sess),
+	}, nil
+}
+
+// NewAWSKMS creates a KMS with a given client.
+func NewAWSKMS(client KeyManagementClient) *KMS {
+	return &KMS{
+		service: client,
+	}
+}
+
+// GetPublicKey returns the public key of the given AWS KMS key. Key names can
+// be a key id, a key ARN, an alias name prefixed with "alias/", an alias ARN,
+// or an uri like awskms:key-id=1234abcd-12ab-34cd-56ef-1234567890ab.
+func (k *KMS) GetPublicKey(req *apiv1.GetPublicKeyRequest) (crypto.PublicKey, error) {
+	if req.Name == "" {
+		return nil, errors.New("getPublicKey 'name' cannot be empty")
+	}
+
+	keyID, err := parseKeyID(req.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	return getPublicKey(k.service, keyID)
+}
+
+// CreateKey creates in AWS KMS a new asymmetric key for signing, and an alias
+// with the given name pointing to it. If the alias already exists it will be
+// updated to point to the new key. The name in the response is the uri of the
+// new key.
+func (k *KMS) CreateKey(req *apiv1.CreateKeyRequest) (*apiv1.CreateKeyResponse, error) {
+	if req.Name == "" {
+		return nil, errors.New("createKeyRequest 'name' cannot be empty")
+	}
+
+	// AWS KMS keys are always generated and stored in HSMs.
+	switch req.ProtectionLevel {
+	case apiv1.UnspecifiedProtectionLevel, apiv1.HSM:
+	default:
+		return nil, errors.Errorf("awsKMS does not support protection level '%s'", req.ProtectionLevel)
+	}
+
+	keySpec, err := getCustomerMasterKeySpec(req.SignatureAlgorithm, req.Bits)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := defaultContext()
+	defer cancel()
+
+	response, err := k.service.CreateKeyWithContext(ctx, &kms.CreateKeyInput{
+		Description:           aws.String(req.Name),
+		CustomerMasterKeySpec: aws.String(keySpec),
+		KeyUsage:              aws.String(kms.KeyUsageTypeSignVerify),
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "awsKMS CreateKeyWithContext failed")
+	}
+	keyID := aws.StringValue(response.KeyMetadata.KeyId)
+
+	if err := k.createOrUpdateAlias(aliasName(req.Name), keyID); err != nil {
+		return nil, err
+	}
+
+	name := uri.New(Scheme, url.Values{"key-id": []string{keyID}}).String()
+	publicKey, err := getPublicKey(k.service, keyID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &apiv1.CreateKeyResponse{
+		Name:      name,
+		PublicKey: publicKey,
+		CreateSignerRequest: apiv1.CreateSignerRequest{
+			SigningKey: name,
+		},
+	}, nil
+}
+
+func (k *KMS) createOrUpdateAlias(alias, keyID string) error {
+	ctx, cancel := defaultContext()
+	defer cancel()
+
+	_, err := k.service.CreateAliasWithContext(ctx, &kms.CreateAliasInput{
+		AliasName:   aws.String(alias),
+		TargetKeyId: aws.String(keyID),
+	})
+	if err == nil {
+		return nil
+	}
+	if aerr, ok := err.(awserr.Error); !ok || aerr.Code() != kms.ErrCodeAlreadyExistsException {
+		return errors.Wrap(err, "awsKMS CreateAliasWithContext failed")
+	}
+
+	// Point the existing alias to the new key.
+	if _, err := k.service.UpdateAliasWithContext(ctx, &kms.UpdateAliasInput{
+		AliasName:   aws.String(alias),
+		TargetKeyId: aws.String(keyID),
+	}); err != nil {
+		return errors.Wrap(err, "awsKMS UpdateAliasWithContext failed")
+	}
+	return nil
+}
+
+// CreateSigner creates a new crypto.Signer with the given AWS KMS key.
+func (k *KMS) CreateSigner(req *apiv1.CreateSignerRequest) (crypto.Signer, error) {
+	if req.SigningKey == "" {
+		return nil, errors.New("createSigner 'signingKey' cannot be empty")
+	}
+
+	keyID, err := parseKeyID(req.SigningKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewSigner(k.service, keyID)
+}
+
+// Close is a noop, there is no connection to close.
+func (k *KMS) Close() error {
+	return nil
+}
+
+func getPublicKey(svc KeyManagementClient, keyID string) (crypto.PublicKey, error) {
+	ctx, cancel := defaultContext()
+	defer cancel()
+
+	response, err := svc.GetPublicKeyWithContext(ctx, &kms.GetPublicKeyInput{
+		KeyId: aws.String(keyID),
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "awsKMS GetPublicKeyWithContext failed")
+	}
+
+	pub, err := x509.ParsePKIXPublicKey(response.PublicKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "error parsing public key")
+	}
+	return pub, nil
+}
+
+func getCustomerMasterKeySpec(alg apiv1.SignatureAlgorithm, bits int) (string, error) {
+	v, ok := customerMasterKeySpecMapping[alg]
+	if !ok {
+		return "", errors.Errorf("awsKMS does not support signature algorithm '%s'", alg)
+	}
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case map[int]string:
+		keySpec, ok := v[bits]
+		if !ok {
+			return "", errors.Errorf("awsKMS does not support signature algorithm '%s' with '%d' bits", alg, bits)
+		}
+		return keySpec, nil
+	default:
+		return "", errors.Errorf("unexpected error: this should not happen")
+	}
+}
+
+// parseKeyID extracts the key-id from an uri, or returns the given name if it
+// is not an uri.
+func parseKeyID(name string) (string, error) {
+	if !uri.HasScheme(Scheme, name) {
+		return name, nil
+	}
+	u, err := uri.ParseWithScheme(Scheme, name)
+	if err != nil {
+		return "", err
+	}
+	keyID := u.Get("key-id")
+	if keyID == "" {
+		return "", errors.Errorf("key %s does not have the 'key-id' attribute", name)
+	}
+	return keyID, nil
+}
+
+// aliasName returns the name prefixed with "alias/" if necessary.
+func aliasName(name string) string {
+	if strings.HasPrefix(name, "alias/") {
+		return name
+	}
+	return "alias/" + name
+}
+
+func defaultContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), 15*time.Second)
+}
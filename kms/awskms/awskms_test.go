@@ -0,0 +1,299 @@
+package awskms
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/smallstep/certificates/kms/apiv1"
+)
+
+const testKeyID = "be468355-ca7a-40d9-a28b-8ae1c4c7f936"
+
+func mustSigner(t *testing.T) crypto.Signer {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func mustPublicKeyDER(t *testing.T, pub crypto.PublicKey) []byte {
+	t.Helper()
+	b, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func getPublicKeyWithContext(der []byte, err error) func(aws.Context, *kms.GetPublicKeyInput, ...request.Option) (*kms.GetPublicKeyOutput, error) {
+	return func(_ aws.Context, input *kms.GetPublicKeyInput, _ ...request.Option) (*kms.GetPublicKeyOutput, error) {
+		if err != nil {
+			return nil, err
+		}
+		return &kms.GetPublicKeyOutput{
+			KeyId:     input.KeyId,
+			PublicKey: der,
+		}, nil
+	}
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    apiv1.Options
+		wantErr bool
+	}{
+		{"ok", apiv1.Options{Type: "awskms"}, false},
+		{"ok with region", apiv1.Options{Type: "awskms", Region: "us-west-2"}, false},
+		{"ok with profile", apiv1.Options{Type: "awskms", Region: "us-west-2", Profile: "smallstep", CredentialsFile: "testdata/credentials"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := New(context.Background(), tt.opts)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("New() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err == nil && (got == nil || got.service == nil) {
+				t.Errorf("New() = %v, want a KMS with a client", got)
+			}
+		})
+	}
+}
+
+func TestNewAWSKMS(t *testing.T) {
+	tests := []struct {
+		name   string
+		client KeyManagementClient
+		want   *KMS
+	}{
+		{"ok", &MockClient{}, &KMS{service: &MockClient{}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NewAWSKMS(tt.client); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("NewAWSKMS() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKMS_GetPublicKey(t *testing.T) {
+	signer := mustSigner(t)
+	der := mustPublicKeyDER(t, signer.Public())
+
+	tests := []struct {
+		name    string
+		client  KeyManagementClient
+		keyName string
+		want    crypto.PublicKey
+		wantErr bool
+	}{
+		{"ok", &MockClient{getPublicKeyWithContext: getPublicKeyWithContext(der, nil)}, "awskms:key-id=" + testKeyID, signer.Public(), false},
+		{"ok key id", &MockClient{getPublicKeyWithContext: getPublicKeyWithContext(der, nil)}, testKeyID, signer.Public(), false},
+		{"ok alias", &MockClient{getPublicKeyWithContext: getPublicKeyWithContext(der, nil)}, "alias/intermediate", signer.Public(), false},
+		{"ok arn", &MockClient{getPublicKeyWithContext: getPublicKeyWithContext(der, nil)}, "arn:aws:kms:us-west-2:111122223333:key/" + testKeyID, signer.Public(), false},
+		{"fail empty", &MockClient{}, "", nil, true},
+		{"fail uri", &MockClient{}, "awskms:alias=intermediate", nil, true},
+		{"fail get public key", &MockClient{getPublicKeyWithContext: getPublicKeyWithContext(nil, fmt.Errorf("an error"))}, testKeyID, nil, true},
+		{"fail parse", &MockClient{getPublicKeyWithContext: getPublicKeyWithContext([]byte("bad der"), nil)}, testKeyID, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := &KMS{service: tt.client}
+			got, err := k.GetPublicKey(&apiv1.GetPublicKeyRequest{Name: tt.keyName})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("KMS.GetPublicKey() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("KMS.GetPublicKey() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKMS_CreateKey(t *testing.T) {
+	signer := mustSigner(t)
+	der := mustPublicKeyDER(t, signer.Public())
+
+	var keySpec, aliasName, updatedAlias string
+	createKey := func(_ aws.Context, input *kms.CreateKeyInput, _ ...request.Option) (*kms.CreateKeyOutput, error) {
+		if aws.StringValue(input.KeyUsage) != kms.KeyUsageTypeSignVerify {
+			return nil, fmt.Errorf("unexpected key usage %s", aws.StringValue(input.KeyUsage))
+		}
+		keySpec = aws.StringValue(input.CustomerMasterKeySpec)
+		return &kms.CreateKeyOutput{
+			KeyMetadata: &kms.KeyMetadata{KeyId: aws.String(testKeyID)},
+		}, nil
+	}
+	createAlias := func(_ aws.Context, input *kms.CreateAliasInput, _ ...request.Option) (*kms.CreateAliasOutput, error) {
+		if aws.StringValue(input.TargetKeyId) != testKeyID {
+			return nil, fmt.Errorf("unexpected target key id %s", aws.StringValue(input.TargetKeyId))
+		}
+		aliasName = aws.StringValue(input.AliasName)
+		return &kms.CreateAliasOutput{}, nil
+	}
+	aliasExists := func(_ aws.Context, input *kms.CreateAliasInput, _ ...request.Option) (*kms.CreateAliasOutput, error) {
+		aliasName = aws.StringValue(input.AliasName)
+		return nil, awserr.New(kms.ErrCodeAlreadyExistsException, "alias already exists", nil)
+	}
+	updateAlias := func(_ aws.Context, input *kms.UpdateAliasInput, _ ...request.Option) (*kms.UpdateAliasOutput, error) {
+		if aws.StringValue(input.TargetKeyId) != testKeyID {
+			return nil, fmt.Errorf("unexpected target key id %s", aws.StringValue(input.TargetKeyId))
+		}
+		updatedAlias = aws.StringValue(input.AliasName)
+		return &kms.UpdateAliasOutput{}, nil
+	}
+	fail := func(_ aws.Context, _ *kms.CreateKeyInput, _ ...request.Option) (*kms.CreateKeyOutput, error) {
+		return nil, fmt.Errorf("an error")
+	}
+	failAlias := func(_ aws.Context, _ *kms.CreateAliasInput, _ ...request.Option) (*kms.CreateAliasOutput, error) {
+		return nil, fmt.Errorf("an error")
+	}
+	failUpdateAlias := func(_ aws.Context, _ *kms.UpdateAliasInput, _ ...request.Option) (*kms.UpdateAliasOutput, error) {
+		return nil, fmt.Errorf("an error")
+	}
+
+	client := &MockClient{
+		getPublicKeyWithContext: getPublicKeyWithContext(der, nil),
+		createKeyWithContext:    createKey,
+		createAliasWithContext:  createAlias,
+		updateAliasWithContext:  updateAlias,
+	}
+	keyName := "awskms:key-id=" + testKeyID
+	want := &apiv1.CreateKeyResponse{
+		Name:      keyName,
+		PublicKey: signer.Public(),
+		CreateSignerRequest: apiv1.CreateSignerRequest{
+			SigningKey: keyName,
+		},
+	}
+
+	tests := []struct {
+		name        string
+		client      KeyManagementClient
+		req         *apiv1.CreateKeyRequest
+		want        *apiv1.CreateKeyResponse
+		wantKeySpec string
+		wantAlias   string
+		wantUpdate  string
+		wantErr     bool
+	}{
+		{"ok default", client, &apiv1.CreateKeyRequest{Name: "root"}, want, "ECC_NIST_P256", "alias/root", "", false},
+		{"ok p256", client, &apiv1.CreateKeyRequest{Name: "intermediate", SignatureAlgorithm: apiv1.ECDSAWithSHA256}, want, "ECC_NIST_P256", "alias/intermediate", "", false},
+		{"ok p384", client, &apiv1.CreateKeyRequest{Name: "intermediate", SignatureAlgorithm: apiv1.ECDSAWithSHA384, ProtectionLevel: apiv1.HSM}, want, "ECC_NIST_P384", "alias/intermediate", "", false},
+		{"ok p521", client, &apiv1.CreateKeyRequest{Name: "alias/intermediate", SignatureAlgorithm: apiv1.ECDSAWithSHA512}, want, "ECC_NIST_P521", "alias/intermediate", "", false},
+		{"ok rsa", client, &apiv1.CreateKeyRequest{Name: "ssh-user-key", SignatureAlgorithm: apiv1.SHA256WithRSA}, want, "RSA_3072", "alias/ssh-user-key", "", false},
+		{"ok rsa 2048", client, &apiv1.CreateKeyRequest{Name: "ssh-user-key", SignatureAlgorithm: apiv1.SHA256WithRSA, Bits: 2048}, want, "RSA_2048", "alias/ssh-user-key", "", false},
+		{"ok rsa-pss 4096", client, &apiv1.CreateKeyRequest{Name: "ssh-host-key", SignatureAlgorithm: apiv1.SHA512WithRSAPSS, Bits: 4096}, want, "RSA_4096", "alias/ssh-host-key", "", false},
+		{"ok update alias", &MockClient{
+			getPublicKeyWithContext: getPublicKeyWithContext(der, nil),
+			createKeyWithContext:    createKey,
+			createAliasWithContext:  aliasExists,
+			updateAliasWithContext:  updateAlias,
+		}, &apiv1.CreateKeyRequest{Name: "intermediate"}, want, "ECC_NIST_P256", "alias/intermediate", "alias/intermediate", false},
+		{"fail name", client, &apiv1.CreateKeyRequest{}, nil, "", "", "", true},
+		{"fail protection level", client, &apiv1.CreateKeyRequest{Name: "root", ProtectionLevel: apiv1.Software}, nil, "", "", "", true},
+		{"fail signature algorithm", client, &apiv1.CreateKeyRequest{Name: "root", SignatureAlgorithm: apiv1.PureEd25519}, nil, "", "", "", true},
+		{"fail bits", client, &apiv1.CreateKeyRequest{Name: "root", SignatureAlgorithm: apiv1.SHA256WithRSA, Bits: 1024}, nil, "", "", "", true},
+		{"fail create key", &MockClient{
+			createKeyWithContext: fail,
+		}, &apiv1.CreateKeyRequest{Name: "root"}, nil, "", "", "", true},
+		{"fail create alias", &MockClient{
+			createKeyWithContext:   createKey,
+			createAliasWithContext: failAlias,
+		}, &apiv1.CreateKeyRequest{Name: "root"}, nil, "ECC_NIST_P256", "", "", true},
+		{"fail update alias", &MockClient{
+			createKeyWithContext:   createKey,
+			createAliasWithContext: aliasExists,
+			updateAliasWithContext: failUpdateAlias,
+		}, &apiv1.CreateKeyRequest{Name: "root"}, nil, "ECC_NIST_P256", "alias/root", "", true},
+		{"fail get public key", &MockClient{
+			getPublicKeyWithContext: getPublicKeyWithContext(nil, fmt.Errorf("an error")),
+			createKeyWithContext:    createKey,
+			createAliasWithContext:  createAlias,
+		}, &apiv1.CreateKeyRequest{Name: "root"}, nil, "ECC_NIST_P256", "alias/root", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keySpec, aliasName, updatedAlias = "", "", ""
+			k := &KMS{service: tt.client}
+			got, err := k.CreateKey(tt.req)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("KMS.CreateKey() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("KMS.CreateKey() = %v, want %v", got, tt.want)
+			}
+			if keySpec != tt.wantKeySpec {
+				t.Errorf("KMS.CreateKey() key spec = %v, want %v", keySpec, tt.wantKeySpec)
+			}
+			if aliasName != tt.wantAlias {
+				t.Errorf("KMS.CreateKey() alias = %v, want %v", aliasName, tt.wantAlias)
+			}
+			if updatedAlias != tt.wantUpdate {
+				t.Errorf("KMS.CreateKey() updated alias = %v, want %v", updatedAlias, tt.wantUpdate)
+			}
+		})
+	}
+}
+
+func TestKMS_CreateSigner(t *testing.T) {
+	signer := mustSigner(t)
+	der := mustPublicKeyDER(t, signer.Public())
+	client := &MockClient{getPublicKeyWithContext: getPublicKeyWithContext(der, nil)}
+
+	tests := []struct {
+		name       string
+		client     KeyManagementClient
+		signingKey string
+		want       crypto.Signer
+		wantErr    bool
+	}{
+		{"ok", client, "awskms:key-id=" + testKeyID, &Signer{service: client, keyID: testKeyID, publicKey: signer.Public()}, false},
+		{"ok alias", client, "alias/intermediate", &Signer{service: client, keyID: "alias/intermediate", publicKey: signer.Public()}, false},
+		{"fail empty", client, "", nil, true},
+		{"fail uri", client, "awskms:key-id", nil, true},
+		{"fail get public key", &MockClient{
+			getPublicKeyWithContext: getPublicKeyWithContext(nil, fmt.Errorf("an error")),
+		}, testKeyID, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := &KMS{service: tt.client}
+			got, err := k.CreateSigner(&apiv1.CreateSignerRequest{SigningKey: tt.signingKey})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("KMS.CreateSigner() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("KMS.CreateSigner() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKMS_Close(t *testing.T) {
+	k := &KMS{service: &MockClient{}}
+	if err := k.Close(); err != nil {
+		t.Errorf("KMS.Close() error = %v", err)
+	}
+}
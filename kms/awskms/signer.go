@@ -0,0 +1,102 @@
+package awskms
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"io"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/pkg/errors"
+)
+
+// Signer implements a crypto.Signer using the AWS KMS.
+type Signer struct {
+	service   KeyManagementClient
+	keyID     string
+	publicKey crypto.PublicKey
+}
+
+// NewSigner creates a new signer using a key in the AWS KMS. The public key is
+// retrieved on creation.
+func NewSigner(svc KeyManagementClient, keyID string) (*Signer, error) {
+	publicKey, err := getPublicKey(svc, keyID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Signer{
+		service:   svc,
+		keyID:     keyID,
+		publicKey: publicKey,
+	}, nil
+}
+
+// Public returns the public key of this signer.
+func (s *Signer) Public() crypto.PublicKey {
+	return s.publicKey
+}
+
+// Sign signs the given digest using the AWS KMS. AWS KMS signs the digest, so
+// the message type is always DIGEST.
+func (s *Signer) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	alg, err := getSigningAlgorithm(s.Public(), opts)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := defaultContext()
+	defer cancel()
+
+	resp, err := s.service.SignWithContext(ctx, &kms.SignInput{
+		KeyId:            aws.String(s.keyID),
+		SigningAlgorithm: aws.String(alg),
+		Message:          digest,
+		MessageType:      aws.String(kms.MessageTypeDigest),
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "awsKMS SignWithContext failed")
+	}
+
+	return resp.Signature, nil
+}
+
+func getSigningAlgorithm(key crypto.PublicKey, opts crypto.SignerOpts) (string, error) {
+	switch key.(type) {
+	case *rsa.PublicKey:
+		_, isPSS := opts.(*rsa.PSSOptions)
+		switch h := opts.HashFunc(); h {
+		case crypto.SHA256:
+			if isPSS {
+				return kms.SigningAlgorithmSpecRsassaPssSha256, nil
+			}
+			return kms.SigningAlgorithmSpecRsassaPkcs1V15Sha256, nil
+		case crypto.SHA384:
+			if isPSS {
+				return kms.SigningAlgorithmSpecRsassaPssSha384, nil
+			}
+			return kms.SigningAlgorithmSpecRsassaPkcs1V15Sha384, nil
+		case crypto.SHA512:
+			if isPSS {
+				return kms.SigningAlgorithmSpecRsassaPssSha512, nil
+			}
+			return kms.SigningAlgorithmSpecRsassaPkcs1V15Sha512, nil
+		default:
+			return "", errors.Errorf("unsupported hash function %v", h)
+		}
+	case *ecdsa.PublicKey:
+		switch h := opts.HashFunc(); h {
+		case crypto.SHA256:
+			return kms.SigningAlgorithmSpecEcdsaSha256, nil
+		case crypto.SHA384:
+			return kms.SigningAlgorithmSpecEcdsaSha384, nil
+		case crypto.SHA512:
+			return kms.SigningAlgorithmSpecEcdsaSha512, nil
+		default:
+			return "", errors.Errorf("unsupported hash function %v", h)
+		}
+	default:
+		return "", errors.Errorf("unsupported key type %T", key)
+	}
+}
@@ -0,0 +1,228 @@
+package awskms
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"golang.org/x/crypto/ssh"
+)
+
+// signWithContext returns a mock SignWithContext that signs with the given key
+// after checking the request.
+func signWithContext(t *testing.T, key crypto.Signer, wantAlgorithm string) func(aws.Context, *kms.SignInput, ...request.Option) (*kms.SignOutput, error) {
+	return func(_ aws.Context, input *kms.SignInput, _ ...request.Option) (*kms.SignOutput, error) {
+		if v := aws.StringValue(input.KeyId); v != testKeyID {
+			return nil, fmt.Errorf("unexpected key id %s", v)
+		}
+		if v := aws.StringValue(input.MessageType); v != kms.MessageTypeDigest {
+			return nil, fmt.Errorf("unexpected message type %s", v)
+		}
+		alg := aws.StringValue(input.SigningAlgorithm)
+		if alg != wantAlgorithm {
+			t.Errorf("SignInput.SigningAlgorithm = %s, want %s", alg, wantAlgorithm)
+		}
+
+		var opts crypto.SignerOpts
+		switch alg {
+		case kms.SigningAlgorithmSpecEcdsaSha256, kms.SigningAlgorithmSpecRsassaPkcs1V15Sha256:
+			opts = crypto.SHA256
+		case kms.SigningAlgorithmSpecEcdsaSha384, kms.SigningAlgorithmSpecRsassaPkcs1V15Sha384:
+			opts = crypto.SHA384
+		case kms.SigningAlgorithmSpecEcdsaSha512, kms.SigningAlgorithmSpecRsassaPkcs1V15Sha512:
+			opts = crypto.SHA512
+		case kms.SigningAlgorithmSpecRsassaPssSha256:
+			opts = &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: crypto.SHA256}
+		case kms.SigningAlgorithmSpecRsassaPssSha384:
+			opts = &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: crypto.SHA384}
+		case kms.SigningAlgorithmSpecRsassaPssSha512:
+			opts = &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: crypto.SHA512}
+		default:
+			return nil, fmt.Errorf("unexpected signing algorithm %s", alg)
+		}
+		sig, err := key.Sign(rand.Reader, input.Message, opts)
+		if err != nil {
+			return nil, err
+		}
+		return &kms.SignOutput{
+			KeyId:            input.KeyId,
+			Signature:        sig,
+			SigningAlgorithm: input.SigningAlgorithm,
+		}, nil
+	}
+}
+
+func TestNewSigner(t *testing.T) {
+	key := mustSigner(t)
+	der := mustPublicKeyDER(t, key.Public())
+	client := &MockClient{getPublicKeyWithContext: getPublicKeyWithContext(der, nil)}
+
+	tests := []struct {
+		name    string
+		client  KeyManagementClient
+		keyID   string
+		want    *Signer
+		wantErr bool
+	}{
+		{"ok", client, testKeyID, &Signer{service: client, keyID: testKeyID, publicKey: key.Public()}, false},
+		{"fail get public key", &MockClient{
+			getPublicKeyWithContext: getPublicKeyWithContext(nil, fmt.Errorf("an error")),
+		}, testKeyID, nil, true},
+		{"fail parse", &MockClient{
+			getPublicKeyWithContext: getPublicKeyWithContext([]byte("bad der"), nil),
+		}, testKeyID, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewSigner(tt.client, tt.keyID)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewSigner() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("NewSigner() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSigner_Public(t *testing.T) {
+	key := mustSigner(t)
+	s := &Signer{service: &MockClient{}, keyID: testKeyID, publicKey: key.Public()}
+	if got := s.Public(); !reflect.DeepEqual(got, key.Public()) {
+		t.Errorf("Signer.Public() = %v, want %v", got, key.Public())
+	}
+}
+
+func TestSigner_Sign(t *testing.T) {
+	p256, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p384, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name               string
+		key                crypto.Signer
+		signatureAlgorithm x509.SignatureAlgorithm
+		wantAlgorithm      string
+	}{
+		{"ok ecdsa p256", p256, x509.ECDSAWithSHA256, kms.SigningAlgorithmSpecEcdsaSha256},
+		{"ok ecdsa p384", p384, x509.ECDSAWithSHA384, kms.SigningAlgorithmSpecEcdsaSha384},
+		{"ok ecdsa sha512", p384, x509.ECDSAWithSHA512, kms.SigningAlgorithmSpecEcdsaSha512},
+		{"ok rsa sha256", rsaKey, x509.SHA256WithRSA, kms.SigningAlgorithmSpecRsassaPkcs1V15Sha256},
+		{"ok rsa sha384", rsaKey, x509.SHA384WithRSA, kms.SigningAlgorithmSpecRsassaPkcs1V15Sha384},
+		{"ok rsa sha512", rsaKey, x509.SHA512WithRSA, kms.SigningAlgorithmSpecRsassaPkcs1V15Sha512},
+		{"ok rsa-pss sha256", rsaKey, x509.SHA256WithRSAPSS, kms.SigningAlgorithmSpecRsassaPssSha256},
+		{"ok rsa-pss sha384", rsaKey, x509.SHA384WithRSAPSS, kms.SigningAlgorithmSpecRsassaPssSha384},
+		{"ok rsa-pss sha512", rsaKey, x509.SHA512WithRSAPSS, kms.SigningAlgorithmSpecRsassaPssSha512},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Signer{
+				service:   &MockClient{signWithContext: signWithContext(t, tt.key, tt.wantAlgorithm)},
+				keyID:     testKeyID,
+				publicKey: tt.key.Public(),
+			}
+
+			template := &x509.Certificate{
+				SerialNumber:       big.NewInt(1),
+				Subject:            pkix.Name{CommonName: "Test Root"},
+				NotBefore:          time.Now(),
+				NotAfter:           time.Now().Add(time.Hour),
+				SignatureAlgorithm: tt.signatureAlgorithm,
+			}
+			der, err := x509.CreateCertificate(rand.Reader, template, template, s.Public(), s)
+			if err != nil {
+				t.Fatalf("x509.CreateCertificate() error = %v", err)
+			}
+			cert, err := x509.ParseCertificate(der)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := cert.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature); err != nil {
+				t.Errorf("Certificate.CheckSignature() error = %v", err)
+			}
+		})
+	}
+}
+
+func TestSigner_Sign_ssh(t *testing.T) {
+	key := mustSigner(t)
+	s := &Signer{
+		service:   &MockClient{signWithContext: signWithContext(t, key, kms.SigningAlgorithmSpecEcdsaSha256)},
+		keyID:     testKeyID,
+		publicKey: key.Public(),
+	}
+
+	signer, err := ssh.NewSignerFromSigner(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := []byte("the quick brown fox jumps over the lazy dog")
+	sig, err := signer.Sign(rand.Reader, data)
+	if err != nil {
+		t.Fatalf("ssh.Signer.Sign() error = %v", err)
+	}
+	if err := signer.PublicKey().Verify(data, sig); err != nil {
+		t.Errorf("ssh.PublicKey.Verify() error = %v", err)
+	}
+}
+
+func TestSigner_Sign_fail(t *testing.T) {
+	ecKey := mustSigner(t)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	failSign := func(_ aws.Context, _ *kms.SignInput, _ ...request.Option) (*kms.SignOutput, error) {
+		return nil, fmt.Errorf("an error")
+	}
+	tests := []struct {
+		name      string
+		publicKey crypto.PublicKey
+		opts      crypto.SignerOpts
+	}{
+		{"fail sign", ecKey.Public(), crypto.SHA256},
+		{"fail ecdsa hash", ecKey.Public(), crypto.SHA1},
+		{"fail rsa hash", rsaKey.Public(), crypto.SHA1},
+		{"fail key type", edPub, crypto.Hash(0)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Signer{
+				service:   &MockClient{signWithContext: failSign},
+				keyID:     testKeyID,
+				publicKey: tt.publicKey,
+			}
+			if _, err := s.Sign(rand.Reader, []byte("digest"), tt.opts); err == nil {
+				t.Error("Signer.Sign() error = nil, wantErr true")
+			}
+		})
+	}
+}
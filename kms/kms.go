@@ -7,8 +7,11 @@ import (
 
 	"github.com/pkg/errors"
 	"github.com/smallstep/certificates/kms/apiv1"
+	"github.com/smallstep/certificates/kms/awskms"
 	"github.com/smallstep/certificates/kms/cloudkms"
+	"github.com/smallstep/certificates/kms/pkcs11"
 	"github.com/smallstep/certificates/kms/softkms"
+	"github.com/smallstep/certificates/kms/vaulttransit"
 )
 
 // KeyManager is the interface implemented by all the KMS.
@@ -30,6 +33,12 @@ func New(ctx context.Context, opts apiv1.Options) (KeyManager, error) {
 		return softkms.New(ctx, opts)
 	case apiv1.CloudKMS:
 		return cloudkms.New(ctx, opts)
+	case apiv1.AmazonKMS:
+		return awskms.New(ctx, opts)
+	case apiv1.PKCS11:
+		return pkcs11.New(ctx, opts)
+	case apiv1.VaultTransit:
+		return vaulttransit.New(ctx, opts)
 	default:
 		return nil, errors.Errorf("unsupported kms type '%s'", opts.Type)
 	}
@@ -7,8 +7,11 @@ import (
 	"testing"
 
 	"github.com/smallstep/certificates/kms/apiv1"
+	"github.com/smallstep/certificates/kms/awskms"
 	"github.com/smallstep/certificates/kms/cloudkms"
+	"github.com/smallstep/certificates/kms/pkcs11"
 	"github.com/smallstep/certificates/kms/softkms"
+	"github.com/smallstep/certificates/kms/vaulttransit"
 )
 
 func TestNew(t *testing.T) {
@@ -28,8 +31,9 @@ func TestNew(t *testing.T) {
 		{"softkms", false, args{ctx, apiv1.Options{Type: "softkms"}}, &softkms.SoftKMS{}, false},
 		{"default", false, args{ctx, apiv1.Options{}}, &softkms.SoftKMS{}, false},
 		{"cloudkms", true, args{ctx, apiv1.Options{Type: "cloudkms"}}, &cloudkms.CloudKMS{}, true}, // fails because not credentials
-		{"awskms", false, args{ctx, apiv1.Options{Type: "awskms"}}, nil, true},                     // not yet supported
-		{"pkcs11", false, args{ctx, apiv1.Options{Type: "pkcs11"}}, nil, true},                     // not yet supported
+		{"vaulttransit", false, args{ctx, apiv1.Options{Type: "vaulttransit", Address: "https://127.0.0.1:8200", Token: "token"}}, &vaulttransit.VaultTransit{}, false},
+		{"awskms", false, args{ctx, apiv1.Options{Type: "awskms", Region: "us-east-1"}}, &awskms.KMS{}, false},
+		{"pkcs11", false, args{ctx, apiv1.Options{Type: "pkcs11"}}, &pkcs11.PKCS11{}, true}, // fails because no module
 		{"fail validation", false, args{ctx, apiv1.Options{Type: "foobar"}}, nil, true},
 	}
 	for _, tt := range tests {
@@ -0,0 +1,130 @@
+// +build cgo,!softhsm2
+
+package pkcs11
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"io"
+	"testing"
+
+	"github.com/ThalesIgnite/crypto11"
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/kms/apiv1"
+)
+
+// stubPKCS11 is an in-memory stand-in of a PKCS #11 token used if the tests
+// are not run against SoftHSMv2.
+type stubPKCS11 struct {
+	signers []*stubSigner
+	closed  bool
+}
+
+type stubSigner struct {
+	crypto.Signer
+	id, label   []byte
+	sensitive   bool
+	extractable bool
+	deleted     bool
+}
+
+func (s *stubSigner) Delete() error {
+	s.deleted = true
+	return nil
+}
+
+func (s *stubSigner) Decrypt(rand io.Reader, msg []byte, opts crypto.DecrypterOpts) ([]byte, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (s *stubPKCS11) FindKeyPair(id, label []byte) (crypto11.Signer, error) {
+	if id == nil && label == nil {
+		return nil, errors.New("id and label cannot both be nil")
+	}
+	for _, signer := range s.signers {
+		if signer.deleted {
+			continue
+		}
+		if (id == nil || bytes.Equal(id, signer.id)) && (label == nil || bytes.Equal(label, signer.label)) {
+			return signer, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *stubPKCS11) GenerateRSAKeyPairWithAttributes(public, private crypto11.AttributeSet, bits int) (crypto11.SignerDecrypter, error) {
+	// Avoid the generation of large keys in the tests.
+	if bits > 2048 {
+		bits = 1024
+	}
+	key, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		return nil, err
+	}
+	return s.addSigner(key, private), nil
+}
+
+func (s *stubPKCS11) GenerateECDSAKeyPairWithAttributes(public, private crypto11.AttributeSet, curve elliptic.Curve) (crypto11.Signer, error) {
+	key, err := ecdsa.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return s.addSigner(key, private), nil
+}
+
+func (s *stubPKCS11) Close() error {
+	if s.closed {
+		return errors.New("already closed")
+	}
+	s.closed = true
+	return nil
+}
+
+func (s *stubPKCS11) addSigner(key crypto.Signer, attrs crypto11.AttributeSet) *stubSigner {
+	signer := &stubSigner{Signer: key}
+	if a, ok := attrs[crypto11.CkaId]; ok {
+		signer.id = a.Value
+	}
+	if a, ok := attrs[crypto11.CkaLabel]; ok {
+		signer.label = a.Value
+	}
+	if a, ok := attrs[crypto11.CkaSensitive]; ok {
+		signer.sensitive = len(a.Value) == 1 && a.Value[0] == 1
+	}
+	if a, ok := attrs[crypto11.CkaExtractable]; ok {
+		signer.extractable = len(a.Value) == 1 && a.Value[0] == 1
+	}
+	s.signers = append(s.signers, signer)
+	return signer
+}
+
+func setupPKCS11(t *testing.T) *PKCS11 {
+	t.Helper()
+	k := &PKCS11{
+		p11: &stubPKCS11{},
+	}
+	setupKeys(t, k)
+	return k
+}
+
+func TestPKCS11_CreateKey_attributes(t *testing.T) {
+	k := setupPKCS11(t)
+	if _, err := k.CreateKey(&apiv1.CreateKeyRequest{
+		Name:               "pkcs11:id=7390;object=attributes-key",
+		SignatureAlgorithm: apiv1.ECDSAWithSHA256,
+	}); err != nil {
+		t.Fatalf("PKCS11.CreateKey() error = %v", err)
+	}
+	signers := k.p11.(*stubPKCS11).signers
+	signer := signers[len(signers)-1]
+	if !bytes.Equal(signer.id, []byte{0x73, 0x90}) || string(signer.label) != "attributes-key" {
+		t.Errorf("PKCS11.CreateKey() id = %x, label = %s, want 7390, attributes-key", signer.id, signer.label)
+	}
+	if !signer.sensitive || signer.extractable {
+		t.Errorf("PKCS11.CreateKey() sensitive = %v, extractable = %v, want true, false", signer.sensitive, signer.extractable)
+	}
+}
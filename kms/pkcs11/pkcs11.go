@@ -0,0 +1,242 @@
+// +build cgo
+
+package pkcs11
+
+import (
+	"context"
+	"crypto"
+	"crypto/elliptic"
+	"strconv"
+
+	"github.com/ThalesIgnite/crypto11"
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/kms/apiv1"
+	"github.com/smallstep/certificates/kms/uri"
+)
+
+// Scheme is the scheme used in the PKCS #11 URIs.
+const Scheme = "pkcs11"
+
+// DefaultRSASize is the number of bits of a new RSA key if no size has been
+// specified.
+const DefaultRSASize = 3072
+
+// P11 defines the methods on crypto11.Context that this package will use. This
+// interface will be used for unit testing.
+type P11 interface {
+	FindKeyPair(id, label []byte) (crypto11.Signer, error)
+	GenerateRSAKeyPairWithAttributes(public, private crypto11.AttributeSet, bits int) (crypto11.SignerDecrypter, error)
+	GenerateECDSAKeyPairWithAttributes(public, private crypto11.AttributeSet, curve elliptic.Curve) (crypto11.Signer, error)
+	Close() error
+}
+
+var p11Configure = func(config *crypto11.Config) (P11, error) {
+	return crypto11.Configure(config)
+}
+
+// PKCS11 is the implementation of a KMS using the PKCS #11 standard. The keys
+// are generated and stored in the token, and they are identified by PKCS #11
+// URIs, e.g. pkcs11:id=7331;object=intermediate-key.
+type PKCS11 struct {
+	p11    P11
+	closed bool
+}
+
+// New returns a new PKCS11 KMS. The token is configured with the uri option,
+// e.g. pkcs11:module-path=/usr/local/lib/softhsm/libsofthsm2.so;token=smallstep,
+// the module and pin options take precedence over the ones in the uri.
+func New(ctx context.Context, opts apiv1.Options) (*PKCS11, error) {
+	var config crypto11.Config
+	if opts.URI != "" {
+		u, err := uri.ParseWithScheme(Scheme, opts.URI)
+		if err != nil {
+			return nil, err
+		}
+		config.Path = u.Get("module-path")
+		config.TokenLabel = u.Get("token")
+		config.TokenSerial = u.Get("serial")
+		if v := u.Get("slot-id"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return nil, errors.Wrap(err, "kms uri 'slot-id' must be a number")
+			}
+			config.SlotNumber = &n
+		}
+		config.Pin = u.Pin()
+	}
+	if opts.Module != "" {
+		config.Path = opts.Module
+	}
+	if opts.Pin != "" {
+		config.Pin = opts.Pin
+	}
+
+	switch {
+	case config.Path == "":
+		return nil, errors.New("kms module or uri 'module-path' is required")
+	case config.TokenLabel == "" && config.TokenSerial == "" && config.SlotNumber == nil:
+		return nil, errors.New("kms uri 'token', 'serial' or 'slot-id' is required")
+	case config.Pin == "":
+		return nil, errors.New("kms pin or uri 'pin-value' is required")
+	}
+
+	p11, err := p11Configure(&config)
+	if err != nil {
+		return nil, errors.Wrap(err, "error initializing PKCS#11")
+	}
+
+	return &PKCS11{
+		p11: p11,
+	}, nil
+}
+
+// GetPublicKey returns the public key of the key pair identified by the given
+// uri.
+func (k *PKCS11) GetPublicKey(req *apiv1.GetPublicKeyRequest) (crypto.PublicKey, error) {
+	if req.Name == "" {
+		return nil, errors.New("getPublicKeyRequest 'name' cannot be empty")
+	}
+
+	signer, err := findSigner(k.p11, req.Name)
+	if err != nil {
+		return nil, errors.Wrap(err, "getPublicKey failed")
+	}
+
+	return signer.Public(), nil
+}
+
+// CreateKey generates a new key pair in the token with the id and label in
+// the uri, e.g. pkcs11:id=7331;object=intermediate-key. The private key is
+// generated as sensitive and non-extractable.
+func (k *PKCS11) CreateKey(req *apiv1.CreateKeyRequest) (*apiv1.CreateKeyResponse, error) {
+	switch {
+	case req.Name == "":
+		return nil, errors.New("createKeyRequest 'name' cannot be empty")
+	case req.ProtectionLevel != apiv1.UnspecifiedProtectionLevel && req.ProtectionLevel != apiv1.HSM:
+		return nil, errors.Errorf("pkcs11 does not support protection level '%s'", req.ProtectionLevel)
+	}
+
+	signer, err := generateKey(k.p11, req)
+	if err != nil {
+		return nil, errors.Wrap(err, "createKey failed")
+	}
+
+	return &apiv1.CreateKeyResponse{
+		Name:      req.Name,
+		PublicKey: signer.Public(),
+		CreateSignerRequest: apiv1.CreateSignerRequest{
+			SigningKey: req.Name,
+		},
+	}, nil
+}
+
+// CreateSigner creates a signer using the key pair identified by the given
+// uri.
+func (k *PKCS11) CreateSigner(req *apiv1.CreateSignerRequest) (crypto.Signer, error) {
+	if req.SigningKey == "" {
+		return nil, errors.New("createSignerRequest 'signingKey' cannot be empty")
+	}
+
+	signer, err := findSigner(k.p11, req.SigningKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "createSigner failed")
+	}
+
+	return signer, nil
+}
+
+// Close releases the connection to the PKCS #11 module.
+func (k *PKCS11) Close() error {
+	if k.closed {
+		return nil
+	}
+	k.closed = true
+	return errors.Wrap(k.p11.Close(), "error closing pkcs#11 context")
+}
+
+func toCryptoID(rawuri string) (id, label []byte, err error) {
+	u, err := uri.ParseWithScheme(Scheme, rawuri)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// crypto11 uses nil to skip the id or label in the search
+	if v := u.Get("object"); v != "" {
+		label = []byte(v)
+	}
+	if id = u.GetEncoded("id"); len(id) == 0 && len(label) == 0 {
+		return nil, nil, errors.Errorf("key with uri %s is not valid, id or object are required", rawuri)
+	}
+
+	return id, label, nil
+}
+
+func findSigner(p11 P11, rawuri string) (crypto11.Signer, error) {
+	id, label, err := toCryptoID(rawuri)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := p11.FindKeyPair(id, label)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error finding key with uri %s", rawuri)
+	}
+	if signer == nil {
+		return nil, errors.Errorf("key with uri %s not found", rawuri)
+	}
+	return signer, nil
+}
+
+func generateKey(p11 P11, req *apiv1.CreateKeyRequest) (crypto11.Signer, error) {
+	id, label, err := toCryptoID(req.Name)
+	if err != nil {
+		return nil, err
+	}
+	if len(id) == 0 {
+		return nil, errors.Errorf("key with uri %s is not valid, id is required", req.Name)
+	}
+	signer, err := p11.FindKeyPair(id, nil)
+	if err != nil {
+		return nil, err
+	}
+	if signer != nil {
+		return nil, errors.Errorf("key with uri %s already exists", req.Name)
+	}
+
+	var public crypto11.AttributeSet
+	if label == nil {
+		public, err = crypto11.NewAttributeSetWithID(id)
+	} else {
+		public, err = crypto11.NewAttributeSetWithIDAndLabel(id, label)
+	}
+	if err != nil {
+		return nil, err
+	}
+	private := public.Copy()
+	if err := private.Set(crypto11.CkaSensitive, true); err != nil {
+		return nil, err
+	}
+	if err := private.Set(crypto11.CkaExtractable, false); err != nil {
+		return nil, err
+	}
+
+	bits := req.Bits
+	if bits == 0 {
+		bits = DefaultRSASize
+	}
+
+	switch req.SignatureAlgorithm {
+	case apiv1.UnspecifiedSignAlgorithm, apiv1.ECDSAWithSHA256:
+		return p11.GenerateECDSAKeyPairWithAttributes(public, private, elliptic.P256())
+	case apiv1.ECDSAWithSHA384:
+		return p11.GenerateECDSAKeyPairWithAttributes(public, private, elliptic.P384())
+	case apiv1.ECDSAWithSHA512:
+		return p11.GenerateECDSAKeyPairWithAttributes(public, private, elliptic.P521())
+	case apiv1.SHA256WithRSA, apiv1.SHA384WithRSA, apiv1.SHA512WithRSA,
+		apiv1.SHA256WithRSAPSS, apiv1.SHA384WithRSAPSS, apiv1.SHA512WithRSAPSS:
+		return p11.GenerateRSAKeyPairWithAttributes(public, private, bits)
+	case apiv1.PureEd25519:
+		return nil, errors.New("pkcs11 does not support signature algorithm 'Ed25519'")
+	default:
+		return nil, errors.Errorf("pkcs11 does not support signature algorithm '%s'", req.SignatureAlgorithm)
+	}
+}
@@ -0,0 +1,42 @@
+// +build !cgo
+
+package pkcs11
+
+import (
+	"context"
+	"crypto"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/kms/apiv1"
+)
+
+var errUnsupported = errors.New("unsupported kms type 'pkcs11': ca compiled without cgo support")
+
+// PKCS11 is the implementation of a KMS using the PKCS #11 standard. It
+// requires cgo, and this implementation always returns an error.
+type PKCS11 struct{}
+
+// New always returns an error because the CA has been compiled without cgo.
+func New(ctx context.Context, opts apiv1.Options) (*PKCS11, error) {
+	return nil, errUnsupported
+}
+
+// GetPublicKey always returns an unsupported error.
+func (*PKCS11) GetPublicKey(req *apiv1.GetPublicKeyRequest) (crypto.PublicKey, error) {
+	return nil, errUnsupported
+}
+
+// CreateKey always returns an unsupported error.
+func (*PKCS11) CreateKey(req *apiv1.CreateKeyRequest) (*apiv1.CreateKeyResponse, error) {
+	return nil, errUnsupported
+}
+
+// CreateSigner always returns an unsupported error.
+func (*PKCS11) CreateSigner(req *apiv1.CreateSignerRequest) (crypto.Signer, error) {
+	return nil, errUnsupported
+}
+
+// Close always returns an unsupported error.
+func (*PKCS11) Close() error {
+	return errUnsupported
+}
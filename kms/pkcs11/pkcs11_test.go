@@ -0,0 +1,339 @@
+// +build cgo
+
+package pkcs11
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ThalesIgnite/crypto11"
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/kms/apiv1"
+	"golang.org/x/crypto/ssh"
+)
+
+var testKeys = []struct {
+	Name               string
+	SignatureAlgorithm apiv1.SignatureAlgorithm
+	Bits               int
+}{
+	{"pkcs11:id=7370;object=rsa-key", apiv1.SHA256WithRSA, 2048},
+	{"pkcs11:id=7371;object=rsa-pss-key", apiv1.SHA256WithRSAPSS, 2048},
+	{"pkcs11:id=7372;object=ecdsa-p256-key", apiv1.ECDSAWithSHA256, 0},
+	{"pkcs11:id=7373;object=ecdsa-p384-key", apiv1.ECDSAWithSHA384, 0},
+}
+
+// setupKeys creates the test keys, removing them first if they already exist.
+func setupKeys(t *testing.T, k *PKCS11) {
+	t.Helper()
+	for _, tk := range testKeys {
+		deleteKey(t, k, tk.Name)
+		if _, err := k.CreateKey(&apiv1.CreateKeyRequest{
+			Name:               tk.Name,
+			SignatureAlgorithm: tk.SignatureAlgorithm,
+			Bits:               tk.Bits,
+		}); err != nil {
+			t.Fatalf("PKCS11.CreateKey() error = %v", err)
+		}
+	}
+}
+
+func deleteKey(t *testing.T, k *PKCS11, rawuri string) {
+	t.Helper()
+	id, label, err := toCryptoID(rawuri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for {
+		signer, err := k.p11.FindKeyPair(id, label)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if signer == nil {
+			return
+		}
+		if err := signer.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+type mockP11 struct {
+	P11
+}
+
+func TestNew(t *testing.T) {
+	tmp := p11Configure
+	defer func() {
+		p11Configure = tmp
+	}()
+
+	pinFile, err := ioutil.TempFile("", "pkcs11-pin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(pinFile.Name())
+	if _, err := pinFile.WriteString("password\n"); err != nil {
+		t.Fatal(err)
+	}
+	pinFile.Close()
+
+	var got crypto11.Config
+	p11Configure = func(config *crypto11.Config) (P11, error) {
+		got = *config
+		if config.TokenLabel == "fail" {
+			return nil, errors.New("an error")
+		}
+		return &mockP11{}, nil
+	}
+
+	slot := 1
+	tests := []struct {
+		name    string
+		opts    apiv1.Options
+		want    crypto11.Config
+		wantErr bool
+	}{
+		{"ok", apiv1.Options{
+			Type: "pkcs11",
+			URI:  "pkcs11:module-path=/usr/local/lib/softhsm/libsofthsm2.so;token=pkcs11-test?pin-value=password",
+		}, crypto11.Config{Path: "/usr/local/lib/softhsm/libsofthsm2.so", TokenLabel: "pkcs11-test", Pin: "password"}, false},
+		{"ok module and pin", apiv1.Options{
+			Type:   "pkcs11",
+			URI:    "pkcs11:module-path=/usr/local/lib/softhsm/libsofthsm2.so;serial=0123456789?pin-value=password",
+			Module: "/usr/lib/libsofthsm2.so",
+			Pin:    "secret",
+		}, crypto11.Config{Path: "/usr/lib/libsofthsm2.so", TokenSerial: "0123456789", Pin: "secret"}, false},
+		{"ok slot and pin-source", apiv1.Options{
+			Type: "pkcs11",
+			URI:  "pkcs11:module-path=/usr/local/lib/softhsm/libsofthsm2.so;slot-id=1?pin-source=" + pinFile.Name(),
+		}, crypto11.Config{Path: "/usr/local/lib/softhsm/libsofthsm2.so", SlotNumber: &slot, Pin: "password"}, false},
+		{"fail scheme", apiv1.Options{
+			Type: "pkcs11",
+			URI:  "foo:module-path=/usr/local/lib/softhsm/libsofthsm2.so;token=pkcs11-test?pin-value=password",
+		}, crypto11.Config{}, true},
+		{"fail slot-id", apiv1.Options{
+			Type: "pkcs11",
+			URI:  "pkcs11:module-path=/usr/local/lib/softhsm/libsofthsm2.so;slot-id=foo?pin-value=password",
+		}, crypto11.Config{}, true},
+		{"fail module", apiv1.Options{
+			Type: "pkcs11",
+			URI:  "pkcs11:token=pkcs11-test?pin-value=password",
+		}, crypto11.Config{}, true},
+		{"fail token", apiv1.Options{
+			Type: "pkcs11",
+			URI:  "pkcs11:module-path=/usr/local/lib/softhsm/libsofthsm2.so?pin-value=password",
+		}, crypto11.Config{}, true},
+		{"fail pin", apiv1.Options{
+			Type: "pkcs11",
+			URI:  "pkcs11:module-path=/usr/local/lib/softhsm/libsofthsm2.so;token=pkcs11-test",
+		}, crypto11.Config{}, true},
+		{"fail configure", apiv1.Options{
+			Type: "pkcs11",
+			URI:  "pkcs11:module-path=/usr/local/lib/softhsm/libsofthsm2.so;token=fail?pin-value=password",
+		}, crypto11.Config{Path: "/usr/local/lib/softhsm/libsofthsm2.so", TokenLabel: "fail", Pin: "password"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got = crypto11.Config{}
+			k, err := New(context.Background(), tt.opts)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("New() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err == nil && k.p11 == nil {
+				t.Error("New() p11 is nil")
+			}
+			if got.Path != tt.want.Path || got.TokenLabel != tt.want.TokenLabel || got.TokenSerial != tt.want.TokenSerial || got.Pin != tt.want.Pin {
+				t.Errorf("New() config = %+v, want %+v", got, tt.want)
+			}
+			if (got.SlotNumber == nil) != (tt.want.SlotNumber == nil) || (got.SlotNumber != nil && *got.SlotNumber != *tt.want.SlotNumber) {
+				t.Errorf("New() config.SlotNumber = %v, want %v", got.SlotNumber, tt.want.SlotNumber)
+			}
+		})
+	}
+}
+
+func TestPKCS11_GetPublicKey(t *testing.T) {
+	k := setupPKCS11(t)
+	defer k.Close()
+
+	tests := []struct {
+		name    string
+		keyName string
+		want    interface{}
+		wantErr bool
+	}{
+		{"ok rsa", "pkcs11:id=7370;object=rsa-key", &rsa.PublicKey{}, false},
+		{"ok id", "pkcs11:id=7372", &ecdsa.PublicKey{}, false},
+		{"ok object", "pkcs11:object=ecdsa-p384-key", &ecdsa.PublicKey{}, false},
+		{"ok percent-encoded", "pkcs11:id=%73%71", &rsa.PublicKey{}, false},
+		{"fail empty", "", nil, true},
+		{"fail scheme", "foo:id=7370", nil, true},
+		{"fail attributes", "pkcs11:token=pkcs11-test", nil, true},
+		{"fail not found", "pkcs11:id=7399", nil, true},
+		{"fail mismatch", "pkcs11:id=7370;object=ecdsa-p256-key", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := k.GetPublicKey(&apiv1.GetPublicKeyRequest{Name: tt.keyName})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("PKCS11.GetPublicKey() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err == nil {
+				switch tt.want.(type) {
+				case *rsa.PublicKey:
+					if _, ok := got.(*rsa.PublicKey); !ok {
+						t.Errorf("PKCS11.GetPublicKey() = %T, want *rsa.PublicKey", got)
+					}
+				case *ecdsa.PublicKey:
+					if _, ok := got.(*ecdsa.PublicKey); !ok {
+						t.Errorf("PKCS11.GetPublicKey() = %T, want *ecdsa.PublicKey", got)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestPKCS11_CreateKey(t *testing.T) {
+	k := setupPKCS11(t)
+	defer k.Close()
+
+	tests := []struct {
+		name    string
+		req     *apiv1.CreateKeyRequest
+		want    interface{}
+		wantErr bool
+	}{
+		{"ok default", &apiv1.CreateKeyRequest{Name: "pkcs11:id=7380;object=default-key"}, elliptic.P256(), false},
+		{"ok p256", &apiv1.CreateKeyRequest{Name: "pkcs11:id=7381;object=p256-key", SignatureAlgorithm: apiv1.ECDSAWithSHA256}, elliptic.P256(), false},
+		{"ok p384 hsm", &apiv1.CreateKeyRequest{Name: "pkcs11:id=7382;object=p384-key", SignatureAlgorithm: apiv1.ECDSAWithSHA384, ProtectionLevel: apiv1.HSM}, elliptic.P384(), false},
+		{"ok p521", &apiv1.CreateKeyRequest{Name: "pkcs11:id=7383", SignatureAlgorithm: apiv1.ECDSAWithSHA512}, elliptic.P521(), false},
+		{"ok rsa", &apiv1.CreateKeyRequest{Name: "pkcs11:id=7384;object=rsa-key", SignatureAlgorithm: apiv1.SHA256WithRSA, Bits: 2048}, 2048, false},
+		{"ok rsa pss", &apiv1.CreateKeyRequest{Name: "pkcs11:id=7385;object=rsa-pss-key", SignatureAlgorithm: apiv1.SHA384WithRSAPSS, Bits: 2048}, 2048, false},
+		{"fail name", &apiv1.CreateKeyRequest{SignatureAlgorithm: apiv1.ECDSAWithSHA256}, nil, true},
+		{"fail id", &apiv1.CreateKeyRequest{Name: "pkcs11:object=no-id-key", SignatureAlgorithm: apiv1.ECDSAWithSHA256}, nil, true},
+		{"fail exists", &apiv1.CreateKeyRequest{Name: "pkcs11:id=7370;object=other-key", SignatureAlgorithm: apiv1.ECDSAWithSHA256}, nil, true},
+		{"fail ed25519", &apiv1.CreateKeyRequest{Name: "pkcs11:id=7386", SignatureAlgorithm: apiv1.PureEd25519}, nil, true},
+		{"fail algorithm", &apiv1.CreateKeyRequest{Name: "pkcs11:id=7386", SignatureAlgorithm: apiv1.SignatureAlgorithm(100)}, nil, true},
+		{"fail software", &apiv1.CreateKeyRequest{Name: "pkcs11:id=7386", SignatureAlgorithm: apiv1.ECDSAWithSHA256, ProtectionLevel: apiv1.Software}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !tt.wantErr {
+				deleteKey(t, k, tt.req.Name)
+				defer deleteKey(t, k, tt.req.Name)
+			}
+			got, err := k.CreateKey(tt.req)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("PKCS11.CreateKey() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err != nil {
+				return
+			}
+			if got.Name != tt.req.Name || got.CreateSignerRequest.SigningKey != tt.req.Name {
+				t.Errorf("PKCS11.CreateKey() name = %v, want %v", got.Name, tt.req.Name)
+			}
+			switch want := tt.want.(type) {
+			case elliptic.Curve:
+				if pub, ok := got.PublicKey.(*ecdsa.PublicKey); !ok || pub.Curve != want {
+					t.Errorf("PKCS11.CreateKey() public key = %T, want ecdsa %s", got.PublicKey, want.Params().Name)
+				}
+			case int:
+				if pub, ok := got.PublicKey.(*rsa.PublicKey); !ok || pub.N.BitLen() != want {
+					t.Errorf("PKCS11.CreateKey() public key = %T, want rsa %d", got.PublicKey, want)
+				}
+			}
+		})
+	}
+}
+
+func TestPKCS11_CreateSigner(t *testing.T) {
+	k := setupPKCS11(t)
+	defer k.Close()
+
+	tests := []struct {
+		name               string
+		signingKey         string
+		signatureAlgorithm x509.SignatureAlgorithm
+		wantErr            bool
+	}{
+		{"ok rsa", "pkcs11:id=7370;object=rsa-key", x509.SHA256WithRSA, false},
+		{"ok rsa sha512", "pkcs11:id=7370;object=rsa-key", x509.SHA512WithRSA, false},
+		{"ok rsa pss", "pkcs11:id=7371;object=rsa-pss-key", x509.SHA256WithRSAPSS, false},
+		{"ok ecdsa p256", "pkcs11:id=7372;object=ecdsa-p256-key", x509.ECDSAWithSHA256, false},
+		{"ok ecdsa p384", "pkcs11:id=7373;object=ecdsa-p384-key", x509.ECDSAWithSHA384, false},
+		{"fail empty", "", x509.UnknownSignatureAlgorithm, true},
+		{"fail not found", "pkcs11:id=7399;object=missing-key", x509.UnknownSignatureAlgorithm, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := k.CreateSigner(&apiv1.CreateSignerRequest{SigningKey: tt.signingKey})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("PKCS11.CreateSigner() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err != nil {
+				return
+			}
+
+			// X.509: sign and verify a self-signed certificate
+			template := &x509.Certificate{
+				SerialNumber:       big.NewInt(1),
+				Subject:            pkix.Name{CommonName: "Test Root"},
+				NotBefore:          time.Now(),
+				NotAfter:           time.Now().Add(time.Hour),
+				SignatureAlgorithm: tt.signatureAlgorithm,
+			}
+			der, err := x509.CreateCertificate(rand.Reader, template, template, got.Public(), got)
+			if err != nil {
+				t.Fatalf("x509.CreateCertificate() error = %v", err)
+			}
+			cert, err := x509.ParseCertificate(der)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := cert.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature); err != nil {
+				t.Errorf("Certificate.CheckSignature() error = %v", err)
+			}
+
+			// SSH: sign and verify some data
+			sshSigner, err := ssh.NewSignerFromSigner(got)
+			if err != nil {
+				t.Fatalf("ssh.NewSignerFromSigner() error = %v", err)
+			}
+			data := []byte("the quick brown fox jumps over the lazy dog")
+			sig, err := sshSigner.Sign(rand.Reader, data)
+			if err != nil {
+				t.Fatalf("ssh.Signer.Sign() error = %v", err)
+			}
+			if err := sshSigner.PublicKey().Verify(data, sig); err != nil {
+				t.Errorf("ssh.PublicKey.Verify() error = %v", err)
+			}
+		})
+	}
+}
+
+func TestPKCS11_Close(t *testing.T) {
+	k := setupPKCS11(t)
+	if err := k.Close(); err != nil {
+		t.Errorf("PKCS11.Close() error = %v", err)
+	}
+	// A second call is a noop
+	if err := k.Close(); err != nil {
+		t.Errorf("PKCS11.Close() error = %v", err)
+	}
+}
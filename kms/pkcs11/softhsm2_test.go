@@ -0,0 +1,33 @@
+// +build cgo,softhsm2
+
+package pkcs11
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/smallstep/certificates/kms/apiv1"
+)
+
+// The SoftHSMv2 tests require a token initialized with:
+//
+//	softhsm2-util --init-token --free --label pkcs11-test --pin password --so-pin password
+//
+// The module path can be set with the SOFTHSM2_MODULE environment variable.
+func setupPKCS11(t *testing.T) *PKCS11 {
+	t.Helper()
+	module := os.Getenv("SOFTHSM2_MODULE")
+	if module == "" {
+		module = "/usr/local/lib/softhsm/libsofthsm2.so"
+	}
+	k, err := New(context.Background(), apiv1.Options{
+		Type: "pkcs11",
+		URI:  "pkcs11:module-path=" + module + ";token=pkcs11-test?pin-value=password",
+	})
+	if err != nil {
+		t.Fatalf("failed to initialize SoftHSMv2: %v", err)
+	}
+	setupKeys(t, k)
+	return k
+}
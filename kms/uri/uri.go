@@ -0,0 +1,132 @@
+package uri
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io/ioutil"
+	"net/url"
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+)
+
+// URI implements a parser for URIs in the format used by the KMS to identify
+// keys, like the PKCS #11 URIs defined in RFC 7512:
+//
+//	pkcs11:token=smallstep;id=%01;object=intermediate?pin-value=password
+//
+// The attributes in the path are separated by semicolons, and the attributes
+// in the query by ampersands.
+type URI struct {
+	*url.URL
+	Values url.Values
+}
+
+// New creates a new URI from the given scheme and values.
+func New(scheme string, values url.Values) *URI {
+	return &URI{
+		URL: &url.URL{
+			Scheme: scheme,
+			Opaque: strings.ReplaceAll(values.Encode(), "&", ";"),
+		},
+		Values: values,
+	}
+}
+
+// HasScheme returns true if the given uri has the given scheme, false
+// otherwise.
+func HasScheme(scheme, rawuri string) bool {
+	u, err := url.Parse(rawuri)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Scheme, scheme)
+}
+
+// Parse returns a new URI for the given string.
+func Parse(rawuri string) (*URI, error) {
+	u, err := url.Parse(rawuri)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error parsing %s", rawuri)
+	}
+	if u.Scheme == "" {
+		return nil, errors.Errorf("error parsing %s: scheme is missing", rawuri)
+	}
+	v, err := url.ParseQuery(strings.ReplaceAll(u.Opaque, ";", "&"))
+	if err != nil {
+		return nil, errors.Wrapf(err, "error parsing %s", rawuri)
+	}
+
+	return &URI{
+		URL:    u,
+		Values: v,
+	}, nil
+}
+
+// ParseWithScheme returns a new URI for the given string only if it has the
+// given scheme.
+func ParseWithScheme(scheme, rawuri string) (*URI, error) {
+	u, err := Parse(rawuri)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.EqualFold(u.Scheme, scheme) {
+		return nil, errors.Errorf("error parsing %s: scheme not expected", rawuri)
+	}
+	return u, nil
+}
+
+// Get returns the first value in the uri with the given key, it will return
+// empty string if that field is not present. Attributes in the path take
+// precedence over the ones in the query.
+func (u *URI) Get(key string) string {
+	v := u.Values.Get(key)
+	if v == "" {
+		v = u.URL.Query().Get(key)
+	}
+	return v
+}
+
+// GetEncoded returns the first value in the uri with the given key, it will
+// return nil if the field is not present. The value is decoded from hex if
+// possible, this is useful for ids like id=7331, where the value is 0x73 0x31,
+// the value is returned as is if it's percent-encoded like id=%73%31.
+func (u *URI) GetEncoded(key string) []byte {
+	v := u.Get(key)
+	if v == "" {
+		return nil
+	}
+	if len(v)%2 == 0 {
+		if b, err := hex.DecodeString(v); err == nil {
+			return b
+		}
+	}
+	return []byte(v)
+}
+
+// Pin returns the pin encoded in the url. It will read the pin from the
+// pin-value or the pin-source attributes.
+func (u *URI) Pin() string {
+	if value := u.Get("pin-value"); value != "" {
+		return value
+	}
+	if path := u.Get("pin-source"); path != "" {
+		if b, err := readFile(path); err == nil {
+			return string(bytes.TrimRightFunc(b, unicode.IsSpace))
+		}
+	}
+	return ""
+}
+
+func readFile(path string) ([]byte, error) {
+	u, err := url.Parse(path)
+	if err == nil && (u.Scheme == "" || u.Scheme == "file") && u.Path != "" {
+		path = u.Path
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading %s", path)
+	}
+	return b, nil
+}
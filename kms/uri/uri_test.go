@@ -0,0 +1,208 @@
+package uri
+
+import (
+	"io/ioutil"
+	"net/url"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func mustParse(rawuri string) *URI {
+	u, err := Parse(rawuri)
+	if err != nil {
+		panic(err)
+	}
+	return u
+}
+
+func TestNew(t *testing.T) {
+	type args struct {
+		scheme string
+		values url.Values
+	}
+	tests := []struct {
+		name string
+		args args
+		want *URI
+	}{
+		{"ok", args{"yubikey", url.Values{"slot-id": []string{"9a"}}}, &URI{
+			URL:    &url.URL{Scheme: "yubikey", Opaque: "slot-id=9a"},
+			Values: url.Values{"slot-id": []string{"9a"}},
+		}},
+		{"ok multiple", args{"pkcs11", url.Values{"token": []string{"smallstep"}, "object": []string{"root-key"}}}, &URI{
+			URL:    &url.URL{Scheme: "pkcs11", Opaque: "object=root-key;token=smallstep"},
+			Values: url.Values{"token": []string{"smallstep"}, "object": []string{"root-key"}},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := New(tt.args.scheme, tt.args.values); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("New() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHasScheme(t *testing.T) {
+	tests := []struct {
+		name   string
+		scheme string
+		rawuri string
+		want   bool
+	}{
+		{"ok", "pkcs11", "pkcs11:id=7331", true},
+		{"ok uppercase", "pkcs11", "PKCS11:id=7331", true},
+		{"fail other", "pkcs11", "yubikey:slot-id=9a", false},
+		{"fail path", "pkcs11", "/path/to/key.pem", false},
+		{"fail parse", "pkcs11", ":id=7331", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := HasScheme(tt.scheme, tt.rawuri); got != tt.want {
+				t.Errorf("HasScheme() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		rawuri  string
+		want    *URI
+		wantErr bool
+	}{
+		{"ok", "pkcs11:id=7331;object=intermediate", &URI{
+			URL:    &url.URL{Scheme: "pkcs11", Opaque: "id=7331;object=intermediate"},
+			Values: url.Values{"id": []string{"7331"}, "object": []string{"intermediate"}},
+		}, false},
+		{"ok query", "pkcs11:token=smallstep?pin-value=password", &URI{
+			URL:    &url.URL{Scheme: "pkcs11", Opaque: "token=smallstep", RawQuery: "pin-value=password"},
+			Values: url.Values{"token": []string{"smallstep"}},
+		}, false},
+		{"ok percent-encoded", "pkcs11:id=%73%31", &URI{
+			URL:    &url.URL{Scheme: "pkcs11", Opaque: "id=%73%31"},
+			Values: url.Values{"id": []string{"s1"}},
+		}, false},
+		{"fail scheme", "id=7331;object=intermediate", nil, true},
+		{"fail parse", "pkcs11:%", nil, true},
+		{"fail values", "pkcs11:id=%ZZ", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.rawuri)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseWithScheme(t *testing.T) {
+	tests := []struct {
+		name    string
+		scheme  string
+		rawuri  string
+		want    *URI
+		wantErr bool
+	}{
+		{"ok", "pkcs11", "pkcs11:id=7331", &URI{
+			URL:    &url.URL{Scheme: "pkcs11", Opaque: "id=7331"},
+			Values: url.Values{"id": []string{"7331"}},
+		}, false},
+		{"fail scheme", "pkcs11", "yubikey:slot-id=9a", nil, true},
+		{"fail parse", "pkcs11", "pkcs11:%", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseWithScheme(tt.scheme, tt.rawuri)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseWithScheme() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseWithScheme() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestURI_Get(t *testing.T) {
+	tests := []struct {
+		name string
+		uri  *URI
+		key  string
+		want string
+	}{
+		{"ok", mustParse("pkcs11:token=smallstep;object=root-key"), "object", "root-key"},
+		{"ok query", mustParse("pkcs11:token=smallstep?pin-value=password"), "pin-value", "password"},
+		{"ok precedence", mustParse("pkcs11:pin-value=secret?pin-value=password"), "pin-value", "secret"},
+		{"ok missing", mustParse("pkcs11:token=smallstep"), "object", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.uri.Get(tt.key); got != tt.want {
+				t.Errorf("URI.Get() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestURI_GetEncoded(t *testing.T) {
+	tests := []struct {
+		name string
+		uri  *URI
+		key  string
+		want []byte
+	}{
+		{"ok hex", mustParse("pkcs11:id=7331"), "id", []byte{0x73, 0x31}},
+		{"ok percent-encoded", mustParse("pkcs11:id=%73%31"), "id", []byte{0x73, 0x31}},
+		{"ok odd", mustParse("pkcs11:id=733"), "id", []byte("733")},
+		{"ok not hex", mustParse("pkcs11:id=root"), "id", []byte("root")},
+		{"ok missing", mustParse("pkcs11:object=root"), "id", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.uri.GetEncoded(tt.key); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("URI.GetEncoded() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestURI_Pin(t *testing.T) {
+	f, err := ioutil.TempFile("", "uri-pin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.WriteString("trim-me\n"); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	tests := []struct {
+		name string
+		uri  *URI
+		want string
+	}{
+		{"ok value", mustParse("pkcs11:token=smallstep?pin-value=password"), "password"},
+		{"ok source", mustParse("pkcs11:token=smallstep?pin-source=" + f.Name()), "trim-me"},
+		{"ok file source", mustParse("pkcs11:token=smallstep?pin-source=file://" + f.Name()), "trim-me"},
+		{"ok precedence", mustParse("pkcs11:token=smallstep?pin-value=password&pin-source=" + f.Name()), "password"},
+		{"ok missing file", mustParse("pkcs11:token=smallstep?pin-source=/does/not/exist"), ""},
+		{"ok missing", mustParse("pkcs11:token=smallstep"), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.uri.Pin(); got != tt.want {
+				t.Errorf("URI.Pin() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,232 @@
+package vaulttransit
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// mockVault is a stand-in of the Vault API with the AppRole auth method and
+// the transit secrets engine mounted in /v1/transit.
+type mockVault struct {
+	*httptest.Server
+	mutex    sync.Mutex
+	token    string
+	roleID   string
+	secretID string
+	keys     map[string]*mockKey
+	logins   int
+}
+
+type mockKey struct {
+	Type     string
+	Versions []crypto.Signer
+}
+
+func newMockVault(token string) *mockVault {
+	m := &mockVault{
+		token: token,
+		keys:  make(map[string]*mockKey),
+	}
+	m.Server = httptest.NewServer(http.HandlerFunc(m.handle))
+	return m
+}
+
+func (m *mockVault) addKey(name, keyType string) crypto.Signer {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.addKeyVersion(name, keyType)
+}
+
+func (m *mockVault) addKeyVersion(name, keyType string) crypto.Signer {
+	signer, err := generateKey(keyType)
+	if err != nil {
+		panic(err)
+	}
+	if k, ok := m.keys[name]; ok {
+		k.Versions = append(k.Versions, signer)
+	} else {
+		m.keys[name] = &mockKey{Type: keyType, Versions: []crypto.Signer{signer}}
+	}
+	return signer
+}
+
+func generateKey(keyType string) (crypto.Signer, error) {
+	switch keyType {
+	case "ecdsa-p256":
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case "ecdsa-p384":
+		return ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case "ecdsa-p521":
+		return ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	case "rsa-2048":
+		return rsa.GenerateKey(rand.Reader, 2048)
+	case "ed25519":
+		_, priv, err := ed25519.GenerateKey(rand.Reader)
+		return priv, err
+	default:
+		// Avoid the generation of large keys in the tests.
+		return rsa.GenerateKey(rand.Reader, 1024)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string][]string{"errors": {msg}})
+}
+
+func (m *mockVault) handle(w http.ResponseWriter, r *http.Request) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	var body map[string]interface{}
+	if r.Body != nil {
+		json.NewDecoder(r.Body).Decode(&body)
+	}
+
+	if r.URL.Path == "/v1/auth/approle/login" {
+		if r.Method != "POST" || m.roleID == "" || body["role_id"] != m.roleID || body["secret_id"] != m.secretID {
+			writeError(w, http.StatusBadRequest, "invalid role or secret ID")
+			return
+		}
+		m.logins++
+		m.token = "approle-token-" + strconv.Itoa(m.logins)
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"auth": map[string]interface{}{"client_token": m.token},
+		})
+		return
+	}
+
+	if r.Header.Get("X-Vault-Token") != m.token {
+		writeError(w, http.StatusForbidden, "permission denied")
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/v1/transit/"), "/")
+	switch {
+	case len(parts) == 2 && parts[0] == "keys" && r.Method == "GET":
+		k, ok := m.keys[parts[1]]
+		if !ok {
+			writeJSON(w, http.StatusNotFound, map[string][]string{"errors": {}})
+			return
+		}
+		keys := make(map[string]interface{})
+		for i, s := range k.Versions {
+			keys[strconv.Itoa(i+1)] = map[string]interface{}{
+				"name":       k.Type,
+				"public_key": encodePublicKey(s.Public()),
+			}
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"data": map[string]interface{}{
+				"name":             parts[1],
+				"type":             k.Type,
+				"latest_version":   len(k.Versions),
+				"supports_signing": !strings.HasPrefix(k.Type, "aes"),
+				"keys":             keys,
+			},
+		})
+	case len(parts) == 2 && parts[0] == "keys" && r.Method == "POST":
+		keyType, _ := body["type"].(string)
+		if _, ok := m.keys[parts[1]]; !ok {
+			m.addKeyVersion(parts[1], keyType)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case len(parts) == 3 && parts[0] == "keys" && parts[2] == "rotate" && r.Method == "POST":
+		k, ok := m.keys[parts[1]]
+		if !ok {
+			writeError(w, http.StatusBadRequest, "key not found")
+			return
+		}
+		m.addKeyVersion(parts[1], k.Type)
+		w.WriteHeader(http.StatusNoContent)
+	case len(parts) == 2 && parts[0] == "sign" && r.Method == "POST":
+		m.sign(w, parts[1], body)
+	default:
+		writeError(w, http.StatusNotFound, "unsupported path")
+	}
+}
+
+func (m *mockVault) sign(w http.ResponseWriter, name string, body map[string]interface{}) {
+	k, ok := m.keys[name]
+	if !ok {
+		writeError(w, http.StatusBadRequest, "signing key not found")
+		return
+	}
+	version := len(k.Versions)
+	if v, ok := body["key_version"].(float64); ok && v > 0 {
+		version = int(v)
+	}
+	if version > len(k.Versions) {
+		writeError(w, http.StatusBadRequest, "invalid key version")
+		return
+	}
+	input, _ := body["input"].(string)
+	digest, err := base64.StdEncoding.DecodeString(input)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "unable to decode input as base64")
+		return
+	}
+
+	var opts crypto.SignerOpts = crypto.Hash(0)
+	if body["prehashed"] == true {
+		switch body["hash_algorithm"] {
+		case "sha2-256":
+			opts = crypto.SHA256
+		case "sha2-384":
+			opts = crypto.SHA384
+		case "sha2-512":
+			opts = crypto.SHA512
+		default:
+			writeError(w, http.StatusBadRequest, "unsupported hash algorithm")
+			return
+		}
+		if body["signature_algorithm"] == "pss" {
+			if body["salt_length"] != "hash" {
+				writeError(w, http.StatusBadRequest, "unsupported salt length")
+				return
+			}
+			opts = &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: opts.HashFunc()}
+		}
+	}
+
+	sig, err := k.Versions[version-1].Sign(rand.Reader, digest, opts)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"data": map[string]interface{}{
+			"signature":   "vault:v" + strconv.Itoa(version) + ":" + base64.StdEncoding.EncodeToString(sig),
+			"key_version": version,
+		},
+	})
+}
+
+func encodePublicKey(pub crypto.PublicKey) string {
+	if k, ok := pub.(ed25519.PublicKey); ok {
+		return base64.StdEncoding.EncodeToString(k)
+	}
+	b, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		panic(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: b}))
+}
@@ -0,0 +1,110 @@
+package vaulttransit
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// hashAlgorithms maps the hash functions with the transit hash algorithms.
+var hashAlgorithms = map[crypto.Hash]string{
+	crypto.SHA224: "sha2-224",
+	crypto.SHA256: "sha2-256",
+	crypto.SHA384: "sha2-384",
+	crypto.SHA512: "sha2-512",
+}
+
+// Signer implements a crypto.Signer using a key in the transit secrets engine
+// of Vault.
+type Signer struct {
+	kms       *VaultTransit
+	name      string
+	version   int
+	publicKey crypto.PublicKey
+}
+
+// NewSigner creates a new signer using the given version of the transit key.
+func NewSigner(k *VaultTransit, name string, version int, publicKey crypto.PublicKey) *Signer {
+	return &Signer{
+		kms:       k,
+		name:      name,
+		version:   version,
+		publicKey: publicKey,
+	}
+}
+
+// Public returns the public key of this signer.
+func (s *Signer) Public() crypto.PublicKey {
+	return s.publicKey
+}
+
+// Sign signs digest with the private key stored in Vault. Ed25519 keys sign
+// the full message, and the rest sign the pre-hashed digest.
+func (s *Signer) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	req := map[string]interface{}{
+		"input":       base64.StdEncoding.EncodeToString(digest),
+		"key_version": s.version,
+	}
+
+	switch s.publicKey.(type) {
+	case ed25519.PublicKey:
+		if h := opts.HashFunc(); h != crypto.Hash(0) {
+			return nil, errors.Errorf("unsupported hash function %v", h)
+		}
+	case *ecdsa.PublicKey, *rsa.PublicKey:
+		h := opts.HashFunc()
+		alg, ok := hashAlgorithms[h]
+		if !ok {
+			return nil, errors.Errorf("unsupported hash function %v", h)
+		}
+		req["prehashed"] = true
+		req["hash_algorithm"] = alg
+		if _, ok := s.publicKey.(*rsa.PublicKey); ok {
+			if o, ok := opts.(*rsa.PSSOptions); ok {
+				req["signature_algorithm"] = "pss"
+				req["salt_length"] = saltLength(o)
+			} else {
+				req["signature_algorithm"] = "pkcs1v15"
+			}
+		}
+	default:
+		return nil, errors.Errorf("unsupported public key type %T", s.publicKey)
+	}
+
+	var resp struct {
+		Signature string `json:"signature"`
+	}
+	if err := s.kms.do("POST", s.kms.keyPath("sign", s.name), req, &resp); err != nil {
+		return nil, errors.Wrap(err, "vaultTransit sign failed")
+	}
+
+	// Signatures have the format vault:v<version>:<base64-signature>
+	parts := strings.SplitN(resp.Signature, ":", 3)
+	if len(parts) != 3 || parts[0] != "vault" {
+		return nil, errors.New("vaultTransit sign failed: invalid signature format")
+	}
+	signature, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.Wrap(err, "vaultTransit sign failed: error decoding signature")
+	}
+	return signature, nil
+}
+
+// saltLength returns the transit salt_length of the given PSS options.
+func saltLength(o *rsa.PSSOptions) string {
+	switch o.SaltLength {
+	case rsa.PSSSaltLengthAuto:
+		return "auto"
+	case rsa.PSSSaltLengthEqualsHash:
+		return "hash"
+	default:
+		return strconv.Itoa(o.SaltLength)
+	}
+}
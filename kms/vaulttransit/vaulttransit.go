@@ -0,0 +1,499 @@
+package vaulttransit
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/kms/apiv1"
+	"github.com/smallstep/cli/crypto/pemutil"
+)
+
+const defaultMount = "transit"
+
+// signatureAlgorithmMapping is a mapping between the step signature algorithm,
+// and bits for RSA keys, with the transit key types.
+//
+// The hash function is not part of a transit key, it's selected on every sign
+// operation.
+var signatureAlgorithmMapping = map[apiv1.SignatureAlgorithm]interface{}{
+	apiv1.UnspecifiedSignAlgorithm: "ecdsa-p256",
+	apiv1.SHA256WithRSA: map[int]string{
+		0:    "rsa-3072",
+		2048: "rsa-2048",
+		3072: "rsa-3072",
+		4096: "rsa-4096",
+	},
+	apiv1.SHA384WithRSA: map[int]string{
+		0:    "rsa-3072",
+		2048: "rsa-2048",
+		3072: "rsa-3072",
+		4096: "rsa-4096",
+	},
+	apiv1.SHA512WithRSA: map[int]string{
+		0:    "rsa-4096",
+		2048: "rsa-2048",
+		3072: "rsa-3072",
+		4096: "rsa-4096",
+	},
+	apiv1.SHA256WithRSAPSS: map[int]string{
+		0:    "rsa-3072",
+		2048: "rsa-2048",
+		3072: "rsa-3072",
+		4096: "rsa-4096",
+	},
+	apiv1.SHA384WithRSAPSS: map[int]string{
+		0:    "rsa-3072",
+		2048: "rsa-2048",
+		3072: "rsa-3072",
+		4096: "rsa-4096",
+	},
+	apiv1.SHA512WithRSAPSS: map[int]string{
+		0:    "rsa-4096",
+		2048: "rsa-2048",
+		3072: "rsa-3072",
+		4096: "rsa-4096",
+	},
+	apiv1.ECDSAWithSHA256: "ecdsa-p256",
+	apiv1.ECDSAWithSHA384: "ecdsa-p384",
+	apiv1.ECDSAWithSHA512: "ecdsa-p521",
+	apiv1.PureEd25519:     "ed25519",
+}
+
+// VaultTransit implements a KMS using the transit secrets engine of HashiCorp
+// Vault. The private keys never leave Vault, they are created as
+// non-exportable keys and all the sign operations are done by Vault.
+type VaultTransit struct {
+	client   *http.Client
+	address  string
+	mount    string
+	roleID   string
+	secretID string
+	mutex    sync.RWMutex
+	token    string
+}
+
+// New creates a new VaultTransit. It authenticates with the given token or,
+// if a role id is configured, using the AppRole auth method.
+func New(ctx context.Context, opts apiv1.Options) (*VaultTransit, error) {
+	address := opts.Address
+	if address == "" {
+		address = os.Getenv("VAULT_ADDR")
+	}
+	if address == "" {
+		return nil, errors.New("vaultTransit address cannot be empty")
+	}
+	u, err := url.Parse(address)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return nil, errors.Errorf("vaultTransit address %s is not valid", address)
+	}
+
+	token := opts.Token
+	if token == "" && opts.RoleID == "" {
+		token = os.Getenv("VAULT_TOKEN")
+	}
+	if token == "" && opts.RoleID == "" {
+		return nil, errors.New("vaultTransit requires a token or an AppRole roleID")
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+	caCertFile := opts.CACertFile
+	if caCertFile == "" {
+		caCertFile = os.Getenv("VAULT_CACERT")
+	}
+	if caCertFile != "" {
+		b, err := ioutil.ReadFile(caCertFile)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error reading %s", caCertFile)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(b) {
+			return nil, errors.Errorf("error parsing %s: no certificates found", caCertFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	mount := strings.Trim(opts.Mount, "/")
+	if mount == "" {
+		mount = defaultMount
+	}
+
+	k := &VaultTransit{
+		client: &http.Client{
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: tlsConfig,
+			},
+		},
+		address:  strings.TrimSuffix(address, "/"),
+		mount:    mount,
+		roleID:   opts.RoleID,
+		secretID: opts.SecretID,
+		token:    token,
+	}
+
+	if k.roleID != "" {
+		if err := k.login(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	return k, nil
+}
+
+// Close is a noop that just returns nil.
+func (k *VaultTransit) Close() error {
+	return nil
+}
+
+// CreateSigner returns a new signer configured with the given signing key.
+// The signing key is the name of a transit key, optionally with a version,
+// e.g. intermediate or intermediate/versions/2. If the version is not set the
+// latest version of the key is used.
+func (k *VaultTransit) CreateSigner(req *apiv1.CreateSignerRequest) (crypto.Signer, error) {
+	if req.SigningKey == "" {
+		return nil, errors.New("signing key cannot be empty")
+	}
+
+	name, version, err := parseKeyName(req.SigningKey)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := k.readKey(name)
+	if err != nil {
+		return nil, err
+	}
+	if version == 0 {
+		version = key.LatestVersion
+	}
+	pk, err := key.publicKey(version)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewSigner(k, name, version, pk), nil
+}
+
+// CreateKey creates a new non-exportable key in the transit secrets engine. If
+// the key already exists, and it has the same type, the key is rotated and the
+// new version is returned.
+func (k *VaultTransit) CreateKey(req *apiv1.CreateKeyRequest) (*apiv1.CreateKeyResponse, error) {
+	if req.Name == "" {
+		return nil, errors.New("createKeyRequest 'name' cannot be empty")
+	}
+
+	var keyType string
+	v, ok := signatureAlgorithmMapping[req.SignatureAlgorithm]
+	if !ok {
+		return nil, errors.Errorf("vaultTransit does not support signature algorithm '%s'", req.SignatureAlgorithm)
+	}
+	switch v := v.(type) {
+	case string:
+		keyType = v
+	case map[int]string:
+		if keyType, ok = v[req.Bits]; !ok {
+			return nil, errors.Errorf("vaultTransit does not support signature algorithm '%s' with '%d' bits", req.SignatureAlgorithm, req.Bits)
+		}
+	default:
+		return nil, errors.Errorf("unexpected error: this should not happen")
+	}
+
+	name, version, err := parseKeyName(req.Name)
+	if err != nil {
+		return nil, err
+	}
+	if version != 0 {
+		return nil, errors.Errorf("createKeyRequest 'name' %s cannot contain a version", req.Name)
+	}
+
+	key, err := k.readKey(name)
+	switch {
+	case isNotFound(err):
+		if err := k.do("POST", k.keyPath("keys", name), map[string]interface{}{
+			"type":       keyType,
+			"exportable": false,
+		}, nil); err != nil {
+			return nil, errors.Wrap(err, "vaultTransit create key failed")
+		}
+	case err != nil:
+		return nil, err
+	case key.Type != keyType:
+		return nil, errors.Errorf("vaultTransit key %s already exists with type %s", name, key.Type)
+	default:
+		if err := k.do("POST", k.keyPath("keys", name)+"/rotate", nil, nil); err != nil {
+			return nil, errors.Wrap(err, "vaultTransit rotate key failed")
+		}
+	}
+
+	// Retrieve the public key of the latest version.
+	if key, err = k.readKey(name); err != nil {
+		return nil, err
+	}
+	pk, err := key.publicKey(key.LatestVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	keyName := fmt.Sprintf("%s/versions/%d", name, key.LatestVersion)
+	return &apiv1.CreateKeyResponse{
+		Name:      keyName,
+		PublicKey: pk,
+		CreateSignerRequest: apiv1.CreateSignerRequest{
+			SigningKey: keyName,
+		},
+	}, nil
+}
+
+// GetPublicKey returns the public key of a transit key. The name follows the
+// same format than the signing key in CreateSigner.
+func (k *VaultTransit) GetPublicKey(req *apiv1.GetPublicKeyRequest) (crypto.PublicKey, error) {
+	if req.Name == "" {
+		return nil, errors.New("getPublicKeyRequest 'name' cannot be empty")
+	}
+
+	name, version, err := parseKeyName(req.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := k.readKey(name)
+	if err != nil {
+		return nil, err
+	}
+	if version == 0 {
+		version = key.LatestVersion
+	}
+	return key.publicKey(version)
+}
+
+// transitKey is the response of the read key endpoint of the transit secrets
+// engine.
+type transitKey struct {
+	Name            string `json:"name"`
+	Type            string `json:"type"`
+	LatestVersion   int    `json:"latest_version"`
+	SupportsSigning bool   `json:"supports_signing"`
+	Keys            map[string]struct {
+		PublicKey string `json:"public_key"`
+	} `json:"keys"`
+}
+
+// publicKey returns the public key of the given version.
+func (t *transitKey) publicKey(version int) (crypto.PublicKey, error) {
+	v, ok := t.Keys[strconv.Itoa(version)]
+	if !ok {
+		return nil, errors.Errorf("vaultTransit key %s does not have version %d", t.Name, version)
+	}
+
+	// Ed25519 public keys are base64 encoded, the rest are PEM encoded.
+	if t.Type == "ed25519" {
+		b, err := base64.StdEncoding.DecodeString(v.PublicKey)
+		if err != nil || len(b) != ed25519.PublicKeySize {
+			return nil, errors.Errorf("vaultTransit key %s has an invalid public key", t.Name)
+		}
+		return ed25519.PublicKey(b), nil
+	}
+
+	pk, err := pemutil.ParseKey([]byte(v.PublicKey))
+	if err != nil {
+		return nil, errors.Wrapf(err, "vaultTransit key %s has an invalid public key", t.Name)
+	}
+	return pk, nil
+}
+
+// readKey returns the transit key with the given name.
+func (k *VaultTransit) readKey(name string) (*transitKey, error) {
+	var key transitKey
+	if err := k.do("GET", k.keyPath("keys", name), nil, &key); err != nil {
+		return nil, errors.Wrap(err, "vaultTransit read key failed")
+	}
+	if !key.SupportsSigning {
+		return nil, errors.Errorf("vaultTransit key %s does not support signing", name)
+	}
+	if key.Name == "" {
+		key.Name = name
+	}
+	return &key, nil
+}
+
+func (k *VaultTransit) keyPath(endpoint, name string) string {
+	return "/v1/" + k.mount + "/" + endpoint + "/" + url.PathEscape(name)
+}
+
+// login authenticates with Vault using AppRole and stores the new token.
+func (k *VaultTransit) login(ctx context.Context) error {
+	body := map[string]interface{}{
+		"role_id": k.roleID,
+	}
+	if k.secretID != "" {
+		body["secret_id"] = k.secretID
+	}
+
+	resp, err := k.doRequest(ctx, "POST", "/v1/auth/approle/login", "", body)
+	if err != nil {
+		return errors.Wrap(err, "vaultTransit AppRole login failed")
+	}
+	if resp.Auth == nil || resp.Auth.ClientToken == "" {
+		return errors.New("vaultTransit AppRole login failed: response does not contain a token")
+	}
+
+	k.mutex.Lock()
+	k.token = resp.Auth.ClientToken
+	k.mutex.Unlock()
+	return nil
+}
+
+// do sends a request to Vault and unmarshals the data of the response in out.
+// If AppRole is used and the token has expired, it logs in again and retries
+// the request.
+func (k *VaultTransit) do(method, path string, in, out interface{}) error {
+	ctx, cancel := defaultContext()
+	defer cancel()
+
+	k.mutex.RLock()
+	token := k.token
+	k.mutex.RUnlock()
+
+	resp, err := k.doRequest(ctx, method, path, token, in)
+	if k.roleID != "" && isForbidden(err) {
+		if err = k.login(ctx); err != nil {
+			return err
+		}
+		k.mutex.RLock()
+		token = k.token
+		k.mutex.RUnlock()
+		resp, err = k.doRequest(ctx, method, path, token, in)
+	}
+	if err != nil {
+		return err
+	}
+
+	if out != nil {
+		if len(resp.Data) == 0 {
+			return errors.New("response does not contain any data")
+		}
+		if err := json.Unmarshal(resp.Data, out); err != nil {
+			return errors.Wrap(err, "error unmarshaling response")
+		}
+	}
+	return nil
+}
+
+// vaultResponse is the envelope of the Vault responses.
+type vaultResponse struct {
+	Data json.RawMessage `json:"data"`
+	Auth *struct {
+		ClientToken string `json:"client_token"`
+	} `json:"auth"`
+}
+
+// responseError is the error returned if Vault responds with an error status
+// code.
+type responseError struct {
+	StatusCode int      `json:"-"`
+	Errors     []string `json:"errors"`
+}
+
+func (e *responseError) Error() string {
+	if len(e.Errors) == 0 {
+		return fmt.Sprintf("vault responded with status %d", e.StatusCode)
+	}
+	return fmt.Sprintf("vault responded with status %d: %s", e.StatusCode, strings.Join(e.Errors, ", "))
+}
+
+func isNotFound(err error) bool {
+	e, ok := errors.Cause(err).(*responseError)
+	return ok && e.StatusCode == http.StatusNotFound
+}
+
+func isForbidden(err error) bool {
+	e, ok := errors.Cause(err).(*responseError)
+	return ok && e.StatusCode == http.StatusForbidden
+}
+
+func (k *VaultTransit) doRequest(ctx context.Context, method, path, token string, in interface{}) (*vaultResponse, error) {
+	var body []byte
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return nil, errors.Wrap(err, "error marshaling request")
+		}
+		body = b
+	}
+
+	req, err := http.NewRequest(method, k.address+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating request")
+	}
+	req = req.WithContext(ctx)
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error connecting to %s", k.address)
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading response")
+	}
+	if resp.StatusCode >= 400 {
+		e := &responseError{StatusCode: resp.StatusCode}
+		json.Unmarshal(b, e)
+		return nil, e
+	}
+
+	var v vaultResponse
+	if len(b) > 0 {
+		if err := json.Unmarshal(b, &v); err != nil {
+			return nil, errors.Wrap(err, "error unmarshaling response")
+		}
+	}
+	return &v, nil
+}
+
+func defaultContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), 15*time.Second)
+}
+
+// parseKeyName splits a key name in the format name or name/versions/N in the
+// transit key name and the version, 0 if the version is not set.
+func parseKeyName(s string) (string, int, error) {
+	parts := strings.Split(s, "/")
+	switch {
+	case len(parts) == 1 && parts[0] != "":
+		return parts[0], 0, nil
+	case len(parts) == 3 && parts[0] != "" && parts[1] == "versions":
+		version, err := strconv.Atoi(parts[2])
+		if err != nil || version <= 0 {
+			return "", 0, errors.Errorf("key name %s is not valid", s)
+		}
+		return parts[0], version, nil
+	default:
+		return "", 0, errors.Errorf("key name %s is not valid", s)
+	}
+}
@@ -0,0 +1,348 @@
+package vaulttransit
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/smallstep/certificates/kms/apiv1"
+)
+
+func unsetVaultEnv(t *testing.T) func() {
+	t.Helper()
+	env := map[string]string{}
+	for _, k := range []string{"VAULT_ADDR", "VAULT_TOKEN", "VAULT_CACERT"} {
+		if v, ok := os.LookupEnv(k); ok {
+			env[k] = v
+			os.Unsetenv(k)
+		}
+	}
+	return func() {
+		for k, v := range env {
+			os.Setenv(k, v)
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	defer unsetVaultEnv(t)()
+
+	m := newMockVault("token")
+	defer m.Close()
+	m.roleID = "role-id"
+	m.secretID = "secret-id"
+
+	tests := []struct {
+		name      string
+		opts      apiv1.Options
+		wantToken string
+		wantMount string
+		wantErr   bool
+	}{
+		{"ok token", apiv1.Options{Address: m.URL, Token: "token"}, "token", "transit", false},
+		{"ok mount", apiv1.Options{Address: m.URL + "/", Token: "token", Mount: "/pki-transit/"}, "token", "pki-transit", false},
+		{"ok approle", apiv1.Options{Address: m.URL, RoleID: "role-id", SecretID: "secret-id"}, "approle-token-1", "transit", false},
+		{"fail address", apiv1.Options{Token: "token"}, "", "", true},
+		{"fail address scheme", apiv1.Options{Address: "127.0.0.1:8200", Token: "token"}, "", "", true},
+		{"fail credentials", apiv1.Options{Address: m.URL}, "", "", true},
+		{"fail approle", apiv1.Options{Address: m.URL, RoleID: "role-id", SecretID: "bad-secret-id"}, "", "", true},
+		{"fail caCertFile", apiv1.Options{Address: m.URL, Token: "token", CACertFile: "testdata/missing.crt"}, "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := New(context.Background(), tt.opts)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("New() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err == nil {
+				if got.token != tt.wantToken {
+					t.Errorf("New() token = %v, want %v", got.token, tt.wantToken)
+				}
+				if got.mount != tt.wantMount {
+					t.Errorf("New() mount = %v, want %v", got.mount, tt.wantMount)
+				}
+			}
+		})
+	}
+}
+
+func TestVaultTransit_CreateKey(t *testing.T) {
+	m := newMockVault("token")
+	defer m.Close()
+	m.addKey("existing", "ecdsa-p256")
+	m.addKey("aes", "aes256-gcm96")
+
+	k, err := New(context.Background(), apiv1.Options{Address: m.URL, Token: "token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		req      *apiv1.CreateKeyRequest
+		wantName string
+		wantErr  bool
+	}{
+		{"ok", &apiv1.CreateKeyRequest{Name: "intermediate", SignatureAlgorithm: apiv1.ECDSAWithSHA256}, "intermediate/versions/1", false},
+		{"ok default", &apiv1.CreateKeyRequest{Name: "default"}, "default/versions/1", false},
+		{"ok rsa", &apiv1.CreateKeyRequest{Name: "rsa", SignatureAlgorithm: apiv1.SHA256WithRSA, Bits: 2048}, "rsa/versions/1", false},
+		{"ok ed25519", &apiv1.CreateKeyRequest{Name: "ed25519", SignatureAlgorithm: apiv1.PureEd25519}, "ed25519/versions/1", false},
+		{"ok rotate", &apiv1.CreateKeyRequest{Name: "existing", SignatureAlgorithm: apiv1.ECDSAWithSHA256}, "existing/versions/2", false},
+		{"fail name", &apiv1.CreateKeyRequest{SignatureAlgorithm: apiv1.ECDSAWithSHA256}, "", true},
+		{"fail name version", &apiv1.CreateKeyRequest{Name: "existing/versions/1", SignatureAlgorithm: apiv1.ECDSAWithSHA256}, "", true},
+		{"fail algorithm", &apiv1.CreateKeyRequest{Name: "foo", SignatureAlgorithm: apiv1.SignatureAlgorithm(100)}, "", true},
+		{"fail bits", &apiv1.CreateKeyRequest{Name: "foo", SignatureAlgorithm: apiv1.SHA256WithRSA, Bits: 1024}, "", true},
+		{"fail type", &apiv1.CreateKeyRequest{Name: "existing", SignatureAlgorithm: apiv1.ECDSAWithSHA384}, "", true},
+		{"fail signing", &apiv1.CreateKeyRequest{Name: "aes", SignatureAlgorithm: apiv1.ECDSAWithSHA256}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := k.CreateKey(tt.req)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("VaultTransit.CreateKey() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err == nil {
+				if got.Name != tt.wantName || got.CreateSignerRequest.SigningKey != tt.wantName {
+					t.Errorf("VaultTransit.CreateKey() name = %v, want %v", got.Name, tt.wantName)
+				}
+				pk, err := k.GetPublicKey(&apiv1.GetPublicKeyRequest{Name: tt.wantName})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(got.PublicKey, pk) {
+					t.Errorf("VaultTransit.CreateKey() public key = %v, want %v", got.PublicKey, pk)
+				}
+			}
+		})
+	}
+}
+
+func TestVaultTransit_GetPublicKey(t *testing.T) {
+	m := newMockVault("token")
+	defer m.Close()
+	v1 := m.addKey("intermediate", "ecdsa-p256")
+	v2 := m.addKey("intermediate", "ecdsa-p256")
+	ed := m.addKey("ed25519", "ed25519")
+
+	k, err := New(context.Background(), apiv1.Options{Address: m.URL, Token: "token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		keyName string
+		want    crypto.PublicKey
+		wantErr bool
+	}{
+		{"ok", "intermediate", v2.Public(), false},
+		{"ok version", "intermediate/versions/1", v1.Public(), false},
+		{"ok ed25519", "ed25519", ed.Public(), false},
+		{"fail empty", "", nil, true},
+		{"fail name", "intermediate/1", nil, true},
+		{"fail version", "intermediate/versions/0", nil, true},
+		{"fail missing version", "intermediate/versions/3", nil, true},
+		{"fail missing", "missing", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := k.GetPublicKey(&apiv1.GetPublicKeyRequest{Name: tt.keyName})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("VaultTransit.GetPublicKey() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("VaultTransit.GetPublicKey() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVaultTransit_CreateSigner(t *testing.T) {
+	m := newMockVault("token")
+	defer m.Close()
+	m.addKey("ecdsa", "ecdsa-p256")
+	m.addKey("ecdsa", "ecdsa-p256")
+	m.addKey("p384", "ecdsa-p384")
+	m.addKey("rsa", "rsa-2048")
+	m.addKey("ed25519", "ed25519")
+
+	k, err := New(context.Background(), apiv1.Options{Address: m.URL, Token: "token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name               string
+		signingKey         string
+		signatureAlgorithm x509.SignatureAlgorithm
+		wantErr            bool
+	}{
+		{"ok ecdsa", "ecdsa", x509.ECDSAWithSHA256, false},
+		{"ok ecdsa version", "ecdsa/versions/1", x509.ECDSAWithSHA256, false},
+		{"ok p384", "p384", x509.ECDSAWithSHA384, false},
+		{"ok rsa", "rsa", x509.SHA256WithRSA, false},
+		{"ok rsa sha512", "rsa", x509.SHA512WithRSA, false},
+		{"ok rsa pss", "rsa", x509.SHA384WithRSAPSS, false},
+		{"ok ed25519", "ed25519", x509.PureEd25519, false},
+		{"fail empty", "", x509.UnknownSignatureAlgorithm, true},
+		{"fail missing", "missing", x509.UnknownSignatureAlgorithm, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := k.CreateSigner(&apiv1.CreateSignerRequest{SigningKey: tt.signingKey})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("VaultTransit.CreateSigner() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err != nil {
+				return
+			}
+
+			// Sign and verify a self-signed certificate
+			template := &x509.Certificate{
+				SerialNumber:       big.NewInt(1),
+				Subject:            pkix.Name{CommonName: "Test Root"},
+				NotBefore:          time.Now(),
+				NotAfter:           time.Now().Add(time.Hour),
+				SignatureAlgorithm: tt.signatureAlgorithm,
+			}
+			der, err := x509.CreateCertificate(rand.Reader, template, template, got.Public(), got)
+			if err != nil {
+				t.Fatalf("x509.CreateCertificate() error = %v", err)
+			}
+			cert, err := x509.ParseCertificate(der)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := cert.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature); err != nil {
+				t.Errorf("Certificate.CheckSignature() error = %v", err)
+			}
+		})
+	}
+}
+
+func TestSigner_Sign(t *testing.T) {
+	m := newMockVault("token")
+	defer m.Close()
+	m.addKey("ecdsa", "ecdsa-p256")
+	m.addKey("ed25519", "ed25519")
+
+	k, err := New(context.Background(), apiv1.Options{Address: m.URL, Token: "token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecdsaSigner, err := k.CreateSigner(&apiv1.CreateSignerRequest{SigningKey: "ecdsa"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ed25519Signer, err := k.CreateSigner(&apiv1.CreateSignerRequest{SigningKey: "ed25519"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	missingSigner := NewSigner(k, "missing", 1, ecdsaSigner.Public())
+	unknownSigner := NewSigner(k, "ecdsa", 1, []byte("foo"))
+
+	digest := make([]byte, 32)
+	tests := []struct {
+		name    string
+		signer  crypto.Signer
+		opts    crypto.SignerOpts
+		wantErr bool
+	}{
+		{"ok", ecdsaSigner, crypto.SHA256, false},
+		{"ok ed25519", ed25519Signer, crypto.Hash(0), false},
+		{"fail hash", ecdsaSigner, crypto.MD5, true},
+		{"fail ed25519 hash", ed25519Signer, crypto.SHA256, true},
+		{"fail missing", missingSigner, crypto.SHA256, true},
+		{"fail public key", unknownSigner, crypto.SHA256, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.signer.Sign(rand.Reader, digest, tt.opts)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Signer.Sign() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err == nil && len(got) == 0 {
+				t.Error("Signer.Sign() signature is empty")
+			}
+		})
+	}
+}
+
+func TestVaultTransit_login(t *testing.T) {
+	m := newMockVault("")
+	defer m.Close()
+	m.roleID = "role-id"
+	m.secretID = "secret-id"
+
+	k, err := New(context.Background(), apiv1.Options{Address: m.URL, RoleID: "role-id", SecretID: "secret-id"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.addKey("intermediate", "ecdsa-p256")
+
+	// Expire the token, the next request must login again.
+	m.mutex.Lock()
+	m.token = "new-token"
+	m.mutex.Unlock()
+
+	if _, err := k.GetPublicKey(&apiv1.GetPublicKeyRequest{Name: "intermediate"}); err != nil {
+		t.Fatalf("VaultTransit.GetPublicKey() error = %v", err)
+	}
+	if m.logins != 2 {
+		t.Errorf("VaultTransit logins = %d, want 2", m.logins)
+	}
+	if k.token != "approle-token-2" {
+		t.Errorf("VaultTransit token = %s, want approle-token-2", k.token)
+	}
+
+	// Do not retry with invalid credentials.
+	m.mutex.Lock()
+	m.token = "new-token"
+	m.secretID = "new-secret-id"
+	m.mutex.Unlock()
+	if _, err := k.GetPublicKey(&apiv1.GetPublicKeyRequest{Name: "intermediate"}); err == nil {
+		t.Error("VaultTransit.GetPublicKey() error = nil, wantErr true")
+	}
+}
+
+func Test_parseKeyName(t *testing.T) {
+	tests := []struct {
+		name        string
+		s           string
+		wantName    string
+		wantVersion int
+		wantErr     bool
+	}{
+		{"ok", "intermediate", "intermediate", 0, false},
+		{"ok version", "intermediate/versions/12", "intermediate", 12, false},
+		{"fail empty", "", "", 0, true},
+		{"fail versions", "intermediate/12", "", 0, true},
+		{"fail number", "intermediate/versions/foo", "", 0, true},
+		{"fail negative", "intermediate/versions/-1", "", 0, true},
+		{"fail name", "/versions/1", "", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotName, gotVersion, err := parseKeyName(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseKeyName() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if gotName != tt.wantName || gotVersion != tt.wantVersion {
+				t.Errorf("parseKeyName() = (%v, %v), want (%v, %v)", gotName, gotVersion, tt.wantName, tt.wantVersion)
+			}
+		})
+	}
+}
@@ -22,6 +22,7 @@ var SSHTemplates = &templates.SSHTemplates{
 	Host: []templates.Template{
 		{Name: "sshd_config.tpl", Type: templates.Snippet, TemplatePath: "templates/ssh/sshd_config.tpl", Path: "/etc/ssh/sshd_config", Comment: "#"},
 		{Name: "ca.tpl", Type: templates.Snippet, TemplatePath: "templates/ssh/ca.tpl", Path: "/etc/ssh/ca.pub", Comment: "#"},
+		{Name: "revoked_keys.tpl", Type: templates.File, TemplatePath: "templates/ssh/revoked_keys.tpl", Path: "/etc/ssh/revoked_keys", Comment: "#"},
 	},
 }
 
@@ -64,7 +65,8 @@ var SSHTemplateData = map[string]string{
 	// sshd_config.tpl adds the configuration to support certificates
 	"sshd_config.tpl": `TrustedUserCAKeys /etc/ssh/ca.pub
 HostCertificate /etc/ssh/{{.User.Certificate}}
-HostKey /etc/ssh/{{.User.Key}}`,
+HostKey /etc/ssh/{{.User.Key}}
+RevokedKeys /etc/ssh/revoked_keys`,
 
 	// ca.tpl contains the public key used to authorized clients
 	"ca.tpl": `{{.Step.SSH.UserKey.Type}} {{.Step.SSH.UserKey.Marshal | toString | b64enc}}
@@ -72,6 +74,10 @@ HostKey /etc/ssh/{{.User.Key}}`,
 {{.Type}} {{.Marshal | toString | b64enc}}
 {{- end }}
 `,
+
+	// revoked_keys.tpl contains the key revocation list (KRL) used by sshd to
+	// reject revoked user certificates. It is a binary file.
+	"revoked_keys.tpl": `{{.Step.SSH.KRL | toString}}`,
 }
 
 // getTemplates returns all the templates enabled
@@ -42,13 +42,12 @@ func (t *Templates) Validate() (err error) {
 		return
 	}
 
-	// Do not allow "Step" and "User"
+	// Do not allow "Step", "User", "Token", "CR" and "Cert"
 	if t.Data != nil {
-		if _, ok := t.Data["Step"]; ok {
-			return errors.New("templates variables cannot contain 'Step' as a property")
-		}
-		if _, ok := t.Data["User"]; ok {
-			return errors.New("templates variables cannot contain 'User' as a property")
+		for _, k := range []string{"Step", "User", "Token", "CR", "Cert"} {
+			if _, ok := t.Data[k]; ok {
+				return errors.Errorf("templates variables cannot contain '%s' as a property", k)
+			}
 		}
 	}
 	return nil
@@ -39,6 +39,9 @@ func TestTemplates_Validate(t *testing.T) {
 		{"badSSH", fields{&SSHTemplates{User: []Template{{}}}, nil}, true},
 		{"badDataUser", fields{sshTemplates, map[string]interface{}{"User": "Bar"}}, true},
 		{"badDataStep", fields{sshTemplates, map[string]interface{}{"Step": "Bar"}}, true},
+		{"badDataToken", fields{sshTemplates, map[string]interface{}{"Token": "Bar"}}, true},
+		{"badDataCR", fields{sshTemplates, map[string]interface{}{"CR": "Bar"}}, true},
+		{"badDataCert", fields{sshTemplates, map[string]interface{}{"Cert": "Bar"}}, true},
 	}
 	var nilValue *Templates
 	assert.NoError(t, nilValue.Validate())
@@ -15,4 +15,5 @@ type StepSSH struct {
 	UserKey           ssh.PublicKey
 	HostFederatedKeys []ssh.PublicKey
 	UserFederatedKeys []ssh.PublicKey
+	KRL               []byte
 }